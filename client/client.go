@@ -0,0 +1,262 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+)
+
+var apiPrefix = "/api/" + version.API
+
+// Error is the error envelope returned by the proxy.
+type Error struct {
+	Status int
+	middleware.ErrorResponse
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Status == http.StatusNotFound
+}
+
+const (
+	idempotencyKeyHeader = "X-Idempotency-Key"
+	apiVersionHeader     = "X-Api-Version"
+	clusterHeader        = "X-Cluster"
+	maxRetryBackoff      = 5 * time.Second
+)
+
+// Options of a client, Timeout bounds each attempt of a request but not a
+// watch. A request failing to connect or with 429, 502, 503 or 504 is sent
+// again up to Retries times, after RetryBackoff doubled each time, the
+// writes carry an X-Idempotency-Key so the proxy applies them once. Cluster
+// sends the requests to a cluster of the proxy other than its store.
+type Options struct {
+	Timeout         time.Duration
+	AccessToken     string
+	Cluster         string
+	Retries         int
+	RetryBackoff    time.Duration
+	MaxIdleConns    int
+	MaxConnsPerHost int
+}
+
+// Client talks to a running proxy, keys are sent base64 encoded so any
+// bytes can be used. It's safe for concurrent use and keeps the connections
+// to the proxy.
+type Client struct {
+	addr   string
+	opt    Options
+	http   *http.Client
+	stream *http.Client
+}
+
+func New(addr string, opt Options) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if opt.RetryBackoff <= 0 {
+		opt.RetryBackoff = 100 * time.Millisecond
+	}
+	if opt.MaxIdleConns <= 0 {
+		opt.MaxIdleConns = 1024
+	}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: opt.MaxIdleConns,
+		MaxConnsPerHost:     opt.MaxConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Client{
+		addr:   strings.TrimRight(addr, "/"),
+		opt:    opt,
+		http:   &http.Client{Timeout: opt.Timeout, Transport: transport},
+		stream: &http.Client{Transport: transport},
+	}
+}
+
+func encodeKey(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func (c *Client) request(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+apiPrefix+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set(apiVersionHeader, version.API)
+	if c.opt.Cluster != "" {
+		req.Header.Set(clusterHeader, c.opt.Cluster)
+	}
+	if c.opt.AccessToken != "" {
+		req.Header.Set(middleware.AccessTokenHeader, c.opt.AccessToken)
+	}
+	return req, nil
+}
+
+func responseError(resp *http.Response, data []byte) error {
+	e := &Error{Status: resp.StatusCode}
+	if json.Unmarshal(data, &e.ErrorResponse) != nil || e.Code == "" {
+		e.Code = middleware.StatusCode(resp.StatusCode)
+		e.Message = string(data)
+	}
+	return e
+}
+
+func (c *Client) once(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	req, err := c.request(ctx, method, path, header, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, data, responseError(resp, data)
+	}
+	return resp, data, nil
+}
+
+// retryable is true for the requests which didn't reach the proxy and the
+// proxy overloaded or unavailable.
+func retryable(resp *http.Response, err error) bool {
+	if resp == nil {
+		return err != nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opt.RetryBackoff << uint(attempt)
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	// up to half of it is random, the clients don't retry in step
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	if method != http.MethodGet && c.opt.Retries > 0 {
+		if key := newIdempotencyKey(); key != "" {
+			h := make(http.Header, len(header)+1)
+			for k, v := range header {
+				h[k] = v
+			}
+			h.Set(idempotencyKeyHeader, key)
+			header = h
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		resp, data, err := c.once(ctx, method, path, header, body)
+		if attempt >= c.opt.Retries || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, data, err
+		}
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, data, err
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) Get(ctx context.Context, key []byte) ([]byte, error) {
+	_, data, err := c.do(ctx, http.MethodGet, "/meta/"+encodeKey(key), nil, nil)
+	return data, err
+}
+
+// CheckAndPut writes newVal when the current value matches oldVal, an empty
+// newVal deletes the key.
+func (c *Client) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte) error {
+	body, err := json.Marshal(store.Log{Old: string(oldVal), New: string(newVal)})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Exact", "true")
+	_, _, err = c.do(ctx, http.MethodPut, "/meta/"+encodeKey(key), header, body)
+	return err
+}
+
+func (c *Client) Put(ctx context.Context, key, val []byte) error {
+	_, _, err := c.do(ctx, http.MethodPut, "/unsafe/meta/"+encodeKey(key), nil, val)
+	return err
+}
+
+func (c *Client) Delete(ctx context.Context, key []byte) error {
+	_, _, err := c.do(ctx, http.MethodDelete, "/unsafe/meta/"+encodeKey(key), nil, nil)
+	return err
+}
+
+type ListOption struct {
+	Limit   int
+	Reverse bool
+	KeyOnly bool
+}
+
+func (c *Client) List(ctx context.Context, start, end []byte, opt ListOption) ([]store.KeyValue, error) {
+	header := http.Header{}
+	header.Set("X-Start", encodeKey(start))
+	header.Set("X-End", encodeKey(end))
+	if opt.Limit > 0 {
+		header.Set("X-Limit", strconv.Itoa(opt.Limit))
+	}
+	if opt.Reverse {
+		header.Set("X-Reverse", "true")
+	}
+	if opt.KeyOnly {
+		header.Set("X-Key-Only", "true")
+	}
+	_, data, err := c.do(ctx, http.MethodGet, "/list", header, nil)
+	if err != nil {
+		return nil, err
+	}
+	var items []store.KeyValue
+	err = json.Unmarshal(data, &items)
+	return items, err
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, Options{Retries: 2, RetryBackoff: time.Millisecond})
+	assert.Nil(t, c.Put(context.Background(), []byte("k"), []byte("v")))
+	assert.Equal(t, int32(3), calls)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, keys[0], keys[2])
+
+	calls = 0
+	c = New(srv.URL, Options{Retries: 1, RetryBackoff: time.Millisecond})
+	err := c.Put(context.Background(), []byte("k"), []byte("v"))
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusServiceUnavailable, e.Status)
+}
+
+func TestWatch(t *testing.T) {
+	var lastIDs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIDs = append(lastIDs, r.Header.Get(lastEventIDHeader))
+		assert.Equal(t, "a2V5", r.URL.Query().Get("prefix"))
+		switch len(lastIDs) {
+		case 1:
+			fmt.Fprint(w, ":ping\n\nid:1\nevent:change\ndata:{\"key\":\"a2V5MQ\",\"op\":\"put\",\"new\":\"v1\"}\n\n")
+			fmt.Fprint(w, "event:overflow\ndata:{}\n\n")
+		case 2:
+			fmt.Fprint(w, "event:reset\ndata:{\"last-event-id\":1}\n\n")
+			fmt.Fprint(w, "id:5\nevent:change\ndata:{\"key\":\"a2V5Mg\",\"op\":\"delete\",\"old\":\"v2\"}\n\n")
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	var events []WatchEvent
+	c := New(srv.URL, Options{RetryBackoff: time.Millisecond})
+	err := c.Watch(context.Background(), []byte("key"), func(ev WatchEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusForbidden, e.Status)
+	assert.Equal(t, []string{"", "1", "5"}, lastIDs)
+	assert.Equal(t, []WatchEvent{
+		{ID: 1, Key: []byte("key1"), Op: "put", New: []byte("v1")},
+		{Op: OpReset},
+		{ID: 5, Key: []byte("key2"), Op: "delete", Old: []byte("v2")},
+	}, events)
+
+	stop := errors.New("stop")
+	lastIDs = nil
+	err = c.Watch(context.Background(), []byte("key"), func(ev WatchEvent) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+}
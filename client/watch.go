@@ -0,0 +1,190 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+const (
+	lastEventIDHeader = "Last-Event-ID"
+
+	// OpReset is the op of the event sent when the proxy didn't keep the
+	// changes since the last one, the caller lists the prefix again.
+	OpReset = "reset"
+)
+
+// WatchEvent is a change under the prefix of a watch, ID orders them.
+type WatchEvent struct {
+	ID  uint64
+	Key []byte
+	Op  string
+	Old []byte
+	New []byte
+}
+
+type watchData struct {
+	Key string `json:"key"`
+	Op  string `json:"op"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Watch calls fn with the changes under prefix until ctx is done or fn
+// fails. The stream is opened again after the last change when it ends,
+// falls behind or fails to connect, an error of the proxy like 403 ends
+// the watch.
+func (c *Client) Watch(ctx context.Context, prefix []byte, fn func(WatchEvent) error) error {
+	var lastID uint64
+	for attempt := 0; ; {
+		received, err := c.watch(ctx, prefix, lastID, func(ev WatchEvent) error {
+			if ev.ID > 0 {
+				lastID = ev.ID
+			}
+			return fn(ev)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var e *Error
+		if errors.As(err, &e) && !retryable(&http.Response{StatusCode: e.Status}, err) {
+			return err
+		} else if errors.Is(err, errWatchCallback) {
+			return errors.Unwrap(err)
+		}
+		if received {
+			attempt = 0
+		}
+		if err == nil {
+			continue
+		}
+		timer := time.NewTimer(c.backoff(attempt))
+		attempt++
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+var errWatchCallback = errors.New("watch callback failed")
+
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string {
+	return e.err.Error()
+}
+
+func (e *callbackError) Is(target error) bool {
+	return target == errWatchCallback
+}
+
+func (e *callbackError) Unwrap() error {
+	return e.err
+}
+
+// watch reads a stream, received is true if it got an event.
+func (c *Client) watch(ctx context.Context, prefix []byte, lastID uint64, fn func(WatchEvent) error) (bool, error) {
+	header := http.Header{}
+	header.Set("Accept", "text/event-stream")
+	if lastID > 0 {
+		header.Set(lastEventIDHeader, strconv.FormatUint(lastID, 10))
+	}
+	req, err := c.request(ctx, http.MethodGet, "/watch?prefix="+encodeKey(prefix), header, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return false, responseError(resp, data)
+	}
+
+	received := false
+	var id, event string
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			// a comment is a ping
+			if strings.HasPrefix(line, ":") {
+				continue
+			}
+			field, value := line, ""
+			if i := strings.IndexByte(line, ':'); i >= 0 {
+				field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch field {
+			case "id":
+				id = value
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+			continue
+		}
+
+		ev, ok, err := parseEvent(id, event, strings.Join(data, "\n"))
+		id, event, data = "", "", nil
+		if err != nil {
+			return received, err
+		}
+		if !ok {
+			continue
+		}
+		received = true
+		if err := fn(ev); err != nil {
+			return received, &callbackError{err}
+		}
+	}
+	return received, scanner.Err()
+}
+
+// parseEvent skips the overflow event, the stream ends after it.
+func parseEvent(id, event, data string) (WatchEvent, bool, error) {
+	switch event {
+	case "change":
+	case OpReset:
+		return WatchEvent{Op: OpReset}, true, nil
+	default:
+		return WatchEvent{}, false, nil
+	}
+	var d watchData
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		return WatchEvent{}, false, err
+	}
+	ev := WatchEvent{Op: d.Op}
+	var err error
+	if ev.ID, err = strconv.ParseUint(id, 10, 64); err != nil {
+		return WatchEvent{}, false, err
+	}
+	if ev.Key, err = base64.RawURLEncoding.DecodeString(d.Key); err != nil {
+		return WatchEvent{}, false, err
+	}
+	if d.Old != "" {
+		ev.Old = []byte(d.Old)
+	}
+	if d.New != "" {
+		ev.New = []byte(d.New)
+	}
+	return ev, true, nil
+}
@@ -0,0 +1,383 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/huangnauh/tirest/client"
+	"github.com/huangnauh/tirest/server"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	benchGet  = "get"
+	benchPut  = "put"
+	benchCas  = "cas"
+	benchList = "list"
+)
+
+var benchOps = []string{benchGet, benchPut, benchCas, benchList}
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "bench",
+		Usage: "drive a read/write mix against a proxy, or a store with --direct",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "proxy address",
+				Value: "127.0.0.1:6100",
+			},
+			&cli.StringFlag{
+				Name:  "token",
+				Usage: "access token",
+			},
+			&cli.BoolFlag{
+				Name:  "direct",
+				Usage: "open the store of --config instead of calling the proxy",
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "server config, used with --direct",
+				Value:   "./server.toml",
+			},
+			&cli.UintFlag{
+				Name:    "verbose",
+				Aliases: []string{"vb"},
+				Usage:   "verbose info(2 error, 3 warn, 4 info, 5 debug)",
+				Value:   2,
+			},
+			&cli.StringFlag{
+				Name:  "mix",
+				Usage: "weights of get, put, cas and list",
+				Value: "get=80,put=10,cas=5,list=5",
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "concurrent workers",
+				Value: 16,
+			},
+			&cli.DurationFlag{
+				Name:  "duration",
+				Usage: "how long to run",
+				Value: 30 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:  "keys",
+				Usage: "number of keys",
+				Value: 100000,
+			},
+			&cli.StringFlag{
+				Name:  "prefix",
+				Usage: "key prefix",
+				Value: "bench/",
+			},
+			&cli.StringFlag{
+				Name:  "dist",
+				Usage: "key distribution, uniform or zipf",
+				Value: "uniform",
+			},
+			&cli.Float64Flag{
+				Name:  "zipf-s",
+				Usage: "zipf exponent, > 1",
+				Value: 1.1,
+			},
+			&cli.IntFlag{
+				Name:  "value-size",
+				Usage: "value size in bytes",
+				Value: 256,
+			},
+			&cli.IntFlag{
+				Name:  "list-limit",
+				Usage: "limit of a list",
+				Value: 100,
+			},
+		},
+		Action: runBench,
+	})
+}
+
+var errBenchConflict = errors.New("cas conflict")
+
+// benchTarget is either the proxy or a store opened directly, a missing key
+// is a nil value and a failed cas is errBenchConflict.
+type benchTarget interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Put(ctx context.Context, key, val []byte) error
+	CheckAndPut(ctx context.Context, key, oldVal, newVal []byte) error
+	List(ctx context.Context, start, end []byte, limit int) error
+}
+
+type proxyTarget struct {
+	c *client.Client
+}
+
+func (t proxyTarget) Get(ctx context.Context, key []byte) ([]byte, error) {
+	v, err := t.c.Get(ctx, key)
+	if client.IsNotFound(err) {
+		return nil, nil
+	}
+	return v, err
+}
+
+func (t proxyTarget) Put(ctx context.Context, key, val []byte) error {
+	return t.c.Put(ctx, key, val)
+}
+
+func (t proxyTarget) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte) error {
+	err := t.c.CheckAndPut(ctx, key, oldVal, newVal)
+	if e, ok := err.(*client.Error); ok && e.Status == http.StatusConflict {
+		return errBenchConflict
+	}
+	return err
+}
+
+func (t proxyTarget) List(ctx context.Context, start, end []byte, limit int) error {
+	_, err := t.c.List(ctx, start, end, client.ListOption{Limit: limit})
+	return err
+}
+
+type storeTarget struct {
+	s *store.Store
+}
+
+func (t storeTarget) Get(ctx context.Context, key []byte) ([]byte, error) {
+	k, _ := server.EncodeMetaKey(string(key), true)
+	v, err := t.s.Get(ctx, k, server.DefaultGetOption())
+	if errors.Is(err, xerror.ErrNotExists) {
+		return nil, nil
+	}
+	return v.Value, err
+}
+
+func (t storeTarget) Put(ctx context.Context, key, val []byte) error {
+	k, _ := server.EncodeMetaKey(string(key), true)
+	return t.s.UnsafePut(ctx, k, val)
+}
+
+func (t storeTarget) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte) error {
+	k, _ := server.EncodeMetaKey(string(key), true)
+	entry, err := json.Marshal(store.Log{Old: string(oldVal), New: string(newVal)})
+	if err != nil {
+		return err
+	}
+	err = t.s.CheckAndPut(ctx, k, entry, store.CheckOption{Check: server.ExactCheck})
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		return nil
+	} else if errors.Is(err, xerror.ErrCheckAndSetFailed) {
+		return errBenchConflict
+	}
+	return err
+}
+
+func (t storeTarget) List(ctx context.Context, start, end []byte, limit int) error {
+	s, _ := server.EncodeMetaKey(string(start), true)
+	e, _ := server.EncodeMetaKey(string(end), true)
+	_, err := t.s.List(ctx, s, e, limit, server.DefaultListOption())
+	return err
+}
+
+type benchStats struct {
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	conflicts map[string]int
+}
+
+func newBenchStats() *benchStats {
+	return &benchStats{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]int),
+		conflicts: make(map[string]int),
+	}
+}
+
+func (b *benchStats) merge(o *benchStats) {
+	for op, l := range o.latencies {
+		b.latencies[op] = append(b.latencies[op], l...)
+	}
+	for op, n := range o.errors {
+		b.errors[op] += n
+	}
+	for op, n := range o.conflicts {
+		b.conflicts[op] += n
+	}
+}
+
+// parseMix turns "get=80,put=20" into ops and cumulative weights.
+func parseMix(mix string) ([]string, []int, error) {
+	var ops []string
+	var weights []int
+	total := 0
+	for _, part := range strings.Split(mix, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, nil, fmt.Errorf("invalid mix %q", part)
+		}
+		known := false
+		for _, op := range benchOps {
+			known = known || op == kv[0]
+		}
+		w, err := strconv.Atoi(kv[1])
+		if !known || err != nil || w < 0 {
+			return nil, nil, fmt.Errorf("invalid mix %q", part)
+		}
+		if w == 0 {
+			continue
+		}
+		total += w
+		ops = append(ops, kv[0])
+		weights = append(weights, total)
+	}
+	if total == 0 {
+		return nil, nil, errors.New("empty mix")
+	}
+	return ops, weights, nil
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
+
+func runBench(c *cli.Context) error {
+	ops, weights, err := parseMix(c.String("mix"))
+	if err != nil {
+		return err
+	}
+	keys := c.Int("keys")
+	concurrency := c.Int("concurrency")
+	if keys <= 0 || concurrency <= 0 {
+		return errors.New("keys and concurrency must be positive")
+	}
+	dist := c.String("dist")
+	if dist != "uniform" && dist != "zipf" {
+		return fmt.Errorf("invalid dist %s", dist)
+	}
+	if dist == "zipf" && c.Float64("zipf-s") <= 1 {
+		return errors.New("zipf-s must be > 1")
+	}
+
+	var target benchTarget
+	if c.Bool("direct") {
+		s, err := getStore(c)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		target = storeTarget{s: s}
+	} else {
+		target = proxyTarget{c: client.New(c.String("addr"), client.Options{
+			Timeout:     10 * time.Second,
+			AccessToken: c.String("token"),
+		})}
+	}
+
+	prefix := c.String("prefix")
+	valueSize := c.Int("value-size")
+	listLimit := c.Int("list-limit")
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("%s%012d", prefix, i))
+	}
+
+	duration := c.Duration("duration")
+	ctx, cancel := context.WithTimeout(c.Context, duration)
+	defer cancel()
+
+	fmt.Printf("bench %s for %s, concurrency %d, %d keys %s\n",
+		c.String("mix"), duration, concurrency, keys, dist)
+	stats := newBenchStats()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			next := func() int { return r.Intn(keys) }
+			if dist == "zipf" {
+				z := rand.NewZipf(r, c.Float64("zipf-s"), 1, uint64(keys-1))
+				next = func() int { return int(z.Uint64()) }
+			}
+			value := make([]byte, valueSize)
+			local := newBenchStats()
+			for ctx.Err() == nil {
+				n := r.Intn(weights[len(weights)-1])
+				op := ops[sort.SearchInts(weights, n+1)]
+				k := key(next())
+				r.Read(value)
+				for i := range value {
+					value[i] = 'a' + value[i]%26
+				}
+
+				begin := time.Now()
+				var err error
+				switch op {
+				case benchGet:
+					_, err = target.Get(ctx, k)
+				case benchPut:
+					err = target.Put(ctx, k, value)
+				case benchCas:
+					var old []byte
+					old, err = target.Get(ctx, k)
+					if err == nil {
+						err = target.CheckAndPut(ctx, k, old, value)
+					}
+				case benchList:
+					i := next()
+					end := i + listLimit
+					if end > keys {
+						end = keys
+					}
+					err = target.List(ctx, key(i), key(end), listLimit)
+				}
+				spend := time.Since(begin)
+				if err != nil && ctx.Err() != nil {
+					// cut by the end of the run
+					break
+				}
+				if err == errBenchConflict {
+					local.conflicts[op]++
+				} else if err != nil {
+					local.errors[op]++
+				}
+				local.latencies[op] = append(local.latencies[op], spend)
+			}
+			mu.Lock()
+			stats.merge(local)
+			mu.Unlock()
+		}(time.Now().UnixNano() + int64(w))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\terrors\tconflicts\tqps\tp50\tp90\tp99\tp999\tmax\t")
+	for _, op := range benchOps {
+		l := stats.latencies[op]
+		if len(l) == 0 {
+			continue
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\t\n",
+			op, len(l), stats.errors[op], stats.conflicts[op], float64(len(l))/elapsed.Seconds(),
+			percentile(l, 0.5), percentile(l, 0.9), percentile(l, 0.99), percentile(l, 0.999), l[len(l)-1])
+	}
+	return tw.Flush()
+}
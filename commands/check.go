@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/server"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/validator"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "check-config",
+		Usage: "validate a config and print it with the defaults applied",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"conf"},
+				Usage:   "server config, toml or yaml by the extension",
+				Value:   "./server.toml",
+			},
+			&cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "override an option over the config and the TIREST_ variables, e.g. store.name=tikv, can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "only print problems",
+			},
+		},
+		Action: runCheckConfig,
+	})
+}
+
+func checkConfig(configFile string, sets []string) (*config.Config, []error) {
+	conf, err := config.Load(configFile, sets)
+	if err != nil {
+		return nil, []error{err}
+	}
+	errs := config.Check(configFile, conf)
+	if _, err = server.ParseMode(conf.Server.Mode); err != nil {
+		errs = append(errs, fmt.Errorf("server.mode: %s", err))
+	}
+	// drivers are registered by the imports of main
+	if _, err = store.NewStore(conf); err != nil {
+		errs = append(errs, fmt.Errorf("store, connector, cache or event-rule: %s", err))
+	}
+	if _, err = validator.New(conf.Validations); err != nil {
+		errs = append(errs, fmt.Errorf("validation: %s", err))
+	}
+	if conf.ACL.Enable {
+		if _, err = middleware.NewACL(&conf.ACL); err != nil {
+			errs = append(errs, fmt.Errorf("acl: %s", err))
+		}
+	}
+	return conf, errs
+}
+
+func runCheckConfig(c *cli.Context) error {
+	configFile := c.String("config")
+	conf, errs := checkConfig(configFile, c.StringSlice("set"))
+	if conf != nil && !c.Bool("quiet") {
+		var b strings.Builder
+		toml.NewEncoder(&b).Encode(conf)
+		fmt.Println(conf.Redact(b.String()))
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s has %d problems", configFile, len(errs))
+	}
+	fmt.Fprintf(os.Stderr, "%s ok\n", configFile)
+	return nil
+}
@@ -10,6 +10,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/store/kafka"
+	"github.com/huangnauh/tirest/xerror"
 )
 
 func init() {
@@ -40,6 +43,11 @@ func init() {
 				Usage:   "limit consumer",
 				Value:   10,
 			},
+			&cli.BoolFlag{
+				Name:    "apply",
+				Aliases: []string{"a"},
+				Usage:   "apply the changes of [replica] into the store",
+			},
 		},
 		Action: runConsumer,
 	})
@@ -59,7 +67,37 @@ func runConsumer(c *cli.Context) error {
 	cf := sarama.NewConfig()
 	cf.ClientID = "tikv-consumer"
 
-	cf.Version, err = sarama.ParseKafkaVersion(conf.Connector.Version)
+	kafkaVersion := conf.Connector.Version
+	brokers := conf.Connector.BrokerList
+	kafka.SetAuth(cf, conf.Connector.SASLUser, conf.Connector.SASLPassword, conf.Connector.TLS)
+	topic := conf.Connector.Topic
+	var handler sarama.ConsumerGroupHandler = &Consumer{
+		done:  make(chan struct{}),
+		limit: limit,
+	}
+	if c.Bool("apply") {
+		// replicate another cluster, the changes are applied by their
+		// stamps and aren't sent to the local connector
+		if len(conf.Replica.BrokerList) == 0 || !conf.Server.HLC {
+			logrus.Errorf("apply needs replica.broker-list and server.hlc")
+			return xerror.ErrNotSupported
+		}
+		s, err := store.OnlyOpenDatabase(conf)
+		if err != nil {
+			logrus.Errorf("open store failed, err: %s", err)
+			return err
+		}
+		defer s.Close()
+		kafkaVersion = conf.Replica.Version
+		brokers = conf.Replica.BrokerList
+		kafka.SetAuth(cf, conf.Replica.SASLUser, conf.Replica.SASLPassword, conf.Replica.TLS)
+		topic = conf.Replica.Topic
+		group = conf.Replica.Group
+		oldest = oldest || conf.Replica.Oldest
+		handler = kafka.NewReplica(s, &conf.Replica)
+	}
+
+	cf.Version, err = sarama.ParseKafkaVersion(kafkaVersion)
 	if err != nil {
 		logrus.Errorf("Error parsing version: %v", err)
 		return err
@@ -69,19 +107,15 @@ func runConsumer(c *cli.Context) error {
 		cf.Consumer.Offsets.Initial = sarama.OffsetOldest
 	}
 
-	consumer := Consumer{
-		done:  make(chan struct{}),
-		limit: limit,
-	}
-	ctx, cancel := context.WithCancel(context.Background())
-	client, err := sarama.NewConsumerGroup(conf.Connector.BrokerList, group, cf)
+	client, err := sarama.NewConsumerGroup(brokers, group, cf)
 	if err != nil {
 		logrus.Errorf("init consumer failed, err: %s", err)
 		return err
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		for {
-			if err := client.Consume(ctx, []string{conf.Connector.Topic}, &consumer); err != nil {
+			if err := client.Consume(ctx, []string{topic}, handler); err != nil {
 				logrus.Errorf("Error from consumer: %v", err)
 				return
 			}
@@ -1,7 +1,11 @@
 package commands
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -17,23 +21,114 @@ func init() {
 			&cli.StringFlag{
 				Name:    "config",
 				Aliases: []string{"conf"},
-				Usage:   "init config path",
+				Usage:   "init config path, toml or yaml by the extension",
+			},
+			&cli.StringFlag{
+				Name:  "profile",
+				Usage: "start from a profile, " + strings.Join(config.Profiles, ", ") + ", the defaults if empty",
+			},
+			&cli.StringSliceFlag{
+				Name:  "pd-address",
+				Usage: "pd endpoints of the store, host:port, can be repeated",
+			},
+			&cli.StringSliceFlag{
+				Name:  "brokers",
+				Usage: "kafka brokers of the connector, host:port, can be repeated",
+			},
+			&cli.StringSliceFlag{
+				Name:  "replica-brokers",
+				Usage: "kafka brokers of the cluster replicated, host:port, can be repeated",
+			},
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "api listen address, host:port",
+			},
+			&cli.StringFlag{
+				Name:  "admin-listen",
+				Usage: "admin listen address, host:port",
+			},
+			&cli.BoolFlag{
+				Name:    "force",
+				Aliases: []string{"f"},
+				Usage:   "overwrite an existing config",
+			},
+			&cli.BoolFlag{
+				Name:  "stdout",
+				Usage: "print the config instead of writing it, yaml if the config path is yaml",
 			},
 		},
 		Action: runInit,
 	})
 }
 
+func splitListen(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, p, nil
+}
+
+// initConfig is the profile with the addresses of the flags.
+func initConfig(c *cli.Context) (*config.Config, error) {
+	conf, err := config.NewProfile(c.String("profile"))
+	if err != nil {
+		return nil, err
+	}
+	if pds := c.StringSlice("pd-address"); len(pds) > 0 {
+		conf.Store.PdAddresses = pds
+		conf.Store.Path = "tikv://" + strings.Join(pds, ",")
+	}
+	if brokers := c.StringSlice("brokers"); len(brokers) > 0 {
+		conf.Connector.BrokerList = brokers
+		conf.Connector.EnableProducer = true
+	}
+	if brokers := c.StringSlice("replica-brokers"); len(brokers) > 0 {
+		conf.Replica.BrokerList = brokers
+		conf.Server.HLC = true
+	}
+	if addr := c.String("listen"); addr != "" {
+		if conf.Server.HttpHost, conf.Server.HttpPort, err = splitListen(addr); err != nil {
+			return nil, fmt.Errorf("listen: %s", err)
+		}
+	}
+	if addr := c.String("admin-listen"); addr != "" {
+		if conf.Admin.HttpHost, conf.Admin.HttpPort, err = splitListen(addr); err != nil {
+			return nil, fmt.Errorf("admin-listen: %s", err)
+		}
+	}
+	return conf, nil
+}
+
 func runInit(c *cli.Context) error {
+	conf, err := initConfig(c)
+	if err != nil {
+		logrus.Errorf("init config failed, %s", err)
+		return err
+	}
+	for _, err := range conf.Validate() {
+		logrus.Warnf("config %s", err)
+	}
 	path := c.String("config")
-	_, err := os.Stat(path)
-	if err == nil {
-		logrus.Errorf("file exist, %s", path)
+	if c.Bool("stdout") {
+		return config.Encode(os.Stdout, conf, config.IsYAML(path))
+	}
+	if path == "" {
+		logrus.Errorf("no config path, or --stdout")
+		return xerror.ErrInvalidArgument
+	}
+	_, err = os.Stat(path)
+	if err == nil && !c.Bool("force") {
+		logrus.Errorf("file exist, %s, --force to overwrite", path)
 		return xerror.ErrExists
 	}
-	if !os.IsNotExist(err) {
+	if err != nil && !os.IsNotExist(err) {
 		logrus.Errorf("path %s err, %s", path, err)
 		return err
 	}
-	return config.Save(config.DefaultConfig(), path)
+	return config.Save(conf, path)
 }
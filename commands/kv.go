@@ -0,0 +1,305 @@
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/huangnauh/tirest/client"
+	"github.com/huangnauh/tirest/server"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "kv",
+		Usage: "get, put, delete and scan keys through a proxy, or a store with --direct",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "addr",
+				Usage: "proxy address",
+				Value: "127.0.0.1:6100",
+			},
+			&cli.StringFlag{
+				Name:  "token",
+				Usage: "access token",
+			},
+			&cli.BoolFlag{
+				Name:  "direct",
+				Usage: "open the store of --config instead of calling the proxy",
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "server config, used with --direct",
+				Value:   "./server.toml",
+			},
+			&cli.UintFlag{
+				Name:    "verbose",
+				Aliases: []string{"vb"},
+				Usage:   "verbose info(2 error, 3 warn, 4 info, 5 debug)",
+				Value:   2,
+			},
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "raw key, otherwise keys are base64 url encoded",
+			},
+			&cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "timeout of a request",
+				Value: 10 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:  "retries",
+				Usage: "retries of a request failing to connect or with 429, 502, 503 or 504",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:      "get",
+				Usage:     "print the value of the key",
+				ArgsUsage: "KEY",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("invalid KEY")
+					}
+					return runKVClient(c, kvGet)
+				},
+			},
+			{
+				Name:      "put",
+				Usage:     "put the value, with --old only when the current value matches",
+				ArgsUsage: "KEY VALUE",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "old",
+						Usage: "expected current value, empty means the key must not exist",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						return errors.New("invalid KEY VALUE")
+					}
+					return runKVClient(c, kvPut)
+				},
+			},
+			{
+				Name:      "del",
+				Usage:     "delete the key, with --old only when the current value matches",
+				ArgsUsage: "KEY",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "old",
+						Usage: "expected current value",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("invalid KEY")
+					}
+					return runKVClient(c, kvDelete)
+				},
+			},
+			{
+				Name:  "scan",
+				Usage: "print the keys and values of a range",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "start",
+						Aliases: []string{"s"},
+						Usage:   "start",
+					},
+					&cli.StringFlag{
+						Name:    "end",
+						Aliases: []string{"e"},
+						Usage:   "end",
+					},
+					&cli.IntFlag{
+						Name:    "limit",
+						Aliases: []string{"l"},
+						Usage:   "limit",
+						Value:   10,
+					},
+					&cli.BoolFlag{
+						Name:    "reverse",
+						Aliases: []string{"r"},
+						Usage:   "reverse",
+					},
+					&cli.BoolFlag{
+						Name:  "key-only",
+						Usage: "print keys only",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return runKVClient(c, kvScan)
+				},
+			},
+		},
+	})
+}
+
+// kvClient is implemented by the proxy client and by storeClient, keys are
+// the user keys without the meta type.
+type kvClient interface {
+	Get(ctx context.Context, key []byte) ([]byte, error)
+	Put(ctx context.Context, key, val []byte) error
+	CheckAndPut(ctx context.Context, key, oldVal, newVal []byte) error
+	Delete(ctx context.Context, key []byte) error
+	List(ctx context.Context, start, end []byte, opt client.ListOption) ([]store.KeyValue, error)
+}
+
+type storeClient struct {
+	s *store.Store
+}
+
+func metaKey(key []byte) []byte {
+	k, _ := server.EncodeMetaKey(string(key), true)
+	return k
+}
+
+func (sc storeClient) Get(ctx context.Context, key []byte) ([]byte, error) {
+	v, err := sc.s.Get(ctx, metaKey(key), server.DefaultGetOption())
+	return v.Value, err
+}
+
+func (sc storeClient) Put(ctx context.Context, key, val []byte) error {
+	return sc.s.UnsafePut(ctx, metaKey(key), val)
+}
+
+func (sc storeClient) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte) error {
+	entry, err := json.Marshal(store.Log{Old: string(oldVal), New: string(newVal)})
+	if err != nil {
+		return err
+	}
+	err = sc.s.CheckAndPut(ctx, metaKey(key), entry, store.CheckOption{Check: server.ExactCheck})
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		return nil
+	}
+	return err
+}
+
+func (sc storeClient) Delete(ctx context.Context, key []byte) error {
+	return sc.s.UnsafePut(ctx, metaKey(key), nil)
+}
+
+func (sc storeClient) List(ctx context.Context, start, end []byte, opt client.ListOption) ([]store.KeyValue, error) {
+	o := server.DefaultListOption()
+	o.Reverse = opt.Reverse
+	o.KeyOnly = opt.KeyOnly
+	return sc.s.List(ctx, metaKey(start), metaKey(end), opt.Limit, o)
+}
+
+func runKVClient(c *cli.Context, fn func(ctx context.Context, c *cli.Context, kc kvClient) error) error {
+	var kc kvClient
+	if c.Bool("direct") {
+		s, err := getStore(c)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		kc = storeClient{s: s}
+	} else {
+		kc = client.New(c.String("addr"), client.Options{
+			Timeout:     c.Duration("timeout"),
+			AccessToken: c.String("token"),
+			Retries:     c.Int("retries"),
+		})
+	}
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+	err := fn(ctx, c, kc)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed, err: %s\n", c.Command.Name, err)
+	}
+	return err
+}
+
+// parseKey returns the user key, a raw key is unquoted and any other key is
+// base64 url decoded like the proxy does.
+func parseKey(c *cli.Context, s string) ([]byte, error) {
+	s, err := unquote(s)
+	if err != nil {
+		return nil, err
+	}
+	if c.Bool("raw") {
+		return []byte(s), nil
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func formatKey(c *cli.Context, key []byte) string {
+	if c.Bool("raw") {
+		return strconv.Quote(string(key))
+	}
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func kvGet(ctx context.Context, c *cli.Context, kc kvClient) error {
+	key, err := parseKey(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	v, err := kc.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", v)
+	return nil
+}
+
+func kvPut(ctx context.Context, c *cli.Context, kc kvClient) error {
+	key, err := parseKey(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	val := []byte(c.Args().Get(1))
+	if c.IsSet("old") {
+		return kc.CheckAndPut(ctx, key, []byte(c.String("old")), val)
+	}
+	return kc.Put(ctx, key, val)
+}
+
+func kvDelete(ctx context.Context, c *cli.Context, kc kvClient) error {
+	key, err := parseKey(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if c.IsSet("old") {
+		return kc.CheckAndPut(ctx, key, []byte(c.String("old")), nil)
+	}
+	return kc.Delete(ctx, key)
+}
+
+func kvScan(ctx context.Context, c *cli.Context, kc kvClient) error {
+	start, err := parseKey(c, c.String("start"))
+	if err != nil {
+		return err
+	}
+	end, err := parseKey(c, c.String("end"))
+	if err != nil {
+		return err
+	}
+	items, err := kc.List(ctx, start, end, client.ListOption{
+		Limit:   c.Int("limit"),
+		Reverse: c.Bool("reverse"),
+		KeyOnly: c.Bool("key-only"),
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if c.Bool("key-only") {
+			fmt.Println(formatKey(c, []byte(item.Key)))
+		} else {
+			fmt.Printf("%s\t%s\n", formatKey(c, []byte(item.Key)), item.Value)
+		}
+	}
+	return nil
+}
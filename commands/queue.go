@@ -0,0 +1,407 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/log"
+	"github.com/huangnauh/tirest/store/kafka"
+	"github.com/huangnauh/tirest/version"
+	"github.com/nsqio/go-diskqueue"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const queueRedriveBatch = 256
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "queue",
+		Usage: "inspect and repair the connector diskqueue, stop the server before a repair",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "server config",
+				Value:   "./server.toml",
+			},
+		},
+		Subcommands: []*cli.Command{
+			{
+				Name:   "stat",
+				Usage:  "print the depth, positions and files",
+				Action: runQueueStat,
+			},
+			{
+				Name:   "check",
+				Usage:  "scan the files and report corrupted messages",
+				Action: runQueueCheck,
+			},
+			{
+				Name:   "truncate",
+				Usage:  "cut corrupted files at the last good message",
+				Action: runQueueTruncate,
+			},
+			{
+				Name:   "compact",
+				Usage:  "rewrite the unsent good messages into a fresh queue",
+				Action: runQueueCompact,
+			},
+			{
+				Name:  "redrive",
+				Usage: "send the unsent messages, or the messages of --file, to kafka",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "file",
+						Usage: "data file to send, e.g. a .bad file, can be repeated",
+					},
+					&cli.BoolFlag{
+						Name:  "commit",
+						Usage: "mark the unsent messages as sent once they are acked",
+					},
+				},
+				Action: runQueueRedrive,
+			},
+		},
+	})
+}
+
+// queueSegment is the unsent part of a data file.
+type queueSegment struct {
+	file kafka.QueueFile
+	from int64
+}
+
+func openQueue(c *cli.Context) (*config.Config, kafka.QueueMeta, []kafka.QueueFile, error) {
+	conf, err := config.InitConfig(c.String("config"))
+	if err != nil {
+		fmt.Printf("init config failed, err: %s\n", err)
+		return nil, kafka.QueueMeta{}, nil, err
+	}
+	dataPath := conf.Connector.QueueDataPath
+	meta, err := kafka.ReadQueueMeta(dataPath)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("read queue meta, err: %s\n", err)
+		return nil, meta, nil, err
+	}
+	files, err := kafka.QueueFiles(dataPath)
+	if err != nil {
+		fmt.Printf("list queue files, err: %s\n", err)
+		return nil, meta, nil, err
+	}
+	return conf, meta, files, nil
+}
+
+func pendingSegments(meta kafka.QueueMeta, files []kafka.QueueFile) []queueSegment {
+	var segments []queueSegment
+	for _, f := range files {
+		if f.Bad || f.Num < meta.ReadFileNum {
+			continue
+		}
+		s := queueSegment{file: f}
+		if f.Num == meta.ReadFileNum {
+			s.from = meta.ReadPos
+		}
+		segments = append(segments, s)
+	}
+	return segments
+}
+
+func runQueueStat(c *cli.Context) error {
+	conf, meta, files, err := openQueue(c)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("path: %s\n", conf.Connector.QueueDataPath)
+	fmt.Printf("depth: %d\n", meta.Depth)
+	fmt.Printf("read: %06d:%d\n", meta.ReadFileNum, meta.ReadPos)
+	fmt.Printf("write: %06d:%d\n", meta.WriteFileNum, meta.WritePos)
+	if cp, err := kafka.ReadCheckpoint(conf.Connector.QueueDataPath); err == nil {
+		fmt.Printf("checkpoint: %06d:%d\n", cp.FileNum, cp.Pos)
+	}
+	var pending int64
+	for _, s := range pendingSegments(meta, files) {
+		pending += s.file.Size - s.from
+	}
+	fmt.Printf("pending bytes: %d\n", pending)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tSTATE")
+	for _, f := range files {
+		state := "unsent"
+		if f.Bad {
+			state = "bad"
+		} else if f.Num < meta.ReadFileNum {
+			state = "sent"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\n", filepath.Base(f.Path), f.Size, state)
+	}
+	return w.Flush()
+}
+
+func runQueueCheck(c *cli.Context) error {
+	conf, meta, files, err := openQueue(c)
+	if err != nil {
+		return err
+	}
+	maxMsgSize := conf.Connector.MaxMsgSize
+	var problems, depth int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tSIZE\tMESSAGES\tINVALID\tSTATUS")
+	for _, f := range files {
+		var messages, invalid int64
+		valid, err := kafka.ScanQueueFile(f.Path, 0, maxMsgSize, func(pos int64, body []byte) {
+			messages++
+			if _, err := kafka.DecodeMessage(body); err != nil {
+				invalid++
+			}
+			if !f.Bad && (f.Num > meta.ReadFileNum || (f.Num == meta.ReadFileNum && pos >= meta.ReadPos)) {
+				depth++
+			}
+		})
+		status := "ok"
+		if err != nil {
+			status = err.Error()
+			problems++
+		} else if invalid > 0 {
+			status = "invalid messages"
+			problems++
+		} else if f.Bad {
+			status = "bad"
+			problems++
+		} else if f.Num == meta.WriteFileNum && valid != meta.WritePos {
+			status = fmt.Sprintf("write position %d, file ends at %d", meta.WritePos, valid)
+			problems++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", filepath.Base(f.Path), f.Size, messages, invalid, status)
+	}
+	w.Flush()
+	if depth != meta.Depth {
+		fmt.Printf("depth %d, %d unsent messages found\n", meta.Depth, depth)
+		problems++
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problems found", problems)
+	}
+	return nil
+}
+
+func runQueueTruncate(c *cli.Context) error {
+	conf, meta, files, err := openQueue(c)
+	if err != nil {
+		return err
+	}
+	maxMsgSize := conf.Connector.MaxMsgSize
+	meta.Depth = 0
+	for _, f := range files {
+		if f.Bad {
+			continue
+		}
+		valid, err := kafka.ScanQueueFile(f.Path, 0, maxMsgSize, func(pos int64, body []byte) {
+			if f.Num > meta.ReadFileNum || (f.Num == meta.ReadFileNum && pos >= meta.ReadPos) {
+				meta.Depth++
+			}
+		})
+		if err != nil && !errors.Is(err, kafka.ErrCorruptedMessage) {
+			fmt.Printf("scan %s, err: %s\n", f.Path, err)
+			return err
+		}
+		if err != nil {
+			fmt.Printf("truncate %s at %d, %s\n", f.Path, valid, err)
+			if err = os.Truncate(f.Path, valid); err != nil {
+				fmt.Printf("truncate %s, err: %s\n", f.Path, err)
+				return err
+			}
+		}
+		if f.Num == meta.ReadFileNum && meta.ReadPos > valid {
+			meta.ReadPos = valid
+		}
+		if f.Num == meta.WriteFileNum {
+			meta.WritePos = valid
+		}
+	}
+	fmt.Printf("depth %d, read %06d:%d, write %06d:%d\n",
+		meta.Depth, meta.ReadFileNum, meta.ReadPos, meta.WriteFileNum, meta.WritePos)
+	return kafka.WriteQueueMeta(conf.Connector.QueueDataPath, meta)
+}
+
+// runQueueCompact writes the good unsent messages to a new queue next to the
+// old one and swaps the files, bad files are left for redrive.
+func runQueueCompact(c *cli.Context) error {
+	conf, meta, files, err := openQueue(c)
+	if err != nil {
+		return err
+	}
+	dataPath := conf.Connector.QueueDataPath
+	tmpPath, err := ioutil.TempDir(dataPath, "compact")
+	if err != nil {
+		fmt.Printf("create compact dir, err: %s\n", err)
+		return err
+	}
+	defer os.RemoveAll(tmpPath)
+
+	l := logrus.WithFields(logrus.Fields{"worker": "queue compact"})
+	q := diskqueue.New(version.APP, tmpPath,
+		conf.Connector.MaxBytesPerFile, 4, conf.Connector.MaxMsgSize,
+		conf.Connector.SyncEvery, conf.Connector.SyncTimeout.Duration, log.NewLogFunc(l))
+	var kept, dropped int64
+	var putErr error
+	for _, s := range pendingSegments(meta, files) {
+		_, err := kafka.ScanQueueFile(s.file.Path, s.from, conf.Connector.MaxMsgSize, func(pos int64, body []byte) {
+			if _, err := kafka.DecodeMessage(body); err != nil {
+				dropped++
+				return
+			}
+			if putErr == nil {
+				putErr = q.Put(body)
+			}
+			kept++
+		})
+		if err != nil {
+			fmt.Printf("drop the rest of %s, %s\n", s.file.Path, err)
+		}
+	}
+	if err = q.Close(); putErr == nil {
+		putErr = err
+	}
+	if putErr != nil {
+		fmt.Printf("write compact queue, err: %s\n", putErr)
+		return putErr
+	}
+
+	for _, f := range files {
+		if f.Bad {
+			continue
+		}
+		if err = os.Remove(f.Path); err != nil {
+			return err
+		}
+	}
+	compacted, err := kafka.QueueFiles(tmpPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range compacted {
+		if err = os.Rename(f.Path, filepath.Join(dataPath, filepath.Base(f.Path))); err != nil {
+			return err
+		}
+	}
+	newMeta, err := kafka.ReadQueueMeta(tmpPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("kept %d messages, dropped %d invalid\n", kept, dropped)
+	return kafka.WriteQueueMeta(dataPath, newMeta)
+}
+
+func runQueueRedrive(c *cli.Context) error {
+	conf, meta, files, err := openQueue(c)
+	if err != nil {
+		return err
+	}
+	var segments []queueSegment
+	paths := c.StringSlice("file")
+	for _, p := range paths {
+		segments = append(segments, queueSegment{file: kafka.QueueFile{Path: p}})
+	}
+	if len(paths) == 0 {
+		segments = pendingSegments(meta, files)
+	}
+
+	cf := sarama.NewConfig()
+	cf.ClientID = version.APP + "-redrive"
+	kafka.SetAuth(cf, conf.Connector.SASLUser, conf.Connector.SASLPassword, conf.Connector.TLS)
+	cf.Version, err = sarama.ParseKafkaVersion(conf.Connector.Version)
+	if err != nil {
+		fmt.Printf("parse kafka version, err: %s\n", err)
+		return err
+	}
+	cf.Producer.RequiredAcks = sarama.WaitForLocal
+	cf.Producer.Retry.Max = conf.Connector.Retry
+	cf.Producer.Return.Successes = true
+	encoder, err := kafka.NewValueEncoder(&conf.Connector)
+	if err != nil {
+		fmt.Printf("init %s encoder failed, err: %s\n", conf.Connector.Format, err)
+		return err
+	}
+	producer, err := sarama.NewSyncProducer(conf.Connector.BrokerList, cf)
+	if err != nil {
+		fmt.Printf("init producer failed, err: %s\n", err)
+		return err
+	}
+	defer producer.Close()
+
+	var sent, dropped int64
+	batch := make([]*sarama.ProducerMessage, 0, queueRedriveBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := producer.SendMessages(batch); err != nil {
+			return err
+		}
+		sent += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+	start := time.Now()
+	for _, s := range segments {
+		var sendErr error
+		_, err := kafka.ScanQueueFile(s.file.Path, s.from, conf.Connector.MaxMsgSize, func(pos int64, body []byte) {
+			msg, at, err := kafka.DecodeTimedMessage(body)
+			if err != nil {
+				dropped++
+				return
+			}
+			if sendErr != nil {
+				return
+			}
+			value, err := encoder.Encode(msg.Key, msg.Entry, at)
+			if err != nil {
+				dropped++
+				return
+			}
+			batch = append(batch, &sarama.ProducerMessage{
+				Topic: conf.Connector.Topic,
+				Key:   sarama.ByteEncoder(msg.Key),
+				Value: value,
+			})
+			if len(batch) >= queueRedriveBatch {
+				sendErr = flush()
+			}
+		})
+		if sendErr == nil {
+			sendErr = flush()
+		}
+		if sendErr != nil {
+			fmt.Printf("send %s failed after %d messages, err: %s\n", s.file.Path, sent, sendErr)
+			return sendErr
+		}
+		if err != nil {
+			fmt.Printf("skip the rest of %s, %s\n", s.file.Path, err)
+		}
+	}
+	fmt.Printf("sent %d messages in %s, dropped %d invalid\n",
+		sent, time.Since(start).Round(time.Millisecond), dropped)
+
+	if len(paths) > 0 || !c.Bool("commit") {
+		return nil
+	}
+	meta.Depth = 0
+	meta.ReadFileNum, meta.ReadPos = meta.WriteFileNum, meta.WritePos
+	if err = kafka.WriteQueueMeta(conf.Connector.QueueDataPath, meta); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !f.Bad && f.Num < meta.ReadFileNum {
+			os.Remove(f.Path)
+		}
+	}
+	return nil
+}
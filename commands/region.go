@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/huangnauh/tirest/server"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "pre-split",
+		Usage: "split and scatter regions of a key range before a large import",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "server config",
+				Value:   "./server.toml",
+			},
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "raw key",
+			},
+			&cli.UintFlag{
+				Name:    "verbose",
+				Aliases: []string{"vb"},
+				Usage:   "verbose info(2 error, 3 warn, 4 info, 5 debug)",
+				Value:   2,
+			},
+			&cli.StringFlag{
+				Name:     "start",
+				Aliases:  []string{"s"},
+				Usage:    "start",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     "end",
+				Aliases:  []string{"e"},
+				Usage:    "end",
+				Required: true,
+			},
+			&cli.IntFlag{
+				Name:    "regions",
+				Aliases: []string{"n"},
+				Usage:   "number of regions the range is split into",
+				Value:   server.DefaultSplitRegions,
+			},
+			&cli.BoolFlag{
+				Name:  "no-scatter",
+				Usage: "split only, don't scatter the regions",
+			},
+			&cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait for the scatter to finish",
+			},
+		},
+		Action: runPreSplit,
+	})
+}
+
+func runPreSplit(c *cli.Context) error {
+	raw := c.IsSet("raw")
+	start, err := unquote(c.String("start"))
+	if err != nil {
+		fmt.Printf("unquote start, err: %s\n", err)
+		return err
+	}
+	end, err := unquote(c.String("end"))
+	if err != nil {
+		fmt.Printf("unquote end, err: %s\n", err)
+		return err
+	}
+	n := c.Int("regions")
+	if n <= 0 || n > server.MaxSplitRegions {
+		return fmt.Errorf("invalid regions %d, limit %d", n, server.MaxSplitRegions)
+	}
+
+	st, err := server.EncodeMetaKey(start, raw)
+	if err != nil {
+		fmt.Printf("encode start, err: %s\n", err)
+		return err
+	}
+	en, err := server.EncodeMetaKey(end, raw)
+	if err != nil {
+		fmt.Printf("encode end, err: %s\n", err)
+		return err
+	}
+	if string(st) >= string(en) {
+		return errors.New("start must be less than end")
+	}
+
+	s, err := getStore(c)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	keys := server.PreSplitKeys(st, en, n)
+	ids, err := s.PreSplit(c.Context, keys, !c.Bool("no-scatter"), c.Bool("wait"))
+	if err != nil {
+		fmt.Printf("pre-split %s-%s err: %s\n", start, end, err)
+		return err
+	}
+	fmt.Printf("split %d keys, regions %v\n", len(keys), ids)
+	return nil
+}
@@ -0,0 +1,329 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/server"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/store/file"
+	"github.com/huangnauh/tirest/store/kafka"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var errReplayDone = errors.New("replay done")
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "replay",
+		Usage: "send the events of the file connector or of a kafka topic again through the connector of the config",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "server config",
+				Value:   "./server.toml",
+			},
+			&cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "override an option of the config, e.g. connector.topic=backfill, can be repeated",
+			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "file or kafka",
+				Value: file.Name,
+			},
+			&cli.StringSliceFlag{
+				Name:  "file",
+				Usage: "file of the file connector, read after its backups, can be repeated",
+			},
+			&cli.StringSliceFlag{
+				Name:  "broker-list",
+				Usage: "kafka brokers of the source topic",
+			},
+			&cli.StringFlag{
+				Name:  "topic",
+				Usage: "kafka topic of the json format",
+			},
+			&cli.StringFlag{
+				Name:  "kafka-version",
+				Usage: "kafka version of the source, connector.version if unset",
+			},
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "raw key",
+			},
+			&cli.StringSliceFlag{
+				Name:    "prefix",
+				Aliases: []string{"p"},
+				Usage:   "only replay keys with the prefix, can be repeated",
+			},
+			&cli.TimestampFlag{
+				Name:   "since",
+				Usage:  "only replay the events from the time, RFC3339",
+				Layout: time.RFC3339,
+			},
+			&cli.TimestampFlag{
+				Name:   "until",
+				Usage:  "only replay the events before the time, RFC3339",
+				Layout: time.RFC3339,
+			},
+		},
+		Action: runReplay,
+	})
+}
+
+// replayer sends the events matching the prefixes within [since, until) to
+// the connector.
+type replayer struct {
+	conn     store.Connector
+	prefixes [][]byte
+	since    time.Time
+	until    time.Time
+	sent     int64
+	skipped  int64
+}
+
+func (r *replayer) match(key []byte, at time.Time) bool {
+	if (!r.since.IsZero() && at.Before(r.since)) || (!r.until.IsZero() && !at.Before(r.until)) {
+		return false
+	}
+	if len(r.prefixes) == 0 {
+		return true
+	}
+	for _, p := range r.prefixes {
+		if bytes.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *replayer) send(msg store.KeyEntry, at time.Time) error {
+	if !r.match(msg.Key, at) {
+		atomic.AddInt64(&r.skipped, 1)
+		return nil
+	}
+	if err := r.conn.Send(msg); err != nil {
+		return err
+	}
+	atomic.AddInt64(&r.sent, 1)
+	return nil
+}
+
+func runReplay(c *cli.Context) error {
+	conf, err := config.Load(c.String("config"), c.StringSlice("set"))
+	if err != nil {
+		fmt.Printf("init config failed, err: %s\n", err)
+		return err
+	}
+	r := &replayer{}
+	if t := c.Timestamp("since"); t != nil {
+		r.since = *t
+	}
+	if t := c.Timestamp("until"); t != nil {
+		r.until = *t
+	}
+	if !r.since.IsZero() && !r.until.IsZero() && !r.since.Before(r.until) {
+		return fmt.Errorf("since %s not before until %s", r.since, r.until)
+	}
+	for _, p := range c.StringSlice("prefix") {
+		p, err = unquote(p)
+		if err != nil {
+			fmt.Printf("unquote prefix, err: %s\n", err)
+			return err
+		}
+		prefix, err := server.EncodeMetaKey(p, c.IsSet("raw"))
+		if err != nil {
+			fmt.Printf("encode prefix, err: %s\n", err)
+			return err
+		}
+		r.prefixes = append(r.prefixes, prefix)
+	}
+	source := c.String("source")
+	switch source {
+	case file.Name:
+		if len(c.StringSlice("file")) == 0 {
+			return fmt.Errorf("file missing")
+		}
+	case kafka.MQ:
+		if len(c.StringSlice("broker-list")) == 0 || c.String("topic") == "" {
+			return fmt.Errorf("broker-list or topic missing")
+		}
+	default:
+		return fmt.Errorf("invalid source %s", source)
+	}
+
+	r.conn, err = store.NewConnector(conf)
+	if err != nil {
+		fmt.Printf("open connector %s failed, err: %s\n", conf.Connector.Name, err)
+		return err
+	}
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigterm)
+	go func() {
+		select {
+		case <-sigterm:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if source == file.Name {
+		err = replayFiles(ctx, r, c.StringSlice("file"))
+	} else {
+		version := c.String("kafka-version")
+		if version == "" {
+			version = conf.Connector.Version
+		}
+		err = replayKafka(ctx, r, &conf.Connector, c.StringSlice("broker-list"), c.String("topic"), version)
+	}
+	// the connector sends what it queued before it's closed
+	r.conn.Close()
+	fmt.Printf("sent %d, skipped %d\n", r.sent, r.skipped)
+	return err
+}
+
+func replayFiles(ctx context.Context, r *replayer, paths []string) error {
+	for _, path := range paths {
+		for _, name := range file.Files(path) {
+			f, err := os.Open(name)
+			if err != nil {
+				fmt.Printf("open %s failed, err: %s\n", name, err)
+				return err
+			}
+			err = file.ReadEvents(f, func(ev file.Event) error {
+				if ctx.Err() != nil {
+					return errReplayDone
+				}
+				return r.send(store.KeyEntry{Key: ev.Key, Entry: ev.Entry}, ev.Time)
+			})
+			f.Close()
+			if err == errReplayDone {
+				return nil
+			} else if err != nil {
+				fmt.Printf("replay %s failed, err: %s\n", name, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayKafka reads each partition from the first offset since the window
+// starts to the newest offset when it started, with the credentials of the
+// connector.
+func replayKafka(ctx context.Context, r *replayer, auth *config.Connector, brokers []string, topic, version string) error {
+	sarama.Logger = logrus.StandardLogger()
+	cf := sarama.NewConfig()
+	cf.ClientID = "tirest-replay"
+	kafka.SetAuth(cf, auth.SASLUser, auth.SASLPassword, auth.TLS)
+	var err error
+	cf.Version, err = sarama.ParseKafkaVersion(version)
+	if err != nil {
+		logrus.Errorf("Error parsing version: %v", err)
+		return err
+	}
+	client, err := sarama.NewClient(brokers, cf)
+	if err != nil {
+		fmt.Printf("init client failed, err: %s\n", err)
+		return err
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		fmt.Printf("init consumer failed, err: %s\n", err)
+		return err
+	}
+	defer consumer.Close()
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		fmt.Printf("get partitions of %s failed, err: %s\n", topic, err)
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(partitions))
+	for _, p := range partitions {
+		from := sarama.OffsetOldest
+		if !r.since.IsZero() {
+			from = r.since.UnixNano() / int64(time.Millisecond)
+		}
+		from, err = client.GetOffset(topic, p, from)
+		if err != nil {
+			fmt.Printf("get offset of partition %d failed, err: %s\n", p, err)
+			return err
+		}
+		newest, err := client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			fmt.Printf("get offset of partition %d failed, err: %s\n", p, err)
+			return err
+		}
+		if from < 0 || from >= newest {
+			continue
+		}
+		pc, err := consumer.ConsumePartition(topic, p, from)
+		if err != nil {
+			fmt.Printf("consume partition %d failed, err: %s\n", p, err)
+			return err
+		}
+		wg.Add(1)
+		go func(p int32, pc sarama.PartitionConsumer, newest int64) {
+			defer wg.Done()
+			defer pc.AsyncClose()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err, ok := <-pc.Errors():
+					if ok {
+						errCh <- err
+						cancel()
+					}
+					return
+				case m, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					if err := r.send(store.KeyEntry{Key: m.Key, Entry: m.Value}, m.Timestamp); err != nil {
+						errCh <- fmt.Errorf("partition %d offset %d, %s", p, m.Offset, err)
+						cancel()
+						return
+					}
+					if m.Offset >= newest-1 {
+						return
+					}
+				}
+			}
+		}(p, pc, newest)
+	}
+	wg.Wait()
+	select {
+	case err := <-errCh:
+		var perr *sarama.ConsumerError
+		if errors.As(err, &perr) {
+			fmt.Printf("partition %d, err: %s\n", perr.Partition, perr.Err)
+		} else {
+			fmt.Printf("replay failed, err: %s\n", err)
+		}
+		return err
+	default:
+		return nil
+	}
+}
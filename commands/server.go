@@ -2,7 +2,6 @@ package commands
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime"
@@ -24,7 +23,11 @@ func init() {
 			&cli.StringFlag{
 				Name:    "config",
 				Aliases: []string{"conf"},
-				Usage:   "server config",
+				Usage:   "server config, toml or yaml by the extension",
+			},
+			&cli.StringSliceFlag{
+				Name:  "set",
+				Usage: "override an option over the config and the TIREST_ variables, e.g. store.name=tikv, can be repeated",
 			},
 		},
 		Action: runServer,
@@ -33,49 +36,35 @@ func init() {
 
 func runServer(c *cli.Context) error {
 	configFile := c.String("config")
-	conf, err := config.InitConfig(configFile)
+	conf, err := config.Load(configFile, c.StringSlice("set"))
 	if err != nil {
 		logrus.Errorf("init config failed, err: %s", err)
 		return err
 	}
+	if errs := config.Check(configFile, conf); len(errs) > 0 {
+		for _, err := range errs {
+			logrus.Errorf("config %s", err)
+		}
+		return fmt.Errorf("%s has %d problems, see check-config", configFile, len(errs))
+	}
 	logrus.Infof("%s", conf)
 	maxProcs := runtime.GOMAXPROCS(0)
 	server.MaxProcs.Set(float64(maxProcs))
 	logrus.Infof("GOMAXPROCS: %d", maxProcs)
 
-	level, err := logrus.ParseLevel(conf.Log.Level)
+	logs, err := log.Init(&conf.Log)
 	if err != nil {
-		level = logrus.InfoLevel
-	}
-	//if level == logrus.DebugLevel {
-	//	logrus.SetReportCaller(true)
-	//}
-	logrus.SetLevel(level)
-	if conf.Log.ErrorLogDir != "" {
-		hook, err := log.NewRotatingOuter(
-			conf.Log.ErrorLogDir, conf.Log.BufferSize, conf.Log.MaxBytes, conf.Log.BackupCount,
-			&logrus.TextFormatter{
-				TimestampFormat: "2006-01-02 15:04:05",
-				FullTimestamp:   true,
-			})
-		if err != nil {
-			logrus.Errorf("init error log failed, err: %s", err)
-			return err
-		}
-		defer hook.Close()
-
-		logrus.SetEntryBufferDisable(true)
-		logrus.SetFormatter(log.NullFormatter{})
-		logrus.SetOutput(ioutil.Discard)
-		logrus.AddHook(hook)
-	} else {
-		logrus.SetOutput(os.Stderr)
+		logrus.Errorf("init log failed, err: %s", err)
+		return err
 	}
+	defer logs.Close()
 
 	if conf.Log.AccessLogDir == "std" {
-		err = middleware.InitLog("", conf.Log.BufferSize, conf.Log.MaxBytes, conf.Log.BackupCount)
+		err = middleware.InitLog("", conf.Log.BufferSize, conf.Log.MaxBytes, conf.Log.BackupCount,
+			conf.Log.RotateInterval.Duration)
 	} else if conf.Log.AccessLogDir != "" {
-		err = middleware.InitLog(conf.Log.AccessLogDir, conf.Log.BufferSize, conf.Log.MaxBytes, conf.Log.BackupCount)
+		err = middleware.InitLog(conf.Log.AccessLogDir, conf.Log.BufferSize, conf.Log.MaxBytes, conf.Log.BackupCount,
+			conf.Log.RotateInterval.Duration)
 		if err != nil {
 			logrus.Errorf("init access log failed, err: %s", err)
 			return err
@@ -90,9 +79,13 @@ func runServer(c *cli.Context) error {
 
 	go s.Start()
 	signalCh := make(chan os.Signal, 10)
-	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
 
 	sig := <-signalCh
+	for sig == syscall.SIGUSR1 {
+		s.ToggleLogLevel()
+		sig = <-signalCh
+	}
 	fmt.Printf("Received signal %s, clean up and exit...\n", sig)
 	fmt.Printf("stop api...\n")
 	s.Close()
@@ -103,13 +103,16 @@ func init() {
 }
 
 func getStore(c *cli.Context) (*store.Store, error) {
+	return openStore(c, c.String("config"))
+}
+
+func openStore(c *cli.Context, configFile string) (*store.Store, error) {
 	verbose := c.Uint("verbose")
 	if verbose > 5 {
 		return nil, errors.New("invalid verbose")
 	}
 	level := logrus.Level(verbose)
 	logrus.SetLevel(level)
-	configFile := c.String("config")
 	conf, err := config.InitConfig(configFile)
 	if err != nil {
 		fmt.Printf("init config failed, err: %s\n", err)
@@ -144,7 +147,7 @@ func runKVPut(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = s.UnsafePut(meta, utils.S2B(value))
+	err = s.UnsafePut(c.Context, meta, utils.S2B(value))
 	if err != nil {
 		fmt.Printf("put %s err: %s\n", meta, err)
 		return err
@@ -171,7 +174,7 @@ func runKVDelete(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	err = s.UnsafePut(meta, nil)
+	err = s.UnsafePut(c.Context, meta, nil)
 	if err != nil {
 		fmt.Printf("del %s err: %s\n", meta, err)
 		return err
@@ -208,7 +211,7 @@ func runKVGet(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	v, err := s.Get(meta, server.DefaultGetOption())
+	v, err := s.Get(c.Context, meta, server.DefaultGetOption())
 	if err != nil {
 		fmt.Printf("get %s err: %s\n", meta, err)
 		return err
@@ -254,7 +257,7 @@ func runKVList(c *cli.Context) error {
 	}
 	opt := server.DefaultListOption()
 	opt.Reverse = reverse
-	items, err := s.List(st, en, limit, opt)
+	items, err := s.List(c.Context, st, en, limit, opt)
 	if err != nil {
 		fmt.Printf("list %s-%s err: %s\n", st, en, err)
 		return err
@@ -0,0 +1,350 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/server"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/store/kafka"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const tailPollInterval = 200 * time.Millisecond
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "tail",
+		Usage: "print change events from the connector queue or the kafka topic",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "server config",
+				Value:   "./server.toml",
+			},
+			&cli.StringFlag{
+				Name:  "source",
+				Usage: "queue or kafka",
+				Value: "queue",
+			},
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "raw key",
+			},
+			&cli.StringSliceFlag{
+				Name:    "prefix",
+				Aliases: []string{"p"},
+				Usage:   "only print keys with the prefix, can be repeated",
+			},
+			&cli.BoolFlag{
+				Name:    "follow",
+				Aliases: []string{"f"},
+				Usage:   "wait for new events",
+			},
+			&cli.BoolFlag{
+				Name:    "oldest",
+				Aliases: []string{"o"},
+				Usage:   "kafka starts from the oldest offset, otherwise from the newest and follows",
+			},
+			&cli.IntFlag{
+				Name:    "limit",
+				Aliases: []string{"l"},
+				Usage:   "stop after printing limit events, 0 is unlimited",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print one json object per event",
+			},
+			&cli.BoolFlag{
+				Name:  "ordered",
+				Usage: "skip the events of a key overtaken by a later one, by the seq of server.sequence",
+			},
+		},
+		Action: runTail,
+	})
+}
+
+type tailEvent struct {
+	Source   string     `json:"source"`
+	Position string     `json:"position"`
+	Time     *time.Time `json:"time,omitempty"`
+	Key      string     `json:"key"`
+	Old      *string    `json:"old,omitempty"`
+	New      *string    `json:"new,omitempty"`
+	Patch    string     `json:"patch,omitempty"`
+	Entry    string     `json:"entry,omitempty"`
+	Seq      uint64     `json:"seq,omitempty"`
+}
+
+type tailer struct {
+	raw      bool
+	json     bool
+	limit    int
+	prefixes [][]byte
+	ordered  *store.SeqTracker
+	printed  int
+	mu       sync.Mutex
+}
+
+// print returns false once the limit is reached.
+func (t *tailer) print(ev tailEvent, msg store.KeyEntry) bool {
+	if !t.match(msg.Key) {
+		return true
+	}
+	key, err := server.DecodeMetaKey(msg.Key)
+	if err != nil {
+		key = msg.Key
+	}
+	if t.raw {
+		ev.Key = strconv.Quote(string(key))
+	} else {
+		ev.Key = base64.RawURLEncoding.EncodeToString(key)
+	}
+	l := store.Log{}
+	if err := json.Unmarshal(msg.Entry, &l); err == nil {
+		if t.ordered != nil && !t.ordered.Accept(msg.Key, l) {
+			return true
+		}
+		if l.Patch != nil {
+			ev.Patch, ev.Seq = string(l.Patch), l.Seq
+		} else {
+			ev.Old, ev.New, ev.Seq = &l.Old, &l.New, l.Seq
+		}
+	} else {
+		ev.Entry = string(msg.Entry)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limit > 0 && t.printed >= t.limit {
+		return false
+	}
+	t.printed++
+	if t.json {
+		b, _ := json.Marshal(ev)
+		fmt.Println(string(b))
+	} else {
+		header := fmt.Sprintf("[%s %s]", ev.Source, ev.Position)
+		if ev.Time != nil {
+			header = fmt.Sprintf("[%s %s %s]", ev.Source, ev.Position, ev.Time.Format(time.RFC3339Nano))
+		}
+		if ev.Seq != 0 {
+			header = fmt.Sprintf("%s seq=%d", header, ev.Seq)
+		}
+		fmt.Printf("%s key=%s\n", header, ev.Key)
+		if ev.Old != nil {
+			fmt.Printf("  old: %s\n  new: %s\n", *ev.Old, *ev.New)
+		} else if ev.Patch != "" {
+			fmt.Printf("  patch: %s\n", ev.Patch)
+		} else {
+			fmt.Printf("  entry: %q\n", ev.Entry)
+		}
+	}
+	return t.limit <= 0 || t.printed < t.limit
+}
+
+func (t *tailer) match(key []byte) bool {
+	if len(t.prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.prefixes {
+		if bytes.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func runTail(c *cli.Context) error {
+	conf, err := config.InitConfig(c.String("config"))
+	if err != nil {
+		fmt.Printf("init config failed, err: %s\n", err)
+		return err
+	}
+	t := &tailer{
+		raw:   c.IsSet("raw"),
+		json:  c.Bool("json"),
+		limit: c.Int("limit"),
+	}
+	if c.Bool("ordered") {
+		t.ordered = store.NewSeqTracker()
+	}
+	for _, p := range c.StringSlice("prefix") {
+		p, err = unquote(p)
+		if err != nil {
+			fmt.Printf("unquote prefix, err: %s\n", err)
+			return err
+		}
+		prefix, err := server.EncodeMetaKey(p, t.raw)
+		if err != nil {
+			fmt.Printf("encode prefix, err: %s\n", err)
+			return err
+		}
+		t.prefixes = append(t.prefixes, prefix)
+	}
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	sigterm := make(chan os.Signal, 1)
+	signal.Notify(sigterm, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigterm)
+	go func() {
+		select {
+		case <-sigterm:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	switch c.String("source") {
+	case "queue":
+		return tailQueue(ctx, conf, t, c.Bool("follow"))
+	case kafka.MQ:
+		return tailKafka(ctx, conf, t, c.Bool("oldest"))
+	default:
+		return fmt.Errorf("invalid source %s", c.String("source"))
+	}
+}
+
+// tailQueue starts at the read position of the queue, i.e. the events not
+// yet sent to kafka, and never updates the queue metadata.
+func tailQueue(ctx context.Context, conf *config.Config, t *tailer, follow bool) error {
+	dataPath := conf.Connector.QueueDataPath
+	meta, err := kafka.ReadQueueMeta(dataPath)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("read queue meta, err: %s\n", err)
+		return err
+	}
+	r := kafka.NewQueueReader(dataPath, meta.ReadFileNum, meta.ReadPos, conf.Connector.MaxMsgSize)
+	defer r.Close()
+
+	for {
+		fileNum, pos := r.Position()
+		body, err := r.Next()
+		if err == io.EOF {
+			if !follow {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(tailPollInterval):
+			}
+			continue
+		} else if err != nil {
+			fmt.Printf("read queue, err: %s\n", err)
+			return err
+		}
+		msg, err := kafka.DecodeMessage(body)
+		if err != nil {
+			fmt.Printf("queue %06d:%d, err: %s\n", fileNum, pos, err)
+			return err
+		}
+		ev := tailEvent{Source: "queue", Position: fmt.Sprintf("%06d:%d", fileNum, pos)}
+		if !t.print(ev, msg) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+func tailKafka(ctx context.Context, conf *config.Config, t *tailer, oldest bool) error {
+	sarama.Logger = logrus.StandardLogger()
+	cf := sarama.NewConfig()
+	cf.ClientID = "tirest-tail"
+	kafka.SetAuth(cf, conf.Connector.SASLUser, conf.Connector.SASLPassword, conf.Connector.TLS)
+	var err error
+	cf.Version, err = sarama.ParseKafkaVersion(conf.Connector.Version)
+	if err != nil {
+		logrus.Errorf("Error parsing version: %v", err)
+		return err
+	}
+	consumer, err := sarama.NewConsumer(conf.Connector.BrokerList, cf)
+	if err != nil {
+		fmt.Printf("init consumer failed, err: %s\n", err)
+		return err
+	}
+	defer consumer.Close()
+
+	topic := conf.Connector.Topic
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		fmt.Printf("get partitions of %s failed, err: %s\n", topic, err)
+		return err
+	}
+	offset := sarama.OffsetNewest
+	if oldest {
+		offset = sarama.OffsetOldest
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(partitions))
+	for _, p := range partitions {
+		pc, err := consumer.ConsumePartition(topic, p, offset)
+		if err != nil {
+			fmt.Printf("consume partition %d failed, err: %s\n", p, err)
+			return err
+		}
+		wg.Add(1)
+		go func(p int32, pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			defer pc.AsyncClose()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err, ok := <-pc.Errors():
+					if ok {
+						errCh <- err
+						cancel()
+					}
+					return
+				case m, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					ts := m.Timestamp
+					ev := tailEvent{
+						Source:   kafka.MQ,
+						Position: fmt.Sprintf("%d:%d", p, m.Offset),
+						Time:     &ts,
+					}
+					if !t.print(ev, store.KeyEntry{Key: m.Key, Entry: m.Value}) {
+						cancel()
+						return
+					}
+				}
+			}
+		}(p, pc)
+	}
+	wg.Wait()
+	select {
+	case err := <-errCh:
+		var perr *sarama.ConsumerError
+		if errors.As(err, &perr) {
+			fmt.Printf("partition %d, err: %s\n", perr.Partition, perr.Err)
+		}
+		return err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "config-upgrade",
+		Usage: "rename the old keys of a config to the current ones, keeping the comments of a toml config",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"conf"},
+				Usage:   "server config, toml or yaml by the extension",
+				Value:   "./server.toml",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "upgraded config path, the config itself if empty, which is kept as .bak",
+			},
+			&cli.BoolFlag{
+				Name:  "stdout",
+				Usage: "print the upgraded config instead of writing it",
+			},
+		},
+		Action: runConfigUpgrade,
+	})
+}
+
+func runConfigUpgrade(c *cli.Context) error {
+	configFile := c.String("config")
+	data, changes, err := config.UpgradeFile(configFile)
+	if err != nil {
+		return fmt.Errorf("upgrade %s, %s", configFile, err)
+	}
+	for _, change := range changes {
+		fmt.Fprintf(os.Stderr, "%s\n", change)
+	}
+	if c.Bool("stdout") {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(os.Stderr, "%s is up to date\n", configFile)
+		return nil
+	}
+	output := c.String("output")
+	if output == "" {
+		output = configFile
+		old, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(configFile+".bak", old, 0644); err != nil {
+			return err
+		}
+	}
+	if err = ioutil.WriteFile(output, data, 0644); err != nil {
+		return err
+	}
+	keys, err := config.UndecodedKeys(output)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		fmt.Fprintf(os.Stderr, "%s: unknown key, removed or misspelled\n", k)
+	}
+	fmt.Fprintf(os.Stderr, "%s upgraded, %d changes\n", output, len(changes))
+	return nil
+}
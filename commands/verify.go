@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+
+	"github.com/huangnauh/tirest/server"
+	"github.com/huangnauh/tirest/store"
+	"github.com/urfave/cli/v2"
+)
+
+func init() {
+	registerCommand(&cli.Command{
+		Name:  "verify",
+		Usage: "compare a key range between two stores, e.g. the primary and the secondary cluster",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "source server config",
+				Value:   "./server.toml",
+			},
+			&cli.StringFlag{
+				Name:     "target",
+				Aliases:  []string{"t"},
+				Usage:    "target server config",
+				Required: true,
+			},
+			&cli.BoolFlag{
+				Name:  "raw",
+				Usage: "raw key",
+			},
+			&cli.UintFlag{
+				Name:    "verbose",
+				Aliases: []string{"vb"},
+				Usage:   "verbose info(2 error, 3 warn, 4 info, 5 debug)",
+				Value:   2,
+			},
+			&cli.StringFlag{
+				Name:    "start",
+				Aliases: []string{"s"},
+				Usage:   "start, all meta keys by default",
+			},
+			&cli.StringFlag{
+				Name:    "end",
+				Aliases: []string{"e"},
+				Usage:   "end, all meta keys by default",
+			},
+			&cli.IntFlag{
+				Name:  "batch",
+				Usage: "keys scanned in a batch",
+				Value: 1000,
+			},
+			&cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "ranges compared in parallel",
+				Value: 4,
+			},
+			&cli.BoolFlag{
+				Name:  "repair",
+				Usage: "make the target match the source",
+			},
+		},
+		Action: runVerify,
+	})
+}
+
+// rangeScanner walks a range in batches, keys are decoded meta keys.
+type rangeScanner struct {
+	s     *store.Store
+	end   []byte
+	batch int
+	next  []byte
+	buf   []store.KeyValue
+	done  bool
+}
+
+func (r *rangeScanner) peek(ctx context.Context) (*store.KeyValue, error) {
+	if len(r.buf) == 0 && !r.done {
+		items, err := r.s.List(ctx, r.next, r.end, r.batch, server.DefaultListOption())
+		if err != nil {
+			return nil, err
+		}
+		r.buf = items
+		if len(items) < r.batch {
+			r.done = true
+		} else {
+			last, _ := server.EncodeMetaKey(items[len(items)-1].Key, true)
+			r.next = append(last, 0)
+		}
+	}
+	if len(r.buf) == 0 {
+		return nil, nil
+	}
+	return &r.buf[0], nil
+}
+
+func (r *rangeScanner) pop() {
+	r.buf = r.buf[1:]
+}
+
+type verifyResult struct {
+	keys       int
+	missing    int
+	extra      int
+	mismatched int
+	repaired   int
+}
+
+type verifier struct {
+	source *store.Store
+	target *store.Store
+	batch  int
+	repair bool
+	mu     sync.Mutex
+	result verifyResult
+}
+
+func (v *verifier) report(kind string, key string) {
+	fmt.Printf("%s %q\n", kind, key)
+}
+
+func (v *verifier) fix(ctx context.Context, r *verifyResult, key string, val []byte) error {
+	if !v.repair {
+		return nil
+	}
+	k, _ := server.EncodeMetaKey(key, true)
+	err := v.target.UnsafePut(ctx, k, val)
+	if err != nil {
+		return err
+	}
+	r.repaired++
+	return nil
+}
+
+// compare merges the sorted scans of both stores.
+func (v *verifier) compare(ctx context.Context, start, end []byte) error {
+	src := &rangeScanner{s: v.source, end: end, batch: v.batch, next: start}
+	dst := &rangeScanner{s: v.target, end: end, batch: v.batch, next: start}
+	srcSum, dstSum := crc32.NewIEEE(), crc32.NewIEEE()
+	var r verifyResult
+	defer func() {
+		v.mu.Lock()
+		v.result.keys += r.keys
+		v.result.missing += r.missing
+		v.result.extra += r.extra
+		v.result.mismatched += r.mismatched
+		v.result.repaired += r.repaired
+		v.mu.Unlock()
+	}()
+
+	for {
+		a, err := src.peek(ctx)
+		if err != nil {
+			return err
+		}
+		b, err := dst.peek(ctx)
+		if err != nil {
+			return err
+		}
+		if a == nil && b == nil {
+			break
+		}
+
+		switch {
+		case b == nil || (a != nil && a.Key < b.Key):
+			r.keys++
+			r.missing++
+			srcSum.Write([]byte(a.Key + a.Value))
+			v.report("missing", a.Key)
+			err = v.fix(ctx, &r, a.Key, []byte(a.Value))
+			src.pop()
+		case a == nil || b.Key < a.Key:
+			r.extra++
+			dstSum.Write([]byte(b.Key + b.Value))
+			v.report("extra", b.Key)
+			err = v.fix(ctx, &r, b.Key, nil)
+			dst.pop()
+		default:
+			r.keys++
+			srcSum.Write([]byte(a.Key + a.Value))
+			dstSum.Write([]byte(b.Key + b.Value))
+			if a.Value != b.Value {
+				r.mismatched++
+				v.report("mismatch", a.Key)
+				err = v.fix(ctx, &r, a.Key, []byte(a.Value))
+			}
+			src.pop()
+			dst.pop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if srcSum.Sum32() != dstSum.Sum32() {
+		fmt.Printf("range %q-%q checksum %08x != %08x\n", start[1:], end[1:], srcSum.Sum32(), dstSum.Sum32())
+	}
+	return nil
+}
+
+func runVerify(c *cli.Context) error {
+	raw := c.IsSet("raw")
+	st, err := server.EncodeMetaKey(c.String("start"), raw)
+	if err != nil {
+		return err
+	}
+	en := []byte{server.MetaType + 1}
+	if c.String("end") != "" {
+		en, err = server.EncodeMetaKey(c.String("end"), raw)
+		if err != nil {
+			return err
+		}
+	}
+	if bytes.Compare(st, en) >= 0 {
+		return errors.New("start must be less than end")
+	}
+	concurrency := c.Int("concurrency")
+	if concurrency <= 0 || c.Int("batch") <= 0 {
+		return errors.New("batch and concurrency must be positive")
+	}
+
+	source, err := getStore(c)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := openStore(c, c.String("target"))
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	v := &verifier{source: source, target: target, batch: c.Int("batch"), repair: c.Bool("repair")}
+	bounds := append([][]byte{st}, store.SplitKeys(st, en, concurrency*4)...)
+	bounds = append(bounds, en)
+
+	ranges := make(chan int)
+	errs := make(chan error, len(bounds))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ranges {
+				if err := v.compare(c.Context, bounds[i], bounds[i+1]); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for i := 0; i < len(bounds)-1; i++ {
+		ranges <- i
+	}
+	close(ranges)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		fmt.Printf("verify err: %s\n", err)
+		return err
+	}
+
+	r := v.result
+	fmt.Printf("keys %d, missing %d, extra %d, mismatched %d, repaired %d\n",
+		r.keys, r.missing, r.extra, r.mismatched, r.repaired)
+	if diff := r.missing + r.extra + r.mismatched; diff > r.repaired {
+		return fmt.Errorf("found %d differences", diff-r.repaired)
+	}
+	return nil
+}
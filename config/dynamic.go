@@ -0,0 +1,62 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DynamicSections are the sections which may be kept in the store, with
+// their keys applied at runtime, the others, e.g. enable, are left to the
+// file.
+var DynamicSections = map[string][]string{
+	"acl":      {"default-deny", "rule"},
+	"firewall": {"allow", "deny", "network", "default"},
+	"server":   {"mode"},
+}
+
+// Overlay decodes data, the json of a dynamic section, over the section
+// of c, the keys it has replace those of c. The other keys, e.g. the
+// updated_at of the timestamp check, are ignored.
+func (c *Config) Overlay(section string, data []byte) error {
+	keys, ok := DynamicSections[section]
+	if !ok {
+		return fmt.Errorf("%s: not a dynamic section", section)
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("%s: %s", section, err)
+	}
+	v, _ := fieldOf(reflect.ValueOf(c).Elem(), section)
+	fragment := make(map[string]interface{})
+	for _, k := range keys {
+		if item, ok := doc[k]; ok {
+			fragment[k] = item
+			// toml merges a list of tables into the one decoded over
+			f, _ := fieldOf(v, k)
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	m, err := tomlValue(section, fragment, v.Type())
+	if err != nil {
+		return err
+	}
+	wrapped := map[string]interface{}{section: m}
+	var b strings.Builder
+	if err = toml.NewEncoder(&b).Encode(wrapped); err != nil {
+		return fmt.Errorf("%s: %s", section, err)
+	}
+	if _, err = toml.Decode(b.String(), c); err != nil {
+		if located := locate("", wrapped, reflect.ValueOf(c).Elem()); located != nil {
+			return located
+		}
+		return fmt.Errorf("%s: %s", section, err)
+	}
+	return nil
+}
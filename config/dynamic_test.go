@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverlay(t *testing.T) {
+	conf := DefaultConfig()
+	conf.ACL.Enable = true
+	conf.ACL.Rules = []ACLRule{{Token: "file", IP: "10.0.0.1", Prefix: "a/", Permission: "rw"}}
+	assert.Nil(t, conf.Overlay("acl", []byte(`{"rule":[{"token":"store","prefix":"b/","permission":"r"}],
+		"enable":false,"updated_at":1}`)))
+	// the rule replaces the one of the file, enable is left to the file
+	assert.Equal(t, []ACLRule{{Token: "store", Prefix: "b/", Permission: "r"}}, conf.ACL.Rules)
+	assert.True(t, conf.ACL.Enable)
+	assert.False(t, conf.ACL.DefaultDeny)
+
+	assert.Nil(t, conf.Overlay("firewall", []byte(`{"network":[{"name":"internal","cidrs":["10.0.0.0/8"],"rate":100,"burst":10}],
+		"default":"internal"}`)))
+	assert.Equal(t, []Network{{Name: "internal", CIDRs: []string{"10.0.0.0/8"}, Rate: 100, Burst: 10}}, conf.Firewall.Networks)
+	assert.Nil(t, conf.Overlay("server", []byte(`{"mode":"read-only","http-port":1}`)))
+	assert.Equal(t, "read-only", conf.Server.Mode)
+	assert.Equal(t, 6100, conf.Server.HttpPort)
+
+	assert.EqualError(t, conf.Overlay("store", []byte(`{}`)), "store: not a dynamic section")
+	assert.EqualError(t, conf.Overlay("acl", []byte(`[]`)),
+		"acl: json: cannot unmarshal array into Go value of type map[string]interface {}")
+	assert.EqualError(t, conf.Overlay("acl", []byte(`{"default-deny":"yes"}`)),
+		"acl.default-deny: cannot load TOML value of type string into a Go boolean")
+}
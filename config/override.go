@@ -0,0 +1,284 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix starts the variables overriding the config, the toml key
+// follows in upper case with _ for . and -, e.g. TIREST_STORE_NAME.
+const EnvPrefix = "TIREST_"
+
+// IsYAML is true for the .yaml and .yml files, the others are toml.
+func IsYAML(configFile string) bool {
+	ext := strings.ToLower(filepath.Ext(configFile))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readFile returns the config as toml, a yaml config has the same keys
+// and is turned into toml, so the toml tags are the only schema.
+func readFile(configFile string) (string, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return "", err
+	}
+	if !IsYAML(configFile) {
+		return string(data), nil
+	}
+	var doc map[interface{}]interface{}
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	m, err := tomlValue("", doc, reflect.TypeOf(Config{}))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if m != nil {
+		if err = toml.NewEncoder(&b).Encode(m); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// tomlValue turns the maps of yaml, keyed by anything, into string keyed
+// ones the toml encoder takes, and the numbers of json into ints or
+// floats. yaml and json have 1 for 1.0, an int is made a float when t, the
+// type it's loaded into, is a float, toml doesn't load an int into a float.
+func tomlValue(field string, v interface{}, t reflect.Type) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = item
+		}
+		return tomlValue(field, m, t)
+	case map[string]interface{}:
+		var st reflect.Value
+		if t != nil && t.Kind() == reflect.Struct {
+			st = reflect.New(t).Elem()
+		}
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			name := key
+			if field != "" {
+				name = field + "." + key
+			}
+			var ft reflect.Type
+			if st.IsValid() {
+				if f, ok := fieldOf(st, key); ok {
+					ft = f.Type()
+				}
+			}
+			value, err := tomlValue(name, item, ft)
+			if err != nil {
+				return nil, err
+			}
+			// toml has no null, the default is kept
+			if value != nil {
+				m[key] = value
+			}
+		}
+		return m, nil
+	case []interface{}:
+		var et reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			et = t.Elem()
+		}
+		list := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			value, err := tomlValue(fmt.Sprintf("%s[%d]", field, i), item, et)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return nil, fmt.Errorf("%s[%d]: null in a list", field, i)
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil && !isFloat(t) {
+			return n, nil
+		}
+		return v.Float64()
+	case int:
+		if isFloat(t) {
+			return float64(v), nil
+		}
+	}
+	return v, nil
+}
+
+func isFloat(t reflect.Type) bool {
+	return t != nil && (t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64)
+}
+
+// fields maps the dotted toml key of each option to its field, the lists
+// of tables, e.g. [[tier]], are left to the file.
+func fields(prefix string, v reflect.Value, m map[string]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		f := v.Field(i)
+		if _, ok := f.Interface().(*Duration); ok {
+			m[name] = f
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Struct:
+			fields(name, f, m)
+		case reflect.Slice:
+			if f.Type().Elem().Kind() == reflect.String {
+				m[name] = f
+			}
+		default:
+			m[name] = f
+		}
+	}
+}
+
+func (c *Config) fields() map[string]reflect.Value {
+	m := make(map[string]reflect.Value)
+	fields("", reflect.ValueOf(c).Elem(), m)
+	return m
+}
+
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+func setField(f reflect.Value, value string) error {
+	if _, ok := f.Interface().(*Duration); ok {
+		d := &Duration{}
+		if err := d.UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		// a list is comma separated, empty for none
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
+// Set overrides the option of a dotted toml key, e.g. store.name, like
+// the --set flag.
+func (c *Config) Set(key, value string) error {
+	f, ok := c.fields()[key]
+	if !ok {
+		return fmt.Errorf("%s: unknown key", key)
+	}
+	if err := setField(f, value); err != nil {
+		return fmt.Errorf("%s: invalid %q, %s", key, value, err)
+	}
+	return nil
+}
+
+// ApplyEnv overrides the options by the TIREST_ variables of environ,
+// the variables matching no option are returned.
+func (c *Config) ApplyEnv(environ []string) ([]string, error) {
+	names := make(map[string]reflect.Value)
+	keys := make(map[string]string)
+	for key, f := range c.fields() {
+		names[envName(key)] = f
+		keys[envName(key)] = key
+	}
+	unknown := make([]string, 0)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			continue
+		}
+		name, value := kv[:i], kv[i+1:]
+		f, ok := names[name]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if err := setField(f, value); err != nil {
+			return unknown, fmt.Errorf("%s (%s): invalid %q, %s", name, keys[name], value, err)
+		}
+	}
+	return unknown, nil
+}
+
+// Load reads configFile, then applies the TIREST_ variables and then
+// sets, key=value pairs of the --set flag, each one over the previous.
+// The secret references of all of them are resolved last.
+func Load(configFile string, sets []string) (*Config, error) {
+	conf, err := readConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range sets {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("--set %q is not key=value", kv)
+		}
+		if err = conf.Set(kv[:i], kv[i+1:]); err != nil {
+			return nil, err
+		}
+	}
+	if err = conf.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// UnknownEnv lists the TIREST_ variables of the environment which match
+// no option, usually a typo.
+func UnknownEnv() []string {
+	unknown, _ := DefaultConfig().ApplyEnv(os.Environ())
+	return unknown
+}
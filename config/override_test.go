@@ -0,0 +1,79 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testYAML = `
+store:
+  name: newtikv
+  pd-address: ["10.0.0.1:2379", "10.0.0.2:2379"]
+  read-timeout: 3s
+server:
+  http-port: 7100
+  max-value-size: 1024
+  typo: 1
+tier:
+  - prefix: hot/
+    name: etcd
+    path: etcd://10.0.0.3:2379
+breaker:
+  error-rate: 1
+log:
+  syslog: null
+`
+
+func TestYAMLAndOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "server.yaml")
+	assert.Nil(t, ioutil.WriteFile(file, []byte(testYAML), 0644))
+
+	conf := DefaultConfig()
+	assert.Nil(t, conf.ReadFromFile(file))
+	assert.Equal(t, "newtikv", conf.Store.Name)
+	assert.Equal(t, []string{"10.0.0.1:2379", "10.0.0.2:2379"}, conf.Store.PdAddresses)
+	assert.Equal(t, 3*time.Second, conf.Store.ReadTimeout.Duration)
+	assert.Equal(t, 7100, conf.Server.HttpPort)
+	assert.Equal(t, int64(1024), conf.Server.MaxValueSize)
+	assert.Equal(t, 1.0, conf.Breaker.ErrorRate)
+	assert.Equal(t, "hot/", conf.Tiers[0].Prefix)
+	assert.Equal(t, "etcd", conf.Tiers[0].Name)
+	keys, err := UndecodedKeys(file)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"server.typo"}, keys)
+
+	// the variables go over the file, --set over both
+	unknown, err := conf.ApplyEnv([]string{"TIREST_STORE_NAME=tikv", "TIREST_SERVER_HTTP_PORT=8100",
+		"TIREST_STORE_PD_ADDRESS=10.0.0.4:2379, 10.0.0.5:2379", "TIREST_LOG_BACKUP_COUNT=3",
+		"TIREST_AUTH_LDAP_TIMEOUT=1s", "TIREST_STORE_NAM=typo", "HOME=/root"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"TIREST_STORE_NAM"}, unknown)
+	assert.Equal(t, "tikv", conf.Store.Name)
+	assert.Equal(t, 8100, conf.Server.HttpPort)
+	assert.Equal(t, []string{"10.0.0.4:2379", "10.0.0.5:2379"}, conf.Store.PdAddresses)
+	assert.Equal(t, 3, conf.Log.BackupCount)
+	assert.Equal(t, time.Second, conf.Auth.LDAP.Timeout.Duration)
+	_, err = conf.ApplyEnv([]string{"TIREST_SERVER_HLC=maybe"})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, conf.Set("store.name", "etcd"))
+	assert.Nil(t, conf.Set("breaker.error-rate", "0.2"))
+	assert.Equal(t, "etcd", conf.Store.Name)
+	assert.Equal(t, 0.2, conf.Breaker.ErrorRate)
+	assert.EqualError(t, conf.Set("store.nam", "x"), "store.nam: unknown key")
+
+	// init writes yaml too
+	out := filepath.Join(dir, "init.yml")
+	assert.Nil(t, Save(DefaultConfig(), out))
+	saved := &Config{}
+	assert.Nil(t, saved.ReadFromFile(out))
+	assert.Equal(t, DefaultConfig().Server, saved.Server)
+}
@@ -0,0 +1,37 @@
+package config
+
+import "fmt"
+
+// Profiles are the configs init starts from besides the defaults: dev runs
+// on one machine without kafka, prod listens on every address with the
+// admin routes on a port of their own and the breaker and the reconnect
+// on, and replica is a prod proxy of a cluster which applies the changes
+// of another one with `consume --apply`, so both have the hlc.
+var Profiles = []string{"dev", "prod", "replica"}
+
+// NewProfile returns the config of a profile, the defaults for "".
+func NewProfile(name string) (*Config, error) {
+	c := DefaultConfig()
+	switch name {
+	case "":
+	case "dev":
+		c.Server.HttpHost = "127.0.0.1"
+		c.Connector.EnableProducer = false
+		c.Log.Level = "debug"
+	case "prod", "replica":
+		c.Server.HttpHost = "0.0.0.0"
+		c.Admin.HttpHost = "127.0.0.1"
+		c.Admin.HttpPort = c.Server.HttpPort + 1
+		c.Breaker.Enable = true
+		c.Reconnect.Enable = true
+		c.Log.Format = "json"
+		c.Log.AccessLogDir = "std"
+		if name == "replica" {
+			c.Server.HLC = true
+			c.Replica.BrokerList = []string{"127.0.0.1:9092"}
+		}
+	default:
+		return nil, fmt.Errorf("unknown profile %q, not one of %v", name, Profiles)
+	}
+	return c, nil
+}
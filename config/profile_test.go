@@ -0,0 +1,29 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range append([]string{""}, Profiles...) {
+		conf, err := NewProfile(name)
+		assert.Nil(t, err)
+		conf.Connector.BrokerList = []string{"127.0.0.1:9092"}
+		conf.Connector.QueueDataPath = dir
+		assert.Empty(t, conf.Validate(), name)
+	}
+	conf, _ := NewProfile("replica")
+	assert.True(t, conf.Server.HLC)
+	assert.Equal(t, 6101, conf.Admin.HttpPort)
+	conf, _ = NewProfile("dev")
+	assert.False(t, conf.Connector.EnableProducer)
+	_, err = NewProfile("staging")
+	assert.EqualError(t, err, `unknown profile "staging", not one of [dev prod replica]`)
+}
@@ -0,0 +1,265 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The secret references a string option may be given as, resolved when
+// the config is loaded: file://path has the secret in a file, e.g. a
+// mounted kubernetes secret, env://NAME in a variable, and
+// vault://path#field in a vault kv secret read with VAULT_ADDR and
+// VAULT_TOKEN, e.g. vault://secret/data/tirest#redis-password.
+const (
+	FileRef  = "file://"
+	EnvRef   = "env://"
+	VaultRef = "vault://"
+)
+
+// VaultTimeout bounds each read of vault.
+var VaultTimeout = 10 * time.Second
+
+func isRef(s string) bool {
+	return strings.HasPrefix(s, FileRef) || strings.HasPrefix(s, EnvRef) || strings.HasPrefix(s, VaultRef)
+}
+
+// resolver reads each vault secret once per load.
+type resolver struct {
+	vault map[string]map[string]interface{}
+}
+
+func (r *resolver) resolve(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, FileRef):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(ref, FileRef))
+		if err != nil {
+			return "", err
+		}
+		// the editors and kubectl leave a newline
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(ref, EnvRef):
+		v, ok := os.LookupEnv(strings.TrimPrefix(ref, EnvRef))
+		if !ok {
+			return "", fmt.Errorf("%s is not set", strings.TrimPrefix(ref, EnvRef))
+		}
+		return v, nil
+	}
+	path := strings.TrimPrefix(ref, VaultRef)
+	i := strings.LastIndexByte(path, '#')
+	if i < 0 {
+		return "", fmt.Errorf("no #field")
+	}
+	path, name := strings.Trim(path[:i], "/"), path[i+1:]
+	data, ok := r.vault[path]
+	if !ok {
+		var err error
+		if data, err = readVault(path); err != nil {
+			return "", err
+		}
+		r.vault[path] = data
+	}
+	switch v := data[name].(type) {
+	case nil:
+		return "", fmt.Errorf("no field %q", name)
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("field %q is not a string", name)
+	}
+}
+
+// readVault reads a secret of the kv engine, the data of version 2 is
+// nested under data.
+func readVault(path string) (map[string]interface{}, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := (&http.Client{Timeout: VaultTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault %s", resp.Status)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err = dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	if nested, ok := body.Data["data"].(map[string]interface{}); ok && body.Data["metadata"] != nil {
+		return nested, nil
+	}
+	return body.Data, nil
+}
+
+// ResolveSecrets replaces the references of every string option by their
+// secret, the references are kept for String, so the log has no secret.
+func (c *Config) ResolveSecrets() error {
+	r := &resolver{vault: make(map[string]map[string]interface{})}
+	return resolveValue(r, "", reflect.ValueOf(c).Elem(), func(ref, secret string) {
+		if c.secrets == nil {
+			c.secrets = make(map[string]string)
+		}
+		c.secrets[secret] = ref
+	})
+}
+
+func resolveValue(r *resolver, name string, v reflect.Value, found func(ref, secret string)) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !isRef(v.String()) {
+			return nil
+		}
+		ref := v.String()
+		secret, err := r.resolve(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %s, %s", name, ref, err)
+		}
+		v.SetString(secret)
+		found(ref, secret)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return resolveValue(r, name, v.Elem(), found)
+		}
+	case reflect.Map:
+		// the values of a map can't be set in place
+		for _, k := range v.MapKeys() {
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(k))
+			if err := resolveValue(r, fmt.Sprintf("%s.%v", name, k), e, found); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveValue(r, fmt.Sprintf("%s[%d]", name, i), v.Index(i), found); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			key := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+			if key == "-" {
+				continue
+			}
+			field := name
+			if key != "" {
+				if field != "" {
+					field += "."
+				}
+				field += key
+			}
+			if err := resolveValue(r, field, v.Field(i), found); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Redact puts the references back for the secrets quoted in text, e.g.
+// the config encoded as json or toml.
+func (c *Config) Redact(text string) string {
+	for secret, ref := range c.secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, strconv.Quote(secret), strconv.Quote(ref))
+		}
+	}
+	return text
+}
+
+// SecretMask replaces the secrets in Masked.
+const SecretMask = "******"
+
+// Masked returns a copy of the config with the options tagged secret
+// masked, to be served by the admin api.
+func (c *Config) Masked() *Config {
+	m := maskValue(reflect.ValueOf(c).Elem(), false).Interface().(Config)
+	return &m
+}
+
+// Copy returns a deep copy of the config, the slices, maps and pointers
+// of the copy are its own.
+func (c *Config) Copy() *Config {
+	m := maskValue(reflect.ValueOf(c).Elem(), false).Interface().(Config)
+	m.secrets = c.secrets
+	return &m
+}
+
+// maskValue deep copies v, with its strings masked if secret.
+func maskValue(v reflect.Value, secret bool) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		if secret && v.String() != "" {
+			s := reflect.New(v.Type()).Elem()
+			s.SetString(SecretMask)
+			return s
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(maskValue(v.Elem(), secret))
+		return p
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(maskValue(v.Index(i), secret))
+		}
+		return s
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			m.SetMapIndex(k, maskValue(v.MapIndex(k), secret))
+		}
+		return m
+	case reflect.Struct:
+		s := reflect.New(v.Type()).Elem()
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			// the unexported fields, e.g. the references, are left out
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			s.Field(i).Set(maskValue(v.Field(i), secret || t.Field(i).Tag.Get("secret") == "true"))
+		}
+		return s
+	}
+	return v
+}
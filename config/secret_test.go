@@ -0,0 +1,120 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("admin-token\n"), 0600))
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/tirest":
+			w.Write([]byte(`{"data":{"data":{"redis-password":"hunter2","port":6379},"metadata":{"version":1}}}`))
+		case "/v1/kv/tirest":
+			w.Write([]byte(`{"data":{"secret-key":"0123456789abcdef"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+	os.Setenv("VAULT_ADDR", vault.URL)
+	os.Setenv("VAULT_TOKEN", "root")
+	os.Setenv("TEST_PEERS_TOKEN", "gossip")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+	defer os.Unsetenv("TEST_PEERS_TOKEN")
+
+	conf := DefaultConfig()
+	conf.Admin.Tokens = []string{FileRef + tokenFile, "plain"}
+	conf.Peers.Token = EnvRef + "TEST_PEERS_TOKEN"
+	conf.Cache.RedisPassword = VaultRef + "secret/data/tirest#redis-password"
+	conf.Auth.HMAC.Keys = []HMACKey{{AccessKey: "app", SecretKey: VaultRef + "kv/tirest#secret-key"}}
+	conf.Connector.SASLUser = "tirest"
+	conf.Connector.SASLPassword = FileRef + tokenFile
+	assert.Nil(t, conf.ResolveSecrets())
+	assert.Equal(t, "admin-token", conf.Connector.SASLPassword)
+	assert.Equal(t, []string{"admin-token", "plain"}, conf.Admin.Tokens)
+	assert.Equal(t, "gossip", conf.Peers.Token)
+	assert.Equal(t, "hunter2", conf.Cache.RedisPassword)
+	assert.Equal(t, "0123456789abcdef", conf.Auth.HMAC.Keys[0].SecretKey)
+
+	// the log has the references
+	s := conf.String()
+	assert.NotContains(t, s, "hunter2")
+	assert.NotContains(t, s, "admin-token")
+	assert.Contains(t, s, `"vault://secret/data/tirest#redis-password"`)
+	assert.Contains(t, s, `"plain"`)
+
+	for ref, msg := range map[string]string{
+		EnvRef + "TEST_MISSING":              "peers.token: env://TEST_MISSING, TEST_MISSING is not set",
+		FileRef + filepath.Join(dir, "none"): "peers.token: file://" + dir + "/none, open " + dir + "/none: no such file or directory",
+		VaultRef + "secret/data/tirest":      "peers.token: vault://secret/data/tirest, no #field",
+		VaultRef + "secret/data/tirest#user": "peers.token: vault://secret/data/tirest#user, no field \"user\"",
+		VaultRef + "secret/data/other#user":  "peers.token: vault://secret/data/other#user, vault 404 Not Found",
+	} {
+		conf = DefaultConfig()
+		conf.Peers.Token = ref
+		assert.EqualError(t, conf.ResolveSecrets(), msg)
+	}
+
+	// the options behind a pointer or in a map
+	var opts struct {
+		Token *string           `toml:"token"`
+		Users map[string]string `toml:"users"`
+	}
+	token := EnvRef + "TEST_PEERS_TOKEN"
+	opts.Token = &token
+	opts.Users = map[string]string{"app": VaultRef + "secret/data/tirest#redis-password", "plain": "p"}
+	var refs []string
+	r := &resolver{vault: make(map[string]map[string]interface{})}
+	assert.Nil(t, resolveValue(r, "", reflect.ValueOf(&opts).Elem(), func(ref, secret string) {
+		refs = append(refs, ref)
+	}))
+	assert.Equal(t, "gossip", *opts.Token)
+	assert.Equal(t, map[string]string{"app": "hunter2", "plain": "p"}, opts.Users)
+	assert.Len(t, refs, 2)
+	opts.Users["app"] = EnvRef + "TEST_MISSING"
+	assert.EqualError(t, resolveValue(r, "", reflect.ValueOf(&opts).Elem(), func(ref, secret string) {}),
+		"users.app: env://TEST_MISSING, TEST_MISSING is not set")
+}
+
+func TestMasked(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Admin.Tokens = []string{"admin"}
+	conf.Peers.Token = "gossip"
+	conf.Cache.RedisPassword = ""
+	conf.ACL.Rules = []ACLRule{{Token: "store", Prefix: "b/"}}
+	conf.Encryption.Keys = []EncryptionKey{{ID: "k1", Key: "MDEyMzQ1Njc4OWFiY2RlZg=="}}
+	conf.Connector.SASLUser = "tirest"
+	conf.Connector.SASLPassword = "kafka-secret"
+	m := conf.Masked()
+	assert.Equal(t, SecretMask, m.Connector.SASLUser)
+	assert.Equal(t, SecretMask, m.Connector.SASLPassword)
+	assert.Equal(t, []string{SecretMask}, m.Admin.Tokens)
+	assert.Equal(t, SecretMask, m.Peers.Token)
+	assert.Equal(t, "", m.Cache.RedisPassword)
+	assert.Equal(t, SecretMask, m.ACL.Rules[0].Token)
+	assert.Equal(t, "b/", m.ACL.Rules[0].Prefix)
+	assert.Equal(t, []EncryptionKey{{ID: "k1", Key: SecretMask}}, m.Encryption.Keys)
+	// the config itself is left alone
+	assert.Equal(t, []string{"admin"}, conf.Admin.Tokens)
+	assert.Equal(t, "store", conf.ACL.Rules[0].Token)
+	assert.Equal(t, "MDEyMzQ1Njc4OWFiY2RlZg==", conf.Encryption.Keys[0].Key)
+}
@@ -1,14 +1,17 @@
 package config
 
 import (
-	"io/ioutil"
+	"bytes"
+	"io"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
 	"github.com/huangnauh/tirest/utils/json"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
 type Duration struct {
@@ -21,8 +24,11 @@ type Connector struct {
 	EnableProducer  bool      `toml:"enable-producer"`
 	DebugProducer   bool      `toml:"debug-producer"`
 	BrokerList      []string  `toml:"broker-list"`
+	SASLUser        string    `toml:"sasl-user" secret:"true"`
+	SASLPassword    string    `toml:"sasl-password" secret:"true"`
+	TLS             bool      `toml:"tls"`
 	FetchMetadata   bool      `toml:"fetch-metadata"`
-	Retry           int       `toml:"entry"`
+	Retry           int       `toml:"retry"`
 	BackOff         *Duration `toml:"back-off"`
 	MaxBackOff      *Duration `toml:"max-back-off"`
 	Topic           string    `toml:"topic"`
@@ -34,6 +40,50 @@ type Connector struct {
 	SyncTimeout     *Duration `toml:"sync-timeout"`
 	MaxMsgSize      int32     `toml:"max-msg-size"`
 	WriteTimeout    *Duration `toml:"write-timeout"`
+	BatchSize       int       `toml:"batch-size"`
+	BatchTimeout    *Duration `toml:"batch-timeout"`
+	ReplayRetention *Duration `toml:"replay-retention"`
+	MaxLag          *Duration `toml:"max-lag"`
+	MaxDepth        int64     `toml:"max-depth"`
+	MaxErrorRate    float64   `toml:"max-error-rate"`
+	Format          string    `toml:"format"`
+	SchemaRegistry  string    `toml:"schema-registry"`
+	SubjectStrategy string    `toml:"subject-strategy"`
+	Idempotent      bool      `toml:"idempotent"`
+	TransactionalID string    `toml:"transactional-id"`
+	MaxQueueBytes   int64     `toml:"max-queue-bytes"`
+	MaxQueueAge     *Duration `toml:"max-queue-age"`
+	FullPolicy      string    `toml:"full-policy"`
+	// Delta sends the change of a json object as the merge patch of the
+	// fields changed when it's smaller than the new value.
+	Delta bool `toml:"delta"`
+	File  File `toml:"file"`
+}
+
+// File is the output of the file connector, the changes are appended to
+// Path one json object a line. The file is renamed to Path.1 once it
+// reaches MaxBytes, and so on up to BackupCount files, either 0 keeps
+// growing it.
+type File struct {
+	Path        string `toml:"path"`
+	MaxBytes    int64  `toml:"max-bytes"`
+	BackupCount int    `toml:"backup-count"`
+}
+
+// Replica is the connector topic of another cluster, `tirest consume
+// --apply` writes its changes into this one. The conflicts found are kept
+// for ConflictRetention, 0 keeps them.
+type Replica struct {
+	Version           string    `toml:"version"`
+	BrokerList        []string  `toml:"broker-list"`
+	SASLUser          string    `toml:"sasl-user" secret:"true"`
+	SASLPassword      string    `toml:"sasl-password" secret:"true"`
+	TLS               bool      `toml:"tls"`
+	Topic             string    `toml:"topic"`
+	Group             string    `toml:"group"`
+	Oldest            bool      `toml:"oldest"`
+	BackOff           *Duration `toml:"back-off"`
+	ConflictRetention *Duration `toml:"conflict-retention"`
 }
 
 type Store struct {
@@ -49,6 +99,66 @@ type Store struct {
 	BatchDeleteTimeout *Duration `toml:"batch-delete-timeout"`
 	TsoSlowThreshold   *Duration `toml:"tso-slow-threshold"`
 	DisableLockBackOff bool      `toml:"disable-lock-back-off"`
+	// SeparateReads gives the gets and lists a TiKV client of their own,
+	// with its own gRPC connections, only for newtikv.
+	SeparateReads bool `toml:"separate-reads"`
+}
+
+// Tier routes the keys under Prefix to another store, the fields left out
+// are taken from [store].
+type Tier struct {
+	Prefix string `toml:"prefix"`
+	Store
+}
+
+// StoreConfig returns the store of the tier on top of base.
+func (t *Tier) StoreConfig(base Store) Store {
+	s := base
+	s.Name = t.Name
+	s.GCEnable = t.GCEnable
+	s.DisableLockBackOff = t.DisableLockBackOff
+	s.SeparateReads = t.SeparateReads
+	if t.Path != "" {
+		s.Path = t.Path
+	}
+	if t.Level != "" {
+		s.Level = t.Level
+	}
+	if len(t.PdAddresses) > 0 {
+		s.PdAddresses = t.PdAddresses
+	}
+	for _, d := range []struct{ dst, src **Duration }{
+		{&s.ReadTimeout, &t.ReadTimeout},
+		{&s.ListTimeout, &t.ListTimeout},
+		{&s.WriteTimeout, &t.WriteTimeout},
+		{&s.BatchPutTimeout, &t.BatchPutTimeout},
+		{&s.BatchDeleteTimeout, &t.BatchDeleteTimeout},
+		{&s.TsoSlowThreshold, &t.TsoSlowThreshold},
+	} {
+		if *d.src != nil {
+			*d.dst = *d.src
+		}
+	}
+	return s
+}
+
+// Cluster is another store served by the proxy under /c/{id}/, or to the
+// requests with X-Cluster: id, the fields left out are taken from [store].
+// Its changes are sent to Topic, the connector topic with the suffix -id by
+// default.
+type Cluster struct {
+	ID    string `toml:"id"`
+	Topic string `toml:"topic"`
+	Store
+}
+
+// StoreConfig returns the store of the cluster on top of base.
+func (c *Cluster) StoreConfig(base Store) Store {
+	t := Tier{Store: c.Store}
+	if t.Name == "" {
+		t.Name = base.Name
+	}
+	return t.StoreConfig(base)
 }
 
 func (d *Duration) UnmarshalText(text []byte) error {
@@ -80,6 +190,40 @@ type Server struct {
 	SleepBeforeClose  *Duration   `toml:"sleep-before-close"`
 	ReplicaRead       bool        `toml:"replica-read"`
 	CheckOption       CheckOption `toml:"check-option"`
+	EnableCompress    bool        `toml:"enable-compress"`
+	CompressMinSize   int         `toml:"compress-min-size"`
+	CompressLevel     int         `toml:"compress-level"`
+	MaxKeyLength      int         `toml:"max-key-length"`
+	MaxValueSize      int64       `toml:"max-value-size"`
+	MaxBatchOps       int         `toml:"max-batch-ops"`
+	MaxBatchSize      int64       `toml:"max-batch-size"`
+	MaxListLimit      int         `toml:"max-list-limit"`
+	IdempotencyWindow *Duration   `toml:"idempotency-window"`
+	Mode              string      `toml:"mode"`
+	HLC               bool        `toml:"hlc"`
+	HLCMaxOffset      *Duration   `toml:"hlc-max-offset"`
+	// Sequence numbers the writes of each key, the changes carry it.
+	Sequence bool `toml:"sequence"`
+	// EnableUnsafeDelete allows list deletes with X-Unsafe, they also need
+	// an admin token and a confirmation token.
+	EnableUnsafeDelete bool `toml:"enable-unsafe-delete"`
+	// ListBytes bounds the keys and the values of a list, a list stops
+	// after the item going over it with a cursor to go on, a request may
+	// ask for up to MaxListBytes with X-Max-Bytes.
+	ListBytes    int64 `toml:"list-bytes"`
+	MaxListBytes int64 `toml:"max-list-bytes"`
+	// MaxHeaderBytes bounds the request headers, MaxConnections the
+	// connections open at once, more wait to be accepted, 0 is unlimited.
+	MaxHeaderBytes int `toml:"max-header-bytes"`
+	MaxConnections int `toml:"max-connections"`
+	// TCPKeepAlive is the keep-alive period of the accepted connections,
+	// 0 keeps the default of 15s.
+	TCPKeepAlive *Duration `toml:"tcp-keep-alive"`
+	// Hooks are the names of the store hooks registered to call, in order.
+	Hooks []string `toml:"hooks"`
+	// DedupWrites skips a put of the value the key already has, it's
+	// answered as the write of a value already there.
+	DedupWrites bool `toml:"dedup-writes"`
 }
 
 type Log struct {
@@ -90,15 +234,492 @@ type Log struct {
 	SlowRequest       *Duration `toml:"slow-request"`
 	BufferSize        int       `toml:"buffer-size"`
 	MaxBytes          int       `toml:"max-bytes"`
-	BackupCount       int       `toml:"backup_count"`
+	BackupCount       int       `toml:"backup-count"`
+	// RotateInterval rotates the log files on time boundaries too, 0 disables it.
+	RotateInterval *Duration `toml:"rotate-interval"`
+	Format         string    `toml:"format"`
+	// ErrorFile receives error and above besides the main output.
+	ErrorFile string `toml:"error-file"`
+	// Syslog is "local", e.g. picked up by journald, or udp://host:514.
+	Syslog string `toml:"syslog"`
+}
+
+// Audit records destructive and admin requests whatever the log level is.
+type Audit struct {
+	// File is appended to, stderr is used when empty.
+	File string `toml:"file"`
+	// Connector sends the records to the connector too, with AuditType keys.
+	Connector bool `toml:"connector"`
+}
+
+// Blob keeps the values larger than threshold in an object store, e.g. s3,
+// the db only has a pointer to them. The chunk store splits them into
+// chunk-size parts under the key in the db instead.
+type Blob struct {
+	Name      string    `toml:"name"`
+	Threshold int       `toml:"threshold"`
+	ChunkSize int       `toml:"chunk-size"`
+	Endpoint  string    `toml:"endpoint"`
+	Region    string    `toml:"region"`
+	Bucket    string    `toml:"bucket"`
+	Prefix    string    `toml:"prefix"`
+	AccessKey string    `toml:"access-key"`
+	SecretKey string    `toml:"secret-key" secret:"true"`
+	PathStyle bool      `toml:"path-style"`
+	Timeout   *Duration `toml:"timeout"`
+}
+
+// EncryptionKey is a base64 AES key of 16, 24 or 32 bytes,
+// the key is masked when the config is served.
+type EncryptionKey struct {
+	ID  string `toml:"id"`
+	Key string `toml:"key" secret:"true"`
+}
+
+// Encryption seals the values with AES-GCM by the Primary key of the KMS,
+// the config KMS has its keys in Keys. With Rewrite, a value sealed by
+// another key, or not sealed, is sealed again by Primary once it's read.
+type Encryption struct {
+	Enable  bool            `toml:"enable"`
+	KMS     string          `toml:"kms"`
+	Primary string          `toml:"primary"`
+	Rewrite bool            `toml:"rewrite"`
+	Keys    []EncryptionKey `toml:"key"`
+}
+
+type Cache struct {
+	Name          string    `toml:"name"`
+	Size          int       `toml:"size"`
+	TTL           *Duration `toml:"ttl"`
+	NegativeTTL   *Duration `toml:"negative-ttl"`
+	RedisAddress  string    `toml:"redis-address"`
+	RedisPassword string    `toml:"redis-password" secret:"true"`
+	RedisDB       int       `toml:"redis-db"`
+	RedisPrefix   string    `toml:"redis-prefix"`
+	RedisPoolSize int       `toml:"redis-pool-size"`
+	RedisTimeout  *Duration `toml:"redis-timeout"`
+}
+
+// Watch streams the changes sent to the connector to the clients of
+// /watch, a client which falls buffer events behind is disconnected.
+// The last history changes are kept to resume the clients.
+type Watch struct {
+	Enable     bool      `toml:"enable"`
+	Buffer     int       `toml:"buffer"`
+	MaxClients int       `toml:"max-clients"`
+	Heartbeat  *Duration `toml:"heartbeat"`
+	History    int       `toml:"history"`
+}
+
+type HotKey struct {
+	Enable  bool      `toml:"enable"`
+	Window  *Duration `toml:"window"`
+	Buckets int       `toml:"buckets"`
+	TopN    int       `toml:"top-n"`
+	Width   int       `toml:"width"`
+	Depth   int       `toml:"depth"`
+}
+
+// HotRegion counts the writes by key like HotKey, every Interval the TopN
+// keys written the most are placed in their regions, and a region written
+// at MinQPS or more is hot. AutoSplit asks PD to split at most MaxSplits of
+// the hottest regions each Interval, at the key suggested.
+type HotRegion struct {
+	Enable    bool      `toml:"enable"`
+	Window    *Duration `toml:"window"`
+	Buckets   int       `toml:"buckets"`
+	TopN      int       `toml:"top-n"`
+	Width     int       `toml:"width"`
+	Depth     int       `toml:"depth"`
+	Interval  *Duration `toml:"interval"`
+	MinQPS    float64   `toml:"min-qps"`
+	AutoSplit bool      `toml:"auto-split"`
+	MaxSplits int       `toml:"max-splits"`
+}
+
+// HotKey is the key counting of the writes.
+func (h *HotRegion) HotKey() *HotKey {
+	return &HotKey{Enable: h.Enable, Window: h.Window, Buckets: h.Buckets, TopN: h.TopN, Width: h.Width, Depth: h.Depth}
+}
+
+// Admin serves the admin routes, and the Dashboard page, on HttpPort, or
+// on the api port if it's 0. SwaggerUI is the url of the swagger-ui-dist
+// assets of the swagger page, empty for none.
+type Admin struct {
+	HttpHost     string    `toml:"http-host"`
+	HttpPort     int       `toml:"http-port"`
+	WriteTimeout *Duration `toml:"write-timeout"`
+	Tokens       []string  `toml:"tokens" secret:"true"`
+	Dashboard    bool      `toml:"dashboard"`
+	SwaggerUI    string    `toml:"swagger-ui"`
+}
+
+type Cors struct {
+	Enable           bool      `toml:"enable"`
+	AllowOrigins     []string  `toml:"allow-origins"`
+	AllowMethods     []string  `toml:"allow-methods"`
+	AllowHeaders     []string  `toml:"allow-headers"`
+	ExposeHeaders    []string  `toml:"expose-headers"`
+	AllowCredentials bool      `toml:"allow-credentials"`
+	MaxAge           *Duration `toml:"max-age"`
+}
+
+// Breaker opens when ErrorRate of the calls or SlowRate of them slower
+// than SlowThreshold fail in a Window with at least MinRequests.
+type Breaker struct {
+	Enable         bool      `toml:"enable"`
+	Window         *Duration `toml:"window"`
+	MinRequests    int       `toml:"min-requests"`
+	ErrorRate      float64   `toml:"error-rate"`
+	SlowThreshold  *Duration `toml:"slow-threshold"`
+	SlowRate       float64   `toml:"slow-rate"`
+	OpenTimeout    *Duration `toml:"open-timeout"`
+	HalfOpenProbes int       `toml:"half-open-probes"`
+}
+
+// Bulkhead limits the concurrent calls of each class, 0 is unlimited,
+// a call waits MaxWait for a slot before it's rejected.
+type Bulkhead struct {
+	Enable      bool      `toml:"enable"`
+	List        int       `toml:"list"`
+	BatchDelete int       `toml:"batch-delete"`
+	Write       int       `toml:"write"`
+	MaxWait     *Duration `toml:"max-wait"`
+}
+
+// PriorityRule puts the requests of Route, a route as registered like
+// "/api/v1/batch", and of Token in Class, the first rule matching all of
+// the fields it sets wins.
+type PriorityRule struct {
+	Route string `toml:"route"`
+	Token string `toml:"token" secret:"true"`
+	Class string `toml:"class"`
+}
+
+// Priority runs up to Slots store calls at once, a freed slot goes to a
+// class waiting by the weights, a call waits MaxWait before it's rejected.
+type Priority struct {
+	Enable            bool           `toml:"enable"`
+	Slots             int            `toml:"slots"`
+	InteractiveWeight int            `toml:"interactive-weight"`
+	BatchWeight       int            `toml:"batch-weight"`
+	BackgroundWeight  int            `toml:"background-weight"`
+	MaxWait           *Duration      `toml:"max-wait"`
+	Rules             []PriorityRule `toml:"rule"`
+}
+
+// Checksum stores a CRC32C with each value and checks it on the reads, and
+// the SHA-256 of the blobs. OnMismatch is log to read the value anyway,
+// reject to fail the read, or repair to read the key from another replica
+// and write back the value found intact.
+type Checksum struct {
+	Enable     bool   `toml:"enable"`
+	OnMismatch string `toml:"on-mismatch"`
+}
+
+// ReadRepair writes back in the background the value a get found only
+// under its secondary key, and the copy found intact by checksum.repair,
+// unless the key was written meanwhile. At most Queue repairs wait for
+// the Workers, more are dropped, each has Timeout.
+type ReadRepair struct {
+	Enable  bool      `toml:"enable"`
+	Workers int       `toml:"workers"`
+	Queue   int       `toml:"queue"`
+	Timeout *Duration `toml:"timeout"`
+}
+
+// Reconnect checks PD every Interval, the TiKV client is opened again once
+// the PD addresses resolve to others or FailureThreshold checks in a row
+// failed, a failed attempt is retried with a jittered backoff up to
+// MaxBackoff.
+type Reconnect struct {
+	Enable           bool      `toml:"enable"`
+	Interval         *Duration `toml:"interval"`
+	FailureThreshold int       `toml:"failure-threshold"`
+	MaxBackoff       *Duration `toml:"max-backoff"`
+}
+
+// Timeout is the time budget of the store calls of each endpoint, 0 leaves
+// them to the [store] timeouts.
+type Timeout struct {
+	Get         *Duration `toml:"get"`
+	Put         *Duration `toml:"put"`
+	Cas         *Duration `toml:"cas"`
+	List        *Duration `toml:"list"`
+	BatchDelete *Duration `toml:"batch-delete"`
+}
+
+// Coalesce gathers the puts arriving within Window into one batch write of
+// at most MaxBatch puts, a put may take Window longer.
+type Coalesce struct {
+	Enable   bool      `toml:"enable"`
+	Window   *Duration `toml:"window"`
+	MaxBatch int       `toml:"max-batch"`
+}
+
+// Shadow mirrors ReadPercent of the gets and the lists and WritePercent of
+// the writes to Store in the background, e.g. a new cluster before the
+// cutover, the reads compare what it returns with what [store] did. The
+// fields Store leaves out are taken from [store]. At most Queue calls wait
+// for the Workers, more are dropped.
+type Shadow struct {
+	Enable       bool    `toml:"enable"`
+	ReadPercent  float64 `toml:"read-percent"`
+	WritePercent float64 `toml:"write-percent"`
+	Workers      int     `toml:"workers"`
+	Queue        int     `toml:"queue"`
+	Store        *Store  `toml:"store"`
+}
+
+// StoreConfig returns the store of the shadow on top of base.
+func (s *Shadow) StoreConfig(base Store) Store {
+	c := Cluster{}
+	if s.Store != nil {
+		c.Store = *s.Store
+	}
+	return c.StoreConfig(base)
+}
+
+// Fault injects failures into the store calls to test the retries of the
+// clients and how the proxy degrades, e.g. in staging, never in production.
+// The first rule matching a call applies.
+type Fault struct {
+	Enable bool        `toml:"enable"`
+	Rules  []FaultRule `toml:"rule"`
+}
+
+// FaultRule matches the calls to Target, db or connector, by op and the
+// Prefix of the key, the first one of a batch or the start of a range. A
+// matched call fails or slows down with its Probability: it waits Latency,
+// then fails with Error, unavailable, internal or timeout, if set. Partial
+// fails a batch put after half of it is written, a batch delete after half
+// of its limit and a scan after its first key.
+type FaultRule struct {
+	Target      string    `toml:"target"`
+	Ops         []string  `toml:"ops"`
+	Prefix      string    `toml:"prefix"`
+	Probability float64   `toml:"probability"`
+	Latency     *Duration `toml:"latency"`
+	Error       string    `toml:"error"`
+	Partial     bool      `toml:"partial"`
+}
+
+// Peers forms a fleet of proxies which gossip their membership every
+// GossipInterval, a member not heard of within DeadAfter is dropped. A
+// request for a key is forwarded to its owner on a hash ring of Replicas
+// points per member, so one cache holds the key. Advertise is the api
+// address the others reach this instance at, Token guards the gossip.
+type Peers struct {
+	Enable         bool      `toml:"enable"`
+	Advertise      string    `toml:"advertise"`
+	Seeds          []string  `toml:"seeds"`
+	Token          string    `toml:"token" secret:"true"`
+	GossipInterval *Duration `toml:"gossip-interval"`
+	DeadAfter      *Duration `toml:"dead-after"`
+	Replicas       int       `toml:"replicas"`
+	ForwardTimeout *Duration `toml:"forward-timeout"`
+}
+
+// Dynamic polls the keys under Prefix, in the meta keyspace, every
+// Interval, each one is a section of the config, e.g. acl, as json with
+// the toml keys, applied over the section of the file, so the fleet picks
+// a change up without a redeploy.
+type Dynamic struct {
+	Enable   bool      `toml:"enable"`
+	Prefix   string    `toml:"prefix"`
+	Interval *Duration `toml:"interval"`
+}
+
+// ParallelList splits a list over at least MinRegions regions at the region
+// boundaries, Concurrency of the ranges are read at once.
+type ParallelList struct {
+	Enable      bool `toml:"enable"`
+	MinRegions  int  `toml:"min-regions"`
+	Concurrency int  `toml:"concurrency"`
+}
+
+// ParallelDelete deletes a range on at least MinRegions regions region by
+// region, Concurrency regions at once, at most Rate keys a second in all,
+// 0 is no limit.
+type ParallelDelete struct {
+	Enable      bool `toml:"enable"`
+	MinRegions  int  `toml:"min-regions"`
+	Concurrency int  `toml:"concurrency"`
+	Rate        int  `toml:"rate"`
+}
+
+// ACLRule grants Permission (r, w and s, e.g. rw) on keys under Prefix to requests
+// with Token, of a user in Group or from IP, IP is an address or a CIDR.
+type ACLRule struct {
+	Token      string `toml:"token" json:"token,omitempty" secret:"true"`
+	Group      string `toml:"group" json:"group,omitempty"`
+	IP         string `toml:"ip" json:"ip,omitempty"`
+	Prefix     string `toml:"prefix" json:"prefix"`
+	Permission string `toml:"permission" json:"permission"`
+}
+
+type ACL struct {
+	Enable      bool      `toml:"enable"`
+	DefaultDeny bool      `toml:"default-deny"`
+	Rules       []ACLRule `toml:"rule"`
+}
+
+// Network is a rate class of the peers in CIDRs: each peer makes up to Rate
+// requests a second with bursts of Burst, 0 for no limit, and only reads
+// with ReadOnly.
+type Network struct {
+	Name     string   `toml:"name"`
+	CIDRs    []string `toml:"cidrs"`
+	Rate     float64  `toml:"rate"`
+	Burst    int      `toml:"burst"`
+	ReadOnly bool     `toml:"read-only"`
+}
+
+// Firewall checks the peers of the api listener before auth: a peer in Deny
+// or, if Allow is set, out of Allow is rejected. The first network with a
+// CIDR of the peer is its class, the Default network for the others.
+type Firewall struct {
+	Enable   bool      `toml:"enable"`
+	Allow    []string  `toml:"allow"`
+	Deny     []string  `toml:"deny"`
+	Default  string    `toml:"default"`
+	Networks []Network `toml:"network"`
+}
+
+// OIDCIssuer accepts the bearer JWTs of Issuer signed by a key of JWKSURL,
+// for Audience if it's set. GroupsClaim lists the groups of the user.
+type OIDCIssuer struct {
+	Issuer      string `toml:"issuer"`
+	JWKSURL     string `toml:"jwks-url"`
+	Audience    string `toml:"audience"`
+	GroupsClaim string `toml:"groups-claim"`
+}
+
+// LDAP checks the basic auth of a request: the user found by UserFilter
+// under BaseDN, searched as BindDN, binds with the password, its GroupAttr
+// values are its groups. A successful bind is cached for CacheTTL.
+type LDAP struct {
+	Enable       bool      `toml:"enable"`
+	URL          string    `toml:"url"`
+	BindDN       string    `toml:"bind-dn"`
+	BindPassword string    `toml:"bind-password" secret:"true"`
+	BaseDN       string    `toml:"base-dn"`
+	UserFilter   string    `toml:"user-filter"`
+	GroupAttr    string    `toml:"group-attr"`
+	Timeout      *Duration `toml:"timeout"`
+	CacheTTL     *Duration `toml:"cache-ttl"`
+}
+
+// HMACKey signs the requests of a tenant, its Groups are matched by the
+// group of the ACL rules.
+type HMACKey struct {
+	AccessKey string   `toml:"access-key"`
+	SecretKey string   `toml:"secret-key" secret:"true"`
+	Groups    []string `toml:"groups"`
+}
+
+// HMAC checks the requests signed like the AWS Signature Version 4 of S3,
+// the x-amz-date must be within Window of the clock and a signature is
+// accepted once.
+type HMAC struct {
+	Enable bool      `toml:"enable"`
+	Window *Duration `toml:"window"`
+	Keys   []HMACKey `toml:"key"`
+}
+
+// Auth authenticates the users of the ACL groups besides the static tokens,
+// the keys of the issuers are fetched again after JWKSRefresh.
+type Auth struct {
+	OIDC        []OIDCIssuer `toml:"oidc"`
+	JWKSRefresh *Duration    `toml:"jwks-refresh"`
+	Leeway      *Duration    `toml:"leeway"`
+	LDAP        LDAP         `toml:"ldap"`
+	HMAC        HMAC         `toml:"hmac"`
+}
+
+// Validation checks values written under Prefix, Schema is the json schema
+// file of the json-schema validator.
+type Validation struct {
+	Prefix string `toml:"prefix"`
+	Name   string `toml:"name"`
+	Schema string `toml:"schema"`
+}
+
+// EventRule matches the changes sent to the connector by key prefix, op
+// (put, update, delete, audit) and a value larger than value-size.
+type EventRule struct {
+	Prefix    string   `toml:"prefix"`
+	Ops       []string `toml:"ops"`
+	ValueSize int64    `toml:"value-size"`
+	Action    string   `toml:"action"`
+	Redact    []string `toml:"redact"`
+	Transform string   `toml:"transform"`
+}
+
+// FieldRule seals the Paths, like $.ssn or $.card.number, of the json
+// values under Prefix before they're stored: encrypt by the encryption
+// keys, opened for the s ACL permission, or redact for good.
+type FieldRule struct {
+	Prefix string   `toml:"prefix"`
+	Paths  []string `toml:"paths"`
+	Action string   `toml:"action"`
+}
+
+// Salt spreads the keys under Prefix over Shards ranges, a byte hashed from
+// the rest of the key is stored after the prefix, so keys written in order
+// don't all land on the last region. A list reads Concurrency shards at
+// once, all of them if it's 0.
+type Salt struct {
+	Prefix      string `toml:"prefix"`
+	Shards      int    `toml:"shards"`
+	Concurrency int    `toml:"concurrency"`
 }
 
 type Config struct {
-	Store         Store     `toml:"store"`
-	Server        Server    `toml:"server"`
-	Connector     Connector `toml:"connector"`
-	Log           Log       `toml:"log"`
-	EnableTracing bool      `toml:"enable-tracing"`
+	Store          Store          `toml:"store"`
+	Tiers          []Tier         `toml:"tier"`
+	Clusters       []Cluster      `toml:"cluster"`
+	Server         Server         `toml:"server"`
+	Connector      Connector      `toml:"connector"`
+	Replica        Replica        `toml:"replica"`
+	Cache          Cache          `toml:"cache"`
+	Blob           Blob           `toml:"blob"`
+	Encryption     Encryption     `toml:"encryption"`
+	HotKey         HotKey         `toml:"hot-key"`
+	HotRegion      HotRegion      `toml:"hot-region"`
+	Watch          Watch          `toml:"watch"`
+	Admin          Admin          `toml:"admin"`
+	Cors           Cors           `toml:"cors"`
+	ACL            ACL            `toml:"acl"`
+	Firewall       Firewall       `toml:"firewall"`
+	Auth           Auth           `toml:"auth"`
+	Breaker        Breaker        `toml:"breaker"`
+	Bulkhead       Bulkhead       `toml:"bulkhead"`
+	Priority       Priority       `toml:"priority"`
+	Checksum       Checksum       `toml:"checksum"`
+	ReadRepair     ReadRepair     `toml:"read-repair"`
+	Reconnect      Reconnect      `toml:"reconnect"`
+	Timeout        Timeout        `toml:"timeout"`
+	Coalesce       Coalesce       `toml:"coalesce"`
+	Shadow         Shadow         `toml:"shadow"`
+	Fault          Fault          `toml:"fault"`
+	Peers          Peers          `toml:"peers"`
+	Dynamic        Dynamic        `toml:"dynamic"`
+	ParallelList   ParallelList   `toml:"parallel-list"`
+	ParallelDelete ParallelDelete `toml:"parallel-delete"`
+	Audit          Audit          `toml:"audit"`
+	Validations    []Validation   `toml:"validation"`
+	EventRules     []EventRule    `toml:"event-rule"`
+	FieldRules     []FieldRule    `toml:"field-rule"`
+	Salts          []Salt         `toml:"salt"`
+	Log            Log            `toml:"log"`
+	EnableTracing  bool           `toml:"enable-tracing"`
+	// Plugins are the paths of the Go plugins opened before the drivers are
+	// looked up, a plugin registers its drivers in its init.
+	Plugins []string `toml:"plugins"`
+
+	// secrets maps the resolved secrets to their references.
+	secrets map[string]string
 }
 
 func DefaultConfig() *Config {
@@ -124,9 +745,26 @@ func DefaultConfig() *Config {
 			ReadHeaderTimeout: &Duration{5 * time.Second},
 			WriteTimeout:      &Duration{10 * time.Second},
 			IdleTimeout:       &Duration{2 * time.Minute},
+			MaxHeaderBytes:    1 << 20,
+			MaxConnections:    10000,
+			TCPKeepAlive:      &Duration{time.Minute},
 			SleepBeforeClose:  &Duration{5 * time.Second},
 			ReplicaRead:       false,
 			CheckOption:       TimestampCheck,
+			EnableCompress:    false,
+			CompressMinSize:   1024,
+			CompressLevel:     -1,
+			MaxKeyLength:      4 * 1024,
+			MaxValueSize:      6 * 1024 * 1024,
+			MaxBatchOps:       100,
+			MaxBatchSize:      16 * 1024 * 1024,
+			MaxListLimit:      10000,
+			ListBytes:         8 * 1024 * 1024,
+			MaxListBytes:      64 * 1024 * 1024,
+			IdempotencyWindow: &Duration{time.Hour},
+			Mode:              "normal",
+			HLC:               false,
+			HLCMaxOffset:      &Duration{500 * time.Millisecond},
 		},
 		Connector: Connector{
 			Name:            "kafka",
@@ -147,6 +785,218 @@ func DefaultConfig() *Config {
 			SyncTimeout:     &Duration{2 * time.Second},
 			MaxMsgSize:      1024 * 1024,
 			WriteTimeout:    &Duration{50 * time.Millisecond},
+			BatchSize:       500,
+			BatchTimeout:    &Duration{500 * time.Millisecond},
+			ReplayRetention: &Duration{0},
+			MaxLag:          &Duration{0},
+			Format:          "json",
+			SubjectStrategy: "topic",
+			MaxQueueAge:     &Duration{0},
+			FullPolicy:      "block",
+			File: File{
+				Path:        "./events/events.log",
+				MaxBytes:    100 * 1024 * 1024,
+				BackupCount: 10,
+			},
+		},
+		Replica: Replica{
+			Version:           "0.9.0.1",
+			BrokerList:        []string{},
+			Topic:             "tikvmeta",
+			Group:             "tirest-replica",
+			Oldest:            true,
+			BackOff:           &Duration{time.Second},
+			ConflictRetention: &Duration{7 * 24 * time.Hour},
+		},
+		Blob: Blob{
+			Name:      "",
+			Threshold: 1024 * 1024,
+			ChunkSize: 512 * 1024,
+			Region:    "us-east-1",
+			Prefix:    "tirest/",
+			Timeout:   &Duration{time.Minute},
+		},
+		Encryption: Encryption{
+			Enable:  false,
+			KMS:     "config",
+			Primary: "",
+			Rewrite: true,
+			Keys:    []EncryptionKey{},
+		},
+		Cache: Cache{
+			Name:          "",
+			Size:          100000,
+			TTL:           &Duration{time.Minute},
+			NegativeTTL:   &Duration{0},
+			RedisAddress:  "127.0.0.1:6379",
+			RedisDB:       0,
+			RedisPrefix:   "tirest:",
+			RedisPoolSize: 64,
+			RedisTimeout:  &Duration{100 * time.Millisecond},
+		},
+		Watch: Watch{
+			Enable:     false,
+			Buffer:     1024,
+			MaxClients: 100,
+			Heartbeat:  &Duration{15 * time.Second},
+			History:    4096,
+		},
+		HotKey: HotKey{
+			Enable:  false,
+			Window:  &Duration{time.Minute},
+			Buckets: 6,
+			TopN:    20,
+			Width:   4096,
+			Depth:   4,
+		},
+		HotRegion: HotRegion{
+			Enable:    false,
+			Window:    &Duration{time.Minute},
+			Buckets:   6,
+			TopN:      100,
+			Width:     4096,
+			Depth:     4,
+			Interval:  &Duration{time.Minute},
+			MinQPS:    1000,
+			AutoSplit: false,
+			MaxSplits: 1,
+		},
+		Admin: Admin{
+			HttpHost:     "127.0.0.1",
+			HttpPort:     0,
+			WriteTimeout: &Duration{2 * time.Minute},
+			Tokens:       []string{},
+			Dashboard:    true,
+			SwaggerUI:    "https://unpkg.com/swagger-ui-dist@5",
+		},
+		Cors: Cors{
+			Enable:       false,
+			AllowOrigins: []string{"*"},
+			AllowMethods: []string{"GET", "PUT", "POST", "DELETE"},
+			AllowHeaders: []string{"Content-Type", "X-Start", "X-End", "X-Limit", "X-Reverse",
+				"X-Key-Only", "X-Unsafe", "X-Raw", "X-Exact", "X-Secondary", "X-Access-Token"},
+			ExposeHeaders:    []string{"X-Secondary"},
+			AllowCredentials: false,
+			MaxAge:           &Duration{10 * time.Minute},
+		},
+		ACL: ACL{
+			Enable:      false,
+			DefaultDeny: false,
+		},
+		Firewall: Firewall{
+			Enable:   false,
+			Allow:    []string{},
+			Deny:     []string{},
+			Networks: []Network{},
+		},
+		Auth: Auth{
+			OIDC:        []OIDCIssuer{},
+			JWKSRefresh: &Duration{time.Hour},
+			Leeway:      &Duration{time.Minute},
+			LDAP: LDAP{
+				Enable:     false,
+				UserFilter: "(uid=%s)",
+				GroupAttr:  "memberOf",
+				Timeout:    &Duration{5 * time.Second},
+				CacheTTL:   &Duration{time.Minute},
+			},
+			HMAC: HMAC{
+				Enable: false,
+				Window: &Duration{5 * time.Minute},
+				Keys:   []HMACKey{},
+			},
+		},
+		Breaker: Breaker{
+			Enable:         false,
+			Window:         &Duration{10 * time.Second},
+			MinRequests:    20,
+			ErrorRate:      0.5,
+			SlowThreshold:  &Duration{time.Second},
+			SlowRate:       0.8,
+			OpenTimeout:    &Duration{5 * time.Second},
+			HalfOpenProbes: 3,
+		},
+		Bulkhead: Bulkhead{
+			Enable:      false,
+			List:        32,
+			BatchDelete: 4,
+			Write:       256,
+			MaxWait:     &Duration{100 * time.Millisecond},
+		},
+		Priority: Priority{
+			Enable:            false,
+			Slots:             256,
+			InteractiveWeight: 8,
+			BatchWeight:       2,
+			BackgroundWeight:  1,
+			MaxWait:           &Duration{time.Second},
+		},
+		Checksum: Checksum{
+			Enable:     false,
+			OnMismatch: "log",
+		},
+		ReadRepair: ReadRepair{
+			Enable:  false,
+			Workers: 4,
+			Queue:   1024,
+			Timeout: &Duration{5 * time.Second},
+		},
+		Reconnect: Reconnect{
+			Enable:           false,
+			Interval:         &Duration{10 * time.Second},
+			FailureThreshold: 3,
+			MaxBackoff:       &Duration{2 * time.Minute},
+		},
+		Timeout: Timeout{
+			Get:         &Duration{0},
+			Put:         &Duration{0},
+			Cas:         &Duration{0},
+			List:        &Duration{0},
+			BatchDelete: &Duration{0},
+		},
+		Coalesce: Coalesce{
+			Enable:   false,
+			Window:   &Duration{2 * time.Millisecond},
+			MaxBatch: 128,
+		},
+		Shadow: Shadow{
+			Enable:       false,
+			ReadPercent:  1,
+			WritePercent: 100,
+			Workers:      4,
+			Queue:        10000,
+		},
+		Fault: Fault{
+			Enable: false,
+		},
+		Peers: Peers{
+			Enable:         false,
+			Advertise:      "",
+			Seeds:          []string{},
+			GossipInterval: &Duration{time.Second},
+			DeadAfter:      &Duration{5 * time.Second},
+			Replicas:       128,
+			ForwardTimeout: &Duration{5 * time.Second},
+		},
+		Dynamic: Dynamic{
+			Enable:   false,
+			Prefix:   "tirest/config/",
+			Interval: &Duration{5 * time.Second},
+		},
+		ParallelList: ParallelList{
+			Enable:      false,
+			MinRegions:  4,
+			Concurrency: 8,
+		},
+		ParallelDelete: ParallelDelete{
+			Enable:      false,
+			MinRegions:  4,
+			Concurrency: 4,
+			Rate:        20000,
+		},
+		Audit: Audit{
+			File:      "",
+			Connector: false,
 		},
 		Log: Log{
 			Level:             "info",
@@ -157,21 +1007,21 @@ func DefaultConfig() *Config {
 			MaxBytes:          512 * 1024 * 1024,
 			BackupCount:       10,
 			SlowRequest:       &Duration{50 * time.Millisecond},
+			RotateInterval:    &Duration{0},
+			Format:            "text",
 		},
 		EnableTracing: true,
 	}
 }
 
+// ReadFromFile reads a toml config, or a yaml one by its extension.
 func (c *Config) ReadFromFile(configFile string) error {
 	logrus.Infof("read config file %s", configFile)
-	data, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		return err
-	}
-	if _, err = toml.Decode(string(data), c); err != nil {
-		return err
+	_, warnings, err := decode(configFile, c)
+	for _, w := range warnings {
+		logrus.Warnf("%s: %s", configFile, w)
 	}
-	return nil
+	return err
 }
 
 func (c *Config) HttpServerMode() string {
@@ -182,30 +1032,88 @@ func (c *Config) HttpServerMode() string {
 	return gin.ReleaseMode
 }
 
+// ClusterConfig is the config of the proxy for the cluster cl, a deep copy
+// with its store without tiers, and its connector queue and file in a
+// directory of its own. The peers, the dynamic config, the firewall, cors,
+// the shadow and the audit file are left to the proxy.
+func (c *Config) ClusterConfig(cl *Cluster) *Config {
+	conf := c.Copy()
+	conf.Store = cl.StoreConfig(conf.Store)
+	conf.Tiers, conf.Clusters = nil, nil
+	conf.Peers.Enable = false
+	conf.Dynamic.Enable = false
+	conf.Firewall.Enable = false
+	conf.Cors.Enable = false
+	conf.Shadow.Enable = false
+	conf.Audit.File = ""
+	conf.Connector.Topic = cl.Topic
+	if conf.Connector.Topic == "" {
+		conf.Connector.Topic = c.Connector.Topic + "-" + cl.ID
+	}
+	conf.Connector.QueueDataPath = filepath.Join(c.Connector.QueueDataPath, cl.ID)
+	file := c.Connector.File.Path
+	conf.Connector.File.Path = filepath.Join(filepath.Dir(file), cl.ID, filepath.Base(file))
+	return conf
+}
+
 func (c *Config) String() string {
 	jsonBytes, err := json.Marshal(c)
 	if err != nil {
 		logrus.Errorf("json marshal config, err: %s", err)
 		return ""
 	}
-	return string(jsonBytes)
+	return c.Redact(string(jsonBytes))
 }
 
+// InitConfig reads configFile over the defaults, applies the TIREST_
+// variables of the environment and then resolves the secret references.
 func InitConfig(configFile string) (*Config, error) {
+	return Load(configFile, nil)
+}
+
+func readConfig(configFile string) (*Config, error) {
 	conf := DefaultConfig()
 	err := conf.ReadFromFile(configFile)
 	if err != nil {
 		return nil, err
 	}
+	unknown, err := conf.ApplyEnv(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range unknown {
+		logrus.Warnf("environment variable %s matches no config key", name)
+	}
 	return conf, nil
 }
 
+// Save writes a toml config, or a yaml one by the extension of path.
 func Save(cfg *Config, path string) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	return Encode(f, cfg, IsYAML(path))
+}
 
-	return toml.NewEncoder(f).Encode(cfg)
+// Encode writes the config as toml, or as yaml.
+func Encode(w io.Writer, cfg *Config, asYAML bool) error {
+	if !asYAML {
+		return toml.NewEncoder(w).Encode(cfg)
+	}
+	var b bytes.Buffer
+	if err := toml.NewEncoder(&b).Encode(cfg); err != nil {
+		return err
+	}
+	m := make(map[string]interface{})
+	if _, err := toml.Decode(b.String(), &m); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
 }
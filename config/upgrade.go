@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	tableLine = regexp.MustCompile(`^\s*\[\[?\s*([A-Za-z0-9_.-]+)\s*\]\]?\s*(#.*)?$`)
+	keyLine   = regexp.MustCompile(`^(\s*)([A-Za-z0-9_-]+)(\s*=.*)$`)
+)
+
+type tomlLine struct {
+	text  string
+	table string
+	key   string
+}
+
+func splitKey(key string) (string, string) {
+	i := strings.LastIndexByte(key, '.')
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+1:]
+}
+
+// Upgrade rewrites the old keys of a toml config to the current ones line
+// by line, so the comments and the layout are kept: a key renamed in its
+// table is renamed in place, a key moved to another table is moved after
+// the last line of that table, which is added if missing. An old key whose
+// current key is set too is dropped. The changes are returned.
+func Upgrade(data []byte) ([]byte, []string, error) {
+	lines := make([]tomlLine, 0)
+	set := make(map[string]bool)
+	table := ""
+	for _, text := range strings.Split(string(data), "\n") {
+		l := tomlLine{text: text}
+		if m := tableLine.FindStringSubmatch(text); m != nil {
+			table = m[1]
+		} else if m := keyLine.FindStringSubmatch(text); m != nil {
+			l.key = m[2]
+			full := m[2]
+			if table != "" {
+				full = table + "." + m[2]
+			}
+			set[full] = true
+		}
+		l.table = table
+		lines = append(lines, l)
+	}
+
+	changes := make([]string, 0)
+	moved := make(map[string][]string)
+	out := make([]tomlLine, 0, len(lines))
+	for _, l := range lines {
+		full := l.key
+		if l.table != "" {
+			full = l.table + "." + l.key
+		}
+		current, ok := renamed[full]
+		if l.key == "" || !ok {
+			out = append(out, l)
+			continue
+		}
+		if set[current] {
+			changes = append(changes, fmt.Sprintf("%s dropped, %s is set", full, current))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s -> %s", full, current))
+		newTable, newKey := splitKey(current)
+		m := keyLine.FindStringSubmatch(l.text)
+		if newTable == l.table {
+			l.text = m[1] + newKey + m[3]
+			out = append(out, l)
+			continue
+		}
+		indent := m[1]
+		if indent == "" && newTable != "" {
+			indent = "  "
+		}
+		moved[newTable] = append(moved[newTable], indent+newKey+m[3])
+	}
+
+	tables := make([]string, 0, len(moved))
+	for t := range moved {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+	for _, t := range tables {
+		// after the last key of the table, or at the end in a new one
+		last := -1
+		for i, l := range out {
+			if l.table == t && (l.key != "" || tableLine.MatchString(l.text)) {
+				last = i
+			}
+		}
+		added := make([]tomlLine, 0, len(moved[t])+2)
+		if last < 0 {
+			last = len(out) - 1
+			for last >= 0 && strings.TrimSpace(out[last].text) == "" {
+				last--
+			}
+			added = append(added, tomlLine{}, tomlLine{text: "[" + t + "]", table: t})
+		}
+		for _, text := range moved[t] {
+			added = append(added, tomlLine{text: text, table: t})
+		}
+		out = append(out[:last+1], append(added, out[last+1:]...)...)
+	}
+
+	var b bytes.Buffer
+	for i, l := range out {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(l.text)
+	}
+	if _, err := toml.Decode(b.String(), DefaultConfig()); err != nil {
+		return nil, changes, fmt.Errorf("upgraded config is invalid, %s", err)
+	}
+	return b.Bytes(), changes, nil
+}
+
+// UpgradeFile upgrades a toml or a yaml config, the keys of a yaml one are
+// renamed in its document, which loses the comments.
+func UpgradeFile(configFile string) ([]byte, []string, error) {
+	data, err := readFile(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !IsYAML(configFile) {
+		return Upgrade([]byte(data))
+	}
+	var doc map[string]interface{}
+	if _, err = toml.Decode(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	changes := rename(doc)
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, changes, nil
+}
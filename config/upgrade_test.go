@@ -0,0 +1,57 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpgrade(t *testing.T) {
+	old := `# proxy of the meta cluster
+[connector]
+  # retries of the producer
+  entry = 100 # per message
+  topic = "tikvmeta"
+
+[log]
+  level = "info"
+  backup_count = 3
+  backup-count = 5
+`
+	data, changes, err := Upgrade([]byte(old))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"connector.entry -> connector.retry", "log.backup_count dropped, log.backup-count is set"}, changes)
+	assert.Equal(t, `# proxy of the meta cluster
+[connector]
+  # retries of the producer
+  retry = 100 # per message
+  topic = "tikvmeta"
+
+[log]
+  level = "info"
+  backup-count = 5
+`, string(data))
+
+	// a key moved to another table goes after its last key, or in a new table
+	renamed["server.hlc-offset"] = "clock.max-offset"
+	renamed["log.level"] = "server.log-level"
+	defer delete(renamed, "server.hlc-offset")
+	defer delete(renamed, "log.level")
+	data, changes, err = Upgrade([]byte("[server]\n  hlc-offset = \"1s\"\n[log]\n  level = \"info\"\n\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"server.hlc-offset -> clock.max-offset", "log.level -> server.log-level"}, changes)
+	assert.Equal(t, "[server]\n  log-level = \"info\"\n[log]\n\n[clock]\n  max-offset = \"1s\"\n\n", string(data))
+
+	dir, err := ioutil.TempDir("", "upgrade")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "server.yaml")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("log:\n  backup_count: 3\n"), 0644))
+	data, changes, err = UpgradeFile(file)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"log.backup_count is deprecated, use log.backup-count"}, changes)
+	assert.Equal(t, "log:\n  backup-count: 3\n", string(data))
+}
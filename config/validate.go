@@ -0,0 +1,986 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sirupsen/logrus"
+)
+
+// clusterIDRe is an id of a cluster, a segment of the urls.
+var clusterIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// renamed maps the old keys to the current ones, a config with an old key
+// still loads, with a warning, until the old key is dropped.
+var renamed = map[string]string{
+	"connector.entry":  "connector.retry",
+	"log.backup_count": "log.backup-count",
+}
+
+// rename moves the old keys of doc to their current name, the current one
+// wins when both are set.
+func rename(doc map[string]interface{}) []string {
+	olds := make([]string, 0, len(renamed))
+	for old := range renamed {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	warnings := make([]string, 0)
+	for _, old := range olds {
+		path := strings.Split(old, ".")
+		table := doc
+		for _, k := range path[:len(path)-1] {
+			table, _ = table[k].(map[string]interface{})
+		}
+		v, ok := table[path[len(path)-1]]
+		if !ok {
+			continue
+		}
+		delete(table, path[len(path)-1])
+		warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s", old, renamed[old]))
+		path = strings.Split(renamed[old], ".")
+		table = doc
+		for _, k := range path[:len(path)-1] {
+			next, ok := table[k].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				table[k] = next
+			}
+			table = next
+		}
+		if _, ok := table[path[len(path)-1]]; !ok {
+			table[path[len(path)-1]] = v
+		}
+	}
+	return warnings
+}
+
+// locate finds the key of a value toml can't load into its field, e.g. a
+// string for an int or an invalid duration, decoding the values one by one.
+func locate(prefix string, doc map[string]interface{}, v reflect.Value) error {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := k
+		if prefix != "" {
+			name = prefix + "." + k
+		}
+		f, ok := fieldOf(v, k)
+		if !ok {
+			continue
+		}
+		switch val := doc[k].(type) {
+		case map[string]interface{}:
+			if f.Kind() == reflect.Struct {
+				if err := locate(name, val, f); err != nil {
+					return err
+				}
+				continue
+			}
+		case []map[string]interface{}:
+			if f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Struct {
+				for j, item := range val {
+					err := locate(fmt.Sprintf("%s[%d]", name, j), item, reflect.New(f.Type().Elem()).Elem())
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		one := reflect.StructOf([]reflect.StructField{{Name: "V", Type: f.Type(), Tag: `toml:"v"`}})
+		var b strings.Builder
+		if err := toml.NewEncoder(&b).Encode(map[string]interface{}{"v": doc[k]}); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if _, err := toml.Decode(b.String(), reflect.New(one).Interface()); err != nil {
+			return fmt.Errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "toml: "))
+		}
+	}
+	return nil
+}
+
+// fieldOf is the field of struct v with the toml key, an embedded struct,
+// e.g. the store of a tier, is looked into.
+func fieldOf(v reflect.Value, key string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		if tag == key {
+			return v.Field(i), true
+		}
+		if tag == "" && t.Field(i).Anonymous && t.Field(i).Type.Kind() == reflect.Struct {
+			if f, ok := fieldOf(v.Field(i), key); ok {
+				return f, true
+			}
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// decode reads configFile into c, the old keys are renamed and returned as
+// warnings, a value of the wrong type is reported with its key.
+func decode(configFile string, c *Config) (toml.MetaData, []string, error) {
+	data, err := readFile(configFile)
+	if err != nil {
+		return toml.MetaData{}, nil, err
+	}
+	var doc map[string]interface{}
+	if _, err = toml.Decode(data, &doc); err != nil {
+		return toml.MetaData{}, nil, err
+	}
+	warnings := rename(doc)
+	if len(warnings) > 0 {
+		// re-encoded only then, the errors keep the lines of the file
+		var b strings.Builder
+		if err = toml.NewEncoder(&b).Encode(doc); err != nil {
+			return toml.MetaData{}, nil, err
+		}
+		data = b.String()
+	}
+	md, err := toml.Decode(data, c)
+	if err != nil {
+		if located := locate("", doc, reflect.ValueOf(c).Elem()); located != nil {
+			err = located
+		}
+		return md, warnings, err
+	}
+	return md, warnings, nil
+}
+
+// UndecodedKeys lists the keys of the file which match no config field,
+// usually a typo or a removed option.
+func UndecodedKeys(configFile string) ([]string, error) {
+	md, _, err := decode(configFile, DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0)
+	for _, k := range md.Undecoded() {
+		keys = append(keys, k.String())
+	}
+	return keys, nil
+}
+
+// Check is Validate plus the unknown keys of configFile and the unknown
+// TIREST_ variables, the server doesn't start on any of them.
+func Check(configFile string, c *Config) []error {
+	errs := c.Validate()
+	keys, err := UndecodedKeys(configFile)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, k := range keys {
+		errs = append(errs, fmt.Errorf("%s: unknown key", k))
+	}
+	for _, name := range UnknownEnv() {
+		errs = append(errs, fmt.Errorf("%s: unknown variable", name))
+	}
+	return errs
+}
+
+type checker struct {
+	errs []error
+}
+
+func (c *checker) add(field, format string, args ...interface{}) {
+	c.errs = append(c.errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (c *checker) address(field, addr string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		c.add(field, "invalid address %q, %s", addr, err)
+		return
+	}
+	c.port(field, port, host)
+}
+
+func (c *checker) port(field, port, addr string) {
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		c.add(field, "invalid port %q of %q", port, addr)
+	}
+}
+
+// positive only reports zero, nil and negative are reported by durations
+func (c *checker) positive(field string, d *Duration) {
+	if d != nil && d.Duration == 0 {
+		c.add(field, "must be positive")
+	}
+}
+
+func (c *checker) rate(field string, r float64) {
+	if r < 0 || r > 1 {
+		c.add(field, "%v not in [0, 1]", r)
+	}
+}
+
+func (c *checker) writableFile(field, file string) {
+	if file == "" {
+		return
+	}
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		c.add(field, "%s is a directory", file)
+		return
+	}
+	c.writable(field, filepath.Dir(file))
+}
+
+// writable creates a file in dir, or in its closest existing parent when
+// dir is missing, the queue and the log writers create it on start.
+func (c *checker) writable(field, dir string) {
+	if dir == "" {
+		return
+	}
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				c.add(field, "%s is not a directory", dir)
+				return
+			}
+			break
+		}
+		parent := filepath.Dir(filepath.Clean(dir))
+		if !os.IsNotExist(err) || parent == dir {
+			c.add(field, "%s", err)
+			return
+		}
+		dir = parent
+	}
+	f, err := ioutil.TempFile(dir, ".check-")
+	if err != nil {
+		c.add(field, "not writable, %s", err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// durations reports every negative duration, the field is the toml key.
+func (c *checker) durations(prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			continue
+		}
+		name := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		f := v.Field(i)
+		switch d := f.Interface().(type) {
+		case *Duration:
+			if d == nil {
+				c.add(name, "missing")
+			} else if d.Duration < 0 {
+				c.add(name, "negative duration %s", d.Duration)
+			}
+			continue
+		}
+		if f.Kind() == reflect.Struct {
+			c.durations(name, f)
+		}
+	}
+}
+
+func (c *checker) store(field string, s *Store) {
+	if s.Name == "" {
+		c.add(field+".name", "missing")
+	}
+	switch s.Name {
+	case "etcd":
+		// the endpoints are in the path, etcd://host:port[,host:port]
+		if !strings.HasPrefix(s.Path, "etcd://") {
+			c.add(field+".path", "invalid etcd path %q", s.Path)
+		} else {
+			for _, addr := range strings.Split(strings.TrimPrefix(s.Path, "etcd://"), ",") {
+				c.address(field+".path", addr)
+			}
+		}
+	case "fdb":
+		// the cluster file is in the path, fdb://[cluster file]
+		if !strings.HasPrefix(s.Path, "fdb://") {
+			c.add(field+".path", "invalid fdb path %q", s.Path)
+		}
+	case "tikv", "newtikv":
+		for _, addr := range s.PdAddresses {
+			c.address(field+".pd-address", addr)
+		}
+		if len(s.PdAddresses) == 0 {
+			c.add(field+".pd-address", "missing")
+		}
+	}
+	c.positive(field+".read-timeout", s.ReadTimeout)
+	c.positive(field+".list-timeout", s.ListTimeout)
+	c.positive(field+".write-timeout", s.WriteTimeout)
+	c.positive(field+".batch-put-timeout", s.BatchPutTimeout)
+	c.positive(field+".batch-delete-timeout", s.BatchDeleteTimeout)
+}
+
+func (c *Config) firewall(ck *checker) {
+	cidrs := func(field string, list []string) {
+		for _, cidr := range list {
+			if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+				ck.add(field, "invalid cidr %q", cidr)
+			}
+		}
+	}
+	cidrs("firewall.allow", c.Firewall.Allow)
+	cidrs("firewall.deny", c.Firewall.Deny)
+	found := c.Firewall.Default == ""
+	for i, n := range c.Firewall.Networks {
+		field := fmt.Sprintf("firewall.network[%d]", i)
+		if n.Name == "" {
+			ck.add(field+".name", "missing")
+		}
+		for _, other := range c.Firewall.Networks[:i] {
+			if n.Name == other.Name {
+				ck.add(field+".name", "duplicate %q", n.Name)
+			}
+		}
+		found = found || n.Name == c.Firewall.Default
+		cidrs(field+".cidrs", n.CIDRs)
+		if n.Rate < 0 {
+			ck.add(field+".rate", "negative")
+		}
+		if n.Rate > 0 && n.Burst <= 0 {
+			ck.add(field+".burst", "must be positive with a rate")
+		}
+	}
+	if !found {
+		ck.add("firewall.default", "no network %q", c.Firewall.Default)
+	}
+}
+
+func (c *Config) encryption(ck *checker) {
+	e := &c.Encryption
+	if e.KMS == "" {
+		ck.add("encryption.kms", "missing")
+	}
+	if e.Primary == "" || len(e.Primary) > 255 {
+		ck.add("encryption.primary", "id of 1 to 255 bytes")
+	}
+	if e.KMS != "config" {
+		return
+	}
+	found := false
+	for i, k := range e.Keys {
+		field := fmt.Sprintf("encryption.key[%d]", i)
+		if k.ID == "" || len(k.ID) > 255 {
+			ck.add(field+".id", "id of 1 to 255 bytes")
+		}
+		for _, other := range e.Keys[:i] {
+			if k.ID == other.ID {
+				ck.add(field+".id", "duplicate %q", k.ID)
+			}
+		}
+		b, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			ck.add(field+".key", "invalid base64, %s", err)
+		} else if len(b) != 16 && len(b) != 24 && len(b) != 32 {
+			ck.add(field+".key", "%d bytes, not an AES key of 16, 24 or 32", len(b))
+		}
+		found = found || k.ID == e.Primary
+	}
+	if !found {
+		ck.add("encryption.primary", "no key %q", e.Primary)
+	}
+}
+
+// Validate checks the values which would only fail at runtime, e.g. a
+// broker address without a port or a queue path that isn't writable.
+// faultOps are the ops of the calls a fault rule matches by target.
+var faultOps = map[string][]string{
+	"db":        {"get", "list", "put", "cas", "batch_put", "batch_delete", "unsafe_delete"},
+	"connector": {"send"},
+}
+
+func (c *Config) fault(ck *checker) {
+	if len(c.Fault.Rules) == 0 {
+		ck.add("fault.rule", "missing")
+	}
+	for i, r := range c.Fault.Rules {
+		field := fmt.Sprintf("fault.rule[%d]", i)
+		ops, ok := faultOps[r.Target]
+		if !ok {
+			ck.add(field+".target", "unknown %q", r.Target)
+		}
+		for _, op := range r.Ops {
+			known := false
+			for _, o := range ops {
+				known = known || o == op
+			}
+			if ok && !known {
+				ck.add(field+".ops", "unknown %q of %s", op, r.Target)
+			}
+		}
+		ck.rate(field+".probability", r.Probability)
+		if r.Latency != nil && r.Latency.Duration < 0 {
+			ck.add(field+".latency", "negative duration %s", r.Latency.Duration)
+		}
+		switch r.Error {
+		case "":
+			if r.Latency == nil || r.Latency.Duration <= 0 {
+				ck.add(field, "neither latency nor error")
+			}
+			if r.Partial {
+				ck.add(field+".partial", "needs an error")
+			}
+		case "unavailable", "internal", "timeout":
+		default:
+			ck.add(field+".error", "unknown %q", r.Error)
+		}
+	}
+}
+
+func (c *Config) Validate() []error {
+	ck := &checker{}
+	ck.durations("", reflect.ValueOf(*c))
+
+	for i, p := range c.Plugins {
+		if info, err := os.Stat(p); err != nil {
+			ck.add(fmt.Sprintf("plugins[%d]", i), "%s", err)
+		} else if info.IsDir() {
+			ck.add(fmt.Sprintf("plugins[%d]", i), "%s is a directory", p)
+		}
+	}
+
+	ck.store("store", &c.Store)
+	for i := range c.Tiers {
+		tier := &c.Tiers[i]
+		field := fmt.Sprintf("tier[%d]", i)
+		if tier.Prefix == "" {
+			ck.add(field+".prefix", "missing")
+		}
+		for _, other := range c.Tiers[:i] {
+			if strings.HasPrefix(tier.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, tier.Prefix) {
+				ck.add(field+".prefix", "%q overlaps %q", tier.Prefix, other.Prefix)
+			}
+		}
+		s := tier.StoreConfig(c.Store)
+		ck.durations(field, reflect.ValueOf(s))
+		ck.store(field, &s)
+	}
+	for i := range c.Clusters {
+		cluster := &c.Clusters[i]
+		field := fmt.Sprintf("cluster[%d]", i)
+		if !clusterIDRe.MatchString(cluster.ID) {
+			ck.add(field+".id", "%q not letters, digits, - or _", cluster.ID)
+		}
+		for _, other := range c.Clusters[:i] {
+			if cluster.ID == other.ID {
+				ck.add(field+".id", "duplicate %q", cluster.ID)
+			}
+		}
+		s := cluster.StoreConfig(c.Store)
+		ck.durations(field, reflect.ValueOf(s))
+		ck.store(field, &s)
+	}
+
+	ck.port("server.http-port", strconv.Itoa(c.Server.HttpPort), c.Server.HttpHost)
+	if c.Admin.HttpPort != 0 {
+		ck.port("admin.http-port", strconv.Itoa(c.Admin.HttpPort), c.Admin.HttpHost)
+		if c.Admin.HttpPort == c.Server.HttpPort {
+			ck.add("admin.http-port", "same as server.http-port")
+		}
+	}
+	switch c.Server.CheckOption {
+	case "", NopCheck, ExactCheck, TimestampCheck:
+	default:
+		ck.add("server.check-option", "unknown %q", c.Server.CheckOption)
+	}
+	if c.Server.MaxKeyLength < 0 {
+		ck.add("server.max-key-length", "negative")
+	}
+	if c.Server.MaxValueSize < 0 {
+		ck.add("server.max-value-size", "negative")
+	}
+	if c.Server.MaxBatchOps <= 0 {
+		ck.add("server.max-batch-ops", "must be positive")
+	}
+	if c.Server.MaxBatchSize < 0 {
+		ck.add("server.max-batch-size", "negative")
+	}
+	if c.Server.MaxListLimit <= 0 {
+		ck.add("server.max-list-limit", "must be positive")
+	}
+	if c.Server.ListBytes <= 0 {
+		ck.add("server.list-bytes", "must be positive")
+	} else if c.Server.MaxListBytes < c.Server.ListBytes {
+		ck.add("server.max-list-bytes", "less than list-bytes %d", c.Server.ListBytes)
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		ck.add("server.max-header-bytes", "negative")
+	}
+	if c.Server.MaxConnections < 0 {
+		ck.add("server.max-connections", "negative")
+	}
+
+	if c.Connector.Name == "" {
+		ck.add("connector.name", "missing")
+	}
+	if c.Connector.EnableProducer {
+		if len(c.Connector.BrokerList) == 0 {
+			ck.add("connector.broker-list", "missing")
+		}
+		for _, addr := range c.Connector.BrokerList {
+			ck.address("connector.broker-list", addr)
+		}
+		if c.Connector.Topic == "" {
+			ck.add("connector.topic", "missing")
+		}
+		if c.Connector.PartitionNum <= 0 {
+			ck.add("connector.partition-num", "must be positive")
+		}
+		if c.Connector.BatchSize <= 0 {
+			ck.add("connector.batch-size", "must be positive")
+		}
+		ck.positive("connector.batch-timeout", c.Connector.BatchTimeout)
+		if c.Connector.MemQueueSize < 0 {
+			ck.add("connector.mem-queue-size", "negative")
+		}
+		if c.Connector.MaxBytesPerFile <= 0 {
+			ck.add("connector.max-bytes-per-file", "must be positive")
+		}
+		if c.Connector.SyncEvery <= 0 {
+			ck.add("connector.sync-every", "must be positive")
+		}
+		if c.Connector.MaxMsgSize <= 0 {
+			ck.add("connector.max-msg-size", "must be positive")
+		}
+		if c.Connector.Idempotent && c.Connector.Retry < 1 {
+			ck.add("connector.retry", "idempotent producer needs a retry")
+		}
+		if c.Connector.TransactionalID != "" && !c.Connector.Idempotent {
+			ck.add("connector.transactional-id", "needs idempotent")
+		}
+	}
+	if c.Connector.SASLPassword != "" && c.Connector.SASLUser == "" {
+		ck.add("connector.sasl-password", "needs sasl-user")
+	}
+	if c.Connector.BackOff != nil && c.Connector.MaxBackOff != nil &&
+		c.Connector.BackOff.Duration > c.Connector.MaxBackOff.Duration {
+		ck.add("connector.back-off", "greater than max-back-off")
+	}
+	ck.writable("connector.queue-data-path", c.Connector.QueueDataPath)
+	if c.Connector.Name == "file" {
+		if c.Connector.File.Path == "" {
+			ck.add("connector.file.path", "missing")
+		}
+		ck.writableFile("connector.file.path", c.Connector.File.Path)
+		if c.Connector.File.MaxBytes < 0 {
+			ck.add("connector.file.max-bytes", "negative")
+		}
+		if c.Connector.File.BackupCount < 0 {
+			ck.add("connector.file.backup-count", "negative")
+		}
+	}
+	if len(c.Replica.BrokerList) > 0 {
+		for _, addr := range c.Replica.BrokerList {
+			ck.address("replica.broker-list", addr)
+		}
+		if c.Replica.Topic == "" {
+			ck.add("replica.topic", "missing")
+		}
+		if c.Replica.Group == "" {
+			ck.add("replica.group", "missing")
+		}
+		ck.positive("replica.back-off", c.Replica.BackOff)
+		if c.Replica.SASLPassword != "" && c.Replica.SASLUser == "" {
+			ck.add("replica.sasl-password", "needs sasl-user")
+		}
+	}
+	if c.Replica.ConflictRetention == nil || c.Replica.ConflictRetention.Duration < 0 {
+		ck.add("replica.conflict-retention", "must not be negative")
+	}
+	ck.rate("connector.max-error-rate", c.Connector.MaxErrorRate)
+	switch c.Connector.Format {
+	case "", "json":
+	case "avro":
+		if c.Connector.Delta {
+			ck.add("connector.delta", "needs the json format")
+		}
+		u, err := url.Parse(c.Connector.SchemaRegistry)
+		if err != nil || u.Host == "" {
+			ck.add("connector.schema-registry", "invalid url %q", c.Connector.SchemaRegistry)
+		}
+		switch c.Connector.SubjectStrategy {
+		case "topic", "record", "topic-record":
+		default:
+			ck.add("connector.subject-strategy", "unknown %q", c.Connector.SubjectStrategy)
+		}
+	default:
+		ck.add("connector.format", "unknown %q", c.Connector.Format)
+	}
+	if c.Connector.MaxDepth < 0 {
+		ck.add("connector.max-depth", "negative")
+	}
+	if c.Connector.MaxQueueBytes < 0 {
+		ck.add("connector.max-queue-bytes", "negative")
+	}
+	switch c.Connector.FullPolicy {
+	case "block", "drop-oldest", "drop-newest":
+	default:
+		ck.add("connector.full-policy", "unknown %q", c.Connector.FullPolicy)
+	}
+
+	for i, r := range c.EventRules {
+		field := fmt.Sprintf("event-rule[%d]", i)
+		switch r.Action {
+		case "include", "exclude":
+		default:
+			ck.add(field+".action", "unknown %q", r.Action)
+		}
+		for _, op := range r.Ops {
+			switch op {
+			case "put", "update", "delete", "audit":
+			default:
+				ck.add(field+".ops", "unknown %q", op)
+			}
+		}
+		if r.ValueSize < 0 {
+			ck.add(field+".value-size", "negative")
+		}
+	}
+
+	for i, r := range c.FieldRules {
+		field := fmt.Sprintf("field-rule[%d]", i)
+		switch r.Action {
+		case "encrypt":
+			if !c.Encryption.Enable {
+				ck.add(field+".action", "encrypt needs encryption.enable")
+			}
+		case "redact":
+		default:
+			ck.add(field+".action", "unknown %q", r.Action)
+		}
+		if len(r.Paths) == 0 {
+			ck.add(field+".paths", "missing")
+		}
+		for _, p := range r.Paths {
+			if !strings.HasPrefix(p, "$.") || strings.Contains("."+p[2:]+".", "..") {
+				ck.add(field+".paths", "invalid %q, like $.a.b", p)
+			}
+		}
+	}
+
+	for i, r := range c.Salts {
+		field := fmt.Sprintf("salt[%d]", i)
+		if r.Shards < 2 || r.Shards > 256 {
+			ck.add(field+".shards", "%d not in [2,256]", r.Shards)
+		}
+		if r.Concurrency < 0 {
+			ck.add(field+".concurrency", "negative")
+		}
+		for _, other := range c.Salts[:i] {
+			if strings.HasPrefix(r.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, r.Prefix) {
+				ck.add(field+".prefix", "%q overlaps %q", r.Prefix, other.Prefix)
+			}
+		}
+	}
+
+	if c.Blob.Name != "" {
+		if c.Blob.Threshold <= 0 {
+			ck.add("blob.threshold", "must be positive")
+		}
+		if c.Blob.Name == "chunk" {
+			if c.Blob.ChunkSize <= 0 {
+				ck.add("blob.chunk-size", "must be positive")
+			}
+		} else if c.Blob.Bucket == "" {
+			ck.add("blob.bucket", "missing")
+		}
+		// the parts of chunk go through the sealed db, an s3 body doesn't
+		if c.Blob.Name != "chunk" && c.Encryption.Enable {
+			ck.add("blob.name", "%s blobs aren't sealed, use chunk with encryption.enable", c.Blob.Name)
+		}
+		ck.positive("blob.timeout", c.Blob.Timeout)
+	}
+	if c.Encryption.Enable {
+		c.encryption(ck)
+	}
+	if c.Watch.Enable {
+		if c.Watch.Buffer <= 0 {
+			ck.add("watch.buffer", "must be positive")
+		}
+		if c.Watch.MaxClients <= 0 {
+			ck.add("watch.max-clients", "must be positive")
+		}
+		ck.positive("watch.heartbeat", c.Watch.Heartbeat)
+		if c.Watch.History < 0 {
+			ck.add("watch.history", "must not be negative")
+		}
+	}
+	if c.Cache.Name != "" {
+		ck.positive("cache.ttl", c.Cache.TTL)
+		if c.Cache.Name == "redis" {
+			ck.address("cache.redis-address", c.Cache.RedisAddress)
+		}
+	}
+	if c.HotKey.Enable {
+		ck.positive("hot-key.window", c.HotKey.Window)
+		if c.HotKey.Buckets <= 0 || c.HotKey.TopN <= 0 || c.HotKey.Width <= 0 || c.HotKey.Depth <= 0 {
+			ck.add("hot-key", "buckets, top-n, width and depth must be positive")
+		} else if c.HotKey.Window != nil && c.HotKey.Window.Duration/time.Duration(c.HotKey.Buckets) <= 0 {
+			ck.add("hot-key.window", "shorter than buckets")
+		}
+	}
+	if c.HotRegion.Enable {
+		ck.positive("hot-region.window", c.HotRegion.Window)
+		ck.positive("hot-region.interval", c.HotRegion.Interval)
+		if c.HotRegion.Buckets <= 0 || c.HotRegion.TopN <= 0 || c.HotRegion.Width <= 0 || c.HotRegion.Depth <= 0 {
+			ck.add("hot-region", "buckets, top-n, width and depth must be positive")
+		} else if c.HotRegion.Window != nil && c.HotRegion.Window.Duration/time.Duration(c.HotRegion.Buckets) <= 0 {
+			ck.add("hot-region.window", "shorter than buckets")
+		}
+		if c.HotRegion.MinQPS < 0 {
+			ck.add("hot-region.min-qps", "negative")
+		}
+		if c.HotRegion.AutoSplit && c.HotRegion.MaxSplits <= 0 {
+			ck.add("hot-region.max-splits", "must be positive")
+		}
+	}
+	if c.Breaker.Enable {
+		ck.rate("breaker.error-rate", c.Breaker.ErrorRate)
+		ck.rate("breaker.slow-rate", c.Breaker.SlowRate)
+		ck.positive("breaker.open-timeout", c.Breaker.OpenTimeout)
+	}
+	if c.Priority.Enable {
+		ck.positive("priority.max-wait", c.Priority.MaxWait)
+		if c.Priority.Slots <= 0 {
+			ck.add("priority.slots", "must be positive")
+		}
+		if c.Priority.InteractiveWeight <= 0 || c.Priority.BatchWeight <= 0 || c.Priority.BackgroundWeight <= 0 {
+			ck.add("priority", "interactive-weight, batch-weight and background-weight must be positive")
+		}
+		for i, r := range c.Priority.Rules {
+			field := fmt.Sprintf("priority.rule[%d]", i)
+			if r.Route == "" && r.Token == "" {
+				ck.add(field, "neither route nor token")
+			}
+			switch r.Class {
+			case "interactive", "batch", "background":
+			default:
+				ck.add(field+".class", "unknown %q", r.Class)
+			}
+		}
+	}
+	if c.Checksum.Enable {
+		switch c.Checksum.OnMismatch {
+		case "log", "reject", "repair":
+		default:
+			ck.add("checksum.on-mismatch", "unknown %q", c.Checksum.OnMismatch)
+		}
+	}
+	if c.ReadRepair.Enable {
+		ck.positive("read-repair.timeout", c.ReadRepair.Timeout)
+		if c.ReadRepair.Workers <= 0 || c.ReadRepair.Queue < 0 {
+			ck.add("read-repair", "workers must be positive, queue not negative")
+		}
+	}
+	if c.Reconnect.Enable {
+		ck.positive("reconnect.interval", c.Reconnect.Interval)
+		ck.positive("reconnect.max-backoff", c.Reconnect.MaxBackoff)
+		if c.Reconnect.FailureThreshold <= 0 {
+			ck.add("reconnect.failure-threshold", "must be positive")
+		}
+	}
+	if c.Coalesce.Enable {
+		ck.positive("coalesce.window", c.Coalesce.Window)
+		if c.Coalesce.MaxBatch <= 0 {
+			ck.add("coalesce.max-batch", "must be positive")
+		}
+	}
+	if c.Shadow.Enable {
+		if c.Shadow.Store == nil {
+			ck.add("shadow.store", "missing")
+		} else {
+			s := c.Shadow.StoreConfig(c.Store)
+			ck.durations("shadow.store", reflect.ValueOf(s))
+			ck.store("shadow.store", &s)
+		}
+		if c.Shadow.ReadPercent < 0 || c.Shadow.ReadPercent > 100 {
+			ck.add("shadow.read-percent", "not within 0 and 100")
+		}
+		if c.Shadow.WritePercent < 0 || c.Shadow.WritePercent > 100 {
+			ck.add("shadow.write-percent", "not within 0 and 100")
+		}
+		if c.Shadow.Workers <= 0 {
+			ck.add("shadow.workers", "must be positive")
+		}
+		if c.Shadow.Queue <= 0 {
+			ck.add("shadow.queue", "must be positive")
+		}
+	}
+	if c.Fault.Enable {
+		c.fault(ck)
+	}
+	if c.Peers.Enable {
+		ck.address("peers.advertise", c.Peers.Advertise)
+		for _, addr := range c.Peers.Seeds {
+			ck.address("peers.seeds", addr)
+		}
+		if c.Peers.Token == "" {
+			ck.add("peers.token", "missing")
+		}
+		ck.positive("peers.gossip-interval", c.Peers.GossipInterval)
+		ck.positive("peers.forward-timeout", c.Peers.ForwardTimeout)
+		if c.Peers.GossipInterval != nil && c.Peers.DeadAfter != nil &&
+			c.Peers.DeadAfter.Duration <= c.Peers.GossipInterval.Duration {
+			ck.add("peers.dead-after", "not longer than gossip-interval")
+		}
+		if c.Peers.Replicas <= 0 {
+			ck.add("peers.replicas", "must be positive")
+		}
+		if c.Cache.Name == "" {
+			ck.add("peers.enable", "needs a cache")
+		}
+	}
+	if c.Dynamic.Enable {
+		if c.Dynamic.Prefix == "" {
+			ck.add("dynamic.prefix", "missing")
+		}
+		ck.positive("dynamic.interval", c.Dynamic.Interval)
+	}
+	if c.ParallelList.Enable {
+		if c.ParallelList.MinRegions < 2 {
+			ck.add("parallel-list.min-regions", "must be at least 2")
+		}
+		if c.ParallelList.Concurrency <= 0 {
+			ck.add("parallel-list.concurrency", "must be positive")
+		}
+	}
+	if c.ParallelDelete.Enable {
+		if c.ParallelDelete.MinRegions < 2 {
+			ck.add("parallel-delete.min-regions", "must be at least 2")
+		}
+		if c.ParallelDelete.Concurrency <= 0 {
+			ck.add("parallel-delete.concurrency", "must be positive")
+		}
+		if c.ParallelDelete.Rate < 0 {
+			ck.add("parallel-delete.rate", "negative")
+		}
+	}
+
+	for i, r := range c.ACL.Rules {
+		if r.Token == "" && r.Group == "" && r.IP == "" {
+			ck.add(fmt.Sprintf("acl.rule[%d]", i), "needs a token, a group or an ip")
+		}
+	}
+	if c.Firewall.Enable {
+		c.firewall(ck)
+	}
+	for i, o := range c.Auth.OIDC {
+		field := fmt.Sprintf("auth.oidc[%d]", i)
+		if o.Issuer == "" {
+			ck.add(field+".issuer", "missing")
+		}
+		for _, other := range c.Auth.OIDC[:i] {
+			if o.Issuer == other.Issuer {
+				ck.add(field+".issuer", "duplicate %q", o.Issuer)
+			}
+		}
+		u, err := url.Parse(o.JWKSURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			ck.add(field+".jwks-url", "invalid url %q", o.JWKSURL)
+		}
+	}
+	if len(c.Auth.OIDC) > 0 {
+		ck.positive("auth.jwks-refresh", c.Auth.JWKSRefresh)
+	}
+	if c.Auth.LDAP.Enable {
+		u, err := url.Parse(c.Auth.LDAP.URL)
+		if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			ck.add("auth.ldap.url", "invalid url %q", c.Auth.LDAP.URL)
+		}
+		if c.Auth.LDAP.BaseDN == "" {
+			ck.add("auth.ldap.base-dn", "missing")
+		}
+		if strings.Count(c.Auth.LDAP.UserFilter, "%s") != 1 {
+			ck.add("auth.ldap.user-filter", "%q needs one %%s for the user", c.Auth.LDAP.UserFilter)
+		}
+		ck.positive("auth.ldap.timeout", c.Auth.LDAP.Timeout)
+	}
+	if c.Auth.HMAC.Enable {
+		ck.positive("auth.hmac.window", c.Auth.HMAC.Window)
+		if len(c.Auth.HMAC.Keys) == 0 {
+			ck.add("auth.hmac.key", "missing")
+		}
+		for i, k := range c.Auth.HMAC.Keys {
+			field := fmt.Sprintf("auth.hmac.key[%d]", i)
+			if k.AccessKey == "" || strings.ContainsAny(k.AccessKey, "/, ") {
+				ck.add(field+".access-key", "invalid %q", k.AccessKey)
+			}
+			for _, other := range c.Auth.HMAC.Keys[:i] {
+				if k.AccessKey == other.AccessKey {
+					ck.add(field+".access-key", "duplicate %q", k.AccessKey)
+				}
+			}
+			if len(k.SecretKey) < 16 {
+				ck.add(field+".secret-key", "shorter than 16 bytes")
+			}
+		}
+	}
+
+	if c.Server.EnableUnsafeDelete && len(c.Admin.Tokens) == 0 {
+		ck.add("server.enable-unsafe-delete", "needs admin.tokens")
+	}
+	// an origin matched by "*" is sent back, with credentials any site
+	// could read the api as the user
+	if c.Cors.Enable && c.Cors.AllowCredentials {
+		for _, o := range c.Cors.AllowOrigins {
+			if o == "*" {
+				ck.add("cors.allow-origins", "\"*\" with allow-credentials")
+			}
+		}
+	}
+
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		ck.add("log.level", "%s", err)
+	}
+	if c.Log.Format != "" && c.Log.Format != "text" && c.Log.Format != "json" {
+		ck.add("log.format", "unknown %q", c.Log.Format)
+	}
+	if c.Log.Syslog != "" && c.Log.Syslog != "local" {
+		u, err := url.Parse(c.Log.Syslog)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") {
+			ck.add("log.syslog", "%q is neither local nor udp:// or tcp://", c.Log.Syslog)
+		} else {
+			ck.address("log.syslog", u.Host)
+		}
+	}
+	if c.Log.BufferSize < 0 {
+		ck.add("log.buffer-size", "negative")
+	}
+	if c.Log.MaxBytes < 0 {
+		ck.add("log.max-bytes", "negative")
+	}
+	if c.Log.BackupCount < 0 {
+		ck.add("log.backup-count", "negative")
+	}
+	// the log options are file names despite the dir suffix
+	ck.writableFile("log.error-log-dir", c.Log.ErrorLogDir)
+	if c.Log.AccessLogDir != "std" {
+		ck.writableFile("log.access-log-dir", c.Log.AccessLogDir)
+	}
+	ck.writableFile("log.error-file", c.Log.ErrorFile)
+	ck.writableFile("audit.file", c.Audit.File)
+	return ck.errs
+}
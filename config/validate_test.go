@@ -0,0 +1,196 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	assert.Empty(t, conf.Validate())
+
+	conf.Store.ReadTimeout = &Duration{-time.Second}
+	conf.Store.WriteTimeout = &Duration{0}
+	conf.Server.IdleTimeout = nil
+	conf.Connector.BrokerList = []string{"kafka1"}
+	conf.Connector.SyncEvery = 0
+	conf.Server.CheckOption = "strict"
+	conf.EventRules = []EventRule{{Action: "drop", Ops: []string{"get"}}}
+	conf.FieldRules = []FieldRule{{Action: "encrypt", Paths: []string{"$.ssn", "$.card..number"}}}
+	conf.Salts = []Salt{{Prefix: "logs/", Shards: 16}, {Prefix: "logs/2020/", Shards: 1}}
+	conf.Firewall = Firewall{Enable: true, Deny: []string{"10.0.0.0/33"}, Default: "external"}
+	conf.Coalesce = Coalesce{Enable: true, Window: &Duration{time.Millisecond}, MaxBatch: 0}
+	conf.Peers = Peers{Enable: true, Advertise: "10.0.0.1:6100", GossipInterval: &Duration{time.Second},
+		DeadAfter: &Duration{time.Second}, Replicas: 128, ForwardTimeout: &Duration{time.Second}}
+	conf.ParallelList = ParallelList{Enable: true, MinRegions: 1, Concurrency: 8}
+	conf.ParallelDelete = ParallelDelete{Enable: true, MinRegions: 4, Concurrency: 4, Rate: -1}
+	conf.Log.Level = "verbose"
+	conf.Log.Format = "xml"
+	conf.Log.BackupCount = -1
+	conf.Log.ErrorFile = dir
+	errs := conf.Validate()
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"store.read-timeout: negative duration -1s",
+		"server.idle-timeout: missing",
+		"store.write-timeout: must be positive",
+		"server.check-option: unknown \"strict\"",
+		"connector.broker-list: invalid address \"kafka1\", address kafka1: missing port in address",
+		"connector.sync-every: must be positive",
+		"event-rule[0].action: unknown \"drop\"",
+		"event-rule[0].ops: unknown \"get\"",
+		"field-rule[0].action: encrypt needs encryption.enable",
+		"field-rule[0].paths: invalid \"$.card..number\", like $.a.b",
+		"salt[1].shards: 1 not in [2,256]",
+		"salt[1].prefix: \"logs/2020/\" overlaps \"logs/\"",
+		"coalesce.max-batch: must be positive",
+		"peers.token: missing",
+		"peers.dead-after: not longer than gossip-interval",
+		"peers.enable: needs a cache",
+		"parallel-list.min-regions: must be at least 2",
+		"parallel-delete.rate: negative",
+		"firewall.deny: invalid cidr \"10.0.0.0/33\"",
+		"firewall.default: no network \"external\"",
+		"log.level: not a valid logrus Level: \"verbose\"",
+		"log.format: unknown \"xml\"",
+		"log.backup-count: negative",
+		"log.error-file: " + dir + " is a directory",
+	}, msgs)
+}
+
+func TestValidateBlobEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Encryption.Enable = true
+	conf.Encryption.KMS = "vault"
+	conf.Encryption.Primary = "k1"
+	conf.Blob.Name = "s3"
+	conf.Blob.Bucket = "tirest"
+	errs := conf.Validate()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "blob.name: s3 blobs aren't sealed, use chunk with encryption.enable", errs[0].Error())
+
+	conf.Blob.Name = "chunk"
+	assert.Empty(t, conf.Validate())
+}
+
+func TestValidateCors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Cors.Enable = true
+	conf.Cors.AllowCredentials = true
+	errs := conf.Validate()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, `cors.allow-origins: "*" with allow-credentials`, errs[0].Error())
+
+	conf.Cors.AllowOrigins = []string{"https://app.example.com", "*.example.com"}
+	assert.Empty(t, conf.Validate())
+}
+
+func TestValidateClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Clusters = []Cluster{{ID: "staging"}, {ID: "a/b"}, {ID: "staging", Store: Store{Name: "etcd", Path: "tikv://a"}}}
+	errs := conf.Validate()
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"cluster[1].id: \"a/b\" not letters, digits, - or _",
+		"cluster[2].id: duplicate \"staging\"",
+		"cluster[2].path: invalid etcd path \"tikv://a\"",
+	}, msgs)
+
+	c := conf.ClusterConfig(&conf.Clusters[0])
+	assert.Equal(t, "tikv", c.Store.Name)
+	assert.Equal(t, conf.Connector.Topic+"-staging", c.Connector.Topic)
+	assert.Equal(t, filepath.Join(dir, "staging"), c.Connector.QueueDataPath)
+	assert.Nil(t, c.Clusters)
+	// the slices and pointers of a cluster aren't the proxy's
+	c.Admin.Tokens = append(c.Admin.Tokens[:0], "cluster")
+	c.Store.ReadTimeout.Duration = time.Hour
+	c.Connector.BrokerList[0] = "10.0.0.1:9092"
+	assert.Empty(t, conf.Admin.Tokens)
+	assert.NotEqual(t, time.Hour, conf.Store.ReadTimeout.Duration)
+	assert.Equal(t, DefaultConfig().Connector.BrokerList, conf.Connector.BrokerList)
+}
+
+func TestValidateFault(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Fault.Enable = true
+	conf.Fault.Rules = []FaultRule{
+		{Target: "db", Ops: []string{"get", "send"}, Probability: 0.5, Error: "unavailable", Partial: true},
+		{Target: "connector", Probability: 2, Latency: &Duration{-time.Second}},
+		{Target: "cache", Error: "gone"},
+	}
+	errs := conf.Validate()
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"fault.rule[0].ops: unknown \"send\" of db",
+		"fault.rule[1].probability: 2 not in [0, 1]",
+		"fault.rule[1].latency: negative duration -1s",
+		"fault.rule[1]: neither latency nor error",
+		"fault.rule[2].target: unknown \"cache\"",
+		"fault.rule[2].error: unknown \"gone\"",
+	}, msgs)
+}
+
+func TestDecode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "server.toml")
+
+	// the old keys still load
+	assert.Nil(t, ioutil.WriteFile(file, []byte("[connector]\n  entry = 3\n[log]\n  backup_count = 4\n"+
+		"  backup-count = 5\n"), 0644))
+	conf := DefaultConfig()
+	_, warnings, err := decode(file, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"connector.entry is deprecated, use connector.retry",
+		"log.backup_count is deprecated, use log.backup-count"}, warnings)
+	assert.Equal(t, 3, conf.Connector.Retry)
+	assert.Equal(t, 5, conf.Log.BackupCount)
+	keys, err := UndecodedKeys(file)
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	// a value of the wrong type is reported with its key
+	for data, msg := range map[string]string{
+		"[server]\n  http-port = \"6100\"\n":              "server.http-port: cannot load TOML value of type string into a Go integer",
+		"[store]\n  read-timeout = \"5x\"\n":              "store.read-timeout: time: unknown unit \"x\" in duration \"5x\"",
+		"[[tier]]\n  prefix = \"a/\"\n  pd-address = 1\n": "tier[0].pd-address: cannot load TOML value of type int64 into a Go slice",
+	} {
+		assert.Nil(t, ioutil.WriteFile(file, []byte(data), 0644))
+		assert.EqualError(t, DefaultConfig().ReadFromFile(file), msg)
+	}
+}
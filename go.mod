@@ -1,31 +1,49 @@
 module github.com/huangnauh/tirest
 
-go 1.13
+go 1.16
 
 require (
-	github.com/BurntSushi/toml v0.3.1
-	github.com/DeanThompson/ginpprof v0.0.0-20190408063150-3be636683586
-	github.com/Shopify/sarama v1.26.4
+	github.com/BurntSushi/toml v1.1.0
+	github.com/Shopify/sarama v1.37.2
+	github.com/Shopify/toxiproxy v2.1.4+incompatible // indirect
+	github.com/aws/aws-sdk-go v1.30.24
+	github.com/frankban/quicktest v1.7.2 // indirect
+	github.com/gin-contrib/sse v0.1.0
 	github.com/gin-gonic/gin v1.6.3
-	github.com/golang/protobuf v1.3.4
+	github.com/go-ldap/ldap/v3 v3.1.10
+	github.com/golang/protobuf v1.5.2
 	github.com/google/gopacket v1.1.18
-	github.com/json-iterator/go v1.1.9
+	github.com/json-iterator/go v1.1.12
 	github.com/mozillazg/go-httpheader v0.2.1
 	github.com/nsqio/go-diskqueue v1.0.0
+	github.com/pierrec/lz4 v2.4.1+incompatible // indirect
 	github.com/pingcap/kvproto v0.0.0-20200706115936-1e0910aabe6c
 	github.com/pingcap/pd/v4 v4.0.0-rc.2.0.20200714122454-1a64f969cb3c
 	github.com/pingcap/tidb v1.1.0-beta.0.20200731004449-a63fa79d90c5
-	github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/client_golang v1.13.0
 	github.com/sirupsen/logrus v1.6.1-0.20200528085638-6699a89a232f
-	github.com/stretchr/testify v1.5.1
+	github.com/stretchr/testify v1.8.0
 	github.com/tikv/client-go v0.0.0-20200513031230-7253be23eb15
-	github.com/urfave/cli/v2 v2.1.1
+	github.com/urfave/cli/v2 v2.11.0
+	github.com/xdg/scram v0.0.0-20180814205039-7eeb5667e42c // indirect
+	github.com/xdg/stringprep v1.0.0 // indirect
 	go.etcd.io/etcd v0.5.0-alpha.5.0.20191023171146-3cf2f69b5738
-	golang.org/x/net v0.0.0-20200520182314-0ba52f642ac2
-	golang.org/x/sys v0.0.0-20200808120158-1030fc2bf1d9 // indirect
+	golang.org/x/net v0.0.0-20220927171203-f486391704dc
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
+	google.golang.org/grpc v1.31.0
+	gopkg.in/jcmturner/aescts.v1 v1.0.1 // indirect
+	gopkg.in/jcmturner/dnsutils.v1 v1.0.1 // indirect
+	gopkg.in/jcmturner/goidentity.v3 v3.0.0 // indirect
+	gopkg.in/jcmturner/gokrb5.v7 v7.5.0 // indirect
+	gopkg.in/jcmturner/rpc.v1 v1.1.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 replace (
+	github.com/BurntSushi/toml => github.com/BurntSushi/toml v0.3.1
 	github.com/pingcap/tidb v1.1.0-beta.0.20200731004449-a63fa79d90c5 => github.com/huangnauh/tidb v1.1.0-beta.0.20200820055940-4b0a3daff769
+	github.com/prometheus/client_golang => github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/common => github.com/prometheus/common v0.9.1
 	github.com/sirupsen/logrus v1.6.1-0.20200528085638-6699a89a232f => github.com/huangnauh/logrus v1.6.2
+	google.golang.org/grpc => google.golang.org/grpc v1.26.0
 )
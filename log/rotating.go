@@ -30,6 +30,8 @@ type RotatingOuter struct {
 	backupCount int
 	bufferSize  int
 	format      logrus.Formatter
+	interval    time.Duration
+	period      time.Time // the interval the current file belongs to
 }
 
 type FileFlush struct {
@@ -79,8 +81,10 @@ func NewBufferWriter(fd *os.File, size int) WriteFlushCloser {
 	}
 }
 
+// NewRotatingOuter writes to fileName and renames it to fileName.1 once it
+// reaches maxBytes or, with a positive interval, once the interval is over.
 func NewRotatingOuter(fileName string, bufferSize int, maxBytes int, backupCount int,
-	format logrus.Formatter) (*RotatingOuter, error) {
+	interval time.Duration, format logrus.Formatter) (*RotatingOuter, error) {
 	dir := path.Dir(fileName)
 	os.MkdirAll(dir, 0777)
 
@@ -103,6 +107,7 @@ func NewRotatingOuter(fileName string, bufferSize int, maxBytes int, backupCount
 	h.backupCount = backupCount
 	h.bufferSize = bufferSize
 	h.format = format
+	h.interval = interval
 
 	if err := h.openFile(); err != nil {
 		return nil, err
@@ -177,12 +182,17 @@ func (h *RotatingOuter) openFile() error {
 	}
 	h.nBytes = int(f.Size())
 	h.writer = NewBufferWriter(fd, h.bufferSize)
+	if h.interval > 0 {
+		h.period = time.Now().Truncate(h.interval)
+	}
 	return nil
 }
 
 func (h *RotatingOuter) syncWrite(p []byte) (n int, err error) {
 	if h.nBytes+len(p) >= h.maxBytes {
 		h.rotate()
+	} else if h.interval > 0 && h.nBytes > 0 && time.Now().Truncate(h.interval) != h.period {
+		h.rotate()
 	}
 	n, err = h.writer.Write(p)
 	h.nBytes += n
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var errorLevels = []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+
+func NewFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", FormatText:
+		return &logrus.TextFormatter{
+			TimestampFormat: "2006-01-02 15:04:05",
+			FullTimestamp:   true,
+		}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// levelHook fires the hook on the given levels only.
+type levelHook struct {
+	logrus.Hook
+	levels []logrus.Level
+}
+
+func (h levelHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+type closers []*RotatingOuter
+
+func (c closers) Close() error {
+	var err error
+	for _, h := range c {
+		if e := h.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Init sets up the standard logger: the level, the format, the main output,
+// i.e. stderr or a rotating file, the error file and syslog. Close the
+// returned closer to flush the files.
+func Init(conf *config.Log) (io.Closer, error) {
+	level, err := logrus.ParseLevel(conf.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+	format, err := NewFormatter(conf.Format)
+	if err != nil {
+		return nil, err
+	}
+	interval := conf.RotateInterval.Duration
+
+	var files closers
+	if conf.ErrorLogDir != "" {
+		hook, err := NewRotatingOuter(conf.ErrorLogDir, conf.BufferSize, conf.MaxBytes,
+			conf.BackupCount, interval, format)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, hook)
+
+		logrus.SetEntryBufferDisable(true)
+		logrus.SetFormatter(NullFormatter{})
+		logrus.SetOutput(ioutil.Discard)
+		logrus.AddHook(hook)
+	} else {
+		if conf.Format == FormatJSON {
+			logrus.SetFormatter(format)
+		}
+		logrus.SetOutput(os.Stderr)
+	}
+
+	if conf.ErrorFile != "" {
+		hook, err := NewRotatingOuter(conf.ErrorFile, conf.BufferSize, conf.MaxBytes,
+			conf.BackupCount, interval, format)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		files = append(files, hook)
+		logrus.AddHook(levelHook{Hook: hook, levels: errorLevels})
+	}
+
+	if conf.Syslog != "" {
+		hook, err := NewSyslogHook(conf.Syslog)
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		logrus.AddHook(hook)
+	}
+	return files, nil
+}
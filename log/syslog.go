@@ -0,0 +1,32 @@
+// +build !windows,!nacl,!plan9
+
+package log
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+
+	"github.com/huangnauh/tirest/version"
+	"github.com/sirupsen/logrus"
+	lsyslog "github.com/sirupsen/logrus/hooks/syslog"
+)
+
+const SyslogLocal = "local"
+
+// NewSyslogHook sends to the local syslog, which journald reads as well, or
+// to a remote one given as udp://host:port or tcp://host:port.
+func NewSyslogHook(addr string) (logrus.Hook, error) {
+	network, raddr := "", ""
+	if addr != SyslogLocal {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog %q", addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	return lsyslog.NewSyslogHook(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, version.APP)
+}
@@ -0,0 +1,15 @@
+// +build windows nacl plan9
+
+package log
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+const SyslogLocal = "local"
+
+func NewSyslogHook(addr string) (logrus.Hook, error) {
+	return nil, errors.New("syslog is not supported")
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
-	"fmt"
 	"github.com/urfave/cli/v2"
 	"github.com/huangnauh/tirest/commands"
+	_ "github.com/huangnauh/tirest/store/etcd"
+	_ "github.com/huangnauh/tirest/store/file"
 	_ "github.com/huangnauh/tirest/store/kafka"
+	_ "github.com/huangnauh/tirest/store/lru"
 	_ "github.com/huangnauh/tirest/store/newtikv"
+	_ "github.com/huangnauh/tirest/store/null"
+	_ "github.com/huangnauh/tirest/store/redis"
+	_ "github.com/huangnauh/tirest/store/s3"
 	//_ "github.com/huangnauh/tirest/store/tikv"
 	"github.com/huangnauh/tirest/version"
 	"os"
-	"runtime"
 	"sort"
 )
 
@@ -19,9 +23,7 @@ func main() {
 
 func realMain() int {
 	app := cli.NewApp()
-	app.Version = fmt.Sprintf("%s (%s), api:%s, runtime:%s/%s %s", version.GitDescribe,
-		version.GitCommit, version.API,
-		runtime.GOOS, runtime.GOARCH, runtime.Version())
+	app.Version = version.Get().String()
 	app.Usage = "a HTTP server for TiKV"
 	app.Commands = commands.AllCommands()
 	app.EnableBashCompletion = true
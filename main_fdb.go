@@ -0,0 +1,7 @@
+// +build fdb
+
+package main
+
+import (
+	_ "github.com/huangnauh/tirest/store/fdb"
+)
@@ -113,13 +113,13 @@ func (m *Metric) handlerFunc(abnormal bool, slowRequest time.Duration) gin.Handl
 			msg, _ = val.(string)
 		}
 
-		logger.Infof("%s %s %s %s %d %d %d %s '%s'\n", c.Request.RemoteAddr,
+		logger.Infof("%s %s %s %s %d %d %d %s '%s' %s\n", c.Request.RemoteAddr,
 			now.Format("2006-01-02T15:04:05.999"), c.Request.Method, c.Request.URL,
-			respStatus, requestSize, responseSize, latency, msg)
+			respStatus, requestSize, responseSize, latency, msg, GetRequestId(c))
 	}
 }
 
-func InitLog(fileName string, bufferSize int, maxBytes int, backupCount int) error {
+func InitLog(fileName string, bufferSize int, maxBytes int, backupCount int, interval time.Duration) error {
 	if fileName == "" {
 		logger = logrus.StandardLogger()
 		return nil
@@ -129,7 +129,7 @@ func InitLog(fileName string, bufferSize int, maxBytes int, backupCount int) err
 
 	var err error
 	logWriter, err = log.NewRotatingOuter(fileName, bufferSize, maxBytes,
-		backupCount, log.OriginFormatter{})
+		backupCount, interval, log.OriginFormatter{})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+)
+
+const AccessTokenHeader = "X-Access-Token"
+
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+	// PermSensitiveRead reads the encrypted fields in the clear
+	PermSensitiveRead
+)
+
+func ParsePermission(s string) (Permission, error) {
+	var p Permission
+	for _, c := range s {
+		switch c {
+		case 'r':
+			p |= PermRead
+		case 'w':
+			p |= PermWrite
+		case 's':
+			p |= PermSensitiveRead
+		default:
+			return 0, fmt.Errorf("invalid permission %q", s)
+		}
+	}
+	if p == 0 {
+		return 0, fmt.Errorf("empty permission")
+	}
+	return p, nil
+}
+
+type aclRule struct {
+	token  string
+	group  string
+	ipNet  *net.IPNet
+	prefix []byte
+	perm   Permission
+}
+
+func parseIP(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func parseRule(r config.ACLRule) (aclRule, error) {
+	rule := aclRule{token: r.Token, group: r.Group, prefix: []byte(r.Prefix)}
+	if r.Token == "" && r.Group == "" && r.IP == "" {
+		return rule, fmt.Errorf("rule for %q needs a token, a group or an ip", r.Prefix)
+	}
+	var err error
+	if r.IP != "" {
+		rule.ipNet, err = parseIP(r.IP)
+		if err != nil {
+			return rule, err
+		}
+	}
+	rule.perm, err = ParsePermission(r.Permission)
+	return rule, err
+}
+
+func (r *aclRule) match(token string, groups []string, ip net.IP) bool {
+	if r.token != "" && r.token != token {
+		return false
+	}
+	if r.group != "" && !hasGroup(groups, r.group) {
+		return false
+	}
+	if r.ipNet != nil && (ip == nil || !r.ipNet.Contains(ip)) {
+		return false
+	}
+	return true
+}
+
+func hasGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixEnd is the first key after all keys with prefix, nil means no end.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// cover reports whether [start, end) only has keys under the rule prefix,
+// a nil end is a single key.
+func (r *aclRule) cover(start, end []byte) bool {
+	if !bytes.HasPrefix(start, r.prefix) {
+		return false
+	}
+	if end == nil {
+		return true
+	}
+	pe := prefixEnd(r.prefix)
+	return pe == nil || bytes.Compare(end, pe) <= 0
+}
+
+// ACL decides by the longest prefix among the rules of the requester,
+// requests without a rule follow DefaultDeny.
+type ACL struct {
+	mu          sync.RWMutex
+	defaultDeny bool
+	rules       []aclRule
+	conf        []config.ACLRule
+}
+
+func NewACL(conf *config.ACL) (*ACL, error) {
+	a := &ACL{}
+	err := a.SetRules(conf.DefaultDeny, conf.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *ACL) SetRules(defaultDeny bool, conf []config.ACLRule) error {
+	rules := make([]aclRule, 0, len(conf))
+	for _, r := range conf {
+		rule, err := parseRule(r)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, rule)
+	}
+	a.mu.Lock()
+	a.defaultDeny = defaultDeny
+	a.rules = rules
+	a.conf = append([]config.ACLRule(nil), conf...)
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *ACL) Rules() (bool, []config.ACLRule) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.defaultDeny, append([]config.ACLRule(nil), a.conf...)
+}
+
+// Allow checks the request with token, of a user in groups, from ip.
+func (a *ACL) Allow(token string, groups []string, ip net.IP, perm Permission, start, end []byte) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	longest := -1
+	allowed := false
+	for i := range a.rules {
+		r := &a.rules[i]
+		if !r.match(token, groups, ip) || !r.cover(start, end) {
+			continue
+		}
+		if len(r.prefix) > longest {
+			longest = len(r.prefix)
+			allowed = false
+		}
+		if len(r.prefix) == longest && r.perm&perm == perm {
+			allowed = true
+		}
+	}
+	// the encrypted fields are only read in the clear by a rule
+	if longest < 0 {
+		return !a.defaultDeny && perm&PermSensitiveRead == 0
+	}
+	return allowed
+}
+
+func AccessToken(c *gin.Context) string {
+	if token := c.GetHeader(AccessTokenHeader); token != "" {
+		return token
+	}
+	auth := c.GetHeader("Authorization")
+	if strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimPrefix(auth, bearerPrefix)
+	}
+	return ""
+}
+
+// RemoteIP is the peer address, forwarded headers are not trusted.
+func RemoteIP(c *gin.Context) net.IP {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL(t *testing.T) {
+	a, err := NewACL(&config.ACL{
+		DefaultDeny: true,
+		Rules: []config.ACLRule{
+			{Token: "a", Prefix: "users/", Permission: "rw"},
+			{Token: "a", Prefix: "config/", Permission: "r"},
+			{Token: "a", Prefix: "users/admin/", Permission: "r"},
+			{IP: "10.0.0.0/8", Prefix: "", Permission: "r"},
+			{IP: "192.168.1.1", Prefix: "public/", Permission: "rw"},
+			{Group: "ops", Prefix: "config/", Permission: "rw"},
+		},
+	})
+	assert.Nil(t, err)
+
+	ip := net.ParseIP("127.0.0.1")
+	assert.True(t, a.Allow("a", nil, ip, PermWrite, []byte("users/1"), nil))
+	assert.True(t, a.Allow("a", nil, ip, PermRead, []byte("config/1"), nil))
+	assert.False(t, a.Allow("a", nil, ip, PermWrite, []byte("config/1"), nil))
+	assert.False(t, a.Allow("a", nil, ip, PermWrite, []byte("users/admin/1"), nil))
+	assert.False(t, a.Allow("a", nil, ip, PermRead, []byte("other/1"), nil))
+	assert.False(t, a.Allow("b", nil, ip, PermRead, []byte("users/1"), nil))
+
+	// ranges must stay under the prefix
+	assert.True(t, a.Allow("a", nil, ip, PermRead, []byte("users/"), []byte("users0")))
+	assert.False(t, a.Allow("a", nil, ip, PermRead, []byte("users/"), []byte("usert")))
+
+	assert.True(t, a.Allow("", nil, net.ParseIP("10.1.2.3"), PermRead, []byte("any"), []byte("anz")))
+	assert.False(t, a.Allow("", nil, net.ParseIP("10.1.2.3"), PermWrite, []byte("any"), nil))
+	assert.True(t, a.Allow("", nil, net.ParseIP("192.168.1.1"), PermWrite, []byte("public/x"), nil))
+	assert.False(t, a.Allow("", nil, net.ParseIP("192.168.1.2"), PermWrite, []byte("public/x"), nil))
+
+	// the groups of an authenticated user
+	assert.True(t, a.Allow("", []string{"dev", "ops"}, ip, PermWrite, []byte("config/1"), nil))
+	assert.False(t, a.Allow("", []string{"dev"}, ip, PermRead, []byte("config/1"), nil))
+
+	err = a.SetRules(false, []config.ACLRule{{Token: "a", Prefix: "config/", Permission: "r"}})
+	assert.Nil(t, err)
+	assert.True(t, a.Allow("b", nil, ip, PermWrite, []byte("config/1"), nil))
+	assert.False(t, a.Allow("a", nil, ip, PermWrite, []byte("config/1"), nil))
+
+	assert.NotNil(t, a.SetRules(false, []config.ACLRule{{Prefix: "config/", Permission: "r"}}))
+	assert.NotNil(t, a.SetRules(false, []config.ACLRule{{Token: "a", Permission: "x"}}))
+	assert.NotNil(t, a.SetRules(false, []config.ACLRule{{IP: "1.2.3", Permission: "r"}}))
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("b"), prefixEnd([]byte("a")))
+	assert.Equal(t, []byte{0x01}, prefixEnd([]byte{0x00, 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	assert.Nil(t, prefixEnd(nil))
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AdminTokenHeader = "X-Admin-Token"
+	bearerPrefix     = "Bearer "
+	identityKey      = "identity"
+)
+
+// ErrAuthUnavailable is returned by an Authenticator which can't reach its
+// provider, the credentials may be valid.
+var ErrAuthUnavailable = errors.New("auth provider unavailable")
+
+// Identity is the user behind a request, authenticated by Provider, its
+// groups are matched by the group of the ACL rules.
+type Identity struct {
+	Provider string
+	Subject  string
+	Groups   []string
+}
+
+// Authenticator resolves the identity of a request, nil without the
+// credentials it handles and an error for invalid ones.
+type Authenticator interface {
+	Name() string
+	Authenticate(c *gin.Context) (*Identity, error)
+}
+
+// Authenticate keeps the identity of the first provider which handles the
+// request, a request without credentials goes on with the static tokens.
+func Authenticate(providers ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, p := range providers {
+			id, err := p.Authenticate(c)
+			if errors.Is(err, ErrAuthUnavailable) {
+				AbortWithError(c, http.StatusServiceUnavailable, StatusCode(http.StatusServiceUnavailable),
+					err.Error(), gin.H{"provider": p.Name()})
+				return
+			} else if err != nil {
+				AbortWithError(c, http.StatusUnauthorized, StatusCode(http.StatusUnauthorized),
+					err.Error(), gin.H{"provider": p.Name()})
+				return
+			}
+			if id != nil {
+				c.Set(identityKey, id)
+				break
+			}
+		}
+		c.Next()
+	}
+}
+
+// GetIdentity is nil unless a provider authenticated the request.
+func GetIdentity(c *gin.Context) *Identity {
+	if v, ok := c.Get(identityKey); ok {
+		return v.(*Identity)
+	}
+	return nil
+}
+
+func requestToken(c *gin.Context) string {
+	return headerToken(c.Request.Header)
+}
+
+func headerToken(h http.Header) string {
+	if token := h.Get(AdminTokenHeader); token != "" {
+		return token
+	}
+	auth := h.Get("Authorization")
+	if strings.HasPrefix(auth, bearerPrefix) {
+		return strings.TrimPrefix(auth, bearerPrefix)
+	}
+	return ""
+}
+
+func validToken(token string, tokens []string) bool {
+	valid := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// IsAdmin tells if the request has one of the admin tokens, unlike AdminAuth
+// it's false when no token is configured.
+func IsAdmin(c *gin.Context, tokens []string) bool {
+	token := requestToken(c)
+	return token != "" && validToken(token, tokens)
+}
+
+// IsAdminRequest is IsAdmin for the handlers out of gin.
+func IsAdminRequest(req *http.Request, tokens []string) bool {
+	token := headerToken(req.Header)
+	return token != "" && validToken(token, tokens)
+}
+
+// AdminAuth rejects requests without one of the admin tokens,
+// every request passes when no token is configured.
+func AdminAuth(tokens []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(tokens) == 0 {
+			c.Next()
+			return
+		}
+		token := requestToken(c)
+		if token == "" || !validToken(token, tokens) {
+			AbortWithError(c, http.StatusUnauthorized, StatusCode(http.StatusUnauthorized), "invalid admin token", nil)
+			return
+		}
+		c.Next()
+	}
+}
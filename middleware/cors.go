@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CorsOption struct {
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func allowOrigin(origin string, origins []string) bool {
+	for _, o := range origins {
+		if o == "*" || o == origin {
+			return true
+		}
+		// *.example.com
+		if strings.HasPrefix(o, "*.") && strings.HasSuffix(origin, o[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func SetCors(opt CorsOption) gin.HandlerFunc {
+	methods := strings.Join(opt.AllowMethods, ", ")
+	headers := strings.Join(opt.AllowHeaders, ", ")
+	expose := strings.Join(opt.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(int(opt.MaxAge.Seconds()))
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		if !allowOrigin(origin, opt.AllowOrigins) {
+			if c.Request.Method == http.MethodOptions {
+				AbortWithError(c, http.StatusForbidden, StatusCode(http.StatusForbidden), "origin not allowed", nil)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		if opt.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if c.Request.Method != http.MethodOptions || c.GetHeader("Access-Control-Request-Method") == "" {
+			if expose != "" {
+				h.Set("Access-Control-Expose-Headers", expose)
+			}
+			c.Next()
+			return
+		}
+
+		// preflight
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", methods)
+		if headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if opt.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
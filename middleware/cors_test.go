@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SetCors(CorsOption{
+		AllowOrigins:     []string{"https://app.example.com", "*.example.org"},
+		AllowMethods:     []string{"GET", "PUT"},
+		AllowHeaders:     []string{"Content-Type", "X-Raw"},
+		ExposeHeaders:    []string{"X-Secondary"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.OPTIONS("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	do := func(method, origin, preflight string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight != "" {
+			r.Header.Set("Access-Control-Request-Method", preflight)
+		}
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// preflight
+	w := do(http.MethodOptions, "https://app.example.com", "PUT")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, PUT", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, X-Raw", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		w.Header()["Vary"])
+
+	// a subdomain of the wildcard, the credentials go to the origin matched
+	w = do(http.MethodGet, "https://a.example.org", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://a.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "X-Secondary", w.Header().Get("Access-Control-Expose-Headers"))
+
+	// the origins not allowed get no cors headers, and their preflight is refused
+	for _, origin := range []string{"https://evil.com", "https://example.org.evil.com", "https://app.example.com.evil.com"} {
+		w = do(http.MethodGet, origin, "")
+		assert.Equal(t, http.StatusOK, w.Code, origin)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"), origin)
+		w = do(http.MethodOptions, origin, "PUT")
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	// a request without an origin isn't cors
+	w = do(http.MethodGet, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Vary"))
+	// an OPTIONS without a request method isn't a preflight
+	w = do(http.MethodOptions, "https://app.example.com", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+}
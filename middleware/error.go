@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ErrorResponse struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestId string      `json:"request_id,omitempty"`
+}
+
+// StatusCode turns the status text into a code, e.g. 404 -> not_found.
+func StatusCode(status int) string {
+	if status == 499 {
+		return "client_closed_request"
+	}
+	text := http.StatusText(status)
+	if text == "" {
+		return "unknown"
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
+// ErrorBody is the error of the v2 api, Retryable tells the clients the
+// same request may succeed later.
+type ErrorBody struct {
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestId string      `json:"request_id,omitempty"`
+	Retryable bool        `json:"retryable"`
+}
+
+type ErrorResponseV2 struct {
+	Error ErrorBody `json:"error"`
+}
+
+const structuredErrors = "structured-errors"
+
+// StructuredErrors writes the errors of the requests under prefix in the v2
+// envelope, the ones of the middlewares included.
+func StructuredErrors(prefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, prefix) {
+			c.Set(structuredErrors, true)
+		}
+		c.Next()
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// AbortWithError writes the error envelope and stops the handler chain.
+func AbortWithError(c *gin.Context, status int, code, message string, details interface{}) {
+	c.Set(HttpMessage, message)
+	if c.GetBool(structuredErrors) {
+		c.AbortWithStatusJSON(status, ErrorResponseV2{Error: ErrorBody{
+			Status:    status,
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestId: GetRequestId(c),
+			Retryable: retryable(status),
+		}})
+		return
+	}
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestId: GetRequestId(c),
+	})
+}
@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	firewallPeers   = 65536
+	readOnlyNetwork = "read-only-network"
+)
+
+type network struct {
+	name     string
+	nets     []*net.IPNet
+	limit    rate.Limit
+	burst    int
+	readOnly bool
+}
+
+type peer struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// Firewall rejects the peers denied or out of the allowed networks and the
+// writes from a read-only network with 403, the requests over the rate of
+// a network with 429.
+type Firewall struct {
+	rulesMu    sync.RWMutex
+	allow      []*net.IPNet
+	deny       []*net.IPNet
+	networks   []*network
+	fallback   *network
+	readRoutes map[string]bool
+	mu         sync.Mutex
+	peers      map[string]*peer
+}
+
+func parseNets(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		ipNet, err := parseIP(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func NewFirewall(conf *config.Firewall) (*Firewall, error) {
+	f := &Firewall{readRoutes: make(map[string]bool), peers: make(map[string]*peer)}
+	if err := f.SetRules(conf); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetRules replaces the networks and the lists, the buckets of the peers
+// start again at the new rates.
+func (f *Firewall) SetRules(conf *config.Firewall) error {
+	allow, err := parseNets(conf.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseNets(conf.Deny)
+	if err != nil {
+		return err
+	}
+	var networks []*network
+	var fallback *network
+	for _, n := range conf.Networks {
+		nets, err := parseNets(n.CIDRs)
+		if err != nil {
+			return fmt.Errorf("network %s, %s", n.Name, err)
+		}
+		network := &network{name: n.Name, nets: nets, limit: rate.Limit(n.Rate), burst: n.Burst,
+			readOnly: n.ReadOnly}
+		networks = append(networks, network)
+		if n.Name == conf.Default {
+			fallback = network
+		}
+	}
+	if conf.Default != "" && fallback == nil {
+		return fmt.Errorf("no network %q", conf.Default)
+	}
+	f.rulesMu.Lock()
+	f.allow, f.deny, f.networks, f.fallback = allow, deny, networks, fallback
+	f.rulesMu.Unlock()
+	f.mu.Lock()
+	f.peers = make(map[string]*peer)
+	f.mu.Unlock()
+	return nil
+}
+
+// ReadRoute lets a read-only network reach a route reading with a write
+// method, the handler refuses its own writes by ReadOnlyNetwork.
+func (f *Firewall) ReadRoute(method, route string) {
+	f.rulesMu.Lock()
+	f.readRoutes[method+" "+route] = true
+	f.rulesMu.Unlock()
+}
+
+// ReadOnlyNetwork is the name of the read-only network of the peer, empty
+// for the others.
+func ReadOnlyNetwork(c *gin.Context) string {
+	return c.GetString(readOnlyNetwork)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Firewall) allowed(ip net.IP) bool {
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// network is nil for a peer out of every network without a default one.
+func (f *Firewall) network(ip net.IP) *network {
+	for _, n := range f.networks {
+		if containsIP(n.nets, ip) {
+			return n
+		}
+	}
+	return f.fallback
+}
+
+// limiter keeps a bucket by peer, the peers idle for a minute are dropped
+// when there are too many.
+func (f *Firewall) limiter(n *network, ip net.IP) *rate.Limiter {
+	key := n.name + "/" + ip.String()
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.peers[key]
+	if !ok {
+		if len(f.peers) >= firewallPeers {
+			for k, p := range f.peers {
+				if now.Sub(p.seen) > time.Minute {
+					delete(f.peers, k)
+				}
+			}
+			if len(f.peers) >= firewallPeers {
+				f.peers = make(map[string]*peer)
+			}
+		}
+		p = &peer{limiter: rate.NewLimiter(n.limit, n.burst)}
+		f.peers[key] = p
+	}
+	p.seen = now
+	return p.limiter
+}
+
+func readMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Check runs before the auth, a rejected request never reaches a provider.
+func (f *Firewall) Check(c *gin.Context) {
+	ip := RemoteIP(c)
+	f.rulesMu.RLock()
+	allowed, n := f.allowed(ip), f.network(ip)
+	readRoute := f.readRoutes[c.Request.Method+" "+c.FullPath()]
+	f.rulesMu.RUnlock()
+	if !allowed {
+		AbortWithError(c, http.StatusForbidden, StatusCode(http.StatusForbidden), "ip denied", nil)
+		return
+	}
+	if n == nil {
+		c.Next()
+		return
+	}
+	if n.readOnly && !readMethod(c.Request.Method) {
+		if !readRoute {
+			AbortWithError(c, http.StatusForbidden, StatusCode(http.StatusForbidden),
+				"network is read-only", gin.H{"network": n.name})
+			return
+		}
+		c.Set(readOnlyNetwork, n.name)
+	}
+	if n.limit > 0 && !f.limiter(n, ip).Allow() {
+		AbortWithError(c, http.StatusTooManyRequests, StatusCode(http.StatusTooManyRequests),
+			"rate limited", gin.H{"network": n.name})
+		return
+	}
+	c.Next()
+}
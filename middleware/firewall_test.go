@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirewall(t *testing.T) {
+	f, err := NewFirewall(&config.Firewall{
+		Enable:  true,
+		Deny:    []string{"10.9.0.0/16"},
+		Default: "external",
+		Networks: []config.Network{
+			{Name: "internal", CIDRs: []string{"10.0.0.0/8", "127.0.0.1"}},
+			{Name: "external", Rate: 1, Burst: 2, ReadOnly: true},
+		},
+	})
+	assert.Nil(t, err)
+	router := gin.New()
+	router.Use(f.Check)
+	router.Any("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.POST("/list", func(c *gin.Context) {
+		c.String(http.StatusOK, ReadOnlyNetwork(c))
+	})
+	f.ReadRoute(http.MethodPost, "/list")
+	path := "/"
+	do := func(method, ip string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "10.9.1.1"))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "10.1.1.1"))
+	}
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPut, "8.8.8.8"))
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "8.8.8.8"))
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "8.8.8.8"))
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "8.8.8.8"))
+	// each peer has its own bucket
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "8.8.4.4"))
+
+	// a read route takes a write method, the handler sees the network
+	path = "/list"
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "8.8.1.1"))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "10.1.1.1"))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.RemoteAddr = "8.8.2.2:1234"
+	router.ServeHTTP(w, r)
+	assert.Equal(t, "external", w.Body.String())
+	path = "/"
+
+	f, err = NewFirewall(&config.Firewall{Enable: true, Allow: []string{"127.0.0.0/8"}})
+	assert.Nil(t, err)
+	router = gin.New()
+	router.Use(f.Check)
+	router.Any("/", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "127.0.0.2"))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "10.1.1.1"))
+
+	_, err = NewFirewall(&config.Firewall{Enable: true, Default: "none"})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+)
+
+const (
+	hmacAlgorithm     = "AWS4-HMAC-SHA256"
+	hmacTerminator    = "aws4_request"
+	amzDateHeader     = "X-Amz-Date"
+	amzContentHeader  = "X-Amz-Content-Sha256"
+	amzDateFormat     = "20060102T150405Z"
+	hmacSeenCacheSize = 65536
+)
+
+// emptyHash is the body hash of a request without x-amz-content-sha256.
+var emptyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+var errBodyHash = errors.New("body doesn't match x-amz-content-sha256")
+
+// HMAC authenticates the requests signed like the AWS Signature Version 4
+// of S3 by the secret key of a tenant. The signature covers the method, the
+// path, the query, the signed headers and the body hash, a request with a
+// body sends its hash in x-amz-content-sha256, the body is checked while
+// it's read.
+type HMAC struct {
+	window time.Duration
+	keys   map[string]config.HMACKey
+	now    func() time.Time
+	mu     sync.Mutex
+	seen   map[string]time.Time
+}
+
+func NewHMAC(conf *config.HMAC) *HMAC {
+	h := &HMAC{
+		window: conf.Window.Duration,
+		keys:   make(map[string]config.HMACKey, len(conf.Keys)),
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+	}
+	for _, k := range conf.Keys {
+		h.keys[k.AccessKey] = k
+	}
+	return h
+}
+
+func (h *HMAC) Name() string {
+	return "hmac"
+}
+
+func (h *HMAC) Authenticate(c *gin.Context) (*Identity, error) {
+	auth := c.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, hmacAlgorithm+" ") {
+		return nil, nil
+	}
+	key, payload, err := h.verify(c.Request, auth[len(hmacAlgorithm)+1:])
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = &hashReader{ReadCloser: c.Request.Body, hash: sha256.New(), expected: payload}
+	return &Identity{Provider: h.Name(), Subject: key.AccessKey, Groups: key.Groups}, nil
+}
+
+// verify returns the key of the request and its body hash.
+func (h *HMAC) verify(r *http.Request, auth string) (config.HMACKey, string, error) {
+	var credential, signature string
+	var signed []string
+	for _, part := range strings.Split(auth, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return config.HMACKey{}, "", fmt.Errorf("invalid authorization %q", part)
+		}
+		switch kv[0] {
+		case "Credential":
+			credential = kv[1]
+		case "SignedHeaders":
+			signed = strings.Split(kv[1], ";")
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	// access key/date/region/service/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[4] != hmacTerminator {
+		return config.HMACKey{}, "", fmt.Errorf("invalid credential %q", credential)
+	}
+	key, ok := h.keys[scope[0]]
+	if !ok {
+		return config.HMACKey{}, "", fmt.Errorf("unknown access key %q", scope[0])
+	}
+
+	date := r.Header.Get(amzDateHeader)
+	t, err := time.Parse(amzDateFormat, date)
+	if err != nil {
+		return key, "", fmt.Errorf("invalid x-amz-date %q", date)
+	}
+	if scope[1] != date[:8] {
+		return key, "", fmt.Errorf("credential date %s isn't the x-amz-date", scope[1])
+	}
+	now := h.now()
+	if t.Before(now.Add(-h.window)) || t.After(now.Add(h.window)) {
+		return key, "", fmt.Errorf("x-amz-date %s out of %s", date, h.window)
+	}
+	if !contains(signed, "host") || !contains(signed, "x-amz-date") {
+		return key, "", errors.New("host and x-amz-date must be signed")
+	}
+	payload := r.Header.Get(amzContentHeader)
+	if payload == "" {
+		payload = emptyHash
+	} else if b, err := hex.DecodeString(payload); err != nil || len(b) != sha256.Size {
+		return key, "", fmt.Errorf("x-amz-content-sha256 %q isn't the hex sha256 of the body", payload)
+	}
+
+	canonical := sha256.Sum256([]byte(canonicalRequest(r, signed, payload)))
+	toSign := hmacAlgorithm + "\n" + date + "\n" + strings.Join(scope[1:], "/") + "\n" +
+		hex.EncodeToString(canonical[:])
+	signingKey := hmacSum([]byte("AWS4"+key.SecretKey), scope[1])
+	for _, s := range scope[2:] {
+		signingKey = hmacSum(signingKey, s)
+	}
+	expected := hex.EncodeToString(hmacSum(signingKey, toSign))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return key, "", errors.New("signature mismatch")
+	}
+	if !h.once(signature, t.Add(h.window)) {
+		return key, "", errors.New("signature already used")
+	}
+	return key, payload, nil
+}
+
+// once rejects a replay of a signature until its date leaves the window.
+func (h *HMAC) once(signature string, expireAt time.Time) bool {
+	now := h.now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.seen[signature]; ok && now.Before(e) {
+		return false
+	}
+	if len(h.seen) >= hmacSeenCacheSize {
+		for s, e := range h.seen {
+			if !now.Before(e) {
+				delete(h.seen, s)
+			}
+		}
+		if len(h.seen) >= hmacSeenCacheSize {
+			h.seen = make(map[string]time.Time)
+		}
+	}
+	h.seen[signature] = expireAt
+	return true
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalRequest(r *http.Request, signed []string, payload string) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	var b strings.Builder
+	b.WriteString(r.Method + "\n" + path + "\n" + canonicalQuery(r.URL.RawQuery) + "\n")
+	for _, name := range signed {
+		var value string
+		if name == "host" {
+			value = r.Host
+		} else {
+			values := r.Header[textproto.CanonicalMIMEHeaderKey(name)]
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(trimmed, ",")
+		}
+		b.WriteString(name + ":" + value + "\n")
+	}
+	b.WriteString("\n" + strings.Join(signed, ";") + "\n" + payload)
+	return b.String()
+}
+
+func canonicalQuery(raw string) string {
+	query, _ := url.ParseQuery(raw)
+	pairs := make([][2]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, [2]string{uriEscape(k), uriEscape(v)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	joined := make([]string, len(pairs))
+	for i, p := range pairs {
+		joined[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(joined, "&")
+}
+
+// uriEscape keeps only the unreserved characters of RFC 3986.
+func uriEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hashReader fails the last read of a body which doesn't match its hash.
+type hashReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (r *hashReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.expected {
+		return n, errBodyHash
+	}
+	return n, err
+}
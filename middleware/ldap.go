@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/huangnauh/tirest/config"
+)
+
+const ldapCacheSize = 1024
+
+type ldapEntry struct {
+	id       *Identity
+	expireAt time.Time
+}
+
+// LDAP authenticates the basic auth of a request by a bind of the user,
+// the groups are the first value of the group DNs, e.g. ops for
+// cn=ops,ou=groups,dc=example,dc=com.
+type LDAP struct {
+	conf  config.LDAP
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]ldapEntry
+}
+
+func NewLDAP(conf *config.LDAP) *LDAP {
+	return &LDAP{conf: *conf, cache: make(map[[sha256.Size]byte]ldapEntry)}
+}
+
+func (l *LDAP) Name() string {
+	return "ldap"
+}
+
+func (l *LDAP) Authenticate(c *gin.Context) (*Identity, error) {
+	user, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	// an empty password is an unauthenticated bind, which always succeeds
+	if user == "" || password == "" {
+		return nil, errors.New("empty user or password")
+	}
+	sum := sha256.Sum256([]byte(user + "\x00" + password))
+	now := time.Now()
+	l.mu.Lock()
+	e, ok := l.cache[sum]
+	l.mu.Unlock()
+	if ok && now.Before(e.expireAt) {
+		return e.id, nil
+	}
+
+	id, err := l.bind(user, password)
+	if err != nil || l.conf.CacheTTL.Duration <= 0 {
+		return id, err
+	}
+	l.mu.Lock()
+	if len(l.cache) >= ldapCacheSize {
+		for k, e := range l.cache {
+			if !now.Before(e.expireAt) {
+				delete(l.cache, k)
+			}
+		}
+		if len(l.cache) >= ldapCacheSize {
+			l.cache = make(map[[sha256.Size]byte]ldapEntry)
+		}
+	}
+	l.cache[sum] = ldapEntry{id: id, expireAt: now.Add(l.conf.CacheTTL.Duration)}
+	l.mu.Unlock()
+	return id, nil
+}
+
+func (l *LDAP) bind(user, password string) (*Identity, error) {
+	timeout := l.conf.Timeout.Duration
+	conn, err := ldap.DialURL(l.conf.URL, ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", ErrAuthUnavailable, err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(timeout)
+
+	if l.conf.BindDN != "" {
+		if err := conn.Bind(l.conf.BindDN, l.conf.BindPassword); err != nil {
+			return nil, fmt.Errorf("%w, bind %s, %s", ErrAuthUnavailable, l.conf.BindDN, err)
+		}
+	}
+	req := ldap.NewSearchRequest(l.conf.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(timeout/time.Second), false, fmt.Sprintf(l.conf.UserFilter, ldap.EscapeFilter(user)),
+		[]string{l.conf.GroupAttr}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w, search %s, %s", ErrAuthUnavailable, user, err)
+	}
+	if len(res.Entries) != 1 {
+		return nil, errors.New("invalid user or password")
+	}
+	entry := res.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errors.New("invalid user or password")
+		}
+		return nil, fmt.Errorf("%w, bind %s, %s", ErrAuthUnavailable, user, err)
+	}
+
+	values := entry.GetAttributeValues(l.conf.GroupAttr)
+	groups := make([]string, 0, len(values))
+	for _, v := range values {
+		groups = append(groups, groupName(v))
+	}
+	return &Identity{Provider: l.Name(), Subject: user, Groups: groups}, nil
+}
+
+// groupName is the first value of a DN, a value which isn't a DN is kept.
+func groupName(v string) string {
+	dn, err := ldap.ParseDN(v)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return v
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,328 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+const (
+	// jwksMinRefresh limits the fetches for an unknown key id
+	jwksMinRefresh = 10 * time.Second
+	jwksTimeout    = 10 * time.Second
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// jwks caches the signing keys of an issuer by key id, they are fetched
+// again after refresh, or early for an unknown key id.
+type jwks struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func (s *jwks) fetch() error {
+	s.fetched = time.Now()
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return fmt.Errorf("%w, %s", ErrAuthUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w, get %s status %d", ErrAuthUnavailable, s.url, resp.StatusCode)
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("%w, decode %s, %s", ErrAuthUnavailable, s.url, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	s.keys = keys
+	return nil
+}
+
+// key keeps serving a cached key while the issuer can't be reached.
+func (s *jwks) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetched)
+	if ok && age < s.refresh {
+		return key, nil
+	}
+	if !ok && age < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if err := s.fetch(); err != nil {
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	key, ok = s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	invalid := errors.New("invalid signature")
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %q of a non rsa key", alg)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("alg %q of a non ec key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return invalid
+		}
+		return nil
+	}
+	// none and the hmac algs are never accepted
+	return fmt.Errorf("unsupported alg %q", alg)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// claimStrings reads a claim of a string, space separated like scope, or
+// of a list of strings.
+func claimStrings(v interface{}) []string {
+	switch c := v.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		values := make([]string, 0, len(c))
+		for _, s := range c {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+type oidcIssuer struct {
+	conf config.OIDCIssuer
+	keys *jwks
+}
+
+// OIDC authenticates the bearer JWTs of the issuers, a bearer which isn't
+// a JWT is left to the static tokens.
+type OIDC struct {
+	issuers map[string]*oidcIssuer
+	leeway  time.Duration
+	now     func() time.Time
+}
+
+func NewOIDC(conf *config.Auth) *OIDC {
+	client := &http.Client{Timeout: jwksTimeout}
+	o := &OIDC{
+		issuers: make(map[string]*oidcIssuer, len(conf.OIDC)),
+		leeway:  conf.Leeway.Duration,
+		now:     time.Now,
+	}
+	for _, c := range conf.OIDC {
+		if c.GroupsClaim == "" {
+			c.GroupsClaim = "groups"
+		}
+		o.issuers[c.Issuer] = &oidcIssuer{
+			conf: c,
+			keys: &jwks{url: c.JWKSURL, refresh: conf.JWKSRefresh.Duration, client: client},
+		}
+	}
+	return o
+}
+
+func (o *OIDC) Name() string {
+	return "oidc"
+}
+
+func (o *OIDC) Authenticate(c *gin.Context) (*Identity, error) {
+	token := AccessToken(c)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+	return o.verify(token)
+}
+
+func (o *OIDC) verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid jwt header, %s", err)
+	}
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid jwt claims, %s", err)
+	}
+	iss, _ := claims["iss"].(string)
+	issuer, ok := o.issuers[iss]
+	if !ok {
+		return nil, fmt.Errorf("unknown issuer %q", iss)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt signature, %s", err)
+	}
+	key, err := issuer.keys.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	now := o.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(o.leeway)) {
+		return nil, errors.New("jwt expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(o.leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("jwt not valid yet")
+	}
+	if aud := issuer.conf.Audience; aud != "" {
+		found := false
+		for _, a := range claimStrings(claims["aud"]) {
+			if a == aud {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("jwt not for audience %q", aud)
+		}
+	}
+	sub, _ := claims["sub"].(string)
+	return &Identity{
+		Provider: o.Name(),
+		Subject:  sub,
+		Groups:   claimStrings(claims[issuer.conf.GroupsClaim]),
+	}, nil
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.Nil(t, err)
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jwk{
+			{Kty: "RSA", Kid: "r1", Use: "sig", N: b64(rsaKey.N.Bytes()),
+				E: b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kty: "EC", Kid: "e1", Crv: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())},
+		}})
+	}))
+	defer ts.Close()
+
+	conf := config.DefaultConfig().Auth
+	conf.OIDC = []config.OIDCIssuer{{Issuer: "https://idp", JWKSURL: ts.URL, Audience: "tirest"}}
+	o := NewOIDC(&conf)
+	now := time.Now()
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{"iss": "https://idp", "sub": "alice", "aud": []string{"tirest"},
+			"exp": now.Add(time.Hour).Unix(), "groups": []string{"dev", "ops"}}
+	}
+
+	id, err := o.verify(signJWT(t, "RS256", "r1", rsaKey, claims()))
+	assert.Nil(t, err)
+	assert.Equal(t, &Identity{Provider: "oidc", Subject: "alice", Groups: []string{"dev", "ops"}}, id)
+	_, err = o.verify(signJWT(t, "ES256", "e1", ecKey, claims()))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fetches)
+
+	// a tampered payload
+	token := signJWT(t, "RS256", "r1", rsaKey, claims())
+	c := claims()
+	c["groups"] = []string{"admin"}
+	forged := signJWT(t, "RS256", "r1", rsaKey, c)
+	parts, forgedParts := strings.Split(token, "."), strings.Split(forged, ".")
+	_, err = o.verify(parts[0] + "." + forgedParts[1] + "." + parts[2])
+	assert.NotNil(t, err)
+	// the alg must match the key
+	_, err = o.verify(signJWT(t, "ES256", "r1", ecKey, claims()))
+	assert.NotNil(t, err)
+
+	c = claims()
+	c["exp"] = now.Add(-2 * time.Minute).Unix()
+	_, err = o.verify(signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.NotNil(t, err)
+	c = claims()
+	c["aud"] = "other"
+	_, err = o.verify(signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.NotNil(t, err)
+	c = claims()
+	c["iss"] = "https://other"
+	_, err = o.verify(signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.NotNil(t, err)
+
+	// an unknown key id is fetched again, but not too often
+	_, err = o.verify(signJWT(t, "RS256", "r2", rsaKey, claims()))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, fetches)
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIdHeader = "X-Request-Id"
+	RequestId       = "request_id"
+	maxRequestIdLen = 128
+)
+
+func newRequestId() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRequestId keeps the X-Request-Id sent by the client or generates one,
+// the id is echoed in the response and kept in the context for logs.
+func SetRequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		c.Set(RequestId, id)
+		c.Header(RequestIdHeader, id)
+		c.Next()
+	}
+}
+
+func GetRequestId(c *gin.Context) string {
+	return c.GetString(RequestId)
+}
@@ -0,0 +1,277 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+// The fields of a request are bound by their tags, header for a header,
+// query for a query param and json for the body, default for the value of
+// a missing header or param, a pointer is left nil when missing, and
+// checked by the binding tag: required, and min and max of a number or of
+// the length of a string or a list, as gin's binding does. The fields of a
+// type are looked up once, a request only parses the values it has.
+
+const (
+	InHeader = "header"
+	InQuery  = "query"
+	InBody   = "body"
+)
+
+// FieldError is a field of a request failing to parse or to check.
+type FieldError struct {
+	In     string `json:"in"`
+	Field  string `json:"field"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+type field struct {
+	index    int
+	in       string
+	name     string
+	def      string
+	required bool
+	min      *float64
+	max      *float64
+}
+
+var fields sync.Map
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func parseRules(f *field, tag string) error {
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg := rule, ""
+		if i := strings.IndexByte(rule, '='); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "":
+		case "required":
+			f.required = true
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %s %q", f.name, name, arg)
+			}
+			if name == "min" {
+				f.min = &n
+			} else {
+				f.max = &n
+			}
+		default:
+			return fmt.Errorf("%s: unknown rule %s", f.name, name)
+		}
+	}
+	return nil
+}
+
+// fieldsOf are the bound fields of the struct t.
+func fieldsOf(t reflect.Type) ([]field, error) {
+	if fs, ok := fields.Load(t); ok {
+		return fs.([]field), nil
+	}
+	fs := make([]field, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		f := field{index: i, in: InBody, name: strings.Split(sf.Tag.Get("json"), ",")[0], def: sf.Tag.Get("default")}
+		if name := sf.Tag.Get("header"); name != "" {
+			f.in, f.name = InHeader, name
+		} else if name := sf.Tag.Get("query"); name != "" {
+			f.in, f.name = InQuery, name
+		}
+		if f.name == "" || f.name == "-" {
+			f.name = sf.Name
+		}
+		if err := parseRules(&f, sf.Tag.Get("binding")); err != nil {
+			return nil, fmt.Errorf("%s.%s", t, err)
+		}
+		fs = append(fs, f)
+	}
+	fields.Store(t, fs)
+	return fs, nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// check is the field of value f against its rules.
+func (f *field) check(v reflect.Value) *FieldError {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if f.required {
+				return &FieldError{In: f.in, Field: f.name, Reason: "required"}
+			}
+			return nil
+		}
+		v = v.Elem()
+	}
+	var n float64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		n = float64(v.Len())
+	}
+	reason := ""
+	switch {
+	case f.required && v.IsZero():
+		reason = "required"
+	case f.min != nil && n < *f.min:
+		reason = fmt.Sprintf("less than %v", *f.min)
+	case f.max != nil && n > *f.max:
+		reason = fmt.Sprintf("more than %v", *f.max)
+	default:
+		return nil
+	}
+	e := &FieldError{In: f.in, Field: f.name, Reason: reason}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Map {
+		e.Value = fmt.Sprint(v.Interface())
+	}
+	return e
+}
+
+func structOf(v interface{}) (reflect.Value, []field, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("bind %T, not a pointer to a struct", v)
+	}
+	fs, err := fieldsOf(rv.Elem().Type())
+	return rv.Elem(), fs, err
+}
+
+func bind(h http.Header, q url.Values, v interface{}) error {
+	rv, fs, err := structOf(v)
+	if err != nil {
+		return err
+	}
+	for i := range fs {
+		f := &fs[i]
+		var s string
+		switch f.in {
+		case InHeader:
+			s = h.Get(f.name)
+		case InQuery:
+			s = q.Get(f.name)
+		default:
+			continue
+		}
+		if s == "" {
+			s = f.def
+		}
+		if s == "" {
+			continue
+		}
+		if err = setValue(rv.Field(f.index), s); err != nil {
+			return &FieldError{In: f.in, Field: f.name, Value: s, Reason: err.Error()}
+		}
+	}
+	return Check(v)
+}
+
+// Bind sets the fields of the header and query tags of v, a pointer to a
+// struct, from r, and checks v.
+func Bind(r *http.Request, v interface{}) error {
+	return bind(r.Header, r.URL.Query(), v)
+}
+
+// BindHeader sets the fields of the header tags of v, and checks v.
+func BindHeader(h http.Header, v interface{}) error {
+	return bind(h, nil, v)
+}
+
+// BindJSON decodes data, the json body of a request, into v, an empty body
+// leaves v as is, and checks v.
+func BindJSON(data []byte, v interface{}) error {
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return &FieldError{In: InBody, Field: InBody, Reason: err.Error()}
+		}
+	}
+	return Check(v)
+}
+
+// Check checks the fields of v by their binding tags.
+func Check(v interface{}) error {
+	rv, fs, err := structOf(v)
+	if err != nil {
+		return err
+	}
+	for i := range fs {
+		f := &fs[i]
+		if !f.required && f.min == nil && f.max == nil {
+			continue
+		}
+		if e := f.check(rv.Field(f.index)); e != nil {
+			return e
+		}
+	}
+	return nil
+}
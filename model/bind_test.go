@@ -0,0 +1,92 @@
+package model
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/assert"
+)
+
+// the headers are bound as gin's header binding does
+func TestBindHeader(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Raw", "true")
+	r.Header.Set("X-Exact", "1")
+	r.Header.Set("X-Secondary", "b")
+	r.Header.Set("X-Ts", "42")
+	r.Header.Set("X-If-Unmodified-Since-Ts", "41")
+	r.Header.Set("X-Start", "a")
+	r.Header.Set("X-End", "z")
+	r.Header.Set("X-Limit", "10")
+	r.Header.Set("X-Reverse", "true")
+
+	m, expectedMeta := Meta{}, Meta{}
+	assert.Nil(t, BindHeader(r.Header, &m))
+	assert.Nil(t, binding.Header.Bind(r, &expectedMeta))
+	assert.Equal(t, expectedMeta, m)
+
+	l, expectedList := List{}, List{}
+	assert.Nil(t, Bind(r, &l))
+	assert.Nil(t, binding.Header.Bind(r, &expectedList))
+	assert.Equal(t, expectedList, l)
+	assert.Equal(t, 10, l.Limit)
+
+	r.Header.Set("X-Limit", "ten")
+	err := BindHeader(r.Header, &l)
+	assert.Equal(t, &FieldError{In: InHeader, Field: "X-Limit", Value: "ten",
+		Reason: `strconv.ParseInt: parsing "ten": invalid syntax`}, err)
+	r.Header.Set("X-Ts", "-1")
+	assert.NotNil(t, BindHeader(r.Header, &m))
+}
+
+type bindRequest struct {
+	Prefix   string        `query:"prefix" binding:"required,max=4"`
+	Limit    int           `query:"limit" default:"100" binding:"min=1,max=1000"`
+	Scatter  bool          `query:"scatter" default:"true"`
+	File     *int64        `query:"file" binding:"min=0"`
+	Duration time.Duration `query:"duration"`
+	Token    string        `header:"X-Token"`
+	Keys     []string      `json:"keys" binding:"max=2"`
+}
+
+func TestBind(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/?prefix=ab&duration=1m", nil)
+	r.Header.Set("X-Token", "t")
+	req := bindRequest{}
+	assert.Nil(t, Bind(r, &req))
+	assert.Equal(t, bindRequest{Prefix: "ab", Limit: 100, Scatter: true, Duration: time.Minute, Token: "t"}, req)
+
+	for query, expected := range map[string]*FieldError{
+		"/":                     {In: InQuery, Field: "prefix", Value: "", Reason: "required"},
+		"/?prefix=abcde":        {In: InQuery, Field: "prefix", Value: "abcde", Reason: "more than 4"},
+		"/?prefix=a&limit=0":    {In: InQuery, Field: "limit", Value: "0", Reason: "less than 1"},
+		"/?prefix=a&limit=1001": {In: InQuery, Field: "limit", Value: "1001", Reason: "more than 1000"},
+		"/?prefix=a&file=-1":    {In: InQuery, Field: "file", Value: "-1", Reason: "less than 0"},
+		"/?prefix=a&scatter=no": {In: InQuery, Field: "scatter", Value: "no", Reason: `strconv.ParseBool: parsing "no": invalid syntax`},
+	} {
+		r, _ := http.NewRequest(http.MethodGet, query, nil)
+		assert.Equal(t, expected, Bind(r, &bindRequest{}), query)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/?prefix=a&file=0", nil)
+	req = bindRequest{}
+	assert.Nil(t, Bind(r, &req))
+	assert.Equal(t, int64(0), *req.File)
+
+	req = bindRequest{Prefix: "a", Limit: 1}
+	assert.Nil(t, BindJSON(nil, &req))
+	assert.Nil(t, BindJSON([]byte(`{"keys":["a","b"]}`), &req))
+	assert.Equal(t, []string{"a", "b"}, req.Keys)
+	err := BindJSON([]byte(`{"keys":["a","b","c"]}`), &req)
+	assert.Equal(t, &FieldError{In: InBody, Field: "keys", Reason: "more than 2"}, err)
+	err = BindJSON([]byte(`{"keys":`), &req)
+	assert.Equal(t, InBody, err.(*FieldError).In)
+
+	// not a pointer to a struct, an invalid rule
+	assert.NotNil(t, Bind(r, req))
+	assert.NotNil(t, Check(&struct {
+		N int `binding:"min=a"`
+	}{}))
+}
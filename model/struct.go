@@ -1,17 +1,89 @@
 package model
 
+import "reflect"
+
 type List struct {
 	Start   string `header:"X-Start" json:"start"`
 	End     string `header:"X-End" json:"end"`
-	Limit   int    `header:"X-Limit" json:"limit"`
+	Limit   int    `header:"X-Limit" json:"limit" binding:"min=0"`
 	Reverse bool   `header:"X-Reverse" json:"reverse"`
 	KeyOnly bool   `header:"X-Key-Only" json:"key-only"`
 	Unsafe  bool   `header:"X-Unsafe" json:"unsafe"`
 	Raw     bool   `header:"X-Raw" json:"raw"`
+	// MaxBytes is the byte budget of a list, Cursor the one of the last
+	// page of the same range
+	MaxBytes int64  `header:"X-Max-Bytes" json:"max-bytes" binding:"min=0"`
+	Cursor   string `header:"X-Cursor" json:"cursor"`
+	// body is set for the list of a v2 body, with the fields named by json
+	body bool
+}
+
+// Invalid is the error of the field of l with the json name, named as the
+// request gave it, a header or a field of the body.
+func (l *List) Invalid(name, value, reason string) *FieldError {
+	if l.body {
+		return &FieldError{In: InBody, Field: name, Value: value, Reason: reason}
+	}
+	t := reflect.TypeOf(*l)
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Tag.Get("json") == name {
+			name = f.Tag.Get("header")
+		}
+	}
+	return &FieldError{In: InHeader, Field: name, Value: value, Reason: reason}
+}
+
+// ListRequest is the body of a v2 list, Cursor is the cursor of the last
+// page of the same range.
+type ListRequest struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Limit   int    `json:"limit" binding:"min=0"`
+	Reverse bool   `json:"reverse"`
+	KeyOnly bool   `json:"key-only"`
+	Unsafe  bool   `json:"unsafe"`
+	Raw     bool   `json:"raw"`
+	Cursor  string `json:"cursor,omitempty"`
+	// MaxBytes is the byte budget of the page
+	MaxBytes int64 `json:"max-bytes,omitempty" binding:"min=0"`
+}
+
+func (r *ListRequest) List() *List {
+	return &List{Start: r.Start, End: r.End, Limit: r.Limit, Reverse: r.Reverse,
+		KeyOnly: r.KeyOnly, Unsafe: r.Unsafe, Raw: r.Raw, MaxBytes: r.MaxBytes, Cursor: r.Cursor, body: true}
 }
 
 type Meta struct {
-	Raw       bool   `header:"X-Raw" json:"raw"`
-	Exact     bool   `header:"X-Exact" json:"exact"`
-	Secondary string `header:"X-Secondary" json:"secondary"`
+	Raw             bool   `header:"X-Raw" json:"raw"`
+	Exact           bool   `header:"X-Exact" json:"exact"`
+	Secondary       string `header:"X-Secondary" json:"secondary"`
+	Ts              uint64 `header:"X-Ts" json:"ts"`
+	UnmodifiedSince uint64 `header:"X-If-Unmodified-Since-Ts" json:"unmodified-since"`
+}
+
+// BatchOp is a get or a put of a batch, a put is a cas from Old to New.
+type BatchOp struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Raw       bool   `json:"raw"`
+	Secondary string `json:"secondary,omitempty"`
+	Exact     bool   `json:"exact,omitempty"`
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+}
+
+type Batch struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+type Health struct {
+	Verbose bool `query:"verbose" json:"verbose"`
+}
+
+// Watch is the prefix of a watch, LastEventID the id of the last change
+// the client got before reconnecting.
+type Watch struct {
+	Prefix      string `query:"prefix" json:"prefix"`
+	Raw         bool   `query:"raw" json:"raw"`
+	LastEventID uint64 `header:"Last-Event-ID" json:"last-event-id"`
 }
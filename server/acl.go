@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+type aclRules struct {
+	DefaultDeny bool             `json:"default_deny"`
+	Rules       []config.ACLRule `json:"rules"`
+}
+
+// allow checks the token and the groups of the user authenticated.
+func (s *Server) allow(c *gin.Context, perm middleware.Permission, start, end []byte) bool {
+	var groups []string
+	if id := middleware.GetIdentity(c); id != nil {
+		groups = id.Groups
+	}
+	return s.acl.Allow(middleware.AccessToken(c), groups, middleware.RemoteIP(c), perm, start, end)
+}
+
+// reveal opens the encrypted fields of val for the sensitive-read permission,
+// without the ACL nobody may read them in the clear.
+func (s *Server) reveal(c *gin.Context, key, val []byte) []byte {
+	if s.acl == nil || len(val) == 0 || !s.allow(c, middleware.PermSensitiveRead, key[1:], nil) {
+		return val
+	}
+	opened, err := s.store.OpenFields(key, val)
+	if err != nil {
+		s.logger(c).Errorf("open fields of key %s failed, %s", key, err)
+		return val
+	}
+	return opened
+}
+
+func (s *Server) checkACL(c *gin.Context, perm middleware.Permission, start, end []byte) {
+	if s.allow(c, perm, start, end) {
+		c.Next()
+		return
+	}
+	s.deny(c)
+}
+
+func (s *Server) deny(c *gin.Context) {
+	s.logger(c).Warnf("access denied %s %s from %s", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
+	s.writeError(c, xerror.ErrAccessDenied, nil)
+}
+
+// metaACL checks the key of the meta routes, and the X-Secondary key of a
+// read, invalid keys are left to the handler.
+func (s *Server) metaACL(perm middleware.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.acl == nil {
+			c.Next()
+			return
+		}
+		l := &model.Meta{}
+		if err := model.BindHeader(c.Request.Header, l); err != nil {
+			c.Next()
+			return
+		}
+		key, err := EncodeMetaKey(c.Param("key"), l.Raw)
+		if err != nil {
+			c.Next()
+			return
+		}
+		if perm == middleware.PermRead && l.Secondary != "" {
+			secondary, err := EncodeMetaKey(l.Secondary, l.Raw)
+			if err == nil && !s.allow(c, perm, secondary[1:], nil) {
+				s.deny(c)
+				return
+			}
+		}
+		s.checkACL(c, perm, key[1:], nil)
+	}
+}
+
+// listACL checks the range of the list routes.
+func (s *Server) listACL(perm middleware.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.acl == nil {
+			c.Next()
+			return
+		}
+		l := &model.List{}
+		if err := model.BindHeader(c.Request.Header, l); err != nil {
+			c.Next()
+			return
+		}
+		start, end, err := s.getRangeFromList(l)
+		if err != nil {
+			c.Next()
+			return
+		}
+		s.checkACL(c, perm, start[1:], end[1:])
+	}
+}
+
+func (s *Server) GetACL(c *gin.Context) {
+	if s.acl == nil {
+		s.writeError(c, xerror.ErrNotSupported, gin.H{"reason": "acl is disabled"})
+		return
+	}
+	defaultDeny, rules := s.acl.Rules()
+	c.JSON(http.StatusOK, aclRules{DefaultDeny: defaultDeny, Rules: rules})
+}
+
+// SetACL replaces the rules until the next restart, the config file is not changed.
+func (s *Server) SetACL(c *gin.Context) {
+	if s.acl == nil {
+		s.writeError(c, xerror.ErrNotSupported, gin.H{"reason": "acl is disabled"})
+		return
+	}
+	rules := aclRules{}
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), nil)
+		return
+	}
+	if err := s.acl.SetRules(rules.DefaultDeny, rules.Rules); err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), nil)
+		return
+	}
+	s.logger(c).Infof("acl updated, default deny %t, %d rules", rules.DefaultDeny, len(rules.Rules))
+	c.Status(http.StatusNoContent)
+}
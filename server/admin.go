@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+)
+
+type hotKey struct {
+	Key   string  `json:"key"`
+	Count uint64  `json:"count"`
+	QPS   float64 `json:"qps"`
+}
+
+// hotKeysRequest is the number of keys, hotkey.top-n if missing.
+type hotKeysRequest struct {
+	N *int `query:"n" binding:"min=1"`
+}
+
+func (s *Server) HotKeys(c *gin.Context) {
+	req := &hotKeysRequest{N: &s.conf.HotKey.TopN}
+	if !s.bind(c, req) {
+		return
+	}
+
+	top := s.store.HotKeys(*req.N)
+	keys := make([]hotKey, 0, len(top))
+	for _, k := range top {
+		key, err := DecodeMetaKey(k.Key)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, hotKey{Key: encodeBase64(key), Count: k.Count, QPS: k.QPS})
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+type hotRegion struct {
+	ID       uint64   `json:"id"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	QPS      float64  `json:"qps"`
+	Hot      bool     `json:"hot"`
+	Keys     []hotKey `json:"keys"`
+	SplitKey string   `json:"split_key,omitempty"`
+}
+
+// HotRegions lists the regions of the keys written the most, the hottest
+// first, the region boundaries are as stored in base64.
+func (s *Server) HotRegions(c *gin.Context) {
+	found, err := s.store.HotRegions(c.Request.Context())
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	regions := make([]hotRegion, 0, len(found))
+	for _, r := range found {
+		region := hotRegion{ID: r.Region.ID, Start: encodeBase64(r.Region.Start),
+			End: encodeBase64(r.Region.End), QPS: r.QPS, Hot: r.Hot, Keys: make([]hotKey, 0, len(r.Keys))}
+		for _, k := range r.Keys {
+			key, err := DecodeMetaKey(k.Key)
+			if err != nil {
+				continue
+			}
+			region.Keys = append(region.Keys, hotKey{Key: encodeBase64(key), Count: k.Count, QPS: k.QPS})
+		}
+		if r.SplitKey != nil {
+			if key, err := DecodeMetaKey(r.SplitKey); err == nil {
+				region.SplitKey = encodeBase64(key)
+			}
+		}
+		regions = append(regions, region)
+	}
+	c.JSON(http.StatusOK, regions)
+}
+
+type conflict struct {
+	Key     string    `json:"key"`
+	Winner  string    `json:"winner"`
+	Local   string    `json:"local"`
+	LocalTs uint64    `json:"local_ts"`
+	Remote  store.Log `json:"remote"`
+}
+
+type conflictsRequest struct {
+	Since uint64 `query:"since"`
+	Limit int    `query:"limit" default:"1000" binding:"min=1,max=10000"`
+}
+
+// Conflicts lists the changes of another cluster applied concurrently
+// with a local one, stamped after since, oldest first.
+func (s *Server) Conflicts(c *gin.Context) {
+	req := &conflictsRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	found, err := s.store.ListConflicts(c.Request.Context(), req.Since, req.Limit)
+	if err != nil {
+		s.logger(c).Errorf("list conflicts failed, %s", err)
+		s.writeError(c, err, nil)
+		return
+	}
+	conflicts := make([]conflict, 0, len(found))
+	for _, f := range found {
+		key, err := DecodeMetaKey(f.Key)
+		if err != nil {
+			continue
+		}
+		conflicts = append(conflicts, conflict{Key: encodeBase64(key), Winner: f.Winner,
+			Local: f.Local, LocalTs: f.LocalTs, Remote: f.Remote})
+	}
+	c.JSON(http.StatusOK, conflicts)
+}
+
+func (s *Server) ListJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, s.jobs.List())
+}
+
+func (s *Server) Cluster(c *gin.Context) {
+	info, err := s.store.Cluster(c.Request.Context())
+	if err != nil {
+		s.logger(c).Errorf("get cluster info failed, %s", err)
+		var details interface{}
+		if info != nil && info.PD != nil {
+			details = gin.H{"pd": info.PD}
+		}
+		s.writeError(c, err, details)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
@@ -2,34 +2,33 @@ package server
 
 import (
 	"bytes"
-	"io/ioutil"
-	"net"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/huangnauh/tirest/middleware"
 	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
 	"github.com/huangnauh/tirest/utils"
-	"github.com/huangnauh/tirest/utils/json"
 	"github.com/huangnauh/tirest/xerror"
 )
 
 func (s *Server) Get(c *gin.Context) {
 	l := &model.Meta{}
-	if err := c.ShouldBindHeader(&l); err != nil {
-		s.log.Errorf("bind header, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !s.bind(c, l) {
 		return
 	}
 
 	keyStr := c.Param("key")
 	key, err := EncodeMetaKey(keyStr, l.Raw)
 	if err != nil {
-		s.log.Errorf("check key %s, err %s", keyStr, err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key"})
+		s.logger(c).Errorf("check key %s, err %s", keyStr, err)
+		s.writeError(c, xerror.ErrKeyInvalid, gin.H{"key": keyStr, "reason": err.Error()})
+		return
+	}
+	if !s.checkKeyLength(c, key) {
 		return
 	}
 
@@ -40,51 +39,54 @@ func (s *Server) Get(c *gin.Context) {
 	if l.Secondary != "" {
 		secondary, err := EncodeMetaKey(l.Secondary, l.Raw)
 		if err != nil {
-			s.log.Errorf("check secondary key %s, err %s", l.Secondary, err)
-			c.Set(middleware.HttpMessage, err.Error())
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid secondary"})
+			s.logger(c).Errorf("check secondary key %s, err %s", l.Secondary, err)
+			s.writeError(c, xerror.ErrKeyInvalid, gin.H{"secondary": l.Secondary, "reason": err.Error()})
 			return
 		}
 		opts.Secondary = secondary
 	}
 
-	v, err := s.store.Get(key, opts)
-	if err == xerror.ErrNotExists {
-		c.Status(http.StatusNotFound)
-	} else if err != nil {
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	v, err := s.store.GetStream(c.Request.Context(), key, opts)
+	if err != nil {
+		s.writeError(c, err, nil)
 	} else {
 		if v.Secondary {
 			c.Header("X-Secondary", "true")
 		}
-		c.Header("Content-Length", strconv.Itoa(len(v.Value)))
-		c.Data(http.StatusOK, "application/octet-stream", v.Value)
+		setTsHeader(c, v.Ts)
+		setSeqHeader(c, v.Seq)
+		if v.Reader != nil {
+			defer v.Reader.Close()
+			c.DataFromReader(http.StatusOK, v.Size, "application/octet-stream", v.Reader, nil)
+			return
+		}
+		if v.Secondary {
+			key = opts.Secondary
+		}
+		s.writeData(c, http.StatusOK, "application/octet-stream", s.reveal(c, key, v.Value))
 	}
 }
 
 func (s *Server) UnsafeDelete(c *gin.Context) {
 	l := &model.Meta{}
-	if err := c.ShouldBindHeader(&l); err != nil {
-		s.log.Errorf("bind header, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !s.bind(c, l) {
 		return
 	}
 
 	keyStr := c.Param("key")
 	key, err := EncodeMetaKey(keyStr, l.Raw)
 	if err != nil {
-		s.log.Errorf("check key %s, err %s", keyStr, err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key"})
+		s.logger(c).Errorf("check key %s, err %s", keyStr, err)
+		s.writeError(c, xerror.ErrKeyInvalid, gin.H{"key": keyStr, "reason": err.Error()})
+		return
+	}
+	if !s.checkKeyLength(c, key) {
 		return
 	}
 
-	err = s.store.UnsafePut(key, nil)
+	err = s.store.UnsafePut(c.Request.Context(), key, nil)
 	if err != nil {
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		s.writeError(c, err, nil)
 	} else {
 		c.Status(http.StatusNoContent)
 	}
@@ -92,38 +94,91 @@ func (s *Server) UnsafeDelete(c *gin.Context) {
 
 func (s *Server) UnsafePut(c *gin.Context) {
 	l := &model.Meta{}
-	if err := c.ShouldBindHeader(&l); err != nil {
-		s.log.Errorf("bind header, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !s.bind(c, l) {
 		return
 	}
 
 	keyStr := c.Param("key")
 	key, err := EncodeMetaKey(keyStr, l.Raw)
 	if err != nil {
-		s.log.Errorf("check key %s, err %s", keyStr, err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key"})
+		s.logger(c).Errorf("check key %s, err %s", keyStr, err)
+		s.writeError(c, xerror.ErrKeyInvalid, gin.H{"key": keyStr, "reason": err.Error()})
+		return
+	}
+	if !s.checkKeyLength(c, key) {
 		return
 	}
 
-	val, err := ioutil.ReadAll(c.Request.Body)
-	if err != nil {
-		s.log.Errorf("read body failed: %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		if e, ok := err.(net.Error); ok && e.Timeout() {
-			c.JSON(499, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		}
+	if conditional(l) {
+		s.conditionalPut(c, l, key)
+		return
+	}
+	if s.store.Streaming(key) && !s.validators.Match(key[1:]) {
+		s.unsafePutStream(c, key)
 		return
 	}
 
-	err = s.store.UnsafePut(key, val)
-	if err != nil {
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	val, ok := s.readBody(c, s.conf.Server.MaxValueSize)
+	if !ok {
+		return
+	}
+	if !s.validate(c, key, val) {
+		return
+	}
+
+	err = s.store.UnsafePut(c.Request.Context(), key, val)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		c.Status(http.StatusOK)
+	} else if err != nil {
+		s.writeError(c, err, nil)
+	} else {
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// conditionalPut is an unsafe put with X-Ts or X-If-Unmodified-Since-Ts, a
+// stale write from another cluster is ignored with 200.
+func (s *Server) conditionalPut(c *gin.Context, l *model.Meta, key []byte) {
+	opts := store.CheckOption{}
+	if !s.stampOption(c, l, &opts) {
+		return
+	}
+	val, ok := s.readBody(c, s.conf.Server.MaxValueSize)
+	if !ok {
+		return
+	}
+	if !s.validate(c, key, val) {
+		return
+	}
+
+	err := s.store.ConditionalPut(c.Request.Context(), key, val, opts)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		c.Status(http.StatusOK)
+	} else if err != nil {
+		s.writeError(c, err, nil)
+	} else {
+		setTsHeader(c, opts.Ts)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// unsafePutStream hands the body to the store as it's read, a large value
+// is never held in memory. A chunked body has no length, so the limit is
+// checked as it's read.
+func (s *Server) unsafePutStream(c *gin.Context, key []byte) {
+	limit := s.conf.Server.MaxValueSize
+	if limit > 0 && c.Request.ContentLength > limit {
+		s.writeBodyError(c, xerror.ErrValueTooLarge, limit)
+		return
+	}
+	body := &bodyReader{r: c.Request.Body, limit: limit}
+	err := s.store.UnsafePutStream(c.Request.Context(), key, body)
+	if body.err != nil {
+		s.writeBodyError(c, body.err, limit)
+	} else if errors.Is(err, xerror.ErrAlreadyExists) {
+		c.Status(http.StatusOK)
+	} else if err != nil {
+		s.writeError(c, err, nil)
 	} else {
 		c.Status(http.StatusNoContent)
 	}
@@ -131,19 +186,18 @@ func (s *Server) UnsafePut(c *gin.Context) {
 
 func (s *Server) CheckAndPut(c *gin.Context) {
 	l := &model.Meta{}
-	if err := c.ShouldBindHeader(&l); err != nil {
-		s.log.Errorf("bind header, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !s.bind(c, l) {
 		return
 	}
 
 	keyStr := c.Param("key")
 	key, err := EncodeMetaKey(keyStr, l.Raw)
 	if err != nil {
-		s.log.Errorf("check key %s, err %s", keyStr, err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid key"})
+		s.logger(c).Errorf("check key %s, err %s", keyStr, err)
+		s.writeError(c, xerror.ErrKeyInvalid, gin.H{"key": keyStr, "reason": err.Error()})
+		return
+	}
+	if !s.checkKeyLength(c, key) {
 		return
 	}
 
@@ -151,73 +205,180 @@ func (s *Server) CheckAndPut(c *gin.Context) {
 	if l.Exact {
 		opts.Check = ExactCheck
 	}
-
-	entry, err := ioutil.ReadAll(c.Request.Body)
-	if err != nil {
-		s.log.Errorf("read body failed: %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		if e, ok := err.(net.Error); ok && e.Timeout() {
-			c.JSON(499, gin.H{"error": err.Error()})
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		}
+	if !s.stampOption(c, l, &opts) {
 		return
 	}
 
-	err = s.store.CheckAndPut(key, entry, opts)
-	if err == xerror.ErrCheckAndSetFailed {
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	limit := s.conf.Server.MaxValueSize
+	if limit > 0 {
+		limit = 2*limit + casBodyOverhead
+	}
+	entry, ok := s.readBody(c, limit)
+	if !ok {
+		return
+	}
+	if !s.validateLog(c, key, entry) {
 		return
-	} else if err == xerror.ErrAlreadyExists {
+	}
+
+	var conflict *store.ConflictError
+	err = s.store.CheckAndPut(c.Request.Context(), key, entry, opts)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
 		c.Status(http.StatusOK)
 		return
+	} else if errors.Is(err, xerror.ErrValueTooLarge) {
+		s.writeError(c, err, gin.H{"limit": s.conf.Server.MaxValueSize})
+		return
+	} else if errors.As(err, &conflict) {
+		s.writeError(c, err, gin.H{
+			"exists":  len(conflict.Value) > 0,
+			"current": utils.B2S(s.reveal(c, key, conflict.Value)),
+			"read_ts": conflict.ReadTs,
+		})
+		return
 	} else if err != nil {
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		s.writeError(c, err, nil)
 		return
 	}
+	setTsHeader(c, opts.Ts)
 	c.Status(http.StatusNoContent)
 }
 
+// CursorHeader is the cursor of a list stopped over its bytes, the next
+// request with it gets the rest of the range.
+const CursorHeader = "X-Cursor"
+
+// getRangeFromList is the range of l, a reverse list may give its start
+// and its end in the order of the scan, the range is the same.
 func (s *Server) getRangeFromList(l *model.List) ([]byte, []byte, error) {
 	start, err := EncodeMetaKey(l.Start, l.Raw)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, xerror.ErrKeyInvalid.Wrap(l.Invalid("start", l.Start, err.Error()))
 	}
 	end, err := EncodeMetaKey(l.End, l.Raw)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, xerror.ErrKeyInvalid.Wrap(l.Invalid("end", l.End, err.Error()))
 	}
 
-	if bytes.Compare(start, end) >= 0 {
+	if len(end) == 1 {
+		return nil, nil, xerror.ErrListKVInvalid.Wrap(l.Invalid("end", l.End, "required"))
+	}
+	if l.Reverse && bytes.Compare(start, end) > 0 {
+		start, end = end, start
+	}
+	switch cmp := bytes.Compare(start, end); {
+	case cmp == 0:
+		return nil, nil, xerror.ErrListKVInvalid.Wrap(l.Invalid("end", l.End, "same as the start"))
+	case cmp > 0:
 		s.log.Errorf("list start %s > end %s", l.Start, l.End)
-		return nil, nil, xerror.ErrListKVInvalid
+		return nil, nil, xerror.ErrListKVInvalid.Wrap(l.Invalid("end", l.End, "before the start, without reverse"))
 	}
 	return start, end, nil
 }
 
+// checkList bounds the limit of l by server.max-list-limit, a missing
+// limit is the max, its bytes by server.max-list-bytes, server.list-bytes
+// if missing, and only takes unsafe for a delete with unsafe deletes
+// enabled.
+func (s *Server) checkList(l *model.List, del bool) error {
+	maxLimit := s.conf.Server.MaxListLimit
+	if l.Limit > maxLimit {
+		return xerror.ErrListKVInvalid.Wrap(l.Invalid("limit", strconv.Itoa(l.Limit), fmt.Sprintf("more than %d", maxLimit)))
+	}
+	if l.Limit == 0 {
+		l.Limit = maxLimit
+	}
+	maxBytes := s.conf.Server.MaxListBytes
+	if l.MaxBytes > maxBytes {
+		return xerror.ErrListKVInvalid.Wrap(l.Invalid("max-bytes", strconv.FormatInt(l.MaxBytes, 10),
+			fmt.Sprintf("more than %d", maxBytes)))
+	}
+	if l.MaxBytes == 0 {
+		l.MaxBytes = s.conf.Server.ListBytes
+	}
+	if l.Unsafe && !del {
+		return xerror.ErrListKVInvalid.Wrap(l.Invalid("unsafe", "true", "only for deletes"))
+	}
+	if l.Unsafe && !s.conf.Server.EnableUnsafeDelete {
+		return xerror.ErrUnsafeDeleteDisabled.WithCategory(xerror.InvalidArgument).Wrap(
+			l.Invalid("unsafe", "true", "server.enable-unsafe-delete is off"))
+	}
+	return nil
+}
+
 func (s *Server) List(c *gin.Context) {
 	l := &model.List{}
-	err := c.ShouldBindHeader(&l)
-	if err != nil {
-		s.log.Errorf("bind header, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !s.bind(c, l) {
+		return
+	}
+	if err := s.checkList(l, false); err != nil {
+		s.logger(c).Errorf("list invalid, err %s", err)
+		s.writeError(c, err, nil)
 		return
 	}
 
 	start, end, err := s.getRangeFromList(l)
 	if err != nil {
-		s.log.Errorf("list invalid, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		s.logger(c).Errorf("list invalid, err %s", err)
+		s.writeError(c, err, nil)
 		return
 	}
-	if l.Limit <= 0 || l.Limit > 10000 {
-		l.Limit = 10000
+	start, end, err = afterCursor(l.Cursor, l.Reverse, start, end)
+	if err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(l.Invalid("cursor", l.Cursor, err.Error())), nil)
+		return
+	}
+
+	// a list over its bytes ends with the cursor of its last key in
+	// X-Cursor, a trailer once the response is streamed
+	w := s.newStreamWriter(c, "application/json")
+	defer w.Release()
+	items := newItemWriter(w)
+	defer items.Release()
+	var last []byte
+	var over bool
+	if bytes.Compare(start, end) < 0 {
+		over, err = s.scanRange(c, l, start, end, func(item store.KeyValue) error {
+			last = append(last[:0], item.Key...)
+			return items.Write(item)
+		})
+	}
+	if err == nil {
+		err = items.Close()
+	}
+	if err == nil && over {
+		if w.Started() {
+			c.Header(http.TrailerPrefix+CursorHeader, encodeBase64(last))
+		} else {
+			c.Header(CursorHeader, encodeBase64(last))
+		}
 	}
-	s.log.Debugf("list (%s-%s), limit %d, reverse %t", start, end, l.Limit, l.Reverse)
+	s.endStream(c, w, err)
+}
+
+// endStream writes the error of a list if the response isn't sent yet.
+func (s *Server) endStream(c *gin.Context, w *streamWriter, err error) {
+	if err != nil && !w.Started() {
+		s.writeError(c, err, nil)
+		return
+	} else if err != nil {
+		s.logger(c).Errorf("list failed after the response started, %s", err)
+		return
+	}
+	if err := w.Close(); err != nil {
+		s.logger(c).Errorf("list failed, %s", err)
+	}
+}
+
+// errListBytes stops a scan over its bytes.
+var errListBytes = errors.New("list over its bytes")
+
+// scanRange calls fn with up to l.Limit items from start to end, l is
+// checked by checkList. The scan stops after the item going over
+// l.MaxBytes, with over set.
+func (s *Server) scanRange(c *gin.Context, l *model.List, start, end []byte,
+	fn func(store.KeyValue) error) (over bool, err error) {
+	s.logger(c).Debugf("list (%s-%s), limit %d, reverse %t", start, end, l.Limit, l.Reverse)
 
 	opts := DefaultListOption()
 	if l.KeyOnly {
@@ -228,94 +389,122 @@ func (s *Server) List(c *gin.Context) {
 		opts.Reverse = true
 	}
 
-	keyEntry, err := s.store.List(start, end, l.Limit, opts)
-	if err != nil {
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	jsonBytes, err := json.Marshal(keyEntry)
-	if err != nil {
-		s.log.Errorf("list failed, %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	// without the acl nothing is revealed, the values are left as they are
+	var key []byte
+	if !l.KeyOnly && s.acl != nil {
+		key = []byte{MetaType}
 	}
-	c.Header("Content-Length", strconv.Itoa(len(jsonBytes)))
-	c.Data(http.StatusOK, "application/json", jsonBytes)
+	var size int64
+	err = s.store.Scan(c.Request.Context(), start, end, l.Limit, opts, func(item store.KeyValue) error {
+		if key != nil {
+			key = append(key[:1], item.Key...)
+			item.Value = utils.B2S(s.reveal(c, key, utils.S2B(item.Value)))
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+		size += int64(len(item.Key) + len(item.Value))
+		if size >= l.MaxBytes {
+			return errListBytes
+		}
+		return nil
+	})
+	if err == errListBytes {
+		ListOverBytes.Inc()
+		s.logger(c).Infof("list (%s-%s) stopped at %d bytes", start, end, size)
+		return true, nil
+	}
+	return false, err
 }
 
 func (s *Server) AsyncBatchDelete(c *gin.Context) {
 	l := &model.List{}
-	err := c.ShouldBindHeader(&l)
-	if err != nil {
-		s.log.Errorf("bind header, err %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !s.bind(c, l) {
 		return
 	}
+	s.deleteRange(c, l)
+}
 
+// deleteRange deletes the keys of l in a job, by batches of l.Limit keys.
+func (s *Server) deleteRange(c *gin.Context, l *model.List) {
 	if l.Reverse {
-		c.JSON(http.StatusBadRequest, gin.H{"error": xerror.ErrNotSupported})
+		s.writeError(c, xerror.ErrNotSupported, nil)
+		return
+	}
+	if err := s.checkList(l, true); err != nil {
+		s.writeError(c, err, nil)
 		return
 	}
 
 	start, end, err := s.getRangeFromList(l)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		s.writeError(c, err, nil)
 		return
 	}
 
-	if l.Limit <= 0 || l.Limit > 10000 {
-		l.Limit = 10000
-	}
-
+	// jobs outlive the request, and run in the background class
+	log := s.logger(c)
+	ctx := store.WithPriority(context.Background(), store.PriorityBackground)
 	if l.Unsafe {
+		if !s.checkUnsafeDelete(c, start, end) {
+			return
+		}
+		node := s.node()
+		err := s.store.AcquireLock(c.Request.Context(), unsafeDeleteLock, node, s.conf.Store.BatchDeleteTimeout.Duration)
+		if err != nil {
+			s.writeError(c, err, nil)
+			return
+		}
+		job := s.jobs.Add("unsafe-delete", l.Start, l.End)
 		go func() {
-			s.store.UnsafeDelete(start, end)
+			s.jobs.Finish(job, s.store.UnsafeDelete(ctx, start, end))
+			if err := s.store.ReleaseLock(context.Background(), unsafeDeleteLock, node); err != nil {
+				log.Warnf("release lock %s failed, %s", unsafeDeleteLock, err)
+			}
 		}()
 		c.Status(http.StatusNoContent)
 		return
 	}
 
+	job := s.jobs.Add("batch-delete", l.Start, l.End)
 	go func() {
-		count := 0
-		lastKey := start
-		var err error
-		for {
-			deleted := 0
-			lastKey, deleted, err = s.store.BatchDelete(lastKey, end, l.Limit)
-			if err != nil {
-				s.log.Errorf("list (%s-%s), deleted %d, err: %s", l.Start, l.End, count, err)
-				return
-			}
-			s.log.Infof("list (%s-%s), deleted %d", l.Start, l.End, count)
-			if deleted < l.Limit {
-				return
-			}
-			count += deleted
+		count, err := s.store.DeleteRange(ctx, start, end, l.Limit, func(deleted int) {
+			s.jobs.Progress(job, deleted)
+		})
+		if err != nil {
+			log.Errorf("list (%s-%s), deleted %d, err: %s", l.Start, l.End, count, err)
+		} else {
+			log.Infof("list (%s-%s), deleted %d", l.Start, l.End, count)
 		}
+		s.jobs.Finish(job, err)
 	}()
 	c.Status(http.StatusNoContent)
 }
 
 func (s *Server) GetConfig(c *gin.Context) {
-	c.Render(http.StatusOK, utils.TOML{Data: s.conf})
+	c.Render(http.StatusOK, utils.TOML{Data: s.conf.Masked()})
 }
 
 func (s *Server) Health(c *gin.Context) {
 	if s.closed {
-		c.Set(middleware.HttpMessage, "closed")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "is closed"})
+		s.writeError(c, xerror.ErrServerClosed, nil)
 		return
 	}
 
 	err := s.store.Health()
 	if err != nil {
-		s.log.Errorf("not health, %s", err)
-		c.Set(middleware.HttpMessage, err.Error())
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		s.logger(c).Errorf("not health, %s", err)
+		s.writeError(c, err, nil)
+		return
+	}
+	// a degraded store still serves, the details are only sent along
+	req := &model.Health{}
+	if !s.bind(c, req) {
+		return
+	}
+	st := s.store.Status()
+	if req.Verbose || st.State != store.StateReady.String() {
+		c.JSON(http.StatusOK, st)
 		return
 	}
 	c.Status(http.StatusNoContent)
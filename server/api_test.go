@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -77,12 +78,10 @@ func PerformRequest(r http.Handler, method, path string,
 }
 
 func error2HttpCode(err error) int {
-	if err == xerror.ErrCheckAndSetFailed {
-		return http.StatusConflict
-	} else if err == xerror.ErrAlreadyExists {
+	if err == xerror.ErrAlreadyExists {
 		return http.StatusOK
 	} else if err != nil {
-		return http.StatusBadRequest
+		return xerror.HTTPStatus(err)
 	}
 	return http.StatusNoContent
 }
@@ -435,3 +434,58 @@ func TestServer(t *testing.T) {
 	})
 	s.Close()
 }
+
+func TestCheckList(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Server.MaxListLimit = 100
+	s := &Server{conf: conf, log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+
+	l := &model.List{Start: "a", End: "b", Raw: true}
+	assert.Nil(t, s.checkList(l, false))
+	assert.Equal(t, 100, l.Limit)
+	l.Limit = 101
+	err := s.checkList(l, false)
+	assert.True(t, errors.Is(err, xerror.ErrListKVInvalid))
+	var field *model.FieldError
+	assert.True(t, errors.As(err, &field))
+	assert.Equal(t, &model.FieldError{In: model.InHeader, Field: "X-Limit", Value: "101", Reason: "more than 100"}, field)
+
+	l = &model.List{Start: "a", End: "b", Raw: true, Unsafe: true}
+	assert.True(t, errors.Is(s.checkList(l, false), xerror.ErrListKVInvalid))
+	err = s.checkList(l, true)
+	assert.True(t, errors.Is(err, xerror.ErrUnsafeDeleteDisabled))
+	assert.Equal(t, http.StatusBadRequest, xerror.HTTPStatus(err))
+	conf.Server.EnableUnsafeDelete = true
+	assert.Nil(t, s.checkList(l, true))
+
+	// the fields of a v2 body are named by json
+	r := &model.ListRequest{Limit: 101}
+	assert.True(t, errors.As(s.checkList(r.List(), false), &field))
+	assert.Equal(t, &model.FieldError{In: model.InBody, Field: "limit", Value: "101", Reason: "more than 100"}, field)
+}
+
+func TestGetRangeFromList(t *testing.T) {
+	s := &Server{conf: config.DefaultConfig(), log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	start, end, err := s.getRangeFromList(&model.List{Start: "a", End: "b", Raw: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{MetaType, 'a'}, start)
+	assert.Equal(t, []byte{MetaType, 'b'}, end)
+
+	// a reverse list may go from its last key down
+	start, end, err = s.getRangeFromList(&model.List{Start: "b", End: "a", Raw: true, Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{MetaType, 'a'}, start)
+	assert.Equal(t, []byte{MetaType, 'b'}, end)
+
+	for _, l := range []*model.List{
+		{Start: "b", End: "a", Raw: true},
+		{Start: "a", End: "a", Raw: true},
+		{Start: "a", Raw: true, Reverse: true},
+	} {
+		_, _, err = s.getRangeFromList(l)
+		assert.True(t, errors.Is(err, xerror.ErrListKVInvalid), "%+v", l)
+		var field *model.FieldError
+		assert.True(t, errors.As(err, &field))
+		assert.Equal(t, "X-End", field.Field)
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/version"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const ApiVersionHeader = "X-Api-Version"
+
+// ApiVersions are the api versions served, by their routes.
+var ApiVersions = []string{version.API, version.APIV2}
+
+// apiVersion checks the X-Api-Version of a client against api, the version
+// of the routes, a client without the header is let through. The version
+// may be given without its v, e.g. 1.
+func (s *Server) apiVersion(api string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v := strings.TrimSpace(c.GetHeader(ApiVersionHeader))
+		if v == "" {
+			ClientApiVersion.WithLabelValues("none").Inc()
+			c.Next()
+			return
+		}
+		if !strings.HasPrefix(v, "v") {
+			v = "v" + v
+		}
+		if v == api {
+			ClientApiVersion.WithLabelValues(v).Inc()
+			c.Next()
+			return
+		}
+		label := "unsupported"
+		for _, supported := range ApiVersions {
+			if v == supported {
+				label = v
+			}
+		}
+		ClientApiVersion.WithLabelValues(label).Inc()
+		s.logger(c).Warnf("client of api %s on the routes of %s", c.GetHeader(ApiVersionHeader), api)
+		s.writeError(c, xerror.ErrApiVersionUnsupported, gin.H{
+			"header":    ApiVersionHeader,
+			"value":     c.GetHeader(ApiVersionHeader),
+			"route":     api,
+			"supported": ApiVersions,
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	r := gin.New()
+	r.Use(middleware.StructuredErrors(ApiV2Route + "/"))
+	handler := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	r.GET(ApiRoute+"/health", s.apiVersion(version.API), handler)
+	r.GET(ApiV2Route+"/health", s.apiVersion(version.APIV2), handler)
+	get := func(route, v string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, route+"/health", nil)
+		if v != "" {
+			req.Header.Set(ApiVersionHeader, v)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	unsupported := testutil.ToFloat64(ClientApiVersion.WithLabelValues("unsupported"))
+	assert.Equal(t, http.StatusNoContent, get(ApiRoute, "").Code)
+	assert.Equal(t, http.StatusNoContent, get(ApiRoute, "v1").Code)
+	assert.Equal(t, http.StatusNoContent, get(ApiRoute, "1").Code)
+	assert.Equal(t, http.StatusNoContent, get(ApiV2Route, "v2").Code)
+
+	w := get(ApiRoute, "v3")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	resp := middleware.ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "api_version_unsupported", resp.Code)
+	details := resp.Details.(map[string]interface{})
+	assert.Equal(t, []interface{}{"v1", "v2"}, details["supported"])
+	assert.Equal(t, "v3", details["value"])
+	assert.Equal(t, unsupported+1, testutil.ToFloat64(ClientApiVersion.WithLabelValues("unsupported")))
+
+	// a v2 client on the v1 routes, in the envelope of the route
+	assert.Equal(t, http.StatusBadRequest, get(ApiRoute, "v2").Code)
+	w = get(ApiV2Route, "v1")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	v2 := middleware.ErrorResponseV2{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &v2))
+	assert.Equal(t, "api_version_unsupported", v2.Error.Code)
+	assert.False(t, v2.Error.Retryable)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/sirupsen/logrus"
+)
+
+type AuditActor struct {
+	IP string `json:"ip,omitempty"`
+	// Token is a fingerprint of the admin or access token, never the token.
+	Token string `json:"token,omitempty"`
+	// User is the provider and the subject of an authenticated user.
+	User string `json:"user,omitempty"`
+}
+
+type AuditRecord struct {
+	Time      time.Time         `json:"time"`
+	Method    string            `json:"method"`
+	Route     string            `json:"route"`
+	Params    map[string]string `json:"params,omitempty"`
+	Query     string            `json:"query,omitempty"`
+	Start     string            `json:"start,omitempty"`
+	End       string            `json:"end,omitempty"`
+	Status    int               `json:"status"`
+	Actor     AuditActor        `json:"actor"`
+	RequestId string            `json:"request_id,omitempty"`
+	Cluster   string            `json:"cluster,omitempty"`
+}
+
+// auditOutput is where the records are appended, shared by the auditors of
+// the clusters.
+type auditOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+// Auditor appends one json line per record, it doesn't go through logrus so
+// the log level can't hide it.
+type Auditor struct {
+	out       *auditOutput
+	cluster   string
+	store     *store.Store
+	connector bool
+	log       *logrus.Entry
+}
+
+func NewAuditor(conf *config.Audit, st *store.Store) (*Auditor, error) {
+	a := &Auditor{
+		out:       &auditOutput{w: os.Stderr},
+		store:     st,
+		connector: conf.Connector,
+		log:       logrus.WithFields(logrus.Fields{"worker": "audit"}),
+	}
+	if conf.File != "" {
+		f, err := os.OpenFile(conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.out.f, a.out.w = f, f
+	}
+	return a, nil
+}
+
+// forCluster records the requests of cluster id along with the ones of a,
+// and sends them to the connector of st. Only a closes the file.
+func (a *Auditor) forCluster(id string, st *store.Store) *Auditor {
+	if a == nil {
+		return nil
+	}
+	return &Auditor{out: a.out, cluster: id, store: st, connector: a.connector,
+		log: a.log.WithField("cluster", id)}
+}
+
+func (a *Auditor) Record(r AuditRecord) {
+	if a == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Cluster = a.cluster
+	b, err := json.Marshal(r)
+	if err != nil {
+		a.log.Errorf("marshal %s %s failed, %s", r.Method, r.Route, err)
+		return
+	}
+	a.out.mu.Lock()
+	_, err = a.out.w.Write(append(b, '\n'))
+	a.out.mu.Unlock()
+	if err != nil {
+		a.log.Errorf("write %s failed, %s", b, err)
+	}
+	if a.connector && a.store != nil {
+		a.store.SendAudit(r.Time.UTC().Format(time.RFC3339Nano), b)
+	}
+}
+
+func (a *Auditor) Close() error {
+	if a == nil || a.cluster != "" || a.out.f == nil {
+		return nil
+	}
+	return a.out.f.Close()
+}
+
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:4])
+}
+
+// audited records the request once it's handled, rejected ones included.
+func (s *Server) audited(c *gin.Context) {
+	c.Next()
+	token := c.GetHeader(middleware.AdminTokenHeader)
+	user := ""
+	if id := middleware.GetIdentity(c); id != nil {
+		user = id.Provider + ":" + id.Subject
+	} else if token == "" {
+		token = middleware.AccessToken(c)
+	}
+	ip := ""
+	if remote := middleware.RemoteIP(c); remote != nil {
+		ip = remote.String()
+	}
+	start, end := c.GetHeader("X-Start"), c.GetHeader("X-End")
+	if v, ok := c.Get(listRangeKey); ok {
+		l := v.(*model.List)
+		start, end = l.Start, l.End
+	}
+	r := AuditRecord{
+		Method:    c.Request.Method,
+		Route:     c.FullPath(),
+		Query:     c.Request.URL.RawQuery,
+		Start:     start,
+		End:       end,
+		Status:    c.Writer.Status(),
+		RequestId: middleware.GetRequestId(c),
+		Actor: AuditActor{
+			IP:    ip,
+			Token: tokenFingerprint(token),
+			User:  user,
+		},
+	}
+	if len(c.Params) > 0 {
+		r.Params = make(map[string]string, len(c.Params))
+		for _, p := range c.Params {
+			r.Params[p.Key] = p.Value
+		}
+	}
+	s.auditor.Record(r)
+}
+
+// auditAdmin records the admin requests which change something.
+func (s *Server) auditAdmin(c *gin.Context) {
+	if !isWrite(c.Request.Method) {
+		c.Next()
+		return
+	}
+	s.audited(c)
+}
+
+// rejectAdmin rejects the admin routes when they're neither protected by
+// a token nor served on their own listener.
+func rejectAdmin(c *gin.Context) {
+	middleware.AbortWithError(c, http.StatusForbidden, middleware.StatusCode(http.StatusForbidden),
+		"admin routes need admin.tokens or admin.http-port", nil)
+}
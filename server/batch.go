@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/validator"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	BatchGet = "get"
+	BatchPut = "put"
+)
+
+var (
+	batchRoute   = path.Join(ApiRoute, "/batch")
+	batchV2Route = path.Join(ApiV2Route, "/batch")
+)
+
+// BatchResult is the outcome of an op, Status is the status the op would
+// get on its own route.
+type BatchResult struct {
+	Status    int         `json:"status"`
+	Code      string      `json:"code,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	Value     *string     `json:"value,omitempty"`
+	Secondary bool        `json:"secondary,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+	Failed  int           `json:"failed"`
+}
+
+func errorResult(err error, details interface{}) BatchResult {
+	status := xerror.HTTPStatus(err)
+	code := xerror.CodeOf(err)
+	if code == "" {
+		code = middleware.StatusCode(status)
+	}
+	return BatchResult{Status: status, Code: code, Message: err.Error(), Details: details}
+}
+
+// Batch runs the ops in order, each on its own: a failed op doesn't stop
+// the others and nothing is rolled back. The response is 200 with the
+// result of every op, Failed counts the ops with a status of 300 or more.
+func (s *Server) Batch(c *gin.Context) {
+	body, ok := s.readBody(c, s.conf.Server.MaxBatchSize)
+	if !ok {
+		return
+	}
+	b := &model.Batch{}
+	if err := model.BindJSON(body, b); err != nil {
+		s.writeBindError(c, err)
+		return
+	}
+	if len(b.Ops) > s.conf.Server.MaxBatchOps {
+		s.writeError(c, xerror.ErrInvalidArgument, gin.H{"limit": s.conf.Server.MaxBatchOps})
+		return
+	}
+
+	resp := BatchResponse{Results: make([]BatchResult, len(b.Ops))}
+	for i := range b.Ops {
+		r := s.batchOp(c, &b.Ops[i])
+		if r.Status >= http.StatusMultipleChoices {
+			resp.Failed++
+		}
+		resp.Results[i] = r
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) batchOp(c *gin.Context, op *model.BatchOp) BatchResult {
+	perm := middleware.PermRead
+	switch op.Op {
+	case BatchGet:
+	case BatchPut:
+		perm = middleware.PermWrite
+		if s.Mode() == ModeReadOnly {
+			return errorResult(xerror.ErrReadOnly, nil)
+		}
+		if network := middleware.ReadOnlyNetwork(c); network != "" {
+			return errorResult(xerror.ErrNetworkReadOnly, gin.H{"network": network})
+		}
+	default:
+		return errorResult(xerror.ErrInvalidArgument, gin.H{"op": op.Op})
+	}
+
+	key, err := EncodeMetaKey(op.Key, op.Raw)
+	if err != nil {
+		return errorResult(xerror.ErrKeyInvalid, gin.H{"key": op.Key, "reason": err.Error()})
+	}
+	if limit := s.conf.Server.MaxKeyLength; limit > 0 && len(key)-1 > limit {
+		return errorResult(xerror.ErrKeyTooLarge, gin.H{"limit": limit})
+	}
+	if s.acl != nil && !s.allow(c, perm, key[1:], nil) {
+		s.logger(c).Warnf("access denied batch %s %s", op.Op, key)
+		return errorResult(xerror.ErrAccessDenied, nil)
+	}
+
+	if op.Op == BatchGet {
+		return s.batchGet(c, op, key)
+	}
+	return s.batchPut(c, op, key)
+}
+
+func (s *Server) batchGet(c *gin.Context, op *model.BatchOp, key []byte) BatchResult {
+	opts := DefaultGetOption()
+	opts.ReplicaRead = s.conf.Server.ReplicaRead
+	if op.Secondary != "" {
+		secondary, err := EncodeMetaKey(op.Secondary, op.Raw)
+		if err != nil {
+			return errorResult(xerror.ErrKeyInvalid, gin.H{"secondary": op.Secondary, "reason": err.Error()})
+		}
+		if s.acl != nil && !s.allow(c, middleware.PermRead, secondary[1:], nil) {
+			s.logger(c).Warnf("access denied batch get secondary %s", secondary)
+			return errorResult(xerror.ErrAccessDenied, nil)
+		}
+		opts.Secondary = secondary
+	}
+	v, err := s.store.Get(c.Request.Context(), key, opts)
+	if err != nil {
+		return errorResult(err, nil)
+	}
+	if v.Secondary {
+		key = opts.Secondary
+	}
+	value := string(s.reveal(c, key, v.Value))
+	return BatchResult{Status: http.StatusOK, Value: &value, Secondary: v.Secondary}
+}
+
+func (s *Server) batchPut(c *gin.Context, op *model.BatchOp, key []byte) BatchResult {
+	if len(op.New) > 0 && s.validators.Match(key[1:]) {
+		name, err := s.validators.Validate(key[1:], utils.S2B(op.New))
+		if err != nil {
+			errs, ok := err.(validator.Errors)
+			if !ok {
+				errs = validator.Errors{{Field: "$", Message: err.Error()}}
+			}
+			return errorResult(xerror.ErrValidationFailed.Wrap(err), gin.H{"validator": name, "errors": errs})
+		}
+	}
+
+	opts := GetCheckOption(s.conf.Server.CheckOption)
+	if op.Exact {
+		opts.Check = ExactCheck
+	}
+	entry, err := json.Marshal(store.Log{Old: op.Old, New: op.New})
+	if err != nil {
+		return errorResult(xerror.ErrCheckAndSetInvalid.Wrap(err), nil)
+	}
+
+	var conflict *store.ConflictError
+	err = s.store.CheckAndPut(c.Request.Context(), key, entry, opts)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		return BatchResult{Status: http.StatusOK}
+	} else if errors.Is(err, xerror.ErrValueTooLarge) {
+		return errorResult(err, gin.H{"limit": s.conf.Server.MaxValueSize})
+	} else if errors.As(err, &conflict) {
+		return errorResult(err, gin.H{
+			"exists":  len(conflict.Value) > 0,
+			"current": utils.B2S(s.reveal(c, key, conflict.Value)),
+			"read_ts": conflict.ReadTs,
+		})
+	} else if err != nil {
+		return errorResult(err, nil)
+	}
+	return BatchResult{Status: http.StatusNoContent}
+}
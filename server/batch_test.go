@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/validator"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchDB keeps the keys in a map, a cas is checked against it.
+type batchDB map[string][]byte
+
+func (d batchDB) Name() string                                   { return "batch-test" }
+func (d batchDB) Open(conf *config.Config) (store.DB, error)     { return d, nil }
+func (d batchDB) Close() error                                   { return nil }
+func (d batchDB) Put(ctx context.Context, key, val []byte) error { return nil }
+func (d batchDB) BatchPut(ctx context.Context, items []store.KeyEntry) error {
+	return nil
+}
+
+func (d batchDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option store.CheckOption) error {
+	val, err := option.Check(oldVal, newVal, d[string(key)])
+	if err == xerror.ErrCheckAndSetFailed {
+		return store.NewConflictError(err, d[string(key)], 0)
+	} else if err != nil {
+		return err
+	}
+	d[string(key)] = val
+	return nil
+}
+
+func (d batchDB) Get(ctx context.Context, key []byte, option store.GetOption) (store.Value, error) {
+	if v, ok := d[string(key)]; ok {
+		return store.Value{Value: v}, nil
+	}
+	if v, ok := d[string(option.Secondary)]; ok && option.Secondary != nil {
+		return store.Value{Secondary: true, Value: v}, nil
+	}
+	return store.NoValue, xerror.ErrNotExists
+}
+
+func (d batchDB) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	return nil, nil
+}
+
+func (d batchDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	return nil, 0, nil
+}
+
+func (d batchDB) UnsafeDelete(ctx context.Context, start, end []byte) error { return nil }
+
+func TestBatch(t *testing.T) {
+	db := batchDB{"\x00a": []byte("1")}
+	store.RegisterDB(db)
+	conf := config.DefaultConfig()
+	conf.Store.Name = db.Name()
+	conf.Server.CheckOption = config.ExactCheck
+	conf.Server.MaxBatchOps = 4
+	st, err := store.OnlyOpenDatabase(conf)
+	assert.Nil(t, err)
+	validators, _ := validator.New(nil)
+	s := &Server{store: st, conf: conf, validators: validators,
+		log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST(batchRoute, s.checkMode, s.Batch)
+	do := func(body string) (int, BatchResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", batchRoute, strings.NewReader(body)))
+		resp := BatchResponse{}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, resp := do(`{"ops": [
+		{"op": "get", "key": "a", "raw": true},
+		{"op": "get", "key": "b", "raw": true},
+		{"op": "put", "key": "b", "raw": true, "new": "2"},
+		{"op": "put", "key": "a", "raw": true, "old": "x", "new": "3"}
+	]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, resp.Failed)
+	assert.Equal(t, http.StatusOK, resp.Results[0].Status)
+	assert.Equal(t, "1", *resp.Results[0].Value)
+	assert.Equal(t, http.StatusNotFound, resp.Results[1].Status)
+	assert.Equal(t, "not_exists", resp.Results[1].Code)
+	assert.Equal(t, http.StatusNoContent, resp.Results[2].Status)
+	assert.Equal(t, http.StatusConflict, resp.Results[3].Status)
+	assert.Equal(t, []byte("2"), db["\x00b"])
+
+	// a read-only server still serves the gets
+	s.SetMode(ModeReadOnly)
+	_, resp = do(`{"ops": [{"op": "get", "key": "b", "raw": true}, {"op": "put", "key": "c", "raw": true, "new": "1"}]}`)
+	assert.Equal(t, http.StatusOK, resp.Results[0].Status)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Results[1].Status)
+	s.SetMode(ModeNormal)
+
+	// so does a read-only network
+	f, err := middleware.NewFirewall(&config.Firewall{Enable: true, Default: "external",
+		Networks: []config.Network{{Name: "external", ReadOnly: true}}})
+	assert.Nil(t, err)
+	f.ReadRoute(http.MethodPost, batchRoute)
+	r = gin.New()
+	r.Use(f.Check)
+	r.POST(batchRoute, s.checkMode, s.Batch)
+	_, resp = do(`{"ops": [{"op": "get", "key": "b", "raw": true}, {"op": "put", "key": "c", "raw": true, "new": "1"}]}`)
+	assert.Equal(t, http.StatusOK, resp.Results[0].Status)
+	assert.Equal(t, http.StatusForbidden, resp.Results[1].Status)
+	assert.Equal(t, "network_read_only", resp.Results[1].Code)
+	assert.Nil(t, db["\x00c"])
+	r = gin.New()
+	r.POST(batchRoute, s.checkMode, s.Batch)
+
+	code, _ = do(`{"ops": [{}, {}, {}, {}, {}]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestSecondary(t *testing.T) {
+	db := batchDB{"\x00a": []byte("1"), "\x00oldb": []byte("2"), "\x00privatec": []byte("3")}
+	store.RegisterDB(secondaryDB{db})
+	conf := config.DefaultConfig()
+	conf.Store.Name = secondaryDB{}.Name()
+	st, err := store.OnlyOpenDatabase(conf)
+	assert.Nil(t, err)
+	acl, err := middleware.NewACL(&config.ACL{Enable: true, Rules: []config.ACLRule{{IP: "192.0.2.0/24", Prefix: "private", Permission: "w"}}})
+	assert.Nil(t, err)
+	validators, _ := validator.New(nil)
+	s := &Server{store: st, conf: conf, acl: acl, validators: validators,
+		log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/meta/:key", s.metaACL(middleware.PermRead), s.Get)
+	r.POST(batchRoute, s.Batch)
+	get := func(key, secondary string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/meta/"+key, nil)
+		req.Header.Set("X-Raw", "true")
+		req.Header.Set("X-Secondary", secondary)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("a", "olda")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Body.String())
+	assert.Empty(t, w.Header().Get("X-Secondary"))
+	w = get("newb", "oldb")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("X-Secondary"))
+	assert.Equal(t, http.StatusNotFound, get("newx", "oldx").Code)
+	// the secondary key is under the ACL too
+	assert.Equal(t, http.StatusForbidden, get("newc", "privatec").Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", batchRoute, strings.NewReader(`{"ops": [
+		{"op": "get", "key": "newb", "raw": true, "secondary": "oldb"},
+		{"op": "get", "key": "newc", "raw": true, "secondary": "privatec"}
+	]}`)))
+	resp := BatchResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, http.StatusOK, resp.Results[0].Status)
+	assert.Equal(t, "2", *resp.Results[0].Value)
+	assert.True(t, resp.Results[0].Secondary)
+	assert.Equal(t, http.StatusForbidden, resp.Results[1].Status)
+}
+
+// secondaryDB registers batchDB under another name.
+type secondaryDB struct {
+	batchDB
+}
+
+func (d secondaryDB) Name() string                               { return "secondary-test" }
+func (d secondaryDB) Open(conf *config.Config) (store.DB, error) { return d.batchDB, nil }
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+// benchDB has the same value for every key, a list gets limit of the items.
+type benchDB struct {
+	value []byte
+	items []store.KeyValue
+}
+
+func (d benchDB) Name() string                                   { return "bench" }
+func (d benchDB) Open(conf *config.Config) (store.DB, error)     { return d, nil }
+func (d benchDB) Close() error                                   { return nil }
+func (d benchDB) Put(ctx context.Context, key, val []byte) error { return nil }
+func (d benchDB) BatchPut(ctx context.Context, items []store.KeyEntry) error {
+	return nil
+}
+
+func (d benchDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option store.CheckOption) error {
+	return nil
+}
+
+func (d benchDB) Get(ctx context.Context, key []byte, option store.GetOption) (store.Value, error) {
+	if len(key) < 2 {
+		return store.NoValue, xerror.ErrNotExists
+	}
+	return store.Value{Value: d.value}, nil
+}
+
+func (d benchDB) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	if limit > len(d.items) {
+		limit = len(d.items)
+	}
+	items := make([]store.KeyValue, limit)
+	copy(items, d.items)
+	return items, nil
+}
+
+func (d benchDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	return nil, 0, nil
+}
+
+func (d benchDB) UnsafeDelete(ctx context.Context, start, end []byte) error { return nil }
+
+var registerBench sync.Once
+
+func newBenchRouter(b testing.TB) (*gin.Engine, benchDB) {
+	db := benchDB{value: make([]byte, 4096), items: make([]store.KeyValue, 100)}
+	for i := range db.items {
+		db.items[i] = store.KeyValue{Key: fmt.Sprintf("key-%06d", i), Value: string(db.value[:512])}
+	}
+	registerBench.Do(func() { store.RegisterDB(db) })
+	conf := config.DefaultConfig()
+	conf.Store.Name = db.Name()
+	st, err := store.OnlyOpenDatabase(conf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	s := &Server{store: st, conf: conf, log: logrus.NewEntry(logger)}
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.GET(ApiRoute+"/meta/:key", s.Get)
+	router.GET(ApiRoute+"/list", s.List)
+	router.POST(listV2Route, s.ListV2)
+	return router, db
+}
+
+// discardWriter is a ResponseWriter keeping nothing, the recorder would
+// count the allocations of its own buffer.
+type discardWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(code int)        { w.code = code }
+
+func benchRoute(b *testing.B, r *http.Request) {
+	router, _ := newBenchRouter(b)
+	w := &discardWriter{header: http.Header{}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k := range w.header {
+			delete(w.header, k)
+		}
+		router.ServeHTTP(w, r)
+		if w.code != http.StatusOK {
+			b.Fatalf("status %d", w.code)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	r := httptest.NewRequest(http.MethodGet, ApiRoute+"/meta/a2V5", nil)
+	benchRoute(b, r)
+}
+
+func BenchmarkList(b *testing.B) {
+	r := httptest.NewRequest(http.MethodGet, ApiRoute+"/list", nil)
+	r.Header.Set("X-Start", "YQ")
+	r.Header.Set("X-End", "eg")
+	r.Header.Set("X-Limit", "100")
+	benchRoute(b, r)
+}
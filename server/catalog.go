@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+)
+
+// unsafeDeleteLock is held by a proxy while its unsafe delete runs, so the
+// fleet runs one at a time.
+const unsafeDeleteLock = "unsafe-delete"
+
+type catalogRequest struct {
+	Kind string `query:"kind" binding:"required"`
+}
+
+// node names the proxy in the catalog, the peers know it by its advertised
+// address.
+func (s *Server) node() string {
+	if s.conf.Peers.Enable {
+		return s.conf.Peers.Advertise
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = s.conf.Server.HttpHost
+	}
+	return host + ":" + strconv.Itoa(s.conf.Server.HttpPort)
+}
+
+// recordJob keeps the job in the catalog as node/id.
+func (s *Server) recordJob(job Job) {
+	name := s.node() + "/" + job.ID
+	if err := s.store.PutCatalog(context.Background(), store.CatalogJobs, name, job); err != nil {
+		s.log.Warnf("record job %s failed, %s", name, err)
+	}
+}
+
+// Catalog lists the entries of a kind of the catalog.
+func (s *Server) Catalog(c *gin.Context) {
+	req := &catalogRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	entries, err := s.store.ListCatalog(c.Request.Context(), req.Kind)
+	if err != nil {
+		s.logger(c).Errorf("list catalog %s failed, %s", req.Kind, err)
+		s.writeError(c, err, nil)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
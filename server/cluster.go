@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	ClusterRoute  = "/c"
+	ClusterHeader = "X-Cluster"
+)
+
+type clusterInfo struct {
+	ID    string `json:"id"`
+	Store string `json:"store"`
+	Path  string `json:"path"`
+	State string `json:"state"`
+}
+
+// bareRouter is the router of a cluster, the proxy already logged the
+// request. A panic is recovered here as well, like newRouter does.
+func bareRouter(conf *config.Config) *gin.Engine {
+	router := gin.New()
+	if conf.HttpServerMode() != gin.DebugMode {
+		router.Use(gin.Recovery())
+	}
+	return router
+}
+
+// newClusters is a proxy by cluster of conf, with routes of their own and
+// without listening. They share the auditor of the proxy.
+func newClusters(conf *config.Config, auditor *Auditor) (map[string]*Server, error) {
+	if len(conf.Clusters) == 0 {
+		return nil, nil
+	}
+	clusters := make(map[string]*Server, len(conf.Clusters))
+	for i := range conf.Clusters {
+		cl := &conf.Clusters[i]
+		s, err := newServer(conf.ClusterConfig(cl), bareRouter)
+		if err != nil {
+			for _, opened := range clusters {
+				opened.closeStore()
+			}
+			return nil, err
+		}
+		s.log = s.log.WithField("cluster", cl.ID)
+		s.auditor = auditor.forCluster(cl.ID, s.store)
+		clusters[cl.ID] = s
+	}
+	return clusters, nil
+}
+
+// closeStore closes what a cluster opened, the proxy serving it closes the
+// rest.
+func (s *Server) closeStore() {
+	if err := s.store.Close(); err != nil {
+		s.log.Errorf("store close failed %s", err)
+	}
+}
+
+// registerClusterRoutes serves the clusters under /c/{id}/ and to the
+// requests with X-Cluster, the admin routes of a cluster are on the admin
+// router.
+func (s *Server) registerClusterRoutes() {
+	if s.clusters == nil {
+		return
+	}
+	route := ClusterRoute + "/:cluster/*path"
+	s.router.Use(s.clusterHeader)
+	s.router.Any(route, s.cluster(false))
+	if s.adminServer != nil {
+		s.adminRouter.Any(route, s.cluster(true))
+	}
+}
+
+func (s *Server) cluster(admin bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.serveCluster(c, c.Param("cluster"), c.Param("path"), admin)
+	}
+}
+
+func (s *Server) clusterHeader(c *gin.Context) {
+	id := c.GetHeader(ClusterHeader)
+	if id == "" || strings.HasPrefix(c.Request.URL.Path, ClusterRoute+"/") {
+		return
+	}
+	s.serveCluster(c, id, c.Request.URL.Path, false)
+	c.Abort()
+}
+
+// serveCluster hands the request to the router of the cluster id as path.
+func (s *Server) serveCluster(c *gin.Context, id, path string, admin bool) {
+	cluster, ok := s.clusters[id]
+	if !ok {
+		s.writeError(c, xerror.ErrClusterNotExists, gin.H{"cluster": id})
+		return
+	}
+	ClusterRequests.WithLabelValues(id).Inc()
+	c.Request.URL.Path, c.Request.URL.RawPath = path, ""
+	router := cluster.router
+	if admin {
+		router = cluster.adminRouter
+	}
+	router.ServeHTTP(c.Writer, c.Request)
+}
+
+// ListClusters lists the clusters served besides [store].
+func (s *Server) ListClusters(c *gin.Context) {
+	clusters := make([]clusterInfo, 0, len(s.clusters))
+	for id, cluster := range s.clusters {
+		clusters = append(clusters, clusterInfo{ID: id, Store: cluster.conf.Store.Name,
+			Path: s.conf.Redact(cluster.conf.Store.Path), State: cluster.store.State().String()})
+	}
+	sort.Slice(clusters, func(a, b int) bool {
+		return clusters[a].ID < clusters[b].ID
+	})
+	c.JSON(http.StatusOK, clusters)
+}
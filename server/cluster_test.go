@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCluster(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	health := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) { c.String(http.StatusOK, name) }
+	}
+	staging := bareRouter(config.DefaultConfig())
+	staging.GET(ApiRoute+"/health", health("staging"))
+	staging.GET(ApiRoute+"/list", func(c *gin.Context) { panic("list") })
+	s := &Server{router: gin.New(), log: logrus.WithFields(logrus.Fields{"worker": "test"}),
+		clusters: map[string]*Server{"staging": {router: staging, adminRouter: staging}}}
+	s.adminRouter = s.router
+	// the routes after the clusters are routed by X-Cluster
+	s.registerClusterRoutes()
+	s.router.GET(ApiRoute+"/health", health("prod"))
+	s.router.GET(ApiRoute+"/meta/:key", health("prod"))
+	get := func(path, cluster string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if cluster != "" {
+			req.Header.Set(ClusterHeader, cluster)
+		}
+		s.router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, "prod", get(ApiRoute+"/health", "").Body.String())
+	assert.Equal(t, "staging", get(ClusterRoute+"/staging"+ApiRoute+"/health", "").Body.String())
+	assert.Equal(t, "staging", get(ApiRoute+"/health", "staging").Body.String())
+	// the header doesn't route a request under /c/
+	assert.Equal(t, "staging", get(ClusterRoute+"/staging"+ApiRoute+"/health", "prod").Body.String())
+	assert.Equal(t, http.StatusNotFound, get(ClusterRoute+"/staging"+ApiRoute+"/meta/a", "").Code)
+	// a cluster recovers its own panics
+	assert.Equal(t, http.StatusInternalServerError, get(ClusterRoute+"/staging"+ApiRoute+"/list", "").Code)
+
+	w := get(ClusterRoute+"/prod"+ApiRoute+"/health", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	resp := middleware.ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "cluster_not_exists", resp.Code)
+	assert.Equal(t, http.StatusNotFound, get(ApiRoute+"/meta/a", "prod").Code)
+}
+
+func TestClusterAuditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	a, err := NewAuditor(&config.Audit{File: file}, nil)
+	assert.Nil(t, err)
+	staging := a.forCluster("staging", nil)
+
+	a.Record(AuditRecord{Method: "DELETE", Route: "/api/v1/list"})
+	staging.Record(AuditRecord{Method: "DELETE", Route: "/api/v1/list"})
+	// the cluster leaves the file open for the proxy
+	assert.Nil(t, staging.Close())
+	a.Record(AuditRecord{Method: "PUT", Route: "/admin/mode"})
+	assert.Nil(t, a.Close())
+
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 3)
+	assert.NotContains(t, lines[0], `"cluster"`)
+	assert.Contains(t, lines[1], `"cluster":"staging"`)
+	assert.Contains(t, lines[2], `"route":"/admin/mode"`)
+}
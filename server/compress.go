@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/utils"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// deflate is the zlib format of RFC 1950, not the raw deflate stream.
+var (
+	gzipPools [zlib.BestCompression + 2]sync.Pool
+	zlibPools [zlib.BestCompression + 2]sync.Pool
+)
+
+// acceptEncoding picks gzip or deflate from the Accept-Encoding header,
+// gzip wins when both have the same quality.
+func acceptEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = strings.TrimSpace(part[:i])
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					continue
+				}
+				q = v
+			}
+		}
+		name = strings.ToLower(name)
+		if q <= 0 || (name != EncodingGzip && name != EncodingDeflate) {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == EncodingGzip) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// newCompressor takes a writer of the encoding from the pools, release puts
+// it back once it's closed.
+func newCompressor(dst io.Writer, encoding string, level int) (w io.WriteCloser, release func(), err error) {
+	if level < zlib.DefaultCompression || level > zlib.BestCompression {
+		level = zlib.DefaultCompression
+	}
+	// level is in [-1, 9], shift it for the pool index
+	idx := level + 1
+	switch encoding {
+	case EncodingGzip:
+		zw, ok := gzipPools[idx].Get().(*gzip.Writer)
+		if ok {
+			zw.Reset(dst)
+		} else if zw, err = gzip.NewWriterLevel(dst, level); err != nil {
+			return nil, nil, err
+		}
+		return zw, func() { gzipPools[idx].Put(zw) }, nil
+	default:
+		zw, ok := zlibPools[idx].Get().(*zlib.Writer)
+		if ok {
+			zw.Reset(dst)
+		} else if zw, err = zlib.NewWriterLevel(dst, level); err != nil {
+			return nil, nil, err
+		}
+		return zw, func() { zlibPools[idx].Put(zw) }, nil
+	}
+}
+
+func compress(buf *bytes.Buffer, encoding string, level int, data []byte) error {
+	w, release, err := newCompressor(buf, encoding, level)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// contentTypes are the header values of the content types written by
+// writeData, shared to not allocate them on every response.
+var contentTypes = map[string][]string{
+	"application/json":         {"application/json"},
+	"application/octet-stream": {"application/octet-stream"},
+}
+
+// writeData writes data, compressed when the client accepts it and the data
+// is not smaller than compress-min-size.
+func (s *Server) writeData(c *gin.Context, code int, contentType string, data []byte) {
+	conf := s.conf.Server
+	if conf.EnableCompress && len(data) >= conf.CompressMinSize {
+		c.Header("Vary", "Accept-Encoding")
+		encoding := acceptEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding != "" {
+			buf := utils.GetBuf()
+			defer utils.PutBuf(buf)
+			err := compress(buf, encoding, conf.CompressLevel, data)
+			if err == nil {
+				c.Header("Content-Encoding", encoding)
+				c.Header("Content-Length", strconv.Itoa(buf.Len()))
+				writeBody(c, code, contentType, buf.Bytes())
+				return
+			}
+			s.log.Errorf("compress %s failed, %s", encoding, err)
+		}
+	}
+	c.Header("Content-Length", strconv.Itoa(len(data)))
+	writeBody(c, code, contentType, data)
+}
+
+// writeBody writes data to the response as it is, without the render of
+// c.Data.
+func writeBody(c *gin.Context, code int, contentType string, data []byte) {
+	if v, ok := contentTypes[contentType]; ok {
+		c.Writer.Header()["Content-Type"] = v
+	} else {
+		c.Header("Content-Type", contentType)
+	}
+	c.Status(code)
+	if _, err := c.Writer.Write(data); err != nil {
+		_ = c.Error(err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptEncoding(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Header   string
+		Encoding string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", EncodingGzip},
+		{"deflate, gzip", EncodingGzip},
+		{"gzip;q=0.5, deflate", EncodingDeflate},
+		{"gzip;q=0, br", ""},
+		{"GZIP;q=0.8", EncodingGzip},
+	}
+	for _, tt := range cases {
+		assert.Equal(t, tt.Encoding, acceptEncoding(tt.Header), tt.Header)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte(`{"key":"MTEx","value":"123"}`), 100)
+	for i := 0; i < 2; i++ {
+		buf := &bytes.Buffer{}
+		err := compress(buf, EncodingGzip, 100, data)
+		assert.Nil(t, err)
+		assert.True(t, buf.Len() < len(data))
+		r, err := gzip.NewReader(buf)
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, data, out)
+	}
+}
+
+func TestCompressDeflate(t *testing.T) {
+	t.Parallel()
+	data := bytes.Repeat([]byte(`{"key":"MTEx","value":"123"}`), 100)
+	for i := 0; i < 2; i++ {
+		buf := &bytes.Buffer{}
+		err := compress(buf, EncodingDeflate, 6, data)
+		assert.Nil(t, err)
+		// the zlib header, the deflate of a browser
+		assert.Equal(t, byte(0x78), buf.Bytes()[0])
+		r, err := zlib.NewReader(buf)
+		assert.Nil(t, err)
+		out, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, data, out)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	ConfirmTokenHeader = "X-Confirm-Token"
+	ConfirmTokenTTL    = time.Minute
+)
+
+type confirmation struct {
+	start, end []byte
+	expireAt   time.Time
+}
+
+// Confirmations are single use tokens bound to a range, they live in memory
+// so the delete must reach the instance which issued the token.
+type Confirmations struct {
+	mu     sync.Mutex
+	tokens map[string]confirmation
+}
+
+func NewConfirmations() *Confirmations {
+	return &Confirmations{tokens: make(map[string]confirmation)}
+}
+
+func (cf *Confirmations) Issue(start, end []byte) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(b)
+	now := time.Now()
+	expireAt := now.Add(ConfirmTokenTTL)
+
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	for t, c := range cf.tokens {
+		if now.After(c.expireAt) {
+			delete(cf.tokens, t)
+		}
+	}
+	cf.tokens[token] = confirmation{start: start, end: end, expireAt: expireAt}
+	return token, expireAt, nil
+}
+
+// Use consumes the token, it's valid only for the same range before it expires.
+func (cf *Confirmations) Use(token string, start, end []byte) bool {
+	cf.mu.Lock()
+	c, ok := cf.tokens[token]
+	delete(cf.tokens, token)
+	cf.mu.Unlock()
+	return ok && time.Now().Before(c.expireAt) &&
+		bytes.Equal(c.start, start) && bytes.Equal(c.end, end)
+}
+
+// checkUnsafeDelete writes the error and returns false unless unsafe deletes
+// are enabled and the request has an admin token and a confirmation token.
+func (s *Server) checkUnsafeDelete(c *gin.Context, start, end []byte) bool {
+	if !s.conf.Server.EnableUnsafeDelete {
+		s.writeError(c, xerror.ErrUnsafeDeleteDisabled, nil)
+		return false
+	}
+	if !middleware.IsAdmin(c, s.conf.Admin.Tokens) {
+		s.writeError(c, xerror.ErrAccessDenied, gin.H{"reason": "admin token required"})
+		return false
+	}
+	if !s.confirms.Use(c.GetHeader(ConfirmTokenHeader), start, end) {
+		s.writeError(c, xerror.ErrConfirmationInvalid, gin.H{"header": ConfirmTokenHeader})
+		return false
+	}
+	return true
+}
+
+// UnsafeDeleteToken issues the confirmation token of an unsafe delete, the
+// range is given with the list headers.
+func (s *Server) UnsafeDeleteToken(c *gin.Context) {
+	if !s.conf.Server.EnableUnsafeDelete {
+		s.writeError(c, xerror.ErrUnsafeDeleteDisabled, nil)
+		return
+	}
+	l := &model.List{}
+	if !s.bind(c, l) {
+		return
+	}
+	start, end, err := s.getRangeFromList(l)
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	token, expireAt, err := s.confirms.Issue(start, end)
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	s.logger(c).Warnf("unsafe delete token issued for (%s-%s)", l.Start, l.End)
+	c.JSON(http.StatusOK, gin.H{
+		"token":     token,
+		"start":     l.Start,
+		"end":       l.End,
+		"expire_at": expireAt,
+	})
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsafeDeleteConfirm(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := config.DefaultConfig()
+	conf.Admin.Tokens = []string{"admin"}
+	s := &Server{
+		conf:     conf,
+		confirms: NewConfirmations(),
+		log:      logrus.WithFields(logrus.Fields{"worker": "test"}),
+	}
+	r := gin.New()
+	r.POST("/token", s.UnsafeDeleteToken)
+	r.DELETE("/list", func(c *gin.Context) {
+		l := &model.List{}
+		assert.Nil(t, c.ShouldBindHeader(&l))
+		start, end, err := s.getRangeFromList(l)
+		assert.Nil(t, err)
+		if s.checkUnsafeDelete(c, start, end) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+
+	do := func(method, path, start, end, admin, confirm string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Raw", "true")
+		req.Header.Set("X-Start", start)
+		req.Header.Set("X-End", end)
+		if admin != "" {
+			req.Header.Set(middleware.AdminTokenHeader, admin)
+		}
+		if confirm != "" {
+			req.Header.Set(ConfirmTokenHeader, confirm)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	token := func() string {
+		w := do("POST", "/token", "a", "b", "admin", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		res := struct{ Token string }{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res.Token
+	}
+
+	assert.Equal(t, http.StatusForbidden, do("POST", "/token", "a", "b", "admin", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/list", "a", "b", "admin", "x").Code)
+
+	conf.Server.EnableUnsafeDelete = true
+	tk := token()
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/list", "a", "b", "", tk).Code)
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/list", "a", "b", "admin", "").Code)
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/list", "a", "b", "admin", tk).Code)
+	// single use
+	assert.Equal(t, http.StatusForbidden, do("DELETE", "/list", "a", "b", "admin", tk).Code)
+
+	tk = token()
+	w := do("DELETE", "/list", "a", "c", "admin", tk)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "confirmation_invalid")
+}
+
+// fillSecrets sets each option tagged secret to a value of its own, a
+// slice of structs gets an element to reach the secrets inside.
+func fillSecrets(v reflect.Value, secret bool, values *[]string) {
+	switch v.Kind() {
+	case reflect.String:
+		if secret {
+			val := fmt.Sprintf("leaked-secret-%d", len(*values))
+			v.SetString(val)
+			*values = append(*values, val)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			fillSecrets(v.Elem(), secret, values)
+		}
+	case reflect.Slice:
+		if v.Len() == 0 && (secret || v.Type().Elem().Kind() == reflect.Struct) {
+			v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		}
+		for i := 0; i < v.Len(); i++ {
+			fillSecrets(v.Index(i), secret, values)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fillSecrets(v.Field(i), secret || t.Field(i).Tag.Get("secret") == "true", values)
+			}
+		}
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := config.DefaultConfig()
+	var secrets []string
+	fillSecrets(reflect.ValueOf(conf).Elem(), false, &secrets)
+	conf.Admin.Tokens = []string{"admin"}
+	s := &Server{conf: conf, log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	r := gin.New()
+	r.GET("/api/v1/config", s.GetConfig)
+	r.GET("/admin/config", middleware.AdminAuth(conf.Admin.Tokens), s.GetConfig)
+	r.GET("/rejected/config", rejectAdmin, s.GetConfig)
+
+	do := func(path, admin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if admin != "" {
+			req.Header.Set(middleware.AdminTokenHeader, admin)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusUnauthorized, do("/admin/config", "").Code)
+	assert.Equal(t, http.StatusForbidden, do("/rejected/config", "admin").Code)
+	assert.True(t, len(secrets) > 5)
+	for _, w := range []*httptest.ResponseRecorder{do("/api/v1/config", ""), do("/admin/config", "admin")} {
+		assert.Equal(t, http.StatusOK, w.Code)
+		for _, secret := range secrets {
+			assert.NotContains(t, w.Body.String(), secret)
+		}
+		assert.NotContains(t, w.Body.String(), `"admin"`)
+		assert.Contains(t, w.Body.String(), config.SecretMask)
+	}
+}
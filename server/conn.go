@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/huangnauh/tirest/config"
+	"golang.org/x/net/netutil"
+)
+
+// listen opens addr with the tcp keep-alive of the config, at most
+// max-connections are accepted at once, the others wait in the backlog.
+func listen(addr string, conf *config.Server) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: conf.TCPKeepAlive.Duration}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if conf.MaxConnections > 0 {
+		ln = netutil.LimitListener(ln, conf.MaxConnections)
+	}
+	return ln, nil
+}
+
+// connTracker counts the connections of a server by state, it's the
+// ConnState hook of the server.
+type connTracker struct {
+	name   string
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+func newConnTracker(name string) *connTracker {
+	return &connTracker{name: name, states: make(map[net.Conn]http.ConnState)}
+}
+
+func (t *connTracker) track(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.states[c]; ok {
+		Connections.WithLabelValues(t.name, old.String()).Dec()
+	}
+	switch state {
+	case http.StateNew:
+		ConnectionsTotal.WithLabelValues(t.name).Inc()
+	case http.StateHijacked, http.StateClosed:
+		delete(t.states, c)
+		return
+	}
+	t.states[c] = state
+	Connections.WithLabelValues(t.name, state.String()).Inc()
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	conf := config.DefaultConfig().Server
+	conf.MaxConnections = 1
+	ln, err := listen("127.0.0.1:0", &conf)
+	assert.Nil(t, err)
+	total := testutil.ToFloat64(ConnectionsTotal.WithLabelValues("test"))
+	idles := testutil.ToFloat64(Connections.WithLabelValues("test", "idle"))
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ConnState: newConnTracker("test").track,
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	first, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	_, err = first.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	assert.Nil(t, err)
+	buf := make([]byte, 1024)
+	_, err = first.Read(buf)
+	assert.Nil(t, err)
+	idle := func() bool {
+		return testutil.ToFloat64(Connections.WithLabelValues("test", "idle")) == idles+1
+	}
+	assert.Eventually(t, idle, time.Second, 10*time.Millisecond)
+	assert.Equal(t, total+1, testutil.ToFloat64(ConnectionsTotal.WithLabelValues("test")))
+
+	// the second connection waits until the first is closed
+	second, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	defer second.Close()
+	_, err = second.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	assert.Nil(t, err)
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = second.Read(buf)
+	assert.NotNil(t, err)
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = second.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, total+2, testutil.ToFloat64(ConnectionsTotal.WithLabelValues("test")))
+	assert.Eventually(t, idle, time.Second, 10*time.Millisecond)
+}
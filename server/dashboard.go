@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const DashboardRoute = "/dashboard"
+
+// dashboardHTML is the whole dashboard, it calls the admin routes with the
+// admin token typed in the page.
+//
+//go:embed ui/index.html
+var dashboardHTML []byte
+
+func (s *Server) Dashboard(c *gin.Context) {
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", dashboardHTML)
+}
+
+type browsedKey struct {
+	Key   string `json:"key"`
+	Size  int    `json:"size"`
+	Value string `json:"value"`
+}
+
+type browsedKeys struct {
+	Keys []browsedKey `json:"keys"`
+	Next string       `json:"next,omitempty"`
+}
+
+type keysRequest struct {
+	Prefix string `query:"prefix"`
+	After  string `query:"after"`
+	Limit  int    `query:"limit" default:"100" binding:"min=1,max=1000"`
+}
+
+// Keys lists the meta keys starting with the prefix for the key browser,
+// after the base64 key after, the keys and the values are base64.
+func (s *Server) Keys(c *gin.Context) {
+	req := &keysRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	prefix := []byte(req.Prefix)
+	start := append([]byte{MetaType}, prefix...)
+	if v := req.After; v != "" {
+		after, err := decodeBase64(v)
+		if err != nil {
+			s.writeError(c, xerror.ErrInvalidArgument, gin.H{"after": v})
+			return
+		}
+		start = append(append([]byte{MetaType}, after...), 0x00)
+	}
+	end := append([]byte{MetaType}, store.PrefixEnd(prefix)...)
+	if len(end) == 1 {
+		end = []byte{MetaType + 1}
+	}
+
+	res := browsedKeys{Keys: []browsedKey{}}
+	if bytes.Compare(start, end) >= 0 {
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	items, err := s.store.List(c.Request.Context(), start, end, req.Limit, DefaultListOption())
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	for _, item := range items {
+		res.Keys = append(res.Keys, browsedKey{Key: encodeBase64(utils.S2B(item.Key)),
+			Size: len(item.Value), Value: encodeBase64(utils.S2B(item.Value))})
+	}
+	if len(items) == req.Limit {
+		res.Next = res.Keys[len(res.Keys)-1].Key
+	}
+	c.JSON(http.StatusOK, res)
+}
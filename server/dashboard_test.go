@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	s := &Server{}
+	router := gin.New()
+	router.GET(DashboardRoute, s.Dashboard)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, DashboardRoute, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/html"))
+	assert.Contains(t, w.Body.String(), "/admin/keys?")
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const TimeoutHeader = "X-Timeout-Ms"
+
+// deadline bounds the request context with X-Timeout-Ms, the context is
+// canceled as well when the client goes away, so TiKV calls stop early.
+func (s *Server) deadline(c *gin.Context) {
+	v := c.GetHeader(TimeoutHeader)
+	if v == "" {
+		c.Next()
+		return
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		s.logger(c).Errorf("invalid %s %s", TimeoutHeader, v)
+		s.writeError(c, xerror.ErrInvalidHeader, gin.H{"header": TimeoutHeader, "value": v})
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(ms)*time.Millisecond)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"golang.org/x/net/trace"
+)
+
+const maxStackSize = 64 << 20
+
+var profiles = []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"}
+
+func (s *Server) registerDebugRoutes(r gin.IRouter) {
+	debug := r.Group("/debug")
+	debug.GET("/pprof/", gin.WrapF(pprof.Index))
+	debug.GET("/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	debug.GET("/pprof/profile", gin.WrapF(pprof.Profile))
+	debug.GET("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/pprof/trace", gin.WrapF(pprof.Trace))
+	// pprof.Index only serves named profiles under /debug/pprof/
+	for _, name := range profiles {
+		debug.GET("/pprof/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+	debug.GET("/vars", gin.WrapH(expvar.Handler()))
+	debug.GET("/goroutines", Goroutines)
+	if s.conf.EnableTracing {
+		// the trace handlers check the token again on their own
+		tokens := s.conf.Admin.Tokens
+		trace.AuthRequest = func(req *http.Request) (any, sensitive bool) {
+			ok := len(tokens) == 0 || middleware.IsAdminRequest(req, tokens)
+			return ok, ok
+		}
+		debug.GET("/requests", gin.WrapF(trace.Traces))
+		debug.GET("/events", gin.WrapF(trace.Events))
+	}
+}
+
+func Goroutines(c *gin.Context) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackSize {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", buf)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/trace"
+)
+
+func TestDebugTraces(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	conf := config.DefaultConfig()
+	conf.EnableTracing = true
+	conf.Admin.Tokens = []string{"admin"}
+	s := &Server{conf: conf}
+	r := gin.New()
+	s.registerDebugRoutes(r.Group(AdminRoute, middleware.AdminAuth(conf.Admin.Tokens)))
+
+	do := func(path, admin string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if admin != "" {
+			req.Header.Set(middleware.AdminTokenHeader, admin)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNotFound, do("/debug/requests", "admin"))
+	assert.Equal(t, http.StatusNotFound, do("/debug/pprof/", "admin"))
+	assert.Equal(t, http.StatusUnauthorized, do("/admin/debug/requests", ""))
+	assert.Equal(t, http.StatusOK, do("/admin/debug/requests", "admin"))
+	assert.Equal(t, http.StatusOK, do("/admin/debug/events", "admin"))
+
+	// the trace pages check the token on their own too
+	req := httptest.NewRequest("GET", "/admin/debug/requests", nil)
+	ok, _ := trace.AuthRequest(req)
+	assert.False(t, ok)
+	req.Header.Set("Authorization", "Bearer admin")
+	ok, _ = trace.AuthRequest(req)
+	assert.True(t, ok)
+}
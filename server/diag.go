@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+type diagRequest struct {
+	Raw bool `query:"raw"`
+}
+
+type diagRegion struct {
+	ID      uint64             `json:"id"`
+	Version uint64             `json:"version"`
+	ConfVer uint64             `json:"conf_ver"`
+	Start   string             `json:"start"`
+	End     string             `json:"end"`
+	Leader  *store.RegionPeer  `json:"leader"`
+	Peers   []store.RegionPeer `json:"peers"`
+}
+
+type diagStep struct {
+	Name     string `json:"name"`
+	Duration string `json:"duration"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type keyDiagnosis struct {
+	Key    string      `json:"key"`
+	Region *diagRegion `json:"region,omitempty"`
+	Steps  []diagStep  `json:"steps"`
+	Found  bool        `json:"found"`
+	Size   int         `json:"size"`
+}
+
+// newKeyDiagnosis has the region boundaries as stored, in base64, the key
+// as it was asked.
+func newKeyDiagnosis(key string, diag *store.KeyDiagnosis) keyDiagnosis {
+	res := keyDiagnosis{Key: key, Steps: make([]diagStep, 0, len(diag.Steps)), Found: diag.Found, Size: diag.Size}
+	if r := diag.Region; r != nil {
+		res.Region = &diagRegion{ID: r.ID, Version: r.Version, ConfVer: r.ConfVer,
+			Start: encodeBase64(r.Start), End: encodeBase64(r.End), Leader: r.Leader, Peers: r.Peers}
+	}
+	for _, st := range diag.Steps {
+		res.Steps = append(res.Steps, diagStep{Name: st.Name, Duration: st.Duration.String(),
+			Detail: st.Detail, Error: st.Error})
+	}
+	return res
+}
+
+// DiagnoseKey tells the region and the stores of a key and times a probe
+// read of it, the steps done are in the details of an error.
+func (s *Server) DiagnoseKey(c *gin.Context) {
+	req := &diagRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	keyStr := c.Param("key")
+	key, err := EncodeMetaKey(keyStr, req.Raw)
+	if err != nil {
+		s.writeError(c, xerror.ErrKeyInvalid, gin.H{"key": keyStr, "reason": err.Error()})
+		return
+	}
+	diag, err := s.store.DiagnoseKey(c.Request.Context(), key)
+	if err != nil {
+		s.logger(c).Errorf("diagnose key %s failed, %s", keyStr, err)
+		var details interface{}
+		if diag != nil {
+			details = newKeyDiagnosis(keyStr, diag)
+		}
+		s.writeError(c, err, details)
+		return
+	}
+	c.JSON(http.StatusOK, newKeyDiagnosis(keyStr, diag))
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/sirupsen/logrus"
+)
+
+// dynamicLimit bounds the keys read under the prefix, a section is a key.
+const dynamicLimit = 100
+
+// Dynamic polls the sections of the config kept under dynamic.prefix and
+// applies them over the config file when one changes. A section removed
+// goes back to the file, an invalid one is logged and the last applied
+// config is kept.
+type Dynamic struct {
+	s      *Server
+	conf   *config.Dynamic
+	last   string
+	closed chan struct{}
+	once   sync.Once
+	log    *logrus.Entry
+}
+
+func NewDynamic(s *Server, conf *config.Dynamic) *Dynamic {
+	return &Dynamic{
+		s:      s,
+		conf:   conf,
+		closed: make(chan struct{}),
+		log:    logrus.WithFields(logrus.Fields{"worker": "dynamic"}),
+	}
+}
+
+func (d *Dynamic) Start() {
+	go func() {
+		ticker := time.NewTicker(d.conf.Interval.Duration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.closed:
+				return
+			case <-ticker.C:
+				if err := d.Poll(); err != nil {
+					d.log.Debugf("poll config, %s", err)
+				}
+			}
+		}
+	}()
+}
+
+func (d *Dynamic) Close() {
+	d.once.Do(func() { close(d.closed) })
+}
+
+// Poll reads the sections and applies them if any changed since the last
+// poll.
+func (d *Dynamic) Poll() error {
+	start, err := EncodeMetaKey(d.conf.Prefix, true)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.conf.Interval.Duration)
+	defer cancel()
+	items, err := d.s.store.List(ctx, start, store.PrefixEnd(start), dynamicLimit, DefaultListOption())
+	if err != nil {
+		DynamicConfig.WithLabelValues("failed").Inc()
+		return err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	var b strings.Builder
+	for _, item := range items {
+		fmt.Fprintf(&b, "%q=%q\n", item.Key, item.Value)
+	}
+	if b.String() == d.last {
+		return nil
+	}
+	d.last = b.String()
+
+	conf := *d.s.conf
+	sections := make([]string, 0, len(items))
+	for _, item := range items {
+		section := strings.TrimPrefix(item.Key, d.conf.Prefix)
+		if err = conf.Overlay(section, []byte(item.Value)); err != nil {
+			DynamicConfig.WithLabelValues("failed").Inc()
+			d.log.Errorf("config %s not applied, %s", item.Key, err)
+			return err
+		}
+		sections = append(sections, section)
+	}
+	if errs := conf.Validate(); len(errs) > 0 {
+		DynamicConfig.WithLabelValues("failed").Inc()
+		d.log.Errorf("config %s not applied, %v", sections, errs)
+		return errs[0]
+	}
+	if err = d.apply(&conf); err != nil {
+		DynamicConfig.WithLabelValues("failed").Inc()
+		d.log.Errorf("config %s not applied, %s", sections, err)
+		return err
+	}
+	DynamicConfig.WithLabelValues("applied").Inc()
+	d.log.Infof("config applied, sections %s over the file", sections)
+	return nil
+}
+
+// apply sets the rules of the acl and the firewall enabled by the file,
+// and the mode when it changed.
+func (d *Dynamic) apply(conf *config.Config) error {
+	s := d.s
+	mode, err := ParseMode(conf.Server.Mode)
+	if err != nil {
+		return err
+	}
+	if s.acl != nil {
+		if err = s.acl.SetRules(conf.ACL.DefaultDeny, conf.ACL.Rules); err != nil {
+			return fmt.Errorf("acl, %s", err)
+		}
+	}
+	if s.firewall != nil {
+		if err = s.firewall.SetRules(&conf.Firewall); err != nil {
+			return fmt.Errorf("firewall, %s", err)
+		}
+	}
+	if mode != s.Mode() {
+		d.log.Warnf("mode %s -> %s", s.Mode(), mode)
+		s.SetMode(mode)
+	}
+	return nil
+}
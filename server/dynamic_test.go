@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/store"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// dynamicDB lists the keys of a batchDB in order.
+type dynamicDB struct {
+	batchDB
+}
+
+func (d dynamicDB) Name() string                               { return "dynamic-test" }
+func (d dynamicDB) Open(conf *config.Config) (store.DB, error) { return d, nil }
+
+func (d dynamicDB) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	keys := make([]string, 0, len(d.batchDB))
+	for k := range d.batchDB {
+		if k >= string(start) && (end == nil || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var items []store.KeyValue
+	for _, k := range keys {
+		key, val, err := option.Item([]byte(k), d.batchDB[k])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, store.KeyValue{Key: string(key), Value: string(val)})
+	}
+	return items, nil
+}
+
+func TestDynamic(t *testing.T) {
+	db := dynamicDB{batchDB{}}
+	store.RegisterDB(db)
+	conf := config.DefaultConfig()
+	conf.Store.Name = db.Name()
+	conf.ACL = config.ACL{Enable: true, Rules: []config.ACLRule{{Token: "file", Prefix: "a/", Permission: "rw"}}}
+	conf.Firewall = config.Firewall{Enable: true}
+	st, err := store.OnlyOpenDatabase(conf)
+	assert.Nil(t, err)
+	acl, _ := middleware.NewACL(&conf.ACL)
+	firewall, _ := middleware.NewFirewall(&conf.Firewall)
+	s := &Server{store: st, conf: conf, acl: acl, firewall: firewall,
+		log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	d := NewDynamic(s, &conf.Dynamic)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", firewall.Check, func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func() int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	db.batchDB["\x00tirest/config/acl"] = []byte(`{"default-deny":true,"rule":[{"token":"store","prefix":"b/","permission":"r"}],"updated_at":1}`)
+	db.batchDB["\x00tirest/config/firewall"] = []byte(`{"deny":["192.0.2.0/24"],"updated_at":1}`)
+	db.batchDB["\x00tirest/config/server"] = []byte(`{"mode":"read-only","updated_at":1}`)
+	db.batchDB["\x00tirest/other"] = []byte(`{}`)
+	assert.Nil(t, d.Poll())
+	deny, rules := acl.Rules()
+	assert.True(t, deny)
+	assert.Equal(t, []config.ACLRule{{Token: "store", Prefix: "b/", Permission: "r"}}, rules)
+	assert.Equal(t, http.StatusForbidden, get())
+	assert.Equal(t, ModeReadOnly, s.Mode())
+
+	// an invalid section is not applied, the last config is kept
+	db.batchDB["\x00tirest/config/firewall"] = []byte(`{"deny":["192.0.2.0/33"]}`)
+	assert.NotNil(t, d.Poll())
+	assert.Equal(t, http.StatusForbidden, get())
+	db.batchDB["\x00tirest/config/store"] = []byte(`{"name":"etcd"}`)
+	assert.EqualError(t, d.Poll(), "store: not a dynamic section")
+	delete(db.batchDB, "\x00tirest/config/store")
+
+	// the sections removed go back to the file
+	delete(db.batchDB, "\x00tirest/config/firewall")
+	delete(db.batchDB, "\x00tirest/config/acl")
+	db.batchDB["\x00tirest/config/server"] = []byte(`{"mode":"normal","updated_at":2}`)
+	assert.Nil(t, d.Poll())
+	deny, rules = acl.Rules()
+	assert.False(t, deny)
+	assert.Equal(t, conf.ACL.Rules, rules)
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, ModeNormal, s.Mode())
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+// writeError maps err to its status and code, errors outside the xerror
+// taxonomy are internal errors.
+func (s *Server) writeError(c *gin.Context, err error, details interface{}) {
+	// the field of a request failing is the details of its error
+	var field *model.FieldError
+	if details == nil && errors.As(err, &field) {
+		details = field
+	}
+	status := xerror.HTTPStatus(err)
+	code := xerror.CodeOf(err)
+	if code == "" {
+		code = middleware.StatusCode(status)
+	}
+	middleware.AbortWithError(c, status, code, err.Error(), details)
+}
+
+// logger tags the log entry with the request id.
+func (s *Server) logger(c *gin.Context) *logrus.Entry {
+	if id := middleware.GetRequestId(c); id != "" {
+		return s.log.WithField(middleware.RequestId, id)
+	}
+	return s.log
+}
+
+// bind sets the fields of v from the headers and the query of the request,
+// a field failing to parse or to check is answered 400 with the field in
+// the details.
+func (s *Server) bind(c *gin.Context, v interface{}) bool {
+	if err := model.Bind(c.Request, v); err != nil {
+		s.writeBindError(c, err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) writeBindError(c *gin.Context, err error) {
+	s.logger(c).Errorf("bind request, err %s", err)
+	var field *model.FieldError
+	if !errors.As(err, &field) {
+		s.writeError(c, err, nil)
+		return
+	}
+	if field.In == model.InHeader {
+		s.writeError(c, xerror.ErrInvalidHeader.Wrap(err), nil)
+		return
+	}
+	s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), nil)
+}
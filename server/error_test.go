@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	r := gin.New()
+	r.Use(middleware.SetRequestId())
+	r.GET("/", func(c *gin.Context) {
+		s.writeError(c, xerror.ErrCheckAndSetFailed, gin.H{"key": "a"})
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(middleware.RequestIdHeader, "abc")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "abc", w.Header().Get(middleware.RequestIdHeader))
+
+	resp := middleware.ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "check_and_set_failed", resp.Code)
+	assert.Equal(t, xerror.ErrCheckAndSetFailed.Error(), resp.Message)
+	assert.Equal(t, "abc", resp.RequestId)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Len(t, w.Header().Get(middleware.RequestIdHeader), 24)
+}
+
+func TestWriteErrorStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{xerror.ErrNotExists, http.StatusNotFound, "not_exists"},
+		{xerror.ErrInvalidHeader.Wrap(errors.New("bad")), http.StatusBadRequest, "invalid_header"},
+		{xerror.ErrReadBodyTimeout, 499, "read_body_timeout"},
+		{errors.New("unknown"), http.StatusInternalServerError, "internal_server_error"},
+	}
+	for _, tt := range cases {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		s.writeError(c, tt.err, nil)
+		resp := middleware.ErrorResponse{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, tt.status, w.Code)
+		assert.Equal(t, tt.code, resp.Code)
+		assert.Equal(t, tt.err.Error(), resp.Message)
+	}
+}
+
+func TestWriteErrorV2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	r := gin.New()
+	r.Use(middleware.StructuredErrors(ApiV2Route + "/"))
+	handler := func(c *gin.Context) {
+		s.writeError(c, xerror.ErrMaintenance, nil)
+	}
+	r.GET(ApiV2Route+"/health", handler)
+	r.GET(ApiRoute+"/health", handler)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", ApiV2Route+"/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	resp := middleware.ErrorResponseV2{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Error.Status)
+	assert.Equal(t, "maintenance", resp.Error.Code)
+	assert.True(t, resp.Error.Retryable)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", ApiRoute+"/health", nil))
+	v1 := middleware.ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &v1))
+	assert.Equal(t, "maintenance", v1.Code)
+}
+
+func TestBindError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		if s.bind(c, &model.List{}) && s.bind(c, &conflictsRequest{}) {
+			c.Status(http.StatusNoContent)
+		}
+	})
+	get := func(target string, header http.Header) (int, middleware.ErrorResponse) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header = header
+		r.ServeHTTP(w, req)
+		resp := middleware.ErrorResponse{}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, _ := get("/?limit=10", http.Header{})
+	assert.Equal(t, http.StatusNoContent, code)
+	code, resp := get("/", http.Header{"X-Limit": {"ten"}})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_header", resp.Code)
+	assert.Equal(t, map[string]interface{}{"in": "header", "field": "X-Limit", "value": "ten",
+		"reason": `strconv.ParseInt: parsing "ten": invalid syntax`}, resp.Details)
+	code, resp = get("/?limit=10001", http.Header{})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_argument", resp.Code)
+	assert.Equal(t, "limit", resp.Details.(map[string]interface{})["field"])
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	IdempotencyKeyHeader    = "X-Idempotency-Key"
+	IdempotentReplayHeader  = "X-Idempotent-Replayed"
+	maxIdempotencyKeyLength = 256
+)
+
+type recordWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// bodyDigest hashes the body as the handler reads it, a streamed value is
+// never held in memory.
+type bodyDigest struct {
+	io.ReadCloser
+	h hash.Hash
+}
+
+func newBodyDigest(body io.ReadCloser) *bodyDigest {
+	return &bodyDigest{ReadCloser: body, h: sha1.New()}
+}
+
+func (b *bodyDigest) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.h.Write(p[:n])
+	return n, err
+}
+
+// Sum reads what the handler left of the body.
+func (b *bodyDigest) Sum() (string, error) {
+	if _, err := io.Copy(ioutil.Discard, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b.h.Sum(nil)), nil
+}
+
+// requestDigest covers what selects the keys of a write, a list delete has
+// the range in headers. The body is covered by the BodyDigest of the record.
+func requestDigest(c *gin.Context) string {
+	h := sha1.New()
+	for _, v := range []string{c.Request.Method, c.Request.URL.Path,
+		c.GetHeader("X-Start"), c.GetHeader("X-End"), c.GetHeader("X-Raw"), c.GetHeader("X-Unsafe")} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyPrincipal is who the keys belong to, the identity of an auth
+// provider or else a hash of the access token, so a caller can't replay the
+// response recorded for another one. Empty for the anonymous requests.
+func idempotencyPrincipal(c *gin.Context) string {
+	if id := middleware.GetIdentity(c); id != nil {
+		return id.Provider + ":" + id.Subject
+	}
+	if token := middleware.AccessToken(c); token != "" {
+		sum := sha1.Sum([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// Idempotent records the outcome of a write with X-Idempotency-Key, a retry
+// with the same key gets the recorded response instead of writing again.
+func (s *Server) Idempotent(c *gin.Context) {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" || s.conf.Server.IdempotencyWindow.Duration <= 0 {
+		c.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		s.writeError(c, xerror.ErrIdempotencyKeyInvalid, gin.H{"limit": maxIdempotencyKeyLength})
+		return
+	}
+
+	rec := &store.IdempotencyRecord{
+		Method: c.Request.Method,
+		Path:   c.Request.URL.Path,
+		Digest: requestDigest(c),
+	}
+	// a header can't have a NUL, the principal and the key stay apart
+	scoped := idempotencyPrincipal(c) + "\x00" + key
+	prev, reserved, err := s.store.ReserveIdempotency(c.Request.Context(), scoped, rec)
+	if err != nil {
+		s.logger(c).Errorf("reserve idempotency key %s failed, %s", key, err)
+		s.writeError(c, err, nil)
+		return
+	}
+	if prev != nil {
+		s.replayIdempotency(c, key, rec, prev)
+		return
+	}
+
+	body := newBodyDigest(c.Request.Body)
+	c.Request.Body = body
+	w := &recordWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+
+	status := w.Status()
+	// the request context may be done, the outcome is recorded anyway
+	ctx := context.Background()
+	rec.BodyDigest, err = body.Sum()
+	// let the client retry server failures, and the requests whose body
+	// couldn't be read to the end
+	if status >= http.StatusInternalServerError || status == 499 || err != nil {
+		err = s.store.ReleaseIdempotency(ctx, reserved)
+		if err != nil {
+			s.logger(c).Errorf("release idempotency key %s failed, %s", key, err)
+		}
+		return
+	}
+	rec.Status = status
+	rec.ContentType = w.Header().Get("Content-Type")
+	rec.Body = w.body.Bytes()
+	err = s.store.SaveIdempotency(ctx, reserved, rec)
+	if err != nil {
+		s.logger(c).Errorf("save idempotency key %s failed, %s", key, err)
+	}
+}
+
+func (s *Server) replayIdempotency(c *gin.Context, key string, rec, prev *store.IdempotencyRecord) {
+	if prev.Digest != rec.Digest {
+		s.writeError(c, xerror.ErrIdempotencyKeyReused, gin.H{"method": prev.Method, "path": prev.Path})
+		return
+	}
+	if prev.Status == 0 {
+		s.writeError(c, xerror.ErrIdempotencyInProgress, nil)
+		return
+	}
+	digest, err := newBodyDigest(c.Request.Body).Sum()
+	if err != nil {
+		s.writeError(c, xerror.ErrReadBodyFailed, nil)
+		return
+	}
+	// the records written before the body was covered have no BodyDigest
+	if prev.BodyDigest != "" && prev.BodyDigest != digest {
+		s.writeError(c, xerror.ErrIdempotencyKeyReused, gin.H{"method": prev.Method, "path": prev.Path})
+		return
+	}
+	s.logger(c).Infof("replay idempotency key %s, status %d", key, prev.Status)
+	c.Header(IdempotentReplayHeader, "true")
+	if len(prev.Body) == 0 {
+		c.AbortWithStatus(prev.Status)
+		return
+	}
+	c.Abort()
+	c.Data(prev.Status, prev.ContentType, prev.Body)
+}
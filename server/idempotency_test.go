@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/store"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// idempotencyDB keeps the puts of a batchDB, an empty value deletes the key.
+type idempotencyDB struct {
+	batchDB
+}
+
+func (d idempotencyDB) Name() string                               { return "idempotency-test" }
+func (d idempotencyDB) Open(conf *config.Config) (store.DB, error) { return d, nil }
+
+func (d idempotencyDB) Put(ctx context.Context, key, val []byte) error {
+	if len(val) == 0 {
+		delete(d.batchDB, string(key))
+		return nil
+	}
+	d.batchDB[string(key)] = val
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	db := idempotencyDB{batchDB{}}
+	store.RegisterDB(db)
+	conf := config.DefaultConfig()
+	conf.Store.Name = db.Name()
+	st, err := store.OnlyOpenDatabase(conf)
+	assert.Nil(t, err)
+	s := &Server{store: st, conf: conf, log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	writes := 0
+	r.PUT("/meta/:key", s.Idempotent, func(c *gin.Context) {
+		ioutil.ReadAll(c.Request.Body)
+		writes++
+		c.Status(http.StatusNoContent)
+	})
+	token := ""
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/meta/a", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		if token != "" {
+			req.Header.Set(middleware.AccessTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, do("k1", `{"new": "1"}`).Code)
+	w := do("k1", `{"new": "1"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "true", w.Header().Get(IdempotentReplayHeader))
+	// the same key with another body is another request
+	assert.Equal(t, http.StatusUnprocessableEntity, do("k1", `{"new": "2"}`).Code)
+	assert.Equal(t, 1, writes)
+
+	// the keys of each caller are their own
+	token = "tenant-a"
+	w = do("k1", `{"new": "2"}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Header().Get(IdempotentReplayHeader))
+	assert.Equal(t, "true", do("k1", `{"new": "2"}`).Header().Get(IdempotentReplayHeader))
+	token = "tenant-b"
+	assert.Empty(t, do("k1", `{"new": "2"}`).Header().Get(IdempotentReplayHeader))
+	assert.Equal(t, 3, writes)
+	for k := range db.batchDB {
+		assert.NotContains(t, k, "tenant")
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	JobRunning  = "running"
+	JobFinished = "finished"
+	JobFailed   = "failed"
+
+	maxFinishedJobs = 100
+)
+
+type Job struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Start      string    `json:"start"`
+	End        string    `json:"end"`
+	State      string    `json:"state"`
+	Deleted    int       `json:"deleted"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+}
+
+// Jobs keeps the background jobs started by the api, finished jobs are
+// dropped oldest first once there are more than maxFinishedJobs. record is
+// called with a job once it's added and once it's finished.
+type Jobs struct {
+	mu     sync.Mutex
+	seq    int64
+	jobs   map[string]*Job
+	record func(Job)
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{
+		jobs: make(map[string]*Job),
+	}
+}
+
+func (j *Jobs) Add(kind, start, end string) *Job {
+	j.mu.Lock()
+	j.seq++
+	job := &Job{
+		ID:        strconv.FormatInt(j.seq, 10),
+		Kind:      kind,
+		Start:     start,
+		End:       end,
+		State:     JobRunning,
+		StartedAt: time.Now(),
+	}
+	j.jobs[job.ID] = job
+	j.gc()
+	added := *job
+	j.mu.Unlock()
+	if j.record != nil {
+		j.record(added)
+	}
+	return job
+}
+
+func (j *Jobs) Progress(job *Job, deleted int) {
+	j.mu.Lock()
+	job.Deleted = deleted
+	j.mu.Unlock()
+}
+
+func (j *Jobs) Finish(job *Job, err error) {
+	j.mu.Lock()
+	job.FinishedAt = time.Now()
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.State = JobFinished
+	}
+	finished := *job
+	j.mu.Unlock()
+	if j.record != nil {
+		j.record(finished)
+	}
+}
+
+func (j *Jobs) List() []Job {
+	j.mu.Lock()
+	list := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		list = append(list, *job)
+	}
+	j.mu.Unlock()
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].StartedAt.Before(list[b].StartedAt)
+	})
+	return list
+}
+
+func (j *Jobs) gc() {
+	finished := make([]*Job, 0)
+	for _, job := range j.jobs {
+		if job.State != JobRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].FinishedAt.Before(finished[b].FinishedAt)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedJobs] {
+		delete(j.jobs, job.ID)
+	}
+}
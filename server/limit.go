@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// cas body carries both the old and the new value
+const casBodyOverhead = 1024
+
+// checkKeyLength writes 413 when the decoded key is longer than max-key-length.
+func (s *Server) checkKeyLength(c *gin.Context, key []byte) bool {
+	limit := s.conf.Server.MaxKeyLength
+	if limit > 0 && len(key)-1 > limit {
+		s.logger(c).Errorf("key length %d > %d", len(key)-1, limit)
+		s.writeError(c, xerror.ErrKeyTooLarge, gin.H{"limit": limit})
+		return false
+	}
+	return true
+}
+
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(r.Body)
+	}
+	if r.ContentLength > limit {
+		return nil, xerror.ErrValueTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, xerror.ErrValueTooLarge
+	}
+	return body, nil
+}
+
+// readBody reads at most limit bytes and writes the error response on failure.
+func (s *Server) readBody(c *gin.Context, limit int64) ([]byte, bool) {
+	body, err := readBody(c.Request, limit)
+	if err == nil {
+		return body, true
+	}
+	s.writeBodyError(c, err, limit)
+	return nil, false
+}
+
+func (s *Server) writeBodyError(c *gin.Context, err error, limit int64) {
+	s.logger(c).Errorf("read body failed: %s", err)
+	if errors.Is(err, xerror.ErrValueTooLarge) {
+		s.writeError(c, err, gin.H{"limit": limit})
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		s.writeError(c, xerror.ErrReadBodyTimeout.Wrap(err), nil)
+	} else {
+		s.writeError(c, xerror.ErrReadBodyFailed.Wrap(err), nil)
+	}
+}
+
+// bodyReader reads the body up to limit while the store streams it, its own
+// errors are kept to be told from the errors of the store.
+type bodyReader struct {
+	r     io.Reader
+	limit int64
+	n     int64
+	err   error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	if b.limit > 0 && b.n > b.limit {
+		b.err = xerror.ErrValueTooLarge
+		return n, b.err
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadBody(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/", strings.NewReader("abcd"))
+	body, err := readBody(r, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcd", string(body))
+
+	r = httptest.NewRequest("PUT", "/", strings.NewReader("abcde"))
+	_, err = readBody(r, 4)
+	assert.Equal(t, xerror.ErrValueTooLarge, err)
+
+	// chunked body without content length
+	r = httptest.NewRequest("PUT", "/", strings.NewReader("abcde"))
+	r.ContentLength = -1
+	_, err = readBody(r, 4)
+	assert.Equal(t, xerror.ErrValueTooLarge, err)
+
+	r = httptest.NewRequest("PUT", "/", strings.NewReader("abcde"))
+	body, err = readBody(r, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "abcde", string(body))
+}
+
+func TestBodyReader(t *testing.T) {
+	b := &bodyReader{r: strings.NewReader("abcd"), limit: 4}
+	data, err := ioutil.ReadAll(b)
+	assert.Nil(t, err)
+	assert.Nil(t, b.err)
+	assert.Equal(t, "abcd", string(data))
+
+	b = &bodyReader{r: strings.NewReader("abcde"), limit: 4}
+	_, err = ioutil.ReadAll(b)
+	assert.Equal(t, xerror.ErrValueTooLarge, err)
+	assert.Equal(t, xerror.ErrValueTooLarge, b.err)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+// logLevel switches the logrus level at runtime, a temporary level reverts
+// to the base level when its timer fires.
+type logLevel struct {
+	mu       sync.Mutex
+	base     logrus.Level
+	timer    *time.Timer
+	revertAt time.Time
+}
+
+func newLogLevel() *logLevel {
+	return &logLevel{base: logrus.GetLevel()}
+}
+
+func (l *logLevel) set(level logrus.Level, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+		l.revertAt = time.Time{}
+	}
+	logrus.SetLevel(level)
+	if d <= 0 {
+		l.base = level
+		return
+	}
+	l.revertAt = time.Now().Add(d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.timer != t {
+			// replaced by a later set
+			return
+		}
+		l.timer = nil
+		l.revertAt = time.Time{}
+		logrus.SetLevel(l.base)
+	})
+	l.timer = t
+}
+
+// toggle switches between debug and the base level.
+func (l *logLevel) toggle() logrus.Level {
+	level := logrus.DebugLevel
+	if logrus.GetLevel() == logrus.DebugLevel {
+		level = l.base
+	}
+	l.mu.Lock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+		l.revertAt = time.Time{}
+	}
+	logrus.SetLevel(level)
+	l.mu.Unlock()
+	return level
+}
+
+func (l *logLevel) status() gin.H {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := gin.H{"level": logrus.GetLevel().String(), "base": l.base.String()}
+	if !l.revertAt.IsZero() {
+		h["revert_at"] = l.revertAt
+	}
+	return h
+}
+
+// ToggleLogLevel is called on SIGUSR1.
+func (s *Server) ToggleLogLevel() {
+	level := s.logLevel.toggle()
+	s.log.Warnf("log level -> %s", level)
+	s.auditor.Record(AuditRecord{Method: "SIGNAL", Route: "SIGUSR1", Query: "level=" + level.String()})
+}
+
+func (s *Server) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, s.logLevel.status())
+}
+
+type logLevelRequest struct {
+	Level    string        `query:"level" binding:"required"`
+	Duration time.Duration `query:"duration" binding:"min=0"`
+}
+
+// PutLogLevel sets the level, with duration the level only lasts that long.
+func (s *Server) PutLogLevel(c *gin.Context) {
+	req := &logLevelRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), gin.H{"level": req.Level})
+		return
+	}
+	d := req.Duration
+	s.logger(c).Warnf("log level %s -> %s for %s", logrus.GetLevel(), level, d)
+	s.logLevel.set(level, d)
+	c.JSON(http.StatusOK, s.logLevel.status())
+}
@@ -12,6 +12,79 @@ var MaxProcs = prometheus.NewGauge(
 		Help:      "The value of GOMAXPROCS.",
 	})
 
+var BuildInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: version.APP,
+		Name:      "build_info",
+		Help:      "A constant 1 labeled by the version of the build.",
+	}, []string{"git_describe", "git_commit", "api", "go_version"})
+
+var ServerMode = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Subsystem: version.APP,
+		Name:      "mode",
+		Help:      "The server mode, 0 normal, 1 read-only, 2 maintenance.",
+	})
+
+var Connections = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Subsystem: version.APP,
+		Name:      "http_connections",
+		Help:      "The open connections by server and state, new, active or idle.",
+	}, []string{"server", "state"})
+
+var ConnectionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: version.APP,
+		Name:      "http_connections_total",
+		Help:      "A counter for accepted connections by server.",
+	}, []string{"server"})
+
+var PeerMembers = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Subsystem: version.APP,
+		Name:      "peer_members",
+		Help:      "The members alive on the hash ring of the peers.",
+	})
+
+var Forwarded = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: version.APP,
+		Name:      "forwarded_total",
+		Help:      "A counter for requests forwarded to the peer owning the key by result, ok or failed.",
+	}, []string{"result"})
+
+var DynamicConfig = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: version.APP,
+		Name:      "dynamic_config_total",
+		Help:      "A counter for changes of the config kept in the store by result, applied or failed.",
+	}, []string{"result"})
+
+var ClientApiVersion = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: version.APP,
+		Name:      "client_api_version_total",
+		Help:      "A counter for api requests by the X-Api-Version of the client, none without it or unsupported.",
+	}, []string{"version"})
+
+var ListOverBytes = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Subsystem: version.APP,
+		Name:      "list_over_bytes_total",
+		Help:      "A counter for lists stopped over their bytes with a cursor.",
+	})
+
+var ClusterRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Subsystem: version.APP,
+		Name:      "cluster_requests_total",
+		Help:      "A counter for requests served by another cluster, by its id.",
+	}, []string{"cluster"})
+
 func init() {
-	prometheus.MustRegister(MaxProcs)
+	prometheus.MustRegister(MaxProcs, BuildInfo, ServerMode, Connections, ConnectionsTotal, PeerMembers, Forwarded,
+		DynamicConfig, ClientApiVersion, ListOverBytes, ClusterRequests)
+	info := version.Get()
+	BuildInfo.WithLabelValues(info.GitDescribe, info.GitCommit, info.API, info.GoVersion).Set(1)
 }
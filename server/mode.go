@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+type Mode int32
+
+const (
+	ModeNormal Mode = iota
+	// ModeReadOnly rejects writes
+	ModeReadOnly
+	// ModeMaintenance rejects everything except health
+	ModeMaintenance
+)
+
+const retryAfter = "30"
+
+var modeNames = []string{"normal", "read-only", "maintenance"}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return "unknown"
+}
+
+func ParseMode(s string) (Mode, error) {
+	if s == "" {
+		return ModeNormal, nil
+	}
+	for i, name := range modeNames {
+		if name == s {
+			return Mode(i), nil
+		}
+	}
+	return ModeNormal, fmt.Errorf("invalid mode %q", s)
+}
+
+func (s *Server) Mode() Mode {
+	return Mode(atomic.LoadInt32(&s.mode))
+}
+
+func (s *Server) SetMode(m Mode) {
+	atomic.StoreInt32(&s.mode, int32(m))
+	ServerMode.Set(float64(m))
+}
+
+func isWrite(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+var healthRoutes = map[string]bool{
+	path.Join(ApiRoute, "/health"):   true,
+	path.Join(ApiV2Route, "/health"): true,
+}
+
+func (s *Server) checkMode(c *gin.Context) {
+	switch s.Mode() {
+	case ModeMaintenance:
+		if healthRoutes[c.FullPath()] {
+			break
+		}
+		c.Header("Retry-After", retryAfter)
+		s.writeError(c, xerror.ErrMaintenance, nil)
+		return
+	case ModeReadOnly:
+		// the puts of a batch are refused one by one, a v2 list only reads
+		if isWrite(c.Request.Method) && c.FullPath() != batchRoute && c.FullPath() != batchV2Route &&
+			!(c.Request.Method == http.MethodPost && c.FullPath() == listV2Route) {
+			c.Header("Retry-After", retryAfter)
+			s.writeError(c, xerror.ErrReadOnly, nil)
+			return
+		}
+	}
+	c.Next()
+}
+
+func (s *Server) GetMode(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"mode": s.Mode().String()})
+}
+
+type modeRequest struct {
+	Mode string `query:"mode" binding:"required"`
+}
+
+func (s *Server) PutMode(c *gin.Context) {
+	req := &modeRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	m, err := ParseMode(req.Mode)
+	if err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), gin.H{"modes": modeNames})
+		return
+	}
+	s.logger(c).Warnf("mode %s -> %s", s.Mode(), m)
+	s.SetMode(m)
+	c.JSON(http.StatusOK, gin.H{"mode": m.String()})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+	r := gin.New()
+	api := r.Group(ApiRoute, s.checkMode)
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	api.GET("/health", ok)
+	api.GET("/meta/:key", ok)
+	api.PUT("/meta/:key", ok)
+
+	do := func(method, path string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+	get, put, health := ApiRoute+"/meta/a", ApiRoute+"/meta/a", ApiRoute+"/health"
+
+	assert.Equal(t, http.StatusNoContent, do("PUT", put))
+
+	s.SetMode(ModeReadOnly)
+	assert.Equal(t, http.StatusNoContent, do("GET", get))
+	assert.Equal(t, http.StatusServiceUnavailable, do("PUT", put))
+
+	s.SetMode(ModeMaintenance)
+	assert.Equal(t, http.StatusServiceUnavailable, do("GET", get))
+	assert.Equal(t, http.StatusNoContent, do("GET", health))
+
+	m, err := ParseMode("read-only")
+	assert.Nil(t, err)
+	assert.Equal(t, ModeReadOnly, m)
+	_, err = ParseMode("readonly")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+)
+
+const (
+	OpenAPIRoute   = "/openapi.json"
+	SwaggerUIRoute = "/swagger"
+	VersionRoute   = "/version"
+)
+
+// apiDoc describes a route of the api, the params are the header and the
+// query tags of Request, the bodies are the json of Body and Result.
+type apiDoc struct {
+	Summary    string
+	Request    interface{}
+	Idempotent bool
+	Body       interface{}
+	RawBody    bool
+	Status     int
+	Result     interface{}
+	RawResult  bool
+	Stream     bool
+}
+
+func apiDocs() map[string]apiDoc {
+	meta := path.Join(ApiRoute, "/meta/:key")
+	unsafeMeta := path.Join(ApiRoute, UnsafeRoute, "/meta/:key")
+	list := path.Join(ApiRoute, "/list")
+	cas := apiDoc{Summary: "Compare the old value and set the new one", Request: model.Meta{}, Idempotent: true,
+		Body: store.Log{}, Status: http.StatusNoContent}
+	put := apiDoc{Summary: "Put the value without a check", Request: model.Meta{}, Idempotent: true,
+		RawBody: true, Status: http.StatusNoContent}
+	return map[string]apiDoc{
+		http.MethodGet + " " + meta: {Summary: "Get the value of a key", Request: model.Meta{},
+			Status: http.StatusOK, RawResult: true},
+		http.MethodPut + " " + meta:  cas,
+		http.MethodPost + " " + meta: cas,
+		http.MethodGet + " " + list: {Summary: "List the keys from X-Start to X-End", Request: model.List{},
+			Status: http.StatusOK, Result: []store.KeyValue{}},
+		http.MethodDelete + " " + list: {Summary: "Delete the keys from X-Start to X-End in a job",
+			Request: model.List{}, Idempotent: true, Status: http.StatusNoContent},
+		http.MethodGet + " " + path.Join(ApiRoute, "/health"): {Summary: "Check the store",
+			Request: model.Health{}, Status: http.StatusOK, Result: store.Status{}},
+		http.MethodPost + " " + path.Join(ApiRoute, "/batch"): {Summary: "Run gets and puts in one request",
+			Idempotent: true, Body: model.Batch{}, Status: http.StatusOK, Result: BatchResponse{}},
+		http.MethodGet + " " + path.Join(ApiRoute, "/watch"): {Summary: "Stream the changes under a prefix",
+			Request: model.Watch{}, Status: http.StatusOK, Stream: true},
+		http.MethodDelete + " " + unsafeMeta: {Summary: "Delete a key without a check", Request: model.Meta{},
+			Idempotent: true, Status: http.StatusNoContent},
+		http.MethodPut + " " + unsafeMeta:  put,
+		http.MethodPost + " " + unsafeMeta: put,
+		http.MethodPost + " " + listV2Route: {Summary: "List a page of the range of the body",
+			Body: model.ListRequest{}, Status: http.StatusOK, Result: ListResponse{}},
+		http.MethodDelete + " " + listV2Route: {Summary: "Delete the range of the body in a job",
+			Idempotent: true, Body: model.ListRequest{}, Status: http.StatusNoContent},
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf is the json schema of the values of t marshaled to json.
+func schemaOf(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+// tagValue is a number or a bool of a tag as is, e.g. a default.
+func tagValue(s string) interface{} {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
+	}
+	return s
+}
+
+// requestParams are the fields of v with a header or a query tag, with the
+// rules of their binding tags.
+func requestParams(v interface{}) []interface{} {
+	var params []interface{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		in, name := "header", f.Tag.Get("header")
+		if name == "" {
+			in, name = "query", f.Tag.Get("query")
+		}
+		if name == "" {
+			continue
+		}
+		schema := schemaOf(f.Type)
+		param := map[string]interface{}{"name": name, "in": in, "schema": schema}
+		if d := f.Tag.Get("default"); d != "" {
+			schema["default"] = tagValue(d)
+		}
+		for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+			switch {
+			case rule == "required":
+				param["required"] = true
+			case strings.HasPrefix(rule, "min="):
+				schema["minimum"] = tagValue(rule[len("min="):])
+			case strings.HasPrefix(rule, "max="):
+				schema["maximum"] = tagValue(rule[len("max="):])
+			}
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+func jsonContent(v interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaOf(reflect.TypeOf(v))}}
+}
+
+func rawContent(mime string, format string) map[string]interface{} {
+	schema := map[string]interface{}{"type": "string"}
+	if format != "" {
+		schema["format"] = format
+	}
+	return map[string]interface{}{mime: map[string]interface{}{"schema": schema}}
+}
+
+func (d apiDoc) operation(id string, pathParams []string, errorSchema string) map[string]interface{} {
+	params := make([]interface{}, 0)
+	for _, p := range pathParams {
+		params = append(params, map[string]interface{}{"name": p, "in": "path", "required": true,
+			"description": "base64 url of the key without padding, or the key with X-Raw",
+			"schema":      map[string]interface{}{"type": "string"}})
+	}
+	if d.Request != nil {
+		params = append(params, requestParams(d.Request)...)
+	}
+	if d.Idempotent {
+		params = append(params, map[string]interface{}{"name": IdempotencyKeyHeader, "in": "header",
+			"schema": map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKeyLength}})
+	}
+
+	res := map[string]interface{}{"description": http.StatusText(d.Status)}
+	switch {
+	case d.Result != nil:
+		res["content"] = jsonContent(d.Result)
+	case d.RawResult:
+		res["content"] = rawContent("application/octet-stream", "binary")
+	case d.Stream:
+		res["content"] = rawContent("text/event-stream", "")
+	}
+	op := map[string]interface{}{
+		"operationId": id,
+		"summary":     d.Summary,
+		"parameters":  params,
+		"responses": map[string]interface{}{
+			fmt.Sprint(d.Status): res,
+			"default": map[string]interface{}{"description": "error", "content": map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": map[string]interface{}{"$ref": "#/components/schemas/" + errorSchema}}}},
+		},
+	}
+	if d.Body != nil {
+		op["requestBody"] = map[string]interface{}{"required": true, "content": jsonContent(d.Body)}
+	} else if d.RawBody {
+		op["requestBody"] = map[string]interface{}{"required": true,
+			"content": rawContent("application/octet-stream", "binary")}
+	}
+	return op
+}
+
+// handlerName is the method name of a handler, e.g. Get for
+// github.com/huangnauh/tirest/server.(*Server).Get-fm.
+func handlerName(handler string) string {
+	name := strings.TrimSuffix(handler[strings.LastIndex(handler, ".")+1:], "-fm")
+	if name == "" {
+		return handler
+	}
+	return name
+}
+
+// openAPI documents the routes under the api routes, a route missing from
+// the docs only has its path and its handler. A v2 route shared with v1 has
+// the doc of the v1 route.
+func openAPI(routes gin.RoutesInfo) ([]byte, error) {
+	// a handler of both put and post is named by the put
+	rank := map[string]int{http.MethodGet: 1, http.MethodPut: 2, http.MethodDelete: 3, http.MethodPost: 4}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		if rank[routes[i].Method] != rank[routes[j].Method] {
+			return rank[routes[i].Method] < rank[routes[j].Method]
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	docs := apiDocs()
+	paths := map[string]map[string]interface{}{}
+	ids := map[string]bool{}
+	for _, r := range routes {
+		v2 := strings.HasPrefix(r.Path, ApiV2Route+"/")
+		if !v2 && !strings.HasPrefix(r.Path, ApiRoute+"/") {
+			continue
+		}
+		route := strings.TrimSuffix(r.Path, "/")
+		var params []string
+		segments := strings.Split(route, "/")
+		for i, s := range segments {
+			if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+				params = append(params, s[1:])
+				segments[i] = "{" + s[1:] + "}"
+			}
+		}
+		p := strings.Join(segments, "/")
+		method := strings.ToLower(r.Method)
+		if _, ok := paths[p][method]; ok {
+			continue
+		}
+		id := handlerName(r.Handler)
+		if v2 && !strings.HasSuffix(id, "V2") {
+			id += "V2"
+		}
+		if ids[id] {
+			id += strings.ToUpper(method[:1]) + method[1:]
+		}
+		ids[id] = true
+		d, ok := docs[r.Method+" "+route]
+		if !ok && v2 {
+			d, ok = docs[r.Method+" "+ApiRoute+strings.TrimPrefix(route, ApiV2Route)]
+		}
+		if !ok {
+			d = apiDoc{Summary: id, Status: http.StatusOK}
+		}
+		if paths[p] == nil {
+			paths[p] = map[string]interface{}{}
+		}
+		errorSchema := "Error"
+		if v2 {
+			errorSchema = "ErrorV2"
+		}
+		paths[p][method] = d.operation(id, params, errorSchema)
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   version.APP,
+			"version": version.GitDescribe,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Error":   schemaOf(reflect.TypeOf(middleware.ErrorResponse{})),
+				"ErrorV2": schemaOf(reflect.TypeOf(middleware.ErrorResponseV2{})),
+			},
+			"securitySchemes": map[string]interface{}{
+				"token":  map[string]interface{}{"type": "apiKey", "in": "header", "name": middleware.AccessTokenHeader},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"basic":  map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+		// without the acl no credentials are needed
+		"security": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"token": []string{}},
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"basic": []string{}},
+		},
+	}
+	return json.Marshal(doc)
+}
+
+func (s *Server) OpenAPI(c *gin.Context) {
+	s.writeData(c, http.StatusOK, "application/json", s.openapi)
+}
+
+// Version is the build of the server, the same as the version of the cli.
+func (s *Server) Version(c *gin.Context) {
+	info := version.Get()
+	c.JSON(http.StatusOK, gin.H{"info": info, "version": info.String()})
+}
+
+var swaggerUI = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>tirest api</title>
+<link rel="stylesheet" href="{{.}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.}}/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({url: "` + OpenAPIRoute + `", dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`))
+
+// SwaggerUI loads the swagger-ui-dist assets from admin.swagger-ui.
+func (s *Server) SwaggerUI(c *gin.Context) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := swaggerUI.Execute(c.Writer, strings.TrimSuffix(s.conf.Admin.SwaggerUI, "/")); err != nil {
+		s.logger(c).Errorf("render swagger ui failed, %s", err)
+	}
+}
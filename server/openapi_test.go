@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPI(t *testing.T) {
+	router := gin.New()
+	s := &Server{router: router, adminRouter: router, conf: config.DefaultConfig(),
+		log: logrus.WithFields(logrus.Fields{"worker": "server"})}
+	assert.Nil(t, s.registerRoutes())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, OpenAPIRoute, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	doc := struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			RequestBody map[string]interface{} `json:"requestBody"`
+		} `json:"paths"`
+	}{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &doc))
+
+	get := doc.Paths["/api/v1/meta/{key}"]["get"]
+	assert.Equal(t, "Get", get.OperationID)
+	assert.Equal(t, "key", get.Parameters[0].Name)
+	assert.Equal(t, "path", get.Parameters[0].In)
+	assert.NotNil(t, doc.Paths["/api/v1/meta/{key}"]["put"].RequestBody)
+	// the trailing slash routes are the same path
+	list := doc.Paths["/api/v1/list"]["get"]
+	names := []string{}
+	for _, p := range list.Parameters {
+		names = append(names, p.Name)
+	}
+	assert.Contains(t, names, "X-Start")
+	assert.Contains(t, names, "X-Limit")
+	_, ok := doc.Paths["/api/v1/list/"]
+	assert.False(t, ok)
+	// v2 shares the handlers of v1 and lists with a body
+	assert.Equal(t, "GetV2", doc.Paths["/api/v2/meta/{key}"]["get"].OperationID)
+	assert.Equal(t, "ListV2", doc.Paths["/api/v2/list"]["post"].OperationID)
+	assert.NotNil(t, doc.Paths["/api/v2/list"]["post"].RequestBody)
+	// the admin routes aren't part of the api
+	_, ok = doc.Paths["/admin/jobs"]
+	assert.False(t, ok)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, SwaggerUIRoute, nil))
+	assert.Contains(t, w.Body.String(), "https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js")
+}
+
+func TestVersion(t *testing.T) {
+	router := gin.New()
+	s := &Server{router: router, adminRouter: router, conf: config.DefaultConfig(),
+		log: logrus.WithFields(logrus.Fields{"worker": "server"})}
+	assert.Nil(t, s.registerRoutes())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, VersionRoute, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	res := struct {
+		Info    version.Info `json:"info"`
+		Version string       `json:"version"`
+	}{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, version.Get(), res.Info)
+	assert.Equal(t, version.Get().String(), res.Version)
+	assert.Equal(t, runtime.Version(), res.Info.GoVersion)
+
+	info := version.Get()
+	assert.Equal(t, 1.0, testutil.ToFloat64(BuildInfo.WithLabelValues(info.GitDescribe, info.GitCommit, info.API, info.GoVersion)))
+}
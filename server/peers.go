@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/utils/hashring"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PeerTokenHeader   = "X-Peer-Token"
+	ForwardedByHeader = "X-Forwarded-By"
+)
+
+var PeersRoute = path.Join(ApiRoute, "/peers")
+
+// PeerMember is a member as gossiped, the heartbeat only grows while the
+// member is alive, it starts from the clock so a restart goes on growing.
+type PeerMember struct {
+	Address   string `json:"address"`
+	Heartbeat uint64 `json:"heartbeat"`
+}
+
+type peerState struct {
+	heartbeat uint64
+	seen      time.Time
+}
+
+// Peers is the membership of the fleet, each round the heartbeat of this
+// instance is sent with the members alive to a random member or seed, and
+// its members are merged back. The keys are owned on a hash ring of the
+// members alive.
+type Peers struct {
+	conf      *config.Peers
+	self      string
+	client    *http.Client
+	transport http.RoundTripper
+	mu        sync.Mutex
+	members   map[string]*peerState
+	ring      atomic.Value
+	closed    chan struct{}
+	once      sync.Once
+	log       *logrus.Entry
+}
+
+func NewPeers(conf *config.Peers) *Peers {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: conf.ForwardTimeout.Duration, KeepAlive: 30 * time.Second}).DialContext,
+		MaxIdleConnsPerHost:   64,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: conf.ForwardTimeout.Duration,
+	}
+	p := &Peers{
+		conf:      conf,
+		self:      conf.Advertise,
+		client:    &http.Client{Timeout: conf.GossipInterval.Duration, Transport: transport},
+		transport: transport,
+		members: map[string]*peerState{
+			conf.Advertise: {heartbeat: uint64(time.Now().UnixNano()), seen: time.Now()},
+		},
+		closed: make(chan struct{}),
+		log:    logrus.WithFields(logrus.Fields{"worker": "peers"}),
+	}
+	p.ring.Store(hashring.New(conf.Replicas, []string{p.self}))
+	PeerMembers.Set(1)
+	return p
+}
+
+func (p *Peers) Start() {
+	go func() {
+		ticker := time.NewTicker(p.conf.GossipInterval.Duration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.closed:
+				return
+			case <-ticker.C:
+				p.gossip()
+			}
+		}
+	}()
+}
+
+func (p *Peers) Close() {
+	p.once.Do(func() { close(p.closed) })
+}
+
+// Owner is the member owning key, this instance if it's alone.
+func (p *Peers) Owner(key []byte) string {
+	return p.ring.Load().(*hashring.Ring).Get(key)
+}
+
+// Self is the address of this instance.
+func (p *Peers) Self() string {
+	return p.self
+}
+
+// Alive lists the members heard of within dead-after, by address.
+func (p *Peers) Alive() []PeerMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.alive(time.Now())
+}
+
+func (p *Peers) alive(now time.Time) []PeerMember {
+	members := make([]PeerMember, 0, len(p.members))
+	for addr, m := range p.members {
+		if addr == p.self || now.Sub(m.seen) < p.conf.DeadAfter.Duration {
+			members = append(members, PeerMember{Address: addr, Heartbeat: m.heartbeat})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Address < members[j].Address })
+	return members
+}
+
+// Merge takes the members gossiped by another instance, a member is only
+// seen alive again when its heartbeat grew, so a member which died can't
+// be brought back by an instance late to notice.
+func (p *Peers) Merge(members []PeerMember) []PeerMember {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range members {
+		if m.Address == "" || m.Address == p.self {
+			continue
+		}
+		known, ok := p.members[m.Address]
+		if !ok {
+			p.members[m.Address] = &peerState{heartbeat: m.Heartbeat, seen: now}
+		} else if m.Heartbeat > known.heartbeat {
+			known.heartbeat, known.seen = m.Heartbeat, now
+		}
+	}
+	return p.update(now)
+}
+
+// update drops the members dead for long enough that nobody gossips them
+// anymore, and rebuilds the ring if the members alive changed.
+func (p *Peers) update(now time.Time) []PeerMember {
+	for addr, m := range p.members {
+		if addr != p.self && now.Sub(m.seen) > 2*p.conf.DeadAfter.Duration {
+			delete(p.members, addr)
+		}
+	}
+	alive := p.alive(now)
+	addrs := make([]string, 0, len(alive))
+	for _, m := range alive {
+		addrs = append(addrs, m.Address)
+	}
+	ring := p.ring.Load().(*hashring.Ring)
+	if strings.Join(addrs, ",") != strings.Join(ring.Members(), ",") {
+		p.log.Infof("members %v -> %v", ring.Members(), addrs)
+		p.ring.Store(hashring.New(p.conf.Replicas, addrs))
+		PeerMembers.Set(float64(len(addrs)))
+	}
+	return alive
+}
+
+// target picks a member alive or a seed to gossip with.
+func (p *Peers) target() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	me := p.members[p.self]
+	me.heartbeat++
+	me.seen = time.Now()
+	candidates := make([]string, 0, len(p.members)+len(p.conf.Seeds))
+	for _, m := range p.update(me.seen) {
+		if m.Address != p.self {
+			candidates = append(candidates, m.Address)
+		}
+	}
+	for _, seed := range p.conf.Seeds {
+		if _, ok := p.members[seed]; !ok && seed != p.self {
+			candidates = append(candidates, seed)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+func (p *Peers) gossip() {
+	addr := p.target()
+	if addr == "" {
+		return
+	}
+	body, err := json.Marshal(p.Alive())
+	if err != nil {
+		p.log.Errorf("marshal members failed, %s", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+PeersRoute, bytes.NewReader(body))
+	if err != nil {
+		p.log.Errorf("gossip to %s failed, %s", addr, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(PeerTokenHeader, p.conf.Token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.log.Debugf("gossip to %s failed, %s", addr, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		p.log.Warnf("gossip to %s failed, status %d", addr, resp.StatusCode)
+		return
+	}
+	var members []PeerMember
+	if err = json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		p.log.Warnf("gossip from %s invalid, %s", addr, err)
+		return
+	}
+	p.Merge(members)
+}
+
+func (p *Peers) checkToken(c *gin.Context) bool {
+	return subtle.ConstantTimeCompare([]byte(c.GetHeader(PeerTokenHeader)), []byte(p.conf.Token)) == 1
+}
+
+// Gossip merges the members of another instance and answers with its own.
+func (s *Server) Gossip(c *gin.Context) {
+	if !s.peers.checkToken(c) {
+		s.logger(c).Warnf("gossip from %s with an invalid token", c.Request.RemoteAddr)
+		s.writeError(c, xerror.ErrPeerTokenInvalid, nil)
+		return
+	}
+	var members []PeerMember
+	if err := c.ShouldBindJSON(&members); err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), nil)
+		return
+	}
+	c.JSON(http.StatusOK, s.peers.Merge(members))
+}
+
+// ListPeers lists the members alive and marks this instance.
+func (s *Server) ListPeers(c *gin.Context) {
+	if s.peers == nil {
+		s.writeError(c, xerror.ErrNotSupported, gin.H{"reason": "peers are disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"self": s.peers.Self(), "members": s.peers.Alive()})
+}
+
+// forward sends a request for a key owned by another member to it, so the
+// cache of the owner serves the key and its writes invalidate it. A request
+// forwarded already is served here, with the client address of the member
+// which forwarded it, it's rejected without the peer token. A get is served
+// here too if the owner can't be reached, a write fails instead since its
+// body may be gone.
+func (s *Server) forward(c *gin.Context) {
+	if s.peers == nil {
+		c.Next()
+		return
+	}
+	if c.GetHeader(ForwardedByHeader) != "" {
+		if !s.peers.checkToken(c) {
+			s.logger(c).Warnf("forwarded request from %s with an invalid token", c.Request.RemoteAddr)
+			middleware.AbortWithError(c, http.StatusUnauthorized, middleware.StatusCode(http.StatusUnauthorized),
+				"invalid peer token", nil)
+			return
+		}
+		forwardedFor(c)
+		c.Next()
+		return
+	}
+	owner := s.peers.Owner([]byte(c.Param("key")))
+	if owner == "" || owner == s.peers.Self() {
+		c.Next()
+		return
+	}
+
+	var failed error
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = owner
+			req.Header.Set(ForwardedByHeader, s.peers.Self())
+			req.Header.Set(PeerTokenHeader, s.peers.conf.Token)
+		},
+		Transport: s.peers.transport,
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			failed = err
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+	if failed == nil {
+		Forwarded.WithLabelValues("ok").Inc()
+		c.Abort()
+		return
+	}
+	Forwarded.WithLabelValues("failed").Inc()
+	s.logger(c).Warnf("forward %s %s to %s failed, %s", c.Request.Method, c.Request.URL.Path, owner, failed)
+	if isWrite(c.Request.Method) {
+		s.writeError(c, xerror.ErrPeerUnavailable.Wrap(failed), gin.H{"owner": owner})
+		return
+	}
+	c.Next()
+}
+
+// forwardedFor takes the client address appended by the reverse proxy of
+// the member, for the ACL rules by ip.
+func forwardedFor(c *gin.Context) {
+	list := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	ip := net.ParseIP(strings.TrimSpace(list[len(list)-1]))
+	if ip != nil {
+		c.Request.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPeerTest(t *testing.T, seeds ...string) (*Server, *httptest.Server) {
+	ts := httptest.NewUnstartedServer(nil)
+	conf := config.DefaultConfig().Peers
+	conf.Enable = true
+	conf.Advertise = ts.Listener.Addr().String()
+	conf.Seeds = seeds
+	conf.Token = "secret"
+	conf.GossipInterval = &config.Duration{Duration: 10 * time.Millisecond}
+	conf.DeadAfter = &config.Duration{Duration: 100 * time.Millisecond}
+	s := &Server{peers: NewPeers(&conf), log: logrus.WithFields(logrus.Fields{"worker": "test"})}
+
+	r := gin.New()
+	r.POST(PeersRoute, s.Gossip)
+	served := func(c *gin.Context) {
+		c.String(http.StatusOK, "%s %s", s.peers.Self(), c.Request.RemoteAddr)
+	}
+	r.GET(ApiRoute+"/meta/:key", s.forward, served)
+	r.PUT(ApiRoute+"/meta/:key", s.forward, served)
+	ts.Config.Handler = r
+	ts.Start()
+	return s, ts
+}
+
+func TestPeers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, tsA := newPeerTest(t)
+	defer tsA.Close()
+	b, tsB := newPeerTest(t, a.peers.Self())
+	a.peers.Start()
+	b.peers.Start()
+	defer a.peers.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(a.peers.Alive()) == 2 && len(b.peers.Alive()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the key of b is forwarded by a
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("k%d", i)
+		if a.peers.Owner([]byte(key)) == b.peers.Self() {
+			break
+		}
+	}
+	assert.Equal(t, b.peers.Self(), b.peers.Owner([]byte(key)))
+	get := func(ts *httptest.Server) (int, string) {
+		resp, err := http.Get(ts.URL + ApiRoute + "/meta/" + key)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	code, body := get(tsA)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, b.peers.Self()+" 127.0.0.1:0", body)
+
+	// b is dropped once it stops, a get of its key is served by a, a put fails
+	b.peers.Close()
+	tsB.Close()
+	code, body = get(tsA)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, a.peers.Self())
+	req, _ := http.NewRequest(http.MethodPut, tsA.URL+ApiRoute+"/meta/"+key, nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	assert.Eventually(t, func() bool {
+		return len(a.peers.Alive()) == 1 && a.peers.Owner([]byte(key)) == a.peers.Self()
+	}, time.Second, 10*time.Millisecond)
+
+	// an old heartbeat doesn't bring b back
+	a.peers.Merge([]PeerMember{{Address: b.peers.Self(), Heartbeat: 1}})
+	assert.Equal(t, 1, len(a.peers.Alive()))
+
+	w := httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, PeersRoute, nil)
+	req.Header.Set(PeerTokenHeader, "wrong")
+	r := gin.New()
+	r.POST(PeersRoute, a.Gossip)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// a forwarded request needs the token, it isn't served as is
+	req, _ = http.NewRequest(http.MethodGet, tsA.URL+ApiRoute+"/meta/"+key, nil)
+	req.Header.Set(ForwardedByHeader, b.peers.Self())
+	req.Header.Set(PeerTokenHeader, "wrong")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
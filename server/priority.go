@@ -0,0 +1,24 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/store"
+)
+
+// priority runs the store calls of the request in the class of the first
+// priority rule matching its route and token, interactive without one.
+func (s *Server) priority(c *gin.Context) {
+	if !s.conf.Priority.Enable {
+		c.Next()
+		return
+	}
+	route, token := c.FullPath(), middleware.AccessToken(c)
+	for _, r := range s.conf.Priority.Rules {
+		if (r.Route == "" || r.Route == route) && (r.Token == "" || r.Token == token) {
+			c.Request = c.Request.WithContext(store.WithPriority(c.Request.Context(), r.Class))
+			break
+		}
+	}
+	c.Next()
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	DefaultSplitRegions = 16
+	MaxSplitRegions     = 4096
+)
+
+// PreSplitKeys splits at both ends of the range as well, so the imported
+// range doesn't share regions with the keys around it.
+func PreSplitKeys(start, end []byte, n int) [][]byte {
+	keys := [][]byte{start}
+	keys = append(keys, store.SplitKeys(start, end, n)...)
+	return append(keys, end)
+}
+
+// preSplitRequest is the range to split, regions between 1 and
+// MaxSplitRegions, DefaultSplitRegions if missing.
+type preSplitRequest struct {
+	Start   string `query:"start"`
+	End     string `query:"end" binding:"required"`
+	Raw     bool   `query:"raw"`
+	Regions int    `query:"regions" default:"16" binding:"min=1,max=4096"`
+	Scatter bool   `query:"scatter" default:"true"`
+	Wait    bool   `query:"wait"`
+}
+
+func (s *Server) PreSplit(c *gin.Context) {
+	req := &preSplitRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	start, err := EncodeMetaKey(req.Start, req.Raw)
+	if err != nil {
+		s.writeError(c, xerror.ErrKeyInvalid.Wrap(err), gin.H{"start": req.Start})
+		return
+	}
+	end, err := EncodeMetaKey(req.End, req.Raw)
+	if err != nil {
+		s.writeError(c, xerror.ErrKeyInvalid.Wrap(err), gin.H{"end": req.End})
+		return
+	}
+	if bytes.Compare(start, end) >= 0 {
+		s.writeError(c, xerror.ErrInvalidArgument, gin.H{"start": req.Start, "end": req.End})
+		return
+	}
+
+	keys := PreSplitKeys(start, end, req.Regions)
+	ids, err := s.store.PreSplit(c.Request.Context(), keys, req.Scatter, req.Wait)
+	if err != nil {
+		s.logger(c).Errorf("pre-split (%s-%s) failed, %s", req.Start, req.End, err)
+		s.writeError(c, err, gin.H{"regions": ids})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": len(keys), "regions": ids})
+}
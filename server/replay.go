@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+func (s *Server) ReplayCheckpoint(c *gin.Context) {
+	r, err := s.store.Replayer()
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"checkpoint": r.Checkpoint()})
+}
+
+// rewindRequest is a position of the replay, by file and pos or by time.
+type rewindRequest struct {
+	Since string `query:"since"`
+	File  *int64 `query:"file" binding:"min=0"`
+	Pos   int64  `query:"pos" binding:"min=0"`
+}
+
+// Rewind sends the changes from file and pos, or since a RFC 3339 time, up
+// to the checkpoint to the mq again.
+func (s *Server) Rewind(c *gin.Context) {
+	r, err := s.store.Replayer()
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	req := &rewindRequest{}
+	if !s.bind(c, req) {
+		return
+	}
+	var from store.ReplayPosition
+	if v := req.Since; v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			s.writeError(c, xerror.ErrInvalidArgument.Wrap(err), gin.H{"since": v})
+			return
+		}
+		from, err = r.PositionAt(since)
+		if err != nil {
+			s.writeError(c, err, gin.H{"since": v})
+			return
+		}
+	} else if req.File != nil {
+		from.FileNum, from.Pos = *req.File, req.Pos
+	} else {
+		s.writeError(c, xerror.ErrInvalidArgument, &model.FieldError{In: model.InQuery, Field: "file", Reason: "required without since"})
+		return
+	}
+	to, err := r.Rewind(from)
+	if err != nil {
+		s.logger(c).Errorf("rewind to %v failed, %s", from, err)
+		s.writeError(c, err, gin.H{"from": from, "checkpoint": to})
+		return
+	}
+	s.logger(c).Warnf("rewind (%v-%v)", from, to)
+	c.JSON(http.StatusAccepted, gin.H{"from": from, "to": to})
+}
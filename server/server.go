@@ -3,7 +3,6 @@ package server
 import (
 	"context"
 	"fmt"
-	"github.com/DeanThompson/ginpprof"
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -11,30 +10,56 @@ import (
 	"github.com/huangnauh/tirest/config"
 	"github.com/huangnauh/tirest/middleware"
 	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/validator"
 	"github.com/huangnauh/tirest/version"
-	"golang.org/x/net/trace"
 	"net/http"
 	"path"
 	"time"
 )
 
 type Server struct {
-	server *http.Server
-	router *gin.Engine
-	conf   *config.Config
-	store  *store.Store
-	log    *logrus.Entry
-	closed bool
+	server      *http.Server
+	adminServer *http.Server
+	router      *gin.Engine
+	adminRouter *gin.Engine
+	conf        *config.Config
+	store       *store.Store
+	jobs        *Jobs
+	validators  *validator.Validators
+	acl         *middleware.ACL
+	firewall    *middleware.Firewall
+	auth        []middleware.Authenticator
+	log         *logrus.Entry
+	closed      bool
+	mode        int32
+	logLevel    *logLevel
+	auditor     *Auditor
+	confirms    *Confirmations
+	peers       *Peers
+	dynamic     *Dynamic
+	clusters    map[string]*Server
+	openapi     []byte
 }
 
-func NewServer(conf *config.Config) (*Server, error) {
-	mode := conf.HttpServerMode()
-	gin.SetMode(mode)
+func newRouter(conf *config.Config) *gin.Engine {
 	router := gin.New()
+	router.Use(middleware.SetRequestId())
 	router.Use(middleware.SetAccessLog(conf.Log.AbnormalAccessLog, conf.Log.SlowRequest.Duration))
-	if mode != gin.DebugMode {
+	if conf.HttpServerMode() != gin.DebugMode {
 		router.Use(gin.Recovery())
 	}
+	return router
+}
+
+func NewServer(conf *config.Config) (*Server, error) {
+	return newServer(conf, newRouter)
+}
+
+// newServer is a proxy of conf with the routers of route.
+func newServer(conf *config.Config, route func(*config.Config) *gin.Engine) (*Server, error) {
+	mode := conf.HttpServerMode()
+	gin.SetMode(mode)
+	router := route(conf)
 
 	server := &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", conf.Server.HttpHost, conf.Server.HttpPort),
@@ -43,6 +68,8 @@ func NewServer(conf *config.Config) (*Server, error) {
 		ReadHeaderTimeout: conf.Server.ReadHeaderTimeout.Duration,
 		WriteTimeout:      conf.Server.WriteTimeout.Duration,
 		IdleTimeout:       conf.Server.IdleTimeout.Duration,
+		MaxHeaderBytes:    conf.Server.MaxHeaderBytes,
+		ConnState:         newConnTracker("api").track,
 	}
 
 	s, err := store.NewStore(conf)
@@ -50,14 +77,98 @@ func NewServer(conf *config.Config) (*Server, error) {
 		return nil, err
 	}
 
+	validators, err := validator.New(conf.Validations)
+	if err != nil {
+		return nil, err
+	}
+
+	serverMode, err := ParseMode(conf.Server.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	auditor, err := NewAuditor(&conf.Audit, s)
+	if err != nil {
+		return nil, err
+	}
+
+	var acl *middleware.ACL
+	if conf.ACL.Enable {
+		acl, err = middleware.NewACL(&conf.ACL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var firewall *middleware.Firewall
+	if conf.Firewall.Enable {
+		firewall, err = middleware.NewFirewall(&conf.Firewall)
+		if err != nil {
+			return nil, err
+		}
+		// the puts of a batch are refused one by one, a v2 list only reads
+		firewall.ReadRoute(http.MethodPost, batchRoute)
+		firewall.ReadRoute(http.MethodPost, batchV2Route)
+		firewall.ReadRoute(http.MethodPost, listV2Route)
+	}
+
+	var auth []middleware.Authenticator
+	if len(conf.Auth.OIDC) > 0 {
+		auth = append(auth, middleware.NewOIDC(&conf.Auth))
+	}
+	if conf.Auth.LDAP.Enable {
+		auth = append(auth, middleware.NewLDAP(&conf.Auth.LDAP))
+	}
+	if conf.Auth.HMAC.Enable {
+		auth = append(auth, middleware.NewHMAC(&conf.Auth.HMAC))
+	}
+
+	var peers *Peers
+	if conf.Peers.Enable {
+		peers = NewPeers(&conf.Peers)
+	}
+
 	ser := &Server{
-		server: server,
-		router: router,
-		conf:   conf,
-		store:  s,
-		log:    logrus.WithFields(logrus.Fields{"worker": "server"}),
+		server:      server,
+		router:      router,
+		adminRouter: router,
+		conf:        conf,
+		store:       s,
+		jobs:        NewJobs(),
+		validators:  validators,
+		acl:         acl,
+		firewall:    firewall,
+		auth:        auth,
+		logLevel:    newLogLevel(),
+		auditor:     auditor,
+		confirms:    NewConfirmations(),
+		peers:       peers,
+		log:         logrus.WithFields(logrus.Fields{"worker": "server"}),
+	}
+
+	if conf.Admin.HttpPort != 0 {
+		ser.adminRouter = route(conf)
+		ser.adminServer = &http.Server{
+			Addr:              fmt.Sprintf("%s:%d", conf.Admin.HttpHost, conf.Admin.HttpPort),
+			Handler:           ser.adminRouter,
+			ReadTimeout:       conf.Server.ReadTimeout.Duration,
+			ReadHeaderTimeout: conf.Server.ReadHeaderTimeout.Duration,
+			WriteTimeout:      conf.Admin.WriteTimeout.Duration,
+			IdleTimeout:       conf.Server.IdleTimeout.Duration,
+			MaxHeaderBytes:    conf.Server.MaxHeaderBytes,
+			ConnState:         newConnTracker("admin").track,
+		}
+	}
+
+	if conf.Dynamic.Enable {
+		ser.dynamic = NewDynamic(ser, &conf.Dynamic)
+	}
+	ser.jobs.record = ser.recordJob
+	if ser.clusters, err = newClusters(conf, auditor); err != nil {
+		return nil, err
 	}
 
+	ser.SetMode(serverMode)
 	err = ser.registerRoutes()
 	if err != nil {
 		ser.log.Errorf("register routes err, %s", err)
@@ -67,13 +178,14 @@ func NewServer(conf *config.Config) (*Server, error) {
 }
 
 func HandleNoRoute(c *gin.Context) {
-	c.Status(http.StatusNotImplemented)
-	return
+	middleware.AbortWithError(c, http.StatusNotImplemented,
+		middleware.StatusCode(http.StatusNotImplemented), "no route", nil)
 }
 
 var (
 	ApiRoute    = path.Join("/api", version.API)
 	UnsafeRoute = "unsafe"
+	AdminRoute  = "/admin"
 )
 
 func prometheusHandler() http.Handler {
@@ -89,35 +201,121 @@ func (s *Server) registerRoutes() error {
 	//		ginSwagger.WrapHandler(swaggerFiles.Handler, url))
 	//}
 	if s.conf.EnableTracing {
-		trace.AuthRequest = func(req *http.Request) (any, sensitive bool) {
-			return true, true
-		}
-
-		s.router.GET("/debug/requests", gin.WrapF(trace.Traces))
-		s.router.GET("/debug/events", gin.WrapF(trace.Events))
-
-		ginpprof.Wrap(s.router)
 		s.router.Use(middleware.SetTrace())
 	}
-	s.router.GET("/metrics", gin.WrapH(prometheusHandler()))
+	s.adminRouter.GET("/metrics", gin.WrapH(prometheusHandler()))
+
+	if s.conf.Cors.Enable {
+		s.router.Use(middleware.SetCors(middleware.CorsOption{
+			AllowOrigins:     s.conf.Cors.AllowOrigins,
+			AllowMethods:     s.conf.Cors.AllowMethods,
+			AllowHeaders:     s.conf.Cors.AllowHeaders,
+			ExposeHeaders:    s.conf.Cors.ExposeHeaders,
+			AllowCredentials: s.conf.Cors.AllowCredentials,
+			MaxAge:           s.conf.Cors.MaxAge.Duration,
+		}))
+	}
 
+	s.router.Use(middleware.StructuredErrors(ApiV2Route + "/"))
 	s.router.NoRoute(HandleNoRoute)
-	api := s.router.Group(ApiRoute)
-	api.GET("/meta/:key", s.Get)
-	api.PUT("/meta/:key", s.CheckAndPut)
-	api.POST("/meta/:key", s.CheckAndPut)
-	api.DELETE("/list/", s.AsyncBatchDelete)
-	api.DELETE("/list", s.AsyncBatchDelete)
-	api.GET("/list/", s.List)
-	api.GET("/list", s.List)
+	if s.firewall != nil {
+		s.router.Use(s.firewall.Check)
+	}
+	// the peers have a token of their own
+	if s.peers != nil {
+		s.router.POST(PeersRoute, s.Gossip)
+	}
+	// a cluster authenticates its requests itself
+	s.registerClusterRoutes()
+	if len(s.auth) > 0 {
+		s.router.Use(middleware.Authenticate(s.auth...))
+	}
+	api := s.router.Group(ApiRoute, s.apiVersion(version.API), s.checkMode, s.deadline, s.priority)
+	readMeta, writeMeta := s.metaACL(middleware.PermRead), s.metaACL(middleware.PermWrite)
+	readList, writeList := s.listACL(middleware.PermRead), s.listACL(middleware.PermWrite)
+	api.GET("/meta/:key", s.forward, readMeta, s.Get)
+	api.PUT("/meta/:key", s.forward, writeMeta, s.Idempotent, s.CheckAndPut)
+	api.POST("/meta/:key", s.forward, writeMeta, s.Idempotent, s.CheckAndPut)
+	api.DELETE("/list/", s.audited, writeList, s.Idempotent, s.AsyncBatchDelete)
+	api.DELETE("/list", s.audited, writeList, s.Idempotent, s.AsyncBatchDelete)
+	api.GET("/list/", readList, s.List)
+	api.GET("/list", readList, s.List)
 	api.GET("/config", s.GetConfig)
 	api.GET("/health", s.Health)
+	api.POST("/batch", s.Idempotent, s.Batch)
+	api.GET("/watch", s.Watch)
 
 	unsafe := api.Group(UnsafeRoute)
-	unsafe.DELETE("/meta/:key", s.UnsafeDelete)
-	unsafe.PUT("/meta/:key", s.UnsafePut)
-	unsafe.POST("/meta/:key", s.UnsafePut)
-	return nil
+	unsafe.DELETE("/meta/:key", s.forward, s.audited, writeMeta, s.Idempotent, s.UnsafeDelete)
+	unsafe.PUT("/meta/:key", s.forward, writeMeta, s.Idempotent, s.UnsafePut)
+	unsafe.POST("/meta/:key", s.forward, writeMeta, s.Idempotent, s.UnsafePut)
+
+	v2 := s.router.Group(ApiV2Route, s.apiVersion(version.APIV2), s.checkMode, s.deadline, s.priority)
+	v2.GET("/meta/:key", s.forward, readMeta, s.Get)
+	v2.PUT("/meta/:key", s.forward, writeMeta, s.Idempotent, s.CheckAndPut)
+	v2.POST("/list", s.ListV2)
+	v2.DELETE("/list", s.audited, s.Idempotent, s.DeleteRangeV2)
+	v2.GET("/health", s.Health)
+	v2.POST("/batch", s.Idempotent, s.Batch)
+	v2.GET("/watch", s.Watch)
+
+	unsafeV2 := v2.Group(UnsafeRoute)
+	unsafeV2.DELETE("/meta/:key", s.forward, s.audited, writeMeta, s.Idempotent, s.UnsafeDelete)
+	unsafeV2.PUT("/meta/:key", s.forward, writeMeta, s.Idempotent, s.UnsafePut)
+
+	// without a token, the admin routes are only served on their own listener
+	adminAuth := middleware.AdminAuth(s.conf.Admin.Tokens)
+	if len(s.conf.Admin.Tokens) == 0 && s.adminServer == nil {
+		s.log.Warnf("no admin token and no admin listener, admin routes are rejected")
+		adminAuth = rejectAdmin
+	} else if len(s.conf.Admin.Tokens) == 0 {
+		s.log.Warnf("no admin token, admin routes are not protected on the admin listener")
+	}
+	adminApi := s.adminRouter.Group(ApiRoute)
+	if s.adminServer != nil {
+		s.adminRouter.NoRoute(HandleNoRoute)
+		adminApi.GET("/health", s.Health)
+	}
+	if s.conf.Admin.Dashboard {
+		s.adminRouter.GET(DashboardRoute, s.Dashboard)
+	}
+	s.router.GET(OpenAPIRoute, s.OpenAPI)
+	s.router.GET(VersionRoute, s.Version)
+	if s.adminServer != nil {
+		s.adminRouter.GET(OpenAPIRoute, s.OpenAPI)
+		s.adminRouter.GET(VersionRoute, s.Version)
+	}
+	if s.conf.Admin.SwaggerUI != "" {
+		s.adminRouter.GET(SwaggerUIRoute, s.SwaggerUI)
+	}
+
+	admin := s.adminRouter.Group(AdminRoute, adminAuth, s.auditAdmin)
+	admin.GET("/config", s.GetConfig)
+	admin.GET("/hotkeys", s.HotKeys)
+	admin.GET("/hot-regions", s.HotRegions)
+	admin.GET("/jobs", s.ListJobs)
+	admin.GET("/catalog", s.Catalog)
+	admin.GET("/clusters", s.ListClusters)
+	admin.GET("/acl", s.GetACL)
+	admin.PUT("/acl", s.SetACL)
+	admin.GET("/mode", s.GetMode)
+	admin.PUT("/mode", s.PutMode)
+	admin.POST("/pre-split", s.PreSplit)
+	admin.GET("/cluster", s.Cluster)
+	admin.GET("/diag/key/:key", s.DiagnoseKey)
+	admin.GET("/peers", s.ListPeers)
+	admin.GET("/loglevel", s.GetLogLevel)
+	admin.PUT("/loglevel", s.PutLogLevel)
+	admin.POST("/unsafe-delete/token", s.UnsafeDeleteToken)
+	admin.GET("/connector/checkpoint", s.ReplayCheckpoint)
+	admin.POST("/connector/rewind", s.Rewind)
+	admin.GET("/conflicts", s.Conflicts)
+	admin.GET("/keys", s.Keys)
+	s.registerDebugRoutes(admin)
+
+	var err error
+	s.openapi, err = openAPI(s.router.Routes())
+	return err
 }
 
 func (s *Server) Start() {
@@ -125,11 +323,40 @@ func (s *Server) Start() {
 		s.store.Open()
 	}()
 
+	for _, cluster := range s.clusters {
+		cluster.store.Open()
+	}
+	if s.peers != nil {
+		s.peers.Start()
+	}
+	if s.dynamic != nil {
+		s.dynamic.Start()
+	}
+
+	if s.adminServer != nil {
+		go func() {
+			s.log.Infof("Serving admin HTTP on %s port %d", s.conf.Admin.HttpHost, s.conf.Admin.HttpPort)
+			err := s.serve(s.adminServer)
+			if err != nil && err != http.ErrServerClosed {
+				s.log.Errorf("admin server failed, %s", err)
+			}
+		}()
+	}
+
 	s.log.Infof("Serving HTTP on %s port %d", s.conf.Server.HttpHost, s.conf.Server.HttpPort)
-	err := s.server.ListenAndServe()
+	err := s.serve(s.server)
+	if err != nil && err != http.ErrServerClosed {
+		s.log.Errorf("server failed, %s", err)
+	}
+}
+
+// serve is ListenAndServe on the listener of listen.
+func (s *Server) serve(srv *http.Server) error {
+	ln, err := listen(srv.Addr, &s.conf.Server)
 	if err != nil {
-		return
+		return err
 	}
+	return srv.Serve(ln)
 }
 
 func (s *Server) Close() {
@@ -137,6 +364,12 @@ func (s *Server) Close() {
 		return
 	}
 	s.closed = true
+	if s.peers != nil {
+		s.peers.Close()
+	}
+	if s.dynamic != nil {
+		s.dynamic.Close()
+	}
 	// waiting health check done
 	time.Sleep(s.conf.Server.SleepBeforeClose.Duration)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
@@ -146,10 +379,24 @@ func (s *Server) Close() {
 	if err != nil {
 		logrus.Errorf("shutdown failed %s", err)
 	}
+	if s.adminServer != nil {
+		err = s.adminServer.Shutdown(ctx)
+		if err != nil {
+			logrus.Errorf("shutdown admin failed %s", err)
+		}
+	}
 	middleware.CloseAccessLog()
 	s.log.Infof("shutdown store")
 	err = s.store.Close()
 	if err != nil {
 		logrus.Errorf("store close failed %s", err)
 	}
+	err = s.auditor.Close()
+	if err != nil {
+		logrus.Errorf("audit close failed %s", err)
+	}
+	for id, cluster := range s.clusters {
+		s.log.Infof("shutdown cluster %s", id)
+		cluster.closeStore()
+	}
 }
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	TsHeader  = "X-Ts"
+	SeqHeader = "X-Seq"
+)
+
+// conditional is true if the write has X-Ts or X-If-Unmodified-Since-Ts.
+func conditional(l *model.Meta) bool {
+	return l.Ts != 0 || l.UnmodifiedSince != 0
+}
+
+// stampOption sets the stamp and the conditions of a write. A write with
+// X-Ts comes from another cluster, it keeps the stamp and the last write
+// wins. It writes the error response and returns false on failure.
+func (s *Server) stampOption(c *gin.Context, l *model.Meta, opts *store.CheckOption) bool {
+	if !s.conf.Server.HLC {
+		if conditional(l) {
+			s.writeError(c, xerror.ErrNotSupported, gin.H{"reason": "server.hlc is off"})
+			return false
+		}
+		return true
+	}
+	clock := s.store.Clock()
+	if l.Ts != 0 {
+		if err := clock.Update(l.Ts); err != nil {
+			s.logger(c).Errorf("update clock to %d, err %s", l.Ts, err)
+			s.writeError(c, xerror.ErrClockOffset.Wrap(err), gin.H{"ts": l.Ts})
+			return false
+		}
+		opts.Ts = l.Ts
+		opts.LWW = true
+	} else {
+		opts.Ts = clock.Now()
+	}
+	opts.UnmodifiedSince = l.UnmodifiedSince
+	return true
+}
+
+func setTsHeader(c *gin.Context, ts uint64) {
+	if ts != 0 {
+		c.Header(TsHeader, strconv.FormatUint(ts, 10))
+	}
+}
+
+func setSeqHeader(c *gin.Context, seq uint64) {
+	if seq != 0 {
+		c.Header(SeqHeader, strconv.FormatUint(seq, 10))
+	}
+}
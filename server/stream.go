@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+// streamBuffer is the start of a list held before the response is sent. A
+// list done by then is written as writeData does, with its length, and its
+// error if it fails. A longer one is sent while it's read, an error after
+// that ends the response without the closing bracket of the json.
+const streamBuffer = 32 << 10
+
+// streamWriter buffers the start of a response and then writes through,
+// compressed when the client accepts it.
+type streamWriter struct {
+	s           *Server
+	c           *gin.Context
+	contentType string
+	buf         *bytes.Buffer
+	// w is set once the response is sent
+	w       io.Writer
+	zw      io.WriteCloser
+	release func()
+}
+
+func (s *Server) newStreamWriter(c *gin.Context, contentType string) *streamWriter {
+	return &streamWriter{s: s, c: c, contentType: contentType, buf: utils.GetBuf()}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.w != nil {
+		return w.w.Write(p)
+	}
+	w.buf.Write(p)
+	if w.buf.Len() < streamBuffer {
+		return len(p), nil
+	}
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// start sends the headers and the buffer.
+func (w *streamWriter) start() error {
+	c := w.c
+	w.w = c.Writer
+	conf := w.s.conf.Server
+	if conf.EnableCompress {
+		c.Header("Vary", "Accept-Encoding")
+		if encoding := acceptEncoding(c.GetHeader("Accept-Encoding")); encoding != "" {
+			zw, release, err := newCompressor(c.Writer, encoding, conf.CompressLevel)
+			if err == nil {
+				c.Header("Content-Encoding", encoding)
+				w.w, w.zw, w.release = zw, zw, release
+			} else {
+				w.s.log.Errorf("compress %s failed, %s", encoding, err)
+			}
+		}
+	}
+	if v, ok := contentTypes[w.contentType]; ok {
+		c.Writer.Header()["Content-Type"] = v
+	} else {
+		c.Header("Content-Type", w.contentType)
+	}
+	c.Status(http.StatusOK)
+	_, err := w.w.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// Started is true once the status is sent, an error can't be written.
+func (w *streamWriter) Started() bool {
+	return w.w != nil
+}
+
+// Close writes a response still buffered, or ends the compressed one.
+func (w *streamWriter) Close() error {
+	if w.w == nil {
+		w.s.writeData(w.c, http.StatusOK, w.contentType, w.buf.Bytes())
+		return nil
+	}
+	if w.zw != nil {
+		return w.zw.Close()
+	}
+	return nil
+}
+
+// Release puts the buffers back, after Close or a failure.
+func (w *streamWriter) Release() {
+	utils.PutBuf(w.buf)
+	if w.release != nil {
+		w.release()
+	}
+}
+
+// itemWriter writes the items of a list as a json array, one by one.
+type itemWriter struct {
+	w       io.Writer
+	scratch *bytes.Buffer
+	enc     *json.Encoder
+	n       int
+	// item is encoded by pointer, the encoder doesn't allocate an
+	// interface for each item
+	item store.KeyValue
+}
+
+func newItemWriter(w io.Writer) *itemWriter {
+	scratch := utils.GetBuf()
+	return &itemWriter{w: w, scratch: scratch, enc: json.NewEncoder(scratch)}
+}
+
+// Write writes the item after the ones before, Encode ends it with a
+// newline which is left out as Marshal does.
+func (iw *itemWriter) Write(item store.KeyValue) error {
+	iw.scratch.Reset()
+	if iw.n == 0 {
+		iw.scratch.WriteByte('[')
+	} else {
+		iw.scratch.WriteByte(',')
+	}
+	iw.item = item
+	if err := iw.enc.Encode(&iw.item); err != nil {
+		return err
+	}
+	iw.n++
+	b := iw.scratch.Bytes()
+	_, err := iw.w.Write(b[:len(b)-1])
+	return err
+}
+
+// Close ends the array, an empty one included.
+func (iw *itemWriter) Close() error {
+	if iw.n == 0 {
+		_, err := iw.w.Write([]byte("[]"))
+		return err
+	}
+	_, err := iw.w.Write([]byte("]"))
+	return err
+}
+
+func (iw *itemWriter) Release() {
+	utils.PutBuf(iw.scratch)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListStream(t *testing.T) {
+	router, db := newBenchRouter(t)
+	// 10 items are written at once, 100 are streamed past streamBuffer
+	for _, limit := range []int{10, 100} {
+		r := httptest.NewRequest(http.MethodGet, ApiRoute+"/list", nil)
+		r.Header.Set("X-Start", "YQ")
+		r.Header.Set("X-End", "eg")
+		r.Header.Set("X-Limit", strconv.Itoa(limit))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		expected, err := json.Marshal(db.items[:limit])
+		assert.Nil(t, err)
+		assert.Equal(t, string(expected), w.Body.String())
+		assert.Equal(t, limit == 10, w.Header().Get("Content-Length") != "")
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, listV2Route,
+		strings.NewReader(`{"start":"a","end":"z","limit":2,"raw":true}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := ListResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, db.items[:2], resp.Items)
+	assert.Equal(t, encodeBase64([]byte(db.items[1].Key)), resp.Cursor)
+
+	// nothing is before the first key, the page is empty without a cursor
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, listV2Route,
+		strings.NewReader(`{"start":"a","end":"z","raw":true,"reverse":true,"cursor":"`+encodeBase64([]byte("a"))+`"}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"items":[]}`, w.Body.String())
+}
+
+func TestListBytes(t *testing.T) {
+	router, db := newBenchRouter(t)
+	list := func(maxBytes int) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, ApiRoute+"/list", nil)
+		r.Header.Set("X-Start", "YQ")
+		r.Header.Set("X-End", "eg")
+		r.Header.Set("X-Max-Bytes", strconv.Itoa(maxBytes))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Result()
+	}
+	// an item is 522 bytes, the list stops after the one reaching the bytes
+	size := len(db.items[0].Key) + len(db.items[0].Value)
+	resp := list(10 * size)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	items := []store.KeyValue{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&items))
+	assert.Equal(t, db.items[:10], items)
+	assert.Equal(t, encodeBase64([]byte(db.items[9].Key)), resp.Header.Get(CursorHeader))
+
+	// a streamed list has the cursor in a trailer
+	resp = list(40000)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&items))
+	assert.Equal(t, 77, len(items))
+	assert.Equal(t, "", resp.Header.Get(CursorHeader))
+	assert.Equal(t, encodeBase64([]byte(db.items[76].Key)), resp.Trailer.Get(CursorHeader))
+
+	// the whole range is under the bytes
+	resp = list(100 * size * 2)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&items))
+	assert.Equal(t, 100, len(items))
+	assert.Equal(t, "", resp.Header.Get(CursorHeader))
+	assert.Equal(t, "", resp.Trailer.Get(CursorHeader))
+	assert.Equal(t, http.StatusBadRequest, list(64*1024*1024+1).StatusCode)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, listV2Route,
+		strings.NewReader(`{"start":"a","end":"z","raw":true,"max-bytes":`+strconv.Itoa(2*size)+`}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	page := ListResponse{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, db.items[:2], page.Items)
+	assert.Equal(t, encodeBase64([]byte(db.items[1].Key)), page.Cursor)
+}
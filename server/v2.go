@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/version"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// ApiV2Route serves the same store as ApiRoute, the lists take a json body
+// and return a cursor, the errors are ErrorResponseV2.
+var ApiV2Route = path.Join("/api", version.APIV2)
+
+var listV2Route = path.Join(ApiV2Route, "/list")
+
+// maxListRequestSize bounds the body of a v2 list, the keys are at most
+// server.max-key-length and base64 makes them a third larger.
+const maxListRequestSize = 64 << 10
+
+// ListResponse is a page of a v2 list, Cursor is set when the page is full,
+// of items or of bytes, and gets the next one.
+type ListResponse struct {
+	Items  []store.KeyValue `json:"items"`
+	Cursor string           `json:"cursor,omitempty"`
+}
+
+// listRangeKey keeps the range of a v2 request for the audit log.
+const listRangeKey = "list-range"
+
+func (s *Server) bindListRequest(c *gin.Context) (*model.ListRequest, bool) {
+	body, ok := s.readBody(c, maxListRequestSize)
+	if !ok {
+		return nil, false
+	}
+	r := &model.ListRequest{}
+	if err := model.BindJSON(body, r); err != nil {
+		s.writeBindError(c, err)
+		return nil, false
+	}
+	c.Set(listRangeKey, r.List())
+	return r, true
+}
+
+// afterCursor narrows the range to the keys after the cursor, in the order
+// of the list. The range is empty when start >= end.
+func afterCursor(cursor string, reverse bool, start, end []byte) ([]byte, []byte, error) {
+	if cursor == "" {
+		return start, end, nil
+	}
+	last, err := decodeBase64(cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := append([]byte{MetaType}, last...)
+	if bytes.Compare(key, start) < 0 || bytes.Compare(key, end) >= 0 {
+		return nil, nil, xerror.ErrListKVInvalid
+	}
+	if reverse {
+		return start, key, nil
+	}
+	return append(key, 0x00), end, nil
+}
+
+// ListV2 lists a page of the range of the json body, the cursor of a page
+// and the same range get the next page.
+func (s *Server) ListV2(c *gin.Context) {
+	r, ok := s.bindListRequest(c)
+	if !ok {
+		return
+	}
+	l := r.List()
+	if err := s.checkList(l, false); err != nil {
+		s.logger(c).Errorf("list invalid, err %s", err)
+		s.writeError(c, err, nil)
+		return
+	}
+	start, end, err := s.getRangeFromList(l)
+	if err != nil {
+		s.logger(c).Errorf("list invalid, err %s", err)
+		s.writeError(c, err, nil)
+		return
+	}
+	if s.acl != nil && !s.allow(c, middleware.PermRead, start[1:], end[1:]) {
+		s.logger(c).Warnf("access denied list %s-%s from %s", start, end, c.Request.RemoteAddr)
+		s.writeError(c, xerror.ErrAccessDenied, nil)
+		return
+	}
+	start, end, err = afterCursor(l.Cursor, l.Reverse, start, end)
+	if err != nil {
+		s.writeError(c, xerror.ErrInvalidArgument.Wrap(l.Invalid("cursor", l.Cursor, err.Error())), nil)
+		return
+	}
+
+	// the page is streamed as ListResponse, the cursor after the items
+	w := s.newStreamWriter(c, "application/json")
+	defer w.Release()
+	items := newItemWriter(w)
+	defer items.Release()
+	var last []byte
+	var over bool
+	if _, err = w.Write([]byte(`{"items":`)); err == nil && bytes.Compare(start, end) < 0 {
+		over, err = s.scanRange(c, l, start, end, func(item store.KeyValue) error {
+			last = append(last[:0], item.Key...)
+			return items.Write(item)
+		})
+	}
+	if err == nil {
+		err = items.Close()
+	}
+	if err == nil && items.n > 0 && (over || items.n == l.Limit) {
+		_, err = w.Write([]byte(`,"cursor":"` + encodeBase64(last) + `"`))
+	}
+	if err == nil {
+		_, err = w.Write([]byte("}"))
+	}
+	s.endStream(c, w, err)
+}
+
+// DeleteRangeV2 deletes the range of the json body in a job, as
+// AsyncBatchDelete.
+func (s *Server) DeleteRangeV2(c *gin.Context) {
+	r, ok := s.bindListRequest(c)
+	if !ok {
+		return
+	}
+	l := r.List()
+	start, end, err := s.getRangeFromList(l)
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	if s.acl != nil && !s.allow(c, middleware.PermWrite, start[1:], end[1:]) {
+		s.logger(c).Warnf("access denied delete %s-%s from %s", start, end, c.Request.RemoteAddr)
+		s.writeError(c, xerror.ErrAccessDenied, nil)
+		return
+	}
+	s.deleteRange(c, l)
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAfterCursor(t *testing.T) {
+	start, end := []byte{MetaType, 'a'}, []byte{MetaType, 'z'}
+
+	s, e, err := afterCursor("", false, start, end)
+	assert.Nil(t, err)
+	assert.Equal(t, start, s)
+	assert.Equal(t, end, e)
+
+	cursor := encodeBase64([]byte("m"))
+	s, e, err = afterCursor(cursor, false, start, end)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{MetaType, 'm', 0x00}, s)
+	assert.Equal(t, end, e)
+
+	s, e, err = afterCursor(cursor, true, start, end)
+	assert.Nil(t, err)
+	assert.Equal(t, start, s)
+	assert.Equal(t, []byte{MetaType, 'm'}, e)
+
+	// a cursor out of the range is of another list
+	_, _, err = afterCursor(encodeBase64([]byte("z")), false, start, end)
+	assert.NotNil(t, err)
+	_, _, err = afterCursor("!", false, start, end)
+	assert.NotNil(t, err)
+}
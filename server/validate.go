@@ -0,0 +1,43 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/validator"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// validate writes 422 when value is rejected by a validator of the key,
+// key is the meta key with its type byte.
+func (s *Server) validate(c *gin.Context, key, value []byte) bool {
+	if len(value) == 0 || !s.validators.Match(key[1:]) {
+		return true
+	}
+	name, err := s.validators.Validate(key[1:], value)
+	if err == nil {
+		return true
+	}
+	s.logger(c).Warnf("key %s rejected by validator %s, %s", key, name, err)
+	errs, ok := err.(validator.Errors)
+	if !ok {
+		errs = validator.Errors{{Field: "$", Message: err.Error()}}
+	}
+	s.writeError(c, xerror.ErrValidationFailed.Wrap(err), gin.H{"validator": name, "errors": errs})
+	return false
+}
+
+// validateLog checks the new value of a cas body.
+func (s *Server) validateLog(c *gin.Context, key, entry []byte) bool {
+	if !s.validators.Match(key[1:]) {
+		return true
+	}
+	l := &store.Log{}
+	err := json.Unmarshal(entry, l)
+	if err != nil {
+		s.writeError(c, xerror.ErrCheckAndSetInvalid.Wrap(err), nil)
+		return false
+	}
+	return s.validate(c, key, utils.S2B(l.New))
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/huangnauh/tirest/middleware"
+	"github.com/huangnauh/tirest/model"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// WatchEvent is the data of a change event, the key is encoded as the
+// prefix, in base64 unless ?raw=true.
+type WatchEvent struct {
+	Key string `json:"key"`
+	Op  string `json:"op"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// LastEventIDHeader resumes a watch after the change with the ID.
+const LastEventIDHeader = "Last-Event-ID"
+
+// Watch streams the changes under ?prefix= as server-sent events: a
+// change event per write, a ping comment every watch.heartbeat and an
+// overflow event before closing a client which fell behind. The stream
+// ends before server.write-timeout, the clients reconnect with the ID of
+// the last change and get the changes kept since, or a reset event if
+// some aren't kept anymore.
+func (s *Server) Watch(c *gin.Context) {
+	req := &model.Watch{}
+	if !s.bind(c, req) {
+		return
+	}
+	prefix, err := EncodeMetaKey(req.Prefix, req.Raw)
+	if err != nil {
+		s.logger(c).Errorf("check prefix %s, err %s", req.Prefix, err)
+		s.writeError(c, xerror.ErrKeyInvalid, gin.H{"prefix": req.Prefix, "reason": err.Error()})
+		return
+	}
+	if s.acl != nil && !s.allow(c, middleware.PermRead, prefix[1:], store.PrefixEnd(prefix[1:])) {
+		s.logger(c).Warnf("access denied watch %s from %s", prefix, c.Request.RemoteAddr)
+		s.writeError(c, xerror.ErrAccessDenied, nil)
+		return
+	}
+
+	w, err := s.store.Watch(prefix, req.LastEventID)
+	if err != nil {
+		s.writeError(c, err, nil)
+		return
+	}
+	defer s.store.Unwatch(w)
+	s.logger(c).Infof("watch %s from %s", prefix, c.Request.RemoteAddr)
+
+	heartbeat := time.NewTicker(s.conf.Watch.Heartbeat.Duration)
+	defer heartbeat.Stop()
+	var end <-chan time.Time
+	if wt := s.conf.Server.WriteTimeout.Duration; wt > time.Second {
+		timer := time.NewTimer(wt - time.Second)
+		defer timer.Stop()
+		end = timer.C
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	change := func(ev store.WatchEvent) {
+		key := encodeBase64(ev.Key[1:])
+		if req.Raw {
+			key = string(ev.Key[1:])
+		}
+		c.Render(-1, sse.Event{
+			Id:    strconv.FormatUint(ev.ID, 10),
+			Event: "change",
+			Data:  WatchEvent{Key: key, Op: ev.Op, Old: ev.Log.Old, New: ev.Log.New, Seq: ev.Log.Seq},
+		})
+	}
+	if w.Missed {
+		s.logger(c).Warnf("watch %s from %s resumed after %d, not kept", prefix, c.Request.RemoteAddr, req.LastEventID)
+		c.SSEvent("reset", gin.H{"last-event-id": req.LastEventID})
+	}
+	for _, ev := range w.Backlog {
+		change(ev)
+	}
+	c.Stream(func(out io.Writer) bool {
+		select {
+		case ev := <-w.C:
+			change(ev)
+			return true
+		case <-w.Done():
+			s.logger(c).Warnf("watch %s from %s fell behind", prefix, c.Request.RemoteAddr)
+			c.SSEvent("overflow", gin.H{"buffer": s.conf.Watch.Buffer})
+			return false
+		case <-heartbeat.C:
+			_, err := io.WriteString(out, ": ping\n\n")
+			return err == nil
+		case <-end:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
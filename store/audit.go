@@ -0,0 +1,16 @@
+package store
+
+// AuditType prefixes the audit records sent to the connector, they are never
+// stored in TiKV.
+const AuditType byte = 0x02
+
+// SendAudit sends an audit record to the connector if there is one.
+func (s *Store) SendAudit(id string, record []byte) {
+	if s.usable() != nil || s.connector == nil {
+		return
+	}
+	key := make([]byte, 1+len(id))
+	key[0] = AuditType
+	copy(key[1:], id)
+	s.send(KeyEntry{Key: key, Entry: record})
+}
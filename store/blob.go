@@ -0,0 +1,350 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// BlobStore keeps the values larger than blob.threshold, the db only has a
+// pointer record to them.
+type BlobStore interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Open(ctx context.Context, name string) (io.ReadCloser, int64, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// BlobStreamer is a BlobStore which writes a blob from a reader, without
+// holding the whole blob in memory.
+type BlobStreamer interface {
+	PutStream(ctx context.Context, name string, r io.Reader) error
+}
+
+type BlobDriver interface {
+	Name() string
+	Open(conf *config.Config) (BlobStore, error)
+}
+
+var bDrivers = make(map[string]BlobDriver)
+
+func RegisterBlobStore(driver BlobDriver) {
+	name := driver.Name()
+	if _, ok := bDrivers[name]; ok {
+		panic(fmt.Errorf("blob store %s is already registered", name))
+	}
+
+	bDrivers[name] = driver
+}
+
+// blobMagic starts a pointer record, a value can't be told from one if it
+// starts the same.
+var blobMagic = []byte("\x00tirest.blob\x00")
+
+type BlobPointer struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func decodePointer(v []byte) (*BlobPointer, bool) {
+	if !bytes.HasPrefix(v, blobMagic) {
+		return nil, false
+	}
+	p := &BlobPointer{}
+	if json.Unmarshal(v[len(blobMagic):], p) != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+func encodePointer(p *BlobPointer) []byte {
+	b, _ := json.Marshal(p)
+	return append(append([]byte{}, blobMagic...), b...)
+}
+
+func blobName(key []byte) (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key) + "/" + hex.EncodeToString(id[:]), nil
+}
+
+func (s *Store) putBlob(ctx context.Context, key, data []byte) (*BlobPointer, error) {
+	name, err := blobName(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	p := &BlobPointer{
+		Name:   name,
+		Size:   int64(len(data)),
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	if err := s.blobs.Put(ctx, p.Name, data); err != nil {
+		s.log.Errorf("put blob %s of key %s failed, %s", p.Name, key, err)
+		return nil, xerror.ErrSetKVFailed.Wrap(err)
+	}
+	return p, nil
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// streamBlob writes the blob from r, it's read into memory if the blob
+// store can't stream.
+func (s *Store) streamBlob(ctx context.Context, key []byte, r io.Reader) (*BlobPointer, error) {
+	streamer, ok := s.blobs.(BlobStreamer)
+	if !ok {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return s.putBlob(ctx, key, data)
+	}
+	name, err := blobName(key)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	cr := &countReader{r: io.TeeReader(r, h)}
+	if err = streamer.PutStream(ctx, name, cr); err != nil {
+		s.log.Errorf("put blob %s of key %s failed, %s", name, key, err)
+		s.deleteBlob(&BlobPointer{Name: name})
+		return nil, xerror.ErrSetKVFailed.Wrap(err)
+	}
+	return &BlobPointer{Name: name, Size: cr.n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// pointerOf returns the pointer record of key, nil if it has none.
+func (s *Store) pointerOf(ctx context.Context, key []byte) *BlobPointer {
+	v, err := s.db.Get(ctx, key, GetOption{})
+	if err != nil {
+		return nil
+	}
+	p, _ := decodePointer(v.Value)
+	return p
+}
+
+// putPointer puts the pointer record of a blob to key, the blob it replaces
+// is removed.
+func (s *Store) putPointer(ctx context.Context, key []byte, p *BlobPointer) error {
+	old := s.pointerOf(ctx, key)
+	err := contextError(ctx, s.db.Put(ctx, key, encodePointer(p)))
+	s.cacheInvalidate(key)
+	if err != nil {
+		s.deleteBlob(p)
+		return err
+	}
+	s.deleteBlob(old)
+	return nil
+}
+
+func (s *Store) readBlob(ctx context.Context, p *BlobPointer) ([]byte, error) {
+	r, _, err := s.blobs.Open(ctx, p.Name)
+	if err != nil {
+		s.log.Errorf("open blob %s failed, %s", p.Name, err)
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return data, s.verifyBlob(p, data)
+}
+
+// deleteBlob runs after the request, a blob left behind only costs space.
+func (s *Store) deleteBlob(p *BlobPointer) {
+	if p == nil {
+		return
+	}
+	if err := s.blobs.Delete(context.Background(), p.Name); err != nil {
+		s.log.Errorf("delete blob %s failed, %s", p.Name, err)
+	}
+}
+
+// blobScanPage is the page of the scan of a range delete for the pointer
+// records.
+const blobScanPage = 1000
+
+type keyPointer struct {
+	key string
+	p   *BlobPointer
+}
+
+// rangePointers returns the pointer records of the first limit keys of
+// [start, end), of every key without a limit. A range delete removes their
+// blobs once the records are gone.
+func (s *Store) rangePointers(ctx context.Context, start, end []byte, limit int) ([]keyPointer, error) {
+	if s.blobs == nil {
+		return nil, nil
+	}
+	var ps []keyPointer
+	for n := 0; limit <= 0 || n < limit; {
+		page := blobScanPage
+		if limit > 0 && limit-n < page {
+			page = limit - n
+		}
+		items, err := s.db.List(ctx, start, end, page, ListOption{})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if p, ok := decodePointer(utils.S2B(item.Value)); ok {
+				ps = append(ps, keyPointer{key: item.Key, p: p})
+			}
+		}
+		if len(items) < page {
+			break
+		}
+		n += len(items)
+		start = append([]byte(items[len(items)-1].Key), 0)
+	}
+	return ps, nil
+}
+
+// deleteRangeBlobs removes the blobs of the records deleted, a batch
+// delete which stopped at its limit left the records after lastKey.
+func (s *Store) deleteRangeBlobs(ps []keyPointer, lastKey []byte, stopped bool) {
+	for _, kp := range ps {
+		if stopped && kp.key > string(lastKey) {
+			continue
+		}
+		s.deleteBlob(kp.p)
+	}
+}
+
+// openValue returns the reader of the blob a pointer record points to.
+func (s *Store) openValue(ctx context.Context, v Value) (Value, error) {
+	p, ok := decodePointer(v.Value)
+	if !ok {
+		return v, nil
+	}
+	r, _, err := s.blobs.Open(ctx, p.Name)
+	if err != nil {
+		s.log.Errorf("open blob %s failed, %s", p.Name, err)
+		return NoValue, err
+	}
+	if s.conf.Checksum.Enable && p.SHA256 != "" {
+		r = &blobVerifier{ReadCloser: r, s: s, p: p, h: sha256.New()}
+	}
+	return Value{Secondary: v.Secondary, Reader: r, Size: p.Size}, nil
+}
+
+// blobWrite is the blob written by a CAS and the one it replaces, the check
+// may run more than once when the db retries.
+type blobWrite struct {
+	old *BlobPointer
+	new *BlobPointer
+}
+
+// blobCheck reads the blob of the existing value for check, and writes the
+// new value to a blob if it's larger than the threshold.
+func (s *Store) blobCheck(ctx context.Context, key []byte, check CheckFunc, w *blobWrite) CheckFunc {
+	return func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		s.deleteBlob(w.new)
+		w.old, w.new = nil, nil
+		if p, ok := decodePointer(existVal); ok {
+			data, err := s.readBlob(ctx, p)
+			if err != nil {
+				return nil, xerror.ErrGetKVFailed.Wrap(err)
+			}
+			w.old, existVal = p, data
+		}
+		var err error
+		if check != nil {
+			newVal, err = check(oldVal, newVal, existVal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(newVal) <= s.conf.Blob.Threshold {
+			return newVal, nil
+		}
+		w.new, err = s.putBlob(ctx, key, newVal)
+		if err != nil {
+			return nil, err
+		}
+		return encodePointer(w.new), nil
+	}
+}
+
+// blobDone removes the blob replaced by a successful CAS, or the one written
+// by a failed one.
+func (s *Store) blobDone(ctx context.Context, w *blobWrite, err error) error {
+	if err != nil {
+		s.deleteBlob(w.new)
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			if p, ok := decodePointer(conflict.Value); ok {
+				if data, rerr := s.readBlob(ctx, p); rerr == nil {
+					conflict.Value = data
+				}
+			}
+		}
+		return err
+	}
+	s.deleteBlob(w.old)
+	return nil
+}
+
+// blobItems writes the large entries of a batch put to blobs, the written
+// ones are returned to be removed if the batch fails.
+func (s *Store) blobItems(ctx context.Context, items []KeyEntry) ([]KeyEntry, []*BlobPointer, error) {
+	var written []*BlobPointer
+	out := items
+	for i, item := range items {
+		if len(item.Entry) <= s.conf.Blob.Threshold {
+			continue
+		}
+		if len(written) == 0 {
+			out = append([]KeyEntry{}, items...)
+		}
+		p, err := s.putBlob(ctx, item.Key, item.Entry)
+		if err != nil {
+			for _, w := range written {
+				s.deleteBlob(w)
+			}
+			return nil, nil, err
+		}
+		written = append(written, p)
+		out[i] = KeyEntry{Key: item.Key, Entry: encodePointer(p)}
+	}
+	return out, written, nil
+}
+
+// resolveItems replaces the pointer records of a list with the blobs.
+func (s *Store) resolveItems(ctx context.Context, items []KeyValue) error {
+	for i := range items {
+		p, ok := decodePointer([]byte(items[i].Value))
+		if !ok {
+			continue
+		}
+		data, err := s.readBlob(ctx, p)
+		if err != nil {
+			return xerror.ErrListKVFailed.Wrap(err)
+		}
+		items[i].Value = string(data)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type memBlobs map[string][]byte
+
+func (m memBlobs) Put(ctx context.Context, name string, data []byte) error {
+	m[name] = append([]byte{}, data...)
+	return nil
+}
+
+func (m memBlobs) Open(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, 0, xerror.ErrNotExists
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (m memBlobs) Delete(ctx context.Context, name string) error {
+	delete(m, name)
+	return nil
+}
+
+// casDB checks a CAS against the value in the map.
+type casDB struct {
+	memDB
+}
+
+func (d *casDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	exist := []byte(d.kv[string(key)])
+	val, err := option.Check(oldVal, newVal, exist)
+	if err != nil {
+		return NewConflictError(err, exist, 0)
+	}
+	if len(val) == 0 {
+		delete(d.kv, string(key))
+	} else {
+		d.kv[string(key)] = string(val)
+	}
+	return nil
+}
+
+// rangeKeys returns the first limit keys of [start, end) in order.
+func (d *casDB) rangeKeys(start, end []byte, limit int) []string {
+	var keys []string
+	for k := range d.kv {
+		if k >= string(start) && (len(end) == 0 || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+func (d *casDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	items := make([]KeyValue, 0)
+	for _, k := range d.rangeKeys(start, end, limit) {
+		key, val := []byte(k), []byte(d.kv[k])
+		if option.Item != nil {
+			key, val, _ = option.Item(key, val)
+		}
+		items = append(items, KeyValue{Key: string(key), Value: string(val)})
+	}
+	return items, nil
+}
+
+func equalCheck(oldVal, newVal, existVal []byte) ([]byte, error) {
+	if !bytes.Equal(oldVal, existVal) {
+		return nil, xerror.ErrCheckAndSetFailed
+	}
+	return newVal, nil
+}
+
+func cas(s *Store, key, old, new string) error {
+	entry, _ := json.Marshal(Log{Old: old, New: new})
+	return s.CheckAndPut(context.Background(), []byte(key), entry, CheckOption{Check: equalCheck})
+}
+
+func TestStoreBlob(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Blob.Threshold = 4
+	db := &casDB{memDB{kv: map[string]string{}}}
+	blobs := memBlobs{}
+	s := &Store{db: db, blobs: blobs, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	assert.Nil(t, cas(s, "small", "", "abc"))
+	assert.Equal(t, "abc", db.kv["small"])
+	assert.Len(t, blobs, 0)
+
+	assert.Nil(t, cas(s, "large", "", "abcdef"))
+	p, ok := decodePointer([]byte(db.kv["large"]))
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), p.Size)
+	assert.Equal(t, []byte("abcdef"), blobs[p.Name])
+
+	v, err := s.Get(ctx, []byte("large"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcdef"), v.Value)
+	v, err = s.GetStream(ctx, []byte("large"), GetOption{})
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadAll(v.Reader)
+	assert.Equal(t, []byte("abcdef"), data)
+	assert.Equal(t, int64(6), v.Size)
+
+	items, err := s.List(ctx, nil, nil, 0, ListOption{})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []KeyValue{{Key: "small", Value: "abc"}, {Key: "large", Value: "abcdef"}}, items)
+
+	// the check sees the blob, a failed CAS leaves no blob behind
+	err = cas(s, "large", "wrong", "ghijkl")
+	var conflict *ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, []byte("abcdef"), conflict.Value)
+	assert.Len(t, blobs, 1)
+
+	assert.Nil(t, cas(s, "large", "abcdef", "ghijklm"))
+	assert.Len(t, blobs, 1)
+	v, err = s.Get(ctx, []byte("large"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ghijklm"), v.Value)
+
+	assert.Nil(t, cas(s, "large", "ghijklm", ""))
+	assert.Len(t, blobs, 0)
+	assert.NotContains(t, db.kv, "large")
+
+	assert.Nil(t, s.BatchPut(ctx, []KeyEntry{
+		{Key: []byte("a"), Entry: []byte("1")},
+		{Key: []byte("b"), Entry: []byte("22222")},
+	}))
+	assert.Equal(t, "1", db.kv["a"])
+	assert.Len(t, blobs, 1)
+	v, err = s.Get(ctx, []byte("b"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("22222"), v.Value)
+}
+
+func (d *casDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	keys := d.rangeKeys(start, end, limit)
+	for _, k := range keys {
+		delete(d.kv, k)
+	}
+	if len(keys) == 0 {
+		return nil, 0, nil
+	}
+	return []byte(keys[len(keys)-1]), len(keys), nil
+}
+
+func (d *casDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	_, _, err := d.BatchDelete(ctx, start, end, 0)
+	return err
+}
+
+func TestStoreBlobRangeDelete(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Blob.Threshold = 4
+	db := &casDB{memDB{kv: map[string]string{}}}
+	blobs := memBlobs{}
+	s := &Store{db: db, blobs: blobs, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	for _, k := range []string{"a1", "a2", "a3", "b1"} {
+		assert.Nil(t, cas(s, k, "", "large-"+k))
+	}
+	assert.Nil(t, cas(s, "a0", "", "abc"))
+	assert.Len(t, blobs, 4)
+
+	// the blobs after the last key of a batch stay with their records
+	lastKey, deleted, err := s.BatchDelete(ctx, []byte("a"), []byte("b"), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "a1", string(lastKey))
+	assert.Equal(t, 2, deleted)
+	assert.Len(t, blobs, 3)
+	_, deleted, err = s.BatchDelete(ctx, []byte("a"), []byte("b"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Len(t, blobs, 1)
+	v, err := s.Get(ctx, []byte("b1"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("large-b1"), v.Value)
+
+	assert.Nil(t, s.UnsafeDelete(ctx, []byte("b"), nil))
+	assert.Len(t, blobs, 0)
+	assert.Empty(t, db.kv)
+}
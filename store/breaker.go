@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/breaker"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	OpGet          = "get"
+	OpList         = "list"
+	OpPut          = "put"
+	OpCas          = "cas"
+	OpBatchPut     = "batch_put"
+	OpBatchDelete  = "batch_delete"
+	OpUnsafeDelete = "unsafe_delete"
+)
+
+var breakerOps = []string{OpGet, OpList, OpPut, OpCas, OpBatchPut, OpBatchDelete, OpUnsafeDelete}
+
+// breakerDB fails fast with ErrCircuitOpen while TiKV keeps failing or
+// slowing down, so requests don't pile up waiting on timeouts.
+type breakerDB struct {
+	DB
+	breakers map[string]*breaker.Breaker
+}
+
+// only errors of TiKV count, not found or a failed check are fine
+func isDBFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	category := xerror.CategoryOf(err)
+	return category == xerror.Internal || category == xerror.Unavailable
+}
+
+func newBreakerDB(db DB, conf *config.Breaker) *breakerDB {
+	b := &breakerDB{DB: db, breakers: make(map[string]*breaker.Breaker)}
+	log := logrus.WithFields(logrus.Fields{"worker": "breaker"})
+	for _, op := range breakerOps {
+		op := op
+		metric.BreakerState.WithLabelValues(op).Set(float64(breaker.Closed))
+		b.breakers[op] = breaker.New(breaker.Options{
+			Window:         conf.Window.Duration,
+			MinRequests:    conf.MinRequests,
+			ErrorRate:      conf.ErrorRate,
+			SlowThreshold:  conf.SlowThreshold.Duration,
+			SlowRate:       conf.SlowRate,
+			OpenTimeout:    conf.OpenTimeout.Duration,
+			HalfOpenProbes: conf.HalfOpenProbes,
+			IsFailure:      isDBFailure,
+			OnStateChange: func(from, to breaker.State) {
+				log.Warnf("%s breaker %s -> %s", op, from, to)
+				metric.BreakerState.WithLabelValues(op).Set(float64(to))
+			},
+		})
+	}
+	return b
+}
+
+func (b *breakerDB) do(ctx context.Context, op string, fn func() error) error {
+	err := b.breakers[op].Do(func() error {
+		return contextError(ctx, fn())
+	})
+	if err == breaker.ErrOpen {
+		metric.BreakerRejected.WithLabelValues(op).Inc()
+		return xerror.ErrCircuitOpen
+	}
+	return err
+}
+
+func (b *breakerDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	var v Value
+	err := b.do(ctx, OpGet, func() error {
+		var err error
+		v, err = b.DB.Get(ctx, key, option)
+		return err
+	})
+	return v, err
+}
+
+func (b *breakerDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	var kvs []KeyValue
+	err := b.do(ctx, OpList, func() error {
+		var err error
+		kvs, err = b.DB.List(ctx, start, end, limit, option)
+		return err
+	})
+	return kvs, err
+}
+
+// Scan doesn't count the errors of fn, e.g. a client gone, as failures.
+func (b *breakerDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	var fnErr error
+	err := b.do(ctx, OpList, func() error {
+		err := scan(ctx, b.DB, start, end, limit, option, func(key, val []byte) error {
+			fnErr = fn(key, val)
+			return fnErr
+		})
+		if fnErr != nil {
+			return nil
+		}
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (b *breakerDB) Put(ctx context.Context, key, val []byte) error {
+	return b.do(ctx, OpPut, func() error {
+		return b.DB.Put(ctx, key, val)
+	})
+}
+
+func (b *breakerDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	return b.do(ctx, OpCas, func() error {
+		return b.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	})
+}
+
+func (b *breakerDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	return b.do(ctx, OpBatchPut, func() error {
+		return b.DB.BatchPut(ctx, items)
+	})
+}
+
+func (b *breakerDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	var lastKey []byte
+	var count int
+	err := b.do(ctx, OpBatchDelete, func() error {
+		var err error
+		lastKey, count, err = b.DB.BatchDelete(ctx, start, end, limit)
+		return err
+	})
+	return lastKey, count, err
+}
+
+func (b *breakerDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	return b.do(ctx, OpUnsafeDelete, func() error {
+		return b.DB.UnsafeDelete(ctx, start, end)
+	})
+}
+
+func (b *breakerDB) Unwrap() DB {
+	return b.DB
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// The endpoints with a timeout budget, they label the timeout metric.
+const (
+	EndpointGet         = "get"
+	EndpointPut         = "put"
+	EndpointCas         = "cas"
+	EndpointList        = "list"
+	EndpointBatchDelete = "batch_delete"
+)
+
+func nopCancel() {}
+
+// budget bounds ctx with the [timeout] of endpoint, a sooner deadline of
+// the caller still applies. Without a budget ctx is returned as it is.
+func (s *Store) budget(ctx context.Context, endpoint string) (context.Context, context.CancelFunc) {
+	conf := &s.conf.Timeout
+	var d *config.Duration
+	switch endpoint {
+	case EndpointGet:
+		d = conf.Get
+	case EndpointPut:
+		d = conf.Put
+	case EndpointCas:
+		d = conf.Cas
+	case EndpointList:
+		d = conf.List
+	case EndpointBatchDelete:
+		d = conf.BatchDelete
+	}
+	if d == nil || d.Duration <= 0 {
+		return ctx, nopCancel
+	}
+	return context.WithTimeout(ctx, d.Duration)
+}
+
+// timedOut counts err for endpoint if the call ran out of time.
+func timedOut(endpoint string, err error) error {
+	if err != nil && errors.Is(err, xerror.ErrRequestTimeout) {
+		metric.Timeout.WithLabelValues(endpoint).Inc()
+	}
+	return err
+}
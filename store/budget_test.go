@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowDB answers once ctx is done.
+type slowDB struct {
+	stateDB
+}
+
+func (d *slowDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	<-ctx.Done()
+	return NoValue, ctx.Err()
+}
+
+func (d *slowDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestBudget(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Timeout.Get = &config.Duration{Duration: 10 * time.Millisecond}
+	conf.Timeout.List = &config.Duration{Duration: 50 * time.Millisecond}
+	s := &Store{db: &slowDB{}, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	gets := testutil.ToFloat64(metric.Timeout.WithLabelValues(EndpointGet))
+	start := time.Now()
+	_, err := s.Get(ctx, []byte("a"), GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrRequestTimeout), err)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+	assert.Equal(t, gets+1, testutil.ToFloat64(metric.Timeout.WithLabelValues(EndpointGet)))
+
+	lists := testutil.ToFloat64(metric.Timeout.WithLabelValues(EndpointList))
+	start = time.Now()
+	_, err = s.List(ctx, []byte("a"), []byte("b"), 10, ListOption{})
+	assert.True(t, errors.Is(err, xerror.ErrRequestTimeout), err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Equal(t, lists+1, testutil.ToFloat64(metric.Timeout.WithLabelValues(EndpointList)))
+
+	// the sooner deadline of the caller wins
+	cctx, cancel := context.WithTimeout(ctx, 5*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = s.List(cctx, []byte("a"), []byte("b"), 10, ListOption{})
+	assert.True(t, errors.Is(err, xerror.ErrRequestTimeout), err)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	ClassList        = "list"
+	ClassBatchDelete = "batch_delete"
+	ClassWrite       = "write"
+)
+
+// bulkhead bounds the concurrent calls of a class, a call waits at most
+// maxWait for a slot and is rejected after that.
+type bulkhead struct {
+	class   string
+	sem     chan struct{}
+	maxWait time.Duration
+}
+
+func newBulkhead(class string, size int, maxWait time.Duration) *bulkhead {
+	if size <= 0 {
+		return nil
+	}
+	return &bulkhead{class: class, sem: make(chan struct{}, size), maxWait: maxWait}
+}
+
+func (b *bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.sem <- struct{}{}:
+		metric.BulkheadInflight.WithLabelValues(b.class).Inc()
+		return nil
+	default:
+	}
+	if b.maxWait <= 0 {
+		metric.BulkheadRejected.WithLabelValues(b.class).Inc()
+		return xerror.ErrConcurrencyLimited
+	}
+
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+	select {
+	case b.sem <- struct{}{}:
+		metric.BulkheadInflight.WithLabelValues(b.class).Inc()
+		return nil
+	case <-timer.C:
+		metric.BulkheadRejected.WithLabelValues(b.class).Inc()
+		return xerror.ErrConcurrencyLimited
+	case <-ctx.Done():
+		return contextError(ctx, ctx.Err())
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.sem
+	metric.BulkheadInflight.WithLabelValues(b.class).Dec()
+}
+
+func (b *bulkhead) do(ctx context.Context, fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+	defer b.release()
+	return fn()
+}
+
+// bulkheadDB keeps heavy scans, range deletes and writes in separate
+// pools, so none of them can take all TiKV connections from point reads.
+type bulkheadDB struct {
+	DB
+	list        *bulkhead
+	batchDelete *bulkhead
+	write       *bulkhead
+}
+
+func newBulkheadDB(db DB, conf *config.Bulkhead) *bulkheadDB {
+	return &bulkheadDB{
+		DB:          db,
+		list:        newBulkhead(ClassList, conf.List, conf.MaxWait.Duration),
+		batchDelete: newBulkhead(ClassBatchDelete, conf.BatchDelete, conf.MaxWait.Duration),
+		write:       newBulkhead(ClassWrite, conf.Write, conf.MaxWait.Duration),
+	}
+}
+
+func (b *bulkheadDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	var kvs []KeyValue
+	err := b.list.do(ctx, func() error {
+		var err error
+		kvs, err = b.DB.List(ctx, start, end, limit, option)
+		return err
+	})
+	return kvs, err
+}
+
+// Scan holds a list slot until fn has taken the last item.
+func (b *bulkheadDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return b.list.do(ctx, func() error {
+		return scan(ctx, b.DB, start, end, limit, option, fn)
+	})
+}
+
+func (b *bulkheadDB) Put(ctx context.Context, key, val []byte) error {
+	return b.write.do(ctx, func() error {
+		return b.DB.Put(ctx, key, val)
+	})
+}
+
+func (b *bulkheadDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	return b.write.do(ctx, func() error {
+		return b.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	})
+}
+
+func (b *bulkheadDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	return b.write.do(ctx, func() error {
+		return b.DB.BatchPut(ctx, items)
+	})
+}
+
+func (b *bulkheadDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	var lastKey []byte
+	var count int
+	err := b.batchDelete.do(ctx, func() error {
+		var err error
+		lastKey, count, err = b.DB.BatchDelete(ctx, start, end, limit)
+		return err
+	})
+	return lastKey, count, err
+}
+
+func (b *bulkheadDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	return b.batchDelete.do(ctx, func() error {
+		return b.DB.UnsafeDelete(ctx, start, end)
+	})
+}
+
+func (b *bulkheadDB) Unwrap() DB {
+	return b.DB
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// Cache keeps hot values, an entry with an empty value marks a missing key,
+// TiKV never stores empty values so they can't be confused.
+type Cache interface {
+	Close() error
+	Get(key []byte) (Value, bool)
+	Set(key []byte, val Value, ttl time.Duration)
+	Delete(key []byte)
+	DeleteRange(start, end []byte)
+}
+
+type CacheDriver interface {
+	Name() string
+	Open(conf *config.Config) (Cache, error)
+}
+
+var caDrivers = make(map[string]CacheDriver)
+
+func RegisterCache(driver CacheDriver) {
+	name := driver.Name()
+	if _, ok := caDrivers[name]; ok {
+		panic(fmt.Errorf("cache %s is already registered", name))
+	}
+
+	caDrivers[name] = driver
+}
+
+func (s *Store) OpenCache() error {
+	if s.conf.Cache.Name == "" {
+		return nil
+	}
+	caDriver := caDrivers[s.conf.Cache.Name]
+	cache, err := caDriver.Open(s.conf)
+	if err != nil {
+		s.log.Errorf("open cache %s failed, %s", s.conf.Cache.Name, err)
+		return err
+	}
+	if !s.keep(func() { s.cache = cache }) {
+		cache.Close()
+		return xerror.ErrStoreClosed
+	}
+	return nil
+}
+
+func (s *Store) cacheGet(key []byte, opt GetOption) (Value, bool) {
+	if s.cache == nil || opt.Secondary != nil {
+		return NoValue, false
+	}
+	v, ok := s.cache.Get(key)
+	if !ok {
+		metric.CacheMiss.Inc()
+	} else if len(v.Value) == 0 {
+		metric.CacheNegativeHit.Inc()
+	} else {
+		metric.CacheHit.Inc()
+	}
+	return v, ok
+}
+
+// cacheSet only fills the cache when no write happened since gen was taken,
+// otherwise a concurrent read could put back a value that is already stale.
+func (s *Store) cacheSet(key []byte, opt GetOption, gen uint64, v Value) {
+	if s.cache == nil || opt.Secondary != nil {
+		return
+	}
+	if s.cacheGen() != gen {
+		return
+	}
+	s.cache.Set(key, v, s.conf.Cache.TTL.Duration)
+}
+
+func (s *Store) cacheSetMissing(key []byte, opt GetOption, gen uint64) {
+	if s.cache == nil || opt.Secondary != nil || s.conf.Cache.NegativeTTL.Duration <= 0 {
+		return
+	}
+	if s.cacheGen() != gen {
+		return
+	}
+	s.cache.Set(key, NoValue, s.conf.Cache.NegativeTTL.Duration)
+}
+
+func (s *Store) cacheInvalidate(key []byte) {
+	if s.cache == nil {
+		return
+	}
+	s.invalidate()
+	s.cache.Delete(key)
+	metric.CacheInvalidate.Inc()
+}
+
+func (s *Store) cacheInvalidateRange(start, end []byte) {
+	if s.cache == nil {
+		return
+	}
+	s.invalidate()
+	s.cache.DeleteRange(start, end)
+	metric.CacheInvalidate.Inc()
+}
+
+func (s *Store) cacheGen() uint64 {
+	return atomic.LoadUint64(&s.gen)
+}
+
+func (s *Store) invalidate() {
+	atomic.AddUint64(&s.gen, 1)
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// The catalog is kept by the proxy under the meta key __meta/, as
+// __meta/kind/name, the users can't read, write, list or delete it.
+const CatalogPrefix = "__meta/"
+
+const (
+	CatalogNamespaces = "namespaces"
+	CatalogQuotas     = "quotas"
+	CatalogJobs       = "jobs"
+	CatalogLocks      = "locks"
+	CatalogSchemas    = "schemas"
+)
+
+const catalogListLimit = 10000
+
+var (
+	catalogStart = append([]byte{0x00}, CatalogPrefix...)
+	catalogEnd   = PrefixEnd(catalogStart)
+)
+
+// CatalogEntry is the value of a catalog key.
+type CatalogEntry struct {
+	Kind      string          `json:"kind"`
+	Name      string          `json:"name"`
+	Value     json.RawMessage `json:"value"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// CatalogLock is the value of a lock, it's free once expired.
+type CatalogLock struct {
+	Owner    string `json:"owner"`
+	ExpireAt int64  `json:"expire_at"`
+}
+
+func catalogKey(kind, name string) []byte {
+	k := make([]byte, 0, len(catalogStart)+len(kind)+1+len(name))
+	k = append(k, catalogStart...)
+	k = append(k, kind...)
+	k = append(k, '/')
+	return append(k, name...)
+}
+
+// IsReserved is true for the keys of the catalog.
+func IsReserved(key []byte) bool {
+	return bytes.HasPrefix(key, catalogStart)
+}
+
+// overlapsCatalog is true if [start, end) holds catalog keys, an empty end
+// is the end of the keys.
+func overlapsCatalog(start, end []byte) bool {
+	return bytes.Compare(start, catalogEnd) < 0 &&
+		(len(end) == 0 || bytes.Compare(end, catalogStart) > 0)
+}
+
+// outsideCatalog cuts the catalog out of the spans, the parts of a span
+// are in the order of the list.
+func outsideCatalog(spans []span, reverse bool) []span {
+	out := make([]span, 0, len(spans)+1)
+	for _, sp := range spans {
+		if !overlapsCatalog(sp.start, sp.end) {
+			out = append(out, sp)
+			continue
+		}
+		parts := make([]span, 0, 2)
+		if bytes.Compare(sp.start, catalogStart) < 0 {
+			parts = append(parts, span{start: sp.start, end: catalogStart})
+		}
+		if len(sp.end) == 0 || bytes.Compare(sp.end, catalogEnd) > 0 {
+			parts = append(parts, span{start: catalogEnd, end: sp.end})
+		}
+		if reverse && len(parts) == 2 {
+			parts[0], parts[1] = parts[1], parts[0]
+		}
+		out = append(out, parts...)
+	}
+	return out
+}
+
+// PutCatalog sets the entry kind/name to value.
+func (s *Store) PutCatalog(ctx context.Context, kind, name string, value interface{}) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	val, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(&CatalogEntry{Kind: kind, Name: name, Value: val, UpdatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return s.db.Put(ctx, catalogKey(kind, name), entry)
+}
+
+// GetCatalog returns the entry kind/name, xerror.ErrNotExists if it's
+// missing.
+func (s *Store) GetCatalog(ctx context.Context, kind, name string) (*CatalogEntry, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	v, err := s.db.Get(ctx, catalogKey(kind, name), GetOption{})
+	if err != nil {
+		return nil, err
+	}
+	e := &CatalogEntry{}
+	if err := json.Unmarshal(v.Value, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ListCatalog returns the entries of kind, by name.
+func (s *Store) ListCatalog(ctx context.Context, kind string) ([]CatalogEntry, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	start := catalogKey(kind, "")
+	items, err := s.db.List(ctx, start, PrefixEnd(start), catalogListLimit, ListOption{})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]CatalogEntry, 0, len(items))
+	for _, item := range items {
+		var e CatalogEntry
+		if err := json.Unmarshal([]byte(item.Value), &e); err != nil {
+			s.log.Errorf("decode catalog %q failed, %s", item.Key, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// DeleteCatalog drops the entry kind/name.
+func (s *Store) DeleteCatalog(ctx context.Context, kind, name string) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	return s.db.Put(ctx, catalogKey(kind, name), nil)
+}
+
+// AcquireLock takes the lock name for owner until ttl, an owner holding it
+// renews it. It fails with xerror.ErrLockHeld while another owner holds it.
+func (s *Store) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) error {
+	now := time.Now()
+	return s.casLock(ctx, name, owner, &CatalogLock{Owner: owner, ExpireAt: now.Add(ttl).UnixNano()}, now)
+}
+
+// ReleaseLock frees the lock name held by owner, a lock held by another
+// owner is left as it is.
+func (s *Store) ReleaseLock(ctx context.Context, name, owner string) error {
+	now := time.Now()
+	err := s.casLock(ctx, name, owner, &CatalogLock{Owner: owner, ExpireAt: now.UnixNano()}, now)
+	if errors.Is(err, xerror.ErrLockHeld) {
+		return nil
+	}
+	return err
+}
+
+// casLock sets the lock name to lock if it's free or held by owner.
+func (s *Store) casLock(ctx context.Context, name, owner string, lock *CatalogLock, now time.Time) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	val, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(&CatalogEntry{Kind: CatalogLocks, Name: name, Value: val, UpdatedAt: now})
+	if err != nil {
+		return err
+	}
+	err = s.db.CheckAndPut(ctx, catalogKey(CatalogLocks, name), nil, entry, CheckOption{
+		Check: func(_, newVal, existVal []byte) ([]byte, error) {
+			if len(existVal) == 0 {
+				return newVal, nil
+			}
+			e := CatalogEntry{}
+			held := CatalogLock{}
+			if json.Unmarshal(existVal, &e) != nil || json.Unmarshal(e.Value, &held) != nil ||
+				held.Owner == owner || held.ExpireAt <= now.UnixNano() {
+				return newVal, nil
+			}
+			return nil, xerror.ErrLockHeld
+		},
+	})
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return xerror.ErrLockHeld
+	}
+	return err
+}
+
+// syncCatalog records the namespaces, the quotas and the schemas of the
+// config once the store is ready, the entries left from an older config
+// are dropped. The proxies of a cluster share the catalog, the last one
+// opened wins.
+func (s *Store) syncCatalog() {
+	ctx := context.Background()
+	namespaces := make(map[string]interface{}, len(s.conf.Tiers))
+	for _, t := range s.conf.Tiers {
+		namespaces[t.Prefix] = map[string]string{"store": t.Name}
+	}
+	quotas := map[string]interface{}{
+		"server": map[string]int64{
+			"max-value-size": s.conf.Server.MaxValueSize,
+			"max-list-limit": int64(s.conf.Server.MaxListLimit),
+			"list-bytes":     s.conf.Server.ListBytes,
+			"max-list-bytes": s.conf.Server.MaxListBytes,
+		},
+	}
+	if s.conf.Bulkhead.Enable {
+		quotas["bulkhead"] = s.conf.Bulkhead
+	}
+	schemas := make(map[string]interface{}, len(s.conf.Validations)+len(s.conf.FieldRules))
+	for _, v := range s.conf.Validations {
+		schemas["validation/"+v.Prefix] = v
+	}
+	for _, f := range s.conf.FieldRules {
+		schemas["field-rule/"+f.Prefix] = f
+	}
+
+	for kind, entries := range map[string]map[string]interface{}{
+		CatalogNamespaces: namespaces,
+		CatalogQuotas:     quotas,
+		CatalogSchemas:    schemas,
+	} {
+		if err := s.replaceCatalog(ctx, kind, entries); err != nil {
+			s.log.Errorf("sync catalog %s failed, %s", kind, err)
+		}
+	}
+}
+
+func (s *Store) replaceCatalog(ctx context.Context, kind string, entries map[string]interface{}) error {
+	old, err := s.ListCatalog(ctx, kind)
+	if err != nil {
+		return err
+	}
+	for _, e := range old {
+		if _, ok := entries[e.Name]; !ok {
+			if err := s.DeleteCatalog(ctx, kind, e.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, value := range entries {
+		if err := s.PutCatalog(ctx, kind, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutsideCatalog(t *testing.T) {
+	user := func(s string) []byte { return append([]byte{0x00}, s...) }
+	assert.Equal(t, []span{{start: user("a"), end: user("b")}},
+		outsideCatalog([]span{{start: user("a"), end: user("b")}}, false))
+	assert.Equal(t, []span{}, outsideCatalog([]span{{start: user("__meta/a"), end: user("__meta/b")}}, false))
+
+	spans := outsideCatalog([]span{{start: user("A"), end: []byte{0x01}}}, false)
+	assert.Equal(t, []span{{start: user("A"), end: catalogStart}, {start: catalogEnd, end: []byte{0x01}}}, spans)
+	spans = outsideCatalog([]span{{start: user("A"), end: []byte{0x01}}}, true)
+	assert.Equal(t, []span{{start: catalogEnd, end: []byte{0x01}}, {start: user("A"), end: catalogStart}}, spans)
+	spans = outsideCatalog([]span{{start: user("__meta/x"), end: nil}}, false)
+	assert.Equal(t, []span{{start: catalogEnd, end: nil}}, spans)
+}
+
+func TestCatalog(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Tiers = []config.Tier{{Prefix: "cold/", Store: config.Store{Name: "tikv"}}}
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	s.syncCatalog()
+	e, err := s.GetCatalog(ctx, CatalogNamespaces, "cold/")
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"store":"tikv"}`, string(e.Value))
+	quotas, err := s.ListCatalog(ctx, CatalogQuotas)
+	assert.Nil(t, err)
+	assert.Len(t, quotas, 1)
+
+	// an entry gone from the config is dropped
+	conf.Tiers = nil
+	s.syncCatalog()
+	_, err = s.GetCatalog(ctx, CatalogNamespaces, "cold/")
+	assert.Equal(t, xerror.ErrNotExists, err)
+
+	assert.Nil(t, s.AcquireLock(ctx, "l", "a", time.Minute))
+	assert.Nil(t, s.AcquireLock(ctx, "l", "a", time.Minute))
+	assert.Equal(t, xerror.ErrLockHeld, s.AcquireLock(ctx, "l", "b", time.Minute))
+	assert.Nil(t, s.ReleaseLock(ctx, "l", "b"))
+	assert.Equal(t, xerror.ErrLockHeld, s.AcquireLock(ctx, "l", "b", time.Minute))
+	assert.Nil(t, s.ReleaseLock(ctx, "l", "a"))
+	assert.Nil(t, s.AcquireLock(ctx, "l", "b", time.Minute))
+
+	// the users can't reach the catalog
+	key := catalogKey(CatalogLocks, "l")
+	_, err = s.Get(ctx, key, GetOption{})
+	assert.Equal(t, xerror.ErrKeyReserved, err)
+	assert.Equal(t, xerror.ErrKeyReserved, s.UnsafePut(ctx, key, nil))
+	assert.Equal(t, xerror.ErrKeyReserved, s.BatchPut(ctx, []KeyEntry{{Key: key, Entry: []byte("v")}}))
+	assert.Equal(t, xerror.ErrKeyReserved, cas(s, string(key), "", "v"))
+
+	assert.Nil(t, s.UnsafePut(ctx, []byte{0x00, 'a'}, []byte("v")))
+	items, err := s.List(ctx, []byte{0x00}, []byte{0x01}, 0, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: "\x00a", Value: "v"}}, items)
+	n := len(db.kv)
+	_, err = s.DeleteRange(ctx, []byte{0x00}, []byte{0x01}, 0, func(int) {})
+	assert.Nil(t, err)
+	assert.Len(t, db.kv, n-1)
+}
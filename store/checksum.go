@@ -0,0 +1,264 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ChecksumLog    = "log"
+	ChecksumReject = "reject"
+	ChecksumRepair = "repair"
+
+	checksumLogged   = "logged"
+	checksumRejected = "rejected"
+	checksumRepaired = "repaired"
+)
+
+// checksumMagic starts a record with a checksum, the CRC32C of the value
+// follows it and then the value.
+var checksumMagic = []byte("\x00tirest.crc\x00")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// withChecksum keeps an empty value empty, it deletes the key.
+func withChecksum(val []byte) []byte {
+	if len(val) == 0 {
+		return val
+	}
+	b := make([]byte, len(checksumMagic)+4+len(val))
+	n := copy(b, checksumMagic)
+	binary.BigEndian.PutUint32(b[n:], crc32.Checksum(val, castagnoli))
+	copy(b[n+4:], val)
+	return b
+}
+
+// verifyChecksum returns the value of a record and false if it doesn't
+// match its checksum, a value written without one is returned as is.
+func verifyChecksum(v []byte) ([]byte, bool) {
+	if len(v) < len(checksumMagic)+4 || !bytes.HasPrefix(v, checksumMagic) {
+		return v, true
+	}
+	n := len(checksumMagic)
+	val := v[n+4:]
+	return val, binary.BigEndian.Uint32(v[n:]) == crc32.Checksum(val, castagnoli)
+}
+
+// checksumDB stores each value with its checksum and checks it on reads,
+// a value which doesn't match is handled by mode. A list leaves it out
+// unless mode is log, a get of the key repairs it, in the background with
+// repairs.
+type checksumDB struct {
+	DB
+	mode    string
+	repairs *repairer
+	log     *logrus.Entry
+}
+
+func newChecksumDB(db DB, conf *config.Checksum, repairs *repairer) *checksumDB {
+	return &checksumDB{DB: db, mode: conf.OnMismatch, repairs: repairs,
+		log: logrus.WithFields(logrus.Fields{"worker": "checksum"})}
+}
+
+func (d *checksumDB) Unwrap() DB {
+	return d.DB
+}
+
+func (d *checksumDB) Put(ctx context.Context, key, val []byte) error {
+	return d.DB.Put(ctx, key, withChecksum(val))
+}
+
+func (d *checksumDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	sealed := make([]KeyEntry, len(items))
+	for i, item := range items {
+		sealed[i] = KeyEntry{Key: item.Key, Entry: withChecksum(item.Entry)}
+	}
+	return d.DB.BatchPut(ctx, sealed)
+}
+
+// CheckAndPut refuses to check against a value which doesn't match unless
+// mode is log, an unsafe put overwrites it.
+func (d *checksumDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	check := option.Check
+	option.Check = func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		existVal, ok := verifyChecksum(existVal)
+		if !ok {
+			if err := d.mismatch(key, d.mode == ChecksumLog); err != nil {
+				return nil, err
+			}
+		}
+		val := newVal
+		if check != nil {
+			var err error
+			val, err = check(oldVal, newVal, existVal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return withChecksum(val), nil
+	}
+	err := d.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		conflict.Value, _ = verifyChecksum(conflict.Value)
+	}
+	return err
+}
+
+func (d *checksumDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	v, err := d.DB.Get(ctx, key, option)
+	if err != nil {
+		return v, err
+	}
+	raw := v.Value
+	val, ok := verifyChecksum(raw)
+	if ok {
+		v.Value = val
+		return v, nil
+	}
+	if v.Secondary {
+		key = option.Secondary
+	}
+	switch d.mode {
+	case ChecksumLog:
+		v.Value = val
+		return v, d.mismatch(key, true)
+	case ChecksumRepair:
+		if val, ok := d.repair(ctx, key, raw, option); ok {
+			v.Value = val
+			return v, nil
+		}
+	}
+	return NoValue, d.mismatch(key, false)
+}
+
+// repair reads key from another replica than the read which didn't match,
+// a value found intact is written back unless the key was written since,
+// right away without repairs.
+func (d *checksumDB) repair(ctx context.Context, key, bad []byte, option GetOption) ([]byte, bool) {
+	v, err := d.DB.Get(ctx, key, GetOption{ReplicaRead: !option.ReplicaRead})
+	if err != nil {
+		d.log.Errorf("read key %s to repair, %s", key, err)
+		return nil, false
+	}
+	val, ok := verifyChecksum(v.Value)
+	if !ok || bytes.Equal(v.Value, bad) {
+		return nil, false
+	}
+	metric.ChecksumMismatch.WithLabelValues(checksumRepaired).Inc()
+	d.log.Warnf("key %s checksum mismatch, repaired from another replica", key)
+	key, bad, good := copyBytes(key), copyBytes(bad), copyBytes(v.Value)
+	writeBack := func(ctx context.Context) error {
+		return d.DB.CheckAndPut(ctx, key, nil, nil, CheckOption{
+			Check: func(_, _, existVal []byte) ([]byte, error) {
+				if !bytes.Equal(existVal, bad) {
+					return nil, xerror.ErrAlreadyExists
+				}
+				return good, nil
+			},
+		})
+	}
+	if d.repairs != nil {
+		d.repairs.repair(RepairChecksum, key, writeBack)
+	} else if err := writeBack(ctx); err != nil && !errors.Is(err, xerror.ErrAlreadyExists) {
+		d.log.Errorf("write key %s to repair, %s", key, err)
+	}
+	return val, true
+}
+
+// mismatch counts a value which doesn't match, it's an error unless it's
+// returned anyway.
+func (d *checksumDB) mismatch(key []byte, returned bool) error {
+	if returned {
+		metric.ChecksumMismatch.WithLabelValues(checksumLogged).Inc()
+		d.log.Errorf("key %s checksum mismatch", key)
+		return nil
+	}
+	metric.ChecksumMismatch.WithLabelValues(checksumRejected).Inc()
+	d.log.Errorf("key %s checksum mismatch, rejected", key)
+	return xerror.ErrChecksumMismatch
+}
+
+func (d *checksumDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	return d.DB.List(ctx, start, end, limit, d.verifyOption(option))
+}
+
+func (d *checksumDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return scan(ctx, d.DB, start, end, limit, d.verifyOption(option), fn)
+}
+
+// verifyOption checks the values before option.Item.
+func (d *checksumDB) verifyOption(option ListOption) ListOption {
+	item := option.Item
+	option.Item = func(key, val []byte) ([]byte, []byte, error) {
+		if !option.KeyOnly {
+			var ok bool
+			if val, ok = verifyChecksum(val); !ok {
+				if err := d.mismatch(key, d.mode == ChecksumLog); err != nil {
+					return key, nil, err
+				}
+			}
+		}
+		if item == nil {
+			return key, val, nil
+		}
+		return item(key, val)
+	}
+	return option
+}
+
+// verifyBlob checks data against the SHA-256 of its pointer.
+func (s *Store) verifyBlob(p *BlobPointer, data []byte) error {
+	if !s.conf.Checksum.Enable || p.SHA256 == "" {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return s.blobMismatch(p, hex.EncodeToString(sum[:]))
+}
+
+func (s *Store) blobMismatch(p *BlobPointer, sum string) error {
+	if sum == p.SHA256 {
+		return nil
+	}
+	if s.conf.Checksum.OnMismatch == ChecksumLog {
+		metric.ChecksumMismatch.WithLabelValues(checksumLogged).Inc()
+		s.log.Errorf("blob %s checksum mismatch", p.Name)
+		return nil
+	}
+	// a blob has no other copy to repair it from
+	metric.ChecksumMismatch.WithLabelValues(checksumRejected).Inc()
+	s.log.Errorf("blob %s checksum mismatch, rejected", p.Name)
+	return xerror.ErrChecksumMismatch
+}
+
+// blobVerifier checks the blob read through it once it's read to the end,
+// a mismatch rejected takes the place of io.EOF.
+type blobVerifier struct {
+	io.ReadCloser
+	s *Store
+	p *BlobPointer
+	h hash.Hash
+}
+
+func (r *blobVerifier) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.h.Write(b[:n])
+	if err == io.EOF {
+		if e := r.s.blobMismatch(r.p, hex.EncodeToString(r.h.Sum(nil))); e != nil {
+			return n, e
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// followerDB reads the replica reads from follower.
+type followerDB struct {
+	casDB
+	follower map[string]string
+}
+
+func (d *followerDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	if v, ok := d.follower[string(key)]; ok && option.ReplicaRead {
+		return Value{Value: []byte(v)}, nil
+	}
+	return d.casDB.Get(ctx, key, option)
+}
+
+// flip flips a bit of the last byte of the value of key.
+func flip(kv map[string]string, key string) {
+	b := []byte(kv[key])
+	b[len(b)-1] ^= 1
+	kv[key] = string(b)
+}
+
+func TestChecksumDB(t *testing.T) {
+	inner := &followerDB{casDB: casDB{memDB{kv: map[string]string{}}}, follower: map[string]string{}}
+	conf := &config.Checksum{Enable: true, OnMismatch: ChecksumLog}
+	db := newChecksumDB(inner, conf, nil)
+	ctx := context.Background()
+
+	assert.Nil(t, db.Put(ctx, []byte("a"), []byte("value")))
+	assert.NotEqual(t, "value", inner.kv["a"])
+	v, err := db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	items, err := db.List(ctx, nil, nil, 0, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: "a", Value: "value"}}, items)
+
+	// values written before are read as is
+	inner.kv["old"] = "plain"
+	v, err = db.Get(ctx, []byte("old"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", string(v.Value))
+
+	good := inner.kv["a"]
+	flip(inner.kv, "a")
+	logged := testutil.ToFloat64(metric.ChecksumMismatch.WithLabelValues(checksumLogged))
+	v, err = db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "valud", string(v.Value))
+	assert.Equal(t, logged+1, testutil.ToFloat64(metric.ChecksumMismatch.WithLabelValues(checksumLogged)))
+
+	db.mode = ChecksumReject
+	_, err = db.Get(ctx, []byte("a"), GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrChecksumMismatch))
+	err = db.CheckAndPut(ctx, []byte("a"), nil, []byte("new"), CheckOption{})
+	assert.True(t, errors.Is(err, xerror.ErrChecksumMismatch))
+
+	// repaired from the follower, the follower may be corrupted too
+	db.mode = ChecksumRepair
+	inner.follower["a"] = inner.kv["a"]
+	_, err = db.Get(ctx, []byte("a"), GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrChecksumMismatch))
+	inner.follower["a"] = good
+	v, err = db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	assert.Equal(t, good, inner.kv["a"])
+
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("a"), nil, []byte("new"), CheckOption{}))
+	v, err = db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "new", string(v.Value))
+}
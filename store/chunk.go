@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const ChunkBlobName = "chunk"
+
+// ChunkType prefixes the chunk parts, out of the meta keys the users list
+// and delete.
+const ChunkType byte = 0x04
+
+// chunkStore is the blob store in the db, a blob is split into chunk-size
+// parts put under ChunkType | name-N. The pointer record is the manifest,
+// so a CAS on it switches to the new parts at once, the parts of a failed
+// CAS are under another id and removed.
+type chunkStore struct {
+	s *Store
+}
+
+// partPrefix returns ChunkType | name- of the blob name hex(key)/<id>.
+func partPrefix(name string) ([]byte, error) {
+	if strings.IndexByte(name, '/') < 0 {
+		return nil, errors.New("invalid chunk name " + name)
+	}
+	prefix := make([]byte, 0, len(name)+2)
+	prefix = append(prefix, ChunkType)
+	prefix = append(prefix, name...)
+	return append(prefix, '-'), nil
+}
+
+func partKey(prefix []byte, n int) []byte {
+	return strconv.AppendInt(append([]byte{}, prefix...), int64(n), 10)
+}
+
+// Put writes the parts in one batch, it's as atomic as a batch put of the db.
+func (c *chunkStore) Put(ctx context.Context, name string, data []byte) error {
+	prefix, err := partPrefix(name)
+	if err != nil {
+		return err
+	}
+	size := c.s.conf.Blob.ChunkSize
+	items := make([]KeyEntry, 0, (len(data)+size-1)/size)
+	for n := 0; len(data) > 0; n++ {
+		part := data
+		if len(part) > size {
+			part = part[:size]
+		}
+		items = append(items, KeyEntry{Key: partKey(prefix, n), Entry: part})
+		data = data[len(part):]
+	}
+	return c.s.db.BatchPut(ctx, items)
+}
+
+// PutStream puts a part once chunk-size bytes are read, the caller removes
+// the parts put before a failure.
+func (c *chunkStore) PutStream(ctx context.Context, name string, r io.Reader) error {
+	prefix, err := partPrefix(name)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, c.s.conf.Blob.ChunkSize)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if perr := c.s.db.Put(ctx, partKey(prefix, i), buf[:n]); perr != nil {
+				return perr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Open reads the parts one by one, the size isn't known until the last one.
+func (c *chunkStore) Open(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	prefix, err := partPrefix(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	r := &chunkReader{ctx: ctx, db: c.s.db, prefix: prefix}
+	if err = r.next(); err != nil {
+		return nil, 0, err
+	}
+	return r, -1, nil
+}
+
+// Delete removes the parts in batches of chunkDeleteLimit.
+func (c *chunkStore) Delete(ctx context.Context, name string) error {
+	prefix, err := partPrefix(name)
+	if err != nil {
+		return err
+	}
+	start, end := prefix, PrefixEnd(prefix)
+	for {
+		lastKey, n, err := c.s.db.BatchDelete(ctx, start, end, chunkDeleteLimit)
+		if err != nil {
+			return err
+		}
+		if n < chunkDeleteLimit {
+			return nil
+		}
+		start = append(lastKey, 0)
+	}
+}
+
+const chunkDeleteLimit = 1000
+
+type chunkReader struct {
+	ctx    context.Context
+	db     DB
+	prefix []byte
+	n      int
+	buf    []byte
+	done   bool
+}
+
+// next reads the next part, a missing one is the end of the blob.
+func (r *chunkReader) next() error {
+	v, err := r.db.Get(r.ctx, partKey(r.prefix, r.n), GetOption{})
+	if errors.Is(err, xerror.ErrNotExists) {
+		if r.n == 0 {
+			return err
+		}
+		r.done = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	r.n++
+	r.buf = v.Value
+	return nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	return nil
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkStore(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Blob.Name = ChunkBlobName
+	conf.Blob.Threshold = 4
+	conf.Blob.ChunkSize = 3
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	s.blobs = &chunkStore{s: s}
+	ctx := context.Background()
+
+	assert.Nil(t, cas(s, "k", "", "abcdefgh"))
+	assert.Len(t, db.kv, 4)
+	p, ok := decodePointer([]byte(db.kv["k"]))
+	assert.True(t, ok)
+	prefix, err := partPrefix(p.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", db.kv[string(prefix)+"0"])
+	assert.Equal(t, "gh", db.kv[string(prefix)+"2"])
+
+	v, err := s.GetStream(ctx, []byte("k"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), v.Size)
+	data, _ := ioutil.ReadAll(v.Reader)
+	assert.Equal(t, []byte("abcdefgh"), data)
+
+	// the parts are out of the keys, a key like one is listed
+	assert.True(t, prefix[0] == ChunkType)
+	assert.Nil(t, cas(s, "k/part-0123456789abcdef-0", "", "abc"))
+	items, err := s.List(ctx, []byte("a"), []byte("z"), 2, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: "k", Value: "abcdefgh"}, {Key: "k/part-0123456789abcdef-0", Value: "abc"}}, items)
+	assert.Nil(t, cas(s, "k/part-0123456789abcdef-0", "abc", ""))
+
+	assert.Nil(t, cas(s, "k", "abcdefgh", "ijklm"))
+	assert.Len(t, db.kv, 3)
+	v, err = s.Get(ctx, []byte("k"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ijklm"), v.Value)
+
+	assert.Nil(t, cas(s, "k", "ijklm", ""))
+	assert.Len(t, db.kv, 0)
+}
+
+func TestChunkStoreStream(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Blob.Name = ChunkBlobName
+	conf.Blob.Threshold = 4
+	conf.Blob.ChunkSize = 3
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	s.blobs = &chunkStore{s: s}
+	ctx := context.Background()
+
+	assert.Nil(t, s.UnsafePutStream(ctx, []byte("small"), strings.NewReader("abc")))
+	assert.Equal(t, "abc", db.kv["small"])
+
+	assert.Nil(t, s.UnsafePutStream(ctx, []byte("k"), strings.NewReader("abcdefghij")))
+	assert.Len(t, db.kv, 6)
+	p, ok := decodePointer([]byte(db.kv["k"]))
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), p.Size)
+	v, err := s.Get(ctx, []byte("k"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abcdefghij"), v.Value)
+
+	// the parts of the replaced value are removed
+	assert.Nil(t, s.UnsafePutStream(ctx, []byte("k"), strings.NewReader("klmnop")))
+	assert.Len(t, db.kv, 4)
+	assert.Nil(t, s.UnsafePut(ctx, []byte("k"), nil))
+	assert.Equal(t, map[string]string{"small": "abc"}, db.kv)
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+type KeyRange struct {
+	Name  string
+	Start []byte
+	End   []byte
+}
+
+// the key ranges used by the proxy, regions are counted for each one
+var clusterRanges = []KeyRange{
+	{Name: "meta", Start: []byte{0x00}, End: []byte{0x01}},
+	{Name: "idempotency", Start: []byte{IdempotencyType}, End: []byte{IdempotencyType + 1}},
+	{Name: "chunk", Start: []byte{ChunkType}, End: []byte{ChunkType + 1}},
+}
+
+type ClusterMember struct {
+	Name       string   `json:"name"`
+	ClientURLs []string `json:"client_urls"`
+	Leader     bool     `json:"leader"`
+}
+
+type ClusterStore struct {
+	ID      uint64 `json:"id"`
+	Address string `json:"address"`
+	Version string `json:"version"`
+	State   string `json:"state"`
+}
+
+// PDStatus is the connectivity to PD seen by the reconnect checks.
+type PDStatus struct {
+	Addresses     []string  `json:"addresses"`
+	Connected     bool      `json:"connected"`
+	Failures      int       `json:"failures"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastCheck     time.Time `json:"last_check"`
+	Reconnects    int       `json:"reconnects"`
+	LastReconnect time.Time `json:"last_reconnect"`
+}
+
+type ClusterInfo struct {
+	ClusterID uint64          `json:"cluster_id"`
+	Members   []ClusterMember `json:"members"`
+	Stores    []ClusterStore  `json:"stores"`
+	Regions   map[string]int  `json:"regions"`
+	PD        *PDStatus       `json:"pd,omitempty"`
+}
+
+// Cluster is implemented by a DB which can describe its cluster.
+type Cluster interface {
+	Cluster(ctx context.Context, ranges []KeyRange) (*ClusterInfo, error)
+}
+
+// Cluster may return the info known so far along with an error.
+func (s *Store) Cluster(ctx context.Context) (*ClusterInfo, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	cluster, ok := baseDB(s.db).(Cluster)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	info, err := cluster.Cluster(ctx, clusterRanges)
+	if err != nil {
+		s.log.Errorf("get cluster info failed, %s", err)
+		// the pd status may tell why
+		return info, contextError(ctx, err)
+	}
+	return info, nil
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+)
+
+// putBatch is the puts gathered in a window, err is set before done closes.
+type putBatch struct {
+	items []KeyEntry
+	index map[string]int
+	timer *time.Timer
+	done  chan struct{}
+	err   error
+}
+
+// coalesceDB gathers the puts arriving within window into one BatchPut of
+// at most maxBatch items, a put returns the error of its batch. A later put
+// of a key in the batch replaces the earlier one, both callers see it
+// written.
+type coalesceDB struct {
+	DB
+	window   time.Duration
+	maxBatch int
+
+	mu      sync.Mutex
+	pending *putBatch
+}
+
+func newCoalesceDB(db DB, conf *config.Coalesce) *coalesceDB {
+	return &coalesceDB{DB: db, window: conf.Window.Duration, maxBatch: conf.MaxBatch}
+}
+
+// Put waits for the batch even after ctx is done, the batch is written
+// without it and only the driver timeouts apply.
+func (c *coalesceDB) Put(ctx context.Context, key, val []byte) error {
+	c.mu.Lock()
+	b := c.pending
+	if b == nil {
+		b = &putBatch{index: make(map[string]int), done: make(chan struct{})}
+		b.timer = time.AfterFunc(c.window, func() { c.flush(b) })
+		c.pending = b
+	}
+	// the caller may reuse key and val once ctx is done
+	val = append([]byte(nil), val...)
+	if i, ok := b.index[string(key)]; ok {
+		b.items[i].Entry = val
+	} else {
+		b.index[string(key)] = len(b.items)
+		b.items = append(b.items, KeyEntry{Key: append([]byte(nil), key...), Entry: val})
+	}
+	full := len(b.items) >= c.maxBatch
+	if full {
+		c.pending = nil
+	}
+	c.mu.Unlock()
+
+	if full && b.timer.Stop() {
+		c.flush(b)
+	}
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush writes b, it's called by the timer or by whoever stops it, the
+// puts go to a new batch from then on.
+func (c *coalesceDB) flush(b *putBatch) {
+	c.mu.Lock()
+	if c.pending == b {
+		c.pending = nil
+	}
+	c.mu.Unlock()
+
+	metric.CoalesceBatchSize.Observe(float64(len(b.items)))
+	b.err = c.DB.BatchPut(context.Background(), b.items)
+	close(b.done)
+}
+
+// Close writes the batch pending before closing the db.
+func (c *coalesceDB) Close() error {
+	c.mu.Lock()
+	b := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if b != nil && b.timer.Stop() {
+		c.flush(b)
+	}
+	return c.DB.Close()
+}
+
+// Scan keeps the Scanner of the db.
+func (c *coalesceDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return scan(ctx, c.DB, start, end, limit, option, fn)
+}
+
+func (c *coalesceDB) Unwrap() DB {
+	return c.DB
+}
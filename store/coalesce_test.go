@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// batchCountDB keeps the batches written, err fails them.
+type batchCountDB struct {
+	stateDB
+	mu      sync.Mutex
+	batches [][]KeyEntry
+	err     error
+}
+
+func (d *batchCountDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.batches = append(d.batches, items)
+	return d.err
+}
+
+func TestCoalesceDB(t *testing.T) {
+	db := &batchCountDB{}
+	c := newCoalesceDB(db, &config.Coalesce{Enable: true, Window: &config.Duration{Duration: 20 * time.Millisecond}, MaxBatch: 4})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, c.Put(ctx, []byte(fmt.Sprintf("k%d", i)), []byte("v")))
+		}(i)
+	}
+	wg.Wait()
+	sizes := make([]int, 0)
+	total := 0
+	for _, b := range db.batches {
+		sizes = append(sizes, len(b))
+		total += len(b)
+	}
+	assert.Equal(t, 10, total)
+	assert.Equal(t, 3, len(db.batches), sizes)
+
+	// the later put of a key wins
+	db.batches = nil
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, c.Put(ctx, []byte("a"), []byte("1")))
+	}()
+	time.Sleep(5 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, c.Put(ctx, []byte("a"), []byte("2")))
+	}()
+	wg.Wait()
+	assert.Equal(t, [][]KeyEntry{{{Key: []byte("a"), Entry: []byte("2")}}}, db.batches)
+
+	db.err = errors.New("commit failed")
+	assert.Equal(t, db.err, c.Put(ctx, []byte("b"), []byte("1")))
+
+	// a put gives up with its ctx, the batch is still written
+	db.err = nil
+	db.batches = nil
+	cctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, c.Put(cctx, []byte("c"), []byte("1")))
+	assert.Nil(t, c.Close())
+	assert.Equal(t, 1, len(db.batches))
+}
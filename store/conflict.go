@@ -0,0 +1,24 @@
+package store
+
+// ConflictError is returned by CheckAndPut when the check fails against the
+// stored value, it carries the value the check saw, so the client can retry
+// without another get. ReadTs is when the value was read, the start ts of
+// the tikv transaction or the etcd revision, not the commit ts of the value,
+// 0 if the db has none.
+type ConflictError struct {
+	Err    error
+	Value  []byte
+	ReadTs uint64
+}
+
+func NewConflictError(err error, value []byte, readTs uint64) *ConflictError {
+	return &ConflictError{Err: err, Value: value, ReadTs: readTs}
+}
+
+func (e *ConflictError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
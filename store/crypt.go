@@ -0,0 +1,357 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigKMSName keeps the keys in encryption.key.
+const ConfigKMSName = "config"
+
+const (
+	maxRewrites    = 16
+	rewriteTimeout = 10 * time.Second
+)
+
+// KeyProvider returns the data keys by id, a KMS usually unwraps them once
+// and keeps them in memory.
+type KeyProvider interface {
+	Key(id string) ([]byte, error)
+}
+
+type KMSDriver interface {
+	Name() string
+	Open(conf *config.Config) (KeyProvider, error)
+}
+
+var kDrivers = make(map[string]KMSDriver)
+
+func RegisterKMS(driver KMSDriver) {
+	name := driver.Name()
+	if _, ok := kDrivers[name]; ok {
+		panic(fmt.Errorf("kms %s is already registered", name))
+	}
+
+	kDrivers[name] = driver
+}
+
+type configKeys map[string][]byte
+
+func newConfigKeys(keys []config.EncryptionKey) (configKeys, error) {
+	k := make(configKeys, len(keys))
+	for _, key := range keys {
+		b, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil {
+			return nil, fmt.Errorf("key %s, %s", key.ID, err)
+		}
+		k[key.ID] = b
+	}
+	return k, nil
+}
+
+func (k configKeys) Key(id string) ([]byte, error) {
+	key, ok := k[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return key, nil
+}
+
+func openKMS(conf *config.Config) (KeyProvider, error) {
+	if conf.Encryption.KMS == ConfigKMSName {
+		return newConfigKeys(conf.Encryption.Keys)
+	}
+	kDriver, ok := kDrivers[conf.Encryption.KMS]
+	if !ok {
+		return nil, xerror.ErrKMSNotRegister
+	}
+	return kDriver.Open(conf)
+}
+
+type dataKey struct {
+	aead cipher.AEAD
+	raw  []byte
+}
+
+// keyring seals by the primary key and opens by any key of the KMS, the
+// values and the json fields share it.
+type keyring struct {
+	keys    KeyProvider
+	primary string
+	mu      sync.RWMutex
+	cached  map[string]*dataKey
+}
+
+// newKeyring fails at the start rather than on the first write.
+func newKeyring(keys KeyProvider, primary string) (*keyring, error) {
+	k := &keyring{keys: keys, primary: primary, cached: make(map[string]*dataKey)}
+	if _, err := k.dataKey(primary); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func openKeyring(conf *config.Config) (*keyring, error) {
+	keys, err := openKMS(conf)
+	if err != nil {
+		return nil, err
+	}
+	return newKeyring(keys, conf.Encryption.Primary)
+}
+
+func (k *keyring) dataKey(id string) (*dataKey, error) {
+	k.mu.RLock()
+	d, ok := k.cached[id]
+	k.mu.RUnlock()
+	if ok {
+		return d, nil
+	}
+	raw, err := k.keys.Key(id)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("key %s, %s", id, err)
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	d = &dataKey{aead: a, raw: raw}
+	k.mu.Lock()
+	k.cached[id] = d
+	k.mu.Unlock()
+	return d, nil
+}
+
+func (k *keyring) aead(id string) (cipher.AEAD, error) {
+	d, err := k.dataKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.aead, nil
+}
+
+// cryptMagic starts a sealed value: magic | id length | key id | nonce |
+// ciphertext, the key of the record is the additional data, so a value
+// can't be moved to another key.
+var cryptMagic = []byte("\x00tirest.enc\x00")
+
+// cryptDB seals every value by the primary key, a value without the magic
+// was written before encryption was enabled and is read as is.
+type cryptDB struct {
+	DB
+	*keyring
+	rewrite  bool
+	rewrites chan struct{}
+	log      *logrus.Entry
+}
+
+func newCryptDB(db DB, keys *keyring, conf *config.Encryption) *cryptDB {
+	return &cryptDB{
+		DB:       db,
+		keyring:  keys,
+		rewrite:  conf.Rewrite,
+		rewrites: make(chan struct{}, maxRewrites),
+		log:      logrus.WithFields(logrus.Fields{"worker": "crypt"}),
+	}
+}
+
+func (d *cryptDB) Unwrap() DB {
+	return d.DB
+}
+
+// seal keeps an empty value empty, it deletes the key.
+func (d *cryptDB) seal(key, val []byte) ([]byte, error) {
+	if len(val) == 0 {
+		return val, nil
+	}
+	a, err := d.aead(d.primary)
+	if err != nil {
+		return nil, err
+	}
+	n := len(cryptMagic) + 1 + len(d.primary)
+	b := make([]byte, n+a.NonceSize(), n+a.NonceSize()+len(val)+a.Overhead())
+	copy(b, cryptMagic)
+	b[len(cryptMagic)] = byte(len(d.primary))
+	copy(b[len(cryptMagic)+1:], d.primary)
+	if _, err := rand.Read(b[n:]); err != nil {
+		return nil, err
+	}
+	return a.Seal(b, b[n:], val, key), nil
+}
+
+// open also returns the key id, "" for a value which isn't sealed.
+func (d *cryptDB) open(key, val []byte) ([]byte, string, error) {
+	if !bytes.HasPrefix(val, cryptMagic) {
+		return val, "", nil
+	}
+	b := val[len(cryptMagic):]
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, "", xerror.ErrDecryptFailed
+	}
+	id := string(b[1 : 1+b[0]])
+	b = b[1+b[0]:]
+	a, err := d.aead(id)
+	if err != nil {
+		return nil, id, xerror.ErrDecryptFailed.Wrap(err)
+	}
+	if len(b) < a.NonceSize() {
+		return nil, id, xerror.ErrDecryptFailed
+	}
+	plain, err := a.Open(nil, b[:a.NonceSize()], b[a.NonceSize():], key)
+	if err != nil {
+		return nil, id, xerror.ErrDecryptFailed.Wrap(err)
+	}
+	return plain, id, nil
+}
+
+// read opens val and seals it again by the primary key in the background.
+func (d *cryptDB) read(key, val []byte) ([]byte, error) {
+	plain, id, err := d.open(key, val)
+	if err == nil && d.rewrite && id != d.primary && len(val) > 0 {
+		d.rewriteValue(key, val)
+	}
+	return plain, err
+}
+
+// rewriteValue is skipped while maxRewrites are running, the next read
+// rewrites the value, and it fails if the key was written meanwhile.
+func (d *cryptDB) rewriteValue(key, val []byte) {
+	select {
+	case d.rewrites <- struct{}{}:
+	default:
+		return
+	}
+	key = append([]byte(nil), key...)
+	val = append([]byte(nil), val...)
+	go func() {
+		defer func() { <-d.rewrites }()
+		ctx, cancel := context.WithTimeout(WithPriority(context.Background(), PriorityBackground), rewriteTimeout)
+		defer cancel()
+		err := d.DB.CheckAndPut(ctx, key, nil, nil, CheckOption{
+			Check: func(_, _, existVal []byte) ([]byte, error) {
+				if !bytes.Equal(existVal, val) {
+					return nil, xerror.ErrCheckAndSetFailed
+				}
+				plain, _, err := d.open(key, val)
+				if err != nil {
+					return nil, err
+				}
+				return d.seal(key, plain)
+			},
+		})
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			return
+		} else if err != nil {
+			d.log.Warnf("rewrite key %s failed, %s", key, err)
+			return
+		}
+		metric.ValueRewrite.Inc()
+	}()
+}
+
+func (d *cryptDB) Put(ctx context.Context, key, val []byte) error {
+	sealed, err := d.seal(key, val)
+	if err != nil {
+		return err
+	}
+	return d.DB.Put(ctx, key, sealed)
+}
+
+func (d *cryptDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	sealed := make([]KeyEntry, len(items))
+	for i, item := range items {
+		entry, err := d.seal(item.Key, item.Entry)
+		if err != nil {
+			return err
+		}
+		sealed[i] = KeyEntry{Key: item.Key, Entry: entry}
+	}
+	return d.DB.BatchPut(ctx, sealed)
+}
+
+func (d *cryptDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	check := option.Check
+	// the write seals the value by the primary key, no rewrite
+	option.Check = func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		existVal, _, err := d.open(key, existVal)
+		if err != nil {
+			return nil, err
+		}
+		val := newVal
+		if check != nil {
+			val, err = check(oldVal, newVal, existVal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return d.seal(key, val)
+	}
+	err := d.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		conflict.Value, _, _ = d.open(key, conflict.Value)
+	}
+	return err
+}
+
+func (d *cryptDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	v, err := d.DB.Get(ctx, key, option)
+	if err != nil {
+		return v, err
+	}
+	// a secondary value is read from the secondary key
+	sealedKey := key
+	if v.Secondary {
+		sealedKey = option.Secondary
+	}
+	v.Value, err = d.read(sealedKey, v.Value)
+	if err != nil {
+		return NoValue, err
+	}
+	return v, nil
+}
+
+func (d *cryptDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	return d.DB.List(ctx, start, end, limit, d.listOption(option))
+}
+
+func (d *cryptDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return scan(ctx, d.DB, start, end, limit, d.listOption(option), fn)
+}
+
+// listOption decrypts the values before option.Item.
+func (d *cryptDB) listOption(option ListOption) ListOption {
+	item := option.Item
+	option.Item = func(key, val []byte) ([]byte, []byte, error) {
+		if len(val) > 0 {
+			var err error
+			val, err = d.read(key, val)
+			if err != nil {
+				d.log.Errorf("list key %s skipped, %s", key, err)
+				return nil, nil, err
+			}
+		}
+		if item == nil {
+			return key, val, nil
+		}
+		return item(key, val)
+	}
+	return option
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitRewrites takes every slot, so the rewrites running are done.
+func waitRewrites(d *cryptDB) {
+	for i := 0; i < maxRewrites; i++ {
+		d.rewrites <- struct{}{}
+	}
+	for i := 0; i < maxRewrites; i++ {
+		<-d.rewrites
+	}
+}
+
+func TestCryptDB(t *testing.T) {
+	conf := &config.Encryption{Enable: true, KMS: ConfigKMSName, Primary: "k1", Rewrite: true,
+		Keys: []config.EncryptionKey{
+			{ID: "k1", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))},
+			{ID: "k2", Key: base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 16))},
+		}}
+	provider, err := newConfigKeys(conf.Keys)
+	assert.Nil(t, err)
+	keys, err := newKeyring(provider, conf.Primary)
+	assert.Nil(t, err)
+	inner := &casDB{memDB{kv: map[string]string{}}}
+	db := newCryptDB(inner, keys, conf)
+	ctx := context.Background()
+
+	assert.Nil(t, db.Put(ctx, []byte("a"), []byte("secret")))
+	assert.True(t, bytes.HasPrefix([]byte(inner.kv["a"]), cryptMagic))
+	assert.NotContains(t, inner.kv["a"], "secret")
+	v, err := db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret"), v.Value)
+	items, err := db.List(ctx, nil, nil, 0, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: "a", Value: "secret"}}, items)
+
+	// the conflict has the value opened
+	err = db.CheckAndPut(ctx, []byte("a"), []byte("x"), []byte("y"), CheckOption{Check: equalCheck})
+	var conflict *ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, []byte("secret"), conflict.Value)
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("a"), []byte("secret"), []byte("2"), CheckOption{Check: equalCheck}))
+
+	// a value can't be moved to another key
+	inner.kv["b"] = inner.kv["a"]
+	_, err = db.Get(ctx, []byte("b"), GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrDecryptFailed))
+
+	// values of another key and values written before are sealed again
+	db.primary = "k2"
+	assert.Nil(t, db.Put(ctx, []byte("c"), []byte("3")))
+	db.primary = "k1"
+	inner.kv["d"] = "plain"
+	for _, key := range []string{"c", "d"} {
+		_, err = db.Get(ctx, []byte(key), GetOption{})
+		assert.Nil(t, err)
+		waitRewrites(db)
+		_, id, err := db.open([]byte(key), []byte(inner.kv[key]))
+		assert.Nil(t, err)
+		assert.Equal(t, "k1", id)
+	}
+	v, err = db.Get(ctx, []byte("d"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain"), v.Value)
+
+	_, err = newKeyring(provider, "k3")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// dedupCheck refuses a new value equal to the value stored with
+// ErrAlreadyExists, check sees the others. A delete isn't deduplicated.
+func dedupCheck(check CheckFunc) CheckFunc {
+	return func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		if len(newVal) > 0 && bytes.Equal(newVal, existVal) {
+			metric.WriteDedup.Inc()
+			return nil, xerror.ErrAlreadyExists
+		}
+		if check == nil {
+			return newVal, nil
+		}
+		return check(oldVal, newVal, existVal)
+	}
+}
+
+// unchanged reads key from TiKV, not from the cache which may miss a write
+// of another node, a failed read is taken as changed.
+func (s *Store) unchanged(ctx context.Context, key, val []byte) bool {
+	if !s.conf.Server.DedupWrites || len(val) == 0 {
+		return false
+	}
+	bctx, cancel := s.budget(ctx, EndpointGet)
+	v, err := s.db.Get(bctx, key, GetOption{})
+	cancel()
+	if err != nil || !bytes.Equal(v.Value, val) {
+		return false
+	}
+	metric.WriteDedup.Inc()
+	s.log.Debugf("unsafe put %s skipped, unchanged", key)
+	return true
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupWrites(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Server.DedupWrites = true
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+	skipped := testutil.ToFloat64(metric.WriteDedup)
+
+	assert.Nil(t, cas(s, "a", "", "1"))
+	assert.True(t, errors.Is(cas(s, "a", "1", "1"), xerror.ErrAlreadyExists))
+	assert.Nil(t, cas(s, "a", "1", "2"))
+	// a delete goes through the check
+	assert.Nil(t, cas(s, "a", "2", ""))
+
+	assert.Nil(t, s.UnsafePut(ctx, []byte("b"), []byte("1")))
+	assert.Equal(t, xerror.ErrAlreadyExists, s.UnsafePut(ctx, []byte("b"), []byte("1")))
+	assert.Nil(t, s.UnsafePut(ctx, []byte("b"), []byte("2")))
+	assert.Equal(t, map[string]string{"b": "2"}, db.kv)
+	assert.Equal(t, skipped+2, testutil.ToFloat64(metric.WriteDedup))
+
+	conf.Server.DedupWrites = false
+	assert.Nil(t, s.UnsafePut(ctx, []byte("b"), []byte("2")))
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+// jsonObject parses b as a json object, keeping the fields as they are.
+func jsonObject(b []byte) (map[string]json.RawMessage, bool) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '{' {
+		return nil, false
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// canonical is b with the keys sorted and without spaces, the numbers are
+// kept as they are.
+func canonical(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// diffObject is the merge patch from o to n, null for a field removed.
+func diffObject(o, n map[string]json.RawMessage) map[string]json.RawMessage {
+	patch := make(map[string]json.RawMessage)
+	for k := range o {
+		if _, ok := n[k]; !ok {
+			patch[k] = json.RawMessage("null")
+		}
+	}
+	for k, nv := range n {
+		ov, ok := o[k]
+		if ok && bytes.Equal(ov, nv) {
+			continue
+		}
+		oo, oOK := jsonObject(ov)
+		no, nOK := jsonObject(nv)
+		if ok && oOK && nOK {
+			if sub := diffObject(oo, no); len(sub) > 0 {
+				b, _ := json.Marshal(sub)
+				patch[k] = b
+			}
+			continue
+		}
+		patch[k] = nv
+	}
+	return patch
+}
+
+// diffJSON is the json merge patch (RFC 7386) from oldVal to newVal, false
+// unless both are json objects, the patch is smaller than newVal, and it
+// gives newVal back, a null set in newVal can't be patched.
+func diffJSON(oldVal, newVal []byte) ([]byte, bool) {
+	o, ok := jsonObject(oldVal)
+	if !ok {
+		return nil, false
+	}
+	n, ok := jsonObject(newVal)
+	if !ok {
+		return nil, false
+	}
+	patch, err := json.Marshal(diffObject(o, n))
+	if err != nil || len(patch) >= len(newVal) {
+		return nil, false
+	}
+	merged, err := mergePatch(oldVal, patch)
+	if err != nil {
+		return nil, false
+	}
+	want, err := canonical(newVal)
+	if err != nil {
+		return nil, false
+	}
+	got, err := canonical(merged)
+	if err != nil || !bytes.Equal(got, want) {
+		return nil, false
+	}
+	return patch, true
+}
+
+// mergePatch applies the json merge patch to target, a target which isn't
+// a json object is taken as an empty one.
+func mergePatch(target, patch []byte) ([]byte, error) {
+	var p map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, err
+	}
+	t, ok := jsonObject(target)
+	if !ok {
+		t = make(map[string]json.RawMessage)
+	}
+	for k, v := range p {
+		if bytes.Equal(bytes.TrimSpace(v), []byte("null")) {
+			delete(t, k)
+			continue
+		}
+		if _, ok := jsonObject(v); ok {
+			merged, err := mergePatch(t[k], v)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = merged
+			continue
+		}
+		t[k] = v
+	}
+	return json.Marshal(t)
+}
+
+// delta sends a change of a json object as its patch with connector.delta,
+// after the event rules which see the values.
+func (s *Store) delta(msg KeyEntry) KeyEntry {
+	if !s.conf.Connector.Delta || len(msg.Key) == 0 || msg.Key[0] == AuditType {
+		return msg
+	}
+	l := Log{}
+	if err := json.Unmarshal(msg.Entry, &l); err != nil || l.Patch != nil {
+		return msg
+	}
+	patch, ok := diffJSON([]byte(l.Old), []byte(l.New))
+	if !ok {
+		return msg
+	}
+	l.Old, l.New, l.Patch = "", "", patch
+	entry, err := json.Marshal(l)
+	if err != nil {
+		return msg
+	}
+	return KeyEntry{Key: msg.Key, Entry: entry}
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/hlc"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffJSON(t *testing.T) {
+	doc := `{"name":"a","size":12345678901234567890,"meta":{"tag":"x","owner":"bob","notes":"a long note which stays"}}`
+	tests := []struct {
+		old, new string
+		patch    string
+	}{
+		{doc, `{"name":"b","size":12345678901234567890,"meta":{"tag":"x","owner":"bob","notes":"a long note which stays"}}`,
+			`{"name":"b"}`},
+		{doc, `{"name":"a","size":12345678901234567890,"meta":{"tag":"y","notes":"a long note which stays"}}`,
+			`{"meta":{"owner":null,"tag":"y"}}`},
+		// a null set can't be patched
+		{doc, `{"name":null,"size":12345678901234567890,"meta":{"tag":"x","owner":"bob","notes":"a long note which stays"}}`, ""},
+		// not smaller than the value
+		{`{"a":1}`, `{"b":2}`, ""},
+		{`[1]`, `[2]`, ""},
+		{"", doc, ""},
+	}
+	for _, tt := range tests {
+		patch, ok := diffJSON([]byte(tt.old), []byte(tt.new))
+		assert.Equal(t, tt.patch != "", ok, tt.new)
+		if !ok {
+			continue
+		}
+		assert.Equal(t, tt.patch, string(patch))
+		merged, err := mergePatch([]byte(tt.old), patch)
+		assert.Nil(t, err)
+		want, _ := canonical([]byte(tt.new))
+		got, _ := canonical(merged)
+		assert.Equal(t, string(want), string(got))
+	}
+}
+
+func TestDeltaApply(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Server.HLC = true
+	conf.Connector.Delta = true
+	clock := hlc.New(0)
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: newStampDB(db, clock), clock: clock, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	old := `{"name":"a","body":"a body long enough to be worth a patch"}`
+	entry, _ := json.Marshal(Log{Old: old, New: `{"name":"b","body":"a body long enough to be worth a patch"}`, Ts: 7})
+	msg := s.delta(KeyEntry{Key: []byte("a"), Entry: entry})
+	l := Log{}
+	assert.Nil(t, json.Unmarshal(msg.Entry, &l))
+	assert.Equal(t, Log{Ts: 7, Patch: json.RawMessage(`{"name":"b"}`)}, l)
+
+	assert.Nil(t, s.Apply(ctx, []byte("a"), Log{New: old, Ts: clock.Now()}))
+	assert.Nil(t, s.Apply(ctx, []byte("a"), Log{Patch: l.Patch, Ts: clock.Now()}))
+	v, err := s.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"name":"b","body":"a body long enough to be worth a patch"}`, string(v.Value))
+}
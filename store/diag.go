@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+type RegionPeer struct {
+	ID      uint64 `json:"id"`
+	StoreID uint64 `json:"store_id"`
+	Address string `json:"address"`
+	Down    bool   `json:"down,omitempty"`
+	Pending bool   `json:"pending,omitempty"`
+}
+
+// KeyRegion is the region of a key as PD sees it.
+type KeyRegion struct {
+	ID      uint64
+	Version uint64
+	ConfVer uint64
+	Start   []byte
+	End     []byte
+	Leader  *RegionPeer
+	Peers   []RegionPeer
+}
+
+// DiagStep is a step of a probe read, Detail has what the client collected.
+type DiagStep struct {
+	Name     string
+	Duration time.Duration
+	Detail   string
+	Error    string
+}
+
+// KeyDiagnosis is where a key lives and the steps of a read of it, the
+// steps after a failed one are missing.
+type KeyDiagnosis struct {
+	Region *KeyRegion
+	Steps  []DiagStep
+	Found  bool
+	Size   int
+}
+
+// KeyDiagnoser is implemented by a DB which can locate a key and time a
+// read of it step by step.
+type KeyDiagnoser interface {
+	DiagnoseKey(ctx context.Context, key []byte) (*KeyDiagnosis, error)
+}
+
+// DiagnoseKey reads key from the driver of [store], past the cache and the
+// breaker, as stored with its shard under a [[salt]] prefix. It may return the steps done so far along with an error.
+func (s *Store) DiagnoseKey(ctx context.Context, key []byte) (*KeyDiagnosis, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	diagnoser, ok := baseDB(s.db).(KeyDiagnoser)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	diag, err := diagnoser.DiagnoseKey(ctx, s.salts.key(key))
+	if err != nil {
+		s.log.Errorf("diagnose %q failed, %s", key, err)
+		return diag, contextError(ctx, err)
+	}
+	return diag, nil
+}
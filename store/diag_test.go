@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type diagDB struct {
+	memDB
+}
+
+func (d *diagDB) DiagnoseKey(ctx context.Context, key []byte) (*KeyDiagnosis, error) {
+	diag := &KeyDiagnosis{Region: &KeyRegion{ID: 2}, Steps: []DiagStep{{Name: "get", Duration: time.Millisecond}}}
+	v, ok := d.kv[string(key)]
+	diag.Found, diag.Size = ok, len(v)
+	return diag, nil
+}
+
+func TestDiagnoseKey(t *testing.T) {
+	ctx := context.Background()
+	s := &Store{state: int32(StateReady), log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	s.db = &casDB{memDB{kv: map[string]string{}}}
+	_, err := s.DiagnoseKey(ctx, []byte("a"))
+	assert.Equal(t, xerror.ErrNotSupported, err)
+
+	// the probe read goes past the decorators
+	db := &diagDB{memDB{kv: map[string]string{"a": "12"}}}
+	s.db = newFaultDB(db, &config.Fault{Rules: []config.FaultRule{{Target: FaultDB, Probability: 1, Error: "internal"}}})
+	diag, err := s.DiagnoseKey(ctx, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), diag.Region.ID)
+	assert.True(t, diag.Found)
+	assert.Equal(t, 2, diag.Size)
+}
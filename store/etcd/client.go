@@ -0,0 +1,305 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+)
+
+const (
+	DBName = "etcd"
+	Scheme = "etcd://"
+
+	// casRetry is how many times a CAS is checked again after another
+	// write changed the key between the read and the txn.
+	casRetry = 3
+
+	// maxTxnOps is the default max-txn-ops of etcd, a batch is committed
+	// in txns of up to this many operations.
+	maxTxnOps = 128
+)
+
+// Etcd maps the DB onto the etcd KV, a CAS is a txn on the mod revision of
+// the key, so values are limited by the request size of etcd.
+type Etcd struct {
+	client *clientv3.Client
+	conf   *config.Config
+	log    *logrus.Entry
+}
+
+type Driver struct {
+}
+
+func init() {
+	store.RegisterDB(Driver{})
+}
+
+func (d Driver) Name() string {
+	return DBName
+}
+
+// Endpoints returns the addresses of path, etcd://host1:2379,host2:2379.
+func Endpoints(path string) ([]string, error) {
+	if !strings.HasPrefix(path, Scheme) || len(path) == len(Scheme) {
+		return nil, errors.New("path needs etcd://host:port[,host:port]")
+	}
+	return strings.Split(path[len(Scheme):], ","), nil
+}
+
+func (d Driver) Open(conf *config.Config) (store.DB, error) {
+	endpoints, err := Endpoints(conf.Store.Path)
+	if err != nil {
+		return nil, err
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: conf.Store.ReadTimeout.Duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Etcd{
+		client: client,
+		conf:   conf,
+		log:    logrus.WithFields(logrus.Fields{"worker": DBName}),
+	}, nil
+}
+
+func (e *Etcd) Close() error {
+	return e.client.Close()
+}
+
+func (e *Etcd) Get(ctx context.Context, key []byte, option store.GetOption) (store.Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.ReadTimeout.Duration)
+	defer cancel()
+	var opts []clientv3.OpOption
+	if option.ReplicaRead {
+		opts = append(opts, clientv3.WithSerializable())
+	}
+	resp, err := e.client.Get(ctx, utils.B2S(key), opts...)
+	secondary := false
+	if err == nil && len(resp.Kvs) == 0 && option.Secondary != nil {
+		// the secondary is read at the revision of the key, in the same snapshot
+		secondary = true
+		opts = append(opts, clientv3.WithRev(resp.Header.Revision))
+		resp, err = e.client.Get(ctx, utils.B2S(option.Secondary), opts...)
+	}
+	if err != nil {
+		e.log.Errorf("get %s failed %s", key, err)
+		return store.NoValue, wrapError(xerror.ErrGetKVFailed, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return store.NoValue, xerror.ErrNotExists
+	}
+	return store.Value{Secondary: secondary, Value: resp.Kvs[0].Value}, nil
+}
+
+// rangeEnd maps an empty end to every key after start.
+func rangeEnd(end []byte) string {
+	if len(end) == 0 {
+		return "\x00"
+	}
+	return utils.B2S(end)
+}
+
+// List reads pages of limit keys until limit items are left by option.Item,
+// or the range is done.
+func (e *Etcd) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.ListTimeout.Duration)
+	defer cancel()
+	opts := []clientv3.OpOption{clientv3.WithLimit(int64(limit))}
+	if option.ReplicaRead {
+		opts = append(opts, clientv3.WithSerializable())
+	}
+	if option.KeyOnly {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+	if option.Reverse {
+		opts = append(opts, clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
+	} else {
+		opts = append(opts, clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	}
+
+	s := string(start)
+	en := rangeEnd(end)
+	ret := make([]store.KeyValue, 0)
+	for {
+		resp, err := e.client.Get(ctx, s, append(opts, clientv3.WithRange(en))...)
+		if err != nil {
+			e.log.Errorf("list (%s-%s) failed %s", start, end, err)
+			return nil, wrapError(xerror.ErrListKVFailed, err)
+		}
+		for _, kv := range resp.Kvs {
+			k, v := kv.Key, kv.Value
+			if option.Item != nil {
+				k, v, err = option.Item(k, v)
+				if err != nil {
+					e.log.Warnf("list (%s-%s) key %s, err %s", start, end, k, err)
+					continue
+				}
+			}
+			ret = append(ret, store.KeyValue{Key: utils.B2S(k), Value: utils.B2S(v)})
+			if limit > 0 && len(ret) >= limit {
+				return ret, nil
+			}
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			return ret, nil
+		}
+		last := string(resp.Kvs[len(resp.Kvs)-1].Key)
+		if option.Reverse {
+			en = last
+		} else {
+			s = last + "\x00"
+		}
+	}
+}
+
+func (e *Etcd) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option store.CheckOption) error {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.WriteTimeout.Duration)
+	defer cancel()
+	k := string(key)
+	for i := 0; ; i++ {
+		resp, err := e.client.Get(ctx, k)
+		if err != nil {
+			e.log.Errorf("cas %s get failed %s", key, err)
+			return wrapError(xerror.ErrGetKVFailed, err)
+		}
+		var existVal []byte
+		var rev int64
+		if len(resp.Kvs) > 0 {
+			existVal = resp.Kvs[0].Value
+			rev = resp.Kvs[0].ModRevision
+		}
+
+		val := newVal
+		if option.Check != nil {
+			val, err = option.Check(oldVal, newVal, existVal)
+			if errors.Is(err, xerror.ErrCheckAndSetFailed) {
+				return store.NewConflictError(err, existVal, uint64(resp.Header.Revision))
+			} else if err != nil {
+				return err
+			}
+		}
+
+		op := clientv3.OpPut(k, string(val))
+		if len(val) == 0 {
+			op = clientv3.OpDelete(k)
+		}
+		txn, err := e.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(k), "=", rev)).
+			Then(op).
+			Else(clientv3.OpGet(k)).
+			Commit()
+		if err != nil {
+			e.log.Errorf("cas %s commit failed %s", key, err)
+			return wrapError(xerror.ErrCheckAndSetFailed, err)
+		}
+		if txn.Succeeded {
+			return nil
+		}
+		if i >= casRetry {
+			e.log.Errorf("cas %s changed by others %d times", key, i+1)
+			// the else branch read the key as it was when the txn failed
+			existVal = nil
+			if kvs := txn.Responses[0].GetResponseRange().Kvs; len(kvs) > 0 {
+				existVal = kvs[0].Value
+			}
+			return store.NewConflictError(xerror.ErrCheckAndSetFailed, existVal, uint64(txn.Header.Revision))
+		}
+	}
+}
+
+func (e *Etcd) Put(ctx context.Context, key, val []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.WriteTimeout.Duration)
+	defer cancel()
+	var err error
+	if len(val) == 0 {
+		_, err = e.client.Delete(ctx, string(key))
+	} else {
+		_, err = e.client.Put(ctx, string(key), string(val))
+	}
+	if err != nil {
+		e.log.Errorf("put %s failed %s", key, err)
+		return wrapError(xerror.ErrSetKVFailed, err)
+	}
+	return nil
+}
+
+// BatchPut writes the items in txns of maxTxnOps, etcd limits a txn to
+// max-txn-ops operations, so a batch over it is not atomic.
+func (e *Etcd) BatchPut(ctx context.Context, items []store.KeyEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.BatchPutTimeout.Duration)
+	defer cancel()
+	for len(items) > 0 {
+		n := len(items)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		ops := make([]clientv3.Op, 0, n)
+		for _, item := range items[:n] {
+			if len(item.Entry) == 0 {
+				ops = append(ops, clientv3.OpDelete(string(item.Key)))
+			} else {
+				ops = append(ops, clientv3.OpPut(string(item.Key), string(item.Entry)))
+			}
+		}
+		_, err := e.client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			e.log.Errorf("batch put commit %d items failed %s", n, err)
+			return wrapError(xerror.ErrCommitKVFailed, err)
+		}
+		items = items[n:]
+	}
+	return nil
+}
+
+// BatchDelete deletes up to limit keys from start, the keys are listed
+// first and the range up to the last one is deleted.
+func (e *Etcd) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.BatchDeleteTimeout.Duration)
+	defer cancel()
+	opts := []clientv3.OpOption{
+		clientv3.WithRange(rangeEnd(end)),
+		clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+	}
+	if limit > 0 {
+		opts = append(opts, clientv3.WithLimit(int64(limit)))
+	}
+	resp, err := e.client.Get(ctx, string(start), opts...)
+	if err != nil {
+		e.log.Errorf("list (%s-%s) failed %s", start, end, err)
+		return nil, 0, wrapError(xerror.ErrListKVFailed, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	lastKey := resp.Kvs[len(resp.Kvs)-1].Key
+	e.log.Infof("start delete %s, (%s-%s)", start, lastKey, end)
+	del, err := e.client.Delete(ctx, string(start), clientv3.WithRange(string(lastKey)+"\x00"))
+	if err != nil {
+		return nil, 0, wrapError(xerror.ErrCommitKVFailed, err)
+	}
+	return lastKey, int(del.Deleted), nil
+}
+
+// UnsafeDelete deletes the range at once, there is no GC to wait for.
+func (e *Etcd) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, e.conf.Store.BatchDeleteTimeout.Duration)
+	defer cancel()
+	_, err := e.client.Delete(ctx, string(start), clientv3.WithRange(rangeEnd(end)))
+	if err != nil {
+		e.log.Errorf("unsafe delete (%s-%s) failed %s", start, end, err)
+		return wrapError(xerror.ErrCommitKVFailed, err)
+	}
+	e.log.Infof("unsafe deleted (%s-%s)", start, end)
+	return nil
+}
@@ -0,0 +1,138 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/embed"
+)
+
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	u, _ := url.Parse("http://" + l.Addr().String())
+	return *u
+}
+
+func startEtcd(t *testing.T) (*embed.Etcd, string) {
+	dir, err := ioutil.TempDir("", "etcd")
+	assert.Nil(t, err)
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	client, peer := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{client}, []url.URL{client}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+	return e, dir
+}
+
+func TestEtcd(t *testing.T) {
+	server, dir := startEtcd(t)
+	defer os.RemoveAll(dir)
+	defer server.Close()
+
+	conf := config.DefaultConfig()
+	conf.Store.Name = DBName
+	conf.Store.Path = Scheme + server.Config().LCUrls[0].Host
+	db, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put(ctx, []byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	v, err := db.Get(ctx, []byte("k1"), store.GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", string(v.Value))
+	v, err = db.Get(ctx, []byte("x"), store.GetOption{Secondary: []byte("k2")})
+	assert.Nil(t, err)
+	assert.Equal(t, store.Value{Secondary: true, Value: []byte("v2")}, v)
+	_, err = db.Get(ctx, []byte("x"), store.GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrNotExists))
+
+	// every other item is left out, the pages go on until the limit
+	skip := func(k, v []byte) ([]byte, []byte, error) {
+		if k[1]%2 == 1 {
+			return nil, nil, errors.New("skip")
+		}
+		return k, v, nil
+	}
+	items, err := db.List(ctx, []byte("k"), []byte("l"), 2, store.ListOption{Item: skip})
+	assert.Nil(t, err)
+	assert.Equal(t, []store.KeyValue{{Key: "k0", Value: "v0"}, {Key: "k2", Value: "v2"}}, items)
+	items, err = db.List(ctx, []byte("k"), []byte("k4"), 2, store.ListOption{Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []store.KeyValue{{Key: "k3", Value: "v3"}, {Key: "k2", Value: "v2"}}, items)
+
+	check := func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		if string(oldVal) != string(existVal) {
+			return nil, xerror.ErrCheckAndSetFailed
+		}
+		return newVal, nil
+	}
+	err = db.CheckAndPut(ctx, []byte("k1"), []byte("v0"), []byte("n1"), store.CheckOption{Check: check})
+	var conflict *store.ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, "v1", string(conflict.Value))
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("k1"), []byte("v1"), []byte("n1"), store.CheckOption{Check: check}))
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("k9"), nil, []byte("v9"), store.CheckOption{Check: check}))
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("k9"), []byte("v9"), nil, store.CheckOption{Check: check}))
+	_, err = db.Get(ctx, []byte("k9"), store.GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrNotExists))
+
+	assert.Nil(t, db.BatchPut(ctx, []store.KeyEntry{{Key: []byte("k5"), Entry: []byte("v5")}, {Key: []byte("k0")}}))
+	lastKey, deleted, err := db.BatchDelete(ctx, []byte("k"), []byte("l"), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "k2", string(lastKey))
+	assert.Equal(t, 2, deleted)
+
+	// a batch over max-txn-ops is committed in chunks
+	batch := make([]store.KeyEntry, 300)
+	for i := range batch {
+		batch[i] = store.KeyEntry{Key: []byte(fmt.Sprintf("kb%03d", i)), Entry: []byte("v")}
+	}
+	assert.Nil(t, db.BatchPut(ctx, batch))
+	items, err = db.List(ctx, []byte("kb"), []byte("kc"), 1000, store.ListOption{})
+	assert.Nil(t, err)
+	assert.Len(t, items, len(batch))
+
+	// a key changed before every txn conflicts with the last value written
+	writes := 0
+	racing := func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		writes++
+		assert.Nil(t, db.Put(ctx, []byte("kr"), []byte(fmt.Sprintf("r%d", writes))))
+		return newVal, nil
+	}
+	err = db.CheckAndPut(ctx, []byte("kr"), nil, []byte("n"), store.CheckOption{Check: racing})
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, fmt.Sprintf("r%d", writes), string(conflict.Value))
+	assert.NotZero(t, conflict.ReadTs)
+
+	assert.Nil(t, db.UnsafeDelete(ctx, []byte("k"), nil))
+	items, err = db.List(ctx, []byte("k"), []byte("l"), 10, store.ListOption{})
+	assert.Nil(t, err)
+	assert.Empty(t, items)
+}
@@ -0,0 +1,30 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/huangnauh/tirest/xerror"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func isUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		err == rpctypes.ErrNoLeader || err == rpctypes.ErrTimeout ||
+		err == rpctypes.ErrTimeoutDueToLeaderFail || err == rpctypes.ErrTooManyRequests {
+		return true
+	}
+	return status.Code(err) == codes.Unavailable
+}
+
+// wrapError keeps the etcd error as the cause, errors from a cluster
+// without a leader or unreachable are reported as unavailable.
+func wrapError(e *xerror.Error, err error) error {
+	w := e.Wrap(err)
+	if isUnavailable(err) {
+		w.Category = xerror.Unavailable
+	}
+	return w
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	FaultDB        = "db"
+	FaultConnector = "connector"
+
+	OpSend = "send"
+)
+
+var faultErrors = map[string]error{
+	"unavailable": xerror.ErrFaultUnavailable,
+	"internal":    xerror.ErrFaultInternal,
+	"timeout":     xerror.ErrFaultTimeout,
+}
+
+type faultRule struct {
+	ops         map[string]bool
+	prefix      []byte
+	probability float64
+	latency     time.Duration
+	err         error
+	partial     bool
+}
+
+// faults are the fault rules of a target, the first rule matching a call
+// applies.
+type faults struct {
+	target string
+	rules  []faultRule
+}
+
+func newFaults(confs []config.FaultRule, target string) *faults {
+	f := &faults{target: target}
+	for _, conf := range confs {
+		if conf.Target != target {
+			continue
+		}
+		r := faultRule{
+			prefix:      []byte(conf.Prefix),
+			probability: conf.Probability,
+			err:         faultErrors[conf.Error],
+			partial:     conf.Partial,
+		}
+		if conf.Latency != nil {
+			r.latency = conf.Latency.Duration
+		}
+		if len(conf.Ops) > 0 {
+			r.ops = make(map[string]bool, len(conf.Ops))
+			for _, op := range conf.Ops {
+				r.ops[op] = true
+			}
+		}
+		f.rules = append(f.rules, r)
+	}
+	return f
+}
+
+// match returns the rule matching op on key if it fires, the prefix is of
+// the user keys.
+func (f *faults) match(op string, key []byte) *faultRule {
+	for i := range f.rules {
+		r := &f.rules[i]
+		if r.ops != nil && !r.ops[op] {
+			continue
+		}
+		if len(r.prefix) > 0 && (len(key) == 0 || key[0] != metaType || !bytes.HasPrefix(key[1:], r.prefix)) {
+			continue
+		}
+		if rand.Float64() >= r.probability {
+			return nil
+		}
+		return r
+	}
+	return nil
+}
+
+// inject waits the latency of the rule matching op on key and returns its
+// error, partial is true if the call is to fail after a part of it is done.
+func (f *faults) inject(ctx context.Context, op string, key []byte) (partial bool, err error) {
+	r := f.match(op, key)
+	if r == nil {
+		return false, nil
+	}
+	metric.Fault.WithLabelValues(f.target, op).Inc()
+	if r.latency > 0 {
+		t := time.NewTimer(r.latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return false, contextError(ctx, ctx.Err())
+		}
+	}
+	return r.partial && r.err != nil, r.err
+}
+
+// faultDB injects the faults of [fault] into the calls of the DB under it,
+// the decorators above see them as failures of TiKV.
+type faultDB struct {
+	DB
+	faults *faults
+}
+
+func newFaultDB(db DB, conf *config.Fault) *faultDB {
+	return &faultDB{DB: db, faults: newFaults(conf.Rules, FaultDB)}
+}
+
+func (d *faultDB) Unwrap() DB {
+	return d.DB
+}
+
+func (d *faultDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	if _, err := d.faults.inject(ctx, OpGet, key); err != nil {
+		return Value{}, err
+	}
+	return d.DB.Get(ctx, key, option)
+}
+
+func (d *faultDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	if _, err := d.faults.inject(ctx, OpList, start); err != nil {
+		return nil, err
+	}
+	return d.DB.List(ctx, start, end, limit, option)
+}
+
+// Scan of a partial fault hands the first key to fn before it fails.
+func (d *faultDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	partial, err := d.faults.inject(ctx, OpList, start)
+	if err == nil {
+		return scan(ctx, d.DB, start, end, limit, option, fn)
+	}
+	if partial {
+		if err := scan(ctx, d.DB, start, end, 1, option, fn); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (d *faultDB) Put(ctx context.Context, key, val []byte) error {
+	if _, err := d.faults.inject(ctx, OpPut, key); err != nil {
+		return err
+	}
+	return d.DB.Put(ctx, key, val)
+}
+
+func (d *faultDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	if _, err := d.faults.inject(ctx, OpCas, key); err != nil {
+		return err
+	}
+	return d.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+}
+
+// BatchPut of a partial fault writes the first half of the items before it
+// fails, the batch is matched by its first key.
+func (d *faultDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	var key []byte
+	if len(items) > 0 {
+		key = items[0].Key
+	}
+	partial, err := d.faults.inject(ctx, OpBatchPut, key)
+	if err == nil {
+		return d.DB.BatchPut(ctx, items)
+	}
+	if partial && len(items) > 1 {
+		if err := d.DB.BatchPut(ctx, items[:len(items)/2]); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// BatchDelete of a partial fault deletes up to half of the limit, at least
+// one key, before it fails.
+func (d *faultDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	partial, err := d.faults.inject(ctx, OpBatchDelete, start)
+	if err == nil {
+		return d.DB.BatchDelete(ctx, start, end, limit)
+	}
+	if !partial {
+		return nil, 0, err
+	}
+	half := limit / 2
+	if half < 1 {
+		half = 1
+	}
+	lastKey, deleted, deleteErr := d.DB.BatchDelete(ctx, start, end, half)
+	if deleteErr != nil {
+		return lastKey, deleted, deleteErr
+	}
+	return lastKey, deleted, err
+}
+
+func (d *faultDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	if _, err := d.faults.inject(ctx, OpUnsafeDelete, start); err != nil {
+		return err
+	}
+	return d.DB.UnsafeDelete(ctx, start, end)
+}
+
+// faultConnector injects the faults of [fault] into the sends, a change
+// which failed is dropped as by a full queue.
+type faultConnector struct {
+	Connector
+	faults *faults
+}
+
+func newFaultConnector(c Connector, conf *config.Fault) *faultConnector {
+	return &faultConnector{Connector: c, faults: newFaults(conf.Rules, FaultConnector)}
+}
+
+func (c *faultConnector) Unwrap() Connector {
+	return c.Connector
+}
+
+func (c *faultConnector) Send(msg KeyEntry) error {
+	if _, err := c.faults.inject(context.Background(), OpSend, msg.Key); err != nil {
+		return err
+	}
+	return c.Connector.Send(msg)
+}
+
+// baseConnector returns the driver under the decorators, so optional
+// interfaces like Replayer can be checked.
+func baseConnector(c Connector) Connector {
+	for {
+		w, ok := c.(interface{ Unwrap() Connector })
+		if !ok {
+			return c
+		}
+		c = w.Unwrap()
+	}
+}
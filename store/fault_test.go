@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultDB(t *testing.T) {
+	user := func(s string) []byte { return append([]byte{0x00}, s...) }
+	conf := &config.Fault{Enable: true, Rules: []config.FaultRule{
+		{Target: FaultDB, Ops: []string{OpGet}, Prefix: "never/", Probability: 0, Error: "internal"},
+		{Target: FaultDB, Ops: []string{OpGet, OpPut}, Prefix: "test/", Probability: 1, Error: "unavailable"},
+		{Target: FaultDB, Ops: []string{OpBatchPut}, Probability: 1, Error: "internal", Partial: true},
+		{Target: FaultDB, Ops: []string{OpList}, Probability: 1, Latency: &config.Duration{Duration: time.Second}},
+		{Target: FaultConnector, Probability: 1, Error: "unavailable"},
+	}}
+	mem := &casDB{memDB{kv: map[string]string{}}}
+	d := newFaultDB(mem, conf)
+	ctx := context.Background()
+
+	assert.Equal(t, xerror.ErrFaultUnavailable, d.Put(ctx, user("test/a"), []byte("1")))
+	assert.Nil(t, d.Put(ctx, user("other/a"), []byte("1")))
+	assert.Nil(t, d.Put(ctx, []byte("test/a"), []byte("1")))
+	_, err := d.Get(ctx, user("test/a"), GetOption{})
+	assert.Equal(t, xerror.ErrFaultUnavailable, err)
+	// the first rule matching applies, even if it doesn't fire
+	_, err = d.Get(ctx, user("never/a"), GetOption{})
+	assert.Equal(t, xerror.ErrNotExists, err)
+
+	err = d.BatchPut(ctx, []KeyEntry{{Key: []byte("b"), Entry: []byte("2")}, {Key: []byte("c"), Entry: []byte("3")}})
+	assert.Equal(t, xerror.ErrFaultInternal, err)
+	assert.Equal(t, map[string]string{"\x00other/a": "1", "test/a": "1", "b": "2"}, mem.kv)
+
+	timeout, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = d.List(timeout, []byte("a"), []byte("z"), 0, ListOption{})
+	assert.Equal(t, xerror.DeadlineExceeded, xerror.CategoryOf(err))
+
+	lag := &lagConnector{problems: []string{"lag"}}
+	c := newFaultConnector(lag, conf)
+	assert.Equal(t, xerror.ErrFaultUnavailable, c.Send(KeyEntry{Key: user("a")}))
+	_, ok := baseConnector(c).(StatusReporter)
+	assert.True(t, ok)
+}
@@ -0,0 +1,295 @@
+// +build fdb
+
+// Package fdb is built with the fdb tag, it needs the FoundationDB client
+// library and github.com/apple/foundationdb/bindings/go.
+package fdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/apple/foundationdb/bindings/go/src/fdb"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	DBName     = "fdb"
+	Scheme     = "fdb://"
+	APIVersion = 620
+)
+
+// FDB maps the DB onto FoundationDB transactions, which retry by themselves
+// on conflicts, so a CAS is checked again until it commits or fails. A
+// transaction is limited to 10MB and 5 seconds, a value to 100KB.
+type FDB struct {
+	db   fdb.Database
+	conf *config.Config
+	log  *logrus.Entry
+}
+
+type Driver struct {
+}
+
+func init() {
+	store.RegisterDB(Driver{})
+}
+
+func (d Driver) Name() string {
+	return DBName
+}
+
+// ClusterFile returns the cluster file of path, fdb:///etc/foundationdb/fdb.cluster,
+// an empty one is the default cluster file.
+func ClusterFile(path string) (string, error) {
+	if !strings.HasPrefix(path, Scheme) {
+		return "", errors.New("path needs fdb://[cluster file]")
+	}
+	return path[len(Scheme):], nil
+}
+
+func (d Driver) Open(conf *config.Config) (store.DB, error) {
+	clusterFile, err := ClusterFile(conf.Store.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err = fdb.APIVersion(APIVersion); err != nil {
+		return nil, err
+	}
+	db, err := fdb.OpenDatabase(clusterFile)
+	if err != nil {
+		return nil, err
+	}
+	return &FDB{
+		db:   db,
+		conf: conf,
+		log:  logrus.WithFields(logrus.Fields{"worker": DBName}),
+	}, nil
+}
+
+func (f *FDB) Close() error {
+	return nil
+}
+
+// setTimeout bounds a transaction by timeout and the deadline of ctx.
+func setTimeout(ctx context.Context, tr fdb.Transaction, timeout time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return context.DeadlineExceeded
+	}
+	return tr.Options().SetTimeout(int64(timeout / time.Millisecond))
+}
+
+// keyRange maps an empty end to the end of the user keys, the system keys
+// start with 0xff.
+func keyRange(start, end []byte) fdb.KeyRange {
+	if len(end) == 0 {
+		return fdb.KeyRange{Begin: fdb.Key(start), End: fdb.Key{0xff}}
+	}
+	return fdb.KeyRange{Begin: fdb.Key(start), End: fdb.Key(end)}
+}
+
+func (f *FDB) Get(ctx context.Context, key []byte, option store.GetOption) (store.Value, error) {
+	ret, err := f.db.ReadTransact(func(rt fdb.ReadTransaction) (interface{}, error) {
+		tr := rt.(fdb.Transaction)
+		if err := setTimeout(ctx, tr, f.conf.Store.ReadTimeout.Duration); err != nil {
+			return nil, err
+		}
+		v, err := tr.Get(fdb.Key(key)).Get()
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && option.Secondary != nil {
+			v, err = tr.Get(fdb.Key(option.Secondary)).Get()
+			return store.Value{Secondary: true, Value: v}, err
+		}
+		return store.Value{Value: v}, nil
+	})
+	if err != nil {
+		f.log.Errorf("get %s failed %s", key, err)
+		return store.NoValue, wrapError(xerror.ErrGetKVFailed, err)
+	}
+	v := ret.(store.Value)
+	if v.Value == nil {
+		return store.NoValue, xerror.ErrNotExists
+	}
+	return v, nil
+}
+
+func (f *FDB) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	ret, err := f.db.ReadTransact(func(rt fdb.ReadTransaction) (interface{}, error) {
+		tr := rt.(fdb.Transaction)
+		if err := setTimeout(ctx, tr, f.conf.Store.ListTimeout.Duration); err != nil {
+			return nil, err
+		}
+		r := keyRange(start, end)
+		it := tr.GetRange(r, fdb.RangeOptions{Reverse: option.Reverse}).Iterator()
+		items := make([]store.KeyValue, 0)
+		for it.Advance() {
+			kv, err := it.Get()
+			if err != nil {
+				return nil, err
+			}
+			k, v := []byte(kv.Key), kv.Value
+			if option.KeyOnly {
+				v = nil
+			}
+			if option.Item != nil {
+				k, v, err = option.Item(k, v)
+				if err != nil {
+					f.log.Warnf("list (%s-%s) key %s, err %s", start, end, k, err)
+					continue
+				}
+			}
+			items = append(items, store.KeyValue{Key: utils.B2S(k), Value: utils.B2S(v)})
+			if limit > 0 && len(items) >= limit {
+				break
+			}
+		}
+		return items, nil
+	})
+	if err != nil {
+		f.log.Errorf("list (%s-%s) failed %s", start, end, err)
+		return nil, wrapError(xerror.ErrListKVFailed, err)
+	}
+	return ret.([]store.KeyValue), nil
+}
+
+func (f *FDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option store.CheckOption) error {
+	_, err := f.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := setTimeout(ctx, tr, f.conf.Store.WriteTimeout.Duration); err != nil {
+			return nil, err
+		}
+		existVal, err := tr.Get(fdb.Key(key)).Get()
+		if err != nil {
+			return nil, err
+		}
+		val := newVal
+		if option.Check != nil {
+			val, err = option.Check(oldVal, newVal, existVal)
+			if errors.Is(err, xerror.ErrCheckAndSetFailed) {
+				return nil, store.NewConflictError(err, existVal, 0)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if len(val) == 0 {
+			tr.Clear(fdb.Key(key))
+		} else {
+			tr.Set(fdb.Key(key), val)
+		}
+		return nil, nil
+	})
+	var fdbErr fdb.Error
+	if errors.As(err, &fdbErr) {
+		f.log.Errorf("cas %s commit failed %s", key, err)
+		return wrapError(xerror.ErrCheckAndSetFailed, err)
+	}
+	return err
+}
+
+func (f *FDB) Put(ctx context.Context, key, val []byte) error {
+	_, err := f.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := setTimeout(ctx, tr, f.conf.Store.WriteTimeout.Duration); err != nil {
+			return nil, err
+		}
+		if len(val) == 0 {
+			tr.Clear(fdb.Key(key))
+		} else {
+			tr.Set(fdb.Key(key), val)
+		}
+		return nil, nil
+	})
+	if err != nil {
+		f.log.Errorf("put %s failed %s", key, err)
+		return wrapError(xerror.ErrSetKVFailed, err)
+	}
+	return nil
+}
+
+func (f *FDB) BatchPut(ctx context.Context, items []store.KeyEntry) error {
+	_, err := f.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := setTimeout(ctx, tr, f.conf.Store.BatchPutTimeout.Duration); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if len(item.Entry) == 0 {
+				tr.Clear(fdb.Key(item.Key))
+			} else {
+				tr.Set(fdb.Key(item.Key), item.Entry)
+			}
+		}
+		return nil, nil
+	})
+	if err != nil {
+		f.log.Errorf("batch put commit failed %s", err)
+		return wrapError(xerror.ErrCommitKVFailed, err)
+	}
+	return nil
+}
+
+type deleted struct {
+	lastKey []byte
+	count   int
+}
+
+// BatchDelete clears up to limit keys from start in one transaction.
+func (f *FDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	ret, err := f.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := setTimeout(ctx, tr, f.conf.Store.BatchDeleteTimeout.Duration); err != nil {
+			return nil, err
+		}
+		r := keyRange(start, end)
+		kvs, err := tr.GetRange(r, fdb.RangeOptions{Limit: limit}).GetSliceWithError()
+		if err != nil || len(kvs) == 0 {
+			return deleted{}, err
+		}
+		lastKey := kvs[len(kvs)-1].Key
+		tr.ClearRange(fdb.KeyRange{Begin: fdb.Key(start), End: fdb.Key(string(lastKey) + "\x00")})
+		return deleted{lastKey: lastKey, count: len(kvs)}, nil
+	})
+	if err != nil {
+		f.log.Errorf("batch delete (%s-%s) failed %s", start, end, err)
+		return nil, 0, wrapError(xerror.ErrCommitKVFailed, err)
+	}
+	d := ret.(deleted)
+	return d.lastKey, d.count, nil
+}
+
+// UnsafeDelete clears the range at once, FoundationDB frees the space in
+// the background.
+func (f *FDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	_, err := f.db.Transact(func(tr fdb.Transaction) (interface{}, error) {
+		if err := setTimeout(ctx, tr, f.conf.Store.BatchDeleteTimeout.Duration); err != nil {
+			return nil, err
+		}
+		tr.ClearRange(keyRange(start, end))
+		return nil, nil
+	})
+	if err != nil {
+		f.log.Errorf("unsafe delete (%s-%s) failed %s", start, end, err)
+		return wrapError(xerror.ErrCommitKVFailed, err)
+	}
+	return nil
+}
+
+// retryable errors of a busy or unreachable cluster, e.g. 1031 timed out
+// and 1037 process behind.
+var unavailableCodes = map[int]bool{1004: true, 1031: true, 1037: true, 1213: true}
+
+func wrapError(e *xerror.Error, err error) error {
+	w := e.Wrap(err)
+	var fdbErr fdb.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &fdbErr) && unavailableCodes[fdbErr.Code] {
+		w.Category = xerror.Unavailable
+	}
+	return w
+}
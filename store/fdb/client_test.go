@@ -0,0 +1,58 @@
+// +build fdb
+
+package fdb
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	pathArg = flag.String("conf", "", "test conf path")
+)
+
+func TestSecondary(t *testing.T) {
+	flag.Parse()
+	if *pathArg == "" {
+		t.Skip("need config file")
+	}
+	conf, err := config.InitConfig(*pathArg)
+	assert.Nil(t, err)
+	db, err := Driver{}.Open(conf)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	clean := func() {
+		assert.Nil(t, db.UnsafeDelete(ctx, []byte("tirest-test/secondary/"), []byte("tirest-test/secondary0")))
+	}
+	clean()
+	defer clean()
+	key, old := []byte("tirest-test/secondary/new"), []byte("tirest-test/secondary/old")
+	assert.Nil(t, db.Put(ctx, old, []byte("v1")))
+
+	// the key doesn't exist, the secondary is read
+	v, err := db.Get(ctx, key, store.GetOption{Secondary: old})
+	assert.Nil(t, err)
+	assert.Equal(t, store.Value{Secondary: true, Value: []byte("v1")}, v)
+	_, err = db.Get(ctx, key, store.GetOption{})
+	assert.True(t, errors.Is(err, xerror.ErrNotExists))
+
+	// the key wins once it exists
+	assert.Nil(t, db.Put(ctx, key, []byte("v2")))
+	v, err = db.Get(ctx, key, store.GetOption{Secondary: old})
+	assert.Nil(t, err)
+	assert.Equal(t, store.Value{Value: []byte("v2")}, v)
+
+	_, err = db.Get(ctx, []byte("tirest-test/secondary/none"), store.GetOption{Secondary: []byte("tirest-test/secondary/none-old")})
+	assert.True(t, errors.Is(err, xerror.ErrNotExists))
+}
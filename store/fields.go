@@ -0,0 +1,245 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	FieldEncrypt = "encrypt"
+	FieldRedact  = "redact"
+
+	// metaType prefixes the keys of the meta routes, the field rules only
+	// apply to them.
+	metaType byte = 0x00
+	// sealedPrefix starts an encrypted field: prefix | key id | ":" |
+	// base64 of the nonce and the ciphertext.
+	sealedPrefix = "tirest:enc:"
+)
+
+type fieldMode int
+
+const (
+	// sealMode encrypts and redacts the fields before they're stored
+	sealMode fieldMode = iota
+	// openMode decrypts the fields for the sensitive-read permission
+	openMode
+	// compareMode decrypts and redacts, so a value sent by a client can
+	// be compared with the value stored
+	compareMode
+)
+
+type fieldRule struct {
+	prefix []byte
+	paths  [][]string
+	redact bool
+}
+
+// ParseFieldPath splits $.a.b into its fields, the paths only go through
+// json objects.
+func ParseFieldPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$.") {
+		return nil, fmt.Errorf("path %q doesn't start with $.", path)
+	}
+	fields := strings.Split(path[2:], ".")
+	for _, f := range fields {
+		if f == "" {
+			return nil, fmt.Errorf("path %q has an empty field", path)
+		}
+	}
+	return fields, nil
+}
+
+// fieldSealer seals the json fields of the values before they're stored.
+// The nonce of a field is derived from the key, the path and the value, so
+// a value sealed twice is the same and the CAS checks and the replication
+// can compare the values sealed.
+type fieldSealer struct {
+	rules []fieldRule
+	keys  *keyring
+}
+
+func newFieldSealer(confs []config.FieldRule, keys *keyring) (*fieldSealer, error) {
+	f := &fieldSealer{keys: keys}
+	for _, conf := range confs {
+		r := fieldRule{prefix: []byte(conf.Prefix), redact: conf.Action == FieldRedact}
+		if !r.redact && keys == nil {
+			return nil, fmt.Errorf("field rule %q needs the encryption keys", conf.Prefix)
+		}
+		for _, p := range conf.Paths {
+			path, err := ParseFieldPath(p)
+			if err != nil {
+				return nil, err
+			}
+			r.paths = append(r.paths, path)
+		}
+		f.rules = append(f.rules, r)
+	}
+	return f, nil
+}
+
+func (f *fieldSealer) match(key []byte) []*fieldRule {
+	if len(key) == 0 || key[0] != metaType {
+		return nil
+	}
+	var rules []*fieldRule
+	for i := range f.rules {
+		if bytes.HasPrefix(key[1:], f.rules[i].prefix) {
+			rules = append(rules, &f.rules[i])
+		}
+	}
+	return rules
+}
+
+// apply keeps a value which isn't a json object as it is.
+func (f *fieldSealer) apply(key, val []byte, mode fieldMode) ([]byte, error) {
+	if f == nil || len(val) == 0 {
+		return val, nil
+	}
+	for _, r := range f.match(key) {
+		for _, path := range r.paths {
+			var err error
+			val, _, err = rewriteField(val, path, func(v json.RawMessage) (json.RawMessage, error) {
+				return f.field(key, strings.Join(path, "."), v, r.redact, mode)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return val, nil
+}
+
+func (f *fieldSealer) field(key []byte, path string, v json.RawMessage, redact bool, mode fieldMode) (json.RawMessage, error) {
+	sealed, isSealed := sealedField(v)
+	switch {
+	case redact && mode != openMode:
+		return json.RawMessage(`"` + redacted + `"`), nil
+	case redact:
+		return v, nil
+	case mode == sealMode && isSealed:
+		return v, nil
+	case mode == sealMode:
+		return f.sealField(key, path, v)
+	case !isSealed:
+		return v, nil
+	}
+	return f.openField(key, path, sealed)
+}
+
+// sealedField returns the string of an encrypted field.
+func sealedField(v json.RawMessage) (string, bool) {
+	if len(v) == 0 || v[0] != '"' {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(v, &s) != nil || !strings.HasPrefix(s, sealedPrefix) {
+		return "", false
+	}
+	return s, true
+}
+
+// fieldAD binds a field to its key and its path.
+func fieldAD(key []byte, path string) []byte {
+	ad := make([]byte, 0, len(key)+1+len(path))
+	ad = append(ad, key...)
+	ad = append(ad, 0)
+	return append(ad, path...)
+}
+
+func (f *fieldSealer) sealField(key []byte, path string, v json.RawMessage) (json.RawMessage, error) {
+	id := f.keys.primary
+	dk, err := f.keys.dataKey(id)
+	if err != nil {
+		return nil, err
+	}
+	ad := fieldAD(key, path)
+	nonceKey := hmac.New(sha256.New, dk.raw)
+	nonceKey.Write([]byte("tirest field nonce"))
+	mac := hmac.New(sha256.New, nonceKey.Sum(nil))
+	mac.Write(ad)
+	mac.Write([]byte{0})
+	mac.Write(v)
+	nonce := mac.Sum(nil)[:dk.aead.NonceSize()]
+	b := dk.aead.Seal(append([]byte(nil), nonce...), nonce, v, ad)
+	return json.Marshal(sealedPrefix + id + ":" + base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (f *fieldSealer) openField(key []byte, path string, sealed string) (json.RawMessage, error) {
+	rest := sealed[len(sealedPrefix):]
+	i := strings.LastIndexByte(rest, ':')
+	if i < 0 {
+		return nil, xerror.ErrDecryptFailed
+	}
+	a, err := f.keys.aead(rest[:i])
+	if err != nil {
+		return nil, xerror.ErrDecryptFailed.Wrap(err)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(rest[i+1:])
+	if err != nil || len(b) < a.NonceSize() {
+		return nil, xerror.ErrDecryptFailed
+	}
+	v, err := a.Open(nil, b[:a.NonceSize()], b[a.NonceSize():], fieldAD(key, path))
+	if err != nil {
+		return nil, xerror.ErrDecryptFailed.Wrap(err)
+	}
+	return v, nil
+}
+
+// rewriteField replaces the field at path of the json object obj, false
+// if it has no such field.
+func rewriteField(obj []byte, path []string, f func(json.RawMessage) (json.RawMessage, error)) ([]byte, bool, error) {
+	var m map[string]json.RawMessage
+	if len(obj) == 0 || obj[0] != '{' || json.Unmarshal(obj, &m) != nil {
+		return obj, false, nil
+	}
+	v, ok := m[path[0]]
+	if !ok {
+		return obj, false, nil
+	}
+	var err error
+	if len(path) == 1 {
+		v, err = f(v)
+	} else {
+		v, ok, err = rewriteField(v, path[1:], f)
+	}
+	if err != nil || !ok {
+		return obj, ok, err
+	}
+	m[path[0]] = v
+	b, err := json.Marshal(m)
+	return b, true, err
+}
+
+// openCheck runs check on the values with their fields opened and
+// redacted, a client may send the old value in the clear or as it read it.
+func (f *fieldSealer) openCheck(key []byte, check CheckFunc) CheckFunc {
+	if check == nil {
+		return nil
+	}
+	return func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		oldVal, err := f.apply(key, oldVal, compareMode)
+		if err != nil {
+			return nil, err
+		}
+		existVal, err = f.apply(key, existVal, compareMode)
+		if err != nil {
+			return nil, err
+		}
+		return check(oldVal, newVal, existVal)
+	}
+}
+
+// OpenFields decrypts the encrypted fields of a value of key, the caller
+// checks the sensitive-read permission.
+func (s *Store) OpenFields(key, val []byte) ([]byte, error) {
+	return s.fields.apply(key, val, openMode)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldSealer(t *testing.T) {
+	provider := configKeys{"k1": bytes.Repeat([]byte{1}, 32)}
+	keys, err := newKeyring(provider, "k1")
+	assert.Nil(t, err)
+	fields, err := newFieldSealer([]config.FieldRule{
+		{Prefix: "users/", Paths: []string{"$.ssn", "$.card.number"}, Action: FieldEncrypt},
+		{Prefix: "users/", Paths: []string{"$.password"}, Action: FieldRedact},
+	}, keys)
+	assert.Nil(t, err)
+	_, err = newFieldSealer([]config.FieldRule{{Prefix: "a", Paths: []string{"$.b"}, Action: FieldEncrypt}}, nil)
+	assert.NotNil(t, err)
+
+	conf := config.DefaultConfig()
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, fields: fields, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+	key := []byte("\x00users/a")
+	val := `{"name":"a","ssn":"123-45-6789","card":{"number":4111},"password":"p"}`
+
+	assert.Nil(t, s.UnsafePut(ctx, key, []byte(val)))
+	stored := db.kv[string(key)]
+	assert.NotContains(t, stored, "6789")
+	assert.NotContains(t, stored, "4111")
+	assert.Contains(t, stored, `"password":"`+redacted+`"`)
+	assert.Contains(t, stored, `"name":"a"`)
+
+	// sealed again the value is the same
+	again, err := fields.apply(key, []byte(stored), sealMode)
+	assert.Nil(t, err)
+	assert.Equal(t, stored, string(again))
+	opened, err := s.OpenFields(key, []byte(stored))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"card":{"number":4111},"name":"a","password":"`+redacted+`","ssn":"123-45-6789"}`,
+		string(opened))
+
+	// a field can't be moved to another key
+	_, err = s.OpenFields([]byte("\x00users/b"), []byte(stored))
+	assert.NotNil(t, err)
+	// other keys and values which aren't objects are kept
+	assert.Nil(t, s.UnsafePut(ctx, []byte("\x00other"), []byte(val)))
+	assert.Equal(t, val, db.kv["\x00other"])
+	assert.Nil(t, s.UnsafePut(ctx, []byte("\x00users/c"), []byte("[1]")))
+	assert.Equal(t, "[1]", db.kv["\x00users/c"])
+
+	// the old value of a CAS may be sent in the clear or as it was read
+	for i, old := range []string{val, stored} {
+		entry, _ := json.Marshal(Log{Old: old, New: val})
+		err = s.CheckAndPut(ctx, key, entry, CheckOption{Check: equalCheck})
+		assert.Nil(t, err, i)
+	}
+	entry, _ := json.Marshal(Log{Old: `{"ssn":"1"}`, New: val})
+	err = s.CheckAndPut(ctx, key, entry, CheckOption{Check: equalCheck})
+	var conflict *ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, stored, string(conflict.Value))
+
+	// a large value of a sealed prefix isn't streamed as it's sent
+	conf.Blob.Threshold = 16
+	conf.Blob.ChunkSize = 32
+	s.blobs = &chunkStore{s: s}
+	assert.True(t, s.Streaming([]byte("\x00other")))
+	assert.False(t, s.Streaming(key))
+	assert.Nil(t, s.UnsafePutStream(ctx, key, strings.NewReader(val)))
+	_, ok := decodePointer([]byte(db.kv[string(key)]))
+	assert.True(t, ok)
+	v, err := s.Get(ctx, key, GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, stored, string(v.Value))
+}
@@ -0,0 +1,207 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+const Name = "file"
+
+// Events counts the changes by result, written or failed.
+var Events = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Subsystem: version.APP,
+	Name:      "connector_file_events_total",
+	Help:      "A counter for changes appended by the file connector by result.",
+}, []string{"result"})
+
+// Event is a line of the file, Key is the key as stored and Entry the
+// change, a json object unless a transform made it a string.
+type Event struct {
+	Time  time.Time       `json:"time"`
+	Key   []byte          `json:"key"`
+	Entry json.RawMessage `json:"entry"`
+}
+
+type Driver struct {
+}
+
+func init() {
+	prometheus.MustRegister(Events)
+	store.RegisterConnector(Driver{})
+}
+
+func (d Driver) Name() string {
+	return Name
+}
+
+func (d Driver) Open(conf *config.Config) (store.Connector, error) {
+	c := &Connector{
+		conf: &conf.Connector.File,
+		log:  logrus.WithFields(logrus.Fields{"worker": "file connector"}),
+	}
+	if err := os.MkdirAll(filepath.Dir(c.conf.Path), 0755); err != nil {
+		c.log.Errorf("Failed to mkdir, %s", err)
+		return nil, err
+	}
+	if err := c.open(); err != nil {
+		c.log.Errorf("open %s failed, %s", c.conf.Path, err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connector appends the changes to a local file, e.g. a simple audit trail
+// of a single host, a change is written before Send returns.
+type Connector struct {
+	mu     sync.Mutex
+	fd     *os.File
+	size   int64
+	closed bool
+	conf   *config.File
+	log    *logrus.Entry
+}
+
+func (c *Connector) open() error {
+	fd, err := os.OpenFile(c.conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	c.fd, c.size = fd, info.Size()
+	return nil
+}
+
+// rotate renames the file to path.1, the backups move one further and the
+// last one is dropped.
+func (c *Connector) rotate() error {
+	if err := c.fd.Close(); err != nil {
+		c.log.Errorf("close %s failed, %s", c.conf.Path, err)
+	}
+	c.fd = nil
+	for i := c.conf.BackupCount - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", c.conf.Path, i), fmt.Sprintf("%s.%d", c.conf.Path, i+1))
+	}
+	if err := os.Rename(c.conf.Path, c.conf.Path+".1"); err != nil {
+		c.log.Errorf("rotate %s failed, %s", c.conf.Path, err)
+	}
+	return c.open()
+}
+
+func entry(b []byte) json.RawMessage {
+	if json.Valid(b) {
+		return b
+	}
+	s, _ := json.Marshal(string(b))
+	return s
+}
+
+func (c *Connector) Send(msg store.KeyEntry) error {
+	line, err := json.Marshal(Event{Time: time.Now(), Key: msg.Key, Entry: entry(msg.Entry)})
+	if err != nil {
+		Events.WithLabelValues("failed").Inc()
+		return err
+	}
+	line = append(line, '\n')
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.write(line); err != nil {
+		Events.WithLabelValues("failed").Inc()
+		c.log.Errorf("write %s failed, %s", c.conf.Path, err)
+		return err
+	}
+	Events.WithLabelValues("written").Inc()
+	return nil
+}
+
+// write opens the file again after it failed to, a line never spans two
+// files.
+func (c *Connector) write(line []byte) error {
+	if c.closed {
+		return xerror.ErrConnectorClosed
+	}
+	if c.fd == nil {
+		if err := c.open(); err != nil {
+			return err
+		}
+	}
+	if c.conf.MaxBytes > 0 && c.conf.BackupCount > 0 && c.size > 0 &&
+		c.size+int64(len(line)) > c.conf.MaxBytes {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := c.fd.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+func (c *Connector) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	if c.fd == nil {
+		return
+	}
+	if err := c.fd.Close(); err != nil {
+		c.log.Errorf("close %s failed, %s", c.conf.Path, err)
+	}
+	c.fd = nil
+}
+
+// Files returns the files of the connector writing path, the oldest backup
+// first.
+func Files(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		files = append([]string{name}, files...)
+	}
+	return append(files, path)
+}
+
+// ReadEvents calls fn with the events of r in order, it stops at the first
+// error of fn or the first line which isn't an event, a last line cut short
+// by a crash is skipped.
+func ReadEvents(r io.Reader, fn func(ev Event) error) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			ev := Event{}
+			if jsonErr := json.Unmarshal(line, &ev); jsonErr != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return fmt.Errorf("line %d, %s", n, jsonErr)
+			}
+			if err := fn(ev); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package file
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func readEvents(t *testing.T, name string) []Event {
+	f, err := os.Open(name)
+	assert.Nil(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ev := Event{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &ev))
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestConnector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := config.DefaultConfig()
+	conf.Connector.File = config.File{Path: filepath.Join(dir, "a", "events.log"), MaxBytes: 10, BackupCount: 2}
+	c, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Send(store.KeyEntry{Key: []byte("\x00a"), Entry: []byte(`{"old":"","new":"1"}`)}))
+	assert.Nil(t, c.Send(store.KeyEntry{Key: []byte("\x00b"), Entry: []byte("not json")}))
+	events := readEvents(t, conf.Connector.File.Path)
+	assert.Len(t, events, 1)
+	assert.Equal(t, []byte("\x00b"), events[0].Key)
+	assert.Equal(t, `"not json"`, string(events[0].Entry))
+	events = readEvents(t, conf.Connector.File.Path+".1")
+	assert.Len(t, events, 1)
+	assert.JSONEq(t, `{"old":"","new":"1"}`, string(events[0].Entry))
+
+	// the oldest backup is dropped
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, c.Send(store.KeyEntry{Key: []byte("\x00c"), Entry: []byte(`{}`)}))
+	}
+	_, err = os.Stat(conf.Connector.File.Path + ".3")
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []byte("\x00c"), readEvents(t, conf.Connector.File.Path+".2")[0].Key)
+
+	c.Close()
+	assert.Equal(t, xerror.ErrConnectorClosed, c.Send(store.KeyEntry{Key: []byte("\x00d")}))
+}
+
+func TestReadEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	conf := config.DefaultConfig()
+	conf.Connector.File = config.File{Path: filepath.Join(dir, "events.log"), MaxBytes: 10, BackupCount: 5}
+	c, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	for _, k := range []string{"a", "b", "c"} {
+		assert.Nil(t, c.Send(store.KeyEntry{Key: []byte(k), Entry: []byte(`{}`)}))
+	}
+	c.Close()
+	files := Files(conf.Connector.File.Path)
+	assert.Equal(t, []string{conf.Connector.File.Path + ".2", conf.Connector.File.Path + ".1",
+		conf.Connector.File.Path}, files)
+
+	// a line cut short is skipped
+	f, err := os.OpenFile(files[2], os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"time":"2020`)
+	assert.Nil(t, err)
+	f.Close()
+	var keys []string
+	for _, name := range files {
+		f, err := os.Open(name)
+		assert.Nil(t, err)
+		assert.Nil(t, ReadEvents(f, func(ev Event) error {
+			keys = append(keys, string(ev.Key))
+			return nil
+		}))
+		f.Close()
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.NotNil(t, ReadEvents(strings.NewReader("{}\nnot json\n"), func(Event) error { return nil }))
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+const (
+	EventPut    = "put"
+	EventUpdate = "update"
+	EventDelete = "delete"
+	EventAudit  = "audit"
+
+	redacted = "[redacted]"
+)
+
+// Transform rewrites a change before it's queued, returning false drops it.
+type Transform func(op string, msg KeyEntry) (KeyEntry, bool)
+
+var transforms = make(map[string]Transform)
+
+func RegisterTransform(name string, t Transform) {
+	if _, ok := transforms[name]; ok {
+		panic(fmt.Errorf("transform %s is already registered", name))
+	}
+
+	transforms[name] = t
+}
+
+type eventRule struct {
+	prefix    []byte
+	ops       map[string]bool
+	valueSize int64
+	exclude   bool
+	redact    []string
+	transform Transform
+}
+
+// EventFilter applies the first event rule matching a change, changes no
+// rule matches are sent as they are.
+type EventFilter struct {
+	rules []eventRule
+}
+
+func NewEventFilter(confs []config.EventRule) (*EventFilter, error) {
+	f := &EventFilter{}
+	for _, conf := range confs {
+		r := eventRule{
+			prefix:    []byte(conf.Prefix),
+			valueSize: conf.ValueSize,
+			exclude:   conf.Action == "exclude",
+			redact:    conf.Redact,
+		}
+		if len(conf.Ops) > 0 {
+			r.ops = make(map[string]bool, len(conf.Ops))
+			for _, op := range conf.Ops {
+				r.ops[op] = true
+			}
+		}
+		if conf.Transform != "" {
+			t, ok := transforms[conf.Transform]
+			if !ok {
+				return nil, fmt.Errorf("transform %s not register", conf.Transform)
+			}
+			r.transform = t
+		}
+		f.rules = append(f.rules, r)
+	}
+	return f, nil
+}
+
+// eventOp returns the op of a change and the size of its value, the new one
+// or the old one of a delete.
+func eventOp(msg KeyEntry, l *Log) (string, int) {
+	if len(msg.Key) > 0 && msg.Key[0] == AuditType {
+		return EventAudit, len(msg.Entry)
+	}
+	switch {
+	case l.New == "":
+		return EventDelete, len(l.Old)
+	case l.Old == "":
+		return EventPut, len(l.New)
+	}
+	return EventUpdate, len(l.New)
+}
+
+// Apply returns the change to queue, false if it's excluded.
+func (f *EventFilter) Apply(msg KeyEntry) (KeyEntry, bool) {
+	if f == nil || len(f.rules) == 0 {
+		return msg, true
+	}
+	l := Log{}
+	isAudit := len(msg.Key) > 0 && msg.Key[0] == AuditType
+	if !isAudit && json.Unmarshal(msg.Entry, &l) != nil {
+		return msg, true
+	}
+	op, size := eventOp(msg, &l)
+	for _, r := range f.rules {
+		if !bytes.HasPrefix(msg.Key, r.prefix) ||
+			(r.ops != nil && !r.ops[op]) ||
+			int64(size) <= r.valueSize && r.valueSize > 0 {
+			continue
+		}
+		if r.exclude {
+			return msg, false
+		}
+		if len(r.redact) > 0 {
+			msg = redactEvent(msg, &l, isAudit, r.redact)
+		}
+		if r.transform != nil {
+			return r.transform(op, msg)
+		}
+		return msg, true
+	}
+	return msg, true
+}
+
+func redactEvent(msg KeyEntry, l *Log, isAudit bool, fields []string) KeyEntry {
+	if isAudit {
+		return KeyEntry{Key: msg.Key, Entry: redactJSON(msg.Entry, fields)}
+	}
+	r := Log{
+		Old: string(redactJSON([]byte(l.Old), fields)),
+		New: string(redactJSON([]byte(l.New), fields)),
+	}
+	entry, err := json.Marshal(r)
+	if err != nil {
+		return msg
+	}
+	return KeyEntry{Key: msg.Key, Entry: entry}
+}
+
+// redactJSON replaces the top level fields of a json object, other values
+// are left as they are.
+func redactJSON(value []byte, fields []string) []byte {
+	var obj map[string]json.RawMessage
+	if len(value) == 0 || json.Unmarshal(value, &obj) != nil {
+		return value
+	}
+	changed := false
+	for _, field := range fields {
+		if _, ok := obj[field]; ok {
+			obj[field] = json.RawMessage(`"` + redacted + `"`)
+			changed = true
+		}
+	}
+	if !changed {
+		return value
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return value
+	}
+	return b
+}
+
+// send queues a change to the connector and the watches unless the event
+// rules exclude it.
+func (s *Store) send(msg KeyEntry) {
+	msg, ok := s.filter.Apply(msg)
+	if !ok {
+		metric.EventExcluded.Inc()
+		return
+	}
+	if s.watchers != nil {
+		s.watchers.Publish(msg)
+	}
+	if s.connector != nil {
+		s.connector.Send(s.delta(msg))
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventFilter(t *testing.T) {
+	RegisterTransform("test-op", func(op string, msg KeyEntry) (KeyEntry, bool) {
+		return KeyEntry{Key: msg.Key, Entry: []byte(op)}, op != EventDelete
+	})
+	f, err := NewEventFilter([]config.EventRule{
+		{Prefix: "tmp/", Action: "exclude"},
+		{Ops: []string{"audit"}, Action: "exclude"},
+		{Ops: []string{"delete"}, Prefix: "log/", Action: "exclude"},
+		{ValueSize: 16, Action: "exclude"},
+		{Prefix: "user/", Action: "include", Redact: []string{"email"}},
+		{Prefix: "op/", Action: "include", Transform: "test-op"},
+	})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		key, entry string
+		want       string
+		ok         bool
+	}{
+		{"tmp/a", `{"old":"","new":"a"}`, "", false},
+		{"log/a", `{"old":"a","new":""}`, "", false},
+		{"log/a", `{"old":"","new":"a"}`, `{"old":"","new":"a"}`, true},
+		{"big", `{"old":"","new":"01234567890123456"}`, "", false},
+		{"user/a", `{"old":"","new":"{\"email\":\"a@b\"}"}`,
+			`{"old":"","new":"{\"email\":\"[redacted]\"}"}`, true},
+		{"op/a", `{"old":"a","new":"b"}`, "update", true},
+		{"op/a", `{"old":"a","new":""}`, "", false},
+		{string(AuditType) + "id", `{"method":"PUT"}`, "", false},
+		{"other", `invalid`, `invalid`, true},
+	}
+	for _, tt := range tests {
+		msg, ok := f.Apply(KeyEntry{Key: []byte(tt.key), Entry: []byte(tt.entry)})
+		assert.Equal(t, tt.ok, ok, tt.key)
+		if ok {
+			assert.Equal(t, tt.want, string(msg.Entry), tt.key)
+		}
+	}
+
+	_, err = NewEventFilter([]config.EventRule{{Action: "include", Transform: "missing"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/huangnauh/tirest/config"
+)
+
+// Hook is called around the store calls of the API, in the order of
+// server.hooks. OnBeforePut sees the value as sent, before the fields are
+// sealed, an empty one deletes the key, and an error refuses the write.
+// The unsafe puts aren't streamed with a hook and the changes applied
+// from another cluster aren't seen. OnAfterGet has the value read or the error,
+// the Reader of a stream isn't to be read. OnDelete has the range deleted
+// by a list delete, or an unsafe delete which doesn't count, at -1.
+type Hook interface {
+	OnBeforePut(ctx context.Context, key, val []byte) error
+	OnAfterGet(ctx context.Context, key []byte, v Value, err error)
+	OnDelete(ctx context.Context, start, end []byte, deleted int, err error)
+}
+
+// NopHook is embedded by a hook for the calls it leaves alone.
+type NopHook struct{}
+
+func (NopHook) OnBeforePut(ctx context.Context, key, val []byte) error            { return nil }
+func (NopHook) OnAfterGet(ctx context.Context, key []byte, v Value, err error)    {}
+func (NopHook) OnDelete(ctx context.Context, start, end []byte, n int, err error) {}
+
+type HookDriver interface {
+	Name() string
+	Open(conf *config.Config) (Hook, error)
+}
+
+var hDrivers = make(map[string]HookDriver)
+
+func RegisterHook(driver HookDriver) {
+	name := driver.Name()
+	if _, ok := hDrivers[name]; ok {
+		panic(fmt.Errorf("hook %s is already registered", name))
+	}
+
+	hDrivers[name] = driver
+}
+
+// openHooks opens the hooks of server.hooks, nil without any.
+func openHooks(conf *config.Config) ([]Hook, error) {
+	var hooks []Hook
+	for _, name := range conf.Server.Hooks {
+		hook, err := hDrivers[name].Open(conf)
+		if err != nil {
+			return nil, fmt.Errorf("open hook %s, %s", name, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+func (s *Store) beforePut(ctx context.Context, key, val []byte) error {
+	for _, h := range s.hooks {
+		if err := h.OnBeforePut(ctx, key, val); err != nil {
+			s.log.Warnf("key %s put refused by a hook, %s", key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) afterGet(ctx context.Context, key []byte, v Value, err error) {
+	for _, h := range s.hooks {
+		h.OnAfterGet(ctx, key, v, err)
+	}
+}
+
+func (s *Store) onDelete(ctx context.Context, start, end []byte, deleted int, err error) {
+	for _, h := range s.hooks {
+		h.OnDelete(ctx, start, end, deleted, err)
+	}
+}
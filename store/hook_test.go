@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var errRefused = errors.New("refused")
+
+// recordHook refuses the values starting with "x" and records the calls.
+type recordHook struct {
+	NopHook
+	gets    []string
+	deletes []int
+}
+
+func (h *recordHook) Name() string                           { return "record" }
+func (h *recordHook) Open(conf *config.Config) (Hook, error) { return h, nil }
+func (h *recordHook) OnAfterGet(ctx context.Context, key []byte, v Value, err error) {
+	h.gets = append(h.gets, string(key)+"="+string(v.Value))
+}
+
+func (h *recordHook) OnBeforePut(ctx context.Context, key, val []byte) error {
+	if strings.HasPrefix(string(val), "x") {
+		return errRefused
+	}
+	return nil
+}
+
+func (h *recordHook) OnDelete(ctx context.Context, start, end []byte, deleted int, err error) {
+	h.deletes = append(h.deletes, deleted)
+}
+
+func TestHooks(t *testing.T) {
+	hook := &recordHook{}
+	RegisterHook(hook)
+	conf := config.DefaultConfig()
+	conf.Server.Hooks = []string{"record"}
+	hooks, err := openHooks(conf)
+	assert.Nil(t, err)
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, hooks: hooks, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	assert.Nil(t, cas(s, "a", "", "1"))
+	assert.Equal(t, errRefused, cas(s, "a", "1", "x"))
+	assert.Equal(t, errRefused, s.UnsafePut(ctx, []byte("b"), []byte("x")))
+	assert.Equal(t, errRefused, s.BatchPut(ctx, []KeyEntry{{Key: []byte("b"), Entry: []byte("2")}, {Key: []byte("c"), Entry: []byte("x")}}))
+	assert.Equal(t, map[string]string{"a": "1"}, db.kv)
+
+	s.Get(ctx, []byte("a"), GetOption{})
+	s.Get(ctx, []byte("b"), GetOption{})
+	assert.Equal(t, []string{"a=1", "b="}, hook.gets)
+
+	_, _, err = s.BatchDelete(ctx, []byte("a"), []byte("b"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, s.UnsafeDelete(ctx, []byte("a"), []byte("b")))
+	assert.Equal(t, []int{1, -1}, hook.deletes)
+
+	// a large value isn't streamed past the hooks
+	conf.Blob.Threshold = 4
+	conf.Blob.ChunkSize = 3
+	s.blobs = &chunkStore{s: s}
+	assert.False(t, s.Streaming([]byte("b")))
+	assert.Equal(t, errRefused, s.UnsafePutStream(ctx, []byte("b"), strings.NewReader("xxxxxxxx")))
+	assert.Nil(t, s.UnsafePutStream(ctx, []byte("b"), strings.NewReader("yyyyyyyy")))
+	v, err := s.Get(ctx, []byte("b"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "yyyyyyyy", string(v.Value))
+}
@@ -0,0 +1,247 @@
+package store
+
+import (
+	"container/heap"
+	"hash/maphash"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/sketch"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	hotKeyCandidateFactor = 4
+	// hotKeyShards split the sketches and the candidates, a touch only
+	// locks the shard of its key
+	hotKeyShards = 16
+)
+
+type HotKey struct {
+	Key   []byte  `json:"key"`
+	Count uint64  `json:"count"`
+	QPS   float64 `json:"qps"`
+}
+
+// HotKeys estimates key access frequency over a sliding window, the window
+// is split into buckets and each bucket owns a count-min sketch. The keys
+// are sharded by hash, each shard keeps its candidates in a min heap.
+type HotKeys struct {
+	shards   []*hotKeyShard
+	seed     maphash.Seed
+	interval time.Duration
+	window   time.Duration
+	topN     int
+	gauge    *prometheus.GaugeVec
+	closed   chan struct{}
+}
+
+type hotKeyShard struct {
+	mu         sync.Mutex
+	buckets    []*sketch.CountMin
+	cur        int
+	limit      int
+	candidates map[string]*hotKeyCandidate
+	heap       hotKeyHeap
+}
+
+type hotKeyCandidate struct {
+	key   string
+	count uint64
+	index int
+}
+
+// hotKeyHeap is a min heap of the candidates by count.
+type hotKeyHeap []*hotKeyCandidate
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotKeyHeap) Push(x interface{}) {
+	c := x.(*hotKeyCandidate)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return c
+}
+
+func NewHotKeys(conf *config.HotKey) *HotKeys {
+	return newHotKeys(conf, metric.HotKeyQPS)
+}
+
+// newHotKeys sets the qps of the TopN keys by rank to gauge, if any.
+func newHotKeys(conf *config.HotKey, gauge *prometheus.GaugeVec) *HotKeys {
+	// a shard sees a share of the keys, so a share of the width keeps the error
+	width := (conf.Width + hotKeyShards - 1) / hotKeyShards
+	shards := make([]*hotKeyShard, hotKeyShards)
+	for i := range shards {
+		buckets := make([]*sketch.CountMin, conf.Buckets)
+		for j := range buckets {
+			buckets[j] = sketch.NewCountMin(width, conf.Depth)
+		}
+		shards[i] = &hotKeyShard{
+			buckets:    buckets,
+			limit:      conf.TopN * hotKeyCandidateFactor,
+			candidates: make(map[string]*hotKeyCandidate),
+		}
+	}
+	return &HotKeys{
+		shards:   shards,
+		seed:     maphash.MakeSeed(),
+		interval: conf.Window.Duration / time.Duration(conf.Buckets),
+		window:   conf.Window.Duration,
+		topN:     conf.TopN,
+		gauge:    gauge,
+		closed:   make(chan struct{}),
+	}
+}
+
+func (h *HotKeys) Start() {
+	go h.run()
+}
+
+func (h *HotKeys) Close() {
+	close(h.closed)
+}
+
+func (h *HotKeys) run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.closed:
+			return
+		case <-ticker.C:
+			h.rotate()
+		}
+	}
+}
+
+func (h *HotKeys) shard(key []byte) *hotKeyShard {
+	var mh maphash.Hash
+	mh.SetSeed(h.seed)
+	mh.Write(key)
+	return h.shards[mh.Sum64()%hotKeyShards]
+}
+
+func (sh *hotKeyShard) estimate(key []byte) uint64 {
+	var count uint64
+	for _, b := range sh.buckets {
+		count += b.Estimate(key)
+	}
+	return count
+}
+
+func (h *HotKeys) Touch(key []byte) {
+	sh := h.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.buckets[sh.cur].Add(key, 1)
+	count := sh.estimate(key)
+
+	if c, ok := sh.candidates[utils.B2S(key)]; ok {
+		c.count = count
+		heap.Fix(&sh.heap, c.index)
+		return
+	}
+	if len(sh.heap) < sh.limit {
+		c := &hotKeyCandidate{key: string(key), count: count}
+		sh.candidates[c.key] = c
+		heap.Push(&sh.heap, c)
+		return
+	}
+	// the least counted candidate is replaced
+	if min := sh.heap[0]; count > min.count {
+		delete(sh.candidates, min.key)
+		min.key, min.count = string(key), count
+		sh.candidates[min.key] = min
+		heap.Fix(&sh.heap, 0)
+	}
+}
+
+func (sh *hotKeyShard) rotate() {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.cur = (sh.cur + 1) % len(sh.buckets)
+	sh.buckets[sh.cur].Reset()
+	kept := sh.heap[:0]
+	for _, c := range sh.heap {
+		c.count = sh.estimate(utils.S2B(c.key))
+		if c.count == 0 {
+			delete(sh.candidates, c.key)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	for i := len(kept); i < len(sh.heap); i++ {
+		sh.heap[i] = nil
+	}
+	sh.heap = kept
+	for i, c := range sh.heap {
+		c.index = i
+	}
+	heap.Init(&sh.heap)
+}
+
+func (h *HotKeys) rotate() {
+	for _, sh := range h.shards {
+		sh.rotate()
+	}
+
+	if h.gauge == nil {
+		return
+	}
+	top := h.Top(h.topN)
+	for i := 0; i < h.topN; i++ {
+		qps := 0.0
+		if i < len(top) {
+			qps = top[i].QPS
+		}
+		h.gauge.WithLabelValues(strconv.Itoa(i + 1)).Set(qps)
+	}
+}
+
+func (h *HotKeys) Top(n int) []HotKey {
+	keys := make([]HotKey, 0)
+	for _, sh := range h.shards {
+		sh.mu.Lock()
+		for _, c := range sh.heap {
+			keys = append(keys, HotKey{
+				Key:   []byte(c.key),
+				Count: c.count,
+				QPS:   float64(c.count) / h.window.Seconds(),
+			})
+		}
+		sh.mu.Unlock()
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Count > keys[j].Count
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func (s *Store) HotKeys(n int) []HotKey {
+	if s.hotKeys == nil {
+		return nil
+	}
+	return s.hotKeys.Top(n)
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHotKeys(t *testing.T) {
+	conf := config.DefaultConfig().HotKey
+	conf.TopN = 2
+	h := newHotKeys(&conf, nil)
+
+	// the hot keys push the cold ones out of the candidates
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				h.Touch([]byte(fmt.Sprintf("cold-%d-%d", w, i)))
+				h.Touch([]byte("hot-a"))
+				if i%2 == 0 {
+					h.Touch([]byte("hot-b"))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	top := h.Top(2)
+	assert.Equal(t, 2, len(top))
+	assert.Equal(t, "hot-a", string(top[0].Key))
+	assert.True(t, top[0].Count >= 8000)
+	assert.Equal(t, "hot-b", string(top[1].Key))
+	assert.True(t, top[1].Count >= 4000)
+
+	// the keys leave once their buckets are rotated out
+	for i := 0; i < conf.Buckets; i++ {
+		h.rotate()
+	}
+	assert.Equal(t, 0, len(h.Top(0)))
+}
+
+func BenchmarkHotKeysTouch(b *testing.B) {
+	conf := config.DefaultConfig().HotKey
+	h := newHotKeys(&conf, nil)
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%d", i))
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			h.Touch(keys[i%len(keys)])
+			i++
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// HotRegion is a region the keys written the most fall in, QPS is theirs
+// summed and Keys are in the order stored, salted under a [[salt]] prefix.
+// SplitKey leaves about half of the qps on each side, it's missing when a
+// single key is hot as a split can't spread it.
+type HotRegion struct {
+	Region   KeyRegion
+	QPS      float64
+	Hot      bool
+	Keys     []HotKey
+	SplitKey []byte
+}
+
+// splitKey is the hot key after which the qps of the keys before it is the
+// closest to half, keys is in order.
+func splitKey(keys []HotKey) []byte {
+	var total float64
+	for _, k := range keys {
+		total += k.QPS
+	}
+	var split []byte
+	left, best := keys[0].QPS, math.Inf(1)
+	for _, k := range keys[1:] {
+		if d := math.Abs(total - 2*left); d < best {
+			split, best = k.Key, d
+		}
+		left += k.QPS
+	}
+	return split
+}
+
+// HotRegions places the keys written the most in their regions, the
+// hottest region first, and suggests where to split the hot ones.
+func (s *Store) HotRegions(ctx context.Context) ([]HotRegion, error) {
+	if s.hotWrites == nil {
+		return nil, xerror.ErrNotSupported
+	}
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	locator, ok := baseDB(s.db).(KeyLocator)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	byID := make(map[uint64]*HotRegion)
+	var regions []*HotRegion
+	for _, k := range s.hotWrites.Top(s.conf.HotRegion.TopN) {
+		r, err := locator.LocateKey(ctx, s.salts.key(k.Key))
+		if err != nil {
+			s.log.Errorf("locate %q failed, %s", k.Key, err)
+			return nil, contextError(ctx, err)
+		}
+		hot, ok := byID[r.ID]
+		if !ok {
+			hot = &HotRegion{Region: *r}
+			byID[r.ID] = hot
+			regions = append(regions, hot)
+		}
+		hot.QPS += k.QPS
+		hot.Keys = append(hot.Keys, k)
+	}
+
+	res := make([]HotRegion, 0, len(regions))
+	for _, r := range regions {
+		r.Hot = r.QPS >= s.conf.HotRegion.MinQPS
+		sort.Slice(r.Keys, func(i, j int) bool {
+			return bytes.Compare(s.salts.key(r.Keys[i].Key), s.salts.key(r.Keys[j].Key)) < 0
+		})
+		if r.Hot {
+			r.SplitKey = splitKey(r.Keys)
+		}
+		res = append(res, *r)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].QPS > res[j].QPS
+	})
+	return res, nil
+}
+
+func (s *Store) runHotRegions() {
+	ticker := time.NewTicker(s.conf.HotRegion.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.checkHotRegions()
+		}
+	}
+}
+
+// checkHotRegions splits up to hot-region.max-splits of the hottest regions
+// with hot-region.auto-split, the new regions are scattered by PD.
+func (s *Store) checkHotRegions() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.HotRegion.Interval.Duration)
+	defer cancel()
+	regions, err := s.HotRegions(ctx)
+	if err != nil {
+		return
+	}
+	hot, splits := 0, 0
+	for _, r := range regions {
+		if !r.Hot {
+			continue
+		}
+		hot++
+		if !s.conf.HotRegion.AutoSplit || r.SplitKey == nil || splits >= s.conf.HotRegion.MaxSplits {
+			continue
+		}
+		splits++
+		_, err := s.PreSplit(ctx, [][]byte{s.salts.key(r.SplitKey)}, true, false)
+		if err != nil {
+			metric.HotRegionSplit.WithLabelValues("failed").Inc()
+			continue
+		}
+		metric.HotRegionSplit.WithLabelValues("ok").Inc()
+		s.log.Infof("split hot region %d at %q, %.1f qps", r.Region.ID, r.SplitKey, r.QPS)
+	}
+	metric.HotRegions.Set(float64(hot))
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// locatorDB has a region from "" to "m" and one from "m" on.
+type locatorDB struct {
+	memDB
+}
+
+func (d *locatorDB) LocateKey(ctx context.Context, key []byte) (*KeyRegion, error) {
+	if strings.Compare(string(key), "m") < 0 {
+		return &KeyRegion{ID: 1, End: []byte("m")}, nil
+	}
+	return &KeyRegion{ID: 2, Start: []byte("m")}, nil
+}
+
+func TestHotRegions(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.HotRegion.Enable = true
+	conf.HotRegion.Window = &config.Duration{Duration: time.Second}
+	conf.HotRegion.Buckets = 1
+	conf.HotRegion.MinQPS = 10
+	s := &Store{state: int32(StateReady), conf: conf, log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	s.db = &locatorDB{memDB{kv: map[string]string{}}}
+	s.hotWrites = newHotKeys(conf.HotRegion.HotKey(), nil)
+
+	touch := func(key string, n int) {
+		for i := 0; i < n; i++ {
+			s.hotWrites.Touch([]byte(key))
+		}
+	}
+	touch("c", 6)
+	touch("a", 6)
+	touch("e", 4)
+	touch("x", 9)
+	regions, err := s.HotRegions(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, regions, 2)
+	assert.Equal(t, uint64(1), regions[0].Region.ID)
+	assert.Equal(t, 16.0, regions[0].QPS)
+	assert.True(t, regions[0].Hot)
+	assert.Equal(t, []byte("c"), regions[0].Keys[1].Key)
+	assert.Equal(t, []byte("c"), regions[0].SplitKey)
+
+	// a single hot key can't be split
+	touch("x", 1)
+	regions, err = s.HotRegions(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), regions[1].Region.ID)
+	assert.True(t, regions[1].Hot)
+	assert.Nil(t, regions[1].SplitKey)
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// IdempotencyType prefixes idempotency records, meta keys use 0x00.
+const IdempotencyType byte = 0x01
+
+const idempotencySweepLimit = 1000
+
+// IdempotencyRecord is the outcome of a write, Status is 0 while the first
+// request is still running. BodyDigest is only known once the body is read.
+type IdempotencyRecord struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Digest      string `json:"digest"`
+	BodyDigest  string `json:"body_digest,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	ExpireAt    int64  `json:"expire_at"`
+}
+
+func (r *IdempotencyRecord) expired(now time.Time) bool {
+	return r.ExpireAt <= now.UnixNano()
+}
+
+// records are put in buckets of one window, so the expired buckets can be
+// dropped with a range delete: type | bucket | key.
+func (s *Store) idempotencyBucket(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(s.conf.Server.IdempotencyWindow.Duration))
+}
+
+func idempotencyKey(bucket uint64, key string) []byte {
+	k := make([]byte, 9+len(key))
+	k[0] = IdempotencyType
+	binary.BigEndian.PutUint64(k[1:9], bucket)
+	copy(k[9:], key)
+	return k
+}
+
+func decodeIdempotencyRecord(val []byte) (*IdempotencyRecord, error) {
+	r := &IdempotencyRecord{}
+	err := json.Unmarshal(val, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *Store) getIdempotency(ctx context.Context, key []byte, now time.Time) (*IdempotencyRecord, error) {
+	v, err := s.db.Get(ctx, key, GetOption{})
+	if errors.Is(err, xerror.ErrNotExists) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	r, err := decodeIdempotencyRecord(v.Value)
+	if err != nil || r.expired(now) {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ReserveIdempotency stores rec unless a live record exists for key,
+// the existing record is returned in that case. Otherwise it returns the
+// reserved record key for SaveIdempotency or ReleaseIdempotency, the bucket
+// may have changed by the time the request is done.
+func (s *Store) ReserveIdempotency(ctx context.Context, key string, rec *IdempotencyRecord) (*IdempotencyRecord, []byte, error) {
+	if err := s.usable(); err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	rec.ExpireAt = now.Add(s.conf.Server.IdempotencyWindow.Duration).UnixNano()
+	bucket := s.idempotencyBucket(now)
+
+	prev, err := s.getIdempotency(ctx, idempotencyKey(bucket-1, key), now)
+	if err != nil || prev != nil {
+		return prev, nil, err
+	}
+
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	reserved := idempotencyKey(bucket, key)
+	err = s.db.CheckAndPut(ctx, reserved, nil, val, CheckOption{
+		Check: func(_, newVal, existVal []byte) ([]byte, error) {
+			if len(existVal) == 0 {
+				return newVal, nil
+			}
+			r, err := decodeIdempotencyRecord(existVal)
+			if err != nil || r.expired(now) {
+				return newVal, nil
+			}
+			return nil, xerror.ErrCheckAndSetFailed
+		},
+	})
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		prev, err = decodeIdempotencyRecord(conflict.Value)
+		return prev, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, reserved, nil
+}
+
+// SaveIdempotency replaces the reservation with the outcome.
+func (s *Store) SaveIdempotency(ctx context.Context, reserved []byte, rec *IdempotencyRecord) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(ctx, reserved, val)
+}
+
+// ReleaseIdempotency drops the reservation, so the write can be retried.
+func (s *Store) ReleaseIdempotency(ctx context.Context, reserved []byte) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	return s.db.Put(ctx, reserved, nil)
+}
+
+func (s *Store) sweepIdempotency() {
+	if s.usable() != nil {
+		return
+	}
+	// the previous bucket is still looked up
+	start := []byte{IdempotencyType}
+	end := idempotencyKey(s.idempotencyBucket(time.Now())-1, "")
+	count := 0
+	for {
+		lastKey, deleted, err := s.db.BatchDelete(WithPriority(context.Background(), PriorityBackground), start, end,
+			idempotencySweepLimit)
+		if err != nil {
+			s.log.Errorf("sweep idempotency records failed, %s", err)
+			return
+		}
+		count += deleted
+		if deleted < idempotencySweepLimit {
+			break
+		}
+		start = lastKey
+	}
+	if count > 0 {
+		s.log.Infof("sweep %d idempotency records", count)
+	}
+}
+
+func (s *Store) runIdempotencySweeper() {
+	ticker := time.NewTicker(s.conf.Server.IdempotencyWindow.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.sweepIdempotency()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyBucket(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Server.IdempotencyWindow = &config.Duration{Duration: time.Second}
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	// reserve near the end of a bucket
+	for time.Now().UnixNano()%int64(time.Second) < int64(900*time.Millisecond) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec := &IdempotencyRecord{Digest: "d"}
+	prev, reserved, err := s.ReserveIdempotency(ctx, "id", rec)
+	assert.Nil(t, err)
+	assert.Nil(t, prev)
+	bucket := binary.BigEndian.Uint64(reserved[1:9])
+
+	// the request is done in the next bucket, the reservation is replaced anyway
+	for s.idempotencyBucket(time.Now()) == bucket {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec.Status = http.StatusNoContent
+	assert.Nil(t, s.SaveIdempotency(ctx, reserved, rec))
+	assert.Len(t, db.kv, 1)
+	prev, reserved, err = s.ReserveIdempotency(ctx, "id", &IdempotencyRecord{Digest: "d"})
+	assert.Nil(t, err)
+	assert.Nil(t, reserved)
+	assert.Equal(t, http.StatusNoContent, prev.Status)
+
+	_, reserved, err = s.ReserveIdempotency(ctx, "other", &IdempotencyRecord{Digest: "d"})
+	assert.Nil(t, err)
+	assert.Nil(t, s.ReleaseIdempotency(ctx, reserved))
+	assert.Len(t, db.kv, 1)
+}
@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+const (
+	FormatJSON = "json"
+	FormatAvro = "avro"
+
+	changeRecord = "tirest.Change"
+	// changeSchema is the value of a change event, an audit record is sent
+	// with an empty old value and the record as the new one.
+	changeSchema = `{"type":"record","name":"Change","namespace":"tirest","fields":[` +
+		`{"name":"key","type":"bytes"},` +
+		`{"name":"old","type":"string"},` +
+		`{"name":"new","type":"string"},` +
+		`{"name":"time","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+		`{"name":"seq","type":"long","default":0}]}`
+)
+
+// ValueEncoder turns the change log of a queue message into the kafka value,
+// at is the enqueue time.
+type ValueEncoder interface {
+	Encode(key, entry []byte, at time.Time) (sarama.Encoder, error)
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) Encode(key, entry []byte, at time.Time) (sarama.Encoder, error) {
+	return sarama.ByteEncoder(entry), nil
+}
+
+// avroEncoder writes the confluent wire format, a zero byte, the schema id
+// and the avro binary record.
+type avroEncoder struct {
+	id int32
+}
+
+// NewValueEncoder registers the schema of the avro format.
+func NewValueEncoder(conf *config.Connector) (ValueEncoder, error) {
+	if conf.Format != FormatAvro {
+		return jsonEncoder{}, nil
+	}
+	subject, err := subjectName(conf.SubjectStrategy, conf.Topic)
+	if err != nil {
+		return nil, err
+	}
+	id, err := registerSchema(conf.SchemaRegistry, subject, changeSchema)
+	if err != nil {
+		return nil, err
+	}
+	return avroEncoder{id: id}, nil
+}
+
+func subjectName(strategy, topic string) (string, error) {
+	switch strategy {
+	case "", "topic":
+		return topic + "-value", nil
+	case "record":
+		return changeRecord, nil
+	case "topic-record":
+		return topic + "-" + changeRecord, nil
+	}
+	return "", fmt.Errorf("unknown subject strategy %q", strategy)
+}
+
+func (e avroEncoder) Encode(key, entry []byte, at time.Time) (sarama.Encoder, error) {
+	l := store.Log{}
+	if len(key) == 0 || key[0] == store.AuditType || json.Unmarshal(entry, &l) != nil {
+		l = store.Log{New: string(entry)}
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+	b := make([]byte, 5, 5+len(key)+len(l.Old)+len(l.New)+5*binary.MaxVarintLen64)
+	binary.BigEndian.PutUint32(b[1:5], uint32(e.id))
+	b = appendAvroBytes(b, key)
+	b = appendAvroBytes(b, []byte(l.Old))
+	b = appendAvroBytes(b, []byte(l.New))
+	b = appendAvroLong(b, at.UnixNano()/int64(time.Millisecond))
+	b = appendAvroLong(b, int64(l.Seq))
+	return sarama.ByteEncoder(b), nil
+}
+
+// appendAvroLong writes a zigzag varint.
+func appendAvroLong(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64((v<<1)^(v>>63)))
+	return append(b, buf[:n]...)
+}
+
+func appendAvroBytes(b, data []byte) []byte {
+	b = appendAvroLong(b, int64(len(data)))
+	return append(b, data...)
+}
+
+var registryClient = &http.Client{Timeout: 10 * time.Second}
+
+// registerSchema returns the id of the schema under the subject, the
+// registry returns the existing id for a schema registered before.
+func registerSchema(registry, subject, schema string) (int32, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	u := strings.TrimRight(registry, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("register schema of %s: %s %s", subject, resp.Status, body)
+	}
+	var r struct {
+		ID int32 `json:"id"`
+	}
+	if err = json.Unmarshal(body, &r); err != nil {
+		return 0, err
+	}
+	return r.ID, nil
+}
@@ -0,0 +1,69 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func readAvroBytes(t *testing.T, b []byte) (string, []byte) {
+	n, size := binary.Varint(b)
+	assert.True(t, size > 0)
+	b = b[size:]
+	return string(b[:n]), b[n:]
+}
+
+func TestAvroEncoder(t *testing.T) {
+	var path string
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Contains(t, string(body), "tirest")
+		w.Write([]byte(`{"id":7}`))
+	}))
+	defer registry.Close()
+
+	conf := config.DefaultConfig().Connector
+	conf.Topic = "changes"
+	conf.Format = FormatAvro
+	conf.SchemaRegistry = registry.URL
+	conf.SubjectStrategy = "topic-record"
+	e, err := NewValueEncoder(&conf)
+	assert.Nil(t, err)
+	assert.Equal(t, "/subjects/changes-tirest.Change/versions", path)
+
+	at := time.Unix(1600000000, 0)
+	tests := []struct {
+		key, entry, old, new string
+		seq                  int64
+	}{
+		{"key", `{"old":"a","new":"b"}`, "a", "b", 0},
+		{"key", `{"old":"b","new":"c","seq":3}`, "b", "c", 3},
+		{string(store.AuditType) + "audit", `{"method":"PUT"}`, "", `{"method":"PUT"}`, 0},
+	}
+	for _, tt := range tests {
+		v, err := e.Encode([]byte(tt.key), []byte(tt.entry), at)
+		assert.Nil(t, err)
+		b, err := v.Encode()
+		assert.Nil(t, err)
+		assert.Equal(t, byte(0), b[0])
+		assert.Equal(t, uint32(7), binary.BigEndian.Uint32(b[1:5]))
+		key, b := readAvroBytes(t, b[5:])
+		old, b := readAvroBytes(t, b)
+		new, b := readAvroBytes(t, b)
+		ms, n := binary.Varint(b)
+		seq, _ := binary.Varint(b[n:])
+		assert.Equal(t, tt.key, key)
+		assert.Equal(t, tt.old, old)
+		assert.Equal(t, tt.new, new)
+		assert.Equal(t, at.Unix()*1000, ms)
+		assert.Equal(t, tt.seq, seq)
+	}
+}
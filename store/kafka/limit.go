@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	PolicyBlock      = "block"
+	PolicyDropOldest = "drop-oldest"
+	PolicyDropNewest = "drop-newest"
+
+	blockInterval = 100 * time.Millisecond
+)
+
+// exceeded reports whether writing size more bytes goes over max-queue-bytes,
+// or the oldest unread message is older than max-queue-age.
+func (c *Connector) exceeded(size int64) bool {
+	conf := c.conf.Connector
+	if conf.MaxQueueBytes > 0 && atomic.LoadInt64(&c.pending)+size > conf.MaxQueueBytes {
+		return true
+	}
+	return conf.MaxQueueAge.Duration > 0 && c.lag(c.queue.Depth()) > conf.MaxQueueAge.Duration
+}
+
+func (c *Connector) setFull(full bool) {
+	var v int32
+	if full {
+		v = 1
+	}
+	if atomic.SwapInt32(&c.full, v) == v {
+		return
+	}
+	metric.QueueFull.Set(float64(v))
+	if full {
+		c.log.Warnf("queue full, %d bytes, lag %s, %s", atomic.LoadInt64(&c.pending),
+			c.lag(c.queue.Depth()), c.conf.Connector.FullPolicy)
+	} else {
+		c.log.Info("queue is not full any more")
+	}
+}
+
+// admit applies full-policy before a message of size bytes is queued, it
+// returns false if the message is dropped. Blocked writes are let through
+// on Close, the queue is about to stop anyway.
+func (c *Connector) admit(size int64) bool {
+	if !c.exceeded(size) {
+		c.setFull(false)
+		return true
+	}
+	c.setFull(true)
+	switch c.conf.Connector.FullPolicy {
+	case PolicyDropNewest:
+		metric.Dropped.WithLabelValues("newest").Inc()
+		return false
+	case PolicyDropOldest:
+		select {
+		case c.overflow <- struct{}{}:
+		default:
+		}
+		return true
+	}
+	ticker := time.NewTicker(blockInterval)
+	defer ticker.Stop()
+	for c.exceeded(size) {
+		select {
+		case <-ticker.C:
+		case <-c.closed:
+			return true
+		}
+	}
+	c.setFull(false)
+	return true
+}
+
+// expired reports whether the producer drops a message read from the queue,
+// with drop-oldest while the queue is over its limits, or older than
+// max-queue-age.
+func (c *Connector) expired(at time.Time) bool {
+	if c.conf.Connector.FullPolicy != PolicyDropOldest {
+		return false
+	}
+	maxAge := c.conf.Connector.MaxQueueAge.Duration
+	if maxAge > 0 && !at.IsZero() && time.Since(at) > maxAge {
+		return true
+	}
+	maxBytes := c.conf.Connector.MaxQueueBytes
+	return maxBytes > 0 && atomic.LoadInt64(&c.pending) > maxBytes
+}
+
+func (c *Connector) dropOldest(msg *sarama.ProducerMessage) bool {
+	d, ok := msg.Metadata.(*delivery)
+	if !ok || !c.expired(d.at) {
+		return false
+	}
+	metric.Dropped.WithLabelValues("oldest").Inc()
+	c.ack(d)
+	return true
+}
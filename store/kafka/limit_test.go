@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func queueEntries(t *testing.T, c *Connector, from, to int, depth int64) {
+	for i := from; i < to; i++ {
+		assert.Nil(t, c.Send(store.KeyEntry{
+			Key:   []byte(fmt.Sprintf("key-%d", i)),
+			Entry: []byte(fmt.Sprintf("entry-%d", i)),
+		}))
+	}
+	for i := 0; i < 200 && (len(c.writeChan) > 0 || c.queue.Depth() < depth); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, depth, c.queue.Depth())
+}
+
+func TestConnectorQueueLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conf.Connector.BatchSize = 4
+
+	// the messages of the test have the same size
+	conf.Connector.FullPolicy = PolicyDropNewest
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+	queueEntries(t, c, 10, 11, 1)
+	size := atomic.LoadInt64(&c.pending)
+	c.conf.Connector.MaxQueueBytes = 3 * size
+	queueEntries(t, c, 11, 20, 3)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&c.full))
+	c.Close()
+
+	// the producer drops what's over the limit when it reads the queue
+	conf.Connector.FullPolicy = PolicyDropOldest
+	conf.Connector.MaxQueueBytes = 0
+	conn, err = Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c = conn.(*Connector)
+	queueEntries(t, c, 20, 30, 13)
+	c.conf.Connector.MaxQueueBytes = 3 * size
+	c.producer = expectEntries(newMockProducer(t), 26, 30)
+	go c.runProducer()
+	for i := 0; i < 200 && c.queue.Depth() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close()
+}
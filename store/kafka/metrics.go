@@ -8,6 +8,27 @@ import (
 type Metric struct {
 	Queue prometheus.Gauge
 	Chan  prometheus.Gauge
+	// Corrupted counts the queue messages dropped by the producer.
+	Corrupted prometheus.Counter
+	Batch     prometheus.Histogram
+	// QueueBytes is the size of the unread messages, Lag the age of the
+	// last message read while more are waiting.
+	QueueBytes     prometheus.Gauge
+	Lag            prometheus.Gauge
+	ProducerErrors prometheus.Counter
+	// Requeued counts the failed messages put back to the queue.
+	Requeued prometheus.Counter
+	// Aborted counts the transactions aborted and sent again.
+	Aborted  prometheus.Counter
+	Delivery prometheus.Histogram
+	// Dropped counts the messages dropped by full-policy, QueueFull is 1
+	// while the queue is over its limits.
+	Dropped   *prometheus.CounterVec
+	QueueFull prometheus.Gauge
+	// Replica counts the changes of another cluster by result, ReplicaLag
+	// is the age of the last one applied.
+	Replica    *prometheus.CounterVec
+	ReplicaLag prometheus.Gauge
 }
 
 var metric = newMetric()
@@ -24,11 +45,75 @@ func newMetric() *Metric {
 			Name:      "connector_chan_depth",
 			Help:      "Connector chan depth.",
 		}),
+		Corrupted: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "connector_queue_corrupted_total",
+			Help:      "Corrupted connector queue messages.",
+		}),
+		Batch: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: version.APP,
+			Name:      "connector_batch_size",
+			Help:      "Queue messages handed to the producer per batch.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		QueueBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "connector_queue_bytes",
+			Help:      "Connector queue bytes not read yet.",
+		}),
+		Lag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "connector_lag_seconds",
+			Help:      "Connector lag behind the writes.",
+		}),
+		ProducerErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "connector_producer_errors_total",
+			Help:      "Messages the producer failed to deliver.",
+		}),
+		Requeued: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "connector_requeued_total",
+			Help:      "Messages put back to the queue after the producer failed.",
+		}),
+		Aborted: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "connector_txn_aborted_total",
+			Help:      "Producer transactions aborted and sent again.",
+		}),
+		Delivery: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: version.APP,
+			Name:      "connector_delivery_seconds",
+			Help:      "Time from the enqueue to the kafka ack.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+		}),
+		Dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "connector_dropped_total",
+			Help:      "Messages dropped by the full policy, the newest or the oldest.",
+		}, []string{"message"}),
+		QueueFull: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "connector_queue_full",
+			Help:      "1 while the connector queue is over max-queue-bytes or max-queue-age.",
+		}),
+		Replica: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "replica_changes_total",
+			Help:      "Changes of another cluster applied, stale, skipped or failed.",
+		}, []string{"result"}),
+		ReplicaLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "replica_lag_seconds",
+			Help:      "Age of the last change of another cluster applied.",
+		}),
 	}
 }
 
 func (m *Metric) mustRegister() {
-	prometheus.MustRegister(m.Queue, m.Chan)
+	prometheus.MustRegister(m.Queue, m.Chan, m.Corrupted, m.Batch,
+		m.QueueBytes, m.Lag, m.ProducerErrors, m.Requeued, m.Aborted, m.Delivery, m.Dropped, m.QueueFull,
+		m.Replica, m.ReplicaLag)
 }
 
 func init() {
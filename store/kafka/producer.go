@@ -2,8 +2,10 @@ package kafka
 
 import (
 	"bytes"
-	"encoding/binary"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -13,6 +15,7 @@ import (
 	"github.com/huangnauh/tirest/log"
 	"github.com/huangnauh/tirest/store"
 	"github.com/huangnauh/tirest/version"
+	"github.com/huangnauh/tirest/xerror"
 )
 
 const (
@@ -24,11 +27,39 @@ type Connector struct {
 	producer  sarama.AsyncProducer
 	log       *logrus.Entry
 	queue     diskqueue.Interface
-	writeBuf  bytes.Buffer
 	writeChan chan store.KeyEntry
 	closed    chan struct{}
-	conf      *config.Config
-	cfg       *sarama.Config
+	done      chan struct{}
+	flushed   chan struct{}
+	closeOnce sync.Once
+	// readPos mirrors the read position of the diskqueue, messages before
+	// skipTo were sent before a restart.
+	readPos   store.ReplayPosition
+	skipTo    store.ReplayPosition
+	archived  int64
+	cpMu      sync.Mutex
+	cp        store.ReplayPosition
+	replaying int32
+	replayWg  sync.WaitGroup
+	// pending is the size of the unread messages, headTime the enqueue
+	// time of the last message read
+	pending  int64
+	headTime int64
+	stats    producerStats
+	full     int32
+	overflow chan struct{}
+	encoder  ValueEncoder
+	conf     *config.Config
+	cfg      *sarama.Config
+
+	// ackPos is the position the messages before have been acked,
+	// inflight the messages read after it, both under cpMu
+	ackPos   store.ReplayPosition
+	inflight []*delivery
+	// sendMu is held by Send across the enqueue, so a message Send accepts
+	// is in writeChan before runQueue drains it
+	sendMu     sync.RWMutex
+	sendClosed bool
 	//TODO: metrics
 }
 
@@ -53,6 +84,21 @@ func (d Driver) Open(conf *config.Config) (store.Connector, error) {
 		l.Errorf("Failed to mkdir, %s", err)
 		return nil, err
 	}
+	// read before the diskqueue starts and rewrites it
+	meta, err := ReadQueueMeta(conf.Connector.QueueDataPath)
+	if err != nil && !os.IsNotExist(err) {
+		l.Errorf("read queue meta failed, %s", err)
+	}
+	cp, cpErr := ReadCheckpoint(conf.Connector.QueueDataPath)
+	if cpErr != nil && !os.IsNotExist(cpErr) {
+		l.Errorf("read checkpoint failed, %s", cpErr)
+	}
+	if conf.Connector.ReplayRetention.Duration > 0 {
+		if err := os.MkdirAll(filepath.Join(conf.Connector.QueueDataPath, replayDir), 0755); err != nil {
+			l.Errorf("Failed to mkdir, %s", err)
+			return nil, err
+		}
+	}
 	queue := diskqueue.New(version.APP, conf.Connector.QueueDataPath,
 		conf.Connector.MaxBytesPerFile, 4, conf.Connector.MaxMsgSize,
 		conf.Connector.SyncEvery, conf.Connector.SyncTimeout.Duration, log.NewLogFunc(l))
@@ -63,7 +109,23 @@ func (d Driver) Open(conf *config.Config) (store.Connector, error) {
 		writeChan: make(chan store.KeyEntry, MaxMessage),
 		conf:      conf,
 		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+		flushed:   make(chan struct{}),
+		overflow:  make(chan struct{}, 1),
+		readPos:   store.ReplayPosition{FileNum: meta.ReadFileNum, Pos: meta.ReadPos},
+		archived:  -1,
+		pending:   PendingBytes(conf.Connector.QueueDataPath, meta),
+		encoder:   jsonEncoder{},
+	}
+	conn.cp = conn.readPos
+	write := store.ReplayPosition{FileNum: meta.WriteFileNum, Pos: meta.WritePos}
+	if cpErr == nil && !write.Before(cp) {
+		// the metadata is synced less often than the checkpoint, the
+		// producer skips or resends the difference
+		conn.cp = cp
+		conn.skipTo = cp
 	}
+	conn.ackPos = conn.cp
 
 	go conn.runQueue()
 	go conn.runMetrics()
@@ -85,6 +147,7 @@ func (d Driver) Open(conf *config.Config) (store.Connector, error) {
 			return conf.Connector.MaxBackOff.Duration
 		}
 		c.ClientID = version.APP
+		SetAuth(c, conf.Connector.SASLUser, conf.Connector.SASLPassword, conf.Connector.TLS)
 		c.Metadata.Full = conf.Connector.FetchMetadata
 		c.Metadata.Retry.Max = conf.Connector.Retry
 		c.Metadata.Retry.BackoffFunc = backoff
@@ -93,12 +156,37 @@ func (d Driver) Open(conf *config.Config) (store.Connector, error) {
 		if err != nil {
 			return nil, err
 		}
+		conn.encoder, err = NewValueEncoder(&conf.Connector)
+		if err != nil {
+			l.Errorf("Failed to create %s encoder, %s", conf.Connector.Format, err)
+			return nil, err
+		}
 
-		if conf.Connector.DebugProducer {
-			c.Producer.Return.Successes = true
+		// for the delivery latency
+		c.Producer.Return.Successes = true
+		c.Producer.RequiredAcks = sarama.WaitForLocal // Only wait for the leader to ack
+		if conf.Connector.Idempotent {
+			// the broker drops the duplicates of retried batches, it needs
+			// all replicas to ack and one request in flight
+			c.Producer.Idempotent = true
+			c.Producer.RequiredAcks = sarama.WaitForAll
+			c.Net.MaxOpenRequests = 1
+		}
+		if conf.Connector.TransactionalID != "" {
+			// the queue position is committed with the messages, kafka's
+			// one is newer than the local checkpoint after a crash
+			c.Producer.Transaction.ID = conf.Connector.TransactionalID
+			cp, ok, err := conn.readTxnCheckpoint()
+			if err != nil {
+				l.Errorf("read transaction checkpoint failed, %s", err)
+				return nil, err
+			}
+			if ok && !write.Before(cp) {
+				conn.cp, conn.skipTo, conn.ackPos = cp, cp, cp
+			}
 		}
-		c.Producer.RequiredAcks = sarama.WaitForLocal       // Only wait for the leader to ack
-		c.Producer.Flush.Frequency = 500 * time.Millisecond // Flush batches every 500ms
+		c.Producer.Flush.Frequency = conf.Connector.BatchTimeout.Duration
+		c.Producer.Flush.Messages = conf.Connector.BatchSize
 		c.Producer.Retry.Max = conf.Connector.Retry
 		c.Producer.Retry.BackoffFunc = backoff
 		producer, err := sarama.NewAsyncProducer(conf.Connector.BrokerList, c)
@@ -112,6 +200,18 @@ func (d Driver) Open(conf *config.Config) (store.Connector, error) {
 	return conn, nil
 }
 
+// SetAuth logs in with SASL/PLAIN as user, PLAIN sends the password as it
+// is, so it goes with tls, which trusts the system roots.
+func SetAuth(c *sarama.Config, user, password string, tls bool) {
+	if user != "" {
+		c.Net.SASL.Enable = true
+		c.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		c.Net.SASL.User = user
+		c.Net.SASL.Password = password
+	}
+	c.Net.TLS.Enable = tls
+}
+
 func (c *Connector) CreateTopic() error {
 	if !c.cfg.Version.IsAtLeast(sarama.V1_1_0_0) {
 		return nil
@@ -161,128 +261,311 @@ func (c *Connector) CreateTopic() error {
 	return nil
 }
 
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// putQueue is safe for concurrent use, every call encodes into its own
+// buffer and the diskqueue has written it out once Put returns.
 func (c *Connector) putQueue(msg store.KeyEntry) error {
-	c.writeBuf.Reset()
-	keyLen := uint32(len(msg.Key))
-	err := binary.Write(&c.writeBuf, binary.BigEndian, keyLen)
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+	err := encodeMessage(buf, msg, time.Now())
 	if err != nil {
 		c.log.Errorf("buffer write failed, %s", err)
 		return err
 	}
-	_, err = c.writeBuf.Write(msg.Key)
-	if err != nil {
-		return err
+	if !c.admit(int64(4 + buf.Len())) {
+		return nil
 	}
-	_, err = c.writeBuf.Write(msg.Entry)
-	if err != nil {
-		return err
+	err = c.queue.Put(buf.Bytes())
+	if err == nil {
+		atomic.AddInt64(&c.pending, int64(4+buf.Len()))
 	}
-	return c.queue.Put(c.writeBuf.Bytes())
+	return err
 }
 
 func (c *Connector) runQueue() {
+	defer close(c.done)
 	timer := time.NewTimer(c.conf.Connector.WriteTimeout.Duration)
 	for {
 		select {
-		case msg, ok := <-c.writeChan:
-			if !ok {
-				return
-			}
-			err := c.putQueue(msg)
-			if err != nil {
-				c.log.Errorf("put queue failed, %s", err)
-				if c.producer == nil {
-					continue
-				}
-
-				input := &sarama.ProducerMessage{
-					Topic: c.conf.Connector.Topic,
-					Key:   sarama.ByteEncoder(msg.Key),
-					Value: sarama.ByteEncoder(msg.Entry),
-				}
-
-				if !timer.Stop() {
-					select {
-					case <-timer.C:
-					default:
-					}
-				}
-				timer.Reset(c.conf.Connector.WriteTimeout.Duration)
+		case msg := <-c.writeChan:
+			c.enqueue(msg, timer)
+		case <-c.closed:
+			// messages sent before Close still go to the queue, once
+			// the Sends woken up by closed are done
+			c.sendMu.Lock()
+			c.sendMu.Unlock()
+			for {
 				select {
-				case c.producer.Input() <- input:
-				case <-timer.C:
-					c.log.Errorf("put kafka timeout, %s", msg.Key)
+				case msg := <-c.writeChan:
+					c.enqueue(msg, timer)
+				default:
+					return
 				}
 			}
 		}
 	}
 }
 
+func (c *Connector) enqueue(msg store.KeyEntry, timer *time.Timer) {
+	err := c.putQueue(msg)
+	if err == nil {
+		return
+	}
+	c.log.Errorf("put queue failed, %s", err)
+	if c.producer == nil {
+		return
+	}
+
+	input := &sarama.ProducerMessage{
+		Topic: c.conf.Connector.Topic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Entry),
+	}
+
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(c.conf.Connector.WriteTimeout.Duration)
+	select {
+	case c.producer.Input() <- input:
+	case <-timer.C:
+		c.log.Errorf("put kafka timeout, %s", msg.Key)
+	}
+}
+
+// runProducer hands the queue messages to the producer in batches of
+// batch-size, or whatever has been read after batch-timeout. Messages read
+// from the queue are gone from it, so the pending batch is flushed on Close.
 func (c *Connector) runProducer() {
 	c.log.Info("running producer")
+	defer close(c.flushed)
+	if c.readPos.Before(c.skipTo) {
+		c.log.Infof("skip queue messages before checkpoint %v", c.skipTo)
+	} else if c.cp.Before(c.readPos) && c.transactional() {
+		c.replayTxn(c.cp, c.readPos)
+	} else if c.cp.Before(c.readPos) {
+		// read but not handed to kafka before a crash, they go first
+		err := c.startReplay(c.cp, c.readPos)
+		if err != nil {
+			c.log.Errorf("replay from checkpoint %v failed, %s", c.cp, err)
+		}
+		c.replayWg.Wait()
+	}
+	batch := make([]*sarama.ProducerMessage, 0, c.conf.Connector.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		metric.Batch.Observe(float64(len(batch)))
+		if c.transactional() {
+			c.flushTxn(batch)
+		} else {
+			for _, msg := range batch {
+				c.produce(msg, true)
+			}
+		}
+		for i := range batch {
+			batch[i] = nil
+		}
+		batch = batch[:0]
+		c.saveCheckpoint()
+	}
+	ticker := time.NewTicker(c.conf.Connector.BatchTimeout.Duration)
+	defer ticker.Stop()
 	for {
 		select {
 		case success, ok := <-c.producer.Successes():
 			if !ok {
 				return
 			}
-			logrus.Debugf("key %s, partition %d, offset %d",
-				success.Key, success.Partition, success.Offset)
+			c.onSuccess(success)
 		case err, ok := <-c.producer.Errors():
 			if !ok {
 				return
 			}
-			c.log.Errorf("producer failed, %s", err)
-		case body, ok := <-c.queue.ReadChan():
+			c.onError(err)
+		case body := <-c.queue.ReadChan():
+			atomic.AddInt64(&c.pending, -int64(4+len(body)))
+			c.archive()
+			skip := c.readPos.Before(c.skipTo)
+			c.advance(len(body))
+			d := &delivery{pos: c.readPos}
+			if skip {
+				c.ack(d)
+				continue
+			}
+			msg, at, err := DecodeTimedMessage(body)
+			if err != nil {
+				c.log.Errorf("drop queue message of %d bytes, %s", len(body), err)
+				metric.Corrupted.Inc()
+				c.ack(d)
+				continue
+			}
+			input, err := c.message(msg, at)
+			if err != nil {
+				c.log.Errorf("drop queue message of key %s, %s", msg.Key, err)
+				metric.Corrupted.Inc()
+				c.ack(d)
+				continue
+			}
+			if !at.IsZero() {
+				atomic.StoreInt64(&c.headTime, at.UnixNano())
+			}
+			d.at, d.msg = at, msg
+			input.Metadata = d
+			c.track(d)
+			if c.dropOldest(input) {
+				continue
+			}
+			batch = append(batch, input)
+			if len(batch) >= c.conf.Connector.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			c.saveCheckpoint()
+		case <-c.closed:
+			flush()
+			c.drain()
+			c.saveCheckpoint()
+			return
+		}
+	}
+}
+
+// drain closes the producer once the replay is done, and waits for the
+// results of the messages in flight, so the checkpoint covers them.
+func (c *Connector) drain() {
+	// a Rewind seeing closed won't start a replay
+	c.cpMu.Lock()
+	c.cpMu.Unlock()
+	c.replayWg.Wait()
+	c.producer.AsyncClose()
+	successes, errors := c.producer.Successes(), c.producer.Errors()
+	for successes != nil || errors != nil {
+		select {
+		case success, ok := <-successes:
 			if !ok {
-				return
+				successes = nil
+				continue
 			}
-			keyLen := binary.BigEndian.Uint32(body[:4])
-			c.producer.Input() <- &sarama.ProducerMessage{
-				Topic: c.conf.Connector.Topic,
-				Key:   sarama.ByteEncoder(body[4 : keyLen+4]),
-				Value: sarama.ByteEncoder(body[keyLen+4:]),
+			c.onSuccess(success)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
 			}
+			c.onError(err)
 		}
 	}
 }
 
+func (c *Connector) message(msg store.KeyEntry, at time.Time) (*sarama.ProducerMessage, error) {
+	value, err := c.encoder.Encode(msg.Key, msg.Entry, at)
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic: c.conf.Connector.Topic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: value,
+	}, nil
+}
+
+// produce keeps draining the results while the input is full, the producer
+// stops accepting messages when nobody reads its errors. Queue messages may
+// be dropped by drop-oldest while waiting.
+func (c *Connector) produce(msg *sarama.ProducerMessage, queued bool) {
+	if queued && c.dropOldest(msg) {
+		return
+	}
+	for {
+		select {
+		case c.producer.Input() <- msg:
+			return
+		case <-c.overflow:
+			if queued && c.dropOldest(msg) {
+				return
+			}
+		case success := <-c.producer.Successes():
+			c.onSuccess(success)
+		case err := <-c.producer.Errors():
+			c.onError(err)
+		}
+	}
+}
+
+// Send may be called from any goroutine, it fails once the connector is
+// closed instead of panicking.
 func (c *Connector) Send(msg store.KeyEntry) error {
-	c.writeChan <- msg
-	return nil
+	c.sendMu.RLock()
+	defer c.sendMu.RUnlock()
+	if c.sendClosed {
+		return xerror.ErrConnectorClosed
+	}
+	// a full writeChan doesn't hold Close up
+	select {
+	case c.writeChan <- msg:
+		return nil
+	case <-c.closed:
+		return xerror.ErrConnectorClosed
+	}
 }
 
 func (c *Connector) Close() {
 	if c.closed == nil {
 		return
 	}
+	c.closeOnce.Do(c.close)
+}
+
+func (c *Connector) close() {
 	close(c.closed)
-	close(c.writeChan)
+	c.sendMu.Lock()
+	c.sendClosed = true
+	c.sendMu.Unlock()
+	<-c.done
+	if c.producer != nil {
+		<-c.flushed
+	}
+	// a Rewind seeing closed won't start a replay
+	c.cpMu.Lock()
+	c.cpMu.Unlock()
+	c.replayWg.Wait()
+	// the producer is closed by runProducer
 	err := c.queue.Close()
 	if err != nil {
 		c.log.Errorf("queue close failed, %s", err)
 	}
-	if c.producer == nil {
-		return
-	}
-	err = c.producer.Close()
-	if err != nil {
-		c.log.Errorf("producer close failed, %s", err)
-	}
 }
 
 func (c *Connector) runMetrics() {
 	c.log.Info("collect metrics")
-	ticker := time.NewTicker(time.Minute)
-	metric.Chan.Set(float64(len(c.writeChan)))
-	metric.Queue.Set(float64(c.queue.Depth()))
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	c.setMetrics()
 	for {
 		select {
 		case <-c.closed:
 			return
 		case <-ticker.C:
-			metric.Chan.Set(float64(len(c.writeChan)))
-			metric.Queue.Set(float64(c.queue.Depth()))
+			c.stats.tick()
+			c.setMetrics()
 		}
 	}
 }
+
+func (c *Connector) setMetrics() {
+	depth := c.queue.Depth()
+	metric.Chan.Set(float64(len(c.writeChan)))
+	metric.Queue.Set(float64(depth))
+	metric.QueueBytes.Set(float64(atomic.LoadInt64(&c.pending)))
+	metric.Lag.Set(c.lag(depth).Seconds())
+}
@@ -0,0 +1,216 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectorConcurrentSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connector")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+
+	const writers, count = 16, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				msg := store.KeyEntry{Key: []byte(key), Entry: []byte("entry-" + key)}
+				// half of them skip the channel to hit putQueue directly
+				if i%2 == 0 {
+					assert.Nil(t, c.Send(msg))
+				} else {
+					assert.Nil(t, c.putQueue(msg))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	c.Close()
+	c.Close()
+	assert.True(t, errors.Is(c.Send(store.KeyEntry{Key: []byte("late")}), xerror.ErrConnectorClosed))
+
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(writers*count), meta.Depth)
+
+	r := NewQueueReader(dir, meta.ReadFileNum, meta.ReadPos, conf.Connector.MaxMsgSize)
+	defer r.Close()
+	seen := make(map[string]bool)
+	for {
+		body, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		msg, err := DecodeMessage(body)
+		assert.Nil(t, err)
+		assert.Equal(t, "entry-"+string(msg.Key), string(msg.Entry))
+		assert.False(t, seen[string(msg.Key)], "duplicated %s", msg.Key)
+		seen[string(msg.Key)] = true
+	}
+	assert.Equal(t, writers*count, len(seen))
+}
+
+func TestConnectorSendClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connector")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+
+	// every message Send accepts is queued, even when it races Close
+	var sent int64
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				err := c.Send(store.KeyEntry{Key: []byte(fmt.Sprintf("key-%d-%d", w, i)), Entry: []byte("v")})
+				if err != nil {
+					assert.True(t, errors.Is(err, xerror.ErrConnectorClosed))
+					return
+				}
+				atomic.AddInt64(&sent, 1)
+			}
+		}(w)
+	}
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	wg.Wait()
+
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, atomic.LoadInt64(&sent), meta.Depth)
+}
+
+func TestConnectorCloseFullChan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connector")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	// the queue is full, runQueue blocks until Close
+	conf.Connector.MaxQueueBytes = 1
+	conf.Connector.FullPolicy = PolicyBlock
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+
+	sent := make(chan error)
+	go func() {
+		for {
+			if err := c.Send(store.KeyEntry{Key: []byte("k"), Entry: []byte("v")}); err != nil {
+				sent <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200 && len(c.writeChan) < MaxMessage; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, MaxMessage, len(c.writeChan))
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked behind a send")
+	}
+	assert.True(t, errors.Is(<-sent, xerror.ErrConnectorClosed))
+}
+
+func TestConnectorBatchFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "connector")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conf.Connector.BatchSize = 64
+	// only full batches go out before Close
+	conf.Connector.BatchTimeout = &config.Duration{Duration: time.Hour}
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+
+	const count = 150
+	var next int
+	producer := newMockProducer(t)
+	for i := 0; i < count; i++ {
+		producer.ExpectInputWithCheckerFunctionAndSucceed(func(val []byte) error {
+			if want := fmt.Sprintf("entry-%d", next); string(val) != want {
+				return fmt.Errorf("got %s, want %s", val, want)
+			}
+			next++
+			return nil
+		})
+	}
+	c.producer = producer
+	go c.runProducer()
+
+	for i := 0; i < count; i++ {
+		assert.Nil(t, c.Send(store.KeyEntry{
+			Key:   []byte(fmt.Sprintf("key-%d", i)),
+			Entry: []byte(fmt.Sprintf("entry-%d", i)),
+		}))
+	}
+	for i := 0; i < 200 && (len(c.writeChan) > 0 || c.queue.Depth() > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), c.queue.Depth())
+	// the last 22 messages are still pending and flushed by Close, the mock
+	// reports missing ones when it's closed
+	c.Close()
+}
+
+func TestSetAuth(t *testing.T) {
+	c := sarama.NewConfig()
+	SetAuth(c, "", "", false)
+	assert.False(t, c.Net.SASL.Enable)
+	assert.False(t, c.Net.TLS.Enable)
+
+	SetAuth(c, "tirest", "secret", true)
+	assert.True(t, c.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypePlaintext), c.Net.SASL.Mechanism)
+	assert.Equal(t, "tirest", c.Net.SASL.User)
+	assert.Equal(t, "secret", c.Net.SASL.Password)
+	assert.True(t, c.Net.TLS.Enable)
+	assert.Nil(t, c.Validate())
+}
@@ -0,0 +1,347 @@
+package kafka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/version"
+)
+
+var ErrCorruptedMessage = errors.New("corrupted queue message")
+
+// QueueMeta mirrors the metadata file of the diskqueue, the positions point
+// at the next message to be read and the next byte to be written.
+type QueueMeta struct {
+	Depth        int64
+	ReadFileNum  int64
+	ReadPos      int64
+	WriteFileNum int64
+	WritePos     int64
+}
+
+func queueMetaFileName(dataPath string) string {
+	return filepath.Join(dataPath, fmt.Sprintf("%s.diskqueue.meta.dat", version.APP))
+}
+
+func QueueFileName(dataPath string, fileNum int64) string {
+	return filepath.Join(dataPath, fmt.Sprintf("%s.diskqueue.%06d.dat", version.APP, fileNum))
+}
+
+func ReadQueueMeta(dataPath string) (QueueMeta, error) {
+	var m QueueMeta
+	f, err := os.Open(queueMetaFileName(dataPath))
+	if err != nil {
+		return m, err
+	}
+	defer f.Close()
+	_, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
+		&m.Depth, &m.ReadFileNum, &m.ReadPos, &m.WriteFileNum, &m.WritePos)
+	return m, err
+}
+
+// timedFlag in the key length marks a message with the enqueue time, in unix
+// nanoseconds, after the key length. Older messages have no time.
+const timedFlag = 1 << 31
+
+func encodeMessage(w io.Writer, msg store.KeyEntry, t time.Time) error {
+	err := binary.Write(w, binary.BigEndian, uint32(len(msg.Key))|timedFlag)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.BigEndian, t.UnixNano())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg.Key)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg.Entry)
+	return err
+}
+
+// DecodeMessage splits a queue message into the key and the change log.
+func DecodeMessage(body []byte) (store.KeyEntry, error) {
+	msg, _, err := DecodeTimedMessage(body)
+	return msg, err
+}
+
+// DecodeTimedMessage returns the enqueue time too, it's zero for a message
+// written by an older version.
+func DecodeTimedMessage(body []byte) (store.KeyEntry, time.Time, error) {
+	var t time.Time
+	if len(body) < 4 {
+		return store.KeyEntry{}, t, ErrCorruptedMessage
+	}
+	keyLen := binary.BigEndian.Uint32(body[:4])
+	body = body[4:]
+	if keyLen&timedFlag != 0 {
+		if len(body) < 8 {
+			return store.KeyEntry{}, t, ErrCorruptedMessage
+		}
+		keyLen &^= timedFlag
+		t = time.Unix(0, int64(binary.BigEndian.Uint64(body[:8])))
+		body = body[8:]
+	}
+	if uint64(keyLen) > uint64(len(body)) {
+		return store.KeyEntry{}, t, ErrCorruptedMessage
+	}
+	return store.KeyEntry{
+		Key:   body[:keyLen],
+		Entry: body[keyLen:],
+	}, t, nil
+}
+
+// QueueReader reads the diskqueue files directly without touching the
+// metadata, so it's safe to run next to a live connector.
+type QueueReader struct {
+	dataPath   string
+	maxMsgSize int32
+	fileNum    int64
+	pos        int64
+	f          *os.File
+	r          *bufio.Reader
+}
+
+func NewQueueReader(dataPath string, fileNum, pos int64, maxMsgSize int32) *QueueReader {
+	return &QueueReader{
+		dataPath:   dataPath,
+		maxMsgSize: maxMsgSize,
+		fileNum:    fileNum,
+		pos:        pos,
+	}
+}
+
+// Position returns the file and offset of the next message.
+func (q *QueueReader) Position() (int64, int64) {
+	return q.fileNum, q.pos
+}
+
+func (q *QueueReader) open() error {
+	f, err := os.Open(QueueFileName(q.dataPath, q.fileNum))
+	if err != nil {
+		return err
+	}
+	if q.pos > 0 {
+		if _, err = f.Seek(q.pos, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	q.f = f
+	q.r = bufio.NewReader(f)
+	return nil
+}
+
+func (q *QueueReader) reset() {
+	if q.f != nil {
+		q.f.Close()
+		q.f = nil
+	}
+}
+
+// Next returns the next message, io.EOF means everything written so far has
+// been read and a later call may return new messages.
+func (q *QueueReader) Next() ([]byte, error) {
+	for {
+		if q.f == nil {
+			err := q.open()
+			if os.IsNotExist(err) {
+				if !q.nextFileExists() {
+					return nil, io.EOF
+				}
+				q.fileNum++
+				q.pos = 0
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		var size int32
+		err := binary.Read(q.r, binary.BigEndian, &size)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the writer has moved on, the rest of this file is done
+			if q.nextFileExists() {
+				q.reset()
+				q.fileNum++
+				q.pos = 0
+				continue
+			}
+			q.reset()
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		if size <= 0 || (q.maxMsgSize > 0 && size > q.maxMsgSize) {
+			return nil, fmt.Errorf("%s at %d: size %d, %w",
+				QueueFileName(q.dataPath, q.fileNum), q.pos, size, ErrCorruptedMessage)
+		}
+		body := make([]byte, size)
+		_, err = io.ReadFull(q.r, body)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a partial write, retry from the same position later
+			q.reset()
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		q.pos += 4 + int64(size)
+		return body, nil
+	}
+}
+
+func (q *QueueReader) nextFileExists() bool {
+	_, err := os.Stat(QueueFileName(q.dataPath, q.fileNum+1))
+	return err == nil
+}
+
+func (q *QueueReader) Close() error {
+	q.reset()
+	return nil
+}
+
+// WriteQueueMeta replaces the metadata file, the connector must not be
+// running since it keeps the positions in memory and rewrites the file.
+// The replay checkpoint is removed, it doesn't apply to the new positions.
+func WriteQueueMeta(dataPath string, m QueueMeta) error {
+	fileName := queueMetaFileName(dataPath)
+	tmpFileName := fileName + ".tmp"
+	f, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d\n%d,%d\n%d,%d\n",
+		m.Depth, m.ReadFileNum, m.ReadPos, m.WriteFileNum, m.WritePos)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	if err = os.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+	err = os.Remove(checkpointFileName(dataPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+type QueueFile struct {
+	Num  int64
+	Path string
+	Size int64
+	// Bad is a file the diskqueue renamed after a read error.
+	Bad bool
+}
+
+// QueueFiles returns the data files sorted by number, bad files included.
+func QueueFiles(dataPath string) ([]QueueFile, error) {
+	var files []QueueFile
+	prefix := version.APP + ".diskqueue."
+	for _, pattern := range []string{prefix + "*.dat", prefix + "*.dat.bad"} {
+		paths, err := filepath.Glob(filepath.Join(dataPath, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			f := QueueFile{Path: p, Bad: strings.HasSuffix(p, ".bad")}
+			name := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(p), ".bad"), ".dat")
+			f.Num, err = strconv.ParseInt(strings.TrimPrefix(name, prefix), 10, 64)
+			if err != nil {
+				// the metadata file
+				continue
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			f.Size = info.Size()
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Num != files[j].Num {
+			return files[i].Num < files[j].Num
+		}
+		return !files[i].Bad
+	})
+	return files, nil
+}
+
+// PendingBytes is the size of the messages after the read position.
+func PendingBytes(dataPath string, m QueueMeta) int64 {
+	var n int64
+	for num := m.ReadFileNum; num <= m.WriteFileNum; num++ {
+		size := m.WritePos
+		if num < m.WriteFileNum {
+			info, err := os.Stat(QueueFileName(dataPath, num))
+			if err != nil {
+				continue
+			}
+			size = info.Size()
+		}
+		if num == m.ReadFileNum {
+			size -= m.ReadPos
+		}
+		if size > 0 {
+			n += size
+		}
+	}
+	return n
+}
+
+// ScanQueueFile calls fn for every message of a data file from the offset,
+// it stops at the first broken frame and returns the offset after the last
+// good one.
+func ScanQueueFile(path string, from int64, maxMsgSize int32, fn func(pos int64, body []byte)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return from, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(from, io.SeekStart); err != nil {
+		return from, err
+	}
+	r := bufio.NewReader(f)
+	pos := from
+	for {
+		var size int32
+		err = binary.Read(r, binary.BigEndian, &size)
+		if err == io.EOF {
+			return pos, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return pos, fmt.Errorf("partial size at %d, %w", pos, ErrCorruptedMessage)
+		} else if err != nil {
+			return pos, err
+		}
+		if size <= 0 || (maxMsgSize > 0 && size > maxMsgSize) {
+			return pos, fmt.Errorf("invalid size %d at %d, %w", size, pos, ErrCorruptedMessage)
+		}
+		body := make([]byte, size)
+		_, err = io.ReadFull(r, body)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pos, fmt.Errorf("partial message at %d, %w", pos, ErrCorruptedMessage)
+		} else if err != nil {
+			return pos, err
+		}
+		fn(pos, body)
+		pos += 4 + int64(size)
+	}
+}
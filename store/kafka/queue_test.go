@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/version"
+	"github.com/nsqio/go-diskqueue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	nop := func(lvl diskqueue.LogLevel, f string, args ...interface{}) {}
+	// a tiny file size forces the queue to roll over several files
+	q := diskqueue.New(version.APP, dir, 64, 4, 1024, 1, time.Second, nop)
+	var buf bytes.Buffer
+	now := time.Unix(0, time.Now().UnixNano())
+	keys := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	for _, k := range keys {
+		buf.Reset()
+		assert.Nil(t, encodeMessage(&buf, store.KeyEntry{
+			Key:   []byte(k),
+			Entry: []byte(`{"old":"","new":"` + k + `"}`),
+		}, now))
+		assert.Nil(t, q.Put(buf.Bytes()))
+	}
+	assert.Nil(t, q.Close())
+
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(keys)), meta.Depth)
+	assert.True(t, meta.WriteFileNum > 0)
+
+	r := NewQueueReader(dir, meta.ReadFileNum, meta.ReadPos, 1024)
+	defer r.Close()
+	for _, k := range keys {
+		body, err := r.Next()
+		assert.Nil(t, err)
+		msg, at, err := DecodeTimedMessage(body)
+		assert.Nil(t, err)
+		assert.Equal(t, k, string(msg.Key))
+		assert.True(t, now.Equal(at))
+	}
+	_, err = r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	files, err := QueueFiles(dir)
+	assert.Nil(t, err)
+	assert.True(t, len(files) > 1)
+	first := files[0]
+	f, err := os.OpenFile(first.Path, os.O_WRONLY|os.O_APPEND, 0600)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0x7f, 0xff, 0xff, 0xff, 'x'})
+	assert.Nil(t, err)
+	f.Close()
+	var scanned int
+	valid, err := ScanQueueFile(first.Path, 0, 1024, func(pos int64, body []byte) { scanned++ })
+	assert.True(t, errors.Is(err, ErrCorruptedMessage))
+	assert.Equal(t, first.Size, valid)
+	assert.True(t, scanned > 0)
+
+	_, err = DecodeMessage([]byte{0, 0, 0, 9, 'a'})
+	assert.Equal(t, ErrCorruptedMessage, err)
+	// written before the time was added
+	msg, at, err := DecodeTimedMessage([]byte{0, 0, 0, 1, 'k', 'v'})
+	assert.Nil(t, err)
+	assert.Equal(t, "k", string(msg.Key))
+	assert.Equal(t, "v", string(msg.Entry))
+	assert.True(t, at.IsZero())
+}
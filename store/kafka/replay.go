@@ -0,0 +1,271 @@
+package kafka
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// replayDir keeps hard links of the data files the producer has read, so
+// they can be replayed after the diskqueue removes them.
+const replayDir = "replay"
+
+type checkpoint struct {
+	store.ReplayPosition
+	Time time.Time `json:"time"`
+}
+
+func checkpointFileName(dataPath string) string {
+	return filepath.Join(dataPath, fmt.Sprintf("%s.replay.checkpoint", version.APP))
+}
+
+// ReadCheckpoint returns the position after the last message handed to
+// kafka, the producer resumes there after a restart.
+func ReadCheckpoint(dataPath string) (store.ReplayPosition, error) {
+	var cp checkpoint
+	b, err := ioutil.ReadFile(checkpointFileName(dataPath))
+	if err != nil {
+		return cp.ReplayPosition, err
+	}
+	err = json.Unmarshal(b, &cp)
+	return cp.ReplayPosition, err
+}
+
+func writeCheckpoint(dataPath string, p store.ReplayPosition) error {
+	b, err := json.Marshal(checkpoint{ReplayPosition: p, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	fileName := checkpointFileName(dataPath)
+	tmpFileName := fileName + ".tmp"
+	if err = ioutil.WriteFile(tmpFileName, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+func (c *Connector) Checkpoint() store.ReplayPosition {
+	c.cpMu.Lock()
+	defer c.cpMu.Unlock()
+	return c.cp
+}
+
+// advance mirrors how the diskqueue moves its read position, it rolls to the
+// next file once the position is past max-bytes-per-file.
+func (c *Connector) advance(size int) {
+	c.readPos.Pos += 4 + int64(size)
+	if c.readPos.Pos > c.conf.Connector.MaxBytesPerFile {
+		c.readPos.FileNum++
+		c.readPos.Pos = 0
+	}
+}
+
+// delivery is the Metadata of a message read from the queue, pos is the
+// read position after it. A replayed message has no delivery.
+type delivery struct {
+	at    time.Time
+	msg   store.KeyEntry
+	pos   store.ReplayPosition
+	acked bool
+}
+
+// track adds a message read from the queue to the inflight ones, in the
+// order of the queue. A message which isn't sent is tracked as acked.
+func (c *Connector) track(d *delivery) {
+	c.cpMu.Lock()
+	c.inflight = append(c.inflight, d)
+	c.settle()
+	c.cpMu.Unlock()
+}
+
+func (c *Connector) ack(d *delivery) {
+	c.cpMu.Lock()
+	d.acked = true
+	c.settle()
+	c.cpMu.Unlock()
+}
+
+// settle moves ackPos past the acked messages at the head, the acks of the
+// partitions come in any order.
+func (c *Connector) settle() {
+	for len(c.inflight) > 0 && c.inflight[0].acked {
+		c.ackPos = c.inflight[0].pos
+		c.inflight[0] = nil
+		c.inflight = c.inflight[1:]
+	}
+}
+
+// saveCheckpoint writes the position every message before has been acked
+// by kafka, the messages after it are sent again after a crash.
+func (c *Connector) saveCheckpoint() {
+	c.cpMu.Lock()
+	if !c.cp.Before(c.ackPos) {
+		c.cpMu.Unlock()
+		return
+	}
+	c.cp = c.ackPos
+	cp := c.cp
+	c.cpMu.Unlock()
+	err := writeCheckpoint(c.conf.Connector.QueueDataPath, cp)
+	if err != nil {
+		c.log.Errorf("write checkpoint failed, %s", err)
+	}
+}
+
+// archive links the file being read into the replay dir, the file of a
+// new position is created by the first write, so it's retried until then.
+func (c *Connector) archive() {
+	if c.conf.Connector.ReplayRetention.Duration <= 0 || c.archived >= c.readPos.FileNum {
+		return
+	}
+	dataPath := c.conf.Connector.QueueDataPath
+	link := QueueFileName(filepath.Join(dataPath, replayDir), c.readPos.FileNum)
+	err := os.Link(QueueFileName(dataPath, c.readPos.FileNum), link)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil && !os.IsExist(err) {
+		c.log.Errorf("link %s failed, %s", link, err)
+		return
+	}
+	c.archived = c.readPos.FileNum
+	c.expire()
+}
+
+// expire removes the links of the files read before and not modified
+// within replay-retention.
+func (c *Connector) expire() {
+	files, err := QueueFiles(filepath.Join(c.conf.Connector.QueueDataPath, replayDir))
+	if err != nil {
+		c.log.Errorf("list replay files failed, %s", err)
+		return
+	}
+	deadline := time.Now().Add(-c.conf.Connector.ReplayRetention.Duration)
+	for _, f := range files {
+		if f.Num >= c.archived {
+			break
+		}
+		info, err := os.Stat(f.Path)
+		if err != nil || info.ModTime().After(deadline) {
+			continue
+		}
+		c.log.Infof("remove replay file %s", f.Path)
+		if err = os.Remove(f.Path); err != nil {
+			c.log.Errorf("remove %s failed, %s", f.Path, err)
+		}
+	}
+}
+
+// PositionAt returns the start of the first kept file modified since t, the
+// replay may include a few older messages of that file.
+func (c *Connector) PositionAt(t time.Time) (store.ReplayPosition, error) {
+	if c.conf.Connector.ReplayRetention.Duration <= 0 {
+		return store.ReplayPosition{}, xerror.ErrReplayNotKept
+	}
+	files, err := QueueFiles(filepath.Join(c.conf.Connector.QueueDataPath, replayDir))
+	if err != nil {
+		return store.ReplayPosition{}, err
+	}
+	for _, f := range files {
+		info, err := os.Stat(f.Path)
+		if err != nil || f.Bad {
+			continue
+		}
+		if !info.ModTime().Before(t) {
+			return store.ReplayPosition{FileNum: f.Num}, nil
+		}
+	}
+	return c.Checkpoint(), nil
+}
+
+// Rewind sends the messages from the position up to the checkpoint to kafka
+// again, the diskqueue and the checkpoint are left as they are. It's not
+// supported by a transactional producer, which sends them once.
+func (c *Connector) Rewind(from store.ReplayPosition) (store.ReplayPosition, error) {
+	to := c.Checkpoint()
+	if c.producer == nil || c.transactional() {
+		return to, xerror.ErrNotSupported
+	}
+	if to.Before(from) {
+		return to, xerror.ErrReplayNotKept
+	}
+	return to, c.startReplay(from, to)
+}
+
+func (c *Connector) startReplay(from, to store.ReplayPosition) error {
+	dataPath := c.conf.Connector.QueueDataPath
+	dir := filepath.Join(dataPath, replayDir)
+	if _, err := os.Stat(QueueFileName(dir, from.FileNum)); err != nil {
+		dir = dataPath
+		if _, err = os.Stat(QueueFileName(dir, from.FileNum)); err != nil {
+			return xerror.ErrReplayNotKept.Wrap(err)
+		}
+	}
+	if !atomic.CompareAndSwapInt32(&c.replaying, 0, 1) {
+		return xerror.ErrReplayRunning
+	}
+	c.cpMu.Lock()
+	defer c.cpMu.Unlock()
+	select {
+	case <-c.closed:
+		atomic.StoreInt32(&c.replaying, 0)
+		return xerror.ErrConnectorClosed
+	default:
+	}
+	c.replayWg.Add(1)
+	go c.replay(dir, from, to)
+	return nil
+}
+
+func (c *Connector) replay(dir string, from, to store.ReplayPosition) {
+	defer c.replayWg.Done()
+	defer atomic.StoreInt32(&c.replaying, 0)
+	c.log.Infof("replay %s (%v-%v)", dir, from, to)
+	r := NewQueueReader(dir, from.FileNum, from.Pos, c.conf.Connector.MaxMsgSize)
+	defer r.Close()
+	count := 0
+	for {
+		select {
+		case <-c.closed:
+			c.log.Warnf("replay stopped by close after %d messages", count)
+			return
+		default:
+		}
+		body, err := r.Next()
+		if err == io.EOF {
+			if fileNum, pos := r.Position(); (store.ReplayPosition{FileNum: fileNum, Pos: pos}).Before(to) {
+				c.log.Warnf("replay ends at %d:%d before %v", fileNum, pos, to)
+			}
+			break
+		} else if err != nil {
+			c.log.Errorf("replay failed after %d messages, %s", count, err)
+			return
+		}
+		fileNum, pos := r.Position()
+		start := store.ReplayPosition{FileNum: fileNum, Pos: pos - 4 - int64(len(body))}
+		if !start.Before(to) {
+			break
+		}
+		msg, at, err := DecodeTimedMessage(body)
+		if err != nil {
+			metric.Corrupted.Inc()
+			continue
+		}
+		input, err := c.message(msg, at)
+		if err != nil {
+			metric.Corrupted.Inc()
+			continue
+		}
+		c.produce(input, false)
+		count++
+	}
+	c.log.Infof("replayed %d messages (%v-%v)", count, from, to)
+}
@@ -0,0 +1,178 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockProducer returns the successes like the connector's producer,
+// the checkpoint moves on with them.
+func newMockProducer(t *testing.T) *mocks.AsyncProducer {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	return mocks.NewAsyncProducer(t, cfg)
+}
+
+func expectEntries(producer *mocks.AsyncProducer, from, to int) *mocks.AsyncProducer {
+	next := from
+	for i := from; i < to; i++ {
+		producer.ExpectInputWithCheckerFunctionAndSucceed(func(val []byte) error {
+			if want := fmt.Sprintf("entry-%d", next); string(val) != want {
+				return fmt.Errorf("got %s, want %s", val, want)
+			}
+			next++
+			return nil
+		})
+	}
+	return producer
+}
+
+func openReplayConnector(t *testing.T, conf *config.Config, producer sarama.AsyncProducer) *Connector {
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+	c.producer = producer
+	go c.runProducer()
+	return c
+}
+
+func sendEntries(t *testing.T, c *Connector, from, to int) {
+	for i := from; i < to; i++ {
+		assert.Nil(t, c.Send(store.KeyEntry{
+			Key:   []byte(fmt.Sprintf("key-%d", i)),
+			Entry: []byte(fmt.Sprintf("entry-%d", i)),
+		}))
+	}
+	for i := 0; i < 200 && (len(c.writeChan) > 0 || c.queue.Depth() > 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(0), c.queue.Depth())
+}
+
+func setReadPos(t *testing.T, dir string, fileNum, pos int64) {
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	// WriteQueueMeta would drop the checkpoint
+	err = ioutil.WriteFile(queueMetaFileName(dir), []byte(fmt.Sprintf("%d\n%d,%d\n%d,%d\n",
+		meta.Depth, fileNum, pos, meta.WriteFileNum, meta.WritePos)), 0600)
+	assert.Nil(t, err)
+}
+
+func TestConnectorCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conf.Connector.MaxBytesPerFile = 64
+	conf.Connector.BatchSize = 4
+	conf.Connector.ReplayRetention = &config.Duration{Duration: time.Hour}
+
+	// 3 messages a file
+	c := openReplayConnector(t, conf, expectEntries(newMockProducer(t), 0, 10))
+	sendEntries(t, c, 0, 10)
+	c.Close()
+	end, err := ReadCheckpoint(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, store.ReplayPosition{FileNum: 3, Pos: 28}, end)
+
+	// the metadata lags behind, the sent messages are skipped
+	setReadPos(t, dir, 3, 0)
+	c = openReplayConnector(t, conf, expectEntries(newMockProducer(t), 10, 15))
+	sendEntries(t, c, 10, 15)
+	c.Close()
+	end, err = ReadCheckpoint(dir)
+	assert.Nil(t, err)
+
+	// a crash lost messages read but not sent, they are sent again first
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, end, store.ReplayPosition{FileNum: meta.ReadFileNum, Pos: meta.ReadPos})
+	assert.Nil(t, writeCheckpoint(dir, store.ReplayPosition{FileNum: 1}))
+	producer := expectEntries(newMockProducer(t), 3, 17)
+	c = openReplayConnector(t, conf, producer)
+	sendEntries(t, c, 15, 17)
+	for i := 0; i < 200 && c.Checkpoint() != (store.ReplayPosition{FileNum: 5, Pos: 60}); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the read files are kept for rewinds
+	from, err := c.PositionAt(time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, store.ReplayPosition{}, from)
+	expectEntries(producer, 0, 17)
+	to, err := c.Rewind(from)
+	assert.Nil(t, err)
+	assert.Equal(t, store.ReplayPosition{FileNum: 5, Pos: 60}, to)
+	c.replayWg.Wait()
+	c.Close()
+}
+
+func TestConnectorAckCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conf.Connector.BatchSize = 1
+
+	// without acks the checkpoint stays
+	c := openReplayConnector(t, conf, expectEntries(mocks.NewAsyncProducer(t, nil), 0, 2))
+	sendEntries(t, c, 0, 2)
+	c.Close()
+	assert.Equal(t, store.ReplayPosition{}, c.Checkpoint())
+
+	// the failed message goes back to the queue, after the others
+	os.RemoveAll(dir)
+	producer := newMockProducer(t)
+	expectEntries(producer, 0, 1)
+	producer.ExpectInputAndFail(errors.New("down"))
+	expectEntries(producer, 2, 3)
+	producer.ExpectInputWithCheckerFunctionAndSucceed(func(val []byte) error {
+		if string(val) != "entry-1" {
+			return fmt.Errorf("got %s, want entry-1", val)
+		}
+		return nil
+	})
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c = conn.(*Connector)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, c.putQueue(store.KeyEntry{
+			Key:   []byte(fmt.Sprintf("key-%d", i)),
+			Entry: []byte(fmt.Sprintf("entry-%d", i)),
+		}))
+	}
+	c.producer = producer
+	go c.runProducer()
+	settled := func() bool {
+		c.cpMu.Lock()
+		defer c.cpMu.Unlock()
+		return atomic.LoadInt64(&c.stats.errors) > 0 && c.queue.Depth() == 0 && len(c.inflight) == 0
+	}
+	for i := 0; i < 200 && !settled(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, settled())
+	c.Close()
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	end, err := ReadCheckpoint(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, store.ReplayPosition{FileNum: meta.ReadFileNum, Pos: meta.ReadPos}, end)
+}
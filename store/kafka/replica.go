@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+// Applier writes the changes of another cluster, store.Store is one.
+type Applier interface {
+	Apply(ctx context.Context, key []byte, l store.Log) error
+}
+
+// Replica is a consumer group handler which applies the changes of the
+// connector topic of another cluster. A change which fails is retried
+// every replica.back-off, the offset is only marked once it's applied.
+type Replica struct {
+	applier Applier
+	conf    *config.Replica
+	log     *logrus.Entry
+}
+
+func NewReplica(applier Applier, conf *config.Replica) *Replica {
+	return &Replica{
+		applier: applier,
+		conf:    conf,
+		log:     logrus.WithFields(logrus.Fields{"worker": "replica"}),
+	}
+}
+
+func (r *Replica) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (r *Replica) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (r *Replica) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for msg := range claim.Messages() {
+		for {
+			err := r.apply(ctx, msg.Key, msg.Value)
+			if err == nil {
+				break
+			}
+			r.log.Errorf("apply partition %d offset %d failed, %s", msg.Partition, msg.Offset, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(r.conf.BackOff.Duration):
+			}
+		}
+		if !msg.Timestamp.IsZero() {
+			metric.ReplicaLag.Set(time.Since(msg.Timestamp).Seconds())
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+// apply returns an error to retry the change, the audit records, the
+// changes without a stamp and the values which aren't a json change are
+// skipped.
+func (r *Replica) apply(ctx context.Context, key, value []byte) error {
+	if len(key) == 0 || key[0] == store.AuditType {
+		metric.Replica.WithLabelValues("skipped").Inc()
+		return nil
+	}
+	l := store.Log{}
+	if err := json.Unmarshal(value, &l); err != nil || l.Ts == 0 {
+		r.log.Warnf("skip key %s, not a stamped json change", key)
+		metric.Replica.WithLabelValues("skipped").Inc()
+		return nil
+	}
+	err := r.applier.Apply(ctx, key, l)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		metric.Replica.WithLabelValues("stale").Inc()
+		return nil
+	} else if err != nil {
+		metric.Replica.WithLabelValues("failed").Inc()
+		return err
+	}
+	metric.Replica.WithLabelValues("applied").Inc()
+	return nil
+}
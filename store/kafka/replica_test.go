@@ -0,0 +1,45 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+type applyFunc func(ctx context.Context, key []byte, l store.Log) error
+
+func (f applyFunc) Apply(ctx context.Context, key []byte, l store.Log) error {
+	return f(ctx, key, l)
+}
+
+func TestReplicaApply(t *testing.T) {
+	var applied []store.Log
+	fail := errors.New("unavailable")
+	r := NewReplica(applyFunc(func(ctx context.Context, key []byte, l store.Log) error {
+		switch l.New {
+		case "stale":
+			return xerror.ErrAlreadyExists
+		case "fail":
+			return fail
+		}
+		applied = append(applied, l)
+		return nil
+	}), &config.DefaultConfig().Replica)
+	ctx := context.Background()
+
+	assert.Nil(t, r.apply(ctx, []byte("\x00a"), []byte(`{"old":"","new":"1","ts":7}`)))
+	assert.Equal(t, []store.Log{{New: "1", Ts: 7}}, applied)
+	assert.Nil(t, r.apply(ctx, []byte("\x00a"), []byte(`{"old":"1","new":"stale","ts":8}`)))
+	assert.Equal(t, fail, r.apply(ctx, []byte("\x00a"), []byte(`{"old":"1","new":"fail","ts":9}`)))
+
+	// skipped: no stamp, not json, audit records
+	assert.Nil(t, r.apply(ctx, []byte("\x00a"), []byte(`{"old":"","new":"2"}`)))
+	assert.Nil(t, r.apply(ctx, []byte("\x00a"), []byte("\x00avro")))
+	assert.Nil(t, r.apply(ctx, []byte{store.AuditType, 'a'}, []byte(`{"new":"3","ts":10}`)))
+	assert.Len(t, applied, 1)
+}
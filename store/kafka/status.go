@@ -0,0 +1,120 @@
+package kafka
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/store"
+)
+
+const statusInterval = 10 * time.Second
+
+// producerStats counts the producer results between two ticks, the rates of
+// the last interval are kept for Status.
+type producerStats struct {
+	errors    int64
+	successes int64
+	timed     int64
+	delivery  int64
+	rates     atomic.Value
+}
+
+type producerRates struct {
+	errorRate float64
+	delivery  time.Duration
+}
+
+func (st *producerStats) tick() {
+	errors := atomic.SwapInt64(&st.errors, 0)
+	successes := atomic.SwapInt64(&st.successes, 0)
+	timed := atomic.SwapInt64(&st.timed, 0)
+	delivery := atomic.SwapInt64(&st.delivery, 0)
+	var r producerRates
+	if errors+successes > 0 {
+		r.errorRate = float64(errors) / float64(errors+successes)
+	}
+	if timed > 0 {
+		r.delivery = time.Duration(delivery / timed)
+	}
+	st.rates.Store(r)
+}
+
+func (c *Connector) onSuccess(success *sarama.ProducerMessage) {
+	atomic.AddInt64(&c.stats.successes, 1)
+	// replayed messages carry no delivery
+	if d, ok := success.Metadata.(*delivery); ok {
+		if !d.at.IsZero() {
+			t := time.Since(d.at)
+			metric.Delivery.Observe(t.Seconds())
+			atomic.AddInt64(&c.stats.timed, 1)
+			atomic.AddInt64(&c.stats.delivery, int64(t))
+		}
+		// a transaction is acked once committed
+		if !c.transactional() {
+			c.ack(d)
+		}
+	}
+	c.log.Debugf("key %s, partition %d, offset %d",
+		success.Key, success.Partition, success.Offset)
+}
+
+// onError puts a queue message which failed after the retries back to the
+// queue, after the messages read since. The checkpoint stays before it
+// when it can't be queued, so it's sent again after a restart. A failed
+// transaction is sent again as a whole instead.
+func (c *Connector) onError(err *sarama.ProducerError) {
+	atomic.AddInt64(&c.stats.errors, 1)
+	metric.ProducerErrors.Inc()
+	c.log.Errorf("producer failed, %s", err)
+	if err.Msg == nil || c.transactional() {
+		return
+	}
+	d, ok := err.Msg.Metadata.(*delivery)
+	if !ok {
+		return
+	}
+	if qErr := c.putQueue(d.msg); qErr != nil {
+		c.log.Errorf("requeue key %s failed, %s", d.msg.Key, qErr)
+		return
+	}
+	metric.Requeued.Inc()
+	c.ack(d)
+}
+
+// lag is the age of the last message read while more are waiting, it keeps
+// growing while the producer is stuck.
+func (c *Connector) lag(depth int64) time.Duration {
+	head := atomic.LoadInt64(&c.headTime)
+	if head == 0 || depth == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, head))
+}
+
+// Status reports the backlog, and a problem for every limit of the config
+// it exceeds.
+func (c *Connector) Status() store.ConnectorStatus {
+	r, _ := c.stats.rates.Load().(producerRates)
+	depth := c.queue.Depth()
+	lag := c.lag(depth)
+	st := store.ConnectorStatus{
+		Depth:           depth,
+		Bytes:           atomic.LoadInt64(&c.pending),
+		LagSeconds:      lag.Seconds(),
+		ErrorRate:       r.errorRate,
+		DeliverySeconds: r.delivery.Seconds(),
+	}
+	conf := c.conf.Connector
+	if conf.MaxDepth > 0 && depth > conf.MaxDepth {
+		st.Problems = append(st.Problems, fmt.Sprintf("depth %d > %d", depth, conf.MaxDepth))
+	}
+	if conf.MaxLag.Duration > 0 && lag > conf.MaxLag.Duration {
+		st.Problems = append(st.Problems, fmt.Sprintf("lag %s > %s", lag, conf.MaxLag.Duration))
+	}
+	if conf.MaxErrorRate > 0 && r.errorRate > conf.MaxErrorRate {
+		st.Problems = append(st.Problems, fmt.Sprintf("error rate %.3f > %.3f", r.errorRate, conf.MaxErrorRate))
+	}
+	return st
+}
@@ -0,0 +1,55 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectorStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conf.Connector.MaxDepth = 2
+	conf.Connector.MaxErrorRate = 0.5
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, c.putQueue(store.KeyEntry{Key: []byte(fmt.Sprintf("k%d", i)), Entry: []byte("v")}))
+	}
+	st := c.Status()
+	assert.Equal(t, int64(5), st.Depth)
+	// size, key length and time, key, entry
+	assert.Equal(t, int64(5*(4+4+8+2+1)), st.Bytes)
+	assert.Equal(t, []string{"depth 5 > 2"}, st.Problems)
+
+	c.onSuccess(&sarama.ProducerMessage{Metadata: &delivery{at: time.Now().Add(-time.Second)}})
+	c.onError(&sarama.ProducerError{Err: errors.New("down")})
+	c.onError(&sarama.ProducerError{Err: errors.New("down")})
+	c.stats.tick()
+	st = c.Status()
+	assert.InDelta(t, 2.0/3, st.ErrorRate, 0.001)
+	assert.True(t, st.DeliverySeconds >= 1)
+	assert.Equal(t, 2, len(st.Problems))
+	c.Close()
+
+	// the unread messages are counted again after a restart
+	conn, err = Driver{}.Open(conf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5*(4+4+8+2+1)), conn.(*Connector).Status().Bytes)
+	conn.Close()
+}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/version"
+)
+
+// txnGroup keeps the queue position committed by the transactions of a
+// transactional id, as the offset metadata of partition 0 of the topic.
+func txnGroup(id string) string {
+	return version.APP + "-txn-" + id
+}
+
+func (c *Connector) transactional() bool {
+	return c.producer != nil && c.producer.IsTransactional()
+}
+
+// readTxnCheckpoint returns the queue position of the last transaction
+// committed, ok is false before the first one.
+func (c *Connector) readTxnCheckpoint() (p store.ReplayPosition, ok bool, err error) {
+	client, err := sarama.NewClient(c.conf.Connector.BrokerList, c.cfg)
+	if err != nil {
+		return p, false, err
+	}
+	defer client.Close()
+	om, err := sarama.NewOffsetManagerFromClient(txnGroup(c.conf.Connector.TransactionalID), client)
+	if err != nil {
+		return p, false, err
+	}
+	defer om.Close()
+	pom, err := om.ManagePartition(c.conf.Connector.Topic, 0)
+	if err != nil {
+		return p, false, err
+	}
+	defer pom.AsyncClose()
+	_, meta := pom.NextOffset()
+	if meta == "" {
+		return p, false, nil
+	}
+	err = json.Unmarshal([]byte(meta), &p)
+	return p, err == nil, err
+}
+
+// flushTxn sends a batch in a transaction with the read position, every
+// message read before is either in it or skipped.
+func (c *Connector) flushTxn(batch []*sarama.ProducerMessage) {
+	if !c.sendTxn(c.readPos, func() {
+		for _, msg := range batch {
+			// sarama keeps the state of a send in the message
+			c.produce(&sarama.ProducerMessage{Topic: msg.Topic, Key: msg.Key, Value: msg.Value,
+				Metadata: msg.Metadata}, false)
+		}
+	}) {
+		return
+	}
+	for _, msg := range batch {
+		c.ack(msg.Metadata.(*delivery))
+	}
+}
+
+// replayTxn sends the messages read but not committed before a restart in
+// a transaction up to the read position.
+func (c *Connector) replayTxn(from, to store.ReplayPosition) {
+	if !c.sendTxn(to, func() {
+		err := c.startReplay(from, to)
+		if err != nil {
+			c.log.Errorf("replay from checkpoint %v failed, %s", from, err)
+		}
+		c.replayWg.Wait()
+	}) {
+		return
+	}
+	c.cpMu.Lock()
+	if len(c.inflight) == 0 && c.ackPos.Before(to) {
+		c.ackPos = to
+	}
+	c.cpMu.Unlock()
+	c.saveCheckpoint()
+}
+
+// sendTxn sends the messages of send with the queue position in a
+// transaction, it's aborted and sent again until it's committed or the
+// connector is closed.
+func (c *Connector) sendTxn(pos store.ReplayPosition, send func()) bool {
+	for attempt := 1; ; attempt++ {
+		err := c.commitTxn(pos, send)
+		if err == nil {
+			return true
+		}
+		metric.Aborted.Inc()
+		c.log.Errorf("transaction up to %v failed %d times, %s", pos, attempt, err)
+		select {
+		case <-c.closed:
+			return false
+		case <-time.After(c.conf.Connector.BackOff.Duration):
+		}
+	}
+}
+
+func (c *Connector) commitTxn(pos store.ReplayPosition, send func()) error {
+	if err := c.producer.BeginTxn(); err != nil {
+		return err
+	}
+	send()
+	b, err := json.Marshal(pos)
+	if err == nil {
+		meta := string(b)
+		err = c.producer.AddOffsetsToTxn(map[string][]*sarama.PartitionOffsetMetadata{
+			c.conf.Connector.Topic: {{Partition: 0, Offset: 0, Metadata: &meta}},
+		}, txnGroup(c.conf.Connector.TransactionalID))
+	}
+	if err == nil {
+		err = c.endTxn(c.producer.CommitTxn)
+		if err == nil {
+			return nil
+		}
+	}
+	if abortErr := c.endTxn(c.producer.AbortTxn); abortErr != nil {
+		c.log.Errorf("abort transaction failed, %s", abortErr)
+	}
+	return err
+}
+
+// endTxn keeps draining the results while the producer flushes the
+// transaction, the producer stops when nobody reads them.
+func (c *Connector) endTxn(end func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- end()
+	}()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case success := <-c.producer.Successes():
+			c.onSuccess(success)
+		case err := <-c.producer.Errors():
+			c.onError(err)
+		}
+	}
+}
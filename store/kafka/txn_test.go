@@ -0,0 +1,177 @@
+package kafka
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/stretchr/testify/assert"
+)
+
+// txnProducer keeps the queue positions committed, the first failCommits
+// commits fail. Like sarama, a transaction ends after the results of its
+// messages, the mock ends it at once.
+type txnProducer struct {
+	*mocks.AsyncProducer
+	in          chan *sarama.ProducerMessage
+	successes   chan *sarama.ProducerMessage
+	errors      chan *sarama.ProducerError
+	sent        int64
+	results     int64
+	mu          sync.Mutex
+	failCommits int
+	pending     store.ReplayPosition
+	committed   []store.ReplayPosition
+}
+
+func newTxnProducer(t *testing.T) *txnProducer {
+	cfg := sarama.NewConfig()
+	cfg.Version = sarama.V2_1_0_0
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Idempotent = true
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Transaction.ID = "test"
+	cfg.Net.MaxOpenRequests = 1
+	p := &txnProducer{
+		AsyncProducer: mocks.NewAsyncProducer(t, cfg),
+		in:            make(chan *sarama.ProducerMessage),
+		successes:     make(chan *sarama.ProducerMessage, cfg.ChannelBufferSize),
+		errors:        make(chan *sarama.ProducerError, cfg.ChannelBufferSize),
+	}
+	go func() {
+		for msg := range p.in {
+			// a message without a topic marks the end of a transaction
+			if flushed, ok := msg.Metadata.(chan struct{}); ok && msg.Topic == "" {
+				for atomic.LoadInt64(&p.results) < p.sent {
+					time.Sleep(time.Millisecond)
+				}
+				close(flushed)
+				continue
+			}
+			p.sent++
+			p.AsyncProducer.Input() <- msg
+		}
+		p.AsyncProducer.AsyncClose()
+	}()
+	go func() {
+		for msg := range p.AsyncProducer.Successes() {
+			p.successes <- msg
+			atomic.AddInt64(&p.results, 1)
+		}
+		close(p.successes)
+	}()
+	go func() {
+		for err := range p.AsyncProducer.Errors() {
+			p.errors <- err
+			atomic.AddInt64(&p.results, 1)
+		}
+		close(p.errors)
+	}()
+	return p
+}
+
+func (p *txnProducer) Input() chan<- *sarama.ProducerMessage     { return p.in }
+func (p *txnProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *txnProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *txnProducer) AsyncClose()                               { close(p.in) }
+
+func (p *txnProducer) flush() {
+	flushed := make(chan struct{})
+	p.in <- &sarama.ProducerMessage{Metadata: flushed}
+	<-flushed
+}
+
+func (p *txnProducer) AbortTxn() error {
+	p.flush()
+	return p.AsyncProducer.AbortTxn()
+}
+
+func (p *txnProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, group string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, o := range offsets {
+		if err := json.Unmarshal([]byte(*o[0].Metadata), &p.pending); err != nil {
+			return err
+		}
+	}
+	return p.AsyncProducer.AddOffsetsToTxn(offsets, group)
+}
+
+func (p *txnProducer) CommitTxn() error {
+	p.flush()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failCommits > 0 {
+		p.failCommits--
+		return errors.New("fenced")
+	}
+	p.committed = append(p.committed, p.pending)
+	return p.AsyncProducer.CommitTxn()
+}
+
+func (p *txnProducer) last() []store.ReplayPosition {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]store.ReplayPosition(nil), p.committed...)
+}
+
+func TestConnectorTxn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "txn")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.DefaultConfig()
+	conf.Connector.QueueDataPath = dir
+	conf.Connector.EnableProducer = false
+	conf.Connector.Idempotent = true
+	conf.Connector.TransactionalID = "test"
+	conf.Connector.BatchSize = 2
+	conf.Connector.BatchTimeout = &config.Duration{Duration: time.Hour}
+	conf.Connector.BackOff = &config.Duration{Duration: time.Millisecond}
+
+	// the aborted batch is sent again in the next transaction
+	producer := newTxnProducer(t)
+	producer.failCommits = 1
+	expectEntries(producer.AsyncProducer, 0, 2)
+	expectEntries(producer.AsyncProducer, 0, 4)
+	conn, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+	c := conn.(*Connector)
+	c.producer = producer
+	go c.runProducer()
+	sendEntries(t, c, 0, 4)
+	for i := 0; i < 200 && len(producer.last()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close()
+	meta, err := ReadQueueMeta(dir)
+	assert.Nil(t, err)
+	end := store.ReplayPosition{FileNum: meta.ReadFileNum, Pos: meta.ReadPos}
+	committed := producer.last()
+	assert.Len(t, committed, 2)
+	assert.Equal(t, end, committed[1])
+	assert.Equal(t, end, c.Checkpoint())
+	_, err = c.Rewind(store.ReplayPosition{})
+	assert.NotNil(t, err)
+
+	// messages read after the last commit are sent again in one
+	assert.Nil(t, writeCheckpoint(dir, committed[0]))
+	producer = newTxnProducer(t)
+	expectEntries(producer.AsyncProducer, 2, 4)
+	c = openReplayConnector(t, conf, producer)
+	for i := 0; i < 200 && len(producer.last()) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Close()
+	assert.Equal(t, []store.ReplayPosition{end}, producer.last())
+	assert.Equal(t, end, c.Checkpoint())
+}
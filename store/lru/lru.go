@@ -0,0 +1,133 @@
+package lru
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils"
+)
+
+const CacheName = "lru"
+
+type entry struct {
+	key      string
+	val      store.Value
+	expireAt time.Time
+}
+
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type Driver struct {
+}
+
+func init() {
+	store.RegisterCache(Driver{})
+}
+
+func (d Driver) Name() string {
+	return CacheName
+}
+
+func (d Driver) Open(conf *config.Config) (store.Cache, error) {
+	return New(conf.Cache.Size), nil
+}
+
+func New(size int) *LRU {
+	return &LRU{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Close() error {
+	c.mu.Lock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *LRU) Get(key []byte) (store.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ele, ok := c.items[utils.B2S(key)]
+	if !ok {
+		return store.NoValue, false
+	}
+	e := ele.Value.(*entry)
+	if time.Now().After(e.expireAt) {
+		c.removeElement(ele)
+		return store.NoValue, false
+	}
+	c.ll.MoveToFront(ele)
+	return e.val, true
+}
+
+func (c *LRU) Set(key []byte, val store.Value, ttl time.Duration) {
+	if c.size <= 0 || ttl <= 0 {
+		return
+	}
+	// the caller may reuse key and value, keep a private copy
+	v := store.Value{
+		Secondary: val.Secondary,
+		Value:     append([]byte(nil), val.Value...),
+		Ts:        val.Ts,
+		Seq:       val.Seq,
+	}
+	expireAt := time.Now().Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ele, ok := c.items[utils.B2S(key)]; ok {
+		e := ele.Value.(*entry)
+		e.val = v
+		e.expireAt = expireAt
+		c.ll.MoveToFront(ele)
+		return
+	}
+	k := string(key)
+	c.items[k] = c.ll.PushFront(&entry{key: k, val: v, expireAt: expireAt})
+	for c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *LRU) Delete(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ele, ok := c.items[utils.B2S(key)]; ok {
+		c.removeElement(ele)
+	}
+}
+
+func (c *LRU) DeleteRange(start, end []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, ele := range c.items {
+		key := utils.S2B(k)
+		if bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0) {
+			c.removeElement(ele)
+		}
+	}
+}
+
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU) removeElement(ele *list.Element) {
+	c.ll.Remove(ele)
+	delete(c.items, ele.Value.(*entry).key)
+}
@@ -0,0 +1,78 @@
+package lru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	t.Parallel()
+	ttl := time.Minute
+
+	t.Run("evict", func(t *testing.T) {
+		c := New(2)
+		c.Set([]byte("a"), store.Value{Value: []byte("1")}, ttl)
+		c.Set([]byte("b"), store.Value{Value: []byte("2")}, ttl)
+		_, ok := c.Get([]byte("a"))
+		assert.True(t, ok)
+		c.Set([]byte("c"), store.Value{Value: []byte("3")}, ttl)
+		_, ok = c.Get([]byte("b"))
+		assert.False(t, ok)
+		v, ok := c.Get([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), v.Value)
+		assert.Equal(t, 2, c.Len())
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		c := New(2)
+		c.Set([]byte("a"), store.Value{Value: []byte("1")}, time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+		_, ok := c.Get([]byte("a"))
+		assert.False(t, ok)
+		assert.Equal(t, 0, c.Len())
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		c := New(2)
+		val := []byte("1")
+		c.Set([]byte("a"), store.Value{Value: val}, ttl)
+		val[0] = '2'
+		v, _ := c.Get([]byte("a"))
+		assert.Equal(t, []byte("1"), v.Value)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		c := New(10)
+		for _, k := range []string{"a", "b", "c", "d"} {
+			c.Set([]byte(k), store.Value{Value: []byte(k)}, ttl)
+		}
+		c.Set([]byte("e"), store.NoValue, ttl)
+		v, ok := c.Get([]byte("e"))
+		assert.True(t, ok)
+		assert.Equal(t, 0, len(v.Value))
+		c.DeleteRange([]byte("b"), []byte("e"))
+		assert.Equal(t, 2, c.Len())
+		_, ok = c.Get([]byte("e"))
+		assert.True(t, ok)
+		c.Delete([]byte("a"))
+		assert.Equal(t, 1, c.Len())
+	})
+
+	t.Run("stamp", func(t *testing.T) {
+		c := New(2)
+		c.Set([]byte("a"), store.Value{Value: []byte("1"), Ts: 42, Seq: 7}, ttl)
+		for i := 0; i < 2; i++ {
+			v, ok := c.Get([]byte("a"))
+			assert.True(t, ok)
+			assert.Equal(t, uint64(42), v.Ts)
+			assert.Equal(t, uint64(7), v.Seq)
+		}
+		c.Set([]byte("b"), store.Value{Secondary: true, Value: []byte("2")}, ttl)
+		v, _ := c.Get([]byte("b"))
+		assert.True(t, v.Secondary)
+	})
+}
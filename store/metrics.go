@@ -0,0 +1,191 @@
+package store
+
+import (
+	"github.com/huangnauh/tirest/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Metric struct {
+	CacheHit          prometheus.Counter
+	CacheMiss         prometheus.Counter
+	CacheNegativeHit  prometheus.Counter
+	CacheInvalidate   prometheus.Counter
+	HotKeyQPS         *prometheus.GaugeVec
+	HotRegions        prometheus.Gauge
+	HotRegionSplit    *prometheus.CounterVec
+	BreakerState      *prometheus.GaugeVec
+	BreakerRejected   *prometheus.CounterVec
+	BulkheadInflight  *prometheus.GaugeVec
+	BulkheadRejected  *prometheus.CounterVec
+	PriorityWaiting   *prometheus.GaugeVec
+	PriorityRejected  *prometheus.CounterVec
+	StoreState        prometheus.Gauge
+	EventExcluded     prometheus.Counter
+	WatchClients      prometheus.Gauge
+	WatchOverflow     prometheus.Counter
+	WatchResumeMissed prometheus.Counter
+	ReplicaConflict   *prometheus.CounterVec
+	ValueRewrite      prometheus.Counter
+	WriteDedup        prometheus.Counter
+	ChecksumMismatch  *prometheus.CounterVec
+	ReadRepair        *prometheus.CounterVec
+	CoalesceBatchSize prometheus.Histogram
+	Timeout           *prometheus.CounterVec
+	Shadow            *prometheus.CounterVec
+	Fault             *prometheus.CounterVec
+}
+
+var metric = newMetric()
+
+func newMetric() *Metric {
+	return &Metric{
+		CacheHit: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "cache_hit_total",
+			Help:      "A counter for cache hits.",
+		}),
+		CacheMiss: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "cache_miss_total",
+			Help:      "A counter for cache misses.",
+		}),
+		CacheNegativeHit: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "cache_negative_hit_total",
+			Help:      "A counter for cache hits on missing keys.",
+		}),
+		CacheInvalidate: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "cache_invalidate_total",
+			Help:      "A counter for cache invalidations on write.",
+		}),
+		HotKeyQPS: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "hot_key_qps",
+			Help:      "Estimated qps of the hottest keys by rank.",
+		}, []string{"rank"}),
+		HotRegions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "hot_regions",
+			Help:      "The regions written at hot-region.min-qps or more.",
+		}),
+		HotRegionSplit: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "hot_region_split_total",
+			Help:      "A counter for hot regions split by result.",
+		}, []string{"result"}),
+		BreakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "breaker_state",
+			Help:      "The circuit breaker state by operation, 0 closed, 1 open, 2 half open.",
+		}, []string{"op"}),
+		BreakerRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "breaker_rejected_total",
+			Help:      "A counter for calls rejected by an open circuit breaker.",
+		}, []string{"op"}),
+		BulkheadInflight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "bulkhead_inflight",
+			Help:      "The concurrent calls by operation class.",
+		}, []string{"class"}),
+		BulkheadRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "bulkhead_rejected_total",
+			Help:      "A counter for calls rejected by a full bulkhead.",
+		}, []string{"class"}),
+		PriorityWaiting: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "priority_waiting",
+			Help:      "The calls waiting for a slot by priority class.",
+		}, []string{"class"}),
+		PriorityRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "priority_rejected_total",
+			Help:      "A counter for calls rejected after waiting max-wait for a slot.",
+		}, []string{"class"}),
+		StoreState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "store_state",
+			Help:      "The store state, 0 initializing, 1 ready, 2 degraded, 3 closed.",
+		}),
+		EventExcluded: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "event_excluded_total",
+			Help:      "A counter for changes excluded by the event rules.",
+		}),
+		WatchClients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Subsystem: version.APP,
+			Name:      "watch_clients",
+			Help:      "The clients watching changes.",
+		}),
+		WatchOverflow: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "watch_overflow_total",
+			Help:      "A counter for watches closed for falling behind.",
+		}),
+		WatchResumeMissed: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "watch_resume_missed_total",
+			Help:      "A counter for watches resumed after the history kept.",
+		}),
+		ReplicaConflict: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "replica_conflict_total",
+			Help:      "A counter for changes of another cluster concurrent with a local one by the side kept.",
+		}, []string{"winner"}),
+		ValueRewrite: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "value_rewrite_total",
+			Help:      "A counter for values sealed again by the primary key once read.",
+		}),
+		WriteDedup: prometheus.NewCounter(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "write_dedup_total",
+			Help:      "A counter for puts skipped as the value was stored already.",
+		}),
+		ChecksumMismatch: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "checksum_mismatch_total",
+			Help:      "A counter for values read which didn't match their checksum.",
+		}, []string{"result"}),
+		ReadRepair: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "read_repair_total",
+			Help:      "A counter for the good copies found by reads written back, by source and result.",
+		}, []string{"source", "result"}),
+		CoalesceBatchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Subsystem: version.APP,
+			Name:      "coalesce_batch_size",
+			Help:      "The puts written by a coalesced batch.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		Timeout: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "store_timeout_total",
+			Help:      "A counter for store calls out of time by endpoint.",
+		}, []string{"endpoint"}),
+		Shadow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "shadow_total",
+			Help:      "A counter for calls mirrored to the shadow by op and result, match or mismatch for reads.",
+		}, []string{"op", "result"}),
+		Fault: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Subsystem: version.APP,
+			Name:      "fault_injected_total",
+			Help:      "A counter for faults injected by target and op.",
+		}, []string{"target", "op"}),
+	}
+}
+
+func (m *Metric) mustRegister() {
+	prometheus.MustRegister(m.CacheHit, m.CacheMiss, m.CacheNegativeHit, m.CacheInvalidate,
+		m.HotKeyQPS, m.HotRegions, m.HotRegionSplit, m.BreakerState, m.BreakerRejected,
+		m.BulkheadInflight, m.BulkheadRejected, m.PriorityWaiting, m.PriorityRejected, m.StoreState, m.EventExcluded,
+		m.WatchClients, m.WatchOverflow, m.WatchResumeMissed, m.ReplicaConflict, m.ValueRewrite, m.WriteDedup, m.ChecksumMismatch, m.ReadRepair,
+		m.CoalesceBatchSize, m.Timeout, m.Shadow, m.Fault)
+}
+
+func init() {
+	metric.mustRegister()
+}
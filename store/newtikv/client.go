@@ -2,6 +2,7 @@ package newtikv
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -38,7 +39,10 @@ type Range struct {
 }
 
 type TiKV struct {
+	mu              sync.RWMutex
 	client          kv.Storage
+	reader          kv.Storage
+	pd              *pdMonitor
 	store           tikv.Storage
 	pdClient        pd.Client
 	cancel          context.CancelFunc
@@ -60,8 +64,6 @@ func (d Driver) Name() string {
 }
 
 func (d Driver) Open(conf *config.Config) (store.DB, error) {
-	driver := tikv.Driver{}
-
 	if conf.Store.TsoSlowThreshold != nil {
 		oracles.SlowDist = conf.Store.TsoSlowThreshold.Duration
 	}
@@ -71,39 +73,66 @@ func (d Driver) Open(conf *config.Config) (store.DB, error) {
 	tikvConfig.StoreGlobalConfig(cfg)
 	err := logutil.InitZapLogger(cfg.Log.ToLogConfig())
 
-	s, err := driver.Open(conf.Store.Path)
-	if err != nil {
-		return nil, err
-	}
-
 	var ignoreKill uint32
 	disableLockVars := kv.NewVariables(&ignoreKill)
 	disableLockVars.DisableLockBackOff = true
 
 	t := &TiKV{
-		client:          s,
 		conf:            conf,
 		deleteChan:      make(chan Range, 10),
 		log:             logrus.WithFields(logrus.Fields{"worker": DBName}),
 		disableLockVars: disableLockVars,
 	}
+	t.client, err = t.open()
+	if err != nil {
+		return nil, err
+	}
+	if conf.Store.SeparateReads {
+		t.reader, err = t.openReader()
+		if err != nil {
+			t.client.Close()
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	go t.runDelete(ctx)
+	if conf.Reconnect.Enable {
+		t.pd = newPDMonitor(t)
+		go t.pd.run(ctx)
+	}
+	//https://github.com/pingcap/tidb/pull/12095
+	//gConfig := tiConfig.GetGlobalConfig()
 
-	if conf.Store.GCEnable {
+	return t, nil
+}
+
+// open opens the storage at the store path, and starts its gc worker with
+// gc-enable.
+func (t *TiKV) open() (kv.Storage, error) {
+	driver := tikv.Driver{}
+	s, err := driver.Open(t.conf.Store.Path)
+	if err != nil {
+		return nil, err
+	}
+	if t.conf.Store.GCEnable {
 		if raw, ok := s.(tikv.EtcdBackend); ok {
 			tikv.NewGCHandlerFunc = t.NewGCWorker
 			err = raw.StartGCWorker()
 			if err != nil {
+				s.Close()
 				return nil, err
 			}
 		}
 	}
-	ctx, cancel := context.WithCancel(context.Background())
-	t.cancel = cancel
-	go t.runDelete(ctx)
-	//https://github.com/pingcap/tidb/pull/12095
-	//gConfig := tiConfig.GetGlobalConfig()
+	return s, nil
+}
 
-	return t, nil
+// storage is the storage in use, it's replaced when PD is reconnected.
+func (t *TiKV) storage() kv.Storage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.client
 }
 
 func (t *TiKV) NewGCWorker(store tikv.Storage, pdClient pd.Client) (tikv.GCHandler, error) {
@@ -114,15 +143,23 @@ func (t *TiKV) NewGCWorker(store tikv.Storage, pdClient pd.Client) (tikv.GCHandl
 
 func (t *TiKV) Close() error {
 	t.cancel()
-	return t.client.Close()
+	if t.pd != nil {
+		<-t.pd.done
+	}
+	if t.reader != nil {
+		if err := t.reader.Close(); err != nil {
+			t.log.Errorf("close reader failed, %s", err)
+		}
+	}
+	return t.storage().Close()
 }
 
-func (t *TiKV) Get(key []byte, option store.GetOption) (store.Value, error) {
+func (t *TiKV) Get(ctx context.Context, key []byte, option store.GetOption) (store.Value, error) {
 	start := time.Now()
-	tx, err := t.client.Begin()
+	tx, err := t.readStorage().Begin()
 	if err != nil {
 		t.log.Errorf("client begin failed %s", err)
-		return store.NoValue, xerror.ErrGetTimestampFailed
+		return store.NoValue, wrapError(xerror.ErrGetTimestampFailed, err)
 	}
 	startTs := tx.StartTS()
 	t.log.Debugf("start ts %d, %s", startTs, key)
@@ -133,7 +170,7 @@ func (t *TiKV) Get(key []byte, option store.GetOption) (store.Value, error) {
 		snapshot.SetOption(kv.ReplicaRead, kv.ReplicaReadFollower)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.ReadTimeout.Duration)
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.ReadTimeout.Duration)
 	execDetail := &execdetails.StmtExecDetails{}
 	ctx = context.WithValue(ctx, execdetails.StmtExecDetailKey, execDetail)
 	defer cancel()
@@ -156,16 +193,31 @@ func (t *TiKV) Get(key []byte, option store.GetOption) (store.Value, error) {
 	}
 	if err != nil {
 		t.log.Errorf("get %s failed %s", key, err)
-		return store.NoValue, xerror.ErrGetKVFailed
+		return store.NoValue, wrapError(xerror.ErrGetKVFailed, err)
 	}
 	return store.Value{Secondary: secondary, Value: v}, nil
 }
 
-func (t *TiKV) List(start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
-	tx, err := t.client.Begin()
+func (t *TiKV) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	ret := make([]store.KeyValue, 0)
+	err := t.Scan(ctx, start, end, limit, option, func(k, v []byte) error {
+		ret = append(ret, store.KeyValue{Key: utils.B2S(k), Value: utils.B2S(v)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Scan hands the items to fn as the iterator reads them, the keys and the
+// values are new slices and may be kept.
+func (t *TiKV) Scan(ctx context.Context, start, end []byte, limit int, option store.ListOption,
+	fn func(key, val []byte) error) error {
+	tx, err := t.readStorage().Begin()
 	if err != nil {
 		t.log.Errorf("client begin failed %s", err)
-		return nil, xerror.ErrGetTimestampFailed
+		return wrapError(xerror.ErrGetTimestampFailed, err)
 	}
 
 	if option.KeyOnly {
@@ -191,12 +243,11 @@ func (t *TiKV) List(start, end []byte, limit int, option store.ListOption) ([]st
 
 	if err != nil {
 		t.log.Errorf("iter (%s-%s) failed %s", err, start, end)
-		return nil, xerror.ErrListKVFailed
+		return wrapError(xerror.ErrListKVFailed, err)
 	}
 
 	defer it.Close()
 
-	ret := make([]store.KeyValue, 0)
 	for it.Valid() {
 		k := it.Key()
 		t.log.Debugf("iter key %v", k)
@@ -208,30 +259,34 @@ func (t *TiKV) List(start, end []byte, limit int, option store.ListOption) ([]st
 		k, v, err = option.Item(k, v)
 		if err != nil {
 			t.log.Warnf("iter (%s-%s) key %s, err %s", start, end, k, err)
-			continue
+		} else {
+			if err = fn(k, v); err != nil {
+				return err
+			}
+			limit--
+			if limit <= 0 {
+				break
+			}
 		}
-
-		ret = append(ret, store.KeyValue{Key: utils.B2S(k), Value: utils.B2S(v)})
-
-		limit--
-		if limit <= 0 {
-			break
+		if err = ctx.Err(); err != nil {
+			t.log.Errorf("iter (%s-%s) stopped, %s", start, end, err)
+			return wrapError(xerror.ErrListKVFailed, err)
 		}
 		err = it.Next()
 		if err != nil {
 			t.log.Errorf("iter next (%s-%s) failed %s", err, start, end)
-			return nil, xerror.ErrListKVFailed
+			return wrapError(xerror.ErrListKVFailed, err)
 		}
 	}
-	return ret, nil
+	return nil
 }
 
-func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, check store.CheckOption) error {
+func (t *TiKV) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, check store.CheckOption) error {
 	start := time.Now()
-	tx, err := t.client.Begin()
+	tx, err := t.storage().Begin()
 	if err != nil {
 		t.log.Errorf("client begin failed %s", err)
-		return xerror.ErrGetTimestampFailed
+		return wrapError(xerror.ErrGetTimestampFailed, err)
 	}
 	startTs := tx.StartTS()
 
@@ -243,7 +298,7 @@ func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, check store.CheckOption)
 	snapshotStats := &tikv.SnapshotRuntimeStats{}
 	snapshot.SetOption(kv.CollectRuntimeStats, snapshotStats)
 
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.WriteTimeout.Duration)
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.WriteTimeout.Duration)
 	var commitDetail *execdetails.CommitDetails
 	ctx = context.WithValue(ctx, execdetails.CommitDetailCtxKey, &commitDetail)
 	execDetail := &execdetails.StmtExecDetails{}
@@ -256,12 +311,14 @@ func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, check store.CheckOption)
 		existVal = nil
 	} else if err != nil {
 		t.log.Errorf("cas %s get failed %s", key, err)
-		return xerror.ErrGetKVFailed
+		return wrapError(xerror.ErrGetKVFailed, err)
 	}
 
 	if check.Check != nil {
 		newVal, err = check.Check(oldVal, newVal, existVal)
-		if err != nil {
+		if errors.Is(err, xerror.ErrCheckAndSetFailed) {
+			return store.NewConflictError(err, existVal, startTs)
+		} else if err != nil {
 			return err
 		}
 	}
@@ -274,7 +331,7 @@ func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, check store.CheckOption)
 
 	if err != nil {
 		t.log.Errorf("cas %s put failed %s", key, err)
-		return xerror.ErrCheckAndSetFailed
+		return wrapError(xerror.ErrCheckAndSetFailed, err)
 	}
 
 	err = tx.Commit(ctx)
@@ -289,16 +346,16 @@ func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, check store.CheckOption)
 
 	if err != nil {
 		t.log.Errorf("cas %s commit failed %s", key, err)
-		return xerror.ErrCheckAndSetFailed
+		return wrapError(xerror.ErrCheckAndSetFailed, err)
 	}
 	return nil
 }
 
-func (t *TiKV) Put(key, val []byte) error {
-	tx, err := t.client.Begin()
+func (t *TiKV) Put(ctx context.Context, key, val []byte) error {
+	tx, err := t.storage().Begin()
 	if err != nil {
 		t.log.Errorf("client begin failed %s", err)
-		return xerror.ErrGetTimestampFailed
+		return wrapError(xerror.ErrGetTimestampFailed, err)
 	}
 
 	if len(val) == 0 {
@@ -309,24 +366,24 @@ func (t *TiKV) Put(key, val []byte) error {
 
 	if err != nil {
 		t.log.Errorf("put %s put failed %s", key, err)
-		return xerror.ErrSetKVFailed
+		return wrapError(xerror.ErrSetKVFailed, err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.WriteTimeout.Duration)
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.WriteTimeout.Duration)
 	defer cancel()
 	err = tx.Commit(ctx)
 	if err != nil {
 		t.log.Errorf("put %s commit failed %s", key, err)
-		return xerror.ErrCommitKVFailed
+		return wrapError(xerror.ErrCommitKVFailed, err)
 	}
 	return nil
 }
 
-func (t *TiKV) BatchPut(items []store.KeyEntry) error {
-	tx, err := t.client.Begin()
+func (t *TiKV) BatchPut(ctx context.Context, items []store.KeyEntry) error {
+	tx, err := t.storage().Begin()
 	if err != nil {
 		t.log.Errorf("begin failed %s", err)
-		return xerror.ErrGetTimestampFailed
+		return wrapError(xerror.ErrGetTimestampFailed, err)
 	}
 
 	for _, item := range items {
@@ -339,30 +396,30 @@ func (t *TiKV) BatchPut(items []store.KeyEntry) error {
 
 	if err != nil {
 		t.log.Errorf("put failed %s", err)
-		return xerror.ErrSetKVFailed
+		return wrapError(xerror.ErrSetKVFailed, err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.BatchPutTimeout.Duration)
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.BatchPutTimeout.Duration)
 	defer cancel()
 	err = tx.Commit(ctx)
 	if err != nil {
 		t.log.Errorf("batch put commit failed %s", err)
-		return xerror.ErrCommitKVFailed
+		return wrapError(xerror.ErrCommitKVFailed, err)
 	}
 	return nil
 }
 
-func (t *TiKV) BatchDelete(start, end []byte, limit int) ([]byte, int, error) {
-	tx, err := t.client.Begin()
+func (t *TiKV) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	tx, err := t.storage().Begin()
 	if err != nil {
 		t.log.Errorf("begin failed %s", err)
-		return nil, 0, xerror.ErrGetTimestampFailed
+		return nil, 0, wrapError(xerror.ErrGetTimestampFailed, err)
 	}
 
 	it, err := tx.Iter(kv.Key(start), kv.Key(end))
 	if err != nil {
 		t.log.Errorf("iter (%s-%s) failed %s", start, end, err)
-		return nil, 0, xerror.ErrListKVFailed
+		return nil, 0, wrapError(xerror.ErrListKVFailed, err)
 	}
 	defer it.Close()
 	tx.SetOption(kv.KeyOnly, true)
@@ -394,17 +451,17 @@ func (t *TiKV) BatchDelete(start, end []byte, limit int) ([]byte, int, error) {
 		}
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.BatchDeleteTimeout.Duration)
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.BatchDeleteTimeout.Duration)
 	defer cancel()
 	err = tx.Commit(ctx)
 	if err != nil {
-		return nil, 0, xerror.ErrCommitKVFailed
+		return nil, 0, wrapError(xerror.ErrCommitKVFailed, err)
 	}
 
 	return lastKey, count, nil
 }
 
-func (t *TiKV) UnsafeDelete(start, end []byte) error {
+func (t *TiKV) UnsafeDelete(_ context.Context, start, end []byte) error {
 	select {
 	case t.deleteChan <- Range{start, end}:
 		t.log.Infof("accept delete (%s-%s)", start, end)
@@ -499,7 +556,7 @@ func (t *TiKV) doUnsafeDestroyRangeRequest(ctx context.Context, startKey []byte,
 	err = notifyTask.Execute(ctx)
 	if err != nil {
 		t.log.Errorf("failed notifying regions affected by UnsafeDestroyRange, %s", err)
-		return xerror.ErrNotifyDeleteRangeFailed
+		return wrapError(xerror.ErrNotifyDeleteRangeFailed, err)
 	}
 
 	return nil
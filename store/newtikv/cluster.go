@@ -0,0 +1,122 @@
+package newtikv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+const (
+	pdMembersPath = "/pd/api/v1/members"
+	// milliseconds
+	clusterMaxBackoff = 5000
+)
+
+type pdMember struct {
+	Name       string   `json:"name"`
+	MemberID   uint64   `json:"member_id"`
+	ClientURLs []string `json:"client_urls"`
+}
+
+type pdMembers struct {
+	Members []pdMember `json:"members"`
+	Leader  *pdMember  `json:"leader"`
+}
+
+// members asks the PD http api, the grpc client doesn't list members.
+func (t *TiKV) members(ctx context.Context) ([]store.ClusterMember, error) {
+	var lastErr error
+	for _, addr := range t.conf.Store.PdAddresses {
+		if !strings.Contains(addr, "://") {
+			addr = "http://" + addr
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr+pdMembersPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		var m pdMembers
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("pd %s status %d", addr, resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&m)
+		}
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		members := make([]store.ClusterMember, 0, len(m.Members))
+		for _, member := range m.Members {
+			members = append(members, store.ClusterMember{
+				Name:       member.Name,
+				ClientURLs: member.ClientURLs,
+				Leader:     m.Leader != nil && m.Leader.MemberID == member.MemberID,
+			})
+		}
+		return members, nil
+	}
+	return nil, lastErr
+}
+
+// Cluster returns the pd status along with an error, it tells why PD can't
+// be reached.
+func (t *TiKV) Cluster(ctx context.Context, ranges []store.KeyRange) (*store.ClusterInfo, error) {
+	info := &store.ClusterInfo{Regions: make(map[string]int, len(ranges))}
+	if t.pd != nil {
+		info.PD = t.pd.Status()
+	}
+	storage := t.storage()
+	if _, ok := storage.(closedStorage); ok {
+		return info, wrapError(xerror.ErrGetClusterFailed, errNotConnected)
+	}
+	s, ok := storage.(tikv.Storage)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.ReadTimeout.Duration)
+	defer cancel()
+	cache := s.GetRegionCache()
+	pdClient := cache.PDClient()
+	info.ClusterID = pdClient.GetClusterID(ctx)
+	members, err := t.members(ctx)
+	if err != nil {
+		// stores and regions are still useful
+		t.log.Warnf("get pd members failed, %s", err)
+	}
+	info.Members = members
+
+	stores, err := pdClient.GetAllStores(ctx)
+	if err != nil {
+		return info, wrapError(xerror.ErrGetClusterFailed, err)
+	}
+	for _, st := range stores {
+		info.Stores = append(info.Stores, store.ClusterStore{
+			ID:      st.Id,
+			Address: st.Address,
+			Version: st.Version,
+			State:   st.State.String(),
+		})
+	}
+
+	for _, r := range ranges {
+		bo := tikv.NewBackoffer(ctx, clusterMaxBackoff)
+		regions, err := cache.LoadRegionsInKeyRange(bo, r.Start, r.End)
+		if err != nil {
+			return nil, wrapError(xerror.ErrGetClusterFailed, err)
+		}
+		info.Regions[r.Name] = len(regions)
+	}
+	return info, nil
+}
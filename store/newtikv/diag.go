@@ -0,0 +1,147 @@
+package newtikv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/util/execdetails"
+)
+
+const (
+	DiagRegion = "pd_region"
+	DiagStores = "pd_stores"
+	DiagTSO    = "tso"
+	DiagGet    = "get"
+)
+
+var errNoRegion = errors.New("no region for the key")
+
+// step runs fn as the step name of diag.
+func step(diag *store.KeyDiagnosis, name string, fn func() (string, error)) error {
+	start := time.Now()
+	detail, err := fn()
+	s := store.DiagStep{Name: name, Duration: time.Since(start), Detail: detail}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	diag.Steps = append(diag.Steps, s)
+	return err
+}
+
+func containsPeer(peers []*metapb.Peer, id uint64) bool {
+	for _, p := range peers {
+		if p.GetId() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// DiagnoseKey asks PD for the region of key and the stores of its peers,
+// then reads the key with a timestamp of its own, each step timed.
+func (t *TiKV) DiagnoseKey(ctx context.Context, key []byte) (*store.KeyDiagnosis, error) {
+	storage := t.readStorage()
+	if _, ok := storage.(closedStorage); ok {
+		return nil, wrapError(xerror.ErrGetClusterFailed, errNotConnected)
+	}
+	s, ok := storage.(tikv.Storage)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.ReadTimeout.Duration)
+	defer cancel()
+	pdClient := s.GetRegionCache().PDClient()
+	diag := &store.KeyDiagnosis{}
+	var leader uint64
+
+	err := step(diag, DiagRegion, func() (string, error) {
+		region, err := pdClient.GetRegion(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if region == nil || region.Meta == nil {
+			return "", errNoRegion
+		}
+		r := &store.KeyRegion{
+			ID:      region.Meta.GetId(),
+			Version: region.Meta.GetRegionEpoch().GetVersion(),
+			ConfVer: region.Meta.GetRegionEpoch().GetConfVer(),
+			Start:   region.Meta.GetStartKey(),
+			End:     region.Meta.GetEndKey(),
+		}
+		for _, p := range region.Meta.GetPeers() {
+			r.Peers = append(r.Peers, store.RegionPeer{
+				ID:      p.GetId(),
+				StoreID: p.GetStoreId(),
+				Down:    containsPeer(region.DownPeers, p.GetId()),
+				Pending: containsPeer(region.PendingPeers, p.GetId()),
+			})
+		}
+		diag.Region = r
+		if region.Leader == nil {
+			return "no leader", nil
+		}
+		leader = region.Leader.GetId()
+		return "", nil
+	})
+	if err != nil {
+		return diag, wrapError(xerror.ErrGetClusterFailed, err)
+	}
+
+	err = step(diag, DiagStores, func() (string, error) {
+		peers := diag.Region.Peers
+		for i := range peers {
+			st, err := pdClient.GetStore(ctx, peers[i].StoreID)
+			if err != nil {
+				return "", err
+			}
+			peers[i].Address = st.GetAddress()
+			if peers[i].ID == leader {
+				diag.Region.Leader = &peers[i]
+			}
+		}
+		return "", nil
+	})
+	if err != nil {
+		return diag, wrapError(xerror.ErrGetClusterFailed, err)
+	}
+
+	var tx kv.Transaction
+	err = step(diag, DiagTSO, func() (string, error) {
+		var err error
+		tx, err = s.Begin()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("start ts %d", tx.StartTS()), nil
+	})
+	if err != nil {
+		return diag, wrapError(xerror.ErrGetTimestampFailed, err)
+	}
+
+	err = step(diag, DiagGet, func() (string, error) {
+		snapshot := tx.GetSnapshot()
+		snapshotStats := &tikv.SnapshotRuntimeStats{}
+		snapshot.SetOption(kv.CollectRuntimeStats, snapshotStats)
+		execDetail := &execdetails.StmtExecDetails{}
+		ctx := context.WithValue(ctx, execdetails.StmtExecDetailKey, execDetail)
+		v, err := tx.Get(ctx, key)
+		if kv.IsErrNotFound(err) {
+			err = nil
+		} else if err == nil {
+			diag.Found, diag.Size = true, len(v)
+		}
+		return fmt.Sprintf("%s%s", execDetailsString(execDetail), snapshotStats), err
+	})
+	if err != nil {
+		return diag, wrapError(xerror.ErrGetKVFailed, err)
+	}
+	return diag, nil
+}
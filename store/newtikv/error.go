@@ -0,0 +1,39 @@
+package newtikv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+var unavailableErrors = []interface{ Equal(error) bool }{
+	tikv.ErrTiKVServerTimeout,
+	tikv.ErrTiKVServerBusy,
+	tikv.ErrRegionUnavailable,
+	tikv.ErrPDServerTimeout,
+	tikv.ErrResolveLockTimeout,
+}
+
+func isUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errNotConnected) {
+		return true
+	}
+	for _, e := range unavailableErrors {
+		if e.Equal(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapError keeps the TiKV error as the cause, errors from a busy or
+// unreachable cluster are reported as unavailable.
+func wrapError(e *xerror.Error, err error) error {
+	w := e.Wrap(err)
+	if isUnavailable(err) {
+		w.Category = xerror.Unavailable
+	}
+	return w
+}
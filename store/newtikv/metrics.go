@@ -17,6 +17,8 @@ type Metric struct {
 	GetCommitTsTimeHistogram    *prometheus.HistogramVec
 	CommitBackOffTimeHistogram  *prometheus.HistogramVec
 	ResolveLockTimeHistogram    *prometheus.HistogramVec
+	PDConnected                 prometheus.Gauge
+	PDReconnect                 prometheus.Counter
 }
 
 var metric = newMetric()
@@ -111,6 +113,20 @@ func newMetric() *Metric {
 			},
 			[]string{"method"},
 		),
+		PDConnected: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Subsystem: "tikv",
+				Name:      "pd_connected",
+				Help:      "Whether the last check of pd succeeded, 1 connected, 0 not.",
+			},
+		),
+		PDReconnect: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Subsystem: "tikv",
+				Name:      "pd_reconnect_total",
+				Help:      "A counter for storages opened again after pd moved or failed.",
+			},
+		),
 		CommitBackOffTimeHistogram: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Subsystem: "tikv",
@@ -132,6 +148,8 @@ func registerMetrics() {
 	prometheus.MustRegister(metric.GetCommitTsTimeHistogram)
 	prometheus.MustRegister(metric.ResolveLockTimeHistogram)
 	prometheus.MustRegister(metric.CommitBackOffTimeHistogram)
+	prometheus.MustRegister(metric.PDConnected)
+	prometheus.MustRegister(metric.PDReconnect)
 
 	prometheus.MustRegister(metrics.TiKVBackoffHistogram)
 	prometheus.MustRegister(metrics.TiKVCoprocessorHistogram)
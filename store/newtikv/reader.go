@@ -0,0 +1,44 @@
+package newtikv
+
+import (
+	pd "github.com/pingcap/pd/v4/client"
+	tikvConfig "github.com/pingcap/tidb/config"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/util/execdetails"
+)
+
+// openReader opens a storage for the gets and lists besides the one of the
+// driver, with its own PD client, region cache and gRPC connections, so a
+// long scan doesn't hold up the writes. It's left out of the driver cache,
+// which keeps a single storage of a cluster, and doesn't load the gc safe
+// point, the reads are at the current ts.
+func (t *TiKV) openReader() (kv.Storage, error) {
+	addrs, _, err := tikvConfig.ParsePath(t.conf.Store.Path)
+	if err != nil {
+		return nil, err
+	}
+	security := tikvConfig.GetGlobalConfig().Security
+	pdCli, err := pd.NewClient(addrs, pd.SecurityOption{
+		CAPath:   security.ClusterSSLCA,
+		CertPath: security.ClusterSSLCert,
+		KeyPath:  security.ClusterSSLKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tikv.NewTestTiKVStore(tikv.NewTestRPCClient(security), pdCli, nil, func(c pd.Client) pd.Client {
+		return execdetails.InterceptedPDClient{Client: c}
+	}, 0)
+}
+
+// readStorage is the storage of the gets and lists, the one in use without
+// separate-reads.
+func (t *TiKV) readStorage() kv.Storage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.reader != nil {
+		return t.reader
+	}
+	return t.client
+}
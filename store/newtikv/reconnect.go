@@ -0,0 +1,235 @@
+package newtikv
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+// pdMonitor checks PD every reconnect.interval, the TiKV storage is opened
+// again once the PD addresses resolve to others, e.g. PD moved or scaled
+// behind a DNS name, or failure-threshold checks in a row failed. A failed
+// reconnect is retried with a jittered backoff up to max-backoff.
+type pdMonitor struct {
+	t    *TiKV
+	done chan struct{}
+
+	mu     sync.Mutex
+	status store.PDStatus
+}
+
+func newPDMonitor(t *TiKV) *pdMonitor {
+	return &pdMonitor{t: t, done: make(chan struct{})}
+}
+
+func (m *pdMonitor) run(ctx context.Context) {
+	defer close(m.done)
+	conf := m.t.conf.Reconnect
+	addrs, err := resolvePD(ctx, m.t.conf.Store.PdAddresses)
+	if err != nil {
+		m.t.log.Warnf("resolve pd %v failed, %s", m.t.conf.Store.PdAddresses, err)
+	}
+	m.mu.Lock()
+	m.status.Addresses = addrs
+	m.status.Connected = true
+	m.mu.Unlock()
+	metric.PDConnected.Set(1)
+
+	backoff := conf.Interval.Duration
+	timer := time.NewTimer(conf.Interval.Duration)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		resolved, rerr := resolvePD(ctx, m.t.conf.Store.PdAddresses)
+		changed := rerr == nil && len(addrs) > 0 && !equalAddrs(resolved, addrs)
+		if rerr == nil && len(addrs) == 0 {
+			addrs = resolved
+		}
+		failures := m.check(ctx)
+		next := conf.Interval.Duration
+		if changed || failures >= conf.FailureThreshold {
+			m.t.log.Warnf("reconnect pd, addresses %v to %v, %d failures", addrs, resolved, failures)
+			if err := m.t.reconnect(ctx); err != nil {
+				m.t.log.Errorf("reconnect pd failed, %s", err)
+				next = jitter(backoff)
+				backoff *= 2
+				if backoff > conf.MaxBackoff.Duration {
+					backoff = conf.MaxBackoff.Duration
+				}
+			} else {
+				if rerr == nil {
+					addrs = resolved
+				}
+				backoff = conf.Interval.Duration
+				m.reconnected(addrs)
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// check gets a timestamp from PD, it returns the failures in a row.
+func (m *pdMonitor) check(ctx context.Context) int {
+	err := errNotConnected
+	s, ok := m.t.storage().(tikv.Storage)
+	if ok {
+		ctx, cancel := context.WithTimeout(ctx, m.t.conf.Store.ReadTimeout.Duration)
+		_, _, err = s.GetRegionCache().PDClient().GetTS(ctx)
+		cancel()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.LastCheck = time.Now()
+	if err != nil {
+		m.status.Failures++
+		m.status.LastError = err.Error()
+		m.status.Connected = false
+		metric.PDConnected.Set(0)
+		m.t.log.Warnf("pd check failed %d times, %s", m.status.Failures, err)
+	} else {
+		m.status.Failures = 0
+		m.status.LastError = ""
+		m.status.Connected = true
+		metric.PDConnected.Set(1)
+	}
+	return m.status.Failures
+}
+
+func (m *pdMonitor) reconnected(addrs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status.Addresses = addrs
+	m.status.Connected = true
+	m.status.Failures = 0
+	m.status.LastError = ""
+	m.status.Reconnects++
+	m.status.LastReconnect = time.Now()
+	metric.PDConnected.Set(1)
+	metric.PDReconnect.Inc()
+}
+
+func (m *pdMonitor) Status() *store.PDStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.status
+	return &st
+}
+
+// reconnect opens the storage again, the old one is closed first since the
+// driver keeps a single storage of a cluster. The calls in between fail.
+func (t *TiKV) reconnect(ctx context.Context) error {
+	old := t.storage()
+	if c, ok := old.(closedStorage); ok {
+		old = c.Storage
+	} else if err := old.Close(); err != nil {
+		t.log.Warnf("close storage failed, %s", err)
+	}
+
+	s, err := t.open()
+	t.mu.Lock()
+	if err != nil {
+		// the next attempt doesn't close it again
+		t.client = closedStorage{old}
+		t.mu.Unlock()
+		return err
+	}
+	// Close may be waiting, it closes s
+	t.client = s
+	t.mu.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if t.conf.Store.SeparateReads {
+		t.reopenReader()
+	}
+	return nil
+}
+
+// reopenReader opens the reader of separate-reads again, its PD client may
+// be as lost. The reads go to the storage meanwhile, or after a failure.
+func (t *TiKV) reopenReader() {
+	t.mu.Lock()
+	old := t.reader
+	t.reader = nil
+	t.mu.Unlock()
+	if old != nil {
+		if err := old.Close(); err != nil {
+			t.log.Warnf("close reader failed, %s", err)
+		}
+	}
+	r, err := t.openReader()
+	if err != nil {
+		t.log.Errorf("open reader failed, %s", err)
+		return
+	}
+	t.mu.Lock()
+	t.reader = r
+	t.mu.Unlock()
+}
+
+var errNotConnected = errors.New("pd not connected")
+
+// closedStorage is a storage closed by a failed reconnect.
+type closedStorage struct {
+	kv.Storage
+}
+
+func (closedStorage) Close() error {
+	return nil
+}
+
+// resolvePD resolves the hosts of the PD addresses, the result is sorted
+// to be compared.
+func resolvePD(ctx context.Context, pdAddresses []string) ([]string, error) {
+	addrs := make([]string, 0, len(pdAddresses))
+	for _, addr := range pdAddresses {
+		if i := strings.Index(addr, "://"); i >= 0 {
+			addr = addr[i+3:]
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func equalAddrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// jitter is d +- 20%.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()*0.4-0.2)*float64(d))
+}
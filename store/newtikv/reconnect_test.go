@@ -0,0 +1,29 @@
+package newtikv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolvePD(t *testing.T) {
+	ctx := context.Background()
+	addrs, err := resolvePD(ctx, []string{"http://127.0.0.2:2379", "127.0.0.1:2379", "localhost:2380"})
+	assert.Nil(t, err)
+	assert.Contains(t, addrs, "127.0.0.1:2379")
+	assert.Contains(t, addrs, "127.0.0.1:2380")
+	assert.Equal(t, "127.0.0.1:2379", addrs[0])
+	again, err := resolvePD(ctx, []string{"localhost:2380", "127.0.0.1:2379", "127.0.0.2:2379"})
+	assert.Nil(t, err)
+	assert.True(t, equalAddrs(addrs, again))
+
+	_, err = resolvePD(ctx, []string{"127.0.0.1"})
+	assert.NotNil(t, err)
+
+	for i := 0; i < 100; i++ {
+		d := jitter(10 * time.Second)
+		assert.True(t, d >= 8*time.Second && d <= 12*time.Second, d)
+	}
+}
@@ -0,0 +1,79 @@
+package newtikv
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+// SplitRegions asks PD to split at keys, and to scatter the new regions.
+func (t *TiKV) SplitRegions(ctx context.Context, keys [][]byte, scatter, wait bool) ([]uint64, error) {
+	s, ok := t.storage().(kv.SplittableStore)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	ids, err := s.SplitRegions(ctx, keys, scatter)
+	if err != nil {
+		return ids, wrapError(xerror.ErrSplitRegionFailed, err)
+	}
+	if !scatter || !wait {
+		return ids, nil
+	}
+	for _, id := range ids {
+		err = s.WaitScatterRegionFinish(ctx, id, 0)
+		if err != nil {
+			t.log.Warnf("wait scatter region %d, %s", id, err)
+			return ids, wrapError(xerror.ErrSplitRegionFailed, err)
+		}
+	}
+	return ids, nil
+}
+
+// RegionKeys returns the region boundaries inside (start, end) from the
+// region cache, which loads the regions missing from PD.
+func (t *TiKV) RegionKeys(ctx context.Context, start, end []byte) ([][]byte, error) {
+	s, ok := t.readStorage().(tikv.Storage)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	bo := tikv.NewBackoffer(ctx, clusterMaxBackoff)
+	regions, err := s.GetRegionCache().LoadRegionsInKeyRange(bo, start, end)
+	if err != nil {
+		return nil, wrapError(xerror.ErrListKVFailed, err)
+	}
+	keys := make([][]byte, 0, len(regions))
+	for _, r := range regions {
+		key := r.EndKey()
+		// the last region has no end
+		if len(key) == 0 || bytes.Compare(key, start) <= 0 || (len(end) > 0 && bytes.Compare(key, end) >= 0) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// LocateKey returns the region of key from the region cache, the leader
+// and the peers are left out.
+func (t *TiKV) LocateKey(ctx context.Context, key []byte) (*store.KeyRegion, error) {
+	s, ok := t.readStorage().(tikv.Storage)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	bo := tikv.NewBackoffer(ctx, clusterMaxBackoff)
+	loc, err := s.GetRegionCache().LocateKey(bo, key)
+	if err != nil {
+		return nil, wrapError(xerror.ErrGetClusterFailed, err)
+	}
+	return &store.KeyRegion{
+		ID:      loc.Region.GetID(),
+		Version: loc.Region.GetVer(),
+		ConfVer: loc.Region.GetConfVer(),
+		Start:   loc.StartKey,
+		End:     loc.EndKey,
+	}, nil
+}
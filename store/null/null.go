@@ -0,0 +1,46 @@
+package null
+
+import (
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/version"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const Name = "null"
+
+// Events counts the changes dropped.
+var Events = prometheus.NewCounter(prometheus.CounterOpts{
+	Subsystem: version.APP,
+	Name:      "connector_null_events_total",
+	Help:      "A counter for changes dropped by the null connector.",
+})
+
+type Driver struct {
+}
+
+func init() {
+	prometheus.MustRegister(Events)
+	store.RegisterConnector(Driver{})
+}
+
+func (d Driver) Name() string {
+	return Name
+}
+
+func (d Driver) Open(conf *config.Config) (store.Connector, error) {
+	return Connector{}, nil
+}
+
+// Connector drops the changes, e.g. in development or where nothing
+// consumes them.
+type Connector struct {
+}
+
+func (c Connector) Close() {
+}
+
+func (c Connector) Send(msg store.KeyEntry) error {
+	Events.Inc()
+	return nil
+}
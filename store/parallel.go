@@ -0,0 +1,216 @@
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/huangnauh/tirest/utils"
+	"golang.org/x/time/rate"
+)
+
+// span is a part [start, end) of a list.
+type span struct {
+	start, end []byte
+}
+
+// listSpans splits [start, end) at the region boundaries, in the order of
+// the list, without the catalog. It's nil when the range is clear of the
+// catalog and parallel-list is off or regionSpans is nil, the list is read
+// as a whole then.
+func (s *Store) listSpans(ctx context.Context, start, end []byte, reverse bool) []span {
+	var spans []span
+	if conf := s.conf.ParallelList; conf.Enable {
+		spans = s.regionSpans(ctx, start, end, conf.MinRegions)
+	}
+	if spans == nil {
+		if !overlapsCatalog(start, end) {
+			return nil
+		}
+		spans = []span{{start: start, end: end}}
+	}
+	if reverse {
+		for i, j := 0, len(spans)-1; i < j; i, j = i+1, j-1 {
+			spans[i], spans[j] = spans[j], spans[i]
+		}
+	}
+	return outsideCatalog(spans, reverse)
+}
+
+// regionSpans splits [start, end) at the region boundaries. It's nil when
+// the range is on fewer than minRegions regions or the DB doesn't know its
+// regions.
+func (s *Store) regionSpans(ctx context.Context, start, end []byte, minRegions int) []span {
+	locator, ok := baseDB(s.db).(RegionLocator)
+	if !ok {
+		return nil
+	}
+	keys, err := locator.RegionKeys(ctx, start, end)
+	if err != nil {
+		s.log.Warnf("locate regions (%s-%s) failed, %s", start, end, err)
+		return nil
+	}
+	if len(keys)+1 < minRegions {
+		return nil
+	}
+
+	spans := make([]span, 0, len(keys)+1)
+	from := start
+	for _, key := range keys {
+		spans = append(spans, span{start: from, end: key})
+		from = key
+	}
+	return append(spans, span{start: from, end: end})
+}
+
+// scanSpans lists Concurrency of the spans at once and hands the items to
+// fn in the order of the spans, until limit items. Each span of a round is
+// read with the limit left, a round may read more than is sent.
+func (s *Store) scanSpans(ctx context.Context, spans []span, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	concurrency := s.conf.ParallelList.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	n := 0
+	for len(spans) > 0 {
+		round := spans
+		if len(round) > concurrency {
+			round = round[:concurrency]
+		}
+		spans = spans[len(round):]
+
+		left := limit
+		if limit > 0 {
+			left = limit - n
+		}
+		results := make([][]KeyValue, len(round))
+		errs := make([]error, len(round))
+		var wg sync.WaitGroup
+		for i, sp := range round {
+			wg.Add(1)
+			go func(i int, sp span) {
+				defer wg.Done()
+				results[i], errs[i] = s.db.List(ctx, sp.start, sp.end, left, option)
+			}(i, sp)
+		}
+		wg.Wait()
+
+		for i := range round {
+			if errs[i] != nil {
+				return errs[i]
+			}
+			for _, item := range results[i] {
+				if err := fn(utils.S2B(item.Key), utils.S2B(item.Value)); err != nil {
+					return err
+				}
+				n++
+				if limit > 0 && n >= limit {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteRange deletes [start, end) by batches of limit keys and calls
+// progress with the keys deleted so far after each batch. With
+// parallel-delete, a range on at least MinRegions regions is deleted region
+// by region, Concurrency regions at once and at most Rate keys a second in
+// all, the first error stops the other regions. The catalog is left out.
+func (s *Store) DeleteRange(ctx context.Context, start, end []byte, limit int,
+	progress func(deleted int)) (int, error) {
+	conf := s.conf.ParallelDelete
+	var spans []span
+	if conf.Enable {
+		spans = s.regionSpans(ctx, start, end, conf.MinRegions)
+	}
+	if spans == nil {
+		spans = []span{{start: start, end: end}}
+	}
+	spans = outsideCatalog(spans, false)
+	var limiter *rate.Limiter
+	if conf.Enable && conf.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(conf.Rate), conf.Rate)
+		if limit <= 0 || limit > conf.Rate {
+			limit = conf.Rate
+		}
+	}
+	workers := 1
+	if conf.Enable {
+		workers = conf.Concurrency
+	}
+	if workers > len(spans) {
+		workers = len(spans)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var mu sync.Mutex
+	var firstErr error
+	count := 0
+	add := func(n int) {
+		mu.Lock()
+		count += n
+		progress(count)
+		mu.Unlock()
+	}
+
+	ch := make(chan span)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sp := range ch {
+				if err := s.deleteSpan(ctx, sp, limit, limiter, add); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+	for _, sp := range spans {
+		select {
+		case ch <- sp:
+		case <-ctx.Done():
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return count, firstErr
+}
+
+// deleteSpan deletes sp by batches of limit keys, or at once without a
+// limit. A batch waits for limit
+// keys of the limiter first.
+func (s *Store) deleteSpan(ctx context.Context, sp span, limit int, limiter *rate.Limiter,
+	add func(n int)) error {
+	from := sp.start
+	for {
+		if limiter != nil {
+			if err := limiter.WaitN(ctx, limit); err != nil {
+				return err
+			}
+		}
+		lastKey, deleted, err := s.BatchDelete(ctx, from, sp.end, limit)
+		if err != nil {
+			return err
+		}
+		add(deleted)
+		if limit <= 0 || deleted < limit {
+			return nil
+		}
+		from = lastKey
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// regionDB splits its keys at boundaries and counts the lists.
+type regionDB struct {
+	memDB
+	boundaries [][]byte
+	lists      int32
+	mu         sync.Mutex
+	deletes    int32
+}
+
+func (d *regionDB) RegionKeys(ctx context.Context, start, end []byte) ([][]byte, error) {
+	var keys [][]byte
+	for _, key := range d.boundaries {
+		if bytes.Compare(key, start) > 0 && bytes.Compare(key, end) < 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (d *regionDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	atomic.AddInt32(&d.lists, 1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, 0)
+	for k := range d.kv {
+		if k >= string(start) && k < string(end) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if option.Reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	items := make([]KeyValue, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, KeyValue{Key: k, Value: d.kv[k]})
+	}
+	return items, nil
+}
+
+func (d *regionDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	atomic.AddInt32(&d.deletes, 1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys := make([]string, 0)
+	for k := range d.kv {
+		if k >= string(start) && (len(end) == 0 || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	for _, k := range keys {
+		delete(d.kv, k)
+	}
+	if len(keys) == 0 {
+		return nil, 0, nil
+	}
+	return []byte(keys[len(keys)-1]), len(keys), nil
+}
+
+func TestParallelList(t *testing.T) {
+	db := &regionDB{memDB: memDB{kv: map[string]string{}}}
+	for i := 0; i < 100; i++ {
+		db.kv[fmt.Sprintf("k%03d", i)] = fmt.Sprint(i)
+	}
+	for i := 10; i < 100; i += 10 {
+		db.boundaries = append(db.boundaries, []byte(fmt.Sprintf("k%03d", i)))
+	}
+	conf := config.DefaultConfig()
+	conf.ParallelList = config.ParallelList{Enable: true, MinRegions: 4, Concurrency: 3}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	keys := func(items []KeyValue) []string {
+		res := make([]string, 0, len(items))
+		for _, item := range items {
+			res = append(res, item.Key)
+		}
+		return res
+	}
+	tests := []struct {
+		start, end string
+		limit      int
+		reverse    bool
+		lists      int32
+	}{
+		{"k000", "k999", 0, false, 10},
+		{"k005", "k095", 25, false, 3},
+		{"k005", "k095", 25, true, 3},
+		{"k000", "k050", 50, true, 5},
+		// on 3 regions, one list
+		{"k015", "k040", 0, false, 1},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&db.lists, 0)
+		want, err := db.List(ctx, []byte(tt.start), []byte(tt.end), tt.limit, ListOption{Reverse: tt.reverse})
+		assert.Nil(t, err)
+		atomic.StoreInt32(&db.lists, 0)
+
+		option := ListOption{Reverse: tt.reverse}
+		res, err := s.List(ctx, []byte(tt.start), []byte(tt.end), tt.limit, option)
+		assert.Nil(t, err)
+		assert.Equal(t, keys(want), keys(res), tt.start)
+		assert.Equal(t, tt.lists, atomic.LoadInt32(&db.lists), tt.start)
+
+		var scanned []KeyValue
+		err = s.Scan(ctx, []byte(tt.start), []byte(tt.end), tt.limit, option, func(item KeyValue) error {
+			scanned = append(scanned, item)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, keys(want), keys(scanned), tt.start)
+	}
+}
+
+func TestParallelDelete(t *testing.T) {
+	db := &regionDB{memDB: memDB{kv: map[string]string{}}}
+	for i := 0; i < 100; i++ {
+		db.kv[fmt.Sprintf("k%03d", i)] = fmt.Sprint(i)
+	}
+	for i := 10; i < 100; i += 10 {
+		db.boundaries = append(db.boundaries, []byte(fmt.Sprintf("k%03d", i)))
+	}
+	conf := config.DefaultConfig()
+	conf.ParallelDelete = config.ParallelDelete{Enable: true, MinRegions: 4, Concurrency: 3, Rate: 0}
+	s := &Store{db: db, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	var last int
+	count, err := s.DeleteRange(ctx, []byte("k005"), []byte("k095"), 4, func(deleted int) {
+		assert.True(t, deleted >= last)
+		last = deleted
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 90, count)
+	assert.Equal(t, 90, last)
+	assert.Equal(t, 10, len(db.kv))
+	// 8 regions of 10 keys in 3 batches, 2 of 5 keys in 2
+	assert.Equal(t, int32(28), atomic.LoadInt32(&db.deletes))
+
+	// on 2 regions, one range
+	atomic.StoreInt32(&db.deletes, 0)
+	count, err = s.DeleteRange(ctx, []byte("k000"), []byte("k020"), 10, func(int) {})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&db.deletes))
+
+	// the rate caps the batches
+	conf.ParallelDelete.Rate = 1000
+	atomic.StoreInt32(&db.deletes, 0)
+	count, err = s.DeleteRange(ctx, []byte("k095"), []byte(""), 10000, func(int) {})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 0, len(db.kv))
+}
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadPlugins opens the Go plugins at paths, a plugin registers its drivers
+// or hooks in its init. A plugin opened before isn't loaded again.
+func LoadPlugins(paths []string) error {
+	for _, p := range paths {
+		if _, err := plugin.Open(p); err != nil {
+			return fmt.Errorf("open plugin %s, %s", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPlugins(t *testing.T) {
+	assert.Nil(t, LoadPlugins(nil))
+	err := LoadPlugins([]string{filepath.Join(t.TempDir(), "missing.so")})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing.so")
+}
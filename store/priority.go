@@ -0,0 +1,219 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+const (
+	PriorityInteractive = "interactive"
+	PriorityBatch       = "batch"
+	PriorityBackground  = "background"
+)
+
+var priorityClasses = []string{PriorityInteractive, PriorityBatch, PriorityBackground}
+
+type priorityKey struct{}
+
+// WithPriority runs the store calls of ctx in class, a call without a class
+// is interactive.
+func WithPriority(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, priorityKey{}, class)
+}
+
+func priorityOf(ctx context.Context) int {
+	class, _ := ctx.Value(priorityKey{}).(string)
+	for i, c := range priorityClasses {
+		if c == class {
+			return i
+		}
+	}
+	return 0
+}
+
+// admission runs up to slots calls at once, a freed slot goes to the next
+// call waiting in the class picked by a smooth weighted round robin over
+// the classes with calls waiting, so a class is only slowed down by the
+// others, never starved. A call waits at most maxWait.
+type admission struct {
+	mu      sync.Mutex
+	free    int
+	weights []int
+	current []int
+	queues  []*list.List
+	maxWait time.Duration
+}
+
+func newAdmission(conf *config.Priority) *admission {
+	a := &admission{
+		free:    conf.Slots,
+		weights: []int{conf.InteractiveWeight, conf.BatchWeight, conf.BackgroundWeight},
+		current: make([]int, len(priorityClasses)),
+		queues:  make([]*list.List, len(priorityClasses)),
+		maxWait: conf.MaxWait.Duration,
+	}
+	for i := range a.queues {
+		a.queues[i] = list.New()
+	}
+	return a
+}
+
+func (a *admission) waiting() bool {
+	for _, q := range a.queues {
+		if q.Len() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *admission) acquire(ctx context.Context) error {
+	class := priorityOf(ctx)
+	a.mu.Lock()
+	if a.free > 0 && !a.waiting() {
+		a.free--
+		a.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := a.queues[class].PushBack(ready)
+	a.mu.Unlock()
+	metric.PriorityWaiting.WithLabelValues(priorityClasses[class]).Inc()
+	defer metric.PriorityWaiting.WithLabelValues(priorityClasses[class]).Dec()
+
+	timer := time.NewTimer(a.maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		metric.PriorityRejected.WithLabelValues(priorityClasses[class]).Inc()
+		err = xerror.ErrConcurrencyLimited
+	case <-ctx.Done():
+		err = contextError(ctx, ctx.Err())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-ready:
+		// the slot came meanwhile
+		return nil
+	default:
+	}
+	a.queues[class].Remove(elem)
+	return err
+}
+
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next, total := -1, 0
+	for i, q := range a.queues {
+		if q.Len() == 0 {
+			continue
+		}
+		a.current[i] += a.weights[i]
+		total += a.weights[i]
+		if next < 0 || a.current[i] > a.current[next] {
+			next = i
+		}
+	}
+	if next < 0 {
+		a.free++
+		return
+	}
+	a.current[next] -= total
+	close(a.queues[next].Remove(a.queues[next].Front()).(chan struct{}))
+}
+
+func (a *admission) do(ctx context.Context, fn func() error) error {
+	if err := a.acquire(ctx); err != nil {
+		return err
+	}
+	defer a.release()
+	return fn()
+}
+
+// priorityDB admits every call by the class of its context.
+type priorityDB struct {
+	DB
+	admission *admission
+}
+
+func newPriorityDB(db DB, conf *config.Priority) *priorityDB {
+	return &priorityDB{DB: db, admission: newAdmission(conf)}
+}
+
+func (p *priorityDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	var v Value
+	err := p.admission.do(ctx, func() error {
+		var err error
+		v, err = p.DB.Get(ctx, key, option)
+		return err
+	})
+	return v, err
+}
+
+func (p *priorityDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	var kvs []KeyValue
+	err := p.admission.do(ctx, func() error {
+		var err error
+		kvs, err = p.DB.List(ctx, start, end, limit, option)
+		return err
+	})
+	return kvs, err
+}
+
+// Scan holds its slot until fn has taken the last item.
+func (p *priorityDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return p.admission.do(ctx, func() error {
+		return scan(ctx, p.DB, start, end, limit, option, fn)
+	})
+}
+
+func (p *priorityDB) Put(ctx context.Context, key, val []byte) error {
+	return p.admission.do(ctx, func() error {
+		return p.DB.Put(ctx, key, val)
+	})
+}
+
+func (p *priorityDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	return p.admission.do(ctx, func() error {
+		return p.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	})
+}
+
+func (p *priorityDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	return p.admission.do(ctx, func() error {
+		return p.DB.BatchPut(ctx, items)
+	})
+}
+
+func (p *priorityDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	var lastKey []byte
+	var count int
+	err := p.admission.do(ctx, func() error {
+		var err error
+		lastKey, count, err = p.DB.BatchDelete(ctx, start, end, limit)
+		return err
+	})
+	return lastKey, count, err
+}
+
+func (p *priorityDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	return p.admission.do(ctx, func() error {
+		return p.DB.UnsafeDelete(ctx, start, end)
+	})
+}
+
+func (p *priorityDB) Unwrap() DB {
+	return p.DB
+}
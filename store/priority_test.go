@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmission(t *testing.T) {
+	a := newAdmission(&config.Priority{Slots: 1, InteractiveWeight: 2, BatchWeight: 1, BackgroundWeight: 1,
+		MaxWait: &config.Duration{Duration: time.Minute}})
+	ctx := context.Background()
+	assert.Nil(t, a.acquire(ctx))
+
+	got := make(chan string)
+	enqueue := func(class string, n int) {
+		for i := 0; i < n; i++ {
+			go func() {
+				assert.Nil(t, a.acquire(WithPriority(ctx, class)))
+				got <- class
+			}()
+		}
+	}
+	enqueue(PriorityBackground, 3)
+	enqueue(PriorityInteractive, 3)
+	assert.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.queues[0].Len() == 3 && a.queues[2].Len() == 3
+	}, time.Second, time.Millisecond)
+
+	var order []string
+	for i := 0; i < 6; i++ {
+		a.release()
+		order = append(order, <-got)
+	}
+	assert.Equal(t, []string{PriorityInteractive, PriorityBackground, PriorityInteractive, PriorityInteractive,
+		PriorityBackground, PriorityBackground}, order)
+	a.release()
+	assert.Equal(t, 1, a.free)
+
+	a.maxWait = 10 * time.Millisecond
+	assert.Nil(t, a.acquire(ctx))
+	err := a.acquire(WithPriority(ctx, PriorityBatch))
+	assert.True(t, errors.Is(err, xerror.ErrConcurrencyLimited), err)
+	assert.Equal(t, 0, a.queues[1].Len())
+	a.release()
+	assert.Equal(t, 1, a.free)
+}
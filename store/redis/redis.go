@@ -0,0 +1,316 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CacheName = "redis"
+	scanCount = "1000"
+)
+
+var errNil = errors.New("redis nil")
+
+type conn struct {
+	c  net.Conn
+	br *bufio.Reader
+	bw *bufio.Writer
+}
+
+type Redis struct {
+	pool    chan *conn
+	conf    *config.Cache
+	timeout time.Duration
+	log     *logrus.Entry
+}
+
+type Driver struct {
+}
+
+func init() {
+	store.RegisterCache(Driver{})
+}
+
+func (d Driver) Name() string {
+	return CacheName
+}
+
+func (d Driver) Open(conf *config.Config) (store.Cache, error) {
+	r := &Redis{
+		pool:    make(chan *conn, conf.Cache.RedisPoolSize),
+		conf:    &conf.Cache,
+		timeout: conf.Cache.RedisTimeout.Duration,
+		log:     logrus.WithFields(logrus.Fields{"worker": CacheName}),
+	}
+	cn, err := r.dial()
+	if err != nil {
+		r.log.Errorf("dial %s failed, %s", conf.Cache.RedisAddress, err)
+		return nil, err
+	}
+	r.put(cn, nil)
+	return r, nil
+}
+
+func (r *Redis) dial() (*conn, error) {
+	c, err := net.DialTimeout("tcp", r.conf.RedisAddress, r.timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{c: c, br: bufio.NewReader(c), bw: bufio.NewWriter(c)}
+	if r.conf.RedisPassword != "" {
+		if _, err = r.do(cn, "AUTH", r.conf.RedisPassword); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.conf.RedisDB != 0 {
+		if _, err = r.do(cn, "SELECT", strconv.Itoa(r.conf.RedisDB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (r *Redis) get() (*conn, error) {
+	select {
+	case cn := <-r.pool:
+		return cn, nil
+	default:
+		return r.dial()
+	}
+}
+
+// put returns the connection to the pool, a connection which failed with
+// a network error is closed instead because its stream is out of sync.
+func (r *Redis) put(cn *conn, err error) {
+	if err != nil && err != errNil {
+		if _, ok := err.(redisError); !ok {
+			cn.c.Close()
+			return
+		}
+	}
+	select {
+	case r.pool <- cn:
+	default:
+		cn.c.Close()
+	}
+}
+
+func (r *Redis) call(args ...string) (interface{}, error) {
+	cn, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := r.do(cn, args...)
+	r.put(cn, err)
+	return reply, err
+}
+
+func (r *Redis) do(cn *conn, args ...string) (interface{}, error) {
+	if r.timeout > 0 {
+		cn.c.SetDeadline(time.Now().Add(r.timeout))
+	}
+	cn.bw.WriteString("*")
+	cn.bw.WriteString(strconv.Itoa(len(args)))
+	cn.bw.WriteString("\r\n")
+	for _, arg := range args {
+		cn.bw.WriteString("$")
+		cn.bw.WriteString(strconv.Itoa(len(arg)))
+		cn.bw.WriteString("\r\n")
+		cn.bw.WriteString(arg)
+		cn.bw.WriteString("\r\n")
+	}
+	if err := cn.bw.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(cn.br)
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func readReply(br *bufio.Reader) (interface{}, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errNil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readReply(br)
+			if err != nil && err != errNil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid reply %q", line)
+}
+
+func (r *Redis) key(key []byte) string {
+	return r.conf.RedisPrefix + string(key)
+}
+
+func (r *Redis) Close() error {
+	for {
+		select {
+		case cn := <-r.pool:
+			cn.c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *Redis) Get(key []byte) (store.Value, bool) {
+	reply, err := r.call("GET", r.key(key))
+	if err == errNil {
+		return store.NoValue, false
+	} else if err != nil {
+		r.log.Warnf("get %s failed, %s", key, err)
+		return store.NoValue, false
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return store.NoValue, false
+	}
+	v, err := decodeValue(b)
+	if err != nil {
+		r.log.Warnf("get %s failed, %s", key, err)
+		return store.NoValue, false
+	}
+	return v, true
+}
+
+func (r *Redis) Set(key []byte, val store.Value, ttl time.Duration) {
+	px := int64(ttl / time.Millisecond)
+	if px <= 0 {
+		return
+	}
+	_, err := r.call("SET", r.key(key), string(encodeValue(val)), "PX", strconv.FormatInt(px, 10))
+	if err != nil {
+		r.log.Warnf("set %s failed, %s", key, err)
+	}
+}
+
+const flagSecondary = 1
+
+// encodeValue keeps the flags, the stamp and the sequence of the value in
+// front of it, so a cached value is read back with its X-Secondary, X-Ts
+// and X-Seq.
+func encodeValue(v store.Value) []byte {
+	b := make([]byte, 1+2*binary.MaxVarintLen64, 1+2*binary.MaxVarintLen64+len(v.Value))
+	if v.Secondary {
+		b[0] |= flagSecondary
+	}
+	n := 1 + binary.PutUvarint(b[1:], v.Ts)
+	n += binary.PutUvarint(b[n:], v.Seq)
+	return append(b[:n], v.Value...)
+}
+
+func decodeValue(b []byte) (store.Value, error) {
+	if len(b) == 0 {
+		return store.NoValue, errors.New("invalid cached value")
+	}
+	secondary := b[0]&flagSecondary != 0
+	ts, n := binary.Uvarint(b[1:])
+	if n <= 0 {
+		return store.NoValue, errors.New("invalid cached value")
+	}
+	n++
+	seq, m := binary.Uvarint(b[n:])
+	if m <= 0 {
+		return store.NoValue, errors.New("invalid cached value")
+	}
+	return store.Value{Secondary: secondary, Value: b[n+m:], Ts: ts, Seq: seq}, nil
+}
+
+func (r *Redis) Delete(key []byte) {
+	_, err := r.call("DEL", r.key(key))
+	if err != nil {
+		r.log.Warnf("del %s failed, %s", key, err)
+	}
+}
+
+// DeleteRange walks the prefix with SCAN, it's slow but range deletes are rare.
+func (r *Redis) DeleteRange(start, end []byte) {
+	cursor := "0"
+	for {
+		reply, err := r.call("SCAN", cursor, "MATCH", r.conf.RedisPrefix+"*", "COUNT", scanCount)
+		if err != nil {
+			r.log.Warnf("scan (%s-%s) failed, %s", start, end, err)
+			return
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return
+		}
+		next, _ := items[0].([]byte)
+		keys, _ := items[1].([]interface{})
+		for _, k := range keys {
+			b, _ := k.([]byte)
+			key := b[len(r.conf.RedisPrefix):]
+			if bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0) {
+				r.call("DEL", string(b))
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
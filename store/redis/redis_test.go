@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRedis serves GET and SET of the RESP protocol from a map.
+func fakeRedis(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br, bw := bufio.NewReader(c), bufio.NewWriter(c)
+				for {
+					reply, err := readReply(br)
+					if err != nil {
+						return
+					}
+					items := reply.([]interface{})
+					cmd := string(items[0].([]byte))
+					mu.Lock()
+					switch cmd {
+					case "SET":
+						data[string(items[1].([]byte))] = string(items[2].([]byte))
+						bw.WriteString("+OK\r\n")
+					case "GET":
+						v, ok := data[string(items[1].([]byte))]
+						if !ok {
+							bw.WriteString("$-1\r\n")
+						} else {
+							bw.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+						}
+					default:
+						bw.WriteString("-ERR unknown command\r\n")
+					}
+					mu.Unlock()
+					bw.Flush()
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func TestRedisValue(t *testing.T) {
+	l := fakeRedis(t)
+	defer l.Close()
+	conf := config.DefaultConfig()
+	conf.Cache.RedisAddress = l.Addr().String()
+	r := &Redis{
+		pool:    make(chan *conn, 1),
+		conf:    &conf.Cache,
+		timeout: time.Second,
+		log:     logrus.WithFields(logrus.Fields{"worker": CacheName}),
+	}
+	defer r.Close()
+
+	r.Set([]byte("a"), store.Value{Value: []byte("1"), Ts: 42, Seq: 7}, time.Minute)
+	for i := 0; i < 2; i++ {
+		v, ok := r.Get([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), v.Value)
+		assert.Equal(t, uint64(42), v.Ts)
+		assert.Equal(t, uint64(7), v.Seq)
+	}
+
+	r.Set([]byte("s"), store.Value{Secondary: true, Value: []byte("2")}, time.Minute)
+	v, ok := r.Get([]byte("s"))
+	assert.True(t, ok)
+	assert.Equal(t, store.Value{Secondary: true, Value: []byte("2")}, v)
+
+	r.Set([]byte("b"), store.NoValue, time.Minute)
+	v, ok = r.Get([]byte("b"))
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(v.Value))
+
+	_, ok = r.Get([]byte("c"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// Splitter is implemented by a DB which can split and scatter regions.
+type Splitter interface {
+	SplitRegions(ctx context.Context, keys [][]byte, scatter, wait bool) ([]uint64, error)
+}
+
+// RegionLocator is implemented by a DB which knows where its regions split,
+// RegionKeys returns the boundaries inside (start, end) in order.
+type RegionLocator interface {
+	RegionKeys(ctx context.Context, start, end []byte) ([][]byte, error)
+}
+
+// KeyLocator is implemented by a DB which can tell the region of a key.
+type KeyLocator interface {
+	LocateKey(ctx context.Context, key []byte) (*KeyRegion, error)
+}
+
+// wrapper is implemented by the DB decorators, e.g. the breaker.
+type wrapper interface {
+	Unwrap() DB
+}
+
+// baseDB returns the driver under the decorators, so optional interfaces
+// like Splitter can be checked.
+func baseDB(db DB) DB {
+	for {
+		w, ok := db.(wrapper)
+		if !ok {
+			return db
+		}
+		db = w.Unwrap()
+	}
+}
+
+// SplitKeys divides [start, end) into n ranges of the same width, the 8
+// bytes after the common prefix are taken as a big endian number.
+func SplitKeys(start, end []byte, n int) [][]byte {
+	if n <= 1 || bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	p := 0
+	for p < len(start) && p < len(end) && start[p] == end[p] {
+		p++
+	}
+	var a, b [8]byte
+	copy(a[:], start[p:])
+	copy(b[:], end[p:])
+	lo, hi := binary.BigEndian.Uint64(a[:]), binary.BigEndian.Uint64(b[:])
+	step := (hi - lo) / uint64(n)
+	if step == 0 {
+		return nil
+	}
+
+	keys := make([][]byte, 0, n-1)
+	for i := 1; i < n; i++ {
+		key := make([]byte, p+8)
+		copy(key, start[:p])
+		binary.BigEndian.PutUint64(key[p:], lo+uint64(i)*step)
+		keys = append(keys, bytes.TrimRight(key, "\x00"))
+	}
+	return keys
+}
+
+// PreSplit splits regions at keys before a large import, scattered regions
+// spread the writes over all stores instead of a single hot region.
+func (s *Store) PreSplit(ctx context.Context, keys [][]byte, scatter, wait bool) ([]uint64, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	splitter, ok := baseDB(s.db).(Splitter)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	ids, err := splitter.SplitRegions(ctx, keys, scatter, wait)
+	if err != nil {
+		s.log.Errorf("split %d keys failed, %s", len(keys), err)
+		return ids, contextError(ctx, err)
+	}
+	s.log.Infof("split %d keys into %d regions, scatter %t", len(keys), len(ids), scatter)
+	return ids, nil
+}
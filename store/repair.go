@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RepairSecondary = "secondary"
+	RepairChecksum  = "checksum"
+
+	RepairRepaired = "repaired"
+	RepairSkipped  = "skipped"
+	RepairFailed   = "failed"
+	RepairDropped  = "dropped"
+)
+
+// repairer writes the good copies found by the reads back in the
+// background, at most Queue repairs wait for the Workers, more are
+// dropped and the next read finds the key to repair again.
+type repairer struct {
+	timeout time.Duration
+	mu      sync.RWMutex
+	closed  bool
+	calls   chan func()
+	wg      sync.WaitGroup
+	log     *logrus.Entry
+}
+
+func newRepairer(conf *config.ReadRepair) *repairer {
+	r := &repairer{timeout: conf.Timeout.Duration, calls: make(chan func(), conf.Queue),
+		log: logrus.WithFields(logrus.Fields{"worker": "repair"})}
+	for i := 0; i < conf.Workers; i++ {
+		r.wg.Add(1)
+		go r.run()
+	}
+	return r
+}
+
+func (r *repairer) run() {
+	defer r.wg.Done()
+	for call := range r.calls {
+		call()
+	}
+}
+
+// Close waits for the repairs queued.
+func (r *repairer) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.calls)
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+// repair queues fn to write key back, the arguments are copied by the
+// caller. fn fails with xerror.ErrAlreadyExists if the key was written
+// since it was read, the write isn't needed anymore.
+func (r *repairer) repair(source string, key []byte, fn func(ctx context.Context) error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.calls <- func() {
+		ctx, cancel := context.WithTimeout(WithPriority(context.Background(), PriorityBackground), r.timeout)
+		defer cancel()
+		metric.ReadRepair.WithLabelValues(source, r.result(source, key, fn(ctx))).Inc()
+	}:
+	default:
+		metric.ReadRepair.WithLabelValues(source, RepairDropped).Inc()
+	}
+}
+
+func (r *repairer) result(source string, key []byte, err error) string {
+	switch {
+	case err == nil:
+		r.log.Infof("key %s repaired from %s", key, source)
+		return RepairRepaired
+	case errors.Is(err, xerror.ErrAlreadyExists):
+		return RepairSkipped
+	}
+	r.log.Errorf("repair key %s from %s failed, %s", key, source, err)
+	return RepairFailed
+}
+
+// repairSecondary copies the value read from the secondary key to key if
+// it's still missing there, a blob pointer isn't copied, the two keys
+// would share the blob.
+func (s *Store) repairSecondary(key []byte, v Value) {
+	if s.repairs == nil || !v.Secondary {
+		return
+	}
+	if _, ok := decodePointer(v.Value); ok {
+		return
+	}
+	key, val := copyBytes(key), copyBytes(v.Value)
+	s.repairs.repair(RepairSecondary, key, func(ctx context.Context) error {
+		err := s.db.CheckAndPut(ctx, key, nil, val, CheckOption{
+			Check: func(_, newVal, existVal []byte) ([]byte, error) {
+				if len(existVal) > 0 {
+					return nil, xerror.ErrAlreadyExists
+				}
+				return newVal, nil
+			},
+		})
+		if err == nil {
+			s.cacheInvalidate(key)
+		}
+		return err
+	})
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRepair(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.ReadRepair.Enable = true
+	db := &casDB{memDB{kv: map[string]string{"old/a": "1", "old/b": "2", "b": "3"}}}
+	repairs := newRepairer(&conf.ReadRepair)
+	s := &Store{db: db, repairs: repairs, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+	repaired := testutil.ToFloat64(metric.ReadRepair.WithLabelValues(RepairSecondary, RepairRepaired))
+
+	v, err := s.Get(ctx, []byte("b"), GetOption{Secondary: []byte("old/b")})
+	assert.Nil(t, err)
+	assert.Equal(t, "3", string(v.Value))
+	v, err = s.Get(ctx, []byte("a"), GetOption{Secondary: []byte("old/a")})
+	assert.Nil(t, err)
+	assert.Equal(t, Value{Secondary: true, Value: []byte("1")}, v)
+
+	repairs.Close()
+	assert.Equal(t, map[string]string{"a": "1", "old/a": "1", "old/b": "2", "b": "3"}, db.kv)
+	assert.Equal(t, repaired+1, testutil.ToFloat64(metric.ReadRepair.WithLabelValues(RepairSecondary, RepairRepaired)))
+
+	// a key written since the read is left alone
+	repairs = newRepairer(&conf.ReadRepair)
+	s.repairs = repairs
+	db.kv["c"] = "new"
+	s.repairSecondary([]byte("c"), Value{Secondary: true, Value: []byte("old")})
+	repairs.Close()
+	assert.Equal(t, "new", db.kv["c"])
+
+	// the copy found intact by the checksum
+	inner := &followerDB{casDB: casDB{memDB{kv: map[string]string{}}}, follower: map[string]string{}}
+	repairs = newRepairer(&conf.ReadRepair)
+	cdb := newChecksumDB(inner, &config.Checksum{Enable: true, OnMismatch: ChecksumRepair}, repairs)
+	assert.Nil(t, cdb.Put(ctx, []byte("d"), []byte("value")))
+	good := inner.kv["d"]
+	inner.follower["d"] = good
+	flip(inner.kv, "d")
+	v, err = cdb.Get(ctx, []byte("d"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(v.Value))
+	repairs.Close()
+	assert.Equal(t, good, inner.kv["d"])
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"time"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// ReplayPosition is a position in the connector queue, the file and the
+// offset of the next message.
+type ReplayPosition struct {
+	FileNum int64 `json:"file_num"`
+	Pos     int64 `json:"pos"`
+}
+
+func (p ReplayPosition) Before(o ReplayPosition) bool {
+	return p.FileNum < o.FileNum || (p.FileNum == o.FileNum && p.Pos < o.Pos)
+}
+
+// Replayer is implemented by a connector which can send the changes it has
+// already sent again.
+type Replayer interface {
+	// Checkpoint is the position after the last change handed to the mq.
+	Checkpoint() ReplayPosition
+	// Rewind sends the changes from the position up to the checkpoint
+	// again in the background, it returns the checkpoint.
+	Rewind(from ReplayPosition) (ReplayPosition, error)
+	// PositionAt returns the position of the first change kept since t.
+	PositionAt(t time.Time) (ReplayPosition, error)
+}
+
+func (s *Store) Replayer() (Replayer, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	if s.connector == nil {
+		return nil, xerror.ErrConnectorNotExists
+	}
+	r, ok := baseConnector(s.connector).(Replayer)
+	if !ok {
+		return nil, xerror.ErrNotSupported
+	}
+	return r, nil
+}
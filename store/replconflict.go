@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// ConflictType prefixes the conflicts of the replication: type | remote
+// stamp | key, so they are listed in the order of the remote writes.
+const ConflictType byte = 0x03
+
+const (
+	ConflictLocal  = "local"
+	ConflictRemote = "remote"
+
+	conflictSweepInterval = time.Hour
+	conflictSweepLimit    = 1000
+)
+
+// Conflict is a change of another cluster concurrent with a local one:
+// the key didn't have the old value of the remote change. Winner is the
+// side kept by the last write wins, the value of the other one is lost.
+type Conflict struct {
+	Key     []byte `json:"key"`
+	Winner  string `json:"winner"`
+	Local   string `json:"local"`
+	LocalTs uint64 `json:"local_ts"`
+	Remote  Log    `json:"remote"`
+}
+
+// detectConflict compares the value found by Apply with the remote change.
+// A change applied again finds its own value and isn't a conflict.
+func detectConflict(key []byte, l Log, existTs uint64, existVal []byte, err error) *Conflict {
+	c := &Conflict{Key: key, Local: string(existVal), LocalTs: existTs, Remote: l}
+	switch {
+	case errors.Is(err, xerror.ErrAlreadyExists):
+		if c.Local == l.New {
+			return nil
+		}
+		c.Winner = ConflictLocal
+	case err == nil:
+		if c.Local == l.Old {
+			return nil
+		}
+		c.Winner = ConflictRemote
+	default:
+		return nil
+	}
+	return c
+}
+
+func conflictKey(ts uint64, key []byte) []byte {
+	k := make([]byte, 9+len(key))
+	k[0] = ConflictType
+	binary.BigEndian.PutUint64(k[1:9], ts)
+	copy(k[9:], key)
+	return k
+}
+
+// saveConflict only logs a failure, the change is applied anyway.
+func (s *Store) saveConflict(ctx context.Context, c *Conflict) {
+	metric.ReplicaConflict.WithLabelValues(c.Winner).Inc()
+	s.log.Warnf("conflict on key %s at %d, %s wins", c.Key, c.Remote.Ts, c.Winner)
+	val, err := json.Marshal(c)
+	if err == nil {
+		err = s.db.Put(ctx, conflictKey(c.Remote.Ts, c.Key), val)
+	}
+	if err != nil {
+		s.log.Errorf("save conflict on key %s failed, %s", c.Key, err)
+	}
+}
+
+// ListConflicts returns up to limit conflicts of the remote changes
+// stamped after since.
+func (s *Store) ListConflicts(ctx context.Context, since uint64, limit int) ([]Conflict, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
+	}
+	items, err := s.db.List(ctx, conflictKey(since+1, nil), []byte{ConflictType + 1}, limit, ListOption{})
+	if err != nil {
+		return nil, err
+	}
+	conflicts := make([]Conflict, 0, len(items))
+	for _, item := range items {
+		var c Conflict
+		if err := json.Unmarshal([]byte(item.Value), &c); err != nil {
+			s.log.Errorf("decode conflict %q failed, %s", item.Key, err)
+			continue
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, nil
+}
+
+func (s *Store) sweepConflicts() {
+	if s.usable() != nil {
+		return
+	}
+	// the conflicts are keyed by the remote stamps, hlc ms << 16
+	before := time.Now().Add(-s.conf.Replica.ConflictRetention.Duration)
+	start := []byte{ConflictType}
+	end := conflictKey(uint64(before.UnixNano()/int64(time.Millisecond))<<16, nil)
+	count := 0
+	for {
+		lastKey, deleted, err := s.db.BatchDelete(WithPriority(context.Background(), PriorityBackground), start, end,
+			conflictSweepLimit)
+		if err != nil {
+			s.log.Errorf("sweep conflicts failed, %s", err)
+			return
+		}
+		count += deleted
+		if deleted < conflictSweepLimit {
+			break
+		}
+		start = lastKey
+	}
+	if count > 0 {
+		s.log.Infof("sweep %d conflicts", count)
+	}
+}
+
+func (s *Store) runConflictSweeper() {
+	ticker := time.NewTicker(conflictSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.sweepConflicts()
+		}
+	}
+}
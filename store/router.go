@@ -0,0 +1,188 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+type tier struct {
+	prefix []byte
+	end    []byte
+	db     DB
+}
+
+// routerDB sends the keys under the prefix of a tier to its DB, the other
+// keys go to the DB of [store]. A range is served by one tier, a range
+// across tiers is rejected.
+type routerDB struct {
+	DB
+	tiers []tier
+}
+
+// PrefixEnd returns the first key after every key with prefix, nil if
+// there is none.
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// openTiers opens the DB of every tier, the opened ones are closed if one
+// fails.
+func openTiers(conf *config.Config) ([]tier, error) {
+	tiers := make([]tier, 0, len(conf.Tiers))
+	for i := range conf.Tiers {
+		t := &conf.Tiers[i]
+		driver, ok := dDrivers[t.Name]
+		if !ok {
+			closeTiers(tiers)
+			return nil, xerror.ErrDatabaseNotRegister
+		}
+		c := *conf
+		c.Store = t.StoreConfig(conf.Store)
+		db, err := driver.Open(&c)
+		if err != nil {
+			closeTiers(tiers)
+			return nil, err
+		}
+		prefix := []byte(t.Prefix)
+		tiers = append(tiers, tier{prefix: prefix, end: PrefixEnd(prefix), db: db})
+	}
+	return tiers, nil
+}
+
+func closeTiers(tiers []tier) {
+	for _, t := range tiers {
+		t.db.Close()
+	}
+}
+
+func newRouterDB(db DB, tiers []tier) *routerDB {
+	return &routerDB{DB: db, tiers: tiers}
+}
+
+// Unwrap returns the DB of [store], the admin APIs like pre-split only
+// apply to it.
+func (r *routerDB) Unwrap() DB {
+	return r.DB
+}
+
+func (r *routerDB) route(key []byte) DB {
+	for _, t := range r.tiers {
+		if bytes.HasPrefix(key, t.prefix) {
+			return t.db
+		}
+	}
+	return r.DB
+}
+
+// routeRange returns the DB of a range in one tier, an empty end is the end
+// of the keys.
+func (r *routerDB) routeRange(start, end []byte) (DB, error) {
+	for _, t := range r.tiers {
+		if bytes.HasPrefix(start, t.prefix) {
+			if t.end != nil && (len(end) == 0 || bytes.Compare(end, t.end) > 0) {
+				return nil, xerror.ErrRangeSpansTiers
+			}
+			return t.db, nil
+		}
+	}
+	for _, t := range r.tiers {
+		if (t.end == nil || bytes.Compare(start, t.end) < 0) &&
+			(len(end) == 0 || bytes.Compare(end, t.prefix) > 0) {
+			return nil, xerror.ErrRangeSpansTiers
+		}
+	}
+	return r.DB, nil
+}
+
+func (r *routerDB) Close() error {
+	closeTiers(r.tiers)
+	return r.DB.Close()
+}
+
+func (r *routerDB) Put(ctx context.Context, key, val []byte) error {
+	return r.route(key).Put(ctx, key, val)
+}
+
+// BatchPut writes the items of every tier in turn, the batch is only atomic
+// within a tier.
+func (r *routerDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	groups := make(map[DB][]KeyEntry)
+	order := make([]DB, 0, 1)
+	for _, item := range items {
+		db := r.route(item.Key)
+		if _, ok := groups[db]; !ok {
+			order = append(order, db)
+		}
+		groups[db] = append(groups[db], item)
+	}
+	for _, db := range order {
+		if err := db.BatchPut(ctx, groups[db]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *routerDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	return r.route(key).CheckAndPut(ctx, key, oldVal, newVal, option)
+}
+
+func (r *routerDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	db := r.route(key)
+	if option.Secondary == nil || r.route(option.Secondary) == db {
+		return db.Get(ctx, key, option)
+	}
+	secondary := option.Secondary
+	option.Secondary = nil
+	v, err := db.Get(ctx, key, option)
+	if !errors.Is(err, xerror.ErrNotExists) {
+		return v, err
+	}
+	v, err = r.route(secondary).Get(ctx, secondary, option)
+	v.Secondary = err == nil
+	return v, err
+}
+
+func (r *routerDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	db, err := r.routeRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return db.List(ctx, start, end, limit, option)
+}
+
+func (r *routerDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	db, err := r.routeRange(start, end)
+	if err != nil {
+		return err
+	}
+	return scan(ctx, db, start, end, limit, option, fn)
+}
+
+func (r *routerDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	db, err := r.routeRange(start, end)
+	if err != nil {
+		return nil, 0, err
+	}
+	return db.BatchDelete(ctx, start, end, limit)
+}
+
+func (r *routerDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	db, err := r.routeRange(start, end)
+	if err != nil {
+		return err
+	}
+	return db.UnsafeDelete(ctx, start, end)
+}
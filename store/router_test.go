@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+// memDB keeps the puts in a map, an empty value deletes the key.
+type memDB struct {
+	stateDB
+	kv map[string]string
+}
+
+func (d *memDB) Put(ctx context.Context, key, val []byte) error {
+	if len(val) == 0 {
+		delete(d.kv, string(key))
+		return nil
+	}
+	d.kv[string(key)] = string(val)
+	return nil
+}
+
+func (d *memDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	for _, item := range items {
+		d.kv[string(item.Key)] = string(item.Entry)
+	}
+	return nil
+}
+
+func (d *memDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	if v, ok := d.kv[string(key)]; ok {
+		return Value{Value: []byte(v)}, nil
+	}
+	if v, ok := d.kv[string(option.Secondary)]; ok && option.Secondary != nil {
+		return Value{Secondary: true, Value: []byte(v)}, nil
+	}
+	return NoValue, xerror.ErrNotExists
+}
+
+func TestRouterDB(t *testing.T) {
+	main, hot, cold := &memDB{kv: map[string]string{}}, &memDB{kv: map[string]string{}}, &memDB{kv: map[string]string{}}
+	r := newRouterDB(main, []tier{
+		{prefix: []byte("hot/"), end: PrefixEnd([]byte("hot/")), db: hot},
+		{prefix: []byte("archive/"), end: PrefixEnd([]byte("archive/")), db: cold},
+	})
+	ctx := context.Background()
+	assert.Nil(t, r.Put(ctx, []byte("hot/a"), []byte("1")))
+	assert.Nil(t, r.BatchPut(ctx, []KeyEntry{
+		{Key: []byte("archive/a"), Entry: []byte("2")},
+		{Key: []byte("user/a"), Entry: []byte("3")},
+		{Key: []byte("hot/b"), Entry: []byte("4")},
+	}))
+	assert.Equal(t, map[string]string{"hot/a": "1", "hot/b": "4"}, hot.kv)
+	assert.Equal(t, map[string]string{"archive/a": "2"}, cold.kv)
+	assert.Equal(t, map[string]string{"user/a": "3"}, main.kv)
+
+	v, err := r.Get(ctx, []byte("hot/x"), GetOption{Secondary: []byte("archive/a")})
+	assert.Nil(t, err)
+	assert.Equal(t, Value{Secondary: true, Value: []byte("2")}, v)
+	_, err = r.Get(ctx, []byte("hot/x"), GetOption{Secondary: []byte("user/x")})
+	assert.True(t, errors.Is(err, xerror.ErrNotExists))
+
+	tests := []struct {
+		start, end string
+		db         DB
+	}{
+		{"hot/", "hot0", hot},
+		{"hot/a", "hot/b", hot},
+		{"hot/a", "", nil},
+		{"hot/a", "i", nil},
+		{"user/", "user0", main},
+		{"a", "archive/", main},
+		{"a", "archive/a", nil},
+		{"b", "", nil},
+		{"i", "", main},
+		{"user/", "", main},
+	}
+	for _, tt := range tests {
+		db, err := r.routeRange([]byte(tt.start), []byte(tt.end))
+		if tt.db == nil {
+			assert.True(t, errors.Is(err, xerror.ErrRangeSpansTiers), tt.start)
+		} else {
+			assert.Nil(t, err, tt.start)
+			assert.True(t, tt.db == db, tt.start)
+		}
+	}
+
+	r.Close()
+	assert.Equal(t, int32(1), main.closed)
+	assert.Equal(t, int32(1), hot.closed)
+}
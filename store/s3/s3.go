@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/store"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const BlobName = "s3"
+
+// S3 keeps the blobs under blob.prefix of the bucket, blob.timeout bounds a
+// request including reading the body of a blob.
+type S3 struct {
+	client   *s3.S3
+	uploader *s3manager.Uploader
+	conf     *config.Blob
+	log      *logrus.Entry
+}
+
+type Driver struct {
+}
+
+func init() {
+	store.RegisterBlobStore(Driver{})
+}
+
+func (d Driver) Name() string {
+	return BlobName
+}
+
+func (d Driver) Open(conf *config.Config) (store.BlobStore, error) {
+	c := aws.NewConfig().
+		WithRegion(conf.Blob.Region).
+		WithS3ForcePathStyle(conf.Blob.PathStyle).
+		WithHTTPClient(&http.Client{Timeout: conf.Blob.Timeout.Duration})
+	if conf.Blob.Endpoint != "" {
+		c = c.WithEndpoint(conf.Blob.Endpoint)
+	}
+	if conf.Blob.AccessKey != "" {
+		c = c.WithCredentials(credentials.NewStaticCredentials(conf.Blob.AccessKey, conf.Blob.SecretKey, ""))
+	}
+	sess, err := session.NewSession(c)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.New(sess)
+	return &S3{
+		client:   client,
+		uploader: s3manager.NewUploaderWithClient(client),
+		conf:     &conf.Blob,
+		log:      logrus.WithFields(logrus.Fields{"worker": BlobName}),
+	}, nil
+}
+
+func (s *S3) key(name string) *string {
+	return aws.String(s.conf.Prefix + name)
+}
+
+func (s *S3) Put(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.conf.Bucket),
+		Key:    s.key(name),
+		Body:   bytes.NewReader(data),
+	})
+	return wrapError(err)
+}
+
+// PutStream uploads the blob in parts of 5MB, a multipart upload which
+// fails is aborted.
+func (s *S3) PutStream(ctx context.Context, name string, r io.Reader) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.conf.Bucket),
+		Key:    s.key(name),
+		Body:   r,
+	})
+	return wrapError(err)
+}
+
+func (s *S3) Open(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.conf.Bucket),
+		Key:    s.key(name),
+	})
+	if err != nil {
+		return nil, 0, wrapError(err)
+	}
+	return out.Body, aws.Int64Value(out.ContentLength), nil
+}
+
+// Delete succeeds for a missing blob, S3 doesn't tell them apart.
+func (s *S3) Delete(ctx context.Context, name string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.conf.Bucket),
+		Key:    s.key(name),
+	})
+	return wrapError(err)
+}
+
+// wrapError maps a missing blob to xerror.ErrNotExists.
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotFound {
+		return xerror.ErrNotExists.Wrap(err)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 serves path style objects from a map.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[r.URL.Path] = data
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	conf := config.DefaultConfig()
+	conf.Blob.Name = BlobName
+	conf.Blob.Endpoint = srv.URL
+	conf.Blob.Bucket = "bucket"
+	conf.Blob.PathStyle = true
+	conf.Blob.AccessKey = "key"
+	conf.Blob.SecretKey = "secret"
+	b, err := Driver{}.Open(conf)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	assert.Nil(t, b.Put(ctx, "6b6579/01", []byte("value")))
+	assert.Contains(t, fake.objects, "/bucket/tirest/6b6579/01")
+
+	r, size, err := b.Open(ctx, "6b6579/01")
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, []byte("value"), data)
+	assert.Equal(t, int64(5), size)
+
+	assert.Nil(t, b.Delete(ctx, "6b6579/01"))
+	_, _, err = b.Open(ctx, "6b6579/01")
+	assert.True(t, errors.Is(err, xerror.ErrNotExists))
+}
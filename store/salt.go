@@ -0,0 +1,372 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils"
+)
+
+// saltRule is a [[salt]] with the prefix as stored, after the meta byte.
+type saltRule struct {
+	prefix      []byte
+	end         []byte
+	shards      int
+	concurrency int
+}
+
+func (r *saltRule) shard(key []byte) byte {
+	h := fnv.New32a()
+	h.Write(key[len(r.prefix):])
+	return byte(h.Sum32() % uint32(r.shards))
+}
+
+// salt puts shard after the prefix of key.
+func (r *saltRule) salt(key []byte, shard byte) []byte {
+	b := make([]byte, len(key)+1)
+	n := copy(b, r.prefix)
+	b[n] = shard
+	copy(b[n+1:], key[n:])
+	return b
+}
+
+// shardRange is [start, end) of the rule within shard as stored, an end
+// past the prefix is the end of the shard.
+func (r *saltRule) shardRange(start, end []byte, shard byte) ([]byte, []byte) {
+	from := r.salt(start, shard)
+	if bytes.HasPrefix(end, r.prefix) {
+		return from, r.salt(end, shard)
+	}
+	return from, PrefixEnd(r.salt(r.prefix, shard))
+}
+
+// salter stores the keys under the prefix of a rule with the shard hashed
+// from the rest of the key after the prefix, a nil salter keeps them as
+// they are.
+type salter struct {
+	rules []saltRule
+}
+
+func newSalter(salts []config.Salt) *salter {
+	if len(salts) == 0 {
+		return nil
+	}
+	rules := make([]saltRule, 0, len(salts))
+	for _, c := range salts {
+		prefix := append([]byte{metaType}, c.Prefix...)
+		concurrency := c.Concurrency
+		if concurrency <= 0 || concurrency > c.Shards {
+			concurrency = c.Shards
+		}
+		rules = append(rules, saltRule{prefix: prefix, end: PrefixEnd(prefix), shards: c.Shards,
+			concurrency: concurrency})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return bytes.Compare(rules[i].prefix, rules[j].prefix) < 0
+	})
+	return &salter{rules: rules}
+}
+
+func (s *salter) rule(key []byte) *saltRule {
+	for i := range s.rules {
+		if bytes.HasPrefix(key, s.rules[i].prefix) {
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+// key returns key as stored.
+func (s *salter) key(key []byte) []byte {
+	if s == nil {
+		return key
+	}
+	r := s.rule(key)
+	if r == nil {
+		return key
+	}
+	return r.salt(key, r.shard(key))
+}
+
+// unsalt returns a stored key as it was written.
+func (s *salter) unsalt(key []byte) []byte {
+	if s == nil {
+		return key
+	}
+	r := s.rule(key)
+	if r == nil || len(key) <= len(r.prefix) {
+		return key
+	}
+	b := make([]byte, len(key)-1)
+	n := copy(b, key[:len(r.prefix)])
+	copy(b[n:], key[n+1:])
+	return b
+}
+
+// saltSpan is a part of a range, under the prefix of rule if it's set.
+type saltSpan struct {
+	start []byte
+	end   []byte
+	rule  *saltRule
+}
+
+// spans cuts [start, end) at the prefixes of the rules, in order, an empty
+// end is the end of the keys.
+func (s *salter) spans(start, end []byte) []saltSpan {
+	var spans []saltSpan
+	from := start
+	for i := range s.rules {
+		r := &s.rules[i]
+		if r.end != nil && bytes.Compare(r.end, from) <= 0 {
+			continue
+		}
+		if len(end) > 0 && bytes.Compare(r.prefix, end) >= 0 {
+			break
+		}
+		if bytes.Compare(from, r.prefix) < 0 {
+			spans = append(spans, saltSpan{start: from, end: r.prefix})
+			from = r.prefix
+		}
+		to := r.end
+		if len(end) > 0 && (to == nil || bytes.Compare(end, to) < 0) {
+			to = end
+		}
+		spans = append(spans, saltSpan{start: from, end: to, rule: r})
+		if to == nil {
+			return spans
+		}
+		from = to
+	}
+	if len(end) == 0 || bytes.Compare(from, end) < 0 {
+		spans = append(spans, saltSpan{start: from, end: end})
+	}
+	return spans
+}
+
+// saltDB stores the keys salted by salts. A list under a prefix reads the
+// shards concurrently and merges them, it holds up to limit items of each
+// shard.
+type saltDB struct {
+	DB
+	salts *salter
+}
+
+func newSaltDB(db DB, salts *salter) *saltDB {
+	return &saltDB{DB: db, salts: salts}
+}
+
+func (d *saltDB) Unwrap() DB {
+	return d.DB
+}
+
+func (d *saltDB) Put(ctx context.Context, key, val []byte) error {
+	return d.DB.Put(ctx, d.salts.key(key), val)
+}
+
+func (d *saltDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	salted := make([]KeyEntry, len(items))
+	for i, item := range items {
+		salted[i] = KeyEntry{Key: d.salts.key(item.Key), Entry: item.Entry}
+	}
+	return d.DB.BatchPut(ctx, salted)
+}
+
+func (d *saltDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	return d.DB.CheckAndPut(ctx, d.salts.key(key), oldVal, newVal, option)
+}
+
+func (d *saltDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	if option.Secondary != nil {
+		option.Secondary = d.salts.key(option.Secondary)
+	}
+	return d.DB.Get(ctx, d.salts.key(key), option)
+}
+
+func (d *saltDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	var items []KeyValue
+	err := d.Scan(ctx, start, end, limit, option, func(key, val []byte) error {
+		items = append(items, KeyValue{Key: string(key), Value: string(val)})
+		return nil
+	})
+	return items, err
+}
+
+func (d *saltDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	option = d.unsaltOption(option)
+	spans := d.salts.spans(start, end)
+	if option.Reverse {
+		for i, j := 0, len(spans)-1; i < j; i, j = i+1, j-1 {
+			spans[i], spans[j] = spans[j], spans[i]
+		}
+	}
+	for _, sp := range spans {
+		n := 0
+		count := func(key, val []byte) error {
+			n++
+			return fn(key, val)
+		}
+		var err error
+		if sp.rule == nil {
+			err = scan(ctx, d.DB, sp.start, sp.end, limit, option, count)
+		} else {
+			err = d.scanShards(ctx, sp, limit, option, count)
+		}
+		if err != nil {
+			return err
+		}
+		if limit > 0 {
+			limit -= n
+			if limit <= 0 {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// scanShards lists up to limit items of every shard of sp, concurrency
+// shards at once, the first error stops the others. The lists are merged
+// by key into fn until limit items.
+func (d *saltDB) scanShards(ctx context.Context, sp saltSpan, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([][]KeyValue, sp.rule.shards)
+	errs := make([]error, sp.rule.shards)
+	slots := make(chan struct{}, sp.rule.concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < sp.rule.shards; i++ {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			start, end := sp.rule.shardRange(sp.start, sp.end, byte(i))
+			results[i], errs[i] = d.DB.List(ctx, start, end, limit, option)
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return mergeItems(results, limit, option.Reverse, fn)
+}
+
+// mergeItems hands the items of lists, each in the order of the list, to fn
+// in that order until limit items.
+func mergeItems(lists [][]KeyValue, limit int, reverse bool, fn func(key, val []byte) error) error {
+	heads := make([]int, len(lists))
+	for n := 0; limit <= 0 || n < limit; n++ {
+		next := -1
+		for i, list := range lists {
+			if heads[i] >= len(list) {
+				continue
+			}
+			if next < 0 {
+				next = i
+				continue
+			}
+			key, min := list[heads[i]].Key, lists[next][heads[next]].Key
+			if (!reverse && key < min) || (reverse && key > min) {
+				next = i
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+		item := lists[next][heads[next]]
+		heads[next]++
+		if err := fn(utils.S2B(item.Key), utils.S2B(item.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsaltOption strips the shards before option.Item.
+func (d *saltDB) unsaltOption(option ListOption) ListOption {
+	item := option.Item
+	option.Item = func(key, val []byte) ([]byte, []byte, error) {
+		key = d.salts.unsalt(key)
+		if item == nil {
+			return key, val, nil
+		}
+		return item(key, val)
+	}
+	return option
+}
+
+// BatchDelete deletes the shards of a prefix one after another. Stopped by
+// limit before the last shard, it returns the start of the prefix for the
+// next call, the shards done have nothing left from there.
+func (d *saltDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	var lastKey []byte
+	deleted := 0
+	for _, sp := range d.salts.spans(start, end) {
+		shards := 1
+		if sp.rule != nil {
+			shards = sp.rule.shards
+		}
+		for i := 0; i < shards; i++ {
+			from, to := sp.start, sp.end
+			if sp.rule != nil {
+				from, to = sp.rule.shardRange(sp.start, sp.end, byte(i))
+			}
+			left := 0
+			if limit > 0 {
+				left = limit - deleted
+			}
+			key, n, err := d.DB.BatchDelete(ctx, from, to, left)
+			deleted += n
+			if n > 0 {
+				lastKey = d.salts.unsalt(key)
+			}
+			if err != nil {
+				return lastKey, deleted, err
+			}
+			if limit > 0 && deleted >= limit {
+				if i < shards-1 {
+					return sp.start, deleted, nil
+				}
+				return lastKey, deleted, nil
+			}
+		}
+	}
+	return lastKey, deleted, nil
+}
+
+func (d *saltDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	for _, sp := range d.salts.spans(start, end) {
+		if sp.rule == nil {
+			if err := d.DB.UnsafeDelete(ctx, sp.start, sp.end); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := 0; i < sp.rule.shards; i++ {
+			from, to := sp.rule.shardRange(sp.start, sp.end, byte(i))
+			if err := d.DB.UnsafeDelete(ctx, from, to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// sortedDB lists and deletes in the order of the keys, up to limit.
+type sortedDB struct {
+	memDB
+}
+
+func (d *sortedDB) keys(start, end []byte, reverse bool) []string {
+	var keys []string
+	for k := range d.kv {
+		if k >= string(start) && (len(end) == 0 || k < string(end)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	return keys
+}
+
+func (d *sortedDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	items := make([]KeyValue, 0)
+	for _, k := range d.keys(start, end, option.Reverse) {
+		key, val := []byte(k), []byte(d.kv[k])
+		if option.Item != nil {
+			key, val, _ = option.Item(key, val)
+		}
+		items = append(items, KeyValue{Key: string(key), Value: string(val)})
+		if limit > 0 && len(items) >= limit {
+			break
+		}
+	}
+	return items, nil
+}
+
+func (d *sortedDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	var lastKey []byte
+	n := 0
+	for _, k := range d.keys(start, end, false) {
+		delete(d.kv, k)
+		lastKey = []byte(k)
+		n++
+		if limit > 0 && n >= limit {
+			break
+		}
+	}
+	return lastKey, n, nil
+}
+
+func TestSaltSpans(t *testing.T) {
+	s := newSalter([]config.Salt{{Prefix: "t/", Shards: 4}, {Prefix: "\xff", Shards: 2}})
+	tests := []struct {
+		start, end string
+		spans      []string
+	}{
+		{"\x00a", "\x00b", []string{"\x00a-\x00b"}},
+		{"\x00", "\x01", []string{"\x00-\x00t/", "\x00t/-\x00t0 salted", "\x00t0-\x00\xff", "\x00\xff-\x01 salted"}},
+		{"\x00t/a", "\x00t/b", []string{"\x00t/a-\x00t/b salted"}},
+		{"\x00t/a", "", []string{"\x00t/a-\x00t0 salted", "\x00t0-\x00\xff", "\x00\xff-\x01 salted", "\x01-"}},
+		{"\x00u", "\x00v", []string{"\x00u-\x00v"}},
+	}
+	for _, tt := range tests {
+		var spans []string
+		for _, sp := range s.spans([]byte(tt.start), []byte(tt.end)) {
+			span := fmt.Sprintf("%s-%s", sp.start, sp.end)
+			if sp.rule != nil {
+				span += " salted"
+			}
+			spans = append(spans, span)
+		}
+		assert.Equal(t, tt.spans, spans, tt.start)
+	}
+}
+
+func TestSaltDB(t *testing.T) {
+	ctx := context.Background()
+	mem := &sortedDB{memDB{kv: map[string]string{}}}
+	salts := newSalter([]config.Salt{{Prefix: "t/", Shards: 4, Concurrency: 2}})
+	db := newSaltDB(mem, salts)
+
+	var want []KeyValue
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("\x00t/%02d", i)
+		assert.Nil(t, db.Put(ctx, []byte(key), []byte("v")))
+		want = append(want, KeyValue{Key: key, Value: "v"})
+	}
+	assert.Nil(t, db.BatchPut(ctx, []KeyEntry{{Key: []byte("\x00a"), Entry: []byte("v")}, {Key: []byte("\x00z"), Entry: []byte("v")}}))
+	want = append([]KeyValue{{Key: "\x00a", Value: "v"}}, append(want, KeyValue{Key: "\x00z", Value: "v"})...)
+	assert.Contains(t, mem.kv, "\x00a")
+	assert.NotContains(t, mem.kv, "\x00t/00")
+	shards := map[byte]bool{}
+	for k := range mem.kv {
+		if len(k) > 3 && k[:3] == "\x00t/" {
+			shards[k[3]] = true
+		}
+	}
+	assert.Len(t, shards, 4)
+
+	v, err := db.Get(ctx, []byte("\x00t/07"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), v.Value)
+
+	items, err := db.List(ctx, []byte("\x00"), []byte("\x01"), 0, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, want, items)
+	items, err = db.List(ctx, []byte("\x00t/05"), []byte("\x00t/09"), 2, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, want[6:8], items)
+	items, err = db.List(ctx, []byte("\x00"), []byte("\x01"), 3, ListOption{Reverse: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{want[21], want[20], want[19]}, items)
+
+	// resumed from the key returned, every key is deleted once
+	from, total := []byte("\x00t/"), 0
+	for {
+		lastKey, deleted, err := db.BatchDelete(ctx, from, []byte("\x00t0"), 3)
+		assert.Nil(t, err)
+		total += deleted
+		if deleted < 3 {
+			break
+		}
+		from = lastKey
+	}
+	assert.Equal(t, 20, total)
+	assert.Equal(t, map[string]string{"\x00a": "v", "\x00z": "v"}, mem.kv)
+}
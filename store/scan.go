@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// Scanner is a DB handing the items of a list to fn as they're read, fn
+// doesn't keep key and val after it returns. An error of fn stops the scan
+// and is returned as it is.
+type Scanner interface {
+	Scan(ctx context.Context, start, end []byte, limit int, option ListOption, fn func(key, val []byte) error) error
+}
+
+// scan lists the items of a DB without Scanner before handing them to fn.
+func scan(ctx context.Context, db DB, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	if sc, ok := db.(Scanner); ok {
+		return sc.Scan(ctx, start, end, limit, option, fn)
+	}
+	items, err := db.List(ctx, start, end, limit, option)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fn(utils.S2B(item.Key), utils.S2B(item.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Scan calls fn with the items of the range as List would return them,
+// without holding them all, a large list is sent while it's read. The item
+// is only valid in fn.
+func (s *Store) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(KeyValue) error) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.budget(ctx, EndpointList)
+	defer cancel()
+	var fnErr error
+	read := func(fn func(key, val []byte) error) error {
+		if spans := s.listSpans(ctx, start, end, option.Reverse); spans != nil {
+			return s.scanSpans(ctx, spans, limit, option, fn)
+		}
+		return scan(ctx, s.db, start, end, limit, option, fn)
+	}
+	err := read(func(key, val []byte) error {
+		item := KeyValue{Key: utils.B2S(key), Value: utils.B2S(val)}
+		if s.blobs != nil && !option.KeyOnly {
+			if p, ok := decodePointer(val); ok {
+				data, err := s.readBlob(ctx, p)
+				if err != nil {
+					return xerror.ErrListKVFailed.Wrap(err)
+				}
+				item.Value = utils.B2S(data)
+			}
+		}
+		fnErr = fn(item)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	err = timedOut(EndpointList, contextError(ctx, err))
+	if err != nil {
+		s.log.Errorf("scan (%s-%s) limit %d, %s", start, end, limit, err)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+// seqMagic starts a sequenced record, the sequence of the key follows it
+// and then the value.
+var seqMagic = []byte("\x00tirest.seq\x00")
+
+// sequence keeps an empty value empty, it deletes the key.
+func sequence(seq uint64, val []byte) []byte {
+	if len(val) == 0 {
+		return val
+	}
+	b := make([]byte, len(seqMagic)+8+len(val))
+	n := copy(b, seqMagic)
+	binary.BigEndian.PutUint64(b[n:], seq)
+	copy(b[n+8:], val)
+	return b
+}
+
+// unsequence returns a value written without sequence as is, at 0.
+func unsequence(v []byte) (uint64, []byte) {
+	if len(v) < len(seqMagic)+8 || !bytes.HasPrefix(v, seqMagic) {
+		return 0, v
+	}
+	n := len(seqMagic)
+	return binary.BigEndian.Uint64(v[n:]), v[n+8:]
+}
+
+// seqDB keeps a sequence with each value, a write of the key takes the
+// sequence found plus one in the same transaction, so the writes of a key
+// are numbered in commit order whichever proxy made them. The puts read
+// the key, they're CAS without a check, and a batch put is written key by
+// key. A delete takes the next sequence too, the key starts again from 1.
+type seqDB struct {
+	DB
+}
+
+func newSeqDB(db DB) *seqDB {
+	return &seqDB{DB: db}
+}
+
+func (d *seqDB) Unwrap() DB {
+	return d.DB
+}
+
+func (d *seqDB) Put(ctx context.Context, key, val []byte) error {
+	return d.CheckAndPut(ctx, key, nil, val, CheckOption{})
+}
+
+func (d *seqDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	for _, item := range items {
+		if err := d.Put(ctx, item.Key, item.Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *seqDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	check := option.Check
+	sequenced := option.Sequenced
+	option.Check = func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		seq, existVal := unsequence(existVal)
+		val := newVal
+		if check != nil {
+			var err error
+			val, err = check(oldVal, newVal, existVal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if sequenced != nil {
+			sequenced(seq + 1)
+		}
+		return sequence(seq+1, val), nil
+	}
+	err := d.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		_, conflict.Value = unsequence(conflict.Value)
+	}
+	return err
+}
+
+func (d *seqDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	v, err := d.DB.Get(ctx, key, option)
+	if err != nil {
+		return v, err
+	}
+	v.Seq, v.Value = unsequence(v.Value)
+	return v, nil
+}
+
+func (d *seqDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	return d.DB.List(ctx, start, end, limit, unsequenceOption(option))
+}
+
+func (d *seqDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return scan(ctx, d.DB, start, end, limit, unsequenceOption(option), fn)
+}
+
+// unsequenceOption strips the sequences before option.Item.
+func unsequenceOption(option ListOption) ListOption {
+	item := option.Item
+	option.Item = func(key, val []byte) ([]byte, []byte, error) {
+		_, val = unsequence(val)
+		if item == nil {
+			return key, val, nil
+		}
+		return item(key, val)
+	}
+	return option
+}
+
+// SeqTracker orders the changes of a consumer by the seq of their keys:
+// a change at or below the last seq seen for its key was overtaken by a
+// later one and is dropped. A delete forgets the key, it starts again from
+// 1. The changes without seq always pass.
+type SeqTracker struct {
+	mu   sync.Mutex
+	last map[string]uint64
+}
+
+func NewSeqTracker() *SeqTracker {
+	return &SeqTracker{last: make(map[string]uint64)}
+}
+
+// Accept is false for a change to drop.
+func (t *SeqTracker) Accept(key []byte, l Log) bool {
+	if l.Seq == 0 {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.last[string(key)]; ok && l.Seq <= last {
+		return false
+	}
+	if l.New == "" {
+		delete(t.last, string(key))
+	} else {
+		t.last[string(key)] = l.Seq
+	}
+	return true
+}
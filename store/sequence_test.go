@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/hlc"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeqDB(t *testing.T) {
+	inner := &casDB{memDB{kv: map[string]string{}}}
+	db := newStampDB(newSeqDB(inner), hlc.New(0))
+	ctx := context.Background()
+
+	assert.Nil(t, db.Put(ctx, []byte("a"), []byte("1")))
+	assert.Nil(t, db.BatchPut(ctx, []KeyEntry{{Key: []byte("a"), Entry: []byte("2")}}))
+	v, err := db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), v.Value)
+	assert.Equal(t, uint64(2), v.Seq)
+	assert.NotZero(t, v.Ts)
+	items, err := db.List(ctx, nil, nil, 0, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: "a", Value: "2"}}, items)
+
+	var seq uint64
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("a"), []byte("2"), []byte("3"),
+		CheckOption{Check: equalCheck, Sequenced: func(n uint64) { seq = n }}))
+	assert.Equal(t, uint64(3), seq)
+
+	// the conflict has the value alone
+	err = db.CheckAndPut(ctx, []byte("a"), []byte("x"), []byte("y"), CheckOption{Check: equalCheck})
+	var conflict *ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, []byte("3"), conflict.Value)
+
+	// a delete takes a sequence, the key starts again
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("a"), []byte("3"), nil,
+		CheckOption{Check: equalCheck, Sequenced: func(n uint64) { seq = n }}))
+	assert.Equal(t, uint64(4), seq)
+	assert.Nil(t, db.Put(ctx, []byte("a"), []byte("5")))
+	v, _ = db.Get(ctx, []byte("a"), GetOption{})
+	assert.Equal(t, uint64(1), v.Seq)
+
+	// values written before server.sequence are read as is
+	inner.kv["b"] = "plain"
+	v, err = db.Get(ctx, []byte("b"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, Value{Value: []byte("plain")}, v)
+}
+
+func TestStoreSequence(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Server.Sequence = true
+	s := &Store{db: newSeqDB(&casDB{memDB{kv: map[string]string{}}}), state: int32(StateReady), conf: conf,
+		watchers: NewWatchers(1, 10, 0), log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	w, err := s.Watch([]byte("\x00"), 0)
+	assert.Nil(t, err)
+
+	assert.Nil(t, cas(s, "\x00a", "", "1"))
+	assert.Nil(t, cas(s, "\x00a", "1", "2"))
+	assert.Nil(t, cas(s, "\x00a", "2", ""))
+	tracker := NewSeqTracker()
+	var changes []Log
+	for i := 0; i < 3; i++ {
+		ev := <-w.C
+		assert.Equal(t, uint64(i+1), ev.Log.Seq)
+		changes = append(changes, ev.Log)
+	}
+
+	// the changes of a key overtaken by a later one are dropped
+	assert.True(t, tracker.Accept([]byte("a"), changes[1]))
+	assert.False(t, tracker.Accept([]byte("a"), changes[0]))
+	assert.False(t, tracker.Accept([]byte("a"), changes[1]))
+	assert.True(t, tracker.Accept([]byte("a"), changes[2]))
+	assert.True(t, tracker.Accept([]byte("a"), Log{New: "1", Seq: 1}))
+	assert.True(t, tracker.Accept([]byte("a"), Log{New: "unordered"}))
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ShadowOK       = "ok"
+	ShadowMatch    = "match"
+	ShadowMismatch = "mismatch"
+	ShadowError    = "error"
+	ShadowDropped  = "dropped"
+)
+
+// shadowDB mirrors a sample of the calls which succeeded to another DB in
+// the background, a read compares what the shadow returns with what the DB
+// returned. The shadow gets the values as they're stored.
+type shadowDB struct {
+	DB
+	shadow DB
+	conf   *config.Shadow
+	mu     sync.RWMutex
+	closed bool
+	calls  chan func()
+	wg     sync.WaitGroup
+	log    *logrus.Entry
+}
+
+// openShadow opens the DB of [shadow.store].
+func openShadow(conf *config.Config) (DB, error) {
+	c := *conf
+	c.Store = conf.Shadow.StoreConfig(conf.Store)
+	c.Tiers = nil
+	driver, ok := dDrivers[c.Store.Name]
+	if !ok {
+		return nil, xerror.ErrDatabaseNotRegister
+	}
+	return driver.Open(&c)
+}
+
+func newShadowDB(db, shadow DB, conf *config.Shadow) *shadowDB {
+	d := &shadowDB{DB: db, shadow: shadow, conf: conf, calls: make(chan func(), conf.Queue),
+		log: logrus.WithFields(logrus.Fields{"worker": "shadow"})}
+	for i := 0; i < conf.Workers; i++ {
+		d.wg.Add(1)
+		go d.run()
+	}
+	return d
+}
+
+func (d *shadowDB) Unwrap() DB {
+	return d.DB
+}
+
+func (d *shadowDB) run() {
+	defer d.wg.Done()
+	for call := range d.calls {
+		call()
+	}
+}
+
+// Close waits for the calls queued to the shadow.
+func (d *shadowDB) Close() error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.calls)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+	if err := d.shadow.Close(); err != nil {
+		d.log.Errorf("close shadow failed, %s", err)
+	}
+	return d.DB.Close()
+}
+
+func sampled(percent float64) bool {
+	return percent >= 100 || rand.Float64()*100 < percent
+}
+
+// mirror queues call, it returns the result of op.
+func (d *shadowDB) mirror(op string, call func(ctx context.Context) string) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.calls <- func() {
+		metric.Shadow.WithLabelValues(op, call(context.Background())).Inc()
+	}:
+	default:
+		metric.Shadow.WithLabelValues(op, ShadowDropped).Inc()
+	}
+}
+
+// write mirrors a write, the arguments are copied by the caller.
+func (d *shadowDB) write(op string, fn func(ctx context.Context) error) {
+	d.mirror(op, func(ctx context.Context) string {
+		if err := fn(ctx); err != nil {
+			d.log.Warnf("shadow %s failed, %s", op, err)
+			return ShadowError
+		}
+		return ShadowOK
+	})
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func (d *shadowDB) Put(ctx context.Context, key, val []byte) error {
+	err := d.DB.Put(ctx, key, val)
+	if err == nil && sampled(d.conf.WritePercent) {
+		key, val := copyBytes(key), copyBytes(val)
+		d.write(OpPut, func(ctx context.Context) error {
+			return d.shadow.Put(ctx, key, val)
+		})
+	}
+	return err
+}
+
+func (d *shadowDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	err := d.DB.BatchPut(ctx, items)
+	if err == nil && sampled(d.conf.WritePercent) {
+		copied := make([]KeyEntry, len(items))
+		for i, item := range items {
+			copied[i] = KeyEntry{Key: copyBytes(item.Key), Entry: copyBytes(item.Entry)}
+		}
+		d.write(OpBatchPut, func(ctx context.Context) error {
+			return d.shadow.BatchPut(ctx, copied)
+		})
+	}
+	return err
+}
+
+// CheckAndPut puts the value the check wrote to the shadow, the check isn't
+// run again there.
+func (d *shadowDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	written := newVal
+	if check := option.Check; check != nil {
+		option.Check = func(oldVal, newVal, existVal []byte) ([]byte, error) {
+			val, err := check(oldVal, newVal, existVal)
+			written = val
+			return val, err
+		}
+	}
+	err := d.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	if err == nil && sampled(d.conf.WritePercent) {
+		key, val := copyBytes(key), copyBytes(written)
+		d.write(OpCas, func(ctx context.Context) error {
+			return d.shadow.Put(ctx, key, val)
+		})
+	}
+	return err
+}
+
+// BatchDelete deletes the same keys from the shadow, up to lastKey unless
+// the range is done.
+func (d *shadowDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	lastKey, deleted, err := d.DB.BatchDelete(ctx, start, end, limit)
+	if err == nil && deleted > 0 && sampled(d.conf.WritePercent) {
+		from, to := copyBytes(start), copyBytes(end)
+		if limit > 0 && deleted >= limit {
+			to = append(copyBytes(lastKey), 0x00)
+		}
+		d.write(OpBatchDelete, func(ctx context.Context) error {
+			_, _, err := d.shadow.BatchDelete(ctx, from, to, 0)
+			return err
+		})
+	}
+	return lastKey, deleted, err
+}
+
+func (d *shadowDB) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	err := d.DB.UnsafeDelete(ctx, start, end)
+	if err == nil && sampled(d.conf.WritePercent) {
+		from, to := copyBytes(start), copyBytes(end)
+		d.write(OpUnsafeDelete, func(ctx context.Context) error {
+			return d.shadow.UnsafeDelete(ctx, from, to)
+		})
+	}
+	return err
+}
+
+func (d *shadowDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	v, err := d.DB.Get(ctx, key, option)
+	if (err == nil || errors.Is(err, xerror.ErrNotExists)) && sampled(d.conf.ReadPercent) {
+		key, option.Secondary = copyBytes(key), copyBytes(option.Secondary)
+		found, val, secondary := err == nil, copyBytes(v.Value), v.Secondary
+		d.mirror(OpGet, func(ctx context.Context) string {
+			sv, err := d.shadow.Get(ctx, key, option)
+			if err != nil && !errors.Is(err, xerror.ErrNotExists) {
+				d.log.Warnf("shadow get %q failed, %s", key, err)
+				return ShadowError
+			}
+			if found != (err == nil) || secondary != sv.Secondary || !bytes.Equal(val, sv.Value) {
+				d.log.Warnf("shadow get %q mismatch, found %t, shadow found %t", key, found, err == nil)
+				return ShadowMismatch
+			}
+			return ShadowMatch
+		})
+	}
+	return v, err
+}
+
+// compareList lists the range from the shadow against items.
+func (d *shadowDB) compareList(start, end []byte, limit int, option ListOption, items []KeyValue) {
+	start, end = copyBytes(start), copyBytes(end)
+	d.mirror(OpList, func(ctx context.Context) string {
+		shadowItems, err := d.shadow.List(ctx, start, end, limit, option)
+		if err != nil {
+			d.log.Warnf("shadow list (%q-%q) failed, %s", start, end, err)
+			return ShadowError
+		}
+		if len(items) != len(shadowItems) {
+			d.log.Warnf("shadow list (%q-%q) mismatch, %d items, shadow %d", start, end,
+				len(items), len(shadowItems))
+			return ShadowMismatch
+		}
+		for i := range items {
+			if items[i] != shadowItems[i] {
+				d.log.Warnf("shadow list (%q-%q) mismatch at %q", start, end, items[i].Key)
+				return ShadowMismatch
+			}
+		}
+		return ShadowMatch
+	})
+}
+
+func (d *shadowDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	items, err := d.DB.List(ctx, start, end, limit, option)
+	if err == nil && sampled(d.conf.ReadPercent) {
+		// the strings of the items may share the buffers of the DB
+		copied := make([]KeyValue, len(items))
+		for i, item := range items {
+			copied[i] = KeyValue{Key: string(utils.S2B(item.Key)), Value: string(utils.S2B(item.Value))}
+		}
+		d.compareList(start, end, limit, option, copied)
+	}
+	return items, err
+}
+
+// Scan keeps a copy of the items of a sampled scan, it's compared once the
+// scan is done.
+func (d *shadowDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	if !sampled(d.conf.ReadPercent) {
+		return scan(ctx, d.DB, start, end, limit, option, fn)
+	}
+	var items []KeyValue
+	err := scan(ctx, d.DB, start, end, limit, option, func(key, val []byte) error {
+		items = append(items, KeyValue{Key: string(key), Value: string(val)})
+		return fn(key, val)
+	})
+	if err == nil {
+		d.compareList(start, end, limit, option, items)
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowDB(t *testing.T) {
+	primary := &casDB{memDB{kv: map[string]string{}}}
+	shadow := &regionDB{memDB: memDB{kv: map[string]string{}}}
+	d := newShadowDB(primary, shadow, &config.Shadow{ReadPercent: 100, WritePercent: 100, Workers: 1, Queue: 100})
+	ctx := context.Background()
+	// one worker runs the calls in order
+	wait := func() {
+		done := make(chan struct{})
+		d.calls <- func() { close(done) }
+		<-done
+	}
+	count := func(op, result string) float64 {
+		return testutil.ToFloat64(metric.Shadow.WithLabelValues(op, result))
+	}
+
+	assert.Nil(t, d.Put(ctx, []byte("a"), []byte("1")))
+	assert.Nil(t, d.CheckAndPut(ctx, []byte("b"), nil, []byte("2"), CheckOption{Check: equalCheck}))
+	assert.NotNil(t, d.CheckAndPut(ctx, []byte("b"), nil, []byte("3"), CheckOption{Check: equalCheck}))
+	assert.Nil(t, d.BatchPut(ctx, []KeyEntry{{Key: []byte("c"), Entry: []byte("3")}}))
+	wait()
+	assert.Equal(t, map[string]string{"a": "1", "b": "2", "c": "3"}, shadow.kv)
+
+	match, mismatch := count(OpGet, ShadowMatch), count(OpGet, ShadowMismatch)
+	_, err := d.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	_, err = d.Get(ctx, []byte("z"), GetOption{})
+	assert.NotNil(t, err)
+	wait()
+	shadow.kv["a"] = "x"
+	_, err = d.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	wait()
+	assert.Equal(t, match+2, count(OpGet, ShadowMatch))
+	assert.Equal(t, mismatch+1, count(OpGet, ShadowMismatch))
+
+	match, mismatch = count(OpList, ShadowMatch), count(OpList, ShadowMismatch)
+	_, err = d.List(ctx, []byte("a"), []byte("b"), 0, ListOption{})
+	assert.Nil(t, err)
+	wait()
+	shadow.kv["a"] = "1"
+	var items []string
+	assert.Nil(t, d.Scan(ctx, []byte("a"), []byte("b"), 0, ListOption{}, func(key, val []byte) error {
+		items = append(items, string(key))
+		return nil
+	}))
+	assert.Equal(t, []string{"a"}, items)
+	wait()
+	assert.Equal(t, match+1, count(OpList, ShadowMatch))
+	assert.Equal(t, mismatch+1, count(OpList, ShadowMismatch))
+
+	// the calls queued are done before the shadow is closed
+	assert.Nil(t, d.Put(ctx, []byte("c"), nil))
+	assert.Nil(t, d.Close())
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, shadow.kv)
+	assert.Equal(t, int32(1), shadow.closed)
+	assert.NotPanics(t, func() { _ = d.Put(ctx, []byte("d"), []byte("4")) })
+	_, ok := shadow.kv["d"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/hlc"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// stampMagic starts a stamped record, the HLC timestamp of the write
+// follows it and then the value.
+var stampMagic = []byte("\x00tirest.ts\x00")
+
+// stamp keeps an empty value empty, it deletes the key.
+func stamp(ts uint64, val []byte) []byte {
+	if len(val) == 0 {
+		return val
+	}
+	b := make([]byte, len(stampMagic)+8+len(val))
+	n := copy(b, stampMagic)
+	binary.BigEndian.PutUint64(b[n:], ts)
+	copy(b[n+8:], val)
+	return b
+}
+
+// unstamp returns a value written without stamps as is, at timestamp 0.
+func unstamp(v []byte) (uint64, []byte) {
+	if len(v) < len(stampMagic)+8 || !bytes.HasPrefix(v, stampMagic) {
+		return 0, v
+	}
+	n := len(stampMagic)
+	return binary.BigEndian.Uint64(v[n:]), v[n+8:]
+}
+
+// stampDB stamps every write with the HLC, a CAS also checks the stamp
+// against CheckOption.UnmodifiedSince and, for LWW, CheckOption.Ts.
+type stampDB struct {
+	DB
+	clock *hlc.Clock
+}
+
+func newStampDB(db DB, clock *hlc.Clock) *stampDB {
+	return &stampDB{DB: db, clock: clock}
+}
+
+func (d *stampDB) Unwrap() DB {
+	return d.DB
+}
+
+func (d *stampDB) Put(ctx context.Context, key, val []byte) error {
+	return d.DB.Put(ctx, key, stamp(d.clock.Now(), val))
+}
+
+func (d *stampDB) BatchPut(ctx context.Context, items []KeyEntry) error {
+	ts := d.clock.Now()
+	stamped := make([]KeyEntry, len(items))
+	for i, item := range items {
+		stamped[i] = KeyEntry{Key: item.Key, Entry: stamp(ts, item.Entry)}
+	}
+	return d.DB.BatchPut(ctx, stamped)
+}
+
+func (d *stampDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	ts := option.Ts
+	if ts == 0 {
+		ts = d.clock.Now()
+	}
+	check := option.Check
+	option.Check = func(oldVal, newVal, existVal []byte) ([]byte, error) {
+		existTs, existVal := unstamp(existVal)
+		if option.Observe != nil {
+			option.Observe(existTs, existVal)
+		}
+		if option.UnmodifiedSince > 0 && existTs > option.UnmodifiedSince {
+			return nil, xerror.ErrPreconditionFailed
+		}
+		if option.LWW && existTs >= ts {
+			return nil, xerror.ErrAlreadyExists
+		}
+		val := newVal
+		if check != nil {
+			var err error
+			val, err = check(oldVal, newVal, existVal)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return stamp(ts, val), nil
+	}
+	err := d.DB.CheckAndPut(ctx, key, oldVal, newVal, option)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		_, conflict.Value = unstamp(conflict.Value)
+	}
+	return err
+}
+
+func (d *stampDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	v, err := d.DB.Get(ctx, key, option)
+	if err != nil {
+		return v, err
+	}
+	v.Ts, v.Value = unstamp(v.Value)
+	return v, nil
+}
+
+func (d *stampDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	return d.DB.List(ctx, start, end, limit, unstampOption(option))
+}
+
+func (d *stampDB) Scan(ctx context.Context, start, end []byte, limit int, option ListOption,
+	fn func(key, val []byte) error) error {
+	return scan(ctx, d.DB, start, end, limit, unstampOption(option), fn)
+}
+
+// unstampOption strips the stamps before option.Item.
+func unstampOption(option ListOption) ListOption {
+	item := option.Item
+	option.Item = func(key, val []byte) ([]byte, []byte, error) {
+		_, val = unstamp(val)
+		if item == nil {
+			return key, val, nil
+		}
+		return item(key, val)
+	}
+	return option
+}
+
+// Apply writes a change of another cluster, the last write wins by the
+// stamps. It isn't sent to the connector, so two clusters can replicate
+// each other, and a change applied before is ignored with
+// xerror.ErrAlreadyExists.
+func (s *Store) Apply(ctx context.Context, key []byte, l Log) error {
+	if !s.conf.Server.HLC || l.Ts == 0 {
+		return xerror.ErrNotSupported
+	}
+	// a stamp ahead of the clock is applied anyway, the consumer can't
+	// skip it, a local write may be stamped before it until the wall clock
+	// catches up
+	if err := s.clock.Update(l.Ts); err != nil {
+		s.log.Warnf("apply key %s at %d, %s", key, l.Ts, err)
+	}
+	var existTs uint64
+	var existVal []byte
+	option := CheckOption{
+		Ts:  l.Ts,
+		LWW: true,
+		Observe: func(ts uint64, val []byte) {
+			existTs, existVal = ts, append([]byte(nil), val...)
+		},
+	}
+	// a patch is merged into the local value, whichever it is
+	if l.Patch != nil {
+		option.Check = func(_, _, existVal []byte) ([]byte, error) {
+			return mergePatch(existVal, l.Patch)
+		}
+	}
+	err := s.ConditionalPut(ctx, key, utils.S2B(l.New), option)
+	if l.Patch != nil {
+		return err
+	}
+	if c := detectConflict(key, l, existTs, existVal, err); c != nil {
+		s.saveConflict(ctx, c)
+	}
+	return err
+}
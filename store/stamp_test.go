@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/hlc"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampDB(t *testing.T) {
+	inner := &casDB{memDB{kv: map[string]string{}}}
+	db := newStampDB(inner, hlc.New(0))
+	ctx := context.Background()
+
+	assert.Nil(t, db.Put(ctx, []byte("a"), []byte("1")))
+	assert.NotEqual(t, "1", inner.kv["a"])
+	v, err := db.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), v.Value)
+	assert.NotZero(t, v.Ts)
+	items, err := db.List(ctx, nil, nil, 0, ListOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: "a", Value: "1"}}, items)
+
+	// modified after the stamp given
+	err = db.CheckAndPut(ctx, []byte("a"), nil, []byte("2"), CheckOption{UnmodifiedSince: v.Ts - 1})
+	assert.True(t, errors.Is(err, xerror.ErrPreconditionFailed))
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("a"), nil, []byte("2"), CheckOption{UnmodifiedSince: v.Ts}))
+
+	// last write wins
+	v, _ = db.Get(ctx, []byte("a"), GetOption{})
+	err = db.CheckAndPut(ctx, []byte("a"), nil, []byte("old"), CheckOption{Ts: v.Ts - 1, LWW: true})
+	assert.True(t, errors.Is(err, xerror.ErrAlreadyExists))
+	assert.Nil(t, db.CheckAndPut(ctx, []byte("a"), nil, []byte("new"), CheckOption{Ts: v.Ts + 1, LWW: true}))
+	v, _ = db.Get(ctx, []byte("a"), GetOption{})
+	assert.Equal(t, []byte("new"), v.Value)
+
+	// the conflict has the value without the stamp
+	err = db.CheckAndPut(ctx, []byte("a"), []byte("x"), []byte("y"), CheckOption{Check: equalCheck})
+	var conflict *ConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, []byte("new"), conflict.Value)
+
+	// values written before server.hlc are read as is
+	inner.kv["b"] = "plain"
+	v, err = db.Get(ctx, []byte("b"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, Value{Value: []byte("plain")}, v)
+}
+
+func TestStoreApply(t *testing.T) {
+	conf := config.DefaultConfig()
+	conf.Server.HLC = true
+	clock := hlc.New(0)
+	db := &casDB{memDB{kv: map[string]string{}}}
+	s := &Store{db: newStampDB(db, clock), clock: clock, state: int32(StateReady), conf: conf,
+		log: logrus.WithFields(logrus.Fields{"worker": "store"})}
+	ctx := context.Background()
+
+	// the stamp of the other cluster moves the clock
+	ts := clock.Now() + 1000
+	assert.Nil(t, s.Apply(ctx, []byte("a"), Log{New: "2", Ts: ts}))
+	assert.True(t, clock.Now() > ts)
+	err := s.Apply(ctx, []byte("a"), Log{New: "1", Ts: ts - 1})
+	assert.True(t, errors.Is(err, xerror.ErrAlreadyExists))
+	err = s.Apply(ctx, []byte("a"), Log{New: "2", Ts: ts})
+	assert.True(t, errors.Is(err, xerror.ErrAlreadyExists))
+	v, err := s.Get(ctx, []byte("a"), GetOption{})
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(v.Value))
+	assert.Equal(t, ts, v.Ts)
+
+	assert.Nil(t, s.Apply(ctx, []byte("a"), Log{Old: "2", Ts: ts + 1}))
+	_, ok := db.kv["a"]
+	assert.False(t, ok)
+
+	assert.Equal(t, xerror.ErrNotSupported, s.Apply(ctx, []byte("a"), Log{New: "3"}))
+
+	// only the older change missing the local value is a conflict
+	conflicts, err := s.ListConflicts(ctx, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []Conflict{{Key: []byte("a"), Winner: ConflictLocal, Local: "2", LocalTs: ts,
+		Remote: Log{New: "1", Ts: ts - 1}}}, conflicts)
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// State is the lifecycle of a Store, every method consults it before
+// touching the db, the connector or the cache.
+type State int32
+
+const (
+	// StateInitializing is the state until the db is open, a db which
+	// fails to open leaves the store here.
+	StateInitializing State = iota
+	StateReady
+	// StateDegraded serves requests, but the connector or the cache failed
+	// to open.
+	StateDegraded
+	StateClosed
+)
+
+var stateNames = map[State]string{
+	StateInitializing: "initializing",
+	StateReady:        "ready",
+	StateDegraded:     "degraded",
+	StateClosed:       "closed",
+}
+
+func (st State) String() string {
+	if name, ok := stateNames[st]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func (s *Store) State() State {
+	return State(atomic.LoadInt32(&s.state))
+}
+
+// setState is called with s.mu held, the fields are set before the state
+// so a reader which sees ready also sees them.
+func (s *Store) setState(st State) {
+	prev := State(atomic.SwapInt32(&s.state, int32(st)))
+	metric.StoreState.Set(float64(st))
+	s.log.Infof("store %s -> %s", prev, st)
+}
+
+// opened leaves initializing once the db is open, unless the store was closed
+// meanwhile.
+func (s *Store) opened(degraded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.State() != StateInitializing {
+		return
+	}
+	if degraded {
+		s.setState(StateDegraded)
+	} else {
+		s.setState(StateReady)
+	}
+}
+
+// keep calls set to store a resource opened in the background, false means
+// the store was closed meanwhile and the caller has to close the resource.
+func (s *Store) keep(set func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.State() == StateClosed {
+		return false
+	}
+	set()
+	return true
+}
+
+// usable returns nil when the fields set while opening may be used, the
+// atomic load orders them before the caller's reads.
+func (s *Store) usable() error {
+	switch s.State() {
+	case StateReady, StateDegraded:
+		return nil
+	case StateClosed:
+		return xerror.ErrStoreClosed
+	default:
+		return xerror.ErrDatabaseNotExists
+	}
+}
+
+// ConnectorStatus is the backlog of a connector, Problems lists the limits
+// it exceeds.
+type ConnectorStatus struct {
+	Depth           int64    `json:"depth"`
+	Bytes           int64    `json:"bytes"`
+	LagSeconds      float64  `json:"lag_seconds"`
+	ErrorRate       float64  `json:"error_rate"`
+	DeliverySeconds float64  `json:"delivery_seconds"`
+	Problems        []string `json:"problems,omitempty"`
+}
+
+// StatusReporter is implemented by a connector which reports its backlog.
+type StatusReporter interface {
+	Status() ConnectorStatus
+}
+
+type Status struct {
+	State     string           `json:"state"`
+	Connector *ConnectorStatus `json:"connector,omitempty"`
+}
+
+func (s *Store) Status() Status {
+	st := Status{State: s.State().String()}
+	if s.usable() != nil {
+		return st
+	}
+	if r, ok := baseConnector(s.connector).(StatusReporter); ok {
+		cs := r.Status()
+		st.Connector = &cs
+	}
+	return st
+}
+
+const statusInterval = 10 * time.Second
+
+// runStatusCheck degrades a ready store while the connector exceeds a limit
+// and restores it afterwards, a store degraded while opening stays so.
+func (s *Store) runStatusCheck() {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.checkStatus()
+		}
+	}
+}
+
+func (s *Store) checkStatus() {
+	st := s.Status()
+	lagging := st.Connector != nil && len(st.Connector.Problems) > 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case lagging && s.State() == StateReady:
+		s.log.Warnf("connector %v", st.Connector.Problems)
+		s.lagging = true
+		s.setState(StateDegraded)
+	case !lagging && s.lagging && s.State() == StateDegraded:
+		s.lagging = false
+		s.setState(StateReady)
+	}
+}
@@ -0,0 +1,260 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+type stateDB struct {
+	closed int32
+}
+
+func (d *stateDB) Close() error {
+	atomic.AddInt32(&d.closed, 1)
+	return nil
+}
+
+func (d *stateDB) Put(ctx context.Context, key, val []byte) error            { return nil }
+func (d *stateDB) BatchPut(ctx context.Context, items []KeyEntry) error      { return nil }
+func (d *stateDB) UnsafeDelete(ctx context.Context, start, end []byte) error { return nil }
+
+func (d *stateDB) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	return nil
+}
+
+func (d *stateDB) Get(ctx context.Context, key []byte, option GetOption) (Value, error) {
+	return NoValue, xerror.ErrNotExists
+}
+
+func (d *stateDB) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	return nil, nil
+}
+
+func (d *stateDB) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	return nil, 0, nil
+}
+
+type stateDBDriver struct {
+	name string
+	wait chan struct{}
+	db   *stateDB
+}
+
+func (d *stateDBDriver) Name() string { return d.name }
+
+func (d *stateDBDriver) Open(conf *config.Config) (DB, error) {
+	if d.wait != nil {
+		<-d.wait
+	}
+	if d.db != nil {
+		return d.db, nil
+	}
+	return &stateDB{}, nil
+}
+
+type stateConnector struct{}
+
+func (stateConnector) Close()                  {}
+func (stateConnector) Send(msg KeyEntry) error { return nil }
+
+type lagConnector struct {
+	stateConnector
+	problems []string
+}
+
+func (c *lagConnector) Status() ConnectorStatus {
+	return ConnectorStatus{Problems: c.problems}
+}
+
+type stateConnectorDriver struct {
+	name string
+	err  error
+}
+
+func (d stateConnectorDriver) Name() string { return d.name }
+
+func (d stateConnectorDriver) Open(conf *config.Config) (Connector, error) {
+	return stateConnector{}, d.err
+}
+
+var (
+	stateDriver      = &stateDBDriver{name: "state"}
+	stateBlockDriver = &stateDBDriver{name: "state-block", wait: make(chan struct{}), db: &stateDB{}}
+)
+
+func init() {
+	RegisterDB(stateDriver)
+	RegisterDB(stateBlockDriver)
+	RegisterConnector(stateConnectorDriver{name: "state"})
+	RegisterConnector(stateConnectorDriver{name: "state-fail", err: errors.New("no broker")})
+}
+
+func newStateStore(t *testing.T, db, connector string) *Store {
+	conf := config.DefaultConfig()
+	conf.Store.Name = db
+	conf.Connector.Name = connector
+	conf.Cache.Name = ""
+	conf.HotKey.Enable = false
+	s, err := NewStore(conf)
+	assert.Nil(t, err)
+	return s
+}
+
+func waitOpened(s *Store) {
+	for i := 0; i < 100 && s.State() == StateInitializing; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var stateCalls = map[string]func(ctx context.Context, s *Store) error{
+	"Get": func(ctx context.Context, s *Store) error {
+		_, err := s.Get(ctx, []byte("k"), GetOption{})
+		if errors.Is(err, xerror.ErrNotExists) {
+			return nil
+		}
+		return err
+	},
+	"CheckAndPut": func(ctx context.Context, s *Store) error {
+		return s.CheckAndPut(ctx, []byte("k"), []byte(`{"old":"","new":"v"}`), CheckOption{})
+	},
+	"List": func(ctx context.Context, s *Store) error {
+		_, err := s.List(ctx, []byte("a"), []byte("z"), 10, ListOption{})
+		return err
+	},
+	"BatchPut": func(ctx context.Context, s *Store) error {
+		return s.BatchPut(ctx, []KeyEntry{{Key: []byte("k"), Entry: []byte("v")}})
+	},
+	"BatchDelete": func(ctx context.Context, s *Store) error {
+		_, _, err := s.BatchDelete(ctx, []byte("a"), []byte("z"), 10)
+		return err
+	},
+	"UnsafeDelete": func(ctx context.Context, s *Store) error {
+		return s.UnsafeDelete(ctx, []byte("a"), []byte("z"))
+	},
+	"UnsafePut": func(ctx context.Context, s *Store) error {
+		return s.UnsafePut(ctx, []byte("k"), []byte("v"))
+	},
+	"ReserveIdempotency": func(ctx context.Context, s *Store) error {
+		_, _, err := s.ReserveIdempotency(ctx, "id", &IdempotencyRecord{})
+		return err
+	},
+	"SaveIdempotency": func(ctx context.Context, s *Store) error {
+		return s.SaveIdempotency(ctx, idempotencyKey(1, "id"), &IdempotencyRecord{})
+	},
+	"ReleaseIdempotency": func(ctx context.Context, s *Store) error {
+		return s.ReleaseIdempotency(ctx, idempotencyKey(1, "id"))
+	},
+	"PreSplit": func(ctx context.Context, s *Store) error {
+		_, err := s.PreSplit(ctx, [][]byte{[]byte("m")}, false, false)
+		if errors.Is(err, xerror.ErrNotSupported) {
+			return nil
+		}
+		return err
+	},
+	"Cluster": func(ctx context.Context, s *Store) error {
+		_, err := s.Cluster(ctx)
+		if errors.Is(err, xerror.ErrNotSupported) {
+			return nil
+		}
+		return err
+	},
+	"Health": func(ctx context.Context, s *Store) error {
+		return s.Health()
+	},
+}
+
+func TestStoreState(t *testing.T) {
+	cases := []struct {
+		state State
+		setup func(t *testing.T) *Store
+		err   error
+	}{
+		{StateInitializing, func(t *testing.T) *Store {
+			return newStateStore(t, "state", "state")
+		}, xerror.ErrDatabaseNotExists},
+		{StateReady, func(t *testing.T) *Store {
+			s := newStateStore(t, "state", "state")
+			s.Open()
+			waitOpened(s)
+			return s
+		}, nil},
+		{StateDegraded, func(t *testing.T) *Store {
+			s := newStateStore(t, "state", "state-fail")
+			s.Open()
+			waitOpened(s)
+			return s
+		}, nil},
+		{StateClosed, func(t *testing.T) *Store {
+			s := newStateStore(t, "state", "state")
+			s.Open()
+			waitOpened(s)
+			assert.Nil(t, s.Close())
+			return s
+		}, xerror.ErrStoreClosed},
+	}
+	ctx := context.Background()
+	for _, c := range cases {
+		s := c.setup(t)
+		assert.Equal(t, c.state, s.State())
+		for name, call := range stateCalls {
+			err := call(ctx, s)
+			if c.err == nil {
+				assert.Nil(t, err, "%s %s", c.state, name)
+			} else {
+				assert.True(t, errors.Is(err, c.err), "%s %s: %v", c.state, name, err)
+			}
+		}
+		s.SendAudit("id", []byte("{}"))
+		assert.Nil(t, s.Close())
+		assert.Nil(t, s.Close())
+		assert.Equal(t, StateClosed, s.State())
+	}
+}
+
+func TestStoreCloseWhileOpening(t *testing.T) {
+	s := newStateStore(t, "state-block", "state")
+	s.Open()
+	assert.Nil(t, s.Close())
+	close(stateBlockDriver.wait)
+	db := stateBlockDriver.db
+	for i := 0; i < 100 && atomic.LoadInt32(&db.closed) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the db opened after Close is closed instead of kept
+	assert.Equal(t, StateClosed, s.State())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&db.closed))
+	assert.True(t, errors.Is(s.Health(), xerror.ErrStoreClosed))
+}
+
+func TestStoreStatusCheck(t *testing.T) {
+	s := newStateStore(t, "state", "state")
+	assert.Nil(t, s.OpenDatabase())
+	lc := &lagConnector{}
+	s.connector = lc
+
+	s.checkStatus()
+	assert.Equal(t, StateReady, s.State())
+	lc.problems = []string{"lag 1m0s > 10s"}
+	s.checkStatus()
+	assert.Equal(t, StateDegraded, s.State())
+	assert.Equal(t, lc.problems, s.Status().Connector.Problems)
+	lc.problems = nil
+	s.checkStatus()
+	assert.Equal(t, StateReady, s.State())
+	assert.Nil(t, s.Close())
+
+	// degraded while opening isn't restored
+	s = newStateStore(t, "state", "state-fail")
+	s.Open()
+	waitOpened(s)
+	s.checkStatus()
+	assert.Equal(t, StateDegraded, s.State())
+	assert.Nil(t, s.Close())
+}
@@ -1,24 +1,32 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 	"github.com/huangnauh/tirest/config"
 	"github.com/huangnauh/tirest/utils"
+	"github.com/huangnauh/tirest/utils/hlc"
 	"github.com/huangnauh/tirest/utils/json"
 	"github.com/huangnauh/tirest/xerror"
 )
 
+// DB calls stop once ctx is done, the driver timeouts still apply on top.
 type DB interface {
 	Close() error
-	Put(key, val []byte) error
-	BatchPut(items []KeyEntry) error                                  //TODO: ErrEntryTooLarge
-	CheckAndPut(key, oldVal, newVal []byte, option CheckOption) error // to kafka
-	Get(key []byte, option GetOption) (Value, error)
-	List(start, end []byte, limit int, option ListOption) ([]KeyValue, error)
-	BatchDelete(start, end []byte, limit int) ([]byte, int, error)
-	UnsafeDelete(start, end []byte) error
+	Put(ctx context.Context, key, val []byte) error
+	BatchPut(ctx context.Context, items []KeyEntry) error                                  //TODO: ErrEntryTooLarge
+	CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error // to kafka
+	Get(ctx context.Context, key []byte, option GetOption) (Value, error)
+	List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error)
+	BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error)
+	UnsafeDelete(ctx context.Context, start, end []byte) error
 }
 
 type CheckFunc func(oldVal, newVal, existVal []byte) ([]byte, error)
@@ -29,9 +37,16 @@ type KeyValue struct {
 	Value string `json:"value"`
 }
 
+// Value has a Reader instead of Value if it's opened by GetStream from a
+// blob, the caller closes it. Ts is the HLC stamp of the write, 0 without
+// server.hlc, and Seq the sequence of the key, 0 without server.sequence.
 type Value struct {
 	Secondary bool
 	Value     []byte
+	Reader    io.ReadCloser
+	Size      int64
+	Ts        uint64
+	Seq       uint64
 }
 
 var NoValue = Value{}
@@ -41,6 +56,11 @@ type KeyEntry struct {
 	Entry []byte
 }
 
+// GetOption reads Secondary, another key as stored, when the key doesn't
+// exist, e.g. the key of the old layout during a migration. The drivers
+// read both in one snapshot, the tiers of the two keys may differ, and
+// Value.Secondary tells the value is the secondary's. A get with Secondary
+// isn't cached.
 type GetOption struct {
 	ReplicaRead bool
 	Secondary   []byte
@@ -53,8 +73,18 @@ type ListOption struct {
 	Item        ItemFunc
 }
 
+// CheckOption stamps the write with Ts if it's set. With server.hlc, a CAS
+// fails if the key was stamped after UnmodifiedSince, and for LWW it's
+// ignored as ErrAlreadyExists unless Ts is after the stamp of the key.
+// Observe is then called with the stamp and the value found. With
+// server.sequence, Sequenced is called with the sequence of the write.
 type CheckOption struct {
-	Check CheckFunc
+	Check           CheckFunc
+	Ts              uint64
+	UnmodifiedSince uint64
+	LWW             bool
+	Observe         func(ts uint64, val []byte)
+	Sequenced       func(seq uint64)
 }
 
 type Connector interface {
@@ -63,15 +93,39 @@ type Connector interface {
 }
 
 type Store struct {
+	gen       uint64
+	state     int32
+	mu        sync.Mutex
+	lagging   bool
 	db        DB
 	connector Connector
+	cache     Cache
+	blobs     BlobStore
+	hotKeys   *HotKeys
+	hotWrites *HotKeys
+	filter    *EventFilter
+	salts     *salter
+	hooks     []Hook
+	repairs   *repairer
+	keys      *keyring
+	fields    *fieldSealer
+	clock     *hlc.Clock
+	watchers  *Watchers
+	closed    chan struct{}
 	conf      *config.Config
 	log       *logrus.Entry
 }
 
+// Log is a change, Ts is the stamp of the write with server.hlc and Seq
+// the sequence of the key with server.sequence. With connector.delta, the
+// connector may get the change of a json object with Patch, the json merge
+// patch from the old value to the new one, instead of Old and New.
 type Log struct {
-	Old string `json:"old"`
-	New string `json:"new"`
+	Old   string          `json:"old"`
+	New   string          `json:"new"`
+	Ts    uint64          `json:"ts,omitempty"`
+	Seq   uint64          `json:"seq,omitempty"`
+	Patch json.RawMessage `json:"patch,omitempty"`
 }
 
 type DBDriver interface {
@@ -106,6 +160,9 @@ func RegisterConnector(driver ConnectorDriver) {
 }
 
 func NewStore(conf *config.Config) (*Store, error) {
+	if err := LoadPlugins(conf.Plugins); err != nil {
+		return nil, err
+	}
 	_, ok := cDrivers[conf.Connector.Name]
 	if !ok {
 		return nil, xerror.ErrConnectorNotRegister
@@ -114,13 +171,84 @@ func NewStore(conf *config.Config) (*Store, error) {
 	if !ok {
 		return nil, xerror.ErrDatabaseNotRegister
 	}
-	return &Store{
-		conf: conf,
-		log:  logrus.WithFields(logrus.Fields{"worker": "store"}),
-	}, nil
+	for _, t := range conf.Tiers {
+		if _, ok = dDrivers[t.Name]; !ok {
+			return nil, xerror.ErrDatabaseNotRegister
+		}
+	}
+	if conf.Shadow.Enable {
+		if _, ok = dDrivers[conf.Shadow.StoreConfig(conf.Store).Name]; !ok {
+			return nil, xerror.ErrDatabaseNotRegister
+		}
+	}
+	if conf.Cache.Name != "" {
+		_, ok = caDrivers[conf.Cache.Name]
+		if !ok {
+			return nil, xerror.ErrCacheNotRegister
+		}
+	}
+	for _, name := range conf.Server.Hooks {
+		if _, ok = hDrivers[name]; !ok {
+			return nil, xerror.ErrHookNotRegister
+		}
+	}
+	s := &Store{
+		closed: make(chan struct{}),
+		conf:   conf,
+		clock:  hlc.New(conf.Server.HLCMaxOffset.Duration),
+		log:    logrus.WithFields(logrus.Fields{"worker": "store"}),
+	}
+	if conf.Blob.Name == ChunkBlobName {
+		s.blobs = &chunkStore{s: s}
+	} else if conf.Blob.Name != "" {
+		bDriver, ok := bDrivers[conf.Blob.Name]
+		if !ok {
+			return nil, xerror.ErrBlobNotRegister
+		}
+		blobs, err := bDriver.Open(conf)
+		if err != nil {
+			return nil, err
+		}
+		s.blobs = blobs
+	}
+	if conf.HotKey.Enable {
+		s.hotKeys = NewHotKeys(&conf.HotKey)
+	}
+	if conf.HotRegion.Enable {
+		s.hotWrites = newHotKeys(conf.HotRegion.HotKey(), nil)
+	}
+	if conf.Watch.Enable {
+		s.watchers = NewWatchers(conf.Watch.MaxClients, conf.Watch.Buffer, conf.Watch.History)
+	}
+	filter, err := NewEventFilter(conf.EventRules)
+	if err != nil {
+		return nil, err
+	}
+	s.filter = filter
+	s.salts = newSalter(conf.Salts)
+	if conf.ReadRepair.Enable {
+		s.repairs = newRepairer(&conf.ReadRepair)
+	}
+	if s.hooks, err = openHooks(conf); err != nil {
+		return nil, err
+	}
+	if len(conf.FieldRules) > 0 {
+		if conf.Encryption.Enable {
+			if s.keys, err = openKeyring(conf); err != nil {
+				return nil, err
+			}
+		}
+		if s.fields, err = newFieldSealer(conf.FieldRules, s.keys); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 func OnlyOpenDatabase(conf *config.Config) (*Store, error) {
+	if err := LoadPlugins(conf.Plugins); err != nil {
+		return nil, err
+	}
 	conf.Store.GCEnable = false
 
 	_, ok := dDrivers[conf.Store.Name]
@@ -128,8 +256,10 @@ func OnlyOpenDatabase(conf *config.Config) (*Store, error) {
 		return nil, xerror.ErrDatabaseNotRegister
 	}
 	s := &Store{
-		conf: conf,
-		log:  logrus.WithFields(logrus.Fields{"worker": "database"}),
+		closed: make(chan struct{}),
+		conf:   conf,
+		clock:  hlc.New(conf.Server.HLCMaxOffset.Duration),
+		log:    logrus.WithFields(logrus.Fields{"worker": "database"}),
 	}
 
 	err := s.OpenDatabase()
@@ -139,38 +269,192 @@ func OnlyOpenDatabase(conf *config.Config) (*Store, error) {
 	return s, nil
 }
 
+// NewConnector opens the connector of conf without a store, e.g. to send
+// changes again.
+func NewConnector(conf *config.Config) (Connector, error) {
+	if err := LoadPlugins(conf.Plugins); err != nil {
+		return nil, err
+	}
+	cDriver, ok := cDrivers[conf.Connector.Name]
+	if !ok {
+		return nil, xerror.ErrConnectorNotRegister
+	}
+	return cDriver.Open(conf)
+}
+
 func (s *Store) OpenConnector() error {
 	cDriver := cDrivers[s.conf.Connector.Name]
 	connector, err := cDriver.Open(s.conf)
 	if err != nil {
 		s.log.Errorf("open connector %s failed, %s", s.conf.Connector.Name, err)
-	} else {
-		s.connector = connector
+		return err
 	}
-	return err
+	if s.conf.Fault.Enable {
+		connector = newFaultConnector(connector, &s.conf.Fault)
+	}
+	if !s.keep(func() { s.connector = connector }) {
+		connector.Close()
+		return xerror.ErrStoreClosed
+	}
+	return nil
 }
 
-func (s *Store) OpenDatabase() error {
+func (s *Store) openDatabase() error {
 	dDriver := dDrivers[s.conf.Store.Name]
 	db, err := dDriver.Open(s.conf)
 	if err != nil {
 		s.log.Errorf("open db %s failed, %s", s.conf.Store.Name, err)
-	} else {
-		s.db = db
+		return err
+	}
+	if len(s.conf.Tiers) > 0 {
+		tiers, err := openTiers(s.conf)
+		if err != nil {
+			s.log.Errorf("open tiers failed, %s", err)
+			db.Close()
+			return err
+		}
+		db = newRouterDB(db, tiers)
+	}
+	if s.salts != nil {
+		db = newSaltDB(db, s.salts)
+	}
+	// the checksums cover the values as stored
+	if s.conf.Checksum.Enable {
+		db = newChecksumDB(db, &s.conf.Checksum, s.repairs)
+	}
+	// the faults are taken as failures of TiKV, by the shadow too
+	if s.conf.Fault.Enable {
+		db = newFaultDB(db, &s.conf.Fault)
+	}
+	if s.conf.Shadow.Enable {
+		shadow, err := openShadow(s.conf)
+		if err != nil {
+			s.log.Errorf("open shadow failed, %s", err)
+			db.Close()
+			return err
+		}
+		db = newShadowDB(db, shadow, &s.conf.Shadow)
+	}
+	if s.conf.Breaker.Enable {
+		db = newBreakerDB(db, &s.conf.Breaker)
+	}
+	// calls waiting for a slot don't count for the breaker
+	if s.conf.Bulkhead.Enable {
+		db = newBulkheadDB(db, &s.conf.Bulkhead)
+	}
+	// a batch is admitted as interactive
+	if s.conf.Priority.Enable {
+		db = newPriorityDB(db, &s.conf.Priority)
+	}
+	// a batch takes one write slot
+	if s.conf.Coalesce.Enable {
+		db = newCoalesceDB(db, &s.conf.Coalesce)
+	}
+	// the stamps are sealed along with the value
+	if s.conf.Encryption.Enable {
+		if s.keys == nil {
+			s.keys, err = openKeyring(s.conf)
+			if err != nil {
+				s.log.Errorf("open kms %s failed, %s", s.conf.Encryption.KMS, err)
+				db.Close()
+				return err
+			}
+		}
+		db = newCryptDB(db, s.keys, &s.conf.Encryption)
+	}
+	// the stamp is checked before the sequence is taken
+	if s.conf.Server.Sequence {
+		db = newSeqDB(db)
+	}
+	if s.conf.Server.HLC {
+		db = newStampDB(db, s.clock)
+	}
+	if !s.keep(func() { s.db = db }) {
+		db.Close()
+		return xerror.ErrStoreClosed
+	}
+	return nil
+}
+
+// OpenDatabase opens the db alone, the store is ready once it returns nil.
+func (s *Store) OpenDatabase() error {
+	err := s.openDatabase()
+	if err == nil {
+		s.opened(false)
 	}
 	return err
 }
 
+// Open opens the connector, the db and the cache in the background, the
+// store turns ready after all of them are done, or degraded if the
+// connector or the cache failed.
 func (s *Store) Open() {
-	go s.OpenConnector()
-	go s.OpenDatabase()
+	go func() {
+		var wg sync.WaitGroup
+		var connErr, cacheErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			connErr = s.OpenConnector()
+		}()
+		go func() {
+			defer wg.Done()
+			cacheErr = s.OpenCache()
+		}()
+		err := s.openDatabase()
+		wg.Wait()
+		if err == nil {
+			s.opened(connErr != nil || cacheErr != nil)
+			s.syncCatalog()
+		}
+	}()
+	if s.hotKeys != nil {
+		s.hotKeys.Start()
+	}
+	if s.hotWrites != nil {
+		s.hotWrites.Start()
+		go s.runHotRegions()
+	}
+	if s.conf.Server.IdempotencyWindow.Duration > 0 {
+		go s.runIdempotencySweeper()
+	}
+	if s.conf.Server.HLC && s.conf.Replica.ConflictRetention.Duration > 0 {
+		go s.runConflictSweeper()
+	}
+	go s.runStatusCheck()
 }
 
+// Close is safe to call more than once, a resource still opening is closed
+// once it's done.
 func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.State() == StateClosed {
+		return nil
+	}
+	s.setState(StateClosed)
+	close(s.closed)
+	if s.hotKeys != nil {
+		s.hotKeys.Close()
+	}
+	if s.hotWrites != nil {
+		s.hotWrites.Close()
+	}
+	// the repairs queued write to the db
+	if s.repairs != nil {
+		s.repairs.Close()
+	}
 	if s.connector != nil {
 		logrus.Infof("close connector %s", s.conf.Connector.Name)
 		s.connector.Close()
 	}
+	if s.cache != nil {
+		logrus.Infof("close cache %s", s.conf.Cache.Name)
+		err := s.cache.Close()
+		if err != nil {
+			logrus.Errorf("close cache %s failed, %s", s.conf.Cache.Name, err)
+		}
+	}
 	if s.db != nil {
 		logrus.Infof("close db %s", s.conf.Store.Name)
 		return s.db.Close()
@@ -178,47 +462,182 @@ func (s *Store) Close() error {
 	return nil
 }
 
+// Health fails until the db is open and after the store is closed, a
+// degraded store is still healthy.
 func (s *Store) Health() error {
-	if s.db == nil {
-		return xerror.ErrDatabaseNotExists
+	return s.usable()
+}
+
+// contextError reports a call which failed because the caller gave up or
+// ran out of time, so it isn't taken as a failure of TiKV.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
 	}
-	return nil
+	switch ctx.Err() {
+	case context.Canceled:
+		return xerror.ErrRequestCanceled.Wrap(err)
+	case context.DeadlineExceeded:
+		return xerror.ErrRequestTimeout.Wrap(err)
+	}
+	return err
 }
 
-func (s *Store) Get(key []byte, opt GetOption) (Value, error) {
-	if s.db == nil {
-		return NoValue, xerror.ErrNotExists
+// Get reads the blob of a pointer record into Value.
+func (s *Store) Get(ctx context.Context, key []byte, opt GetOption) (Value, error) {
+	v, err := s.get(ctx, key, opt)
+	s.afterGet(ctx, key, v, err)
+	return v, err
+}
+
+func (s *Store) get(ctx context.Context, key []byte, opt GetOption) (Value, error) {
+	v, err := s.getValue(ctx, key, opt)
+	if err != nil || s.blobs == nil {
+		return v, err
 	}
-	v, err := s.db.Get(key, opt)
-	if err == xerror.ErrNotExists {
+	p, ok := decodePointer(v.Value)
+	if !ok {
+		return v, nil
+	}
+	data, err := s.readBlob(ctx, p)
+	if err != nil {
+		return NoValue, contextError(ctx, xerror.ErrGetKVFailed.Wrap(err))
+	}
+	return Value{Secondary: v.Secondary, Value: data}, nil
+}
+
+// GetStream opens the blob of a pointer record as Value.Reader, other
+// values are returned as Get does.
+func (s *Store) GetStream(ctx context.Context, key []byte, opt GetOption) (Value, error) {
+	v, err := s.getStream(ctx, key, opt)
+	s.afterGet(ctx, key, v, err)
+	return v, err
+}
+
+func (s *Store) getStream(ctx context.Context, key []byte, opt GetOption) (Value, error) {
+	v, err := s.getValue(ctx, key, opt)
+	if err != nil || s.blobs == nil {
+		return v, err
+	}
+	v, err = s.openValue(ctx, v)
+	if err != nil {
+		return NoValue, contextError(ctx, xerror.ErrGetKVFailed.Wrap(err))
+	}
+	return v, nil
+}
+
+// getValue caches the pointer records, not the blobs.
+func (s *Store) getValue(ctx context.Context, key []byte, opt GetOption) (Value, error) {
+	if err := s.usable(); err != nil {
+		return NoValue, err
+	}
+	if IsReserved(key) {
+		return NoValue, xerror.ErrKeyReserved
+	}
+	if s.hotKeys != nil {
+		s.hotKeys.Touch(key)
+	}
+	if v, ok := s.cacheGet(key, opt); ok {
+		if len(v.Value) == 0 {
+			return NoValue, xerror.ErrNotExists
+		}
+		// the arguments of Debugf escape even when it doesn't log
+		if s.log.Logger.IsLevelEnabled(logrus.DebugLevel) {
+			s.log.Debugf("key %s cached value %s", key, v.Value)
+		}
+		return v, nil
+	}
+
+	gen := s.cacheGen()
+	bctx, cancel := s.budget(ctx, EndpointGet)
+	v, err := s.db.Get(bctx, key, opt)
+	err = timedOut(EndpointGet, contextError(bctx, err))
+	cancel()
+	if errors.Is(err, xerror.ErrNotExists) {
+		s.cacheSetMissing(key, opt, gen)
 		return NoValue, xerror.ErrNotExists
 	} else if err != nil {
 		s.log.Errorf("get key %s failed, %s", key, err)
 		return NoValue, err
 	}
-	s.log.Debugf("key %s value %t %s", key, v.Secondary, v.Value)
+	if s.log.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		s.log.Debugf("key %s value %t %s", key, v.Secondary, v.Value)
+	}
+	s.cacheSet(key, opt, gen, v)
+	s.repairSecondary(key, v)
 	return v, nil
 }
 
-func (s *Store) CheckAndPut(key, entry []byte, option CheckOption) error {
-	if s.db == nil {
-		return xerror.ErrNotExists
+func (s *Store) checkAndPut(ctx context.Context, key, oldVal, newVal []byte, option CheckOption) error {
+	if IsReserved(key) {
+		return xerror.ErrKeyReserved
+	}
+	if s.hotWrites != nil {
+		s.hotWrites.Touch(key)
+	}
+	if s.conf.Server.DedupWrites {
+		option.Check = dedupCheck(option.Check)
+	}
+	var w *blobWrite
+	if s.blobs != nil {
+		w = &blobWrite{}
+		option.Check = s.blobCheck(ctx, key, option.Check, w)
+	}
+	bctx, cancel := s.budget(ctx, EndpointCas)
+	err := timedOut(EndpointCas, contextError(bctx, s.db.CheckAndPut(bctx, key, oldVal, newVal, option)))
+	cancel()
+	if w != nil {
+		err = s.blobDone(ctx, w, err)
+	}
+	if !errors.Is(err, xerror.ErrAlreadyExists) {
+		s.cacheInvalidate(key)
+	}
+	return err
+}
+
+func (s *Store) CheckAndPut(ctx context.Context, key, entry []byte, option CheckOption) error {
+	if err := s.usable(); err != nil {
+		return err
 	}
 
 	if len(entry) == 0 {
 		s.log.Errorf("key %s cas need body", key)
-		return xerror.ErrNotExists
+		return xerror.ErrCheckAndSetInvalid
 	}
 
 	l := &Log{}
 	err := json.Unmarshal(entry, &l)
 	if err != nil {
 		s.log.Errorf("key %s cas invalid, %s", key, err)
+		return xerror.ErrCheckAndSetInvalid.Wrap(err)
+	}
+
+	limit := s.conf.Server.MaxValueSize
+	if limit > 0 && int64(len(l.New)) > limit {
+		s.log.Errorf("key %s value size %d > %d", key, len(l.New), limit)
+		return xerror.ErrValueTooLarge
+	}
+	if err := s.beforePut(ctx, key, utils.S2B(l.New)); err != nil {
 		return err
 	}
 
-	err = s.db.CheckAndPut(key, utils.S2B(l.Old), utils.S2B(l.New), option)
-	if err == xerror.ErrAlreadyExists {
+	if s.conf.Server.HLC && option.Ts == 0 {
+		option.Ts = s.clock.Now()
+	}
+	newVal := utils.S2B(l.New)
+	if s.fields != nil {
+		if newVal, err = s.fields.apply(key, newVal, sealMode); err != nil {
+			s.log.Errorf("key %s seal fields failed, %s", key, err)
+			return err
+		}
+		option.Check = s.fields.openCheck(key, option.Check)
+	}
+	var seq uint64
+	if s.conf.Server.Sequence {
+		option.Sequenced = func(n uint64) { seq = n }
+	}
+	err = s.checkAndPut(ctx, key, utils.S2B(l.Old), newVal, option)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
 		s.log.Debugf("key %s already exist, %s", key, err)
 		return err
 	} else if err != nil {
@@ -227,18 +646,56 @@ func (s *Store) CheckAndPut(key, entry []byte, option CheckOption) error {
 	}
 	s.log.Debugf("key %s old %s new %s", key, l.Old, l.New)
 
-	if entry != nil && s.connector != nil {
-		s.connector.Send(KeyEntry{Key: key, Entry: entry})
+	if entry != nil && (s.connector != nil || s.watchers != nil) {
+		changed := false
+		// the events carry the fields sealed as they're stored
+		if s.fields != nil {
+			oldVal, err := s.fields.apply(key, utils.S2B(l.Old), sealMode)
+			if err != nil {
+				s.log.Errorf("key %s seal fields failed, %s", key, err)
+				return err
+			}
+			l.Old, l.New, changed = string(oldVal), string(newVal), true
+		}
+		// the stamp lets another cluster apply the change in order
+		if option.Ts != 0 && l.Ts != option.Ts {
+			l.Ts, changed = option.Ts, true
+		}
+		// the consumers order the changes of a key by it
+		if seq != 0 && l.Seq != seq {
+			l.Seq, changed = seq, true
+		}
+		if changed {
+			if b, err := json.Marshal(l); err == nil {
+				entry = b
+			}
+		}
+		s.send(KeyEntry{Key: key, Entry: entry})
 	}
 	return nil
 }
 
-func (s *Store) List(start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
-	if s.db == nil {
-		return nil, xerror.ErrNotExists
+func (s *Store) List(ctx context.Context, start, end []byte, limit int, option ListOption) ([]KeyValue, error) {
+	if err := s.usable(); err != nil {
+		return nil, err
 	}
 
-	res, err := s.db.List(start, end, limit, option)
+	ctx, cancel := s.budget(ctx, EndpointList)
+	defer cancel()
+	var res []KeyValue
+	var err error
+	if spans := s.listSpans(ctx, start, end, option.Reverse); spans != nil {
+		err = s.scanSpans(ctx, spans, limit, option, func(key, val []byte) error {
+			res = append(res, KeyValue{Key: utils.B2S(key), Value: utils.B2S(val)})
+			return nil
+		})
+	} else {
+		res, err = s.db.List(ctx, start, end, limit, option)
+	}
+	if err == nil && s.blobs != nil && !option.KeyOnly {
+		err = s.resolveItems(ctx, res)
+	}
+	err = timedOut(EndpointList, contextError(ctx, err))
 	if err != nil {
 		s.log.Errorf("list (%s-%s) limit %d, %s", start, end, limit, err)
 		return nil, err
@@ -247,60 +704,247 @@ func (s *Store) List(start, end []byte, limit int, option ListOption) ([]KeyValu
 	return res, nil
 }
 
-func (s *Store) BatchPut(items []KeyEntry) error {
-	if s.db == nil {
-		return xerror.ErrNotExists
+func (s *Store) BatchPut(ctx context.Context, items []KeyEntry) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if IsReserved(item.Key) {
+			return xerror.ErrKeyReserved
+		}
+	}
+	for _, item := range items {
+		if err := s.beforePut(ctx, item.Key, item.Entry); err != nil {
+			return err
+		}
+	}
+	if s.hotWrites != nil {
+		for _, item := range items {
+			s.hotWrites.Touch(item.Key)
+		}
 	}
 
-	err := s.db.BatchPut(items)
+	if s.fields != nil {
+		sealed := make([]KeyEntry, len(items))
+		for i, item := range items {
+			entry, err := s.fields.apply(item.Key, item.Entry, sealMode)
+			if err != nil {
+				s.log.Errorf("key %s seal fields failed, %s", item.Key, err)
+				return err
+			}
+			sealed[i] = KeyEntry{Key: item.Key, Entry: entry}
+		}
+		items = sealed
+	}
+	var written []*BlobPointer
+	if s.blobs != nil {
+		var err error
+		items, written, err = s.blobItems(ctx, items)
+		if err != nil {
+			return err
+		}
+	}
+	bctx, cancel := s.budget(ctx, EndpointPut)
+	err := timedOut(EndpointPut, contextError(bctx, s.db.BatchPut(bctx, items)))
+	cancel()
+	for _, item := range items {
+		s.cacheInvalidate(item.Key)
+	}
 	if err != nil {
+		for _, p := range written {
+			s.deleteBlob(p)
+		}
 		s.log.Errorf("batch delete err %s", err)
 		return err
 	}
 	return nil
 }
 
-func (s *Store) BatchDelete(start, end []byte, limit int) ([]byte, int, error) {
-	if s.db == nil {
-		return nil, 0, xerror.ErrNotExists
+func (s *Store) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	if err := s.usable(); err != nil {
+		return nil, 0, err
 	}
 
-	lastKey, deleted, err := s.db.BatchDelete(start, end, limit)
+	ctx, cancel := s.budget(ctx, EndpointBatchDelete)
+	defer cancel()
+	// the keys a batch deletes are scanned first, for the blobs
+	pointers, err := s.rangePointers(ctx, start, end, limit)
+	if err != nil {
+		err = timedOut(EndpointBatchDelete, contextError(ctx, err))
+		s.log.Errorf("scan blobs of (%s-%s) limit %d err %s", start, end, limit, err)
+		return nil, 0, err
+	}
+	lastKey, deleted, err := s.db.BatchDelete(ctx, start, end, limit)
+	err = timedOut(EndpointBatchDelete, contextError(ctx, err))
+	s.cacheInvalidateRange(start, end)
+	s.onDelete(ctx, start, end, deleted, err)
 	if err != nil {
 		s.log.Errorf("deleted %d (%s-%s) limit %d err %s", deleted, start, end, limit, err)
 		return lastKey, deleted, err
 	}
+	s.deleteRangeBlobs(pointers, lastKey, limit > 0 && deleted >= limit)
 	//TODO
 	s.log.Infof("deleted %d (%s-%s)", deleted, start, end)
 	return lastKey, deleted, nil
 }
 
-func (s *Store) UnsafeDelete(start, end []byte) error {
-	if s.db == nil {
-		return xerror.ErrNotExists
+func (s *Store) UnsafeDelete(ctx context.Context, start, end []byte) error {
+	if err := s.usable(); err != nil {
+		return err
 	}
 
-	err := s.db.UnsafeDelete(start, end)
+	spans := outsideCatalog([]span{{start: start, end: end}}, false)
+	var pointers []keyPointer
+	for _, sp := range spans {
+		ps, err := s.rangePointers(ctx, sp.start, sp.end, 0)
+		if err != nil {
+			err = contextError(ctx, err)
+			s.log.Errorf("scan blobs of (%s-%s), err %s", start, end, err)
+			return err
+		}
+		pointers = append(pointers, ps...)
+	}
+	var err error
+	for _, sp := range spans {
+		if err = s.db.UnsafeDelete(ctx, sp.start, sp.end); err != nil {
+			break
+		}
+	}
+	err = contextError(ctx, err)
+	s.cacheInvalidateRange(start, end)
+	s.onDelete(ctx, start, end, -1, err)
 	if err != nil {
 		s.log.Errorf("unsafe deleted (%s-%s), err %s", start, end, err)
 		return err
 	}
+	s.deleteRangeBlobs(pointers, nil, false)
 	//TODO
 	s.log.Infof("unsafe deleted (%s-%s)", start, end)
 	return nil
 }
 
-func (s *Store) UnsafePut(key, val []byte) error {
-	if s.db == nil {
-		return xerror.ErrNotExists
+func (s *Store) UnsafePut(ctx context.Context, key, val []byte) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	if IsReserved(key) {
+		return xerror.ErrKeyReserved
+	}
+	if err := s.beforePut(ctx, key, val); err != nil {
+		return err
+	}
+	if s.hotWrites != nil {
+		s.hotWrites.Touch(key)
+	}
+	val, err := s.fields.apply(key, val, sealMode)
+	if err != nil {
+		s.log.Errorf("key %s seal fields failed, %s", key, err)
+		return err
+	}
+	var old *BlobPointer
+	if s.blobs != nil {
+		if len(val) > s.conf.Blob.Threshold {
+			p, err := s.putBlob(ctx, key, val)
+			if err != nil {
+				return err
+			}
+			return s.putPointer(ctx, key, p)
+		}
+		old = s.pointerOf(ctx, key)
+	}
+	if old == nil && s.unchanged(ctx, key, val) {
+		return xerror.ErrAlreadyExists
 	}
 
-	err := s.db.Put(key, val)
+	bctx, cancel := s.budget(ctx, EndpointPut)
+	err = timedOut(EndpointPut, contextError(bctx, s.db.Put(bctx, key, val)))
+	cancel()
+	s.cacheInvalidate(key)
 	if err != nil {
 		s.log.Errorf("unsafe put %s val %s, err %s", key, val, err)
 		return err
 	}
+	s.deleteBlob(old)
 	//TODO
 	s.log.Debugf("unsafe put %s val %s", key, val)
 	return nil
 }
+
+// Clock is the HLC which stamps the writes with server.hlc.
+func (s *Store) Clock() *hlc.Clock {
+	return s.clock
+}
+
+// ConditionalPut is an unsafe put checked by option, it isn't sent to the
+// connector.
+func (s *Store) ConditionalPut(ctx context.Context, key, val []byte, option CheckOption) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+
+	// the values applied from another cluster are sealed already
+	val, err := s.fields.apply(key, val, sealMode)
+	if err != nil {
+		s.log.Errorf("key %s seal fields failed, %s", key, err)
+		return err
+	}
+	err = s.checkAndPut(ctx, key, nil, val, option)
+	if errors.Is(err, xerror.ErrAlreadyExists) {
+		s.log.Debugf("conditional put %s ignored, %s", key, err)
+		return err
+	} else if err != nil {
+		s.log.Errorf("conditional put %s failed, %s", key, err)
+		return err
+	}
+	s.log.Debugf("conditional put %s val %s", key, val)
+	return nil
+}
+
+// Streaming is true if UnsafePutStream keeps a large value of key out of
+// memory, the hooks and the sealed fields need the whole value, so a key
+// they apply to is read in full.
+func (s *Store) Streaming(key []byte) bool {
+	if s.blobs == nil || len(s.hooks) > 0 {
+		return false
+	}
+	return s.fields == nil || len(s.fields.match(key)) == 0
+}
+
+// UnsafePutStream puts the value read from r, up to blob.threshold bytes are
+// read into memory and a larger value is streamed to the blob store.
+func (s *Store) UnsafePutStream(ctx context.Context, key []byte, r io.Reader) error {
+	if err := s.usable(); err != nil {
+		return err
+	}
+	if IsReserved(key) {
+		return xerror.ErrKeyReserved
+	}
+	if s.hotWrites != nil {
+		s.hotWrites.Touch(key)
+	}
+	if !s.Streaming(key) {
+		val, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return s.UnsafePut(ctx, key, val)
+	}
+
+	buf := make([]byte, s.conf.Blob.Threshold+1)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.UnsafePut(ctx, key, buf[:n])
+	} else if err != nil {
+		return err
+	}
+	p, err := s.streamBlob(ctx, key, io.MultiReader(bytes.NewReader(buf), r))
+	if err != nil {
+		return err
+	}
+	if err = s.putPointer(ctx, key, p); err != nil {
+		s.log.Errorf("unsafe put %s blob %s, err %s", key, p.Name, err)
+		return err
+	}
+	s.log.Debugf("unsafe put %s blob %s size %d", key, p.Name, p.Size)
+	return nil
+}
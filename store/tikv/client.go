@@ -2,6 +2,7 @@ package tikv
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -54,12 +55,12 @@ func (t *TiKV) Close() error {
 	return t.client.Close()
 }
 
-func (t *TiKV) Get(key []byte, option store.GetOption) (store.Value, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.ReadTimeout.Duration)
+func (t *TiKV) Get(ctx context.Context, key []byte, option store.GetOption) (store.Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.ReadTimeout.Duration)
 	defer cancel()
 	tx, err := t.client.Begin(ctx)
 	if err != nil {
-		return store.NoValue, xerror.ErrGetTimestampFailed
+		return store.NoValue, xerror.ErrGetTimestampFailed.Wrap(err)
 	}
 
 	v, err := tx.Get(ctx, key)
@@ -73,17 +74,31 @@ func (t *TiKV) Get(key []byte, option store.GetOption) (store.Value, error) {
 		return store.NoValue, xerror.ErrNotExists
 	}
 	if err != nil {
-		return store.NoValue, xerror.ErrGetKVFailed
+		return store.NoValue, xerror.ErrGetKVFailed.Wrap(err)
 	}
 	return store.Value{Secondary: secondary, Value: v}, nil
 }
 
-func (t *TiKV) List(start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.ListTimeout.Duration)
+func (t *TiKV) List(ctx context.Context, start, end []byte, limit int, option store.ListOption) ([]store.KeyValue, error) {
+	ret := make([]store.KeyValue, 0)
+	err := t.Scan(ctx, start, end, limit, option, func(k, v []byte) error {
+		ret = append(ret, store.KeyValue{Key: utils.B2S(k), Value: utils.B2S(v)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Scan hands the items to fn as the iterator reads them.
+func (t *TiKV) Scan(ctx context.Context, start, end []byte, limit int, option store.ListOption,
+	fn func(key, val []byte) error) error {
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.ListTimeout.Duration)
 	defer cancel()
 	tx, err := t.client.Begin(ctx)
 	if err != nil {
-		return nil, xerror.ErrGetTimestampFailed
+		return xerror.ErrGetTimestampFailed.Wrap(err)
 	}
 	if option.KeyOnly {
 		tx.SetOption(kv.KeyOnly, true)
@@ -99,11 +114,10 @@ func (t *TiKV) List(start, end []byte, limit int, option store.ListOption) ([]st
 		it, err = tx.IterReverse(ctx, e)
 	}
 	if err != nil {
-		return nil, xerror.ErrListKVFailed
+		return xerror.ErrListKVFailed.Wrap(err)
 	}
 	defer it.Close()
 
-	ret := make([]store.KeyValue, 0)
 	for it.Valid() {
 		k := it.Key()
 		if key.Key(k).Cmp(s) < 0 || key.Key(k).Cmp(e) >= 0 {
@@ -112,41 +126,43 @@ func (t *TiKV) List(start, end []byte, limit int, option store.ListOption) ([]st
 
 		v := it.Value()
 		k, v, err = option.Item(k, v)
-		if err != nil {
-			continue
-		}
-
-		ret = append(ret, store.KeyValue{Key: utils.B2S(k), Value: utils.B2S(v)})
-		limit--
-		if limit <= 0 {
-			break
+		if err == nil {
+			if err = fn(k, v); err != nil {
+				return err
+			}
+			limit--
+			if limit <= 0 {
+				break
+			}
 		}
 		err = it.Next(ctx)
 		if err != nil {
-			return nil, xerror.ErrListKVFailed
+			return xerror.ErrListKVFailed.Wrap(err)
 		}
 	}
-	return ret, nil
+	return nil
 }
 
-func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, option store.CheckOption) error {
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.WriteTimeout.Duration)
+func (t *TiKV) CheckAndPut(ctx context.Context, key, oldVal, newVal []byte, option store.CheckOption) error {
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.WriteTimeout.Duration)
 	defer cancel()
 	tx, err := t.client.Begin(ctx)
 	if err != nil {
-		return xerror.ErrGetTimestampFailed
+		return xerror.ErrGetTimestampFailed.Wrap(err)
 	}
 
 	existVal, err := tx.Get(ctx, key)
 	if err == kv.ErrNotExist {
 		existVal = nil
 	} else if err != nil {
-		return xerror.ErrGetKVFailed
+		return xerror.ErrGetKVFailed.Wrap(err)
 	}
 
 	if option.Check != nil {
 		newVal, err = option.Check(oldVal, newVal, existVal)
-		if err != nil {
+		if errors.Is(err, xerror.ErrCheckAndSetFailed) {
+			return store.NewConflictError(err, existVal, 0)
+		} else if err != nil {
 			return err
 		}
 	}
@@ -158,22 +174,22 @@ func (t *TiKV) CheckAndPut(key, oldVal, newVal []byte, option store.CheckOption)
 	}
 
 	if err != nil {
-		return xerror.ErrSetKVFailed
+		return xerror.ErrSetKVFailed.Wrap(err)
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return xerror.ErrCommitKVFailed
+		return xerror.ErrCommitKVFailed.Wrap(err)
 	}
 	return nil
 }
 
-func (t *TiKV) Put(key, val []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.WriteTimeout.Duration)
+func (t *TiKV) Put(ctx context.Context, key, val []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.WriteTimeout.Duration)
 	defer cancel()
 	tx, err := t.client.Begin(ctx)
 	if err != nil {
-		return xerror.ErrGetTimestampFailed
+		return xerror.ErrGetTimestampFailed.Wrap(err)
 	}
 
 	if len(val) > 0 {
@@ -182,22 +198,22 @@ func (t *TiKV) Put(key, val []byte) error {
 		err = tx.Set(key, val)
 	}
 	if err != nil {
-		return xerror.ErrSetKVFailed
+		return xerror.ErrSetKVFailed.Wrap(err)
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return xerror.ErrCommitKVFailed
+		return xerror.ErrCommitKVFailed.Wrap(err)
 	}
 	return nil
 }
 
-func (t *TiKV) BatchPut(items []store.KeyEntry) error {
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.BatchPutTimeout.Duration)
+func (t *TiKV) BatchPut(ctx context.Context, items []store.KeyEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.BatchPutTimeout.Duration)
 	defer cancel()
 	tx, err := t.client.Begin(ctx)
 	if err != nil {
-		return xerror.ErrGetTimestampFailed
+		return xerror.ErrGetTimestampFailed.Wrap(err)
 	}
 
 	for _, item := range items {
@@ -209,28 +225,28 @@ func (t *TiKV) BatchPut(items []store.KeyEntry) error {
 	}
 
 	if err != nil {
-		return xerror.ErrSetKVFailed
+		return xerror.ErrSetKVFailed.Wrap(err)
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return xerror.ErrCommitKVFailed
+		return xerror.ErrCommitKVFailed.Wrap(err)
 	}
 	return nil
 }
 
-func (t *TiKV) BatchDelete(start, end []byte, limit int) ([]byte, int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), t.conf.Store.BatchDeleteTimeout.Duration)
+func (t *TiKV) BatchDelete(ctx context.Context, start, end []byte, limit int) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Store.BatchDeleteTimeout.Duration)
 	defer cancel()
 	tx, err := t.client.Begin(ctx)
 	if err != nil {
-		return nil, 0, xerror.ErrGetTimestampFailed
+		return nil, 0, xerror.ErrGetTimestampFailed.Wrap(err)
 	}
 	tx.SetOption(kv.KeyOnly, true)
 
 	it, err := tx.Iter(ctx, key.Key(start), key.Key(end))
 	if err != nil {
-		return nil, 0, xerror.ErrListKVFailed
+		return nil, 0, xerror.ErrListKVFailed.Wrap(err)
 	}
 	defer it.Close()
 
@@ -258,11 +274,11 @@ func (t *TiKV) BatchDelete(start, end []byte, limit int) ([]byte, int, error) {
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return nil, 0, xerror.ErrCommitKVFailed
+		return nil, 0, xerror.ErrCommitKVFailed.Wrap(err)
 	}
 	return lastKey, count, nil
 }
 
-func (t *TiKV) UnsafeDelete(_, _ []byte) error {
+func (t *TiKV) UnsafeDelete(_ context.Context, _, _ []byte) error {
 	return nil
 }
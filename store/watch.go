@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/huangnauh/tirest/utils/json"
+	"github.com/huangnauh/tirest/xerror"
+)
+
+// WatchEvent is a change sent to a watch, Op is one of the event ops and
+// ID increases by one per change published.
+type WatchEvent struct {
+	ID  uint64
+	Key []byte
+	Op  string
+	Log Log
+}
+
+// Watch receives the changes under prefix on C. A watch which falls
+// behind by its buffer is closed: Done is closed and C isn't fed anymore.
+// A resumed watch gets the changes it missed in Backlog first, or Missed
+// if they aren't in the history anymore.
+type Watch struct {
+	prefix  []byte
+	C       chan WatchEvent
+	Backlog []WatchEvent
+	Missed  bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (w *Watch) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *Watch) close() {
+	w.once.Do(func() { close(w.done) })
+}
+
+// Overflowed is true if the watch was closed for falling behind.
+func (w *Watch) Overflowed() bool {
+	select {
+	case <-w.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Watchers fans the changes out to the watches, a slow watch never blocks
+// the writes. The last changes are kept in a ring of history events to
+// resume the watches. The IDs start at the boot time in nanoseconds, so
+// an ID from a previous run is never resumed.
+type Watchers struct {
+	mu      sync.Mutex
+	watches map[*Watch]struct{}
+	max     int
+	buffer  int
+	history []WatchEvent
+	kept    int
+	last    uint64
+}
+
+func NewWatchers(max, buffer, history int) *Watchers {
+	return &Watchers{
+		watches: make(map[*Watch]struct{}),
+		max:     max,
+		buffer:  buffer,
+		history: make([]WatchEvent, history),
+		last:    uint64(time.Now().UnixNano()),
+	}
+}
+
+// Add starts a watch on prefix, it resumes after lastID unless it's 0.
+func (ws *Watchers) Add(prefix []byte, lastID uint64) (*Watch, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.watches) >= ws.max {
+		return nil, xerror.ErrTooManyWatches
+	}
+	w := &Watch{
+		prefix: prefix,
+		C:      make(chan WatchEvent, ws.buffer),
+		done:   make(chan struct{}),
+	}
+	if lastID > 0 {
+		w.Backlog, w.Missed = ws.since(prefix, lastID)
+		if w.Missed {
+			metric.WatchResumeMissed.Inc()
+		}
+	}
+	ws.watches[w] = struct{}{}
+	metric.WatchClients.Set(float64(len(ws.watches)))
+	return w, nil
+}
+
+// since returns the kept changes under prefix after lastID, false if some
+// aren't kept anymore.
+func (ws *Watchers) since(prefix []byte, lastID uint64) ([]WatchEvent, bool) {
+	oldest := ws.last - uint64(ws.kept) + 1
+	if lastID > ws.last || lastID+1 < oldest {
+		return nil, true
+	}
+	var events []WatchEvent
+	for id := lastID + 1; id <= ws.last; id++ {
+		ev := ws.history[id%uint64(len(ws.history))]
+		if bytes.HasPrefix(ev.Key, prefix) {
+			events = append(events, ev)
+		}
+	}
+	return events, false
+}
+
+func (ws *Watchers) Remove(w *Watch) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.watches, w)
+	metric.WatchClients.Set(float64(len(ws.watches)))
+}
+
+// Publish sends a change to the watches of its key, it's dropped for the
+// audit records and the changes which aren't a Log.
+func (ws *Watchers) Publish(msg KeyEntry) {
+	if len(msg.Key) > 0 && msg.Key[0] == AuditType {
+		return
+	}
+	ev := WatchEvent{Key: msg.Key}
+	if json.Unmarshal(msg.Entry, &ev.Log) != nil {
+		return
+	}
+	ev.Op, _ = eventOp(msg, &ev.Log)
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.last++
+	ev.ID = ws.last
+	if len(ws.history) > 0 {
+		ws.history[ev.ID%uint64(len(ws.history))] = ev
+		if ws.kept < len(ws.history) {
+			ws.kept++
+		}
+	}
+	for w := range ws.watches {
+		if !bytes.HasPrefix(msg.Key, w.prefix) || w.Overflowed() {
+			continue
+		}
+		select {
+		case w.C <- ev:
+		default:
+			metric.WatchOverflow.Inc()
+			w.close()
+		}
+	}
+}
+
+// Watch starts a watch on prefix after lastID, it's removed by Unwatch.
+func (s *Store) Watch(prefix []byte, lastID uint64) (*Watch, error) {
+	if s.watchers == nil {
+		return nil, xerror.ErrNotSupported
+	}
+	return s.watchers.Add(prefix, lastID)
+}
+
+func (s *Store) Unwatch(w *Watch) {
+	s.watchers.Remove(w)
+}
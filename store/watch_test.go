@@ -0,0 +1,69 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/huangnauh/tirest/xerror"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchers(t *testing.T) {
+	ws := NewWatchers(2, 1, 0)
+	a, err := ws.Add([]byte("\x00a"), 0)
+	assert.Nil(t, err)
+	b, err := ws.Add([]byte("\x00b"), 0)
+	assert.Nil(t, err)
+	_, err = ws.Add([]byte("\x00c"), 0)
+	assert.Equal(t, xerror.ErrTooManyWatches, err)
+
+	ws.Publish(KeyEntry{Key: []byte("\x00a1"), Entry: []byte(`{"old":"","new":"1"}`)})
+	ws.Publish(KeyEntry{Key: []byte{AuditType, 'a'}, Entry: []byte(`{}`)})
+	ev := <-a.C
+	assert.Equal(t, "\x00a1", string(ev.Key))
+	assert.Equal(t, EventPut, ev.Op)
+	assert.Equal(t, "1", ev.Log.New)
+	assert.Len(t, b.C, 0)
+
+	// the buffer of a is 1, the second change overflows it
+	ws.Publish(KeyEntry{Key: []byte("\x00a1"), Entry: []byte(`{"old":"1","new":"2"}`)})
+	ws.Publish(KeyEntry{Key: []byte("\x00a1"), Entry: []byte(`{"old":"2","new":""}`)})
+	assert.True(t, a.Overflowed())
+	assert.False(t, b.Overflowed())
+
+	ws.Remove(a)
+	_, err = ws.Add([]byte("\x00c"), 0)
+	assert.Nil(t, err)
+}
+
+func TestWatchersResume(t *testing.T) {
+	ws := NewWatchers(10, 10, 2)
+	w, err := ws.Add([]byte("\x00"), 0)
+	assert.Nil(t, err)
+	for _, k := range []string{"\x00a", "\x00b", "\x00a"} {
+		ws.Publish(KeyEntry{Key: []byte(k), Entry: []byte(`{"old":"","new":"1"}`)})
+	}
+	first := <-w.C
+	second := <-w.C
+	third := <-w.C
+	assert.Equal(t, first.ID+1, second.ID)
+
+	r, err := ws.Add([]byte("\x00a"), first.ID)
+	assert.Nil(t, err)
+	assert.False(t, r.Missed)
+	assert.Equal(t, []WatchEvent{third}, r.Backlog)
+
+	r, err = ws.Add([]byte("\x00a"), third.ID)
+	assert.Nil(t, err)
+	assert.False(t, r.Missed)
+	assert.Len(t, r.Backlog, 0)
+
+	// only the last 2 changes are kept
+	r, err = ws.Add([]byte("\x00a"), first.ID-1)
+	assert.Nil(t, err)
+	assert.True(t, r.Missed)
+
+	// an ID of a previous run
+	r, err = ws.Add([]byte("\x00a"), 1)
+	assert.Nil(t, err)
+	assert.True(t, r.Missed)
+}
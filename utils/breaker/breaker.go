@@ -0,0 +1,160 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+var stateNames = []string{"closed", "open", "half-open"}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
+type Options struct {
+	// counts are reset every Window while closed
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64
+	// calls slower than SlowThreshold count as slow, 0 disables
+	SlowThreshold time.Duration
+	SlowRate      float64
+	// OpenTimeout is how long to fail fast before probing
+	OpenTimeout time.Duration
+	// HalfOpenProbes successful probes close the breaker
+	HalfOpenProbes int
+	IsFailure      func(err error) bool
+	OnStateChange  func(from, to State)
+}
+
+// Breaker trips on the error rate or the slow call rate, it fails fast while
+// open and lets a few probes through when half open.
+type Breaker struct {
+	mu          sync.Mutex
+	opt         Options
+	state       State
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	slow        int
+	probes      int
+	successes   int
+}
+
+func New(opt Options) *Breaker {
+	if opt.HalfOpenProbes <= 0 {
+		opt.HalfOpenProbes = 1
+	}
+	if opt.IsFailure == nil {
+		opt.IsFailure = func(err error) bool { return err != nil }
+	}
+	return &Breaker{opt: opt, windowStart: time.Now()}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *Breaker) setState(to State, now time.Time) {
+	from := b.state
+	b.state = to
+	b.requests, b.failures, b.slow = 0, 0, 0
+	b.probes, b.successes = 0, 0
+	b.windowStart = now
+	if to == Open {
+		b.openedAt = now
+	}
+	if b.opt.OnStateChange != nil && from != to {
+		b.opt.OnStateChange(from, to)
+	}
+}
+
+// allow returns whether the call may run and whether it's a probe.
+func (b *Breaker) allow(now time.Time) (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if now.Sub(b.openedAt) < b.opt.OpenTimeout {
+			return false, false
+		}
+		b.setState(HalfOpen, now)
+		fallthrough
+	case HalfOpen:
+		if b.probes >= b.opt.HalfOpenProbes {
+			return false, false
+		}
+		b.probes++
+		return true, true
+	}
+	if b.opt.Window > 0 && now.Sub(b.windowStart) >= b.opt.Window {
+		b.requests, b.failures, b.slow = 0, 0, 0
+		b.windowStart = now
+	}
+	return true, false
+}
+
+func (b *Breaker) done(probe, failed, slow bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		if b.state != HalfOpen {
+			return
+		}
+		if failed || slow {
+			b.setState(Open, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opt.HalfOpenProbes {
+			b.setState(Closed, now)
+		}
+		return
+	}
+	if b.state != Closed {
+		return
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+	if b.requests < b.opt.MinRequests {
+		return
+	}
+	total := float64(b.requests)
+	if (b.opt.ErrorRate > 0 && float64(b.failures)/total >= b.opt.ErrorRate) ||
+		(b.opt.SlowRate > 0 && float64(b.slow)/total >= b.opt.SlowRate) {
+		b.setState(Open, now)
+	}
+}
+
+// Do runs fn unless the breaker is open, ErrOpen is returned then.
+func (b *Breaker) Do(fn func() error) error {
+	start := time.Now()
+	ok, probe := b.allow(start)
+	if !ok {
+		return ErrOpen
+	}
+	err := fn()
+	now := time.Now()
+	slow := b.opt.SlowThreshold > 0 && now.Sub(start) > b.opt.SlowThreshold
+	b.done(probe, b.opt.IsFailure(err), slow, now)
+	return err
+}
@@ -0,0 +1,62 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	var changes []State
+	b := New(Options{
+		Window:         time.Minute,
+		MinRequests:    4,
+		ErrorRate:      0.5,
+		OpenTimeout:    50 * time.Millisecond,
+		HalfOpenProbes: 2,
+		OnStateChange: func(from, to State) {
+			changes = append(changes, to)
+		},
+	})
+	fail := errors.New("fail")
+	ok := func() error { return nil }
+	bad := func() error { return fail }
+
+	assert.Nil(t, b.Do(ok))
+	assert.Nil(t, b.Do(ok))
+	assert.Equal(t, fail, b.Do(bad))
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, fail, b.Do(bad))
+	assert.Equal(t, Open, b.State())
+	assert.Equal(t, ErrOpen, b.Do(ok))
+
+	time.Sleep(60 * time.Millisecond)
+	// a failed probe opens it again
+	assert.Equal(t, fail, b.Do(bad))
+	assert.Equal(t, Open, b.State())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, b.Do(ok))
+	assert.Equal(t, HalfOpen, b.State())
+	assert.Nil(t, b.Do(ok))
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, []State{Open, HalfOpen, Open, HalfOpen, Closed}, changes)
+}
+
+func TestBreakerSlow(t *testing.T) {
+	b := New(Options{
+		MinRequests:   2,
+		SlowThreshold: time.Millisecond,
+		SlowRate:      0.6,
+		OpenTimeout:   time.Minute,
+		IsFailure:     func(err error) bool { return false },
+	})
+	slow := func() error { time.Sleep(2 * time.Millisecond); return errors.New("ignored") }
+	b.Do(slow)
+	b.Do(func() error { return nil })
+	assert.Equal(t, Closed, b.State())
+	b.Do(slow)
+	assert.Equal(t, Open, b.State())
+}
@@ -0,0 +1,78 @@
+package hashring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring is a consistent hash ring, each member has replicas points on it
+// and a key belongs to the member of the first point at or after its hash.
+// A member joining or leaving only moves about 1/n of the keys.
+type Ring struct {
+	replicas int
+	hashes   []uint64
+	owners   map[uint64]string
+	members  []string
+}
+
+func hash(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	// fnv alone clusters the points of similar names
+	return mix(h.Sum64())
+}
+
+// mix is the finalizer of splitmix64.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func New(replicas int, members []string) *Ring {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	r := &Ring{
+		replicas: replicas,
+		owners:   make(map[uint64]string, replicas*len(members)),
+		members:  append([]string(nil), members...),
+	}
+	sort.Strings(r.members)
+	for _, m := range r.members {
+		for i := 0; i < replicas; i++ {
+			h := hash([]byte(strconv.Itoa(i) + "-" + m))
+			// the smaller name wins a collision on every instance alike
+			if owner, ok := r.owners[h]; ok && owner < m {
+				continue
+			} else if !ok {
+				r.hashes = append(r.hashes, h)
+			}
+			r.owners[h] = m
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Get is the member owning key, empty if the ring has none.
+func (r *Ring) Get(key []byte) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Members are sorted.
+func (r *Ring) Members() []string {
+	return r.members
+}
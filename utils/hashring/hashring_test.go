@@ -0,0 +1,40 @@
+package hashring
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "", New(16, nil).Get([]byte("a")))
+
+	members := []string{"10.0.0.1:6100", "10.0.0.2:6100", "10.0.0.3:6100"}
+	r := New(128, members)
+	// the order of the members doesn't matter
+	other := New(128, []string{members[2], members[0], members[1]})
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		owner := r.Get(key)
+		assert.Equal(t, owner, other.Get(key))
+		counts[owner]++
+	}
+	for _, m := range members {
+		assert.True(t, counts[m] > 600, counts)
+	}
+
+	// a new member only takes keys from the others
+	grown := New(128, append(members, "10.0.0.4:6100"))
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key_%d", i))
+		if owner := grown.Get(key); owner != r.Get(key) {
+			assert.Equal(t, "10.0.0.4:6100", owner)
+			moved++
+		}
+	}
+	assert.True(t, moved > 400 && moved < 1200, moved)
+}
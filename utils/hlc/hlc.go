@@ -0,0 +1,62 @@
+// Package hlc is a hybrid logical clock, a timestamp is the wall time in
+// milliseconds shifted left by 16 bits plus a logical counter, so stamps
+// follow causality across nodes while staying close to the wall time.
+package hlc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const logicalBits = 16
+
+var ErrOffset = errors.New("timestamp is too far ahead of the wall clock")
+
+type Clock struct {
+	mu        sync.Mutex
+	last      uint64
+	maxOffset time.Duration
+	wall      func() time.Time
+}
+
+// New returns a clock which rejects remote stamps more than maxOffset
+// ahead of the wall clock, 0 accepts any.
+func New(maxOffset time.Duration) *Clock {
+	return &Clock{maxOffset: maxOffset, wall: time.Now}
+}
+
+func fromTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/int64(time.Millisecond)) << logicalBits
+}
+
+// Physical returns the wall time of ts.
+func Physical(ts uint64) time.Time {
+	ms := int64(ts >> logicalBits)
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Now returns a stamp after every stamp returned or updated before.
+func (c *Clock) Now() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ts := fromTime(c.wall())
+	if ts <= c.last {
+		ts = c.last + 1
+	}
+	c.last = ts
+	return ts
+}
+
+// Update moves the clock past ts, a stamp from another node.
+func (c *Clock) Update(ts uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxOffset > 0 && Physical(ts).Sub(c.wall()) > c.maxOffset {
+		return ErrOffset
+	}
+	if ts > c.last {
+		c.last = ts
+	}
+	return nil
+}
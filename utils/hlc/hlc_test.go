@@ -0,0 +1,28 @@
+package hlc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	wall := time.Unix(1000, 0)
+	c := New(time.Second)
+	c.wall = func() time.Time { return wall }
+
+	ts := c.Now()
+	assert.Equal(t, wall, Physical(ts))
+	// the wall clock doesn't move, the logical counter does
+	assert.Equal(t, ts+1, c.Now())
+
+	remote := fromTime(wall.Add(500 * time.Millisecond))
+	assert.Nil(t, c.Update(remote))
+	assert.Equal(t, remote+1, c.Now())
+	assert.Equal(t, ErrOffset, c.Update(fromTime(wall.Add(2*time.Second))))
+
+	// a clock going back doesn't move the stamps back
+	wall = wall.Add(-time.Minute)
+	assert.Equal(t, remote+2, c.Now())
+}
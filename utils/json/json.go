@@ -10,4 +10,10 @@ var (
 	MarshalIndent = json.MarshalIndent
 	NewDecoder    = json.NewDecoder
 	NewEncoder    = json.NewEncoder
+	Valid         = json.Valid
+)
+
+type (
+	RawMessage = json.RawMessage
+	Encoder    = json.Encoder
 )
@@ -11,4 +11,10 @@ var (
 	MarshalIndent = json.MarshalIndent
 	NewDecoder    = json.NewDecoder
 	NewEncoder    = json.NewEncoder
+	Valid         = json.Valid
+)
+
+type (
+	RawMessage = jsoniter.RawMessage
+	Encoder    = jsoniter.Encoder
 )
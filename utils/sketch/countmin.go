@@ -0,0 +1,65 @@
+package sketch
+
+import (
+	"hash/fnv"
+)
+
+// CountMin is a count-min sketch, Estimate never under counts and over
+// counts by at most total/width with a probability of 1-(1/2)^depth.
+type CountMin struct {
+	width  uint64
+	depth  uint64
+	counts []uint64
+}
+
+func NewCountMin(width, depth int) *CountMin {
+	if width <= 0 {
+		width = 1
+	}
+	if depth <= 0 {
+		depth = 1
+	}
+	return &CountMin{
+		width:  uint64(width),
+		depth:  uint64(depth),
+		counts: make([]uint64, width*depth),
+	}
+}
+
+func hash(key []byte) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum, sum>>33 | 1
+}
+
+func (c *CountMin) Add(key []byte, n uint64) uint64 {
+	h1, h2 := hash(key)
+	min := ^uint64(0)
+	for i := uint64(0); i < c.depth; i++ {
+		idx := i*c.width + (h1+i*h2)%c.width
+		c.counts[idx] += n
+		if c.counts[idx] < min {
+			min = c.counts[idx]
+		}
+	}
+	return min
+}
+
+func (c *CountMin) Estimate(key []byte) uint64 {
+	h1, h2 := hash(key)
+	min := ^uint64(0)
+	for i := uint64(0); i < c.depth; i++ {
+		idx := i*c.width + (h1+i*h2)%c.width
+		if c.counts[idx] < min {
+			min = c.counts[idx]
+		}
+	}
+	return min
+}
+
+func (c *CountMin) Reset() {
+	for i := range c.counts {
+		c.counts[i] = 0
+	}
+}
@@ -0,0 +1,26 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountMin(t *testing.T) {
+	t.Parallel()
+	c := NewCountMin(1024, 4)
+	for i := 0; i < 1000; i++ {
+		c.Add([]byte(fmt.Sprintf("key_%d", i)), 1)
+	}
+	for i := 0; i < 500; i++ {
+		c.Add([]byte("hot"), 1)
+	}
+	assert.True(t, c.Estimate([]byte("hot")) >= 500)
+	assert.True(t, c.Estimate([]byte("hot")) < 520)
+	assert.True(t, c.Estimate([]byte("key_1")) >= 1)
+	assert.True(t, c.Estimate([]byte("missing")) < 20)
+
+	c.Reset()
+	assert.Equal(t, uint64(0), c.Estimate([]byte("hot")))
+}
@@ -0,0 +1,50 @@
+package validator
+
+import (
+	"github.com/huangnauh/tirest/config"
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+const (
+	JSONName       = "json"
+	JSONSchemaName = "json-schema"
+)
+
+func init() {
+	RegisterValidator(jsonDriver{})
+	RegisterValidator(schemaDriver{})
+}
+
+type jsonDriver struct {
+}
+
+func (d jsonDriver) Name() string {
+	return JSONName
+}
+
+func (d jsonDriver) Open(conf *config.Validation) (Validator, error) {
+	return jsonValidator{}, nil
+}
+
+// jsonValidator only requires a valid json document.
+type jsonValidator struct {
+}
+
+func (j jsonValidator) Validate(key, value []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return Errors{{Field: "$", Message: "invalid json, " + err.Error()}}
+	}
+	return nil
+}
+
+type schemaDriver struct {
+}
+
+func (d schemaDriver) Name() string {
+	return JSONSchemaName
+}
+
+func (d schemaDriver) Open(conf *config.Validation) (Validator, error) {
+	return LoadSchema(conf.Schema)
+}
@@ -0,0 +1,241 @@
+package validator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/huangnauh/tirest/utils/json"
+)
+
+// Schema is the subset of JSON Schema (draft 7) used to check values:
+// type, enum, const, properties, required, additionalProperties, items,
+// minimum, maximum, minLength, maxLength, pattern, minItems and maxItems.
+type Schema struct {
+	Type                 types              `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Const                interface{}        `json:"const"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additional        `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+
+	pattern *regexp.Regexp
+}
+
+// types is either "string" or ["string", "null"].
+type types []string
+
+func (t *types) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = types{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return fmt.Errorf("invalid type %s", b)
+	}
+	*t = ss
+	return nil
+}
+
+// additional is either a bool or a schema.
+type additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *additional) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(b, &a.Schema)
+}
+
+func LoadSchema(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(data)
+}
+
+func ParseSchema(data []byte) (*Schema, error) {
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) compile() error {
+	var err error
+	if s.Pattern != "" {
+		s.pattern, err = regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Properties {
+		if err = p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err = s.Items.compile(); err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		return s.AdditionalProperties.Schema.compile()
+	}
+	return nil
+}
+
+func (s *Schema) Validate(key, value []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return Errors{{Field: "$", Message: "invalid json, " + err.Error()}}
+	}
+	var errs Errors
+	s.validate("$", v, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func typeOf(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func (s *Schema) matchType(v interface{}) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	t := typeOf(v)
+	for _, want := range s.Type {
+		if want == t || (want == "number" && t == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) validate(path string, v interface{}, errs *Errors) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !s.matchType(v) {
+		fail("expected %v, got %s", []string(s.Type), typeOf(v))
+		return
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const, v) {
+		fail("expected %v", s.Const)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("not one of %v", s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			fail("less than minimum %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			fail("greater than maximum %v", *s.Maximum)
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			fail("shorter than %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			fail("longer than %d", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			fail("does not match %s", s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			fail("fewer than %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			fail("more than %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(path+"["+strconv.Itoa(i)+"]", item, errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				*errs = append(*errs, Error{Field: path + "." + name, Message: "required"})
+			}
+		}
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p, ok := s.Properties[name]; ok {
+				p.validate(path+"."+name, val[name], errs)
+				continue
+			}
+			if s.AdditionalProperties == nil {
+				continue
+			}
+			if !s.AdditionalProperties.Allowed {
+				*errs = append(*errs, Error{Field: path + "." + name, Message: "not allowed"})
+			} else if s.AdditionalProperties.Schema != nil {
+				s.AdditionalProperties.Schema.validate(path+"."+name, val[name], errs)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/huangnauh/tirest/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const metaSchema = `{
+	"type": "object",
+	"required": ["updated_at"],
+	"properties": {
+		"updated_at": {"type": "integer", "minimum": 1},
+		"deleted": {"type": "boolean"},
+		"content_type": {"type": ["string", "null"], "pattern": "^[a-z]+/"},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}}
+	},
+	"additionalProperties": false
+}`
+
+func TestSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(metaSchema))
+	assert.Nil(t, err)
+
+	assert.Nil(t, s.Validate(nil, []byte(`{"updated_at": 100, "content_type": "image/png"}`)))
+	assert.Nil(t, s.Validate(nil, []byte(`{"updated_at": 100, "content_type": null, "tags": ["a"]}`)))
+
+	err = s.Validate(nil, []byte(`{"updated_at": 1.5, "deleted": "no", "size": 1}`))
+	assert.Equal(t, Errors{
+		{Field: "$.deleted", Message: "expected [boolean], got string"},
+		{Field: "$.size", Message: "not allowed"},
+		{Field: "$.updated_at", Message: "expected [integer], got number"},
+	}, err)
+
+	err = s.Validate(nil, []byte(`{"content_type": "png", "tags": ["a", "", "c"]}`))
+	assert.Equal(t, Errors{
+		{Field: "$.updated_at", Message: "required"},
+		{Field: "$.content_type", Message: "does not match ^[a-z]+/"},
+		{Field: "$.tags", Message: "more than 2 items"},
+		{Field: "$.tags[1]", Message: "shorter than 1"},
+	}, err)
+
+	err = s.Validate(nil, []byte(`{"updated_at"`))
+	assert.Len(t, err, 1)
+}
+
+func TestValidators(t *testing.T) {
+	v, err := New([]config.Validation{{Prefix: "user/", Name: JSONName}})
+	assert.Nil(t, err)
+	assert.True(t, v.Match([]byte("user/1")))
+	assert.False(t, v.Match([]byte("group/1")))
+
+	name, err := v.Validate([]byte("user/1"), []byte("{"))
+	assert.Equal(t, JSONName, name)
+	assert.NotNil(t, err)
+	_, err = v.Validate([]byte("group/1"), []byte("{"))
+	assert.Nil(t, err)
+
+	_, err = New([]config.Validation{{Prefix: "user/", Name: "unknown"}})
+	assert.NotNil(t, err)
+}
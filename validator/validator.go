@@ -0,0 +1,97 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/huangnauh/tirest/config"
+)
+
+// Validator checks a value before it's written.
+type Validator interface {
+	Validate(key, value []byte) error
+}
+
+type Driver interface {
+	Name() string
+	Open(conf *config.Validation) (Validator, error)
+}
+
+var drivers = make(map[string]Driver)
+
+func RegisterValidator(driver Driver) {
+	name := driver.Name()
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Errorf("validator %s is already registered", name))
+	}
+
+	drivers[name] = driver
+}
+
+// Error is one failed rule, Field is the path of the value, e.g. $.metadata.size.
+type Error struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Errors []Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Field+": "+err.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type rule struct {
+	prefix    []byte
+	name      string
+	validator Validator
+}
+
+// Validators applies every validator whose prefix matches the key.
+type Validators struct {
+	rules []rule
+}
+
+func New(confs []config.Validation) (*Validators, error) {
+	v := &Validators{}
+	for i := range confs {
+		conf := &confs[i]
+		driver, ok := drivers[conf.Name]
+		if !ok {
+			return nil, fmt.Errorf("validator %s not register", conf.Name)
+		}
+		validator, err := driver.Open(conf)
+		if err != nil {
+			return nil, fmt.Errorf("open validator %s for %q failed, %s", conf.Name, conf.Prefix, err)
+		}
+		v.rules = append(v.rules, rule{prefix: []byte(conf.Prefix), name: conf.Name, validator: validator})
+	}
+	return v, nil
+}
+
+// Match reports whether any validator applies to key.
+func (v *Validators) Match(key []byte) bool {
+	for _, r := range v.rules {
+		if bytes.HasPrefix(key, r.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns the name of the failed validator with its error.
+func (v *Validators) Validate(key, value []byte) (string, error) {
+	for _, r := range v.rules {
+		if !bytes.HasPrefix(key, r.prefix) {
+			continue
+		}
+		if err := r.validator.Validate(key, value); err != nil {
+			return r.name, err
+		}
+	}
+	return "", nil
+}
@@ -1,7 +1,13 @@
 package version
 
+import (
+	"fmt"
+	"runtime"
+)
+
 const (
 	API        = "v1"
+	APIV2      = "v2"
 	APP        = "tirest"
 )
 
@@ -9,3 +15,31 @@ var (
 	GitCommit   = "UNKNOWN"
 	GitDescribe = "UNKNOWN"
 )
+
+// Info is the build of the binary, set by the ldflags of the Makefile.
+type Info struct {
+	GitDescribe string `json:"git_describe"`
+	GitCommit   string `json:"git_commit"`
+	API         string `json:"api"`
+	GoVersion   string `json:"go_version"`
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+}
+
+func Get() Info {
+	return Info{
+		GitDescribe: GitDescribe,
+		GitCommit:   GitCommit,
+		API:         API,
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+	}
+}
+
+// String is the version of the cli, e.g.
+// v0.1.0 (0a1b2c3), api:v1, runtime:linux/amd64 go1.16.
+func (i Info) String() string {
+	return fmt.Sprintf("%s (%s), api:%s, runtime:%s/%s %s", i.GitDescribe,
+		i.GitCommit, i.API, i.OS, i.Arch, i.GoVersion)
+}
@@ -1,24 +1,247 @@
 package xerror
 
-import "errors"
-
-var ErrExists = errors.New("exists")
-var ErrNotExists = errors.New("not exists")
-var ErrKeyInvalid = errors.New("key invalid")
-var ErrAlreadyExists = errors.New("already exists")
-var ErrGetKVFailed = errors.New("get kv failed")
-var ErrSetKVFailed = errors.New("set kv failed")
-var ErrNotSupported = errors.New("not supported")
-var ErrListKVFailed = errors.New("list kv failed")
-var ErrListKVInvalid = errors.New("list kv invalid")
-var ErrCommitKVFailed = errors.New("commit kv failed")
-var ErrDatabaseNotExists = errors.New("database not exists")
-var ErrCheckAndSetFailed = errors.New("check and set failed")
-var ErrCheckAndSetInvalid = errors.New("check and set invalid")
-var ErrGetTimestampFailed = errors.New("get timestamp failed")
-var ErrConnectorNotExists = errors.New("connector not exists")
-var ErrGetSafePointFailed = errors.New("get safe point failed")
-var ErrDatabaseNotRegister = errors.New("database not register")
-var ErrConnectorNotRegister = errors.New("connector not register")
-var ErrUnsafeDestroyRangeFailed = errors.New("unsafe destroy range failed")
-var ErrNotifyDeleteRangeFailed = errors.New("failed notifying regions")
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+var ErrExists = New(Conflict, "exists", "exists")
+var ErrInvalidArgument = New(InvalidArgument, "invalid_argument", "invalid argument")
+var ErrInvalidHeader = New(InvalidArgument, "invalid_header", "invalid header")
+var ErrApiVersionUnsupported = New(InvalidArgument, "api_version_unsupported", "api version not supported")
+var ErrReadBodyFailed = New(InvalidArgument, "read_body_failed", "read body failed")
+var ErrReadBodyTimeout = New(Canceled, "read_body_timeout", "read body timeout")
+var ErrRequestCanceled = New(Canceled, "request_canceled", "request canceled")
+var ErrRequestTimeout = New(DeadlineExceeded, "request_timeout", "request timeout")
+var ErrConcurrencyLimited = New(Exhausted, "concurrency_limited", "too many concurrent requests")
+var ErrCircuitOpen = New(Unavailable, "circuit_open", "circuit breaker is open")
+var ErrReadOnly = New(Unavailable, "read_only", "server is read-only")
+var ErrMaintenance = New(Unavailable, "maintenance", "server is under maintenance")
+var ErrAccessDenied = New(PermissionDenied, "access_denied", "access denied")
+var ErrUnsafeDeleteDisabled = New(PermissionDenied, "unsafe_delete_disabled", "unsafe delete is disabled")
+var ErrConfirmationInvalid = New(PermissionDenied, "confirmation_invalid", "confirmation token invalid")
+var ErrValidationFailed = New(Unprocessable, "validation_failed", "validation failed")
+var ErrIdempotencyKeyInvalid = New(InvalidArgument, "idempotency_key_invalid", "idempotency key invalid")
+var ErrIdempotencyKeyReused = New(Unprocessable, "idempotency_key_reused", "idempotency key reused by another request")
+var ErrIdempotencyInProgress = New(Conflict, "idempotency_in_progress", "request with the idempotency key in progress")
+var ErrNotExists = New(NotFound, "not_exists", "not exists")
+var ErrKeyInvalid = New(InvalidArgument, "key_invalid", "key invalid")
+var ErrKeyTooLarge = New(TooLarge, "key_too_large", "key too large")
+var ErrValueTooLarge = New(TooLarge, "value_too_large", "value too large")
+var ErrAlreadyExists = New(Conflict, "already_exists", "already exists")
+var ErrGetKVFailed = New(Internal, "get_failed", "get kv failed")
+var ErrSetKVFailed = New(Internal, "set_failed", "set kv failed")
+var ErrNotSupported = New(InvalidArgument, "not_supported", "not supported")
+var ErrListKVFailed = New(Internal, "list_failed", "list kv failed")
+var ErrListKVInvalid = New(InvalidArgument, "list_invalid", "list kv invalid")
+var ErrCommitKVFailed = New(Internal, "commit_failed", "commit kv failed")
+var ErrDatabaseNotExists = New(Unavailable, "database_not_exists", "database not exists")
+var ErrCheckAndSetFailed = New(Conflict, "check_and_set_failed", "check and set failed")
+var ErrCheckAndSetInvalid = New(InvalidArgument, "check_and_set_invalid", "check and set invalid")
+var ErrGetTimestampFailed = New(Unavailable, "get_timestamp_failed", "get timestamp failed")
+var ErrConnectorNotExists = New(Unavailable, "connector_not_exists", "connector not exists")
+var ErrConnectorClosed = New(Unavailable, "connector_closed", "connector closed")
+var ErrReplayRunning = New(Conflict, "replay_running", "a replay is running")
+var ErrReplayNotKept = New(InvalidArgument, "replay_not_kept", "replay position not kept")
+var ErrGetSafePointFailed = New(Internal, "get_safe_point_failed", "get safe point failed")
+var ErrDatabaseNotRegister = New(Internal, "database_not_register", "database not register")
+var ErrConnectorNotRegister = New(Internal, "connector_not_register", "connector not register")
+var ErrServerClosed = New(Unavailable, "server_closed", "server closed")
+var ErrStoreClosed = New(Unavailable, "store_closed", "store closed")
+var ErrCacheNotRegister = New(Internal, "cache_not_register", "cache not register")
+var ErrPreconditionFailed = New(PreconditionFailed, "precondition_failed", "modified since the timestamp")
+var ErrClockOffset = New(InvalidArgument, "clock_offset", "timestamp too far ahead of the clock")
+var ErrTooManyWatches = New(Exhausted, "too_many_watches", "too many watches")
+var ErrBlobNotRegister = New(Internal, "blob_not_register", "blob store not register")
+var ErrKMSNotRegister = New(Internal, "kms_not_register", "kms not register")
+var ErrHookNotRegister = New(Internal, "hook_not_register", "hook not register")
+var ErrDecryptFailed = New(Internal, "decrypt_failed", "decrypt value failed")
+var ErrChecksumMismatch = New(Internal, "checksum_mismatch", "value checksum mismatch")
+var ErrUnsafeDestroyRangeFailed = New(Exhausted, "unsafe_destroy_range_failed", "unsafe destroy range failed")
+var ErrGetClusterFailed = New(Internal, "get_cluster_failed", "get cluster info failed")
+var ErrSplitRegionFailed = New(Internal, "split_region_failed", "split region failed")
+var ErrRangeSpansTiers = New(InvalidArgument, "range_spans_tiers", "range spans tiers")
+var ErrNotifyDeleteRangeFailed = New(Internal, "notify_delete_range_failed", "failed notifying regions")
+var ErrPeerUnavailable = New(Unavailable, "peer_unavailable", "peer owning the key unavailable")
+var ErrPeerTokenInvalid = New(PermissionDenied, "peer_token_invalid", "peer token invalid")
+var ErrNetworkReadOnly = New(PermissionDenied, "network_read_only", "network is read-only")
+var ErrKeyReserved = New(PermissionDenied, "key_reserved", "key reserved for the proxy")
+var ErrLockHeld = New(Conflict, "lock_held", "lock held by another owner")
+var ErrClusterNotExists = New(NotFound, "cluster_not_exists", "cluster not exists")
+var ErrFaultUnavailable = New(Unavailable, "fault_unavailable", "fault injected, unavailable")
+var ErrFaultInternal = New(Internal, "fault_internal", "fault injected, internal")
+var ErrFaultTimeout = New(DeadlineExceeded, "fault_timeout", "fault injected, timeout")
+
+type Category int
+
+const (
+	Internal Category = iota
+	InvalidArgument
+	NotFound
+	Conflict
+	TooLarge
+	Exhausted
+	Unavailable
+	Canceled
+	Unprocessable
+	PermissionDenied
+	DeadlineExceeded
+	PreconditionFailed
+)
+
+var categoryNames = map[Category]string{
+	Internal:           "internal",
+	InvalidArgument:    "invalid-argument",
+	NotFound:           "not-found",
+	Conflict:           "conflict",
+	TooLarge:           "too-large",
+	Exhausted:          "exhausted",
+	Unavailable:        "unavailable",
+	Canceled:           "canceled",
+	Unprocessable:      "unprocessable",
+	PermissionDenied:   "permission-denied",
+	DeadlineExceeded:   "deadline-exceeded",
+	PreconditionFailed: "precondition-failed",
+}
+
+func (c Category) String() string {
+	if name, ok := categoryNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// HTTPStatus is the response status for errors of the category.
+func (c Category) HTTPStatus() int {
+	switch c {
+	case InvalidArgument:
+		return http.StatusBadRequest
+	case NotFound:
+		return http.StatusNotFound
+	case Conflict:
+		return http.StatusConflict
+	case TooLarge:
+		return http.StatusRequestEntityTooLarge
+	case Exhausted:
+		return http.StatusTooManyRequests
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Canceled:
+		// nginx's client closed request
+		return 499
+	case Unprocessable:
+		return http.StatusUnprocessableEntity
+	case PermissionDenied:
+		return http.StatusForbidden
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case PreconditionFailed:
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusInternalServerError
+}
+
+func (c Category) GRPCCode() codes.Code {
+	switch c {
+	case InvalidArgument, Unprocessable:
+		return codes.InvalidArgument
+	case NotFound:
+		return codes.NotFound
+	case Conflict:
+		return codes.Aborted
+	case TooLarge, Exhausted:
+		return codes.ResourceExhausted
+	case Unavailable:
+		return codes.Unavailable
+	case Canceled:
+		return codes.Canceled
+	case PermissionDenied:
+		return codes.PermissionDenied
+	case DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case PreconditionFailed:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// Error carries a category and a stable code, Cause keeps the underlying
+// error, e.g. the TiKV error which made a get fail.
+type Error struct {
+	Category Category
+	Code     string
+	Message  string
+	Cause    error
+}
+
+func New(category Category, code, message string) *Error {
+	return &Error{Category: category, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is matches errors with the same code, so a wrapped error is still
+// errors.Is the sentinel it was made from.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *Error) Wrap(err error) *Error {
+	w := *e
+	w.Cause = err
+	return &w
+}
+
+// WithCategory returns a copy of e in another category.
+func (e *Error) WithCategory(category Category) *Error {
+	w := *e
+	w.Category = category
+	return &w
+}
+
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// CategoryOf returns Internal for errors outside the taxonomy.
+func CategoryOf(err error) Category {
+	if e, ok := As(err); ok {
+		return e.Category
+	}
+	return Internal
+}
+
+func CodeOf(err error) string {
+	if e, ok := As(err); ok {
+		return e.Code
+	}
+	return ""
+}
+
+func HTTPStatus(err error) int {
+	return CategoryOf(err).HTTPStatus()
+}
+
+func GRPCCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	return CategoryOf(err).GRPCCode()
+}
@@ -0,0 +1,56 @@
+package xerror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("tikv server timeout")
+	err := ErrGetKVFailed.Wrap(cause)
+	assert.True(t, errors.Is(err, ErrGetKVFailed))
+	assert.False(t, errors.Is(err, ErrListKVFailed))
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "get kv failed: tikv server timeout", err.Error())
+	assert.Equal(t, "get kv failed", ErrGetKVFailed.Error())
+
+	wrapped := fmt.Errorf("get key: %w", err.WithCategory(Unavailable))
+	assert.True(t, errors.Is(wrapped, ErrGetKVFailed))
+	assert.Equal(t, Unavailable, CategoryOf(wrapped))
+	assert.Equal(t, "get_failed", CodeOf(wrapped))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(wrapped))
+	assert.Equal(t, codes.Unavailable, GRPCCode(wrapped))
+}
+
+func TestMapping(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   codes.Code
+	}{
+		{ErrNotExists, http.StatusNotFound, codes.NotFound},
+		{ErrKeyInvalid, http.StatusBadRequest, codes.InvalidArgument},
+		{ErrCheckAndSetFailed, http.StatusConflict, codes.Aborted},
+		{ErrValueTooLarge, http.StatusRequestEntityTooLarge, codes.ResourceExhausted},
+		{ErrUnsafeDestroyRangeFailed, http.StatusTooManyRequests, codes.ResourceExhausted},
+		{ErrDatabaseNotExists, http.StatusServiceUnavailable, codes.Unavailable},
+		{ErrReadBodyTimeout, 499, codes.Canceled},
+		{ErrAccessDenied, http.StatusForbidden, codes.PermissionDenied},
+		{ErrConfirmationInvalid, http.StatusForbidden, codes.PermissionDenied},
+		{ErrRequestTimeout, http.StatusGatewayTimeout, codes.DeadlineExceeded},
+		{ErrValidationFailed, http.StatusUnprocessableEntity, codes.InvalidArgument},
+		{ErrCommitKVFailed, http.StatusInternalServerError, codes.Internal},
+		{ErrPreconditionFailed, http.StatusPreconditionFailed, codes.FailedPrecondition},
+		{errors.New("unknown"), http.StatusInternalServerError, codes.Internal},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.status, HTTPStatus(c.err), c.err.Error())
+		assert.Equal(t, c.code, GRPCCode(c.err), c.err.Error())
+	}
+	assert.Equal(t, codes.OK, GRPCCode(nil))
+}